@@ -4,14 +4,11 @@ package main
 
 import (
 	"os/exec"
-	"os/user"
-	"strconv"
-	"syscall"
 
 	"github.com/alecthomas/kingpin/v2"
 
 	"github.com/engity-com/bifroest/pkg/connection"
-	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
 )
 
 type execOpts struct {
@@ -38,37 +35,11 @@ func registerExecCmdFlags(cmd *kingpin.CmdClause, opts *execOpts) {
 
 func enrichExecCmd(cmd *exec.Cmd, with *execOpts) error {
 	if plainUser := with.user; plainUser != "" {
-		cmd.SysProcAttr.Credential = &syscall.Credential{}
-
-		u, err := user.LookupId(plainUser)
-		var uuiErr *user.UnknownUserIdError
-		if errors.As(err, &uuiErr) {
-			u, err = user.Lookup(plainUser)
-		}
+		credential, _, err := sys.LookupCredential(plainUser, with.group)
 		if err != nil {
 			return err
 		}
-		if v, err := strconv.ParseUint(u.Uid, 10, 32); err != nil {
-			return err
-		} else {
-			cmd.SysProcAttr.Credential.Uid = uint32(v)
-		}
-
-		if plainGroup := with.group; plainGroup != "" {
-			g, err := user.LookupGroupId(plainGroup)
-			var ugiErr *user.UnknownGroupIdError
-			if errors.As(err, &ugiErr) {
-				g, err = user.LookupGroup(plainGroup)
-			}
-			if err != nil {
-				return err
-			}
-			if v, err := strconv.ParseUint(g.Gid, 10, 32); err != nil {
-				return err
-			} else {
-				cmd.SysProcAttr.Credential.Gid = uint32(v)
-			}
-		}
+		cmd.SysProcAttr.Credential = credential
 	}
 
 	return nil
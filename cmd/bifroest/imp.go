@@ -32,7 +32,7 @@ var _ = registerCommand(func(app *kingpin.Application) {
 		})
 	cmd.Flag("addr", "Address to bind to.").
 		Default(addr).
-		PlaceHolder("[<host>]:<port>").
+		PlaceHolder("[<host>]:<port>|unix:<path>").
 		StringVar(&addr)
 	cmd.Flag("masterPublicKey", "Public SSH key of the master service which is accessing this imp instance.").
 		Envar(imp.EnvVarMasterPublicKey).
@@ -0,0 +1,60 @@
+//go:build linux
+
+package main
+
+import (
+	goos "os"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	log "github.com/echocat/slf4g"
+
+	"github.com/engity-com/bifroest/pkg/sandbox"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+var _ = registerCommand(func(app *kingpin.Application) {
+	var directory string
+	var serve bool
+
+	cmd := app.Command(sandbox.InitCommand, "Runs as init process of a sandbox.").
+		Hidden().
+		Action(func(*kingpin.ParseContext) error {
+			return doSandboxInit(directory, serve)
+		})
+	cmd.Flag(strings.TrimPrefix(sandbox.ServeFlag, "--"), "Serves the already prepared sandbox.").
+		BoolVar(&serve)
+	cmd.Arg("directory", "Directory on the host where the state of the sandbox is stored.").
+		Required().
+		StringVar(&directory)
+})
+
+func doSandboxInit(directory string, serve bool) error {
+	// Forward all logging flags to the next stage and to the imp.
+	var logArgs []string
+	for _, arg := range goos.Args[1:] {
+		if strings.HasPrefix(arg, "--log.") {
+			logArgs = append(logArgs, arg)
+		}
+	}
+
+	if !serve {
+		args := append([]string{sandbox.BinaryFileLocation, sandbox.InitCommand, directory, sandbox.ServeFlag}, logArgs...)
+		if err := sandbox.Init(directory, goos.Stdin, args); err != nil {
+			log.WithError(err).Error()
+			goos.Exit(1)
+		}
+		return nil
+	}
+
+	log.WithAll(sys.VersionToMap(versionV)).
+		Info("Engity's Bifröst sandbox running...")
+
+	if err := sandbox.Serve(log.GetLogger("sandbox"), logArgs); err != nil {
+		log.WithError(err).Error()
+		goos.Exit(1)
+	}
+
+	log.Info("bye!")
+	return nil
+}
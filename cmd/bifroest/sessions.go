@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	goos "os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/service"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+var _ = registerCommand(func(app *kingpin.Application) {
+	var opts sessionsOpts
+
+	cmd := app.Command("sessions", "Inspect and manage sessions of the configured service.")
+	cmd.Flag("configuration", "Configuration which should be used to access the sessions. Default: "+defaultConfigurationRef).
+		Short('c').
+		Default(defaultConfigurationRef).
+		PlaceHolder("<path>").
+		SetValue(&opts.conf)
+
+	listCmd := cmd.Command("list", "Lists all sessions.").
+		Default().
+		Action(func(*kingpin.ParseContext) error {
+			return doSessionsList(&opts)
+		})
+	listCmd.Flag("flow", "Only sessions of the given flow.").
+		PlaceHolder("<flow>").
+		SetValue(&opts.flow)
+	listCmd.Flag("tag", "Only sessions with the given tag. If the value is empty, every value matches. Can be used multiple times.").
+		PlaceHolder("<key>=<value>").
+		StringMapVar(&opts.tags)
+
+	var showRef sessionRef
+	showCmd := cmd.Command("show", "Shows all details of one session.").
+		Action(func(*kingpin.ParseContext) error {
+			return doSessionsShow(&opts, showRef)
+		})
+	showCmd.Arg("session", "Session to show.").
+		Required().
+		PlaceHolder("<flow>/<id>").
+		SetValue(&showRef)
+
+	var noteRef sessionRef
+	var noteText []string
+	noteAuthor := "admin"
+	noteCmd := cmd.Command("note", "Adds a note to a session.").
+		Action(func(*kingpin.ParseContext) error {
+			return doSessionsNote(&opts, noteRef, noteAuthor, noteText)
+		})
+	noteCmd.Flag("author", "Author of the note. Default: admin").
+		PlaceHolder("<author>").
+		StringVar(&noteAuthor)
+	noteCmd.Arg("session", "Session to add the note to.").
+		Required().
+		PlaceHolder("<flow>/<id>").
+		SetValue(&noteRef)
+	noteCmd.Arg("text", "Text of the note.").
+		Required().
+		StringsVar(&noteText)
+
+	var disposeRef sessionRef
+	disposeCmd := cmd.Command("dispose", "Disposes a session including its authorization and environment. The session itself is kept until the housekeeping removes it.").
+		Action(func(*kingpin.ParseContext) error {
+			return doSessionsDispose(&opts, disposeRef)
+		})
+	disposeCmd.Arg("session", "Session to dispose.").
+		Required().
+		PlaceHolder("<flow>/<id>").
+		SetValue(&disposeRef)
+
+	purgeCmd := cmd.Command("purge", "Disposes and deletes expired sessions.").
+		Action(func(*kingpin.ParseContext) error {
+			return doSessionsPurge(&opts)
+		})
+	purgeCmd.Flag("flow", "Only sessions of the given flow.").
+		PlaceHolder("<flow>").
+		SetValue(&opts.flow)
+	purgeCmd.Flag("tag", "Only sessions with the given tag. If the value is empty, every value matches. Can be used multiple times.").
+		PlaceHolder("<key>=<value>").
+		StringMapVar(&opts.tags)
+	purgeCmd.Flag("all", "Purges also sessions which are still valid.").
+		BoolVar(&opts.all)
+
+	migrateFrom := configuration.DefaultSessionFsStorage
+	var migrateFromEncryptionKeys configuration.SessionEncryptionKeys
+	migrateCmd := cmd.Command("migrate", "Imports all sessions of a fs session storage into the configured session repository.").
+		Action(func(*kingpin.ParseContext) error {
+			return doSessionsMigrate(&opts, migrateFrom, migrateFromEncryptionKeys)
+		})
+	migrateCmd.Flag("from", "Storage directory of the fs sessions which should be imported. Default: "+configuration.DefaultSessionFsStorage).
+		PlaceHolder("<path>").
+		StringVar(&migrateFrom)
+	migrateCmd.Flag("fromEncryptionKey", "Key to decrypt the tokens of the fs sessions which should be imported. Can be used multiple times. Default: keys of the encryption of the configured session repository.").
+		PlaceHolder("<file|env|hostKey>:<value>").
+		SetValue(&migrateFromEncryptionKeys)
+
+	rewrapCmd := cmd.Command("rewrap", "Encrypts the tokens of all sessions again using the current session encryption key. Run this after the key was rotated, before the previous keys are removed from the configuration.").
+		Action(func(*kingpin.ParseContext) error {
+			return doSessionsRewrap(&opts)
+		})
+	rewrapCmd.Flag("flow", "Only sessions of the given flow.").
+		PlaceHolder("<flow>").
+		SetValue(&opts.flow)
+})
+
+type sessionsOpts struct {
+	conf configuration.Ref
+	flow configuration.FlowName
+	tags map[string]string
+	all  bool
+
+	// out receives the output of the commands. If nil, os.Stdout is used.
+	out io.Writer
+}
+
+func (this *sessionsOpts) stdout() io.Writer {
+	if v := this.out; v != nil {
+		return v
+	}
+	return goos.Stdout
+}
+
+func (this *sessionsOpts) findOpts() *session.FindOpts {
+	var result session.FindOpts
+	if !this.flow.IsZero() {
+		result.Predicates = append(result.Predicates, session.IsFlow(this.flow))
+	}
+	if len(this.tags) > 0 {
+		result.Predicates = append(result.Predicates, session.HasTags(this.tags))
+	}
+	return &result
+}
+
+type sessionRef struct {
+	flow configuration.FlowName
+	id   session.Id
+}
+
+func (this *sessionRef) Set(plain string) error {
+	parts := strings.Split(plain, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("illegal session reference %q; expected <flow>/<id>", plain)
+	}
+	var buf sessionRef
+	if err := buf.flow.Set(parts[0]); err != nil {
+		return fmt.Errorf("illegal session reference %q: %w", plain, err)
+	}
+	if err := buf.id.Set(parts[1]); err != nil {
+		return fmt.Errorf("illegal session reference %q: %w", plain, err)
+	}
+	*this = buf
+	return nil
+}
+
+func (this sessionRef) String() string {
+	return this.flow.String() + "/" + this.id.String()
+}
+
+func withSessionsAdministration(opts *sessionsOpts, action func(context.Context, *service.Administration) error) (rErr error) {
+	svc := service.Service{
+		Configuration: *opts.conf.Get(),
+		Version:       versionV,
+	}
+
+	ctx := context.Background()
+	admin, err := svc.OpenAdministration(ctx)
+	if err != nil {
+		return err
+	}
+	defer common.KeepCloseError(&rErr, admin)
+
+	return action(ctx, admin)
+}
+
+func findSessionByRef(ctx context.Context, admin *service.Administration, ref sessionRef) (session.Session, error) {
+	sess, err := admin.Sessions().FindBy(ctx, ref.flow, ref.id, nil)
+	if errors.Is(err, session.ErrNoSuchSession) {
+		return nil, errors.User.Newf("session %v does not exist", ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func doSessionsList(opts *sessionsOpts) error {
+	return withSessionsAdministration(opts, func(ctx context.Context, admin *service.Administration) (rErr error) {
+		tw := tabwriter.NewWriter(opts.stdout(), 0, 0, 2, ' ', 0)
+		defer common.KeepError(&rErr, tw.Flush)
+
+		if _, err := fmt.Fprintln(tw, "FLOW\tID\tSTATE\tCREATED\tCREATED BY\tLAST ACCESSED\tLAST ACCESSED BY\tVALID UNTIL\tKEYS\tTAGS"); err != nil {
+			return err
+		}
+
+		return admin.Sessions().FindAll(ctx, func(ctx context.Context, sess session.Session) (bool, error) {
+			d, err := describeSession(ctx, sess)
+			if err != nil {
+				return false, err
+			}
+			if _, err := fmt.Fprintf(tw, "%v\t%v\t%v\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+				d.flow, d.id, d.state,
+				formatSessionTime(d.createdAt), d.createdBy,
+				formatSessionTime(d.lastAccessedAt), d.lastAccessedBy,
+				formatSessionValidUntil(d.validUntil), len(d.publicKeys),
+				formatSessionTags(d.tags),
+			); err != nil {
+				return false, err
+			}
+			return true, nil
+		}, opts.findOpts())
+	})
+}
+
+func doSessionsShow(opts *sessionsOpts, ref sessionRef) error {
+	return withSessionsAdministration(opts, func(ctx context.Context, admin *service.Administration) (rErr error) {
+		sess, err := findSessionByRef(ctx, admin, ref)
+		if err != nil {
+			return err
+		}
+		d, err := describeSession(ctx, sess)
+		if err != nil {
+			return err
+		}
+		return d.printDetails(opts.stdout())
+	})
+}
+
+func doSessionsNote(opts *sessionsOpts, ref sessionRef, author string, text []string) error {
+	return withSessionsAdministration(opts, func(ctx context.Context, admin *service.Administration) error {
+		sess, err := findSessionByRef(ctx, admin, ref)
+		if err != nil {
+			return err
+		}
+		note := session.Note{
+			At:     time.Now().Truncate(time.Millisecond),
+			Author: author,
+			Text:   strings.Join(text, " "),
+		}
+		if err := sess.AddNote(ctx, note); err != nil {
+			return err
+		}
+		fmt.Fprintf(opts.stdout(), "note added to session %v\n", sess)
+		return nil
+	})
+}
+
+func doSessionsDispose(opts *sessionsOpts, ref sessionRef) error {
+	return withSessionsAdministration(opts, func(ctx context.Context, admin *service.Administration) error {
+		sess, err := findSessionByRef(ctx, admin, ref)
+		if err != nil {
+			return err
+		}
+		// The result of DisposeSession is not reliable to tell if the session
+		// itself was disposed before, so we ask its state instead.
+		info, err := sess.Info(ctx)
+		if err != nil {
+			return err
+		}
+		alreadyDisposed := info.State() == session.StateDisposed
+		if _, err := admin.DisposeSession(ctx, sess); err != nil {
+			return err
+		}
+		if !alreadyDisposed {
+			fmt.Fprintf(opts.stdout(), "session %v disposed\n", sess)
+		} else {
+			fmt.Fprintf(opts.stdout(), "session %v was already disposed\n", sess)
+		}
+		return nil
+	})
+}
+
+func doSessionsPurge(opts *sessionsOpts) error {
+	return withSessionsAdministration(opts, func(ctx context.Context, admin *service.Administration) error {
+		findOpts := opts.findOpts()
+		if !opts.all {
+			findOpts.Predicates = append(findOpts.Predicates, session.IsExpired)
+		}
+
+		// We collect the candidates first to not modify the repository while iterating over it.
+		var candidates []session.Session
+		if err := admin.Sessions().FindAll(ctx, func(_ context.Context, sess session.Session) (bool, error) {
+			candidates = append(candidates, sess)
+			return true, nil
+		}, findOpts); err != nil {
+			return err
+		}
+
+		for _, sess := range candidates {
+			if err := admin.DeleteSession(ctx, sess); err != nil {
+				return err
+			}
+			fmt.Fprintf(opts.stdout(), "session %v purged\n", sess)
+		}
+		fmt.Fprintf(opts.stdout(), "%d session(s) purged\n", len(candidates))
+		return nil
+	})
+}
+
+func doSessionsMigrate(opts *sessionsOpts, from string, fromEncryptionKeys configuration.SessionEncryptionKeys) error {
+	return withSessionsAdministration(opts, func(ctx context.Context, admin *service.Administration) (rErr error) {
+		target, ok := admin.Sessions().(session.Importer)
+		if !ok {
+			return errors.Config.Newf("configured session repository does not support to import sessions")
+		}
+
+		var sourceConf configuration.SessionFs
+		if err := sourceConf.SetDefaults(); err != nil {
+			return err
+		}
+		sourceConf.Storage = from
+		if len(fromEncryptionKeys) > 0 {
+			sourceConf.Encryption.Key = fromEncryptionKeys[0]
+			sourceConf.Encryption.PreviousKeys = fromEncryptionKeys[1:]
+		} else if v := sessionEncryptionOf(opts.conf.Get().Session.V); v != nil {
+			// Usually the keys are kept, when the session repository is
+			// changed.
+			sourceConf.Encryption = *v
+		}
+		if err := sourceConf.Encryption.Validate(); err != nil {
+			return errors.Config.Newf("illegal encryption of sessions to import: %w", err)
+		}
+		source, err := session.NewFsRepository(ctx, &sourceConf)
+		if err != nil {
+			return err
+		}
+		defer common.KeepCloseError(&rErr, source)
+
+		var n int
+		if err := source.FindAll(ctx, func(ctx context.Context, sess session.Session) (bool, error) {
+			if _, err := target.Import(ctx, sess); err != nil {
+				return false, err
+			}
+			fmt.Fprintf(opts.stdout(), "session %v imported\n", sess)
+			n++
+			return true, nil
+		}, nil); err != nil {
+			return err
+		}
+		fmt.Fprintf(opts.stdout(), "%d session(s) imported\n", n)
+		return nil
+	})
+}
+
+// sessionEncryptionOf returns the encryption of the given session
+// configuration or nil, if it does not support any.
+func sessionEncryptionOf(conf configuration.SessionV) *configuration.SessionEncryption {
+	switch v := conf.(type) {
+	case *configuration.SessionFs:
+		return &v.Encryption
+	case *configuration.SessionBolt:
+		return &v.Encryption
+	case *configuration.SessionRedis:
+		return &v.Encryption
+	case *configuration.SessionMemory:
+		return &v.Encryption
+	default:
+		return nil
+	}
+}
+
+func doSessionsRewrap(opts *sessionsOpts) error {
+	return withSessionsAdministration(opts, func(ctx context.Context, admin *service.Administration) error {
+		// We collect the sessions first, because some repositories do not
+		// allow modifications while iterating.
+		var all []session.Session
+		if err := admin.Sessions().FindAll(ctx, func(_ context.Context, sess session.Session) (bool, error) {
+			all = append(all, sess)
+			return true, nil
+		}, opts.findOpts()); err != nil {
+			return err
+		}
+
+		for _, sess := range all {
+			if err := rewrapSession(ctx, sess); err != nil {
+				return err
+			}
+			fmt.Fprintf(opts.stdout(), "session %v rewrapped\n", sess)
+		}
+		fmt.Fprintf(opts.stdout(), "%d session(s) rewrapped\n", len(all))
+		return nil
+	})
+}
+
+func rewrapSession(ctx context.Context, sess session.Session) error {
+	fail := func(err error) error {
+		return fmt.Errorf("cannot rewrap session %v: %w", sess, err)
+	}
+
+	at, err := sess.AuthorizationToken(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	if len(at) > 0 {
+		if err := sess.SetAuthorizationToken(ctx, at); err != nil {
+			return fail(err)
+		}
+	}
+	et, err := sess.EnvironmentToken(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	if len(et) > 0 {
+		if err := sess.SetEnvironmentToken(ctx, et); err != nil {
+			return fail(err)
+		}
+	}
+	return nil
+}
+
+type sessionDescription struct {
+	flow           configuration.FlowName
+	id             session.Id
+	state          session.State
+	createdAt      time.Time
+	createdBy      string
+	lastAccessedAt time.Time
+	lastAccessedBy string
+	validUntil     time.Time
+	publicKeys     []gossh.PublicKey
+	tags           session.Tags
+	notes          []session.Note
+}
+
+func describeSession(ctx context.Context, sess session.Session) (*sessionDescription, error) {
+	fail := func(err error) (*sessionDescription, error) {
+		return nil, fmt.Errorf("cannot describe session %v: %w", sess, err)
+	}
+
+	info, err := sess.Info(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	result := sessionDescription{
+		flow:  info.Flow(),
+		id:    info.Id(),
+		state: info.State(),
+	}
+
+	if v, err := info.Created(ctx); err != nil {
+		return fail(err)
+	} else if v != nil {
+		result.createdAt = v.At()
+		result.createdBy = v.Remote().String()
+	}
+	if v, err := info.LastAccessed(ctx); err != nil {
+		return fail(err)
+	} else if v != nil {
+		result.lastAccessedAt = v.At()
+		result.lastAccessedBy = v.Remote().String()
+	}
+	if result.validUntil, err = info.ValidUntil(ctx); err != nil {
+		return fail(err)
+	}
+	if result.publicKeys, err = sess.PublicKeys(ctx); err != nil {
+		return fail(err)
+	}
+	if result.tags, err = info.Tags(ctx); err != nil {
+		return fail(err)
+	}
+	if result.notes, err = info.Notes(ctx); err != nil {
+		return fail(err)
+	}
+
+	return &result, nil
+}
+
+func (this *sessionDescription) printDetails(w io.Writer) (rErr error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	defer common.KeepError(&rErr, tw.Flush)
+
+	validity := "valid"
+	if this.state == session.StateDisposed {
+		validity = "disposed"
+	} else if !this.validUntil.IsZero() && !time.Now().Before(this.validUntil) {
+		validity = "expired"
+	}
+
+	if _, err := fmt.Fprintf(tw, "Flow:\t%v\nId:\t%v\nState:\t%v\nValidity:\t%s\nValid until:\t%s\nCreated:\t%s\nCreated by:\t%s\nLast accessed:\t%s\nLast accessed by:\t%s\n",
+		this.flow, this.id, this.state, validity, formatSessionValidUntil(this.validUntil),
+		formatSessionTime(this.createdAt), this.createdBy,
+		formatSessionTime(this.lastAccessedAt), this.lastAccessedBy,
+	); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(tw, "Tags:\t%s\n", formatSessionTags(this.tags)); err != nil {
+		return err
+	}
+
+	if len(this.publicKeys) == 0 {
+		if _, err := fmt.Fprintf(tw, "Public keys:\t<none>\n"); err != nil {
+			return err
+		}
+	}
+	for i, pub := range this.publicKeys {
+		title := ""
+		if i == 0 {
+			title = "Public keys:"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s %s\n", title, pub.Type(), gossh.FingerprintSHA256(pub)); err != nil {
+			return err
+		}
+	}
+
+	if len(this.notes) == 0 {
+		_, err := fmt.Fprintf(tw, "Notes:\t<none>\n")
+		return err
+	}
+	for i, note := range this.notes {
+		title := ""
+		if i == 0 {
+			title = "Notes:"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s %s: %s\n", title, formatSessionTime(note.At), note.Author, note.Text); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatSessionTime(v time.Time) string {
+	if v.IsZero() {
+		return "-"
+	}
+	return v.Local().Format(time.RFC3339)
+}
+
+func formatSessionTags(v session.Tags) string {
+	if len(v) == 0 {
+		return "-"
+	}
+	return v.String()
+}
+
+func formatSessionValidUntil(v time.Time) string {
+	if v.IsZero() {
+		return "forever"
+	}
+	return formatSessionTime(v)
+}
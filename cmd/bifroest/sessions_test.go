@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	goos "os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/service"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+func TestDoSessionsList(t *testing.T) {
+	opts, repo := newTestSessionsOpts(t)
+	sess1 := newTestSession(t, repo, "foo")
+	sess2 := newTestSession(t, repo, "bar")
+
+	require.NoError(t, doSessionsList(opts))
+	actual := opts.out.(*bytes.Buffer).String()
+	require.Contains(t, actual, "FLOW")
+	require.Contains(t, actual, sess1.Id().String())
+	require.Contains(t, actual, sess2.Id().String())
+
+	opts.out = new(bytes.Buffer)
+	require.NoError(t, opts.flow.Set("foo"))
+	require.NoError(t, doSessionsList(opts))
+	actual = opts.out.(*bytes.Buffer).String()
+	require.Contains(t, actual, sess1.Id().String())
+	require.NotContains(t, actual, sess2.Id().String())
+}
+
+func TestDoSessionsShow(t *testing.T) {
+	opts, repo := newTestSessionsOpts(t)
+	sess := newTestSession(t, repo, "foo")
+
+	require.NoError(t, doSessionsShow(opts, sessionRef{sess.Flow(), sess.Id()}))
+	actual := opts.out.(*bytes.Buffer).String()
+	require.Contains(t, actual, sess.Id().String())
+	require.Contains(t, actual, "foo")
+
+	actualErr := doSessionsShow(opts, sessionRef{sess.Flow(), session.Id{}})
+	require.ErrorContains(t, actualErr, "does not exist")
+}
+
+func TestDoSessionsDispose(t *testing.T) {
+	opts, repo := newTestSessionsOpts(t)
+	sess := newTestSession(t, repo, "foo")
+	ref := sessionRef{sess.Flow(), sess.Id()}
+
+	require.NoError(t, doSessionsDispose(opts, ref))
+	require.Contains(t, opts.out.(*bytes.Buffer).String(), fmt.Sprintf("session %v disposed\n", sess))
+	require.Equal(t, session.StateDisposed, testSessionState(t, repo, sess))
+
+	opts.out = new(bytes.Buffer)
+	require.NoError(t, doSessionsDispose(opts, ref))
+	require.Contains(t, opts.out.(*bytes.Buffer).String(), fmt.Sprintf("session %v was already disposed\n", sess))
+}
+
+func TestDoSessionsPurge(t *testing.T) {
+	opts, repo := newTestSessionsOpts(t)
+	valid := newTestSession(t, repo, "foo")
+	disposed := newTestSession(t, repo, "foo")
+	require.NoError(t, doSessionsDispose(opts, sessionRef{disposed.Flow(), disposed.Id()}))
+
+	opts.out = new(bytes.Buffer)
+	require.NoError(t, doSessionsPurge(opts))
+	actual := opts.out.(*bytes.Buffer).String()
+	require.Contains(t, actual, fmt.Sprintf("session %v purged\n", disposed))
+	require.Contains(t, actual, "1 session(s) purged\n")
+	require.Equal(t, session.StateNew, testSessionState(t, repo, valid))
+	_, err := repo.FindBy(context.Background(), disposed.Flow(), disposed.Id(), nil)
+	require.ErrorIs(t, err, session.ErrNoSuchSession)
+
+	opts.out = new(bytes.Buffer)
+	opts.all = true
+	require.NoError(t, doSessionsPurge(opts))
+	require.Contains(t, opts.out.(*bytes.Buffer).String(), "1 session(s) purged\n")
+	_, err = repo.FindBy(context.Background(), valid.Flow(), valid.Id(), nil)
+	require.ErrorIs(t, err, session.ErrNoSuchSession)
+}
+
+func TestDoSessionsMigrate_encrypted(t *testing.T) {
+	dir := t.TempDir()
+	keyFn := filepath.Join(dir, "key")
+	require.NoError(t, goos.WriteFile(keyFn, []byte("0123456789abcdef0123456789abcdef"), 0600))
+	sourceDir := filepath.Join(dir, "source")
+
+	var sourceConf configuration.SessionFs
+	require.NoError(t, sourceConf.SetDefaults())
+	sourceConf.Storage = sourceDir
+	sourceConf.Encryption.Key.File = keyFn
+	source, err := session.NewFsRepository(context.Background(), &sourceConf)
+	require.NoError(t, err)
+	sess := newTestSession(t, source, "foo")
+	require.NoError(t, sess.SetAuthorizationToken(context.Background(), []byte("authorization-token")))
+	require.NoError(t, sess.SetEnvironmentToken(context.Background(), []byte("environment-token")))
+	require.NoError(t, source.Close())
+
+	assertImported := func(t *testing.T, opts *sessionsOpts) {
+		t.Helper()
+		require.Contains(t, opts.out.(*bytes.Buffer).String(), "1 session(s) imported\n")
+		require.NoError(t, withSessionsAdministration(opts, func(ctx context.Context, admin *service.Administration) error {
+			actual, err := admin.Sessions().FindBy(ctx, sess.Flow(), sess.Id(), nil)
+			require.NoError(t, err)
+			at, err := actual.AuthorizationToken(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "authorization-token", string(at))
+			et, err := actual.EnvironmentToken(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "environment-token", string(et))
+			return nil
+		}))
+	}
+
+	t.Run("without-keys", func(t *testing.T) {
+		opts := newTestSessionsOptsWith(t, `
+  type: bolt
+  file: `+filepath.Join(t.TempDir(), "sessions.db"))
+
+		actualErr := doSessionsMigrate(opts, sourceDir, nil)
+		require.ErrorContains(t, actualErr, "no session encryption is configured")
+	})
+
+	t.Run("keys-of-flag", func(t *testing.T) {
+		opts := newTestSessionsOptsWith(t, `
+  type: bolt
+  file: `+filepath.Join(t.TempDir(), "sessions.db"))
+		var keys configuration.SessionEncryptionKeys
+		require.NoError(t, keys.Set("file:"+keyFn))
+
+		require.NoError(t, doSessionsMigrate(opts, sourceDir, keys))
+		assertImported(t, opts)
+	})
+
+	t.Run("keys-of-configuration", func(t *testing.T) {
+		opts := newTestSessionsOptsWith(t, `
+  type: bolt
+  file: `+filepath.Join(t.TempDir(), "sessions.db")+`
+  encryption:
+    key:
+      file: `+keyFn)
+
+		require.NoError(t, doSessionsMigrate(opts, sourceDir, nil))
+		assertImported(t, opts)
+	})
+}
+
+func newTestSessionsOpts(t *testing.T) (*sessionsOpts, *session.FsRepository) {
+	t.Helper()
+	result := newTestSessionsOptsWith(t, `
+  type: fs
+  storage: `+filepath.Join(t.TempDir(), "sessions"))
+
+	repo, err := session.NewFsRepository(context.Background(), result.conf.Get().Session.V.(*configuration.SessionFs))
+	require.NoError(t, err)
+	t.Cleanup(func() { common.IgnoreCloseError(repo) })
+
+	return result, repo
+}
+
+// newTestSessionsOptsWith creates sessionsOpts of a configuration with the
+// given session configuration.
+func newTestSessionsOptsWith(t *testing.T, sessionConf string) *sessionsOpts {
+	t.Helper()
+	dir := t.TempDir()
+
+	confFn := filepath.Join(dir, "configuration.yaml")
+	require.NoError(t, goos.WriteFile(confFn, []byte(`ssh:
+  keys:
+    hostKeys: [`+filepath.Join(dir, "host-key")+`]
+session:`+sessionConf+`
+flows:
+  - name: foo
+    authorization:
+      type: simple
+      entries:
+        - name: user
+          authorizedKeys: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAID+B1afwLLOWeTOK3Lqztezc1aNRiLIlQcoVKfxpE4s2"
+    environment:
+      type: dummy
+  - name: bar
+    authorization:
+      type: simple
+      entries:
+        - name: user
+          authorizedKeys: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAID+B1afwLLOWeTOK3Lqztezc1aNRiLIlQcoVKfxpE4s2"
+    environment:
+      type: dummy
+`), 0600))
+
+	result := sessionsOpts{out: new(bytes.Buffer)}
+	require.NoError(t, result.conf.Set(confFn))
+	return &result
+}
+
+func newTestSession(t *testing.T, repo *session.FsRepository, flow string) session.Session {
+	t.Helper()
+	result, err := repo.Create(context.Background(), configuration.FlowName(flow), testRemote{"user", net.MustNewHost("127.0.0.1")}, nil)
+	require.NoError(t, err)
+	return result
+}
+
+func testSessionState(t *testing.T, repo *session.FsRepository, sess session.Session) session.State {
+	t.Helper()
+	actual, err := repo.FindBy(context.Background(), sess.Flow(), sess.Id(), nil)
+	require.NoError(t, err)
+	info, err := actual.Info(context.Background())
+	require.NoError(t, err)
+	return info.State()
+}
+
+type testRemote struct {
+	user string
+	host net.Host
+}
+
+func (this testRemote) User() string   { return this.user }
+func (this testRemote) Host() net.Host { return this.host }
+func (this testRemote) String() string { return this.user + "@" + this.host.String() }
@@ -6,10 +6,13 @@ import (
 	"io"
 
 	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/engity-com/bifroest/pkg/codec"
-	"github.com/engity-com/bifroest/pkg/common"
 	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/sys"
+	"github.com/engity-com/bifroest/pkg/tracing"
 )
 
 const (
@@ -19,6 +22,7 @@ const (
 type Header struct {
 	Method       Method
 	ConnectionId connection.Id
+	TraceContext tracing.Carrier
 }
 
 func (this *Header) DecodeMsgpack(dec *msgpack.Decoder) error {
@@ -41,6 +45,9 @@ func (this Header) EncodeMsgPack(enc codec.MsgPackEncoder) error {
 	if err := this.ConnectionId.EncodeMsgPack(enc); err != nil {
 		return err
 	}
+	if err := this.TraceContext.EncodeMsgPack(enc); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -60,6 +67,9 @@ func (this *Header) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
 	if err := this.ConnectionId.DecodeMsgPack(dec); err != nil {
 		return err
 	}
+	if err := this.TraceContext.DecodeMsgPack(dec); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -68,15 +78,25 @@ func (this *Master) do(ctx context.Context, ref Ref, connectionId connection.Id,
 		return err
 	}
 
+	ctx, span := this.startSpan(ctx, ref, connectionId, method)
+	defer tracing.EndWith(span, &rErr)
+
 	conn, err := this.DialContextWithMsgPack(ctx, ref)
 	if err != nil {
 		return fail(err)
 	}
-	defer common.KeepCloseError(&rErr, conn)
+	defer func() {
+		// The imp might already have closed its side (which happens
+		// immediately for unix sockets), so we cannot notify it anymore.
+		if err := conn.Close(); err != nil && !sys.IsClosedError(err) && rErr == nil {
+			rErr = err
+		}
+	}()
 
 	header := Header{
 		Method:       method,
 		ConnectionId: connectionId,
+		TraceContext: tracing.Inject(ctx),
 	}
 	if err := header.EncodeMsgPack(conn); err != nil {
 		return fail(err)
@@ -88,3 +108,11 @@ func (this *Master) do(ctx context.Context, ref Ref, connectionId connection.Id,
 
 	return nil
 }
+
+func (this *Master) startSpan(ctx context.Context, ref Ref, connectionId connection.Id, method Method) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "imp."+method.String(),
+		attribute.String("bifroest.imp.method", method.String()),
+		attribute.String("bifroest.connection.id", connectionId.String()),
+		attribute.String("bifroest.session.id", ref.SessionId().String()),
+	)
+}
@@ -6,9 +6,12 @@ import (
 	"crypto/x509"
 	gonet "net"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
 	log "github.com/echocat/slf4g"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/engity-com/bifroest/pkg/codec"
 	"github.com/engity-com/bifroest/pkg/common"
@@ -16,6 +19,7 @@ import (
 	"github.com/engity-com/bifroest/pkg/errors"
 	"github.com/engity-com/bifroest/pkg/session"
 	"github.com/engity-com/bifroest/pkg/sys"
+	"github.com/engity-com/bifroest/pkg/tracing"
 )
 
 const (
@@ -23,6 +27,10 @@ const (
 
 	DefaultExitCodeByConnectionIdPathUnix    = `/var/lib/engity/bifroest/exitcodes`
 	DefaultExitCodeByConnectionIdPathWindows = `C:\ProgramData\Engity\Bifroest\exitcodes`
+
+	// UnixAddrPrefix marks an address which refers to a unix domain socket
+	// instead of a TCP address, like: unix:/run/bifroest/imp.sock
+	UnixAddrPrefix = "unix:"
 )
 
 type Imp struct {
@@ -47,7 +55,7 @@ func (this *Imp) Serve(ctx context.Context) error {
 	}
 
 	addr := this.getAddr()
-	ln, err := gonet.Listen("tcp", addr)
+	ln, err := this.listen(addr)
 	if err != nil {
 		return failf("cannot listen to %s: %v", addr, err)
 	}
@@ -55,7 +63,9 @@ func (this *Imp) Serve(ctx context.Context) error {
 	tlsLn := tls.NewListener(ln, tlsConfig)
 
 	instance := &imp{
-		Imp: this,
+		Imp:              this,
+		terminals:        &terminals{logger: this.logger()},
+		tcpListenPending: &tcpListenAcceptedConnections{},
 	}
 
 	go func() {
@@ -74,6 +84,9 @@ func (this *Imp) Serve(ctx context.Context) error {
 
 type imp struct {
 	*Imp
+
+	terminals        *terminals
+	tcpListenPending *tcpListenAcceptedConnections
 }
 
 func (this *imp) serve(ctx context.Context, ln gonet.Listener) error {
@@ -110,6 +123,12 @@ func (this *imp) serveConn(ctx context.Context, plainConn gonet.Conn) (rErr erro
 	if err := header.DecodeMsgPack(conn); err != nil {
 		return fail(err)
 	}
+	ctx, span := tracing.Start(tracing.Extract(ctx, header.TraceContext), "imp.handle."+header.Method.String(),
+		attribute.String("bifroest.imp.method", header.Method.String()),
+		attribute.String("bifroest.connection.id", header.ConnectionId.String()),
+	)
+	defer tracing.EndWith(span, &rErr)
+
 	l := this.logger().
 		With("remote", plainConn.RemoteAddr()).
 		With("method", header.Method).
@@ -131,6 +150,20 @@ func (this *imp) serveConn(ctx context.Context, plainConn gonet.Conn) (rErr erro
 		return done(this.handleMethodGetConnectionExitCode(ctx, &header, l, conn))
 	case MethodGetEnvironment:
 		return done(this.handleMethodGetEnvironment(ctx, &header, l, conn))
+	case MethodListTerminals:
+		return done(this.handleMethodListTerminals(ctx, &header, l, conn))
+	case MethodAttachTerminal:
+		return done(this.handleMethodAttachTerminal(ctx, &header, l, conn))
+	case MethodTcpListen:
+		return done(this.handleMethodTcpListen(ctx, &header, l, conn))
+	case MethodTcpListenAccept:
+		return done(this.handleMethodTcpListenAccept(ctx, &header, l, conn))
+	case MethodStreamLocalForward:
+		return done(this.handleMethodStreamLocalForward(ctx, &header, l, conn))
+	case MethodStreamLocalListen:
+		return done(this.handleMethodStreamLocalListen(ctx, &header, l, conn))
+	case MethodX11Listen:
+		return done(this.handleMethodX11Listen(ctx, &header, l, conn))
 	default:
 		return fail(errors.Network.Newf("unsupported method %v", header.Method))
 	}
@@ -192,6 +225,27 @@ func (this *Imp) getAddr() string {
 	return ":" + strconv.Itoa(DefaultPort)
 }
 
+func (this *Imp) listen(addr string) (gonet.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, UnixAddrPrefix)
+	if !isUnix {
+		return gonet.Listen("tcp", addr)
+	}
+
+	// A stale socket of a previous run would prevent us from listening.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ln, err := gonet.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
 func (this *Imp) logger() log.Logger {
 	result := this.Logger
 	if result == nil {
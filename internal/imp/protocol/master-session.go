@@ -32,6 +32,58 @@ func (this *MasterSession) InitiateTcpForward(ctx context.Context, connectionId
 	return result, nil
 }
 
+func (this *MasterSession) InitiateTcpListen(ctx context.Context, connectionId connection.Id, bind net.HostPort) (*MasterTcpListener, error) {
+	fail := func(err error) (*MasterTcpListener, error) {
+		return nil, errors.Network.Newf("cannot initiate tcp listener for %v at %v: %w", connectionId, bind, err)
+	}
+
+	result, err := this.parent.methodTcpListen(ctx, this.ref, connectionId, bind)
+	if err != nil {
+		return fail(err)
+	}
+
+	return result, nil
+}
+
+func (this *MasterSession) InitiateStreamLocalForward(ctx context.Context, connectionId connection.Id, path string, allowedPaths []string) (gonet.Conn, error) {
+	fail := func(err error) (gonet.Conn, error) {
+		return nil, errors.Network.Newf("cannot initiate socket connection for %v to %s: %w", connectionId, path, err)
+	}
+
+	result, err := this.parent.methodStreamLocalForward(ctx, this.ref, connectionId, path, allowedPaths)
+	if err != nil {
+		return fail(err)
+	}
+
+	return result, nil
+}
+
+func (this *MasterSession) InitiateStreamLocalListen(ctx context.Context, connectionId connection.Id, path string, allowedPaths []string) (*MasterStreamLocalListener, error) {
+	fail := func(err error) (*MasterStreamLocalListener, error) {
+		return nil, errors.Network.Newf("cannot initiate socket listener for %v at %s: %w", connectionId, path, err)
+	}
+
+	result, err := this.parent.methodStreamLocalListen(ctx, this.ref, connectionId, path, allowedPaths)
+	if err != nil {
+		return fail(err)
+	}
+
+	return result, nil
+}
+
+func (this *MasterSession) InitiateX11Listen(ctx context.Context, connectionId connection.Id, authProtocol string, cookie []byte, user, group string) (*MasterX11Listener, error) {
+	fail := func(err error) (*MasterX11Listener, error) {
+		return nil, errors.Network.Newf("cannot initiate X11 display for %v: %w", connectionId, err)
+	}
+
+	result, err := this.parent.methodX11Listen(ctx, this.ref, connectionId, authProtocol, cookie, user, group)
+	if err != nil {
+		return fail(err)
+	}
+
+	return result, nil
+}
+
 func (this *MasterSession) InitiateNamedPipe(ctx context.Context, connectionId connection.Id, purpose net.Purpose) (net.NamedPipe, error) {
 	fail := func(err error) (net.NamedPipe, error) {
 		return nil, errors.Network.Newf("cannot named pipe for %v of %v: %w", connectionId, purpose, err)
@@ -72,3 +124,20 @@ func (this *MasterSession) GetEnvironment(ctx context.Context, connectionId conn
 func (this *MasterSession) Kill(ctx context.Context, connectionId connection.Id, pid int, signal sys.Signal) error {
 	return this.parent.methodKill(ctx, this.ref, connectionId, pid, signal)
 }
+
+func (this *MasterSession) ListTerminals(ctx context.Context, connectionId connection.Id) ([]TerminalInfo, error) {
+	fail := func(err error) ([]TerminalInfo, error) {
+		return nil, errors.Network.Newf("cannot list terminals for %v: %w", connectionId, err)
+	}
+
+	result, err := this.parent.methodListTerminals(ctx, this.ref, connectionId)
+	if err != nil {
+		return fail(err)
+	}
+
+	return result, nil
+}
+
+func (this *MasterSession) AttachTerminal(ctx context.Context, connectionId connection.Id, req TerminalAttach) (*MasterTerminal, error) {
+	return this.parent.methodAttachTerminal(ctx, this.ref, connectionId, req)
+}
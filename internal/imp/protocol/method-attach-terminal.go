@@ -0,0 +1,278 @@
+package protocol
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	log "github.com/echocat/slf4g"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/engity-com/bifroest/pkg/codec"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+	"github.com/engity-com/bifroest/pkg/tracing"
+)
+
+type methodAttachTerminalResponse struct {
+	found bool
+	info  TerminalInfo
+	error error
+}
+
+func (this methodAttachTerminalResponse) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodAttachTerminalResponse) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodAttachTerminalResponse) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeBool(this.found); err != nil {
+		return err
+	}
+	if err := this.info.EncodeMsgPack(enc); err != nil {
+		return err
+	}
+	if err := errors.EncodeMsgPack(this.error, enc); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *methodAttachTerminalResponse) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if this.found, err = dec.DecodeBool(); err != nil {
+		return err
+	}
+	if err := this.info.DecodeMsgPack(dec); err != nil {
+		return err
+	}
+	if this.error, err = errors.DecodeMsgPack(dec); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *imp) handleMethodAttachTerminal(_ context.Context, header *Header, logger log.Logger, conn codec.MsgPackConn) error {
+	failCore := func(err error) error {
+		return errors.Network.Newf("handling %v failed: %w", header.Method, err)
+	}
+	failResponse := func(err error) error {
+		rsp := methodAttachTerminalResponse{found: true, error: err}
+		if errors.Is(err, ErrNoSuchTerminal) {
+			rsp = methodAttachTerminalResponse{}
+		}
+		if err := rsp.EncodeMsgPack(conn); err != nil {
+			return failCore(err)
+		}
+		logger.WithError(err).
+			Info("attach terminal failed")
+		return nil
+	}
+
+	var req TerminalAttach
+	if err := req.DecodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+
+	var t *terminal
+	if req.Start != nil {
+		var err error
+		if t, err = this.terminals.start(req.Start, req.Width, req.Height); err != nil {
+			return failResponse(err)
+		}
+	} else if t = this.terminals.get(req.Id); t == nil {
+		return failResponse(ErrNoSuchTerminal)
+	} else if req.Width > 0 && req.Height > 0 {
+		if err := t.process.resize(req.Width, req.Height); err != nil {
+			logger.WithError(err).Debug("cannot resize terminal; ignoring")
+		}
+	}
+	logger = logger.With("terminal", t.id)
+
+	a, info, err := t.attach()
+	if err != nil {
+		return failResponse(err)
+	}
+	defer t.detach(a)
+
+	if err := (methodAttachTerminalResponse{found: true, info: info}).EncodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+	logger.Debug("terminal attached")
+
+	writerDone := make(chan error, 1)
+	go func() {
+		// Only this goroutine writes to the connection from now on. Once the
+		// attachment has ended, the connection is closed which also ends the
+		// reading below.
+		defer common.IgnoreCloseError(conn)
+		for f := range a.frames {
+			if err := f.EncodeMsgPack(conn); err != nil {
+				writerDone <- err
+				return
+			}
+		}
+		writerDone <- nil
+	}()
+
+	for {
+		var f terminalFrame
+		if err := f.DecodeMsgPack(conn); err != nil {
+			// Either the master has gone or this attachment has ended.
+			break
+		}
+		switch f.t {
+		case terminalFrameData:
+			if _, err := t.process.Write(f.data); err != nil {
+				logger.WithError(err).Debug("cannot write to terminal; ignoring")
+			}
+		case terminalFrameResize:
+			if err := t.process.resize(f.width, f.height); err != nil {
+				logger.WithError(err).Debug("cannot resize terminal; ignoring")
+			}
+		case terminalFrameSignal:
+			if err := t.process.signal(f.signal); err != nil {
+				logger.WithError(err).Debug("cannot signal terminal; ignoring")
+			}
+		default:
+			logger.With("frameType", f.t).Debug("unexpected terminal frame; ignoring")
+		}
+	}
+
+	t.detach(a)
+	if err := <-writerDone; err != nil && !sys.IsClosedError(err) {
+		return failCore(err)
+	}
+	return nil
+}
+
+func (this *Master) methodAttachTerminal(ctx context.Context, ref Ref, connectionId connection.Id, req TerminalAttach) (_ *MasterTerminal, rErr error) {
+	fail := func(err error) (*MasterTerminal, error) {
+		return nil, errors.Network.Newf("handling %v failed: %w", MethodAttachTerminal, err)
+	}
+
+	ctx, span := this.startSpan(ctx, ref, connectionId, MethodAttachTerminal)
+	defer tracing.EndWith(span, &rErr)
+
+	success := false
+	conn, err := this.DialContextWithMsgPack(ctx, ref)
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseErrorIfFalse(&success, conn)
+
+	if err := (Header{MethodAttachTerminal, connectionId, tracing.Inject(ctx)}).EncodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+
+	if err := req.EncodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+
+	var rsp methodAttachTerminalResponse
+	if err := rsp.DecodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+	if err := rsp.error; err != nil {
+		return nil, errors.AsRemoteError(err)
+	}
+	if !rsp.found {
+		return nil, ErrNoSuchTerminal
+	}
+
+	success = true
+	return &MasterTerminal{
+		conn: conn,
+		info: rsp.info,
+	}, nil
+}
+
+// MasterTerminal is the master side of a connection which is attached to a
+// terminal of an imp.
+type MasterTerminal struct {
+	conn codec.MsgPackConn
+	info TerminalInfo
+
+	writeMutex sync.Mutex
+
+	pending  []byte
+	exitCode *int
+	readErr  error
+}
+
+// Info returns the information about the terminal at the moment it was
+// attached.
+func (this *MasterTerminal) Info() TerminalInfo {
+	return this.info
+}
+
+// Read reads the output of the terminal. It returns io.EOF if the process of
+// the terminal has ended (see ExitCode) and ErrTerminalDetached if another
+// connection was attached to the terminal.
+func (this *MasterTerminal) Read(p []byte) (int, error) {
+	for len(this.pending) == 0 {
+		if err := this.readErr; err != nil {
+			return 0, err
+		}
+		var f terminalFrame
+		if err := f.DecodeMsgPack(this.conn); err != nil {
+			return 0, err
+		}
+		switch f.t {
+		case terminalFrameData:
+			this.pending = f.data
+		case terminalFrameExit:
+			this.exitCode = &f.exit
+			this.readErr = io.EOF
+		case terminalFrameDetached:
+			this.readErr = ErrTerminalDetached
+		default:
+			return 0, errors.Network.Newf("unexpected terminal frame type: %d", f.t)
+		}
+	}
+	n := copy(p, this.pending)
+	this.pending = this.pending[n:]
+	return n, nil
+}
+
+// Write writes the given input to the terminal.
+func (this *MasterTerminal) Write(p []byte) (int, error) {
+	if err := this.send(terminalFrame{t: terminalFrameData, data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize changes the window size of the terminal.
+func (this *MasterTerminal) Resize(width, height uint16) error {
+	return this.send(terminalFrame{t: terminalFrameResize, width: width, height: height})
+}
+
+// Signal sends the given signal to the process of the terminal.
+func (this *MasterTerminal) Signal(signal sys.Signal) error {
+	return this.send(terminalFrame{t: terminalFrameSignal, signal: signal})
+}
+
+// ExitCode returns the exitCode of the process of the terminal, if Read has
+// already returned io.EOF.
+func (this *MasterTerminal) ExitCode() (int, bool) {
+	if v := this.exitCode; v != nil {
+		return *v, true
+	}
+	return 0, false
+}
+
+// Close detaches from the terminal. The terminal itself is kept running.
+func (this *MasterTerminal) Close() error {
+	return this.conn.Close()
+}
+
+func (this *MasterTerminal) send(f terminalFrame) error {
+	this.writeMutex.Lock()
+	defer this.writeMutex.Unlock()
+	return f.EncodeMsgPack(this.conn)
+}
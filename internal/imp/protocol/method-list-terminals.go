@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"context"
+
+	log "github.com/echocat/slf4g"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/engity-com/bifroest/pkg/codec"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+type methodListTerminalsRequest struct{}
+
+func (this methodListTerminalsRequest) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodListTerminalsRequest) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodListTerminalsRequest) EncodeMsgPack(codec.MsgPackEncoder) error {
+	return nil
+}
+
+func (this *methodListTerminalsRequest) DecodeMsgPack(codec.MsgPackDecoder) (err error) {
+	return nil
+}
+
+type methodListTerminalsResponse struct {
+	terminals []TerminalInfo
+	error     error
+}
+
+func (this methodListTerminalsResponse) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodListTerminalsResponse) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodListTerminalsResponse) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeArrayLen(len(this.terminals)); err != nil {
+		return err
+	}
+	for _, v := range this.terminals {
+		if err := v.EncodeMsgPack(enc); err != nil {
+			return err
+		}
+	}
+	if err := errors.EncodeMsgPack(this.error, enc); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *methodListTerminalsResponse) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	l, err := dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+	this.terminals = nil
+	if l > 0 {
+		this.terminals = make([]TerminalInfo, l)
+		for i := range this.terminals {
+			if err := this.terminals[i].DecodeMsgPack(dec); err != nil {
+				return err
+			}
+		}
+	}
+	if this.error, err = errors.DecodeMsgPack(dec); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *imp) handleMethodListTerminals(ctx context.Context, header *Header, _ log.Logger, conn codec.MsgPackConn) error {
+	return handleFromServerSide(ctx, header, conn, func(req *methodListTerminalsRequest) methodListTerminalsResponse {
+		return methodListTerminalsResponse{terminals: this.terminals.list()}
+	})
+}
+
+func (this *Master) methodListTerminals(ctx context.Context, ref Ref, connectionId connection.Id) (result []TerminalInfo, _ error) {
+	if err := this.do(ctx, ref, connectionId, MethodListTerminals, func(header *Header, conn codec.MsgPackConn) error {
+		return handleFromClientSide(ctx, header, conn, methodListTerminalsRequest{}, func(v *methodListTerminalsResponse) error {
+			result = v.terminals
+			return errors.AsRemoteError(v.error)
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
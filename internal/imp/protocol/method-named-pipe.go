@@ -14,6 +14,7 @@ import (
 	"github.com/engity-com/bifroest/pkg/errors"
 	"github.com/engity-com/bifroest/pkg/net"
 	"github.com/engity-com/bifroest/pkg/sys"
+	"github.com/engity-com/bifroest/pkg/tracing"
 )
 
 type methodNamedPipeRequest struct {
@@ -204,11 +205,14 @@ func (this *imp) handleMethodNamedPipe(ctx context.Context, header *Header, logg
 	}
 }
 
-func (this *Master) methodNamedPipe(ctx context.Context, ref Ref, connectionId connection.Id, purpose net.Purpose) (net.NamedPipe, error) {
+func (this *Master) methodNamedPipe(ctx context.Context, ref Ref, connectionId connection.Id, purpose net.Purpose) (_ net.NamedPipe, rErr error) {
 	fail := func(err error) (net.NamedPipe, error) {
 		return nil, errors.Network.Newf("handling %v failed: %w", MethodNamedPipe, err)
 	}
 
+	ctx, span := this.startSpan(ctx, ref, connectionId, MethodNamedPipe)
+	defer tracing.EndWith(span, &rErr)
+
 	success := false
 	conn, err := this.DialContextWithMsgPack(ctx, ref)
 	if err != nil {
@@ -216,7 +220,7 @@ func (this *Master) methodNamedPipe(ctx context.Context, ref Ref, connectionId c
 	}
 	defer common.IgnoreCloseErrorIfFalse(&success, conn)
 
-	if err := (Header{MethodNamedPipe, connectionId}).EncodeMsgPack(conn); err != nil {
+	if err := (Header{MethodNamedPipe, connectionId, tracing.Inject(ctx)}).EncodeMsgPack(conn); err != nil {
 		return fail(err)
 	}
 
@@ -0,0 +1,282 @@
+package protocol
+
+import (
+	"context"
+	gonet "net"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/engity-com/bifroest/pkg/codec"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/sys"
+	"github.com/engity-com/bifroest/pkg/tracing"
+)
+
+type methodStreamLocalRequest struct {
+	path         string
+	allowedPaths []string
+}
+
+func (this methodStreamLocalRequest) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodStreamLocalRequest) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodStreamLocalRequest) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeString(this.path); err != nil {
+		return err
+	}
+	if err := enc.EncodeArrayLen(len(this.allowedPaths)); err != nil {
+		return err
+	}
+	for _, v := range this.allowedPaths {
+		if err := enc.EncodeString(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *methodStreamLocalRequest) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if this.path, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	l, err := dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+	this.allowedPaths = make([]string, l)
+	for i := range this.allowedPaths {
+		if this.allowedPaths[i], err = dec.DecodeString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type methodStreamLocalResponse struct {
+	error error
+}
+
+func (this methodStreamLocalResponse) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodStreamLocalResponse) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodStreamLocalResponse) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	return errors.EncodeMsgPack(this.error, enc)
+}
+
+func (this *methodStreamLocalResponse) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	this.error, err = errors.DecodeMsgPack(dec)
+	return err
+}
+
+func (this *imp) handleMethodStreamLocalForward(ctx context.Context, header *Header, logger log.Logger, conn codec.MsgPackConn) error {
+	failCore := func(err error) error {
+		return errors.Network.Newf("handling %v failed: %w", header.Method, err)
+	}
+	failConnectResponse := func(err error) error {
+		wrapped := reWrapIfUserFacingNetworkErrors(err)
+
+		if err := (methodStreamLocalResponse{error: wrapped}).EncodeMsgPack(conn); err != nil {
+			return failCore(err)
+		}
+		logger.WithError(err).
+			Info("socket forwarding failed")
+		return nil
+	}
+
+	var req methodStreamLocalRequest
+	if err := req.DecodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+	logger = logger.With("path", req.path)
+
+	// The path is resolved here, inside the environment, because only here
+	// symlinks point to where they actually point to.
+	resolved, err := net.ResolveAllowedSocketPath(req.allowedPaths, req.path, false)
+	if err != nil {
+		return failConnectResponse(err)
+	}
+
+	var dialer gonet.Dialer
+	target, err := dialer.DialContext(ctx, "unix", resolved)
+	if err != nil {
+		return failConnectResponse(err)
+	}
+	defer common.IgnoreCloseError(target)
+
+	if err := (methodStreamLocalResponse{}).EncodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+
+	if err := sys.FullDuplexCopy(ctx, target, conn, &sys.FullDuplexCopyOpts{
+		OnStart: func() {
+			logger.Debug("socket forwarding started")
+		},
+		OnEnd: func(s2d, d2s int64, duration time.Duration, err error, _ *bool) {
+			ld := logger.
+				With("s2d", s2d).
+				With("d2s", d2s).
+				With("duration", duration)
+			if err != nil {
+				ld.WithError(err).Error("cannot successful handle socket forwarding request; canceling...")
+			} else {
+				ld.Info("socket forwarding finished")
+			}
+		},
+	}); err != nil {
+		return failCore(err)
+	}
+
+	return nil
+}
+
+func (this *imp) handleMethodStreamLocalListen(_ context.Context, header *Header, logger log.Logger, conn codec.MsgPackConn) error {
+	failCore := func(err error) error {
+		return errors.Network.Newf("handling %v failed: %w", header.Method, err)
+	}
+
+	var req methodStreamLocalRequest
+	if err := req.DecodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+	logger = logger.With("path", req.path)
+
+	resolved, err := net.ResolveAllowedSocketPath(req.allowedPaths, req.path, true)
+	if err != nil {
+		if err := (methodStreamLocalResponse{error: err}).EncodeMsgPack(conn); err != nil {
+			return failCore(err)
+		}
+		logger.WithError(err).
+			Info("listen for remote socket forwarding not allowed")
+		return nil
+	}
+
+	ln, err := gonet.Listen("unix", resolved)
+	if err != nil {
+		wrapped := reWrapIfUserFacingNetworkErrors(err)
+		if err := (methodStreamLocalResponse{error: wrapped}).EncodeMsgPack(conn); err != nil {
+			return failCore(err)
+		}
+		logger.WithError(err).
+			Info("listen for remote socket forwarding failed")
+		return nil
+	}
+	defer common.IgnoreCloseError(ln)
+
+	if err := (methodStreamLocalResponse{}).EncodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+	logger.Debug("listening for remote socket forwarding")
+
+	if err := this.serveListener(ln, logger, conn); err != nil {
+		return failCore(err)
+	}
+	return nil
+}
+
+func (this *Master) methodStreamLocalForward(ctx context.Context, ref Ref, connectionId connection.Id, path string, allowedPaths []string) (_ gonet.Conn, rErr error) {
+	fail := func(err error) (gonet.Conn, error) {
+		return nil, errors.Network.Newf("handling %v failed: %w", MethodStreamLocalForward, err)
+	}
+
+	ctx, span := this.startSpan(ctx, ref, connectionId, MethodStreamLocalForward)
+	defer tracing.EndWith(span, &rErr)
+
+	conn, err := this.methodStreamLocal(ctx, ref, connectionId, MethodStreamLocalForward, path, allowedPaths)
+	if err != nil {
+		return fail(err)
+	}
+	return conn, nil
+}
+
+func (this *Master) methodStreamLocalListen(ctx context.Context, ref Ref, connectionId connection.Id, path string, allowedPaths []string) (_ *MasterStreamLocalListener, rErr error) {
+	fail := func(err error) (*MasterStreamLocalListener, error) {
+		return nil, errors.Network.Newf("handling %v failed: %w", MethodStreamLocalListen, err)
+	}
+
+	spanCtx, span := this.startSpan(ctx, ref, connectionId, MethodStreamLocalListen)
+	defer tracing.EndWith(span, &rErr)
+
+	conn, err := this.methodStreamLocal(spanCtx, ref, connectionId, MethodStreamLocalListen, path, allowedPaths)
+	if err != nil {
+		return fail(err)
+	}
+	return &MasterStreamLocalListener{
+		parent:       this,
+		ref:          ref,
+		connectionId: connectionId,
+		context:      ctx,
+		conn:         conn,
+		path:         path,
+	}, nil
+}
+
+func (this *Master) methodStreamLocal(ctx context.Context, ref Ref, connectionId connection.Id, method Method, path string, allowedPaths []string) (codec.MsgPackConn, error) {
+	success := false
+	conn, err := this.DialContextWithMsgPack(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer common.IgnoreCloseErrorIfFalse(&success, conn)
+
+	if err := (Header{method, connectionId, tracing.Inject(ctx)}).EncodeMsgPack(conn); err != nil {
+		return nil, err
+	}
+
+	if err := (methodStreamLocalRequest{path, allowedPaths}).EncodeMsgPack(conn); err != nil {
+		return nil, err
+	}
+
+	var rsp methodStreamLocalResponse
+	if err := rsp.DecodeMsgPack(conn); err != nil {
+		return nil, err
+	}
+	if err := rsp.error; err != nil {
+		return nil, errors.AsRemoteError(err)
+	}
+
+	success = true
+	return conn, nil
+}
+
+// MasterStreamLocalListener is the master side of a listener on a unix socket
+// inside an imp, which accepts connections of a remote socket forwarding.
+type MasterStreamLocalListener struct {
+	parent       *Master
+	ref          Ref
+	connectionId connection.Id
+	context      context.Context
+
+	conn codec.MsgPackConn
+	path string
+}
+
+// Accept waits for the next connection accepted by the imp.
+func (this *MasterStreamLocalListener) Accept() (gonet.Conn, error) {
+	result, _, err := this.parent.acceptListened(this.context, this.ref, this.connectionId, this.conn)
+	return result, err
+}
+
+// Addr returns the path of the unix socket inside the imp.
+func (this *MasterStreamLocalListener) Addr() gonet.Addr {
+	return &gonet.UnixAddr{Name: this.path, Net: "unix"}
+}
+
+// Close stops the listener inside the imp.
+func (this *MasterStreamLocalListener) Close() error {
+	return this.conn.Close()
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/engity-com/bifroest/pkg/errors"
 	"github.com/engity-com/bifroest/pkg/net"
 	"github.com/engity-com/bifroest/pkg/sys"
+	"github.com/engity-com/bifroest/pkg/tracing"
 )
 
 type methodTcpForwardRequest struct {
@@ -143,11 +144,14 @@ func (this *imp) handleMethodTcpForward(ctx context.Context, header *Header, log
 	return nil
 }
 
-func (this *Master) methodTcpForward(ctx context.Context, ref Ref, connectionId connection.Id, target net.HostPort) (gonet.Conn, error) {
+func (this *Master) methodTcpForward(ctx context.Context, ref Ref, connectionId connection.Id, target net.HostPort) (_ gonet.Conn, rErr error) {
 	fail := func(err error) (gonet.Conn, error) {
 		return nil, errors.Network.Newf("handling %v failed: %w", MethodTcpForward, err)
 	}
 
+	ctx, span := this.startSpan(ctx, ref, connectionId, MethodTcpForward)
+	defer tracing.EndWith(span, &rErr)
+
 	success := false
 	conn, err := this.DialContextWithMsgPack(ctx, ref)
 	if err != nil {
@@ -155,7 +159,7 @@ func (this *Master) methodTcpForward(ctx context.Context, ref Ref, connectionId
 	}
 	defer common.IgnoreCloseErrorIfFalse(&success, conn)
 
-	if err := (Header{MethodTcpForward, connectionId}).EncodeMsgPack(conn); err != nil {
+	if err := (Header{MethodTcpForward, connectionId, tracing.Inject(ctx)}).EncodeMsgPack(conn); err != nil {
 		return fail(err)
 	}
 
@@ -0,0 +1,495 @@
+package protocol
+
+import (
+	"context"
+	"io"
+	gonet "net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/engity-com/bifroest/pkg/codec"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/sys"
+	"github.com/engity-com/bifroest/pkg/tracing"
+)
+
+// encodeListenAddress encodes host and port separately, because net.HostPort
+// does not support port 0, which requests a dynamically allocated port.
+func encodeListenAddress(v net.HostPort, enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeString(v.Host.String()); err != nil {
+		return err
+	}
+	if err := enc.EncodeUint16(v.Port); err != nil {
+		return err
+	}
+	return nil
+}
+
+func decodeListenAddress(dec codec.MsgPackDecoder) (result net.HostPort, err error) {
+	host, err := dec.DecodeString()
+	if err != nil {
+		return result, err
+	}
+	if err := result.Host.Set(host); err != nil {
+		return result, err
+	}
+	if result.Port, err = dec.DecodeUint16(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func listenAddressOf(addr gonet.Addr) (result net.HostPort, err error) {
+	if err := result.Host.SetNetAddr(addr); err != nil {
+		return result, err
+	}
+	if v, ok := addr.(*gonet.TCPAddr); ok {
+		result.Port = uint16(v.Port)
+	}
+	return result, nil
+}
+
+type methodTcpListenRequest struct {
+	bind net.HostPort
+}
+
+func (this methodTcpListenRequest) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodTcpListenRequest) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodTcpListenRequest) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	return encodeListenAddress(this.bind, enc)
+}
+
+func (this *methodTcpListenRequest) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	this.bind, err = decodeListenAddress(dec)
+	return err
+}
+
+type methodTcpListenResponse struct {
+	bound net.HostPort
+	error error
+}
+
+func (this methodTcpListenResponse) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodTcpListenResponse) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodTcpListenResponse) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := encodeListenAddress(this.bound, enc); err != nil {
+		return err
+	}
+	if err := errors.EncodeMsgPack(this.error, enc); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *methodTcpListenResponse) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if this.bound, err = decodeListenAddress(dec); err != nil {
+		return err
+	}
+	if this.error, err = errors.DecodeMsgPack(dec); err != nil {
+		return err
+	}
+	return nil
+}
+
+// tcpListenAccepted is sent by the imp for each connection which was accepted
+// by a listener of MethodTcpListen. The master can fetch the connection with
+// MethodTcpListenAccept.
+type tcpListenAccepted struct {
+	id     uint64
+	origin net.HostPort
+}
+
+func (this tcpListenAccepted) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *tcpListenAccepted) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this tcpListenAccepted) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeUint64(this.id); err != nil {
+		return err
+	}
+	if err := encodeListenAddress(this.origin, enc); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *tcpListenAccepted) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if this.id, err = dec.DecodeUint64(); err != nil {
+		return err
+	}
+	if this.origin, err = decodeListenAddress(dec); err != nil {
+		return err
+	}
+	return nil
+}
+
+type methodTcpListenAcceptRequest struct {
+	id uint64
+}
+
+func (this methodTcpListenAcceptRequest) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodTcpListenAcceptRequest) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodTcpListenAcceptRequest) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	return enc.EncodeUint64(this.id)
+}
+
+func (this *methodTcpListenAcceptRequest) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	this.id, err = dec.DecodeUint64()
+	return err
+}
+
+type methodTcpListenAcceptResponse struct {
+	found bool
+}
+
+func (this methodTcpListenAcceptResponse) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodTcpListenAcceptResponse) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodTcpListenAcceptResponse) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	return enc.EncodeBool(this.found)
+}
+
+func (this *methodTcpListenAcceptResponse) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	this.found, err = dec.DecodeBool()
+	return err
+}
+
+// tcpListenAcceptedConnections holds all connections which were accepted by
+// listeners of MethodTcpListen, until the master has fetched them via
+// MethodTcpListenAccept.
+type tcpListenAcceptedConnections struct {
+	mutex  sync.Mutex
+	lastId uint64
+	byId   map[uint64]tcpListenAcceptedConnection
+}
+
+type tcpListenAcceptedConnection struct {
+	conn  gonet.Conn
+	owner gonet.Listener
+}
+
+func (this *tcpListenAcceptedConnections) add(owner gonet.Listener, conn gonet.Conn) uint64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.byId == nil {
+		this.byId = make(map[uint64]tcpListenAcceptedConnection)
+	}
+	this.lastId++
+	this.byId[this.lastId] = tcpListenAcceptedConnection{conn, owner}
+	return this.lastId
+}
+
+func (this *tcpListenAcceptedConnections) take(id uint64) gonet.Conn {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	v, ok := this.byId[id]
+	if !ok {
+		return nil
+	}
+	delete(this.byId, id)
+	return v.conn
+}
+
+// closeAllOf closes all connections of the given listener which were not
+// fetched by the master.
+func (this *tcpListenAcceptedConnections) closeAllOf(owner gonet.Listener) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for id, v := range this.byId {
+		if v.owner == owner {
+			common.IgnoreCloseError(v.conn)
+			delete(this.byId, id)
+		}
+	}
+}
+
+func (this *imp) handleMethodTcpListen(_ context.Context, header *Header, logger log.Logger, conn codec.MsgPackConn) error {
+	failCore := func(err error) error {
+		return errors.Network.Newf("handling %v failed: %w", header.Method, err)
+	}
+	failListenResponse := func(err error) error {
+		wrapped := reWrapIfUserFacingNetworkErrors(err)
+
+		if err := (methodTcpListenResponse{error: wrapped}).EncodeMsgPack(conn); err != nil {
+			return failCore(err)
+		}
+		logger.WithError(err).
+			Info("listen for remote port forwarding failed")
+		return nil
+	}
+
+	var req methodTcpListenRequest
+	if err := req.DecodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+	logger = logger.With("bind", req.bind.String())
+
+	ln, err := gonet.Listen("tcp", gonet.JoinHostPort(req.bind.Host.String(), strconv.Itoa(int(req.bind.Port))))
+	if err != nil {
+		return failListenResponse(err)
+	}
+	defer common.IgnoreCloseError(ln)
+
+	bound, err := listenAddressOf(ln.Addr())
+	if err != nil {
+		return failListenResponse(err)
+	}
+	if err := (methodTcpListenResponse{bound: bound}).EncodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+	logger = logger.With("bound", bound)
+	logger.Debug("listening for remote port forwarding")
+
+	if err := this.serveListener(ln, logger, conn); err != nil {
+		return failCore(err)
+	}
+	return nil
+}
+
+// serveListener announces each connection accepted by the given listener via
+// conn to the master, until either the listener or conn is closed. The master
+// fetches the connections with MethodTcpListenAccept.
+func (this *imp) serveListener(ln gonet.Listener, logger log.Logger, conn codec.MsgPackConn) error {
+	defer this.tcpListenPending.closeAllOf(ln)
+
+	go func() {
+		// The master does not send anything anymore. If its connection is
+		// closed, the listener is closed, too.
+		_, _ = io.Copy(io.Discard, conn)
+		_ = ln.Close()
+	}()
+
+	for {
+		accepted, err := ln.Accept()
+		if err != nil {
+			if sys.IsClosedError(err) {
+				logger.Debug("listening for remote port forwarding stopped")
+				return nil
+			}
+			return err
+		}
+
+		var origin net.HostPort
+		if _, ok := accepted.RemoteAddr().(*gonet.TCPAddr); ok {
+			if origin, err = listenAddressOf(accepted.RemoteAddr()); err != nil {
+				logger.WithError(err).Debug("cannot resolve origin of accepted connection; ignoring")
+			}
+		}
+
+		id := this.tcpListenPending.add(ln, accepted)
+		if err := (tcpListenAccepted{id, origin}).EncodeMsgPack(conn); err != nil {
+			if sys.IsClosedError(err) {
+				return nil
+			}
+			return err
+		}
+		logger.With("origin", origin.String()).Debug("remote port forwarding connection accepted")
+	}
+}
+
+func (this *imp) handleMethodTcpListenAccept(ctx context.Context, header *Header, logger log.Logger, conn codec.MsgPackConn) error {
+	failCore := func(err error) error {
+		return errors.Network.Newf("handling %v failed: %w", header.Method, err)
+	}
+
+	var req methodTcpListenAcceptRequest
+	if err := req.DecodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+
+	target := this.tcpListenPending.take(req.id)
+	if target == nil {
+		if err := (methodTcpListenAcceptResponse{}).EncodeMsgPack(conn); err != nil {
+			return failCore(err)
+		}
+		return nil
+	}
+	defer common.IgnoreCloseError(target)
+	logger = logger.With("origin", target.RemoteAddr())
+
+	if err := (methodTcpListenAcceptResponse{found: true}).EncodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+
+	if err := sys.FullDuplexCopy(ctx, target, conn, &sys.FullDuplexCopyOpts{
+		OnStart: func() {
+			logger.Debug("remote port forwarding started")
+		},
+		OnEnd: func(s2d, d2s int64, duration time.Duration, err error, _ *bool) {
+			ld := logger.
+				With("s2d", s2d).
+				With("d2s", d2s).
+				With("duration", duration)
+			if err != nil {
+				ld.WithError(err).Error("cannot successful handle remote port forwarding request; canceling...")
+			} else {
+				ld.Info("remote port forwarding finished")
+			}
+		},
+	}); err != nil {
+		return failCore(err)
+	}
+
+	return nil
+}
+
+func (this *Master) methodTcpListen(ctx context.Context, ref Ref, connectionId connection.Id, bind net.HostPort) (_ *MasterTcpListener, rErr error) {
+	fail := func(err error) (*MasterTcpListener, error) {
+		return nil, errors.Network.Newf("handling %v failed: %w", MethodTcpListen, err)
+	}
+
+	spanCtx, span := this.startSpan(ctx, ref, connectionId, MethodTcpListen)
+	defer tracing.EndWith(span, &rErr)
+
+	success := false
+	conn, err := this.DialContextWithMsgPack(spanCtx, ref)
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseErrorIfFalse(&success, conn)
+
+	if err := (Header{MethodTcpListen, connectionId, tracing.Inject(spanCtx)}).EncodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+
+	if err := (methodTcpListenRequest{bind}).EncodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+
+	var rsp methodTcpListenResponse
+	if err := rsp.DecodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+	if err := rsp.error; err != nil {
+		return fail(errors.AsRemoteError(err))
+	}
+
+	success = true
+	return &MasterTcpListener{
+		parent:       this,
+		ref:          ref,
+		connectionId: connectionId,
+		context:      ctx,
+		conn:         conn,
+		addr:         rsp.bound,
+	}, nil
+}
+
+func (this *Master) methodTcpListenAccept(ctx context.Context, ref Ref, connectionId connection.Id, id uint64) (_ gonet.Conn, rErr error) {
+	fail := func(err error) (gonet.Conn, error) {
+		return nil, errors.Network.Newf("handling %v failed: %w", MethodTcpListenAccept, err)
+	}
+
+	ctx, span := this.startSpan(ctx, ref, connectionId, MethodTcpListenAccept)
+	defer tracing.EndWith(span, &rErr)
+
+	success := false
+	conn, err := this.DialContextWithMsgPack(ctx, ref)
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseErrorIfFalse(&success, conn)
+
+	if err := (Header{MethodTcpListenAccept, connectionId, tracing.Inject(ctx)}).EncodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+
+	if err := (methodTcpListenAcceptRequest{id}).EncodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+
+	var rsp methodTcpListenAcceptResponse
+	if err := rsp.DecodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+	if !rsp.found {
+		return nil, nil
+	}
+
+	success = true
+	return conn, nil
+}
+
+// MasterTcpListener is the master side of a listener inside an imp, which
+// accepts connections of a remote port forwarding.
+type MasterTcpListener struct {
+	parent       *Master
+	ref          Ref
+	connectionId connection.Id
+	context      context.Context
+
+	conn codec.MsgPackConn
+	addr net.HostPort
+}
+
+// Addr returns the address the listener is bound to. If port 0 was
+// requested, it contains the actually allocated port.
+func (this *MasterTcpListener) Addr() net.HostPort {
+	return this.addr
+}
+
+// Accept waits for the next connection accepted by the imp and returns it
+// together with the address of its origin.
+func (this *MasterTcpListener) Accept() (gonet.Conn, net.HostPort, error) {
+	return this.parent.acceptListened(this.context, this.ref, this.connectionId, this.conn)
+}
+
+// acceptListened waits for the next connection announced by serveListener of
+// the imp via conn and fetches it.
+func (this *Master) acceptListened(ctx context.Context, ref Ref, connectionId connection.Id, conn codec.MsgPackConn) (gonet.Conn, net.HostPort, error) {
+	for {
+		var accepted tcpListenAccepted
+		if err := accepted.DecodeMsgPack(conn); err != nil {
+			return nil, net.HostPort{}, err
+		}
+
+		result, err := this.methodTcpListenAccept(ctx, ref, connectionId, accepted.id)
+		if err != nil {
+			return nil, net.HostPort{}, err
+		}
+		if result == nil {
+			// Already gone; wait for the next one...
+			continue
+		}
+		return result, accepted.origin, nil
+	}
+}
+
+// Close stops the listener inside the imp.
+func (this *MasterTcpListener) Close() error {
+	return this.conn.Close()
+}
@@ -0,0 +1,254 @@
+package protocol
+
+import (
+	"context"
+	gonet "net"
+	"os"
+
+	log "github.com/echocat/slf4g"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/engity-com/bifroest/pkg/codec"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/ssh"
+	"github.com/engity-com/bifroest/pkg/tracing"
+)
+
+type methodX11ListenRequest struct {
+	authProtocol string
+	cookie       []byte
+	user         string
+	group        string
+}
+
+func (this methodX11ListenRequest) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodX11ListenRequest) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodX11ListenRequest) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeString(this.authProtocol); err != nil {
+		return err
+	}
+	if err := enc.EncodeBytes(this.cookie); err != nil {
+		return err
+	}
+	if err := enc.EncodeString(this.user); err != nil {
+		return err
+	}
+	if err := enc.EncodeString(this.group); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *methodX11ListenRequest) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if this.authProtocol, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	if this.cookie, err = dec.DecodeBytes(); err != nil {
+		return err
+	}
+	if this.user, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	if this.group, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	return nil
+}
+
+type methodX11ListenResponse struct {
+	display   uint16
+	authority string
+	error     error
+}
+
+func (this methodX11ListenResponse) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *methodX11ListenResponse) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this methodX11ListenResponse) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeUint16(this.display); err != nil {
+		return err
+	}
+	if err := enc.EncodeString(this.authority); err != nil {
+		return err
+	}
+	if err := errors.EncodeMsgPack(this.error, enc); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *methodX11ListenResponse) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if this.display, err = dec.DecodeUint16(); err != nil {
+		return err
+	}
+	if this.authority, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	if this.error, err = errors.DecodeMsgPack(dec); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *imp) handleMethodX11Listen(_ context.Context, header *Header, logger log.Logger, conn codec.MsgPackConn) error {
+	failCore := func(err error) error {
+		return errors.Network.Newf("handling %v failed: %w", header.Method, err)
+	}
+	failListenResponse := func(err error) error {
+		wrapped := reWrapIfUserFacingNetworkErrors(err)
+
+		if err := (methodX11ListenResponse{error: wrapped}).EncodeMsgPack(conn); err != nil {
+			return failCore(err)
+		}
+		logger.WithError(err).
+			Info("listen for X11 forwarding failed")
+		return nil
+	}
+
+	var req methodX11ListenRequest
+	if err := req.DecodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+
+	ln, display, err := ssh.ListenX11Display()
+	if err != nil {
+		return failListenResponse(err)
+	}
+	defer common.IgnoreCloseError(ln)
+	logger = logger.With("display", display)
+
+	authority, err := ssh.NewXAuthorityFile(display, req.authProtocol, req.cookie)
+	if err != nil {
+		return failListenResponse(err)
+	}
+	defer func() {
+		_ = os.Remove(authority)
+	}()
+	if err := chownXAuthority(authority, req.user, req.group); err != nil {
+		return failListenResponse(err)
+	}
+
+	if err := (methodX11ListenResponse{display: display, authority: authority}).EncodeMsgPack(conn); err != nil {
+		return failCore(err)
+	}
+	logger.Debug("listening for X11 forwarding")
+
+	if err := this.serveListener(ln, logger, conn); err != nil {
+		return failCore(err)
+	}
+	return nil
+}
+
+func (this *Master) methodX11Listen(ctx context.Context, ref Ref, connectionId connection.Id, authProtocol string, cookie []byte, user, group string) (_ *MasterX11Listener, rErr error) {
+	fail := func(err error) (*MasterX11Listener, error) {
+		return nil, errors.Network.Newf("handling %v failed: %w", MethodX11Listen, err)
+	}
+
+	spanCtx, span := this.startSpan(ctx, ref, connectionId, MethodX11Listen)
+	defer tracing.EndWith(span, &rErr)
+
+	success := false
+	conn, err := this.DialContextWithMsgPack(spanCtx, ref)
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseErrorIfFalse(&success, conn)
+
+	if err := (Header{MethodX11Listen, connectionId, tracing.Inject(spanCtx)}).EncodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+
+	if err := (methodX11ListenRequest{authProtocol, cookie, user, group}).EncodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+
+	var rsp methodX11ListenResponse
+	if err := rsp.DecodeMsgPack(conn); err != nil {
+		return fail(err)
+	}
+	if err := rsp.error; err != nil {
+		return fail(errors.AsRemoteError(err))
+	}
+
+	success = true
+	return &MasterX11Listener{
+		parent:       this,
+		ref:          ref,
+		connectionId: connectionId,
+		context:      ctx,
+		conn:         conn,
+		display:      rsp.display,
+		authority:    rsp.authority,
+	}, nil
+}
+
+// MasterX11Listener is the master side of a X11 display inside an imp, which
+// accepts connections of X11 clients.
+type MasterX11Listener struct {
+	parent       *Master
+	ref          Ref
+	connectionId connection.Id
+	context      context.Context
+
+	conn      codec.MsgPackConn
+	display   uint16
+	authority string
+}
+
+// Display returns the number of the display inside the imp.
+func (this *MasterX11Listener) Display() uint16 {
+	return this.display
+}
+
+// Authority returns the path of the Xauthority file inside the imp, which
+// grants access to the display. It is removed by the imp together with the
+// display.
+func (this *MasterX11Listener) Authority() string {
+	return this.authority
+}
+
+// Accept waits for the next connection accepted by the imp.
+func (this *MasterX11Listener) Accept() (gonet.Conn, error) {
+	result, origin, err := this.parent.acceptListened(this.context, this.ref, this.connectionId, this.conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(origin.Host.IP) == 0 {
+		return result, nil
+	}
+	return &originConn{result, &gonet.TCPAddr{IP: origin.Host.IP, Port: int(origin.Port)}}, nil
+}
+
+// Addr returns the address of the display inside the imp.
+func (this *MasterX11Listener) Addr() gonet.Addr {
+	return &gonet.TCPAddr{IP: gonet.IPv4(127, 0, 0, 1), Port: int(this.display) + 6000}
+}
+
+// Close removes the display inside the imp.
+func (this *MasterX11Listener) Close() error {
+	return this.conn.Close()
+}
+
+// originConn reports the origin of a connection accepted by the imp as its
+// remote address instead of the address of the imp itself.
+type originConn struct {
+	gonet.Conn
+	origin gonet.Addr
+}
+
+func (this *originConn) RemoteAddr() gonet.Addr {
+	return this.origin
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package protocol
+
+import (
+	"os"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+func chownXAuthority(fn string, user, group string) error {
+	if user == "" {
+		return nil
+	}
+	credential, _, err := sys.LookupCredential(user, group)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(fn, int(credential.Uid), int(credential.Gid)); err != nil {
+		return errors.System.Newf("cannot change owner of %s: %w", fn, err)
+	}
+	return nil
+}
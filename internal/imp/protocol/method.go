@@ -19,6 +19,13 @@ const (
 	MethodNamedPipe
 	MethodGetConnectionExitCode
 	MethodGetEnvironment
+	MethodListTerminals
+	MethodAttachTerminal
+	MethodTcpListen
+	MethodTcpListenAccept
+	MethodStreamLocalForward
+	MethodStreamLocalListen
+	MethodX11Listen
 )
 
 var (
@@ -82,6 +89,13 @@ var (
 		"namedPipe":             MethodNamedPipe,
 		"getConnectionExitCode": MethodGetConnectionExitCode,
 		"getEnvironment":        MethodGetEnvironment,
+		"listTerminals":         MethodListTerminals,
+		"attachTerminal":        MethodAttachTerminal,
+		"tcpListen":             MethodTcpListen,
+		"tcpListenAccept":       MethodTcpListenAccept,
+		"streamLocalForward":    MethodStreamLocalForward,
+		"streamLocalListen":     MethodStreamLocalListen,
+		"x11Listen":             MethodX11Listen,
 	}
 	protocolMethodToString = func(in map[string]Method) map[Method]string {
 		result := make(map[Method]string, len(in))
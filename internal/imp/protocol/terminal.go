@@ -0,0 +1,383 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/engity-com/bifroest/pkg/codec"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+var (
+	ErrNoSuchTerminal   = errors.User.Newf("no such terminal")
+	ErrTerminalDetached = errors.User.Newf("terminal was attached by another connection")
+)
+
+// TerminalId identifies a terminal inside one imp.
+type TerminalId uint32
+
+func (this TerminalId) String() string {
+	return strconv.FormatUint(uint64(this), 10)
+}
+
+func (this *TerminalId) Set(plain string) error {
+	v, err := strconv.ParseUint(plain, 10, 32)
+	if err != nil {
+		return fmt.Errorf("illegal terminal id: %q", plain)
+	}
+	*this = TerminalId(v)
+	return nil
+}
+
+func (this TerminalId) IsZero() bool {
+	return this == 0
+}
+
+// TerminalInfo describes a terminal which is managed by an imp.
+type TerminalInfo struct {
+	Id      TerminalId
+	Command []string
+	Started time.Time
+
+	// Attached is true if a connection is currently attached to this terminal.
+	Attached bool
+
+	// Detached is the time since when no connection is attached anymore.
+	Detached time.Time
+
+	// IdleTimeout is the duration after a detached terminal is terminated.
+	IdleTimeout time.Duration
+}
+
+func (this TerminalInfo) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *TerminalInfo) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this TerminalInfo) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeUint32(uint32(this.Id)); err != nil {
+		return err
+	}
+	if err := encodeStrings(this.Command, enc); err != nil {
+		return err
+	}
+	if err := encodeTime(this.Started, enc); err != nil {
+		return err
+	}
+	if err := enc.EncodeBool(this.Attached); err != nil {
+		return err
+	}
+	if err := encodeTime(this.Detached, enc); err != nil {
+		return err
+	}
+	if err := enc.EncodeDuration(this.IdleTimeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *TerminalInfo) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if v, err := dec.DecodeUint32(); err != nil {
+		return err
+	} else {
+		this.Id = TerminalId(v)
+	}
+	if this.Command, err = decodeStrings(dec); err != nil {
+		return err
+	}
+	if this.Started, err = decodeTime(dec); err != nil {
+		return err
+	}
+	if this.Attached, err = dec.DecodeBool(); err != nil {
+		return err
+	}
+	if this.Detached, err = decodeTime(dec); err != nil {
+		return err
+	}
+	if this.IdleTimeout, err = dec.DecodeDuration(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TerminalStart defines how the process of a new terminal is started.
+type TerminalStart struct {
+	// Path of the executable. If empty, the first element of Argv is used.
+	Path string
+	Argv []string
+	Dir  string
+
+	// Env will be added to the environment of the imp itself.
+	Env sys.EnvVars
+
+	// User and Group the process runs with. If empty, the same as the imp.
+	User  string
+	Group string
+
+	// IdleTimeout is the duration after a detached terminal is terminated.
+	// 0 means never.
+	IdleTimeout time.Duration
+
+	// Scrollback is the amount of bytes of the most recent output which are
+	// replayed once the terminal is attached again.
+	Scrollback uint32
+}
+
+func (this TerminalStart) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *TerminalStart) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this TerminalStart) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeString(this.Path); err != nil {
+		return err
+	}
+	if err := encodeStrings(this.Argv, enc); err != nil {
+		return err
+	}
+	if err := enc.EncodeString(this.Dir); err != nil {
+		return err
+	}
+	if err := encodeStrings(this.Env.Strings(), enc); err != nil {
+		return err
+	}
+	if err := enc.EncodeString(this.User); err != nil {
+		return err
+	}
+	if err := enc.EncodeString(this.Group); err != nil {
+		return err
+	}
+	if err := enc.EncodeDuration(this.IdleTimeout); err != nil {
+		return err
+	}
+	if err := enc.EncodeUint32(this.Scrollback); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *TerminalStart) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if this.Path, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	if this.Argv, err = decodeStrings(dec); err != nil {
+		return err
+	}
+	if this.Dir, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	if env, err := decodeStrings(dec); err != nil {
+		return err
+	} else {
+		this.Env = sys.EnvVars{}
+		this.Env.Add(env...)
+	}
+	if this.User, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	if this.Group, err = dec.DecodeString(); err != nil {
+		return err
+	}
+	if this.IdleTimeout, err = dec.DecodeDuration(); err != nil {
+		return err
+	}
+	if this.Scrollback, err = dec.DecodeUint32(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TerminalAttach requests to attach to an existing terminal (if Id is
+// provided) or to start a new one (if Start is provided).
+type TerminalAttach struct {
+	Id    TerminalId
+	Start *TerminalStart
+
+	Width  uint16
+	Height uint16
+}
+
+func (this TerminalAttach) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *TerminalAttach) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this TerminalAttach) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeUint32(uint32(this.Id)); err != nil {
+		return err
+	}
+	if err := enc.EncodeBool(this.Start != nil); err != nil {
+		return err
+	}
+	if this.Start != nil {
+		if err := this.Start.EncodeMsgPack(enc); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeUint16(this.Width); err != nil {
+		return err
+	}
+	if err := enc.EncodeUint16(this.Height); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (this *TerminalAttach) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if v, err := dec.DecodeUint32(); err != nil {
+		return err
+	} else {
+		this.Id = TerminalId(v)
+	}
+	if hasStart, err := dec.DecodeBool(); err != nil {
+		return err
+	} else if hasStart {
+		this.Start = new(TerminalStart)
+		if err := this.Start.DecodeMsgPack(dec); err != nil {
+			return err
+		}
+	} else {
+		this.Start = nil
+	}
+	if this.Width, err = dec.DecodeUint16(); err != nil {
+		return err
+	}
+	if this.Height, err = dec.DecodeUint16(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// terminalFrameType is the type of each frame which is exchanged while a
+// connection is attached to a terminal.
+type terminalFrameType uint8
+
+const (
+	// terminalFrameData contains input (master -> imp) or output (imp -> master).
+	terminalFrameData terminalFrameType = iota
+	// terminalFrameResize contains the new size of the window (master -> imp).
+	terminalFrameResize
+	// terminalFrameSignal contains a signal to be sent to the process (master -> imp).
+	terminalFrameSignal
+	// terminalFrameExit contains the exitCode of the process (imp -> master).
+	terminalFrameExit
+	// terminalFrameDetached signals that another connection was attached (imp -> master).
+	terminalFrameDetached
+)
+
+type terminalFrame struct {
+	t      terminalFrameType
+	data   []byte
+	width  uint16
+	height uint16
+	signal sys.Signal
+	exit   int
+}
+
+func (this terminalFrame) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeUint8(uint8(this.t)); err != nil {
+		return err
+	}
+	switch this.t {
+	case terminalFrameData:
+		return enc.EncodeBytes(this.data)
+	case terminalFrameResize:
+		if err := enc.EncodeUint16(this.width); err != nil {
+			return err
+		}
+		return enc.EncodeUint16(this.height)
+	case terminalFrameSignal:
+		return this.signal.EncodeMsgPack(enc)
+	case terminalFrameExit:
+		return enc.EncodeInt(int64(this.exit))
+	case terminalFrameDetached:
+		return nil
+	default:
+		return errors.Network.Newf("illegal terminal frame type: %d", this.t)
+	}
+}
+
+func (this *terminalFrame) DecodeMsgPack(dec codec.MsgPackDecoder) (err error) {
+	if v, err := dec.DecodeUint8(); err != nil {
+		return err
+	} else {
+		this.t = terminalFrameType(v)
+	}
+	switch this.t {
+	case terminalFrameData:
+		this.data, err = dec.DecodeBytes()
+		return err
+	case terminalFrameResize:
+		if this.width, err = dec.DecodeUint16(); err != nil {
+			return err
+		}
+		this.height, err = dec.DecodeUint16()
+		return err
+	case terminalFrameSignal:
+		return this.signal.DecodeMsgPack(dec)
+	case terminalFrameExit:
+		this.exit, err = dec.DecodeInt()
+		return err
+	case terminalFrameDetached:
+		return nil
+	default:
+		return errors.Network.Newf("illegal terminal frame type: %d", this.t)
+	}
+}
+
+func encodeStrings(vs []string, enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeArrayLen(len(vs)); err != nil {
+		return err
+	}
+	for _, v := range vs {
+		if err := enc.EncodeString(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeStrings(dec codec.MsgPackDecoder) ([]string, error) {
+	l, err := dec.DecodeArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	if l < 0 {
+		return nil, nil
+	}
+	result := make([]string, l)
+	for i := range result {
+		if result[i], err = dec.DecodeString(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func encodeTime(v time.Time, enc codec.MsgPackEncoder) error {
+	if v.IsZero() {
+		return enc.EncodeInt(0)
+	}
+	return enc.EncodeInt(v.UnixMilli())
+}
+
+func decodeTime(dec codec.MsgPackDecoder) (time.Time, error) {
+	v, err := dec.DecodeInt64()
+	if err != nil || v == 0 {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(v), nil
+}
@@ -0,0 +1,160 @@
+//go:build unix
+
+package protocol
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	// terminalTerminateTimeout is the duration the process of a terminal has
+	// to end after it received SIGHUP before it will be killed.
+	terminalTerminateTimeout = 10 * time.Second
+)
+
+func startTerminalProcess(spec *TerminalStart, width, height uint16) (_ terminalProcess, rErr error) {
+	fail := func(err error) (terminalProcess, error) {
+		return nil, errors.System.Newf("cannot start terminal process %v: %w", spec.Argv, err)
+	}
+	failf := func(msg string, args ...any) (terminalProcess, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	if len(spec.Argv) == 0 {
+		return failf("no command provided")
+	}
+	path := spec.Path
+	if path == "" {
+		path = spec.Argv[0]
+	}
+	path, err := exec.LookPath(path)
+	if err != nil {
+		return fail(err)
+	}
+
+	cmd := exec.Command(path)
+	cmd.Args = spec.Argv
+	cmd.Dir = spec.Dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:  true,
+		Setctty: true,
+	}
+
+	ev := sys.EnvVars{}
+	ev.Add(os.Environ()...)
+	ev.AddAllOf(spec.Env)
+
+	var uid int
+	if spec.User != "" {
+		credential, home, err := sys.LookupCredential(spec.User, spec.Group)
+		if err != nil {
+			return fail(err)
+		}
+		cmd.SysProcAttr.Credential = credential
+		uid = int(credential.Uid)
+		if _, ok := spec.Env["HOME"]; !ok && home != "" {
+			ev.Set("HOME", home)
+		}
+	}
+	cmd.Env = ev.Strings()
+
+	fPty, fTty, err := pty.Open()
+	if err != nil {
+		return failf("cannot allocate pty: %w", err)
+	}
+	success := false
+	defer common.IgnoreCloseErrorIfFalse(&success, fPty)
+	defer common.IgnoreCloseError(fTty)
+
+	if spec.User != "" {
+		if err := fTty.Chown(uid, -1); err != nil {
+			return failf("cannot change owner of tty: %w", err)
+		}
+	}
+	if width > 0 && height > 0 {
+		if err := pty.Setsize(fPty, &pty.Winsize{Rows: height, Cols: width}); err != nil {
+			return failf("cannot set size of pty: %w", err)
+		}
+	}
+
+	cmd.Stdin = fTty
+	cmd.Stdout = fTty
+	cmd.Stderr = fTty
+	if err := cmd.Start(); err != nil {
+		return fail(err)
+	}
+
+	success = true
+	return &unixTerminalProcess{
+		cmd:  cmd,
+		pty:  fPty,
+		done: make(chan struct{}),
+	}, nil
+}
+
+type unixTerminalProcess struct {
+	cmd *exec.Cmd
+	pty *os.File
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (this *unixTerminalProcess) Read(p []byte) (int, error) {
+	return this.pty.Read(p)
+}
+
+func (this *unixTerminalProcess) Write(p []byte) (int, error) {
+	return this.pty.Write(p)
+}
+
+func (this *unixTerminalProcess) resize(width, height uint16) error {
+	return pty.Setsize(this.pty, &pty.Winsize{Rows: height, Cols: width})
+}
+
+func (this *unixTerminalProcess) signal(signal sys.Signal) error {
+	return signal.SendToProcess(this.cmd.Process)
+}
+
+func (this *unixTerminalProcess) terminate() {
+	// Negative pid: The whole process group (the process is the leader of
+	// its own session, see Setsid).
+	pgid := -this.cmd.Process.Pid
+	_ = syscall.Kill(pgid, syscall.SIGHUP)
+	go func() {
+		select {
+		case <-this.done:
+		case <-time.After(terminalTerminateTimeout):
+			_ = syscall.Kill(pgid, syscall.SIGKILL)
+		}
+	}()
+}
+
+func (this *unixTerminalProcess) wait() int {
+	defer close(this.done)
+	if err := this.cmd.Wait(); err != nil {
+		var eErr *exec.ExitError
+		if errors.As(err, &eErr) {
+			return eErr.ExitCode()
+		}
+		return -1
+	}
+	return 0
+}
+
+func (this *unixTerminalProcess) close() (rErr error) {
+	this.closeOnce.Do(func() {
+		rErr = this.pty.Close()
+	})
+	return rErr
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package protocol
+
+import (
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+func startTerminalProcess(*TerminalStart, uint16, uint16) (terminalProcess, error) {
+	return nil, errors.Config.Newf("persistent terminals are not supported on windows")
+}
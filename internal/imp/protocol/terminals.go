@@ -0,0 +1,320 @@
+package protocol
+
+import (
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/echocat/slf4g"
+
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	// terminalAttachmentBacklog is the amount of output chunks which can be
+	// queued for an attached connection before it is considered too slow and
+	// becomes detached.
+	terminalAttachmentBacklog = 1024
+
+	// terminalExitGracePeriod is the duration the output of a terminal is
+	// still read after its process has ended.
+	terminalExitGracePeriod = 2 * time.Second
+)
+
+// terminalProcess is the platform specific process of a terminal.
+type terminalProcess interface {
+	io.ReadWriter
+	resize(width, height uint16) error
+	signal(sys.Signal) error
+	// terminate asks the process (including its children) to end, and kills
+	// it if it does not end in time.
+	terminate()
+	wait() int
+	close() error
+}
+
+// terminals holds all terminals of an imp, which are running independently
+// of the connections attached to them.
+type terminals struct {
+	logger log.Logger
+
+	mutex  sync.Mutex
+	lastId TerminalId
+	byId   map[TerminalId]*terminal
+}
+
+func (this *terminals) list() []TerminalInfo {
+	this.mutex.Lock()
+	all := make([]*terminal, 0, len(this.byId))
+	for _, t := range this.byId {
+		all = append(all, t)
+	}
+	this.mutex.Unlock()
+
+	result := make([]TerminalInfo, len(all))
+	for i, t := range all {
+		result[i] = t.info()
+	}
+	slices.SortFunc(result, func(a, b TerminalInfo) int {
+		return int(a.Id) - int(b.Id)
+	})
+	return result
+}
+
+func (this *terminals) get(id TerminalId) *terminal {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.byId[id]
+}
+
+func (this *terminals) start(spec *TerminalStart, width, height uint16) (*terminal, error) {
+	process, err := startTerminalProcess(spec, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.lastId++
+	result := &terminal{
+		parent:      this,
+		id:          this.lastId,
+		command:     slices.Clone(spec.Argv),
+		started:     time.Now(),
+		idleTimeout: spec.IdleTimeout,
+		scrollback:  terminalScrollback{limit: int(spec.Scrollback)},
+		process:     process,
+		pumpDone:    make(chan struct{}),
+	}
+	// Until the first connection is attached, it is detached.
+	result.detached = result.started
+	if this.byId == nil {
+		this.byId = make(map[TerminalId]*terminal)
+	}
+	this.byId[result.id] = result
+
+	go result.pump()
+	go result.await()
+
+	result.logger().Info("terminal started")
+	return result, nil
+}
+
+func (this *terminals) remove(t *terminal) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.byId[t.id] == t {
+		delete(this.byId, t.id)
+	}
+}
+
+type terminal struct {
+	parent *terminals
+
+	id          TerminalId
+	command     []string
+	started     time.Time
+	idleTimeout time.Duration
+
+	process  terminalProcess
+	pumpDone chan struct{}
+
+	mutex      sync.Mutex
+	scrollback terminalScrollback
+	attachment *terminalAttachment
+	detached   time.Time
+	idleTimer  *time.Timer
+	exited     bool
+}
+
+func (this *terminal) info() TerminalInfo {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.infoGuarded()
+}
+
+func (this *terminal) infoGuarded() TerminalInfo {
+	return TerminalInfo{
+		Id:          this.id,
+		Command:     this.command,
+		Started:     this.started,
+		Attached:    this.attachment != nil,
+		Detached:    this.detached,
+		IdleTimeout: this.idleTimeout,
+	}
+}
+
+// attach attaches a new connection to this terminal. The output of the
+// scrollback is the first what will be received by the attachment. A
+// previously attached connection will be detached.
+func (this *terminal) attach() (*terminalAttachment, TerminalInfo, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.exited {
+		return nil, TerminalInfo{}, ErrNoSuchTerminal
+	}
+	if existing := this.attachment; existing != nil {
+		existing.end(terminalFrameDetached, 0)
+		this.logger().Info("terminal taken over by another connection")
+	}
+	if t := this.idleTimer; t != nil {
+		t.Stop()
+		this.idleTimer = nil
+	}
+
+	result := &terminalAttachment{
+		frames: make(chan terminalFrame, terminalAttachmentBacklog),
+	}
+	if sb := this.scrollback.bytes(); len(sb) > 0 {
+		result.frames <- terminalFrame{t: terminalFrameData, data: sb}
+	}
+	this.attachment = result
+	this.detached = time.Time{}
+
+	return result, this.infoGuarded(), nil
+}
+
+// detach detaches the given attachment, if it is still the attached one.
+func (this *terminal) detach(a *terminalAttachment) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.detachGuarded(a)
+}
+
+func (this *terminal) detachGuarded(a *terminalAttachment) {
+	if this.attachment != a || this.exited {
+		return
+	}
+	a.end(terminalFrameDetached, 0)
+	this.attachment = nil
+	this.detached = time.Now()
+	if d := this.idleTimeout; d > 0 {
+		this.idleTimer = time.AfterFunc(d, this.onIdleTimeout)
+	}
+	this.logger().Info("terminal detached")
+}
+
+func (this *terminal) onIdleTimeout() {
+	this.mutex.Lock()
+	idle := this.attachment == nil && !this.exited
+	this.mutex.Unlock()
+
+	if idle {
+		this.logger().Info("terminal was detached for too long; terminating...")
+		this.process.terminate()
+	}
+}
+
+// pump reads all output of the process, stores it into the scrollback and
+// sends it to the attached connection.
+func (this *terminal) pump() {
+	defer close(this.pumpDone)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := this.process.Read(buf)
+		if n > 0 {
+			this.onOutput(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (this *terminal) onOutput(p []byte) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.scrollback.write(p)
+	if a := this.attachment; a != nil {
+		select {
+		case a.frames <- terminalFrame{t: terminalFrameData, data: slices.Clone(p)}:
+		default:
+			this.logger().Warn("attached connection cannot keep up with the output of the terminal; detaching it...")
+			this.detachGuarded(a)
+		}
+	}
+}
+
+// await waits for the process to end and reports its exitCode to the
+// attached connection.
+func (this *terminal) await() {
+	exitCode := this.process.wait()
+
+	select {
+	case <-this.pumpDone:
+	case <-time.After(terminalExitGracePeriod):
+	}
+	_ = this.process.close()
+	<-this.pumpDone
+
+	this.parent.remove(this)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.exited = true
+	if t := this.idleTimer; t != nil {
+		t.Stop()
+		this.idleTimer = nil
+	}
+	if a := this.attachment; a != nil {
+		a.end(terminalFrameExit, exitCode)
+		this.attachment = nil
+	}
+	this.logger().
+		With("exitCode", exitCode).
+		Info("terminal ended")
+}
+
+func (this *terminal) logger() log.Logger {
+	return this.parent.logger.
+		With("terminal", this.id)
+}
+
+// terminalAttachment is a connection which is attached to a terminal. All
+// frames which should be sent to the connection are queued in frames. Once
+// the attachment has ended, frames is closed; the final frame (either
+// terminalFrameExit or terminalFrameDetached) is always its last element.
+type terminalAttachment struct {
+	frames chan terminalFrame
+	ended  bool
+}
+
+// end has to be called while holding the mutex of the corresponding terminal.
+func (this *terminalAttachment) end(t terminalFrameType, exitCode int) {
+	if this.ended {
+		return
+	}
+	this.ended = true
+	select {
+	case this.frames <- terminalFrame{t: t, exit: exitCode}:
+	default:
+		// Too slow anyway; the connection will just be closed.
+	}
+	close(this.frames)
+}
+
+// terminalScrollback keeps the most recent output of a terminal.
+type terminalScrollback struct {
+	limit int
+	data  []byte
+}
+
+func (this *terminalScrollback) write(p []byte) {
+	if this.limit <= 0 {
+		return
+	}
+	this.data = append(this.data, p...)
+	if l := len(this.data); l > this.limit {
+		this.data = this.data[l-this.limit:]
+	}
+}
+
+func (this *terminalScrollback) bytes() []byte {
+	return slices.Clone(this.data)
+}
@@ -0,0 +1,105 @@
+//go:build unix
+
+package protocol
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminals_detachAndReattach(t *testing.T) {
+	instance := &terminals{logger: log.GetLogger("test")}
+
+	term, err := instance.start(&TerminalStart{
+		Argv:       []string{"sh", "-c", `echo hello; read x; echo "got $x"; exit 3`},
+		Scrollback: 1024,
+	}, 80, 24)
+	require.NoError(t, err)
+
+	first, info, err := term.attach()
+	require.NoError(t, err)
+	require.Equal(t, term.id, info.Id)
+	require.True(t, info.Attached)
+	requireTerminalOutput(t, first, "hello")
+
+	second, _, err := term.attach()
+	require.NoError(t, err)
+	requireTerminalEnd(t, first, terminalFrameDetached, 0)
+	// Scrollback is replayed to the new attachment.
+	requireTerminalOutput(t, second, "hello")
+
+	term.detach(second)
+	requireTerminalEnd(t, second, terminalFrameDetached, 0)
+	require.Len(t, instance.list(), 1)
+	require.False(t, instance.list()[0].Attached)
+
+	third, _, err := term.attach()
+	require.NoError(t, err)
+	_, err = term.process.Write([]byte("foo\n"))
+	require.NoError(t, err)
+	requireTerminalOutput(t, third, "got foo")
+	requireTerminalEnd(t, third, terminalFrameExit, 3)
+
+	require.Empty(t, instance.list())
+	_, _, err = term.attach()
+	require.ErrorIs(t, err, ErrNoSuchTerminal)
+}
+
+func TestTerminals_idleTimeout(t *testing.T) {
+	instance := &terminals{logger: log.GetLogger("test")}
+
+	term, err := instance.start(&TerminalStart{
+		Argv:        []string{"sh", "-c", `sleep 60`},
+		IdleTimeout: 100 * time.Millisecond,
+	}, 80, 24)
+	require.NoError(t, err)
+
+	a, _, err := term.attach()
+	require.NoError(t, err)
+	term.detach(a)
+
+	require.Eventually(t, func() bool {
+		return len(instance.list()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func requireTerminalOutput(t *testing.T, a *terminalAttachment, expected string) {
+	t.Helper()
+	var buf bytes.Buffer
+	timeout := time.After(5 * time.Second)
+	for !bytes.Contains(buf.Bytes(), []byte(expected)) {
+		select {
+		case f, ok := <-a.frames:
+			require.True(t, ok, "attachment ended before %q was received; got: %q", expected, buf.String())
+			require.Equal(t, terminalFrameData, f.t)
+			buf.Write(f.data)
+		case <-timeout:
+			require.Failf(t, "timeout", "expected output %q; but got: %q", expected, buf.String())
+		}
+	}
+}
+
+func requireTerminalEnd(t *testing.T, a *terminalAttachment, expectedType terminalFrameType, expectedExitCode int) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case f, ok := <-a.frames:
+			require.True(t, ok, "attachment ended without final frame")
+			if f.t == terminalFrameData {
+				continue
+			}
+			require.Equal(t, expectedType, f.t)
+			require.Equal(t, expectedExitCode, f.exit)
+			_, ok = <-a.frames
+			require.False(t, ok)
+			return
+		case <-timeout:
+			require.Fail(t, "timeout while waiting for the end of the attachment")
+		}
+	}
+}
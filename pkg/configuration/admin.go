@@ -0,0 +1,231 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+// Admin defines the administrative HTTP API of the service. It provides a live
+// control plane for connections and sessions and is only served on its own
+// listener(s).
+type Admin struct {
+	// Addresses which the admin API will bind to. If empty, the admin API is disabled.
+	Addresses net.NetAddresses `yaml:"addresses,omitempty"`
+
+	// Tls defines the TLS settings of the admin API. If AdminTls.ClientCaFile is set,
+	// clients can be authenticated via their certificates (mTLS).
+	Tls AdminTls `yaml:"tls,omitempty"`
+
+	// Tokens are static bearer tokens which are accepted to authenticate
+	// requests to the admin API. As long as Tls is not enabled, tokens are
+	// only allowed if all Addresses are loopback addresses.
+	Tokens AdminTokens `yaml:"tokens,omitempty"`
+}
+
+func (this *Admin) SetDefaults() error {
+	return setDefaults(this,
+		noopSetDefault[Admin]("addresses"),
+		func(v *Admin) (string, defaulter) { return "tls", &v.Tls },
+		noopSetDefault[Admin]("tokens"),
+	)
+}
+
+func (this *Admin) Trim() error {
+	return trim(this,
+		func(v *Admin) (string, trimmer) { return "addresses", &v.Addresses },
+		func(v *Admin) (string, trimmer) { return "tls", &v.Tls },
+		func(v *Admin) (string, trimmer) { return "tokens", &v.Tokens },
+	)
+}
+
+func (this *Admin) Validate() error {
+	return validate(this,
+		func(v *Admin) (string, validator) { return "addresses", &v.Addresses },
+		func(v *Admin) (string, validator) { return "tls", &v.Tls },
+		func(v *Admin) (string, validator) { return "tokens", &v.Tokens },
+		func(v *Admin) (string, validator) {
+			return "", validatorFunc(func() error {
+				if v.IsEnabled() && len(v.Tokens) == 0 && v.Tls.ClientCaFile == "" {
+					return fmt.Errorf("[tokens] or [tls.clientCaFile] required if admin API is enabled")
+				}
+				return nil
+			})
+		},
+		func(v *Admin) (string, validator) {
+			return "tls", validatorFunc(func() error {
+				if len(v.Tokens) == 0 || v.Tls.IsEnabled() {
+					return nil
+				}
+				// Tokens would be transferred in plaintext; we only accept this
+				// if they cannot leave this host.
+				for _, addr := range v.Addresses {
+					if !addr.IsLoopback() {
+						return fmt.Errorf("[certificateFile] required if [tokens] are used with non-loopback address %v", addr)
+					}
+				}
+				return nil
+			})
+		},
+	)
+}
+
+func (this *Admin) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *Admin, node *yaml.Node) error {
+		type raw Admin
+		return node.Decode((*raw)(target))
+	})
+}
+
+// IsEnabled returns true if there is at least one address to bind the
+// admin API to.
+func (this Admin) IsEnabled() bool {
+	return len(this.Addresses) > 0
+}
+
+func (this Admin) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case Admin:
+		return this.isEqualTo(&v)
+	case *Admin:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this Admin) isEqualTo(other *Admin) bool {
+	return isEqual(&this.Addresses, &other.Addresses) &&
+		isEqual(&this.Tls, &other.Tls) &&
+		isEqual(&this.Tokens, &other.Tokens)
+}
+
+// AdminTls defines the TLS settings of Admin.
+type AdminTls struct {
+	// CertificateFile is the PEM encoded certificate (chain) of the admin API.
+	// If empty, the admin API will be served without TLS.
+	CertificateFile string `yaml:"certificateFile,omitempty"`
+
+	// PrivateKeyFile is the PEM encoded private key of CertificateFile.
+	PrivateKeyFile string `yaml:"privateKeyFile,omitempty"`
+
+	// ClientCaFile contains the PEM encoded certificate authorities which are
+	// used to verify client certificates. If set, clients presenting a valid
+	// certificate are authenticated.
+	ClientCaFile string `yaml:"clientCaFile,omitempty"`
+}
+
+func (this *AdminTls) SetDefaults() error {
+	return setDefaults(this,
+		noopSetDefault[AdminTls]("certificateFile"),
+		noopSetDefault[AdminTls]("privateKeyFile"),
+		noopSetDefault[AdminTls]("clientCaFile"),
+	)
+}
+
+func (this *AdminTls) Trim() error {
+	return trim(this,
+		func(v *AdminTls) (string, trimmer) { return "certificateFile", &stringTrimmer{&v.CertificateFile} },
+		func(v *AdminTls) (string, trimmer) { return "privateKeyFile", &stringTrimmer{&v.PrivateKeyFile} },
+		func(v *AdminTls) (string, trimmer) { return "clientCaFile", &stringTrimmer{&v.ClientCaFile} },
+	)
+}
+
+func (this *AdminTls) Validate() error {
+	return validate(this,
+		func(v *AdminTls) (string, validator) {
+			return "privateKeyFile", validatorFunc(func() error {
+				if (v.CertificateFile == "") != (v.PrivateKeyFile == "") {
+					return fmt.Errorf("required together with [certificateFile]")
+				}
+				return nil
+			})
+		},
+		func(v *AdminTls) (string, validator) {
+			return "clientCaFile", validatorFunc(func() error {
+				if v.ClientCaFile != "" && v.CertificateFile == "" {
+					return fmt.Errorf("requires [certificateFile] and [privateKeyFile]")
+				}
+				return nil
+			})
+		},
+	)
+}
+
+func (this *AdminTls) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *AdminTls, node *yaml.Node) error {
+		type raw AdminTls
+		return node.Decode((*raw)(target))
+	})
+}
+
+// IsEnabled returns true if a certificate is configured.
+func (this AdminTls) IsEnabled() bool {
+	return this.CertificateFile != ""
+}
+
+func (this AdminTls) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case AdminTls:
+		return this.isEqualTo(&v)
+	case *AdminTls:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this AdminTls) isEqualTo(other *AdminTls) bool {
+	return this.CertificateFile == other.CertificateFile &&
+		this.PrivateKeyFile == other.PrivateKeyFile &&
+		this.ClientCaFile == other.ClientCaFile
+}
+
+// AdminTokens is a set of static bearer tokens of Admin.
+type AdminTokens []string
+
+func (this *AdminTokens) Trim() error {
+	if this == nil {
+		return nil
+	}
+	for i, v := range *this {
+		(*this)[i] = strings.TrimSpace(v)
+	}
+	*this = slices.DeleteFunc(*this, func(v string) bool {
+		return v == ""
+	})
+	return nil
+}
+
+func (this AdminTokens) Validate() error {
+	for i, v := range this {
+		if len(v) < 16 {
+			return fmt.Errorf("[%d] token is too short; it has to be at least 16 characters long", i)
+		}
+	}
+	return nil
+}
+
+func (this AdminTokens) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case AdminTokens:
+		return slices.Equal(this, v)
+	case *AdminTokens:
+		return slices.Equal(this, *v)
+	default:
+		return false
+	}
+}
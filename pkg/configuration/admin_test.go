@@ -0,0 +1,58 @@
+package configuration
+
+import (
+	"testing"
+
+	"github.com/echocat/slf4g/sdk/testlog"
+
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+func TestAdmin_UnmarshalYAML(t *testing.T) {
+	testlog.Hook(t)
+
+	runUnmarshalYamlTests(t,
+		unmarshalYamlTestCase[Admin]{
+			name: "tokens-on-loopback",
+			yaml: `addresses: ["127.0.0.1:8022", "[::1]:8022"]
+tokens: ["0123456789abcdef"]`,
+			expected: Admin{
+				Addresses: net.NetAddresses{net.MustNewAddress("127.0.0.1:8022"), net.MustNewAddress("[::1]:8022")},
+				Tokens:    AdminTokens{"0123456789abcdef"},
+			},
+		},
+		unmarshalYamlTestCase[Admin]{
+			name: "tokens-on-all-interfaces",
+			yaml: `addresses: [":8022"]
+tokens: ["0123456789abcdef"]`,
+			expectedError: `[tls] [certificateFile] required if [tokens] are used with non-loopback address :8022`,
+		},
+		unmarshalYamlTestCase[Admin]{
+			name: "tokens-on-one-non-loopback",
+			yaml: `addresses: ["127.0.0.1:8022", "10.0.0.1:8022"]
+tokens: ["0123456789abcdef"]`,
+			expectedError: `[tls] [certificateFile] required if [tokens] are used with non-loopback address 10.0.0.1:8022`,
+		},
+		unmarshalYamlTestCase[Admin]{
+			name: "tokens-with-tls",
+			yaml: `addresses: [":8022"]
+tls:
+  certificateFile: admin.crt
+  privateKeyFile: admin.key
+tokens: ["0123456789abcdef"]`,
+			expected: Admin{
+				Addresses: net.NetAddresses{net.MustNewAddress(":8022")},
+				Tls: AdminTls{
+					CertificateFile: "admin.crt",
+					PrivateKeyFile:  "admin.key",
+				},
+				Tokens: AdminTokens{"0123456789abcdef"},
+			},
+		},
+		unmarshalYamlTestCase[Admin]{
+			name:          "without-authentication",
+			yaml:          `addresses: ["127.0.0.1:8022"]`,
+			expectedError: `[tokens] or [tls.clientCaFile] required if admin API is enabled`,
+		},
+	)
+}
@@ -31,6 +31,15 @@ type Configuration struct {
 
 	Alternatives Alternatives `yaml:"alternatives"`
 
+	// Admin defines the administrative HTTP API of the service. It is disabled by default.
+	Admin Admin `yaml:"admin,omitempty"`
+
+	// Tracing defines how traces are exported via OpenTelemetry. It is disabled by default.
+	Tracing Tracing `yaml:"tracing,omitempty"`
+
+	// Events defines where lifecycle events of the service are delivered to. It is disabled by default.
+	Events Events `yaml:"events,omitempty"`
+
 	StartMessage template.String `yaml:"startMessage,omitempty"`
 }
 
@@ -41,6 +50,9 @@ func (this *Configuration) SetDefaults() error {
 		func(v *Configuration) (string, defaulter) { return "flows", &v.Flows },
 		func(v *Configuration) (string, defaulter) { return "houseKeeping", &v.HouseKeeping },
 		func(v *Configuration) (string, defaulter) { return "alternatives", &v.Alternatives },
+		func(v *Configuration) (string, defaulter) { return "admin", &v.Admin },
+		func(v *Configuration) (string, defaulter) { return "tracing", &v.Tracing },
+		func(v *Configuration) (string, defaulter) { return "events", &v.Events },
 		fixedDefault("startMessage", func(v *Configuration) *template.String { return &v.StartMessage }, DefaultStartMessage),
 	)
 }
@@ -52,6 +64,9 @@ func (this *Configuration) Trim() error {
 		func(v *Configuration) (string, trimmer) { return "flows", &v.Flows },
 		func(v *Configuration) (string, trimmer) { return "houseKeeping", &v.HouseKeeping },
 		func(v *Configuration) (string, trimmer) { return "alternatives", &v.Alternatives },
+		func(v *Configuration) (string, trimmer) { return "admin", &v.Admin },
+		func(v *Configuration) (string, trimmer) { return "tracing", &v.Tracing },
+		func(v *Configuration) (string, trimmer) { return "events", &v.Events },
 		noopTrim[Configuration]("startMessage"),
 	)
 }
@@ -64,6 +79,9 @@ func (this *Configuration) Validate() error {
 		notZeroValidate("flows", func(v *Configuration) *Flows { return &v.Flows }),
 		func(v *Configuration) (string, validator) { return "houseKeeping", &v.HouseKeeping },
 		func(v *Configuration) (string, validator) { return "alternatives", &v.Alternatives },
+		func(v *Configuration) (string, validator) { return "admin", &v.Admin },
+		func(v *Configuration) (string, validator) { return "tracing", &v.Tracing },
+		func(v *Configuration) (string, validator) { return "events", &v.Events },
 		func(v *Configuration) (string, validator) { return "startMessage", &v.StartMessage },
 	)
 }
@@ -128,5 +146,8 @@ func (this Configuration) isEqualTo(other *Configuration) bool {
 		isEqual(&this.Flows, &other.Flows) &&
 		isEqual(&this.HouseKeeping, &other.HouseKeeping) &&
 		isEqual(&this.Alternatives, &other.Alternatives) &&
+		isEqual(&this.Admin, &other.Admin) &&
+		isEqual(&this.Tracing, &other.Tracing) &&
+		isEqual(&this.Events, &other.Events) &&
 		isEqual(&this.StartMessage, &other.StartMessage)
 }
@@ -59,8 +59,22 @@ func TestConfiguration_UnmarshalYAML(t *testing.T) {
 						Authentications: DefaultMessagesAuthentications,
 						Ciphers:         DefaultMessagesCiphers,
 					},
-					IdleTimeout:    DefaultSshIdleTimeout,
-					MaxTimeout:     DefaultSshMaxTimeout,
+					IdleTimeout: DefaultSshIdleTimeout,
+					MaxTimeout:  DefaultSshMaxTimeout,
+					Expiry: SshExpiry{
+						WarnBefore: DefaultSshExpiryWarnBefore,
+						Warning:    DefaultSshExpiryWarning,
+						Message:    DefaultSshExpiryMessage,
+						ExitCode:   DefaultSshExpiryExitCode,
+					},
+					Sharing: SshSharing{
+						Allowed:               DefaultSshSharingAllowed,
+						ReadWriteAllowed:      DefaultSshSharingReadWriteAllowed,
+						JoinAllowed:           DefaultSshSharingJoinAllowed,
+						JoinOtherFlowsAllowed: DefaultSshSharingJoinOtherFlowsAllowed,
+						ControlKey:            DefaultSshSharingControlKey,
+						MaxParticipants:       DefaultSshSharingMaxParticipants,
+					},
 					MaxAuthTries:   DefaultSshMaxAuthTries,
 					MaxConnections: DefaultSshMaxConnections,
 					Banner:         DefaultSshBanner,
@@ -114,6 +128,8 @@ func TestConfiguration_UnmarshalYAML(t *testing.T) {
 						},
 						Banner:                DefaultEnvironmentLocalBanner,
 						PortForwardingAllowed: DefaultEnvironmentLocalPortForwardingAllowed,
+						SocketForwardingPaths: DefaultEnvironmentLocalSocketForwardingPaths,
+						X11ForwardingAllowed:  DefaultEnvironmentLocalX11ForwardingAllowed,
 					}},
 				}},
 				Alternatives: Alternatives{
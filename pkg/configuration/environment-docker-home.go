@@ -0,0 +1,116 @@
+package configuration
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	// DefaultEnvironmentDockerHomeKey is the default setting for EnvironmentDockerHome.Key.
+	DefaultEnvironmentDockerHomeKey = template.MustNewString("")
+
+	// DefaultEnvironmentDockerHomeDirectory is the default setting for EnvironmentDockerHome.Directory.
+	DefaultEnvironmentDockerHomeDirectory = template.MustNewString("")
+
+	// DefaultEnvironmentDockerHomeDriver is the default setting for EnvironmentDockerHome.Driver.
+	DefaultEnvironmentDockerHomeDriver = template.MustNewString("")
+
+	// DefaultEnvironmentDockerHomeRetention is the default setting for EnvironmentDockerHome.Retention.
+	DefaultEnvironmentDockerHomeRetention = common.DurationOf(0)
+)
+
+// EnvironmentDockerHome defines a persistent home of a user inside of an
+// EnvironmentDocker. It is a named volume which survives the disposal of the
+// containers and is mounted in each container of the same user again.
+type EnvironmentDockerHome struct {
+	// Key identifies the owner of the home (like its email address). If
+	// empty (after being evaluated), no persistent home is used.
+	Key template.String `yaml:"key,omitempty"`
+
+	// Directory where the home is mounted inside the container. If empty,
+	// EnvironmentDocker.Directory is used.
+	Directory template.String `yaml:"directory,omitempty"`
+
+	// Driver is the volume driver which is used to create the volume. If
+	// empty, the default driver of the daemon is used.
+	Driver template.String `yaml:"driver,omitempty"`
+
+	// Retention defines for how long a home is kept after the last login of
+	// its owner. 0 means it is kept forever.
+	Retention common.Duration `yaml:"retention,omitempty"`
+
+	// StateDirectory is where the last logins of the owners of the homes are
+	// recorded, because volumes of Docker cannot be modified after their
+	// creation.
+	StateDirectory string `yaml:"stateDirectory,omitempty"`
+}
+
+func (this *EnvironmentDockerHome) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("key", func(v *EnvironmentDockerHome) *template.String { return &v.Key }, DefaultEnvironmentDockerHomeKey),
+		fixedDefault("directory", func(v *EnvironmentDockerHome) *template.String { return &v.Directory }, DefaultEnvironmentDockerHomeDirectory),
+		fixedDefault("driver", func(v *EnvironmentDockerHome) *template.String { return &v.Driver }, DefaultEnvironmentDockerHomeDriver),
+		fixedDefault("retention", func(v *EnvironmentDockerHome) *common.Duration { return &v.Retention }, DefaultEnvironmentDockerHomeRetention),
+		fixedDefault("stateDirectory", func(v *EnvironmentDockerHome) *string { return &v.StateDirectory }, DefaultEnvironmentDockerHomeStateDirectory),
+	)
+}
+
+func (this *EnvironmentDockerHome) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentDockerHome]("key"),
+		noopTrim[EnvironmentDockerHome]("directory"),
+		noopTrim[EnvironmentDockerHome]("driver"),
+		noopTrim[EnvironmentDockerHome]("retention"),
+		noopTrim[EnvironmentDockerHome]("stateDirectory"),
+	)
+}
+
+func (this *EnvironmentDockerHome) Validate() error {
+	return validate(this,
+		func(v *EnvironmentDockerHome) (string, validator) { return "key", &v.Key },
+		func(v *EnvironmentDockerHome) (string, validator) { return "directory", &v.Directory },
+		func(v *EnvironmentDockerHome) (string, validator) { return "driver", &v.Driver },
+		func(v *EnvironmentDockerHome) (string, validator) {
+			return "retention", validatorFunc(func() error {
+				if v.Retention.Native() < 0 {
+					return fmt.Errorf("cannot be negative, but got: %v", v.Retention)
+				}
+				return nil
+			})
+		},
+		noopValidate[EnvironmentDockerHome]("stateDirectory"),
+	)
+}
+
+func (this *EnvironmentDockerHome) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentDockerHome, node *yaml.Node) error {
+		type raw EnvironmentDockerHome
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentDockerHome) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentDockerHome:
+		return this.isEqualTo(&v)
+	case *EnvironmentDockerHome:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentDockerHome) isEqualTo(other *EnvironmentDockerHome) bool {
+	return isEqual(&this.Key, &other.Key) &&
+		isEqual(&this.Directory, &other.Directory) &&
+		isEqual(&this.Driver, &other.Driver) &&
+		isEqual(&this.Retention, &other.Retention) &&
+		this.StateDirectory == other.StateDirectory
+}
@@ -0,0 +1,7 @@
+//go:build unix
+
+package configuration
+
+const (
+	DefaultEnvironmentDockerHomeStateDirectory = "/var/lib/engity/bifroest/homes"
+)
@@ -0,0 +1,7 @@
+//go:build windows
+
+package configuration
+
+const (
+	DefaultEnvironmentDockerHomeStateDirectory = `C:\ProgramData\Engity\Bifroest\homes`
+)
@@ -0,0 +1,123 @@
+package configuration
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+var (
+	// DefaultEnvironmentDockerPoolSize is the default setting for EnvironmentDockerPool.Size.
+	DefaultEnvironmentDockerPoolSize uint32 = 0
+)
+
+// EnvironmentDockerPool defines a warm pool of containers of an
+// EnvironmentDocker, which are created before they are requested by any
+// session. On login, a container of the pool is claimed by the session,
+// instead of creating a new one.
+type EnvironmentDockerPool struct {
+	// Size is the amount of unclaimed containers which are kept ready. 0
+	// disables the pool.
+	Size uint32 `yaml:"size,omitempty"`
+}
+
+func (this *EnvironmentDockerPool) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("size", func(v *EnvironmentDockerPool) *uint32 { return &v.Size }, DefaultEnvironmentDockerPoolSize),
+	)
+}
+
+func (this *EnvironmentDockerPool) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentDockerPool]("size"),
+	)
+}
+
+func (this *EnvironmentDockerPool) Validate() error {
+	return validate(this,
+		noopValidate[EnvironmentDockerPool]("size"),
+	)
+}
+
+func (this *EnvironmentDockerPool) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentDockerPool, node *yaml.Node) error {
+		type raw EnvironmentDockerPool
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentDockerPool) IsEnabled() bool {
+	return this.Size > 0
+}
+
+func (this EnvironmentDockerPool) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentDockerPool:
+		return this.isEqualTo(&v)
+	case *EnvironmentDockerPool:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentDockerPool) isEqualTo(other *EnvironmentDockerPool) bool {
+	return this.Size == other.Size
+}
+
+// validatePool ensures that all templates which are used to create the
+// containers of the pool do not depend on any per-user context.
+func (this *EnvironmentDocker) validatePool() error {
+	if !this.Pool.IsEnabled() {
+		return nil
+	}
+
+	for _, err := range []error{
+		validateContextIndependent("host", this.Host),
+		validateContextIndependent("apiVersion", this.ApiVersion),
+		validateContextIndependent("certPath", this.CertPath),
+		validateContextIndependent("tlsVerify", this.TlsVerify),
+		validateContextIndependent("image", this.Image),
+		validateContextIndependent("imagePullCredentials", this.ImagePullCredentials),
+		validateContextIndependent("network", this.Networks),
+		validateContextIndependent("volumes", this.Volumes),
+		validateContextIndependent("mounts", this.Mounts),
+		validateContextIndependent("capabilities", this.Capabilities),
+		validateContextIndependent("privileged", this.Privileged),
+		validateContextIndependent("dnsServers", this.DnsServers),
+		validateContextIndependent("dnsSearch", this.DnsSearch),
+		validateContextIndependent("memory", this.Memory),
+		validateContextIndependent("cpus", this.Cpus),
+		validateContextIndependent("pidsLimit", this.PidsLimit),
+		validateContextIndependent("ulimits", this.Ulimits),
+		validateContextIndependent("env", this.Env),
+		validateContextIndependent("labels", this.Labels),
+		validateContextIndependent("readOnlyRootfs", this.ReadOnlyRootfs),
+		validateContextIndependent("tmpfs", this.Tmpfs),
+		validateContextIndependent("securityOptions", this.SecurityOptions),
+		validateContextIndependent("init", this.Init),
+		validateContextIndependent("deviceRequests", this.DeviceRequests),
+		validateContextIndependent("shellCommand", this.ShellCommand),
+		validateContextIndependent("execCommand", this.ExecCommand),
+		validateContextIndependent("sftpCommand", this.SftpCommand),
+		validateContextIndependent("directory", this.Directory),
+		validateContextIndependent("user", this.User),
+		validateContextIndependent("portForwardingAllowed", this.PortForwardingAllowed),
+		validateContextIndependent("socketForwardingPaths", this.SocketForwardingPaths),
+		validateContextIndependent("x11ForwardingAllowed", this.X11ForwardingAllowed),
+		validateContextIndependent("terminals.persistent", this.Terminals.Persistent),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	if !this.Home.Key.IsZero() {
+		return errors.Config.Newf("cannot be combined with [home.key], because a container of the pool does not belong to any user before it is claimed")
+	}
+
+	return nil
+}
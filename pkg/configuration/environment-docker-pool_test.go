@@ -0,0 +1,58 @@
+package configuration
+
+import (
+	"testing"
+
+	"github.com/echocat/slf4g/sdk/testlog"
+)
+
+func TestEnvironmentDocker_UnmarshalYAML_pool(t *testing.T) {
+	testlog.Hook(t)
+
+	runUnmarshalYamlTests(t,
+		unmarshalYamlTestCase[EnvironmentDocker]{
+			name: "session-dependent-image",
+			yaml: `image: "registry/{{.session.id}}"
+pool:
+  size: 2`,
+			expectedError: `[pool] [image] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentDocker]{
+			name: "session-dependent-image-in-branch",
+			yaml: `image: "{{ if env ` + "`FOO`" + ` }}registry/{{.session.id}}{{ else }}alpine{{ end }}"
+pool:
+  size: 2`,
+			expectedError: `[pool] [image] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentDocker]{
+			name: "user-dependent-env",
+			yaml: `env: ["USER_NAME={{.authorization.user.name}}"]
+pool:
+  size: 2`,
+			expectedError: `[pool] [env] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentDocker]{
+			name: "user-dependent-memory",
+			yaml: `memory: "{{ if eq .authorization.user.name ` + "`foo`" + ` }}2g{{ else }}512m{{ end }}"
+pool:
+  size: 2`,
+			expectedError: `[pool] [memory] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentDocker]{
+			name: "user-dependent-terminals",
+			yaml: `terminals:
+  persistent: "{{ eq .authorization.user.name ` + "`foo`" + ` }}"
+pool:
+  size: 2`,
+			expectedError: `[pool] [terminals.persistent] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentDocker]{
+			name: "user-dependent-home",
+			yaml: `home:
+  key: "{{.authorization.user.name}}"
+pool:
+  size: 2`,
+			expectedError: `[pool] cannot be combined with [home.key]`,
+		},
+	)
+}
@@ -26,6 +26,17 @@ var (
 	DefaultEnvironmentDockerPrivileged           = template.BoolOf(false)
 	DefaultEnvironmentDockerDnsServers           = template.MustNewStrings()
 	DefaultEnvironmentDockerDnsSearch            = template.MustNewStrings()
+	DefaultEnvironmentDockerMemory               = template.MustNewString("")
+	DefaultEnvironmentDockerCpus                 = template.MustNewString("")
+	DefaultEnvironmentDockerPidsLimit            = template.Int64Of(0)
+	DefaultEnvironmentDockerUlimits              = template.MustNewStrings()
+	DefaultEnvironmentDockerEnv                  = template.MustNewStrings()
+	DefaultEnvironmentDockerLabels               = template.MustNewStrings()
+	DefaultEnvironmentDockerReadOnlyRootfs       = template.BoolOf(false)
+	DefaultEnvironmentDockerTmpfs                = template.MustNewStrings()
+	DefaultEnvironmentDockerSecurityOptions      = template.MustNewStrings()
+	DefaultEnvironmentDockerInit                 = template.BoolOf(false)
+	DefaultEnvironmentDockerDeviceRequests       = template.MustNewStrings()
 	DefaultEnvironmentDockerShellCommand         = template.MustNewStrings()
 	DefaultEnvironmentDockerExecCommand          = template.MustNewStrings()
 	DefaultEnvironmentDockerSftpCommand          = template.MustNewStrings()
@@ -34,6 +45,8 @@ var (
 
 	DefaultEnvironmentDockerBanner                = template.MustNewString("")
 	DefaultEnvironmentDockerPortForwardingAllowed = template.BoolOf(true)
+	DefaultEnvironmentDockerSocketForwardingPaths = template.MustNewStrings()
+	DefaultEnvironmentDockerX11ForwardingAllowed  = template.BoolOf(false)
 	DefaultEnvironmentDockerImpPublishHost        = net.MustNewHost("")
 
 	DefaultEnvironmentDockerCleanOrphan = template.BoolOf(true)
@@ -61,6 +74,34 @@ type EnvironmentDocker struct {
 	Privileged           template.Bool    `yaml:"privileged,omitempty"`
 	DnsServers           template.Strings `yaml:"dnsServers,omitempty"`
 	DnsSearch            template.Strings `yaml:"dnsSearch,omitempty"`
+	// Memory limit of the container, like 512m or 2g.
+	Memory template.String `yaml:"memory,omitempty"`
+	// Cpus defines how many CPUs the container can use, like 1.5.
+	Cpus template.String `yaml:"cpus,omitempty"`
+	// PidsLimit is the maximum number of processes inside the container.
+	// 0 means unlimited.
+	PidsLimit template.Int64 `yaml:"pidsLimit,omitempty"`
+	// Ulimits in the format <name>=<soft>[:<hard>], like nofile=1024:2048.
+	Ulimits template.Strings `yaml:"ulimits,omitempty"`
+	// Env are additional environment variables in the format <key>=<value>.
+	Env template.Strings `yaml:"env,omitempty"`
+	// Labels are additional labels of the container in the format <key>=<value>.
+	Labels template.Strings `yaml:"labels,omitempty"`
+	// ReadOnlyRootfs mounts the root filesystem of the container read-only,
+	// if true. Defaults to false.
+	ReadOnlyRootfs template.Bool `yaml:"readOnlyRootfs,omitempty"`
+	// Tmpfs mounts in the format <path>[:<options>], like /tmp:size=64m.
+	Tmpfs template.Strings `yaml:"tmpfs,omitempty"`
+	// SecurityOptions in the same format as the --security-opt flag of
+	// docker run, like no-new-privileges or seccomp=<profile>.
+	SecurityOptions template.Strings `yaml:"securityOptions,omitempty"`
+	// Init runs an init process inside the container (like docker run
+	// --init), which forwards signals and reaps processes, if true. Defaults
+	// to false.
+	Init template.Bool `yaml:"init,omitempty"`
+	// DeviceRequests in the same format as the --gpus flag of docker run,
+	// like all or count=2,capabilities=gpu.
+	DeviceRequests template.Strings `yaml:"deviceRequests,omitempty"`
 
 	ShellCommand template.Strings `yaml:"shellCommand,omitempty"`
 	ExecCommand  template.Strings `yaml:"execCommand,omitempty"`
@@ -70,8 +111,14 @@ type EnvironmentDocker struct {
 
 	Banner template.String `yaml:"banner,omitempty"`
 
-	PortForwardingAllowed template.Bool `yaml:"portForwardingAllowed,omitempty"`
-	ImpPublishHost        net.Host      `yaml:"impPublishHost,omitempty"`
+	PortForwardingAllowed template.Bool    `yaml:"portForwardingAllowed,omitempty"`
+	SocketForwardingPaths template.Strings `yaml:"socketForwardingPaths,omitempty"`
+	X11ForwardingAllowed  template.Bool    `yaml:"x11ForwardingAllowed,omitempty"`
+	ImpPublishHost        net.Host         `yaml:"impPublishHost,omitempty"`
+
+	Terminals EnvironmentTerminals  `yaml:"terminals,omitempty"`
+	Home      EnvironmentDockerHome `yaml:"home,omitempty"`
+	Pool      EnvironmentDockerPool `yaml:"pool,omitempty"`
 
 	CleanOrphan template.Bool `yaml:"cleanOrphan,omitempty"`
 }
@@ -95,6 +142,17 @@ func (this *EnvironmentDocker) SetDefaults() error {
 		fixedDefault("privileged", func(v *EnvironmentDocker) *template.Bool { return &v.Privileged }, DefaultEnvironmentDockerPrivileged),
 		fixedDefault("dnsServers", func(v *EnvironmentDocker) *template.Strings { return &v.DnsServers }, DefaultEnvironmentDockerDnsServers),
 		fixedDefault("dnsSearch", func(v *EnvironmentDocker) *template.Strings { return &v.DnsSearch }, DefaultEnvironmentDockerDnsSearch),
+		fixedDefault("memory", func(v *EnvironmentDocker) *template.String { return &v.Memory }, DefaultEnvironmentDockerMemory),
+		fixedDefault("cpus", func(v *EnvironmentDocker) *template.String { return &v.Cpus }, DefaultEnvironmentDockerCpus),
+		fixedDefault("pidsLimit", func(v *EnvironmentDocker) *template.Int64 { return &v.PidsLimit }, DefaultEnvironmentDockerPidsLimit),
+		fixedDefault("ulimits", func(v *EnvironmentDocker) *template.Strings { return &v.Ulimits }, DefaultEnvironmentDockerUlimits),
+		fixedDefault("env", func(v *EnvironmentDocker) *template.Strings { return &v.Env }, DefaultEnvironmentDockerEnv),
+		fixedDefault("labels", func(v *EnvironmentDocker) *template.Strings { return &v.Labels }, DefaultEnvironmentDockerLabels),
+		fixedDefault("readOnlyRootfs", func(v *EnvironmentDocker) *template.Bool { return &v.ReadOnlyRootfs }, DefaultEnvironmentDockerReadOnlyRootfs),
+		fixedDefault("tmpfs", func(v *EnvironmentDocker) *template.Strings { return &v.Tmpfs }, DefaultEnvironmentDockerTmpfs),
+		fixedDefault("securityOptions", func(v *EnvironmentDocker) *template.Strings { return &v.SecurityOptions }, DefaultEnvironmentDockerSecurityOptions),
+		fixedDefault("init", func(v *EnvironmentDocker) *template.Bool { return &v.Init }, DefaultEnvironmentDockerInit),
+		fixedDefault("deviceRequests", func(v *EnvironmentDocker) *template.Strings { return &v.DeviceRequests }, DefaultEnvironmentDockerDeviceRequests),
 
 		fixedDefault("shellCommand", func(v *EnvironmentDocker) *template.Strings { return &v.ShellCommand }, DefaultEnvironmentDockerShellCommand),
 		fixedDefault("execCommand", func(v *EnvironmentDocker) *template.Strings { return &v.ExecCommand }, DefaultEnvironmentDockerExecCommand),
@@ -105,6 +163,11 @@ func (this *EnvironmentDocker) SetDefaults() error {
 		fixedDefault("banner", func(v *EnvironmentDocker) *template.String { return &v.Banner }, DefaultEnvironmentDockerBanner),
 
 		fixedDefault("portForwardingAllowed", func(v *EnvironmentDocker) *template.Bool { return &v.PortForwardingAllowed }, DefaultEnvironmentDockerPortForwardingAllowed),
+		fixedDefault("socketForwardingPaths", func(v *EnvironmentDocker) *template.Strings { return &v.SocketForwardingPaths }, DefaultEnvironmentDockerSocketForwardingPaths),
+		fixedDefault("x11ForwardingAllowed", func(v *EnvironmentDocker) *template.Bool { return &v.X11ForwardingAllowed }, DefaultEnvironmentDockerX11ForwardingAllowed),
+		func(v *EnvironmentDocker) (string, defaulter) { return "terminals", &v.Terminals },
+		func(v *EnvironmentDocker) (string, defaulter) { return "home", &v.Home },
+		func(v *EnvironmentDocker) (string, defaulter) { return "pool", &v.Pool },
 		fixedDefault("impPublishHost", func(v *EnvironmentDocker) *net.Host { return &v.ImpPublishHost }, DefaultEnvironmentDockerImpPublishHost),
 
 		fixedDefault("cleanOrphan", func(v *EnvironmentDocker) *template.Bool { return &v.CleanOrphan }, DefaultEnvironmentDockerCleanOrphan),
@@ -131,6 +194,17 @@ func (this *EnvironmentDocker) Trim() error {
 		noopTrim[EnvironmentDocker]("privileged"),
 		noopTrim[EnvironmentDocker]("dnsServers"),
 		noopTrim[EnvironmentDocker]("dnsSearch"),
+		noopTrim[EnvironmentDocker]("memory"),
+		noopTrim[EnvironmentDocker]("cpus"),
+		noopTrim[EnvironmentDocker]("pidsLimit"),
+		noopTrim[EnvironmentDocker]("ulimits"),
+		noopTrim[EnvironmentDocker]("env"),
+		noopTrim[EnvironmentDocker]("labels"),
+		noopTrim[EnvironmentDocker]("readOnlyRootfs"),
+		noopTrim[EnvironmentDocker]("tmpfs"),
+		noopTrim[EnvironmentDocker]("securityOptions"),
+		noopTrim[EnvironmentDocker]("init"),
+		noopTrim[EnvironmentDocker]("deviceRequests"),
 		noopTrim[EnvironmentDocker]("shellCommand"),
 		noopTrim[EnvironmentDocker]("execCommand"),
 		noopTrim[EnvironmentDocker]("sftpCommand"),
@@ -140,6 +214,11 @@ func (this *EnvironmentDocker) Trim() error {
 		noopTrim[EnvironmentDocker]("banner"),
 
 		noopTrim[EnvironmentDocker]("portForwardingAllowed"),
+		noopTrim[EnvironmentDocker]("socketForwardingPaths"),
+		noopTrim[EnvironmentDocker]("x11ForwardingAllowed"),
+		func(v *EnvironmentDocker) (string, trimmer) { return "terminals", &v.Terminals },
+		func(v *EnvironmentDocker) (string, trimmer) { return "home", &v.Home },
+		func(v *EnvironmentDocker) (string, trimmer) { return "pool", &v.Pool },
 
 		noopTrim[EnvironmentDocker]("impPublishHost"),
 
@@ -168,6 +247,17 @@ func (this *EnvironmentDocker) Validate() error {
 		func(v *EnvironmentDocker) (string, validator) { return "privileged", &v.Privileged },
 		func(v *EnvironmentDocker) (string, validator) { return "dnsServers", &v.DnsServers },
 		func(v *EnvironmentDocker) (string, validator) { return "dnsSearch", &v.DnsSearch },
+		func(v *EnvironmentDocker) (string, validator) { return "memory", &v.Memory },
+		func(v *EnvironmentDocker) (string, validator) { return "cpus", &v.Cpus },
+		func(v *EnvironmentDocker) (string, validator) { return "pidsLimit", &v.PidsLimit },
+		func(v *EnvironmentDocker) (string, validator) { return "ulimits", &v.Ulimits },
+		func(v *EnvironmentDocker) (string, validator) { return "env", &v.Env },
+		func(v *EnvironmentDocker) (string, validator) { return "labels", &v.Labels },
+		func(v *EnvironmentDocker) (string, validator) { return "readOnlyRootfs", &v.ReadOnlyRootfs },
+		func(v *EnvironmentDocker) (string, validator) { return "tmpfs", &v.Tmpfs },
+		func(v *EnvironmentDocker) (string, validator) { return "securityOptions", &v.SecurityOptions },
+		func(v *EnvironmentDocker) (string, validator) { return "init", &v.Init },
+		func(v *EnvironmentDocker) (string, validator) { return "deviceRequests", &v.DeviceRequests },
 		func(v *EnvironmentDocker) (string, validator) { return "shellCommand", &v.ShellCommand },
 		func(v *EnvironmentDocker) (string, validator) { return "execCommand", &v.ExecCommand },
 		func(v *EnvironmentDocker) (string, validator) { return "sftpCommand", &v.SftpCommand },
@@ -179,6 +269,18 @@ func (this *EnvironmentDocker) Validate() error {
 		func(v *EnvironmentDocker) (string, validator) {
 			return "portForwardingAllowed", &v.PortForwardingAllowed
 		},
+		func(v *EnvironmentDocker) (string, validator) {
+			return "socketForwardingPaths", &v.SocketForwardingPaths
+		},
+		func(v *EnvironmentDocker) (string, validator) {
+			return "x11ForwardingAllowed", &v.X11ForwardingAllowed
+		},
+		func(v *EnvironmentDocker) (string, validator) { return "terminals", &v.Terminals },
+		func(v *EnvironmentDocker) (string, validator) { return "home", &v.Home },
+		func(v *EnvironmentDocker) (string, validator) { return "pool", &v.Pool },
+		func(v *EnvironmentDocker) (string, validator) {
+			return "pool", validatorFunc(v.validatePool)
+		},
 
 		func(v *EnvironmentDocker) (string, validator) { return "impPublishHost", &v.ImpPublishHost },
 
@@ -223,6 +325,17 @@ func (this EnvironmentDocker) isEqualTo(other *EnvironmentDocker) bool {
 		isEqual(&this.Privileged, &other.Privileged) &&
 		isEqual(&this.DnsServers, &other.DnsServers) &&
 		isEqual(&this.DnsSearch, &other.DnsSearch) &&
+		isEqual(&this.Memory, &other.Memory) &&
+		isEqual(&this.Cpus, &other.Cpus) &&
+		isEqual(&this.PidsLimit, &other.PidsLimit) &&
+		isEqual(&this.Ulimits, &other.Ulimits) &&
+		isEqual(&this.Env, &other.Env) &&
+		isEqual(&this.Labels, &other.Labels) &&
+		isEqual(&this.ReadOnlyRootfs, &other.ReadOnlyRootfs) &&
+		isEqual(&this.Tmpfs, &other.Tmpfs) &&
+		isEqual(&this.SecurityOptions, &other.SecurityOptions) &&
+		isEqual(&this.Init, &other.Init) &&
+		isEqual(&this.DeviceRequests, &other.DeviceRequests) &&
 		isEqual(&this.ShellCommand, &other.ShellCommand) &&
 		isEqual(&this.ExecCommand, &other.ExecCommand) &&
 		isEqual(&this.SftpCommand, &other.SftpCommand) &&
@@ -230,6 +343,11 @@ func (this EnvironmentDocker) isEqualTo(other *EnvironmentDocker) bool {
 		isEqual(&this.User, &other.User) &&
 		isEqual(&this.Banner, &other.Banner) &&
 		isEqual(&this.PortForwardingAllowed, &other.PortForwardingAllowed) &&
+		isEqual(&this.SocketForwardingPaths, &other.SocketForwardingPaths) &&
+		isEqual(&this.X11ForwardingAllowed, &other.X11ForwardingAllowed) &&
+		isEqual(&this.Terminals, &other.Terminals) &&
+		isEqual(&this.Home, &other.Home) &&
+		isEqual(&this.Pool, &other.Pool) &&
 		isEqual(&this.ImpPublishHost, &other.ImpPublishHost) &&
 		isEqual(&this.CleanOrphan, &other.CleanOrphan)
 }
@@ -0,0 +1,199 @@
+package configuration
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/kubernetes"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	DefaultEnvironmentKubernetesAttachLoginAllowed = template.BoolOf(true)
+
+	DefaultEnvironmentKubernetesAttachConfig  = template.MustNewTextMarshaller[kubernetes.Kubeconfig, *kubernetes.Kubeconfig]("")
+	DefaultEnvironmentKubernetesAttachContext = template.MustNewString("")
+
+	DefaultEnvironmentKubernetesAttachNamespace = template.MustNewString("")
+	DefaultEnvironmentKubernetesAttachPod       = template.MustNewString("")
+	DefaultEnvironmentKubernetesAttachSelector  = template.MustNewString("")
+	DefaultEnvironmentKubernetesAttachOwner     = template.MustNewString("")
+	DefaultEnvironmentKubernetesAttachContainer = template.MustNewString("")
+
+	DefaultEnvironmentKubernetesAttachShellCommand = template.MustNewStrings("/bin/sh")
+	DefaultEnvironmentKubernetesAttachExecCommand  = template.MustNewStrings("/bin/sh", "-c")
+	DefaultEnvironmentKubernetesAttachSftpCommand  = template.MustNewStrings()
+
+	DefaultEnvironmentKubernetesAttachBanner                = template.MustNewString("")
+	DefaultEnvironmentKubernetesAttachPortForwardingAllowed = template.BoolOf(true)
+
+	_ = RegisterEnvironmentV(func() EnvironmentV {
+		return &EnvironmentKubernetesAttach{}
+	})
+)
+
+// EnvironmentKubernetesAttach attaches each session to an existing POD
+// inside a Kubernetes cluster instead of creating a new one. The target POD
+// is never modified or removed by Bifröst.
+type EnvironmentKubernetesAttach struct {
+	LoginAllowed template.Bool `yaml:"loginAllowed,omitempty"`
+
+	Config  template.TextMarshaller[kubernetes.Kubeconfig, *kubernetes.Kubeconfig] `yaml:"config,omitempty"`
+	Context template.String                                                        `yaml:"context,omitempty"`
+
+	Namespace template.String `yaml:"namespace,omitempty"`
+	// Pod selects the target POD by its name. Only one of Pod, Selector and
+	// Owner can be used at the same time.
+	Pod template.String `yaml:"pod,omitempty"`
+	// Selector selects the target POD by a label selector.
+	Selector template.String `yaml:"selector,omitempty"`
+	// Owner selects the target POD by the workload it belongs to, in the
+	// format <kind>/<name> (like deployment/foo or statefulset/bar).
+	Owner template.String `yaml:"owner,omitempty"`
+	// Container inside the target POD. If empty, the default container of
+	// the POD is used.
+	Container template.String `yaml:"container,omitempty"`
+
+	ShellCommand template.Strings `yaml:"shellCommand,omitempty"`
+	ExecCommand  template.Strings `yaml:"execCommand,omitempty"`
+	SftpCommand  template.Strings `yaml:"sftpCommand,omitempty"`
+
+	Banner template.String `yaml:"banner,omitempty"`
+
+	PortForwardingAllowed template.Bool `yaml:"portForwardingAllowed,omitempty"`
+}
+
+func (this *EnvironmentKubernetesAttach) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("loginAllowed", func(v *EnvironmentKubernetesAttach) *template.Bool { return &v.LoginAllowed }, DefaultEnvironmentKubernetesAttachLoginAllowed),
+
+		fixedDefault("config", func(v *EnvironmentKubernetesAttach) *template.TextMarshaller[kubernetes.Kubeconfig, *kubernetes.Kubeconfig] {
+			return &v.Config
+		}, DefaultEnvironmentKubernetesAttachConfig),
+		fixedDefault("context", func(v *EnvironmentKubernetesAttach) *template.String { return &v.Context }, DefaultEnvironmentKubernetesAttachContext),
+
+		fixedDefault("namespace", func(v *EnvironmentKubernetesAttach) *template.String { return &v.Namespace }, DefaultEnvironmentKubernetesAttachNamespace),
+		fixedDefault("pod", func(v *EnvironmentKubernetesAttach) *template.String { return &v.Pod }, DefaultEnvironmentKubernetesAttachPod),
+		fixedDefault("selector", func(v *EnvironmentKubernetesAttach) *template.String { return &v.Selector }, DefaultEnvironmentKubernetesAttachSelector),
+		fixedDefault("owner", func(v *EnvironmentKubernetesAttach) *template.String { return &v.Owner }, DefaultEnvironmentKubernetesAttachOwner),
+		fixedDefault("container", func(v *EnvironmentKubernetesAttach) *template.String { return &v.Container }, DefaultEnvironmentKubernetesAttachContainer),
+
+		fixedDefault("shellCommand", func(v *EnvironmentKubernetesAttach) *template.Strings { return &v.ShellCommand }, DefaultEnvironmentKubernetesAttachShellCommand),
+		fixedDefault("execCommand", func(v *EnvironmentKubernetesAttach) *template.Strings { return &v.ExecCommand }, DefaultEnvironmentKubernetesAttachExecCommand),
+		fixedDefault("sftpCommand", func(v *EnvironmentKubernetesAttach) *template.Strings { return &v.SftpCommand }, DefaultEnvironmentKubernetesAttachSftpCommand),
+
+		fixedDefault("banner", func(v *EnvironmentKubernetesAttach) *template.String { return &v.Banner }, DefaultEnvironmentKubernetesAttachBanner),
+
+		fixedDefault("portForwardingAllowed", func(v *EnvironmentKubernetesAttach) *template.Bool { return &v.PortForwardingAllowed }, DefaultEnvironmentKubernetesAttachPortForwardingAllowed),
+	)
+}
+
+func (this *EnvironmentKubernetesAttach) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentKubernetesAttach]("loginAllowed"),
+
+		noopTrim[EnvironmentKubernetesAttach]("config"),
+		noopTrim[EnvironmentKubernetesAttach]("context"),
+
+		noopTrim[EnvironmentKubernetesAttach]("namespace"),
+		noopTrim[EnvironmentKubernetesAttach]("pod"),
+		noopTrim[EnvironmentKubernetesAttach]("selector"),
+		noopTrim[EnvironmentKubernetesAttach]("owner"),
+		noopTrim[EnvironmentKubernetesAttach]("container"),
+
+		noopTrim[EnvironmentKubernetesAttach]("shellCommand"),
+		noopTrim[EnvironmentKubernetesAttach]("execCommand"),
+		noopTrim[EnvironmentKubernetesAttach]("sftpCommand"),
+
+		noopTrim[EnvironmentKubernetesAttach]("banner"),
+
+		noopTrim[EnvironmentKubernetesAttach]("portForwardingAllowed"),
+	)
+}
+
+func (this *EnvironmentKubernetesAttach) Validate() error {
+	return validate(this,
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "loginAllowed", &v.LoginAllowed },
+
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "config", &v.Config },
+		noopValidate[EnvironmentKubernetesAttach]("context"),
+
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "namespace", &v.Namespace },
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "pod", &v.Pod },
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "selector", &v.Selector },
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "owner", &v.Owner },
+		func(v *EnvironmentKubernetesAttach) (string, validator) {
+			return "pod", validatorFunc(func() error {
+				var amount int
+				for _, candidate := range []template.String{v.Pod, v.Selector, v.Owner} {
+					if !candidate.IsZero() {
+						amount++
+					}
+				}
+				if amount != 1 {
+					return fmt.Errorf("exactly one of [pod], [selector] or [owner] is required")
+				}
+				return nil
+			})
+		},
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "container", &v.Container },
+
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "shellCommand", &v.ShellCommand },
+		notZeroValidate("shellCommand", func(v *EnvironmentKubernetesAttach) *template.Strings { return &v.ShellCommand }),
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "execCommand", &v.ExecCommand },
+		notZeroValidate("execCommand", func(v *EnvironmentKubernetesAttach) *template.Strings { return &v.ExecCommand }),
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "sftpCommand", &v.SftpCommand },
+
+		func(v *EnvironmentKubernetesAttach) (string, validator) { return "banner", &v.Banner },
+
+		func(v *EnvironmentKubernetesAttach) (string, validator) {
+			return "portForwardingAllowed", &v.PortForwardingAllowed
+		},
+	)
+}
+
+func (this *EnvironmentKubernetesAttach) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentKubernetesAttach, node *yaml.Node) error {
+		type raw EnvironmentKubernetesAttach
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentKubernetesAttach) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentKubernetesAttach:
+		return this.isEqualTo(&v)
+	case *EnvironmentKubernetesAttach:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentKubernetesAttach) isEqualTo(other *EnvironmentKubernetesAttach) bool {
+	return isEqual(&this.LoginAllowed, &other.LoginAllowed) &&
+		isEqual(&this.Config, &other.Config) &&
+		this.Context == other.Context &&
+		isEqual(&this.Namespace, &other.Namespace) &&
+		isEqual(&this.Pod, &other.Pod) &&
+		isEqual(&this.Selector, &other.Selector) &&
+		isEqual(&this.Owner, &other.Owner) &&
+		isEqual(&this.Container, &other.Container) &&
+		isEqual(&this.ShellCommand, &other.ShellCommand) &&
+		isEqual(&this.ExecCommand, &other.ExecCommand) &&
+		isEqual(&this.SftpCommand, &other.SftpCommand) &&
+		isEqual(&this.Banner, &other.Banner) &&
+		isEqual(&this.PortForwardingAllowed, &other.PortForwardingAllowed)
+}
+
+func (this EnvironmentKubernetesAttach) Types() []string {
+	return []string{"kubernetesAttach", "kubernetes-attach", "kubernetes_attach"}
+}
+
+func (this EnvironmentKubernetesAttach) FeatureFlags() []string {
+	return []string{"kubernetesAttach"}
+}
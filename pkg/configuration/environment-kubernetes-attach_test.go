@@ -0,0 +1,48 @@
+package configuration
+
+import (
+	"testing"
+
+	"github.com/echocat/slf4g/sdk/testlog"
+
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+func TestEnvironmentKubernetesAttach_UnmarshalYAML(t *testing.T) {
+	testlog.Hook(t)
+
+	runUnmarshalYamlTests(t,
+		unmarshalYamlTestCase[EnvironmentKubernetesAttach]{
+			name:          "target-missing",
+			yaml:          `{}`,
+			expectedError: `[pod] exactly one of [pod], [selector] or [owner] is required`,
+		},
+		unmarshalYamlTestCase[EnvironmentKubernetesAttach]{
+			name: "multiple-targets",
+			yaml: `pod: foo
+owner: deployment/bar`,
+			expectedError: `[pod] exactly one of [pod], [selector] or [owner] is required`,
+		},
+		unmarshalYamlTestCase[EnvironmentKubernetesAttach]{
+			name: "owner",
+			yaml: `namespace: "{{.authorization.idToken.team}}"
+owner: deployment/app
+container: app`,
+			expected: EnvironmentKubernetesAttach{
+				LoginAllowed:          DefaultEnvironmentKubernetesAttachLoginAllowed,
+				Config:                DefaultEnvironmentKubernetesAttachConfig,
+				Context:               DefaultEnvironmentKubernetesAttachContext,
+				Namespace:             template.MustNewString("{{.authorization.idToken.team}}"),
+				Pod:                   DefaultEnvironmentKubernetesAttachPod,
+				Selector:              DefaultEnvironmentKubernetesAttachSelector,
+				Owner:                 template.MustNewString("deployment/app"),
+				Container:             template.MustNewString("app"),
+				ShellCommand:          DefaultEnvironmentKubernetesAttachShellCommand,
+				ExecCommand:           DefaultEnvironmentKubernetesAttachExecCommand,
+				SftpCommand:           DefaultEnvironmentKubernetesAttachSftpCommand,
+				Banner:                DefaultEnvironmentKubernetesAttachBanner,
+				PortForwardingAllowed: DefaultEnvironmentKubernetesAttachPortForwardingAllowed,
+			},
+		},
+	)
+}
@@ -0,0 +1,125 @@
+package configuration
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	// DefaultEnvironmentKubernetesHomeKey is the default setting for EnvironmentKubernetesHome.Key.
+	DefaultEnvironmentKubernetesHomeKey = template.MustNewString("")
+
+	// DefaultEnvironmentKubernetesHomeDirectory is the default setting for EnvironmentKubernetesHome.Directory.
+	DefaultEnvironmentKubernetesHomeDirectory = template.MustNewString("")
+
+	// DefaultEnvironmentKubernetesHomeStorageClass is the default setting for EnvironmentKubernetesHome.StorageClass.
+	DefaultEnvironmentKubernetesHomeStorageClass = template.MustNewString("")
+
+	// DefaultEnvironmentKubernetesHomeSize is the default setting for EnvironmentKubernetesHome.Size.
+	DefaultEnvironmentKubernetesHomeSize = template.MustNewString("1Gi")
+
+	// DefaultEnvironmentKubernetesHomeAccessMode is the default setting for EnvironmentKubernetesHome.AccessMode.
+	DefaultEnvironmentKubernetesHomeAccessMode = template.MustNewString("ReadWriteOnce")
+
+	// DefaultEnvironmentKubernetesHomeRetention is the default setting for EnvironmentKubernetesHome.Retention.
+	DefaultEnvironmentKubernetesHomeRetention = common.DurationOf(0)
+)
+
+// EnvironmentKubernetesHome defines a persistent home of a user inside of an
+// EnvironmentDocker. It is a named volume which survives the disposal of the
+// containers and is mounted in each container of the same user again.
+type EnvironmentKubernetesHome struct {
+	// Key identifies the owner of the home (like its email address). If
+	// empty (after being evaluated), no persistent home is used.
+	Key template.String `yaml:"key,omitempty"`
+
+	// Directory where the home is mounted inside the container. If empty,
+	// EnvironmentKubernetes.Directory is used.
+	Directory template.String `yaml:"directory,omitempty"`
+
+	// StorageClass of the PersistentVolumeClaim. If empty, the default
+	// storage class of the cluster is used.
+	StorageClass template.String `yaml:"storageClass,omitempty"`
+	Size         template.String `yaml:"size,omitempty"`
+	AccessMode   template.String `yaml:"accessMode,omitempty"`
+
+	// Retention defines for how long a home is kept after the last login of
+	// its owner. 0 means it is kept forever.
+	Retention common.Duration `yaml:"retention,omitempty"`
+}
+
+func (this *EnvironmentKubernetesHome) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("key", func(v *EnvironmentKubernetesHome) *template.String { return &v.Key }, DefaultEnvironmentKubernetesHomeKey),
+		fixedDefault("directory", func(v *EnvironmentKubernetesHome) *template.String { return &v.Directory }, DefaultEnvironmentKubernetesHomeDirectory),
+		fixedDefault("storageClass", func(v *EnvironmentKubernetesHome) *template.String { return &v.StorageClass }, DefaultEnvironmentKubernetesHomeStorageClass),
+		fixedDefault("size", func(v *EnvironmentKubernetesHome) *template.String { return &v.Size }, DefaultEnvironmentKubernetesHomeSize),
+		fixedDefault("accessMode", func(v *EnvironmentKubernetesHome) *template.String { return &v.AccessMode }, DefaultEnvironmentKubernetesHomeAccessMode),
+		fixedDefault("retention", func(v *EnvironmentKubernetesHome) *common.Duration { return &v.Retention }, DefaultEnvironmentKubernetesHomeRetention),
+	)
+}
+
+func (this *EnvironmentKubernetesHome) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentKubernetesHome]("key"),
+		noopTrim[EnvironmentKubernetesHome]("directory"),
+		noopTrim[EnvironmentKubernetesHome]("storageClass"),
+		noopTrim[EnvironmentKubernetesHome]("size"),
+		noopTrim[EnvironmentKubernetesHome]("accessMode"),
+		noopTrim[EnvironmentKubernetesHome]("retention"),
+	)
+}
+
+func (this *EnvironmentKubernetesHome) Validate() error {
+	return validate(this,
+		func(v *EnvironmentKubernetesHome) (string, validator) { return "key", &v.Key },
+		func(v *EnvironmentKubernetesHome) (string, validator) { return "directory", &v.Directory },
+		func(v *EnvironmentKubernetesHome) (string, validator) { return "storageClass", &v.StorageClass },
+		func(v *EnvironmentKubernetesHome) (string, validator) { return "size", &v.Size },
+		notZeroValidate("size", func(v *EnvironmentKubernetesHome) *template.String { return &v.Size }),
+		func(v *EnvironmentKubernetesHome) (string, validator) { return "accessMode", &v.AccessMode },
+		notZeroValidate("accessMode", func(v *EnvironmentKubernetesHome) *template.String { return &v.AccessMode }),
+		func(v *EnvironmentKubernetesHome) (string, validator) {
+			return "retention", validatorFunc(func() error {
+				if v.Retention.Native() < 0 {
+					return fmt.Errorf("cannot be negative, but got: %v", v.Retention)
+				}
+				return nil
+			})
+		},
+	)
+}
+
+func (this *EnvironmentKubernetesHome) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentKubernetesHome, node *yaml.Node) error {
+		type raw EnvironmentKubernetesHome
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentKubernetesHome) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentKubernetesHome:
+		return this.isEqualTo(&v)
+	case *EnvironmentKubernetesHome:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentKubernetesHome) isEqualTo(other *EnvironmentKubernetesHome) bool {
+	return isEqual(&this.Key, &other.Key) &&
+		isEqual(&this.Directory, &other.Directory) &&
+		isEqual(&this.StorageClass, &other.StorageClass) &&
+		isEqual(&this.Size, &other.Size) &&
+		isEqual(&this.AccessMode, &other.AccessMode) &&
+		isEqual(&this.Retention, &other.Retention)
+}
@@ -0,0 +1,151 @@
+package configuration
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	// DefaultEnvironmentKubernetesIsolationNamespace is the default setting for EnvironmentKubernetesIsolation.Namespace.
+	DefaultEnvironmentKubernetesIsolationNamespace = template.MustNewString("")
+
+	// DefaultEnvironmentKubernetesIsolationServiceAccount is the default setting for EnvironmentKubernetesIsolation.ServiceAccount.
+	DefaultEnvironmentKubernetesIsolationServiceAccount = template.MustNewString(`metadata:
+  name: user
+`)
+
+	// DefaultEnvironmentKubernetesIsolationRoleBinding is the default setting for EnvironmentKubernetesIsolation.RoleBinding.
+	DefaultEnvironmentKubernetesIsolationRoleBinding = template.MustNewString(`metadata:
+  name: user
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: edit
+subjects:
+  - kind: ServiceAccount
+    name: user
+`)
+
+	// DefaultEnvironmentKubernetesIsolationResourceQuota is the default setting for EnvironmentKubernetesIsolation.ResourceQuota.
+	DefaultEnvironmentKubernetesIsolationResourceQuota = template.MustNewString(`metadata:
+  name: default
+spec:
+  hard:
+    pods: "10"
+    services: "5"
+    persistentvolumeclaims: "5"
+`)
+
+	// DefaultEnvironmentKubernetesIsolationNetworkPolicy is the default setting for EnvironmentKubernetesIsolation.NetworkPolicy.
+	DefaultEnvironmentKubernetesIsolationNetworkPolicy = template.MustNewString(`metadata:
+  name: default
+spec:
+  podSelector: {}
+  policyTypes: [Ingress]
+  ingress:
+    - from:
+        - podSelector: {}
+`)
+
+	// DefaultEnvironmentKubernetesIsolationKubeconfig is the default setting for EnvironmentKubernetesIsolation.Kubeconfig.
+	DefaultEnvironmentKubernetesIsolationKubeconfig = template.MustNewString("/etc/bifroest/kubeconfig")
+)
+
+// EnvironmentKubernetesIsolation defines that each session of an
+// EnvironmentKubernetes gets its own namespace, together with generated
+// RBAC, quota and network resources. The namespace is removed together with
+// the environment.
+type EnvironmentKubernetesIsolation struct {
+	// Namespace is the name of the namespace which is created for each
+	// session. If empty (after being evaluated), no isolation is used.
+	Namespace template.String `yaml:"namespace,omitempty"`
+
+	// ServiceAccount is the manifest (in YAML or JSON) of the ServiceAccount
+	// the POD of the session runs with. The namespace is always set by
+	// Bifröst.
+	ServiceAccount template.String `yaml:"serviceAccount,omitempty"`
+	// RoleBinding is the manifest (in YAML or JSON) of the RoleBinding which
+	// grants permissions inside the namespace. If empty (after being
+	// evaluated), no RoleBinding is created.
+	RoleBinding template.String `yaml:"roleBinding,omitempty"`
+	// ResourceQuota is the manifest (in YAML or JSON) of the ResourceQuota
+	// of the namespace. If empty (after being evaluated), no ResourceQuota is
+	// created.
+	ResourceQuota template.String `yaml:"resourceQuota,omitempty"`
+	// NetworkPolicy is the manifest (in YAML or JSON) of the NetworkPolicy
+	// of the namespace. If empty (after being evaluated), no NetworkPolicy is
+	// created.
+	NetworkPolicy template.String `yaml:"networkPolicy,omitempty"`
+
+	// Kubeconfig is the path inside the container where a kubeconfig, scoped
+	// to the namespace, is placed. If empty (after being evaluated), no
+	// kubeconfig is provided.
+	Kubeconfig template.String `yaml:"kubeconfig,omitempty"`
+}
+
+func (this *EnvironmentKubernetesIsolation) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("namespace", func(v *EnvironmentKubernetesIsolation) *template.String { return &v.Namespace }, DefaultEnvironmentKubernetesIsolationNamespace),
+		fixedDefault("serviceAccount", func(v *EnvironmentKubernetesIsolation) *template.String { return &v.ServiceAccount }, DefaultEnvironmentKubernetesIsolationServiceAccount),
+		fixedDefault("roleBinding", func(v *EnvironmentKubernetesIsolation) *template.String { return &v.RoleBinding }, DefaultEnvironmentKubernetesIsolationRoleBinding),
+		fixedDefault("resourceQuota", func(v *EnvironmentKubernetesIsolation) *template.String { return &v.ResourceQuota }, DefaultEnvironmentKubernetesIsolationResourceQuota),
+		fixedDefault("networkPolicy", func(v *EnvironmentKubernetesIsolation) *template.String { return &v.NetworkPolicy }, DefaultEnvironmentKubernetesIsolationNetworkPolicy),
+		fixedDefault("kubeconfig", func(v *EnvironmentKubernetesIsolation) *template.String { return &v.Kubeconfig }, DefaultEnvironmentKubernetesIsolationKubeconfig),
+	)
+}
+
+func (this *EnvironmentKubernetesIsolation) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentKubernetesIsolation]("namespace"),
+		noopTrim[EnvironmentKubernetesIsolation]("serviceAccount"),
+		noopTrim[EnvironmentKubernetesIsolation]("roleBinding"),
+		noopTrim[EnvironmentKubernetesIsolation]("resourceQuota"),
+		noopTrim[EnvironmentKubernetesIsolation]("networkPolicy"),
+		noopTrim[EnvironmentKubernetesIsolation]("kubeconfig"),
+	)
+}
+
+func (this *EnvironmentKubernetesIsolation) Validate() error {
+	return validate(this,
+		func(v *EnvironmentKubernetesIsolation) (string, validator) { return "namespace", &v.Namespace },
+		func(v *EnvironmentKubernetesIsolation) (string, validator) {
+			return "serviceAccount", &v.ServiceAccount
+		},
+		notZeroValidate("serviceAccount", func(v *EnvironmentKubernetesIsolation) *template.String { return &v.ServiceAccount }),
+		func(v *EnvironmentKubernetesIsolation) (string, validator) { return "roleBinding", &v.RoleBinding },
+		func(v *EnvironmentKubernetesIsolation) (string, validator) { return "resourceQuota", &v.ResourceQuota },
+		func(v *EnvironmentKubernetesIsolation) (string, validator) { return "networkPolicy", &v.NetworkPolicy },
+		func(v *EnvironmentKubernetesIsolation) (string, validator) { return "kubeconfig", &v.Kubeconfig },
+	)
+}
+
+func (this *EnvironmentKubernetesIsolation) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentKubernetesIsolation, node *yaml.Node) error {
+		type raw EnvironmentKubernetesIsolation
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentKubernetesIsolation) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentKubernetesIsolation:
+		return this.isEqualTo(&v)
+	case *EnvironmentKubernetesIsolation:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentKubernetesIsolation) isEqualTo(other *EnvironmentKubernetesIsolation) bool {
+	return isEqual(&this.Namespace, &other.Namespace) &&
+		isEqual(&this.ServiceAccount, &other.ServiceAccount) &&
+		isEqual(&this.RoleBinding, &other.RoleBinding) &&
+		isEqual(&this.ResourceQuota, &other.ResourceQuota) &&
+		isEqual(&this.NetworkPolicy, &other.NetworkPolicy) &&
+		isEqual(&this.Kubeconfig, &other.Kubeconfig)
+}
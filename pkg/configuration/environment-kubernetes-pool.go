@@ -0,0 +1,134 @@
+package configuration
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+var (
+	// DefaultEnvironmentKubernetesPoolSize is the default setting for EnvironmentKubernetesPool.Size.
+	DefaultEnvironmentKubernetesPoolSize uint32 = 0
+)
+
+// EnvironmentKubernetesPool defines a warm pool of PODs of an
+// EnvironmentKubernetes, which are created before they are requested by any
+// session. On login, a POD of the pool is claimed by the session, instead of
+// creating a new one.
+type EnvironmentKubernetesPool struct {
+	// Size is the amount of unclaimed PODs which are kept ready. 0 disables
+	// the pool.
+	Size uint32 `yaml:"size,omitempty"`
+}
+
+func (this *EnvironmentKubernetesPool) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("size", func(v *EnvironmentKubernetesPool) *uint32 { return &v.Size }, DefaultEnvironmentKubernetesPoolSize),
+	)
+}
+
+func (this *EnvironmentKubernetesPool) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentKubernetesPool]("size"),
+	)
+}
+
+func (this *EnvironmentKubernetesPool) Validate() error {
+	return validate(this,
+		noopValidate[EnvironmentKubernetesPool]("size"),
+	)
+}
+
+func (this *EnvironmentKubernetesPool) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentKubernetesPool, node *yaml.Node) error {
+		type raw EnvironmentKubernetesPool
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentKubernetesPool) IsEnabled() bool {
+	return this.Size > 0
+}
+
+func (this EnvironmentKubernetesPool) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentKubernetesPool:
+		return this.isEqualTo(&v)
+	case *EnvironmentKubernetesPool:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentKubernetesPool) isEqualTo(other *EnvironmentKubernetesPool) bool {
+	return this.Size == other.Size
+}
+
+// dataDependentTemplate is a template which might access the data it is
+// rendered with.
+type dataDependentTemplate interface {
+	DependsOnData() bool
+}
+
+// validateContextIndependent ensures that the given template does not depend
+// on any per-user context, like the session or authorization. Such templates
+// are rendered without any context, like the properties of PODs of the
+// EnvironmentKubernetesPool or containers of the EnvironmentDockerPool. Every branch of the template is checked, not
+// only the ones which are taken while the configuration is loaded.
+func validateContextIndependent(name string, tmpl dataDependentTemplate) error {
+	if tmpl.DependsOnData() {
+		return errors.Config.Newf("[%s] cannot depend on any per-user context", name)
+	}
+	return nil
+}
+
+// validatePool ensures that all templates which are used to create the PODs
+// of the pool do not depend on any per-user context.
+func (this *EnvironmentKubernetes) validatePool() error {
+	if !this.Pool.IsEnabled() {
+		return nil
+	}
+
+	for _, err := range []error{
+		validateContextIndependent("config", this.Config),
+		validateContextIndependent("context", this.Context),
+		validateContextIndependent("namespace", this.Namespace),
+		validateContextIndependent("serviceAccountName", this.ServiceAccountName),
+		validateContextIndependent("image", this.Image),
+		validateContextIndependent("imagePullSecretName", this.ImagePullSecretName),
+		validateContextIndependent("imagePullCredentials", this.ImagePullCredentials),
+		validateContextIndependent("readyTimeout", this.ReadyTimeout),
+		validateContextIndependent("capabilities", this.Capabilities),
+		validateContextIndependent("privileged", this.Privileged),
+		validateContextIndependent("dnsServers", this.DnsServers),
+		validateContextIndependent("dnsSearch", this.DnsSearch),
+		validateContextIndependent("podPatch", this.PodPatch),
+		validateContextIndependent("shellCommand", this.ShellCommand),
+		validateContextIndependent("execCommand", this.ExecCommand),
+		validateContextIndependent("sftpCommand", this.SftpCommand),
+		validateContextIndependent("directory", this.Directory),
+		validateContextIndependent("user", this.User),
+		validateContextIndependent("group", this.Group),
+		validateContextIndependent("portForwardingAllowed", this.PortForwardingAllowed),
+		validateContextIndependent("socketForwardingPaths", this.SocketForwardingPaths),
+		validateContextIndependent("x11ForwardingAllowed", this.X11ForwardingAllowed),
+		validateContextIndependent("terminals.persistent", this.Terminals.Persistent),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	if !this.Home.Key.IsZero() {
+		return errors.Config.Newf("cannot be combined with [home.key], because a POD of the pool does not belong to any user before it is claimed")
+	}
+	if !this.Isolation.Namespace.IsZero() {
+		return errors.Config.Newf("cannot be combined with [isolation.namespace], because a POD of the pool does not belong to any session before it is claimed")
+	}
+
+	return nil
+}
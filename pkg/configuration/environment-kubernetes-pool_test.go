@@ -0,0 +1,66 @@
+package configuration
+
+import (
+	"testing"
+
+	"github.com/echocat/slf4g/sdk/testlog"
+)
+
+func TestEnvironmentKubernetes_UnmarshalYAML_pool(t *testing.T) {
+	testlog.Hook(t)
+
+	runUnmarshalYamlTests(t,
+		unmarshalYamlTestCase[EnvironmentKubernetes]{
+			name: "session-dependent-image",
+			yaml: `image: "registry/{{.session.id}}"
+pool:
+  size: 2`,
+			expectedError: `[pool] [image] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentKubernetes]{
+			name: "session-dependent-image-in-branch",
+			yaml: `image: "{{ if env ` + "`FOO`" + ` }}registry/{{.session.id}}{{ else }}alpine{{ end }}"
+pool:
+  size: 2`,
+			expectedError: `[pool] [image] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentKubernetes]{
+			name: "user-dependent-context",
+			yaml: `context: "{{.authorization.user.name}}"
+pool:
+  size: 2`,
+			expectedError: `[pool] [context] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentKubernetes]{
+			name: "user-dependent-config",
+			yaml: `config: "{{.authorization.user.name}}.yaml"
+pool:
+  size: 2`,
+			expectedError: `[pool] [config] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentKubernetes]{
+			name: "user-dependent-terminals",
+			yaml: `terminals:
+  persistent: "{{ eq .authorization.user.name ` + "`foo`" + ` }}"
+pool:
+  size: 2`,
+			expectedError: `[pool] [terminals.persistent] cannot depend on any per-user context`,
+		},
+		unmarshalYamlTestCase[EnvironmentKubernetes]{
+			name: "user-dependent-home",
+			yaml: `home:
+  key: "{{.authorization.user.name}}"
+pool:
+  size: 2`,
+			expectedError: `[pool] cannot be combined with [home.key]`,
+		},
+		unmarshalYamlTestCase[EnvironmentKubernetes]{
+			name: "isolation",
+			yaml: `isolation:
+  namespace: "bifroest-{{.session.id}}"
+pool:
+  size: 2`,
+			expectedError: `[pool] cannot be combined with [isolation.namespace]`,
+		},
+	)
+}
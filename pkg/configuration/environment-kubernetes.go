@@ -33,6 +33,7 @@ var (
 	DefaultEnvironmentKubernetesPrivileged   = template.BoolOf(false)
 	DefaultEnvironmentKubernetesDnsServers   = template.MustNewStrings()
 	DefaultEnvironmentKubernetesDnsSearch    = template.MustNewStrings()
+	DefaultEnvironmentKubernetesPodPatch     = template.MustNewString("")
 	DefaultEnvironmentKubernetesShellCommand = template.MustNewStrings()
 	DefaultEnvironmentKubernetesExecCommand  = template.MustNewStrings()
 	DefaultEnvironmentKubernetesSftpCommand  = template.MustNewStrings()
@@ -42,6 +43,8 @@ var (
 
 	DefaultEnvironmentKubernetesBanner                = template.MustNewString("")
 	DefaultEnvironmentKubernetesPortForwardingAllowed = template.BoolOf(true)
+	DefaultEnvironmentKubernetesSocketForwardingPaths = template.MustNewStrings()
+	DefaultEnvironmentKubernetesX11ForwardingAllowed  = template.BoolOf(false)
 
 	DefaultEnvironmentKubernetesCleanOrphan = template.BoolOf(true)
 
@@ -71,6 +74,9 @@ type EnvironmentKubernetes struct {
 	Privileged           template.Bool     `yaml:"privileged,omitempty"`
 	DnsServers           template.Strings  `yaml:"dnsServers,omitempty"`
 	DnsSearch            template.Strings  `yaml:"dnsSearch,omitempty"`
+	// PodPatch is a strategic merge patch (in YAML or JSON) which is applied
+	// to the POD generated by Bifröst, before it is created.
+	PodPatch template.String `yaml:"podPatch,omitempty"`
 
 	ShellCommand template.Strings `yaml:"shellCommand,omitempty"`
 	ExecCommand  template.Strings `yaml:"execCommand,omitempty"`
@@ -79,8 +85,15 @@ type EnvironmentKubernetes struct {
 	User         template.String  `yaml:"user,omitempty"`
 	Group        template.String  `yaml:"group,omitempty"`
 
-	Banner                template.String `yaml:"banner,omitempty"`
-	PortForwardingAllowed template.Bool   `yaml:"portForwardingAllowed,omitempty"`
+	Banner                template.String  `yaml:"banner,omitempty"`
+	PortForwardingAllowed template.Bool    `yaml:"portForwardingAllowed,omitempty"`
+	SocketForwardingPaths template.Strings `yaml:"socketForwardingPaths,omitempty"`
+	X11ForwardingAllowed  template.Bool    `yaml:"x11ForwardingAllowed,omitempty"`
+
+	Terminals EnvironmentTerminals           `yaml:"terminals,omitempty"`
+	Home      EnvironmentKubernetesHome      `yaml:"home,omitempty"`
+	Isolation EnvironmentKubernetesIsolation `yaml:"isolation,omitempty"`
+	Pool      EnvironmentKubernetesPool      `yaml:"pool,omitempty"`
 
 	CleanOrphan template.Bool `yaml:"cleanOrphan,omitempty"`
 }
@@ -109,6 +122,7 @@ func (this *EnvironmentKubernetes) SetDefaults() error {
 		fixedDefault("privileged", func(v *EnvironmentKubernetes) *template.Bool { return &v.Privileged }, DefaultEnvironmentKubernetesPrivileged),
 		fixedDefault("dnsServers", func(v *EnvironmentKubernetes) *template.Strings { return &v.DnsServers }, DefaultEnvironmentKubernetesDnsServers),
 		fixedDefault("dnsSearch", func(v *EnvironmentKubernetes) *template.Strings { return &v.DnsSearch }, DefaultEnvironmentKubernetesDnsSearch),
+		fixedDefault("podPatch", func(v *EnvironmentKubernetes) *template.String { return &v.PodPatch }, DefaultEnvironmentKubernetesPodPatch),
 
 		fixedDefault("shellCommand", func(v *EnvironmentKubernetes) *template.Strings { return &v.ShellCommand }, DefaultEnvironmentKubernetesShellCommand),
 		fixedDefault("execCommand", func(v *EnvironmentKubernetes) *template.Strings { return &v.ExecCommand }, DefaultEnvironmentKubernetesExecCommand),
@@ -120,6 +134,12 @@ func (this *EnvironmentKubernetes) SetDefaults() error {
 		fixedDefault("banner", func(v *EnvironmentKubernetes) *template.String { return &v.Banner }, DefaultEnvironmentKubernetesBanner),
 
 		fixedDefault("portForwardingAllowed", func(v *EnvironmentKubernetes) *template.Bool { return &v.PortForwardingAllowed }, DefaultEnvironmentKubernetesPortForwardingAllowed),
+		fixedDefault("socketForwardingPaths", func(v *EnvironmentKubernetes) *template.Strings { return &v.SocketForwardingPaths }, DefaultEnvironmentKubernetesSocketForwardingPaths),
+		fixedDefault("x11ForwardingAllowed", func(v *EnvironmentKubernetes) *template.Bool { return &v.X11ForwardingAllowed }, DefaultEnvironmentKubernetesX11ForwardingAllowed),
+		func(v *EnvironmentKubernetes) (string, defaulter) { return "terminals", &v.Terminals },
+		func(v *EnvironmentKubernetes) (string, defaulter) { return "home", &v.Home },
+		func(v *EnvironmentKubernetes) (string, defaulter) { return "isolation", &v.Isolation },
+		func(v *EnvironmentKubernetes) (string, defaulter) { return "pool", &v.Pool },
 
 		fixedDefault("cleanOrphan", func(v *EnvironmentKubernetes) *template.Bool { return &v.CleanOrphan }, DefaultEnvironmentKubernetesCleanOrphan),
 	)
@@ -147,6 +167,7 @@ func (this *EnvironmentKubernetes) Trim() error {
 		noopTrim[EnvironmentKubernetes]("privileged"),
 		noopTrim[EnvironmentKubernetes]("dnsServers"),
 		noopTrim[EnvironmentKubernetes]("dnsSearch"),
+		noopTrim[EnvironmentKubernetes]("podPatch"),
 		noopTrim[EnvironmentKubernetes]("shellCommand"),
 		noopTrim[EnvironmentKubernetes]("execCommand"),
 		noopTrim[EnvironmentKubernetes]("sftpCommand"),
@@ -157,6 +178,12 @@ func (this *EnvironmentKubernetes) Trim() error {
 		noopTrim[EnvironmentKubernetes]("banner"),
 
 		noopTrim[EnvironmentKubernetes]("portForwardingAllowed"),
+		noopTrim[EnvironmentKubernetes]("socketForwardingPaths"),
+		noopTrim[EnvironmentKubernetes]("x11ForwardingAllowed"),
+		func(v *EnvironmentKubernetes) (string, trimmer) { return "terminals", &v.Terminals },
+		func(v *EnvironmentKubernetes) (string, trimmer) { return "home", &v.Home },
+		func(v *EnvironmentKubernetes) (string, trimmer) { return "isolation", &v.Isolation },
+		func(v *EnvironmentKubernetes) (string, trimmer) { return "pool", &v.Pool },
 
 		noopTrim[EnvironmentKubernetes]("cleanOrphan"),
 	)
@@ -197,6 +224,7 @@ func (this *EnvironmentKubernetes) Validate() error {
 		func(v *EnvironmentKubernetes) (string, validator) { return "privileged", &v.Privileged },
 		func(v *EnvironmentKubernetes) (string, validator) { return "dnsServers", &v.DnsServers },
 		func(v *EnvironmentKubernetes) (string, validator) { return "dnsSearch", &v.DnsSearch },
+		func(v *EnvironmentKubernetes) (string, validator) { return "podPatch", &v.PodPatch },
 		func(v *EnvironmentKubernetes) (string, validator) { return "shellCommand", &v.ShellCommand },
 		func(v *EnvironmentKubernetes) (string, validator) { return "execCommand", &v.ExecCommand },
 		func(v *EnvironmentKubernetes) (string, validator) { return "sftpCommand", &v.SftpCommand },
@@ -209,6 +237,19 @@ func (this *EnvironmentKubernetes) Validate() error {
 		func(v *EnvironmentKubernetes) (string, validator) {
 			return "portForwardingAllowed", &v.PortForwardingAllowed
 		},
+		func(v *EnvironmentKubernetes) (string, validator) {
+			return "socketForwardingPaths", &v.SocketForwardingPaths
+		},
+		func(v *EnvironmentKubernetes) (string, validator) {
+			return "x11ForwardingAllowed", &v.X11ForwardingAllowed
+		},
+		func(v *EnvironmentKubernetes) (string, validator) { return "terminals", &v.Terminals },
+		func(v *EnvironmentKubernetes) (string, validator) { return "home", &v.Home },
+		func(v *EnvironmentKubernetes) (string, validator) { return "isolation", &v.Isolation },
+		func(v *EnvironmentKubernetes) (string, validator) { return "pool", &v.Pool },
+		func(v *EnvironmentKubernetes) (string, validator) {
+			return "pool", validatorFunc(v.validatePool)
+		},
 
 		func(v *EnvironmentKubernetes) (string, validator) { return "cleanOrphan", &v.CleanOrphan },
 	)
@@ -254,6 +295,7 @@ func (this EnvironmentKubernetes) isEqualTo(other *EnvironmentKubernetes) bool {
 		isEqual(&this.Privileged, &other.Privileged) &&
 		isEqual(&this.DnsServers, &other.DnsServers) &&
 		isEqual(&this.DnsSearch, &other.DnsSearch) &&
+		isEqual(&this.PodPatch, &other.PodPatch) &&
 		isEqual(&this.ShellCommand, &other.ShellCommand) &&
 		isEqual(&this.ExecCommand, &other.ExecCommand) &&
 		isEqual(&this.SftpCommand, &other.SftpCommand) &&
@@ -262,6 +304,12 @@ func (this EnvironmentKubernetes) isEqualTo(other *EnvironmentKubernetes) bool {
 		isEqual(&this.Group, &other.Group) &&
 		isEqual(&this.Banner, &other.Banner) &&
 		isEqual(&this.PortForwardingAllowed, &other.PortForwardingAllowed) &&
+		isEqual(&this.SocketForwardingPaths, &other.SocketForwardingPaths) &&
+		isEqual(&this.X11ForwardingAllowed, &other.X11ForwardingAllowed) &&
+		isEqual(&this.Terminals, &other.Terminals) &&
+		isEqual(&this.Home, &other.Home) &&
+		isEqual(&this.Isolation, &other.Isolation) &&
+		isEqual(&this.Pool, &other.Pool) &&
 		isEqual(&this.CleanOrphan, &other.CleanOrphan)
 }
 
@@ -9,8 +9,10 @@ import (
 )
 
 var (
-	DefaultEnvironmentLocalCreateIfAbsent    = template.BoolOf(false)
-	DefaultEnvironmentLocalUpdateIfDifferent = template.BoolOf(false)
+	DefaultEnvironmentLocalCreateIfAbsent        = template.BoolOf(false)
+	DefaultEnvironmentLocalUpdateIfDifferent     = template.BoolOf(false)
+	DefaultEnvironmentLocalSocketForwardingPaths = template.MustNewStrings()
+	DefaultEnvironmentLocalX11ForwardingAllowed  = template.BoolOf(false)
 )
 
 type EnvironmentLocal struct {
@@ -24,7 +26,9 @@ type EnvironmentLocal struct {
 
 	Banner template.String `yaml:"banner,omitempty"`
 
-	PortForwardingAllowed template.Bool `yaml:"portForwardingAllowed,omitempty"`
+	PortForwardingAllowed template.Bool    `yaml:"portForwardingAllowed,omitempty"`
+	SocketForwardingPaths template.Strings `yaml:"socketForwardingPaths,omitempty"`
+	X11ForwardingAllowed  template.Bool    `yaml:"x11ForwardingAllowed,omitempty"`
 }
 
 func (this *EnvironmentLocal) SetDefaults() error {
@@ -40,6 +44,8 @@ func (this *EnvironmentLocal) SetDefaults() error {
 		fixedDefault("banner", func(v *EnvironmentLocal) *template.String { return &v.Banner }, DefaultEnvironmentLocalBanner),
 
 		fixedDefault("portForwardingAllowed", func(v *EnvironmentLocal) *template.Bool { return &v.PortForwardingAllowed }, DefaultEnvironmentLocalPortForwardingAllowed),
+		fixedDefault("socketForwardingPaths", func(v *EnvironmentLocal) *template.Strings { return &v.SocketForwardingPaths }, DefaultEnvironmentLocalSocketForwardingPaths),
+		fixedDefault("x11ForwardingAllowed", func(v *EnvironmentLocal) *template.Bool { return &v.X11ForwardingAllowed }, DefaultEnvironmentLocalX11ForwardingAllowed),
 	)
 }
 
@@ -56,6 +62,8 @@ func (this *EnvironmentLocal) Trim() error {
 		noopTrim[EnvironmentLocal]("banner"),
 
 		noopTrim[EnvironmentLocal]("portForwardingAllowed"),
+		noopTrim[EnvironmentLocal]("socketForwardingPaths"),
+		noopTrim[EnvironmentLocal]("x11ForwardingAllowed"),
 	)
 }
 
@@ -74,6 +82,12 @@ func (this *EnvironmentLocal) Validate() error {
 		func(v *EnvironmentLocal) (string, validator) {
 			return "portForwardingAllowed", &v.PortForwardingAllowed
 		},
+		func(v *EnvironmentLocal) (string, validator) {
+			return "socketForwardingPaths", &v.SocketForwardingPaths
+		},
+		func(v *EnvironmentLocal) (string, validator) {
+			return "x11ForwardingAllowed", &v.X11ForwardingAllowed
+		},
 	)
 }
 
@@ -105,7 +119,9 @@ func (this EnvironmentLocal) isEqualTo(other *EnvironmentLocal) bool {
 		isEqual(&this.UpdateIfDifferent, &other.UpdateIfDifferent) &&
 		isEqual(&this.Dispose, &other.Dispose) &&
 		isEqual(&this.Banner, &other.Banner) &&
-		isEqual(&this.PortForwardingAllowed, &other.PortForwardingAllowed)
+		isEqual(&this.PortForwardingAllowed, &other.PortForwardingAllowed) &&
+		isEqual(&this.SocketForwardingPaths, &other.SocketForwardingPaths) &&
+		isEqual(&this.X11ForwardingAllowed, &other.X11ForwardingAllowed)
 }
 
 func (this EnvironmentLocal) Types() []string {
@@ -0,0 +1,228 @@
+package configuration
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	DefaultEnvironmentPodmanLoginAllowed = template.BoolOf(true)
+
+	DefaultEnvironmentPodmanHost = template.MustNewString("{{ env `CONTAINER_HOST` }}")
+
+	DefaultEnvironmentPodmanImage                = template.MustNewString("alpine")
+	DefaultEnvironmentPodmanImagePullPolicy      = PullPolicyIfAbsent
+	DefaultEnvironmentPodmanImagePullCredentials = template.MustNewString("")
+	DefaultEnvironmentPodmanPod                  = template.MustNewString("")
+	DefaultEnvironmentPodmanNetworks             = template.MustNewStrings()
+	DefaultEnvironmentPodmanVolumes              = template.MustNewStrings()
+	DefaultEnvironmentPodmanCapabilities         = template.MustNewStrings()
+	DefaultEnvironmentPodmanPrivileged           = template.BoolOf(false)
+	DefaultEnvironmentPodmanDnsServers           = template.MustNewStrings()
+	DefaultEnvironmentPodmanDnsSearch            = template.MustNewStrings()
+	DefaultEnvironmentPodmanShellCommand         = template.MustNewStrings()
+	DefaultEnvironmentPodmanExecCommand          = template.MustNewStrings()
+	DefaultEnvironmentPodmanSftpCommand          = template.MustNewStrings()
+	DefaultEnvironmentPodmanDirectory            = template.MustNewString("")
+	DefaultEnvironmentPodmanUser                 = template.MustNewString("")
+
+	DefaultEnvironmentPodmanBanner                = template.MustNewString("")
+	DefaultEnvironmentPodmanPortForwardingAllowed = template.BoolOf(true)
+	DefaultEnvironmentPodmanImpPublishHost        = net.MustNewHost("")
+
+	DefaultEnvironmentPodmanCleanOrphan = template.BoolOf(true)
+
+	_ = RegisterEnvironmentV(func() EnvironmentV {
+		return &EnvironmentPodman{}
+	})
+)
+
+type EnvironmentPodman struct {
+	LoginAllowed template.Bool `yaml:"loginAllowed,omitempty"`
+
+	Host template.String `yaml:"host,omitempty"`
+
+	Image                template.String  `yaml:"image"`
+	ImagePullPolicy      PullPolicy       `yaml:"imagePullPolicy,omitempty"`
+	ImagePullCredentials template.String  `yaml:"imagePullCredentials,omitempty"`
+	Pod                  template.String  `yaml:"pod,omitempty"`
+	Networks             template.Strings `yaml:"networks,omitempty"`
+	Volumes              template.Strings `yaml:"volumes,omitempty"`
+	Capabilities         template.Strings `yaml:"capabilities,omitempty"`
+	Privileged           template.Bool    `yaml:"privileged,omitempty"`
+	DnsServers           template.Strings `yaml:"dnsServers,omitempty"`
+	DnsSearch            template.Strings `yaml:"dnsSearch,omitempty"`
+
+	ShellCommand template.Strings `yaml:"shellCommand,omitempty"`
+	ExecCommand  template.Strings `yaml:"execCommand,omitempty"`
+	SftpCommand  template.Strings `yaml:"sftpCommand,omitempty"`
+	Directory    template.String  `yaml:"directory"`
+	User         template.String  `yaml:"user,omitempty"`
+
+	Banner template.String `yaml:"banner,omitempty"`
+
+	PortForwardingAllowed template.Bool `yaml:"portForwardingAllowed,omitempty"`
+	ImpPublishHost        net.Host      `yaml:"impPublishHost,omitempty"`
+
+	Terminals EnvironmentTerminals `yaml:"terminals,omitempty"`
+
+	CleanOrphan template.Bool `yaml:"cleanOrphan,omitempty"`
+}
+
+func (this *EnvironmentPodman) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("loginAllowed", func(v *EnvironmentPodman) *template.Bool { return &v.LoginAllowed }, DefaultEnvironmentPodmanLoginAllowed),
+
+		fixedDefault("host", func(v *EnvironmentPodman) *template.String { return &v.Host }, DefaultEnvironmentPodmanHost),
+
+		fixedDefault("image", func(v *EnvironmentPodman) *template.String { return &v.Image }, DefaultEnvironmentPodmanImage),
+		fixedDefault("imagePullPolicy", func(v *EnvironmentPodman) *PullPolicy { return &v.ImagePullPolicy }, DefaultEnvironmentPodmanImagePullPolicy),
+		fixedDefault("imagePullCredentials", func(v *EnvironmentPodman) *template.String { return &v.ImagePullCredentials }, DefaultEnvironmentPodmanImagePullCredentials),
+		fixedDefault("pod", func(v *EnvironmentPodman) *template.String { return &v.Pod }, DefaultEnvironmentPodmanPod),
+		fixedDefault("networks", func(v *EnvironmentPodman) *template.Strings { return &v.Networks }, DefaultEnvironmentPodmanNetworks),
+		fixedDefault("volumes", func(v *EnvironmentPodman) *template.Strings { return &v.Volumes }, DefaultEnvironmentPodmanVolumes),
+		fixedDefault("capabilities", func(v *EnvironmentPodman) *template.Strings { return &v.Capabilities }, DefaultEnvironmentPodmanCapabilities),
+		fixedDefault("privileged", func(v *EnvironmentPodman) *template.Bool { return &v.Privileged }, DefaultEnvironmentPodmanPrivileged),
+		fixedDefault("dnsServers", func(v *EnvironmentPodman) *template.Strings { return &v.DnsServers }, DefaultEnvironmentPodmanDnsServers),
+		fixedDefault("dnsSearch", func(v *EnvironmentPodman) *template.Strings { return &v.DnsSearch }, DefaultEnvironmentPodmanDnsSearch),
+
+		fixedDefault("shellCommand", func(v *EnvironmentPodman) *template.Strings { return &v.ShellCommand }, DefaultEnvironmentPodmanShellCommand),
+		fixedDefault("execCommand", func(v *EnvironmentPodman) *template.Strings { return &v.ExecCommand }, DefaultEnvironmentPodmanExecCommand),
+		fixedDefault("sftpCommand", func(v *EnvironmentPodman) *template.Strings { return &v.SftpCommand }, DefaultEnvironmentPodmanSftpCommand),
+		fixedDefault("directory", func(v *EnvironmentPodman) *template.String { return &v.Directory }, DefaultEnvironmentPodmanDirectory),
+		fixedDefault("user", func(v *EnvironmentPodman) *template.String { return &v.User }, DefaultEnvironmentPodmanUser),
+
+		fixedDefault("banner", func(v *EnvironmentPodman) *template.String { return &v.Banner }, DefaultEnvironmentPodmanBanner),
+
+		fixedDefault("portForwardingAllowed", func(v *EnvironmentPodman) *template.Bool { return &v.PortForwardingAllowed }, DefaultEnvironmentPodmanPortForwardingAllowed),
+		func(v *EnvironmentPodman) (string, defaulter) { return "terminals", &v.Terminals },
+		fixedDefault("impPublishHost", func(v *EnvironmentPodman) *net.Host { return &v.ImpPublishHost }, DefaultEnvironmentPodmanImpPublishHost),
+
+		fixedDefault("cleanOrphan", func(v *EnvironmentPodman) *template.Bool { return &v.CleanOrphan }, DefaultEnvironmentPodmanCleanOrphan),
+	)
+}
+
+func (this *EnvironmentPodman) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentPodman]("loginAllowed"),
+
+		noopTrim[EnvironmentPodman]("host"),
+
+		noopTrim[EnvironmentPodman]("image"),
+		noopTrim[EnvironmentPodman]("imagePullPolicy"),
+		noopTrim[EnvironmentPodman]("imagePullCredentials"),
+		noopTrim[EnvironmentPodman]("pod"),
+		noopTrim[EnvironmentPodman]("networks"),
+		noopTrim[EnvironmentPodman]("volumes"),
+		noopTrim[EnvironmentPodman]("capabilities"),
+		noopTrim[EnvironmentPodman]("privileged"),
+		noopTrim[EnvironmentPodman]("dnsServers"),
+		noopTrim[EnvironmentPodman]("dnsSearch"),
+		noopTrim[EnvironmentPodman]("shellCommand"),
+		noopTrim[EnvironmentPodman]("execCommand"),
+		noopTrim[EnvironmentPodman]("sftpCommand"),
+		noopTrim[EnvironmentPodman]("directory"),
+		noopTrim[EnvironmentPodman]("user"),
+
+		noopTrim[EnvironmentPodman]("banner"),
+
+		noopTrim[EnvironmentPodman]("portForwardingAllowed"),
+		func(v *EnvironmentPodman) (string, trimmer) { return "terminals", &v.Terminals },
+
+		noopTrim[EnvironmentPodman]("impPublishHost"),
+
+		noopTrim[EnvironmentPodman]("cleanOrphan"),
+	)
+}
+
+func (this *EnvironmentPodman) Validate() error {
+	return validate(this,
+		func(v *EnvironmentPodman) (string, validator) { return "loginAllowed", &v.LoginAllowed },
+
+		func(v *EnvironmentPodman) (string, validator) { return "host", &v.Host },
+
+		func(v *EnvironmentPodman) (string, validator) { return "image", &v.Image },
+		notZeroValidate("image", func(v *EnvironmentPodman) *template.String { return &v.Image }),
+		func(v *EnvironmentPodman) (string, validator) { return "imagePullPolicy", &v.ImagePullPolicy },
+		func(v *EnvironmentPodman) (string, validator) { return "imagePullCredentials", &v.ImagePullCredentials },
+		func(v *EnvironmentPodman) (string, validator) { return "pod", &v.Pod },
+		func(v *EnvironmentPodman) (string, validator) { return "networks", &v.Networks },
+		func(v *EnvironmentPodman) (string, validator) { return "volumes", &v.Volumes },
+		func(v *EnvironmentPodman) (string, validator) { return "capabilities", &v.Capabilities },
+		func(v *EnvironmentPodman) (string, validator) { return "privileged", &v.Privileged },
+		func(v *EnvironmentPodman) (string, validator) { return "dnsServers", &v.DnsServers },
+		func(v *EnvironmentPodman) (string, validator) { return "dnsSearch", &v.DnsSearch },
+		func(v *EnvironmentPodman) (string, validator) { return "shellCommand", &v.ShellCommand },
+		func(v *EnvironmentPodman) (string, validator) { return "execCommand", &v.ExecCommand },
+		func(v *EnvironmentPodman) (string, validator) { return "sftpCommand", &v.SftpCommand },
+		func(v *EnvironmentPodman) (string, validator) { return "directory", &v.Directory },
+		func(v *EnvironmentPodman) (string, validator) { return "user", &v.User },
+
+		func(v *EnvironmentPodman) (string, validator) { return "banner", &v.Banner },
+
+		func(v *EnvironmentPodman) (string, validator) {
+			return "portForwardingAllowed", &v.PortForwardingAllowed
+		},
+		func(v *EnvironmentPodman) (string, validator) { return "terminals", &v.Terminals },
+
+		func(v *EnvironmentPodman) (string, validator) { return "impPublishHost", &v.ImpPublishHost },
+
+		func(v *EnvironmentPodman) (string, validator) { return "cleanOrphan", &v.CleanOrphan },
+	)
+}
+
+func (this *EnvironmentPodman) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentPodman, node *yaml.Node) error {
+		type raw EnvironmentPodman
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentPodman) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentPodman:
+		return this.isEqualTo(&v)
+	case *EnvironmentPodman:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentPodman) isEqualTo(other *EnvironmentPodman) bool {
+	return isEqual(&this.LoginAllowed, &other.LoginAllowed) &&
+		isEqual(&this.Host, &other.Host) &&
+		isEqual(&this.Image, &other.Image) &&
+		isEqual(&this.ImagePullPolicy, &other.ImagePullPolicy) &&
+		isEqual(&this.ImagePullCredentials, &other.ImagePullCredentials) &&
+		isEqual(&this.Pod, &other.Pod) &&
+		isEqual(&this.Networks, &other.Networks) &&
+		isEqual(&this.Volumes, &other.Volumes) &&
+		isEqual(&this.Capabilities, &other.Capabilities) &&
+		isEqual(&this.Privileged, &other.Privileged) &&
+		isEqual(&this.DnsServers, &other.DnsServers) &&
+		isEqual(&this.DnsSearch, &other.DnsSearch) &&
+		isEqual(&this.ShellCommand, &other.ShellCommand) &&
+		isEqual(&this.ExecCommand, &other.ExecCommand) &&
+		isEqual(&this.SftpCommand, &other.SftpCommand) &&
+		isEqual(&this.Directory, &other.Directory) &&
+		isEqual(&this.User, &other.User) &&
+		isEqual(&this.Banner, &other.Banner) &&
+		isEqual(&this.PortForwardingAllowed, &other.PortForwardingAllowed) &&
+		isEqual(&this.Terminals, &other.Terminals) &&
+		isEqual(&this.ImpPublishHost, &other.ImpPublishHost) &&
+		isEqual(&this.CleanOrphan, &other.CleanOrphan)
+}
+
+func (this EnvironmentPodman) Types() []string {
+	return []string{"podman"}
+}
+
+func (this EnvironmentPodman) FeatureFlags() []string {
+	return []string{"podman"}
+}
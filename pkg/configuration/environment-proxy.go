@@ -0,0 +1,185 @@
+package configuration
+
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	DefaultEnvironmentProxyLoginAllowed = template.BoolOf(true)
+
+	DefaultEnvironmentProxyHost            = template.MustNewString("")
+	DefaultEnvironmentProxyPort            = template.Uint64Of(22)
+	DefaultEnvironmentProxyUser            = template.MustNewString("{{.remote.user}}")
+	DefaultEnvironmentProxyKnownHostsFiles = template.MustNewStrings(DefaultEnvironmentProxyKnownHostsLocation)
+	DefaultEnvironmentProxyConnectTimeout  = 30 * time.Second
+
+	DefaultEnvironmentProxyPrivateKeyFile           = template.MustNewString("")
+	DefaultEnvironmentProxyCertificateAuthorityFile = template.MustNewString("")
+	DefaultEnvironmentProxyCertificatePrincipals    = template.MustNewStrings()
+	DefaultEnvironmentProxyCertificateValidity      = template.DurationOf(5 * time.Minute)
+	DefaultEnvironmentProxyUseAgent                 = template.BoolOf(false)
+
+	DefaultEnvironmentProxyBanner                = template.MustNewString("")
+	DefaultEnvironmentProxyPortForwardingAllowed = template.BoolOf(true)
+
+	_ = RegisterEnvironmentV(func() EnvironmentV {
+		return &EnvironmentProxy{}
+	})
+)
+
+// EnvironmentProxy relays each session to an upstream SSH server, which
+// turns Bifröst into an authenticated bastion.
+type EnvironmentProxy struct {
+	LoginAllowed template.Bool `yaml:"loginAllowed,omitempty"`
+
+	Host template.String `yaml:"host"`
+	Port template.Uint64 `yaml:"port,omitempty"`
+	User template.String `yaml:"user,omitempty"`
+
+	// KnownHostsFiles are used to verify the host keys of the upstream
+	// server. Hosts which are not contained are rejected.
+	KnownHostsFiles template.Strings `yaml:"knownHostsFiles,omitempty"`
+	ConnectTimeout  time.Duration    `yaml:"connectTimeout,omitempty"`
+
+	// PrivateKeyFile is a private key which is used to authorize against
+	// the upstream server. It will be created if it does not exist, yet.
+	PrivateKeyFile template.String `yaml:"privateKeyFile,omitempty"`
+	// CertificateAuthorityFile is the private key of a CA which is used to
+	// sign a short living certificate for each connection to the upstream
+	// server.
+	CertificateAuthorityFile template.String   `yaml:"certificateAuthorityFile,omitempty"`
+	CertificatePrincipals    template.Strings  `yaml:"certificatePrincipals,omitempty"`
+	CertificateValidity      template.Duration `yaml:"certificateValidity,omitempty"`
+	// UseAgent enables the authorization against the upstream server using
+	// the agent which was forwarded by the client.
+	UseAgent template.Bool `yaml:"useAgent,omitempty"`
+
+	Banner template.String `yaml:"banner,omitempty"`
+
+	PortForwardingAllowed template.Bool `yaml:"portForwardingAllowed,omitempty"`
+}
+
+func (this *EnvironmentProxy) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("loginAllowed", func(v *EnvironmentProxy) *template.Bool { return &v.LoginAllowed }, DefaultEnvironmentProxyLoginAllowed),
+
+		fixedDefault("host", func(v *EnvironmentProxy) *template.String { return &v.Host }, DefaultEnvironmentProxyHost),
+		fixedDefault("port", func(v *EnvironmentProxy) *template.Uint64 { return &v.Port }, DefaultEnvironmentProxyPort),
+		fixedDefault("user", func(v *EnvironmentProxy) *template.String { return &v.User }, DefaultEnvironmentProxyUser),
+		fixedDefault("knownHostsFiles", func(v *EnvironmentProxy) *template.Strings { return &v.KnownHostsFiles }, DefaultEnvironmentProxyKnownHostsFiles),
+		fixedDefault("connectTimeout", func(v *EnvironmentProxy) *time.Duration { return &v.ConnectTimeout }, DefaultEnvironmentProxyConnectTimeout),
+
+		fixedDefault("privateKeyFile", func(v *EnvironmentProxy) *template.String { return &v.PrivateKeyFile }, DefaultEnvironmentProxyPrivateKeyFile),
+		fixedDefault("certificateAuthorityFile", func(v *EnvironmentProxy) *template.String { return &v.CertificateAuthorityFile }, DefaultEnvironmentProxyCertificateAuthorityFile),
+		fixedDefault("certificatePrincipals", func(v *EnvironmentProxy) *template.Strings { return &v.CertificatePrincipals }, DefaultEnvironmentProxyCertificatePrincipals),
+		fixedDefault("certificateValidity", func(v *EnvironmentProxy) *template.Duration { return &v.CertificateValidity }, DefaultEnvironmentProxyCertificateValidity),
+		fixedDefault("useAgent", func(v *EnvironmentProxy) *template.Bool { return &v.UseAgent }, DefaultEnvironmentProxyUseAgent),
+
+		fixedDefault("banner", func(v *EnvironmentProxy) *template.String { return &v.Banner }, DefaultEnvironmentProxyBanner),
+
+		fixedDefault("portForwardingAllowed", func(v *EnvironmentProxy) *template.Bool { return &v.PortForwardingAllowed }, DefaultEnvironmentProxyPortForwardingAllowed),
+	)
+}
+
+func (this *EnvironmentProxy) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentProxy]("loginAllowed"),
+
+		noopTrim[EnvironmentProxy]("host"),
+		noopTrim[EnvironmentProxy]("port"),
+		noopTrim[EnvironmentProxy]("user"),
+		noopTrim[EnvironmentProxy]("knownHostsFiles"),
+		noopTrim[EnvironmentProxy]("connectTimeout"),
+
+		noopTrim[EnvironmentProxy]("privateKeyFile"),
+		noopTrim[EnvironmentProxy]("certificateAuthorityFile"),
+		noopTrim[EnvironmentProxy]("certificatePrincipals"),
+		noopTrim[EnvironmentProxy]("certificateValidity"),
+		noopTrim[EnvironmentProxy]("useAgent"),
+
+		noopTrim[EnvironmentProxy]("banner"),
+
+		noopTrim[EnvironmentProxy]("portForwardingAllowed"),
+	)
+}
+
+func (this *EnvironmentProxy) Validate() error {
+	return validate(this,
+		func(v *EnvironmentProxy) (string, validator) { return "loginAllowed", &v.LoginAllowed },
+
+		func(v *EnvironmentProxy) (string, validator) { return "host", &v.Host },
+		notZeroValidate("host", func(v *EnvironmentProxy) *template.String { return &v.Host }),
+		func(v *EnvironmentProxy) (string, validator) { return "port", &v.Port },
+		notZeroValidate("port", func(v *EnvironmentProxy) *template.Uint64 { return &v.Port }),
+		func(v *EnvironmentProxy) (string, validator) { return "user", &v.User },
+		notZeroValidate("user", func(v *EnvironmentProxy) *template.String { return &v.User }),
+		func(v *EnvironmentProxy) (string, validator) { return "knownHostsFiles", &v.KnownHostsFiles },
+		notZeroValidate("knownHostsFiles", func(v *EnvironmentProxy) *template.Strings { return &v.KnownHostsFiles }),
+		noopValidate[EnvironmentProxy]("connectTimeout"),
+
+		func(v *EnvironmentProxy) (string, validator) { return "privateKeyFile", &v.PrivateKeyFile },
+		func(v *EnvironmentProxy) (string, validator) {
+			return "certificateAuthorityFile", &v.CertificateAuthorityFile
+		},
+		func(v *EnvironmentProxy) (string, validator) {
+			return "certificatePrincipals", &v.CertificatePrincipals
+		},
+		func(v *EnvironmentProxy) (string, validator) { return "certificateValidity", &v.CertificateValidity },
+		func(v *EnvironmentProxy) (string, validator) { return "useAgent", &v.UseAgent },
+
+		func(v *EnvironmentProxy) (string, validator) { return "banner", &v.Banner },
+
+		func(v *EnvironmentProxy) (string, validator) {
+			return "portForwardingAllowed", &v.PortForwardingAllowed
+		},
+	)
+}
+
+func (this *EnvironmentProxy) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentProxy, node *yaml.Node) error {
+		type raw EnvironmentProxy
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentProxy) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentProxy:
+		return this.isEqualTo(&v)
+	case *EnvironmentProxy:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentProxy) isEqualTo(other *EnvironmentProxy) bool {
+	return isEqual(&this.LoginAllowed, &other.LoginAllowed) &&
+		isEqual(&this.Host, &other.Host) &&
+		isEqual(&this.Port, &other.Port) &&
+		isEqual(&this.User, &other.User) &&
+		isEqual(&this.KnownHostsFiles, &other.KnownHostsFiles) &&
+		this.ConnectTimeout == other.ConnectTimeout &&
+		isEqual(&this.PrivateKeyFile, &other.PrivateKeyFile) &&
+		isEqual(&this.CertificateAuthorityFile, &other.CertificateAuthorityFile) &&
+		isEqual(&this.CertificatePrincipals, &other.CertificatePrincipals) &&
+		isEqual(&this.CertificateValidity, &other.CertificateValidity) &&
+		isEqual(&this.UseAgent, &other.UseAgent) &&
+		isEqual(&this.Banner, &other.Banner) &&
+		isEqual(&this.PortForwardingAllowed, &other.PortForwardingAllowed)
+}
+
+func (this EnvironmentProxy) Types() []string {
+	return []string{"proxy"}
+}
+
+func (this EnvironmentProxy) FeatureFlags() []string {
+	return []string{"proxy"}
+}
@@ -0,0 +1,7 @@
+//go:build unix
+
+package configuration
+
+const (
+	DefaultEnvironmentProxyKnownHostsLocation = "/etc/engity/bifroest/known_hosts"
+)
@@ -0,0 +1,7 @@
+//go:build windows
+
+package configuration
+
+const (
+	DefaultEnvironmentProxyKnownHostsLocation = `C:\ProgramData\Engity\Bifroest\known_hosts`
+)
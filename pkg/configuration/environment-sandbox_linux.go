@@ -0,0 +1,340 @@
+//go:build linux
+
+package configuration
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	DefaultEnvironmentSandboxLoginAllowed = template.BoolOf(true)
+
+	DefaultEnvironmentSandboxStateDirectory = "/var/lib/engity/bifroest/sandboxes"
+
+	DefaultEnvironmentSandboxRootFilesystem   = template.MustNewString("/")
+	DefaultEnvironmentSandboxOverlay          = template.BoolOf(true)
+	DefaultEnvironmentSandboxBindMounts       = template.MustNewStrings()
+	DefaultEnvironmentSandboxTmpfs            = template.MustNewStrings("/tmp", "/var/tmp", "/dev/shm", "/run:0755", "/etc/engity:0700", "/var/lib/engity:0700")
+	DefaultEnvironmentSandboxHostname         = template.MustNewString("sandbox")
+	DefaultEnvironmentSandboxShareHostNetwork = template.BoolOf(false)
+
+	DefaultEnvironmentSandboxHostUid         = uint32(100000)
+	DefaultEnvironmentSandboxHostGid         = uint32(100000)
+	DefaultEnvironmentSandboxIdMappingSize   = uint32(65536)
+	DefaultEnvironmentSandboxHostRootAllowed = false
+
+	DefaultEnvironmentSandboxCapabilities = template.MustNewStrings(
+		"CAP_CHOWN",
+		"CAP_DAC_OVERRIDE",
+		"CAP_FOWNER",
+		"CAP_FSETID",
+		"CAP_KILL",
+		"CAP_SETGID",
+		"CAP_SETUID",
+		"CAP_SETPCAP",
+		"CAP_NET_BIND_SERVICE",
+		"CAP_NET_RAW",
+		"CAP_SYS_CHROOT",
+		"CAP_AUDIT_WRITE",
+		"CAP_SETFCAP",
+	)
+	DefaultEnvironmentSandboxDeniedSyscalls = template.MustNewStrings(
+		"acct",
+		"add_key",
+		"bpf",
+		"clock_adjtime",
+		"clock_settime",
+		"create_module",
+		"delete_module",
+		"finit_module",
+		"fsconfig",
+		"fsmount",
+		"fsopen",
+		"fspick",
+		"get_kernel_syms",
+		"init_module",
+		"ioperm",
+		"iopl",
+		"kexec_file_load",
+		"kexec_load",
+		"keyctl",
+		"lookup_dcookie",
+		"mount",
+		"mount_setattr",
+		"move_mount",
+		"name_to_handle_at",
+		"nfsservctl",
+		"open_by_handle_at",
+		"open_tree",
+		"perf_event_open",
+		"pivot_root",
+		"process_vm_readv",
+		"process_vm_writev",
+		"quotactl",
+		"reboot",
+		"request_key",
+		"setns",
+		"settimeofday",
+		"swapoff",
+		"swapon",
+		"_sysctl",
+		"sysfs",
+		"umount2",
+		"unshare",
+		"uselib",
+		"userfaultfd",
+		"ustat",
+	)
+
+	DefaultEnvironmentSandboxShellCommand = template.MustNewStrings("/bin/sh")
+	DefaultEnvironmentSandboxExecCommand  = template.MustNewStrings("/bin/sh", "-c")
+	DefaultEnvironmentSandboxSftpCommand  = template.MustNewStrings("/run/bifroest/bifroest", "sftp-server")
+	DefaultEnvironmentSandboxDirectory    = template.MustNewString("")
+	DefaultEnvironmentSandboxUser         = template.MustNewString("")
+
+	DefaultEnvironmentSandboxBanner                = template.MustNewString("")
+	DefaultEnvironmentSandboxPortForwardingAllowed = template.BoolOf(true)
+
+	DefaultEnvironmentSandboxCleanOrphan = template.BoolOf(true)
+
+	_ = RegisterEnvironmentV(func() EnvironmentV {
+		return &EnvironmentSandbox{}
+	})
+)
+
+// EnvironmentSandbox runs each session inside its own set of Linux
+// namespaces (user, mount, pid, network and uts) without requiring any
+// container runtime.
+type EnvironmentSandbox struct {
+	LoginAllowed template.Bool `yaml:"loginAllowed,omitempty"`
+
+	// StateDirectory is the directory where the state of each sandbox (like
+	// the upper directory of the overlay and the sockets to communicate
+	// with it) is stored.
+	StateDirectory string `yaml:"stateDirectory,omitempty"`
+
+	RootFilesystem   template.String  `yaml:"rootFilesystem,omitempty"`
+	Overlay          template.Bool    `yaml:"overlay,omitempty"`
+	BindMounts       template.Strings `yaml:"bindMounts,omitempty"`
+	Tmpfs            template.Strings `yaml:"tmpfs,omitempty"`
+	Hostname         template.String  `yaml:"hostname,omitempty"`
+	ShareHostNetwork template.Bool    `yaml:"shareHostNetwork,omitempty"`
+
+	// HostUid is the uid of the host which the root user inside the sandbox
+	// is mapped to. Only respected if Bifröst runs as root.
+	HostUid uint32 `yaml:"hostUid,omitempty"`
+	// HostGid is the gid of the host which the root group inside the
+	// sandbox is mapped to. Only respected if Bifröst runs as root.
+	HostGid uint32 `yaml:"hostGid,omitempty"`
+	// IdMappingSize is the amount of uids and gids which are mapped into the
+	// sandbox. Only respected if Bifröst runs as root.
+	IdMappingSize uint32 `yaml:"idMappingSize,omitempty"`
+	// HostRootAllowed allows to map the root user or group inside the
+	// sandbox (HostUid, HostGid) to the root of the host. In this case the
+	// users inside the sandbox have the same file permissions as the root of
+	// the host.
+	HostRootAllowed bool `yaml:"hostRootAllowed,omitempty"`
+
+	Capabilities   template.Strings `yaml:"capabilities,omitempty"`
+	DeniedSyscalls template.Strings `yaml:"deniedSyscalls,omitempty"`
+
+	ShellCommand template.Strings `yaml:"shellCommand,omitempty"`
+	ExecCommand  template.Strings `yaml:"execCommand,omitempty"`
+	SftpCommand  template.Strings `yaml:"sftpCommand,omitempty"`
+	Directory    template.String  `yaml:"directory"`
+	User         template.String  `yaml:"user,omitempty"`
+
+	Banner template.String `yaml:"banner,omitempty"`
+
+	PortForwardingAllowed template.Bool `yaml:"portForwardingAllowed,omitempty"`
+
+	Terminals EnvironmentTerminals `yaml:"terminals,omitempty"`
+
+	CleanOrphan template.Bool `yaml:"cleanOrphan,omitempty"`
+}
+
+func (this *EnvironmentSandbox) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("loginAllowed", func(v *EnvironmentSandbox) *template.Bool { return &v.LoginAllowed }, DefaultEnvironmentSandboxLoginAllowed),
+
+		fixedDefault("stateDirectory", func(v *EnvironmentSandbox) *string { return &v.StateDirectory }, DefaultEnvironmentSandboxStateDirectory),
+
+		fixedDefault("rootFilesystem", func(v *EnvironmentSandbox) *template.String { return &v.RootFilesystem }, DefaultEnvironmentSandboxRootFilesystem),
+		fixedDefault("overlay", func(v *EnvironmentSandbox) *template.Bool { return &v.Overlay }, DefaultEnvironmentSandboxOverlay),
+		fixedDefault("bindMounts", func(v *EnvironmentSandbox) *template.Strings { return &v.BindMounts }, DefaultEnvironmentSandboxBindMounts),
+		fixedDefault("tmpfs", func(v *EnvironmentSandbox) *template.Strings { return &v.Tmpfs }, DefaultEnvironmentSandboxTmpfs),
+		fixedDefault("hostname", func(v *EnvironmentSandbox) *template.String { return &v.Hostname }, DefaultEnvironmentSandboxHostname),
+		fixedDefault("shareHostNetwork", func(v *EnvironmentSandbox) *template.Bool { return &v.ShareHostNetwork }, DefaultEnvironmentSandboxShareHostNetwork),
+
+		fixedDefault("hostUid", func(v *EnvironmentSandbox) *uint32 { return &v.HostUid }, DefaultEnvironmentSandboxHostUid),
+		fixedDefault("hostGid", func(v *EnvironmentSandbox) *uint32 { return &v.HostGid }, DefaultEnvironmentSandboxHostGid),
+		fixedDefault("idMappingSize", func(v *EnvironmentSandbox) *uint32 { return &v.IdMappingSize }, DefaultEnvironmentSandboxIdMappingSize),
+		fixedDefault("hostRootAllowed", func(v *EnvironmentSandbox) *bool { return &v.HostRootAllowed }, DefaultEnvironmentSandboxHostRootAllowed),
+
+		fixedDefault("capabilities", func(v *EnvironmentSandbox) *template.Strings { return &v.Capabilities }, DefaultEnvironmentSandboxCapabilities),
+		fixedDefault("deniedSyscalls", func(v *EnvironmentSandbox) *template.Strings { return &v.DeniedSyscalls }, DefaultEnvironmentSandboxDeniedSyscalls),
+
+		fixedDefault("shellCommand", func(v *EnvironmentSandbox) *template.Strings { return &v.ShellCommand }, DefaultEnvironmentSandboxShellCommand),
+		fixedDefault("execCommand", func(v *EnvironmentSandbox) *template.Strings { return &v.ExecCommand }, DefaultEnvironmentSandboxExecCommand),
+		fixedDefault("sftpCommand", func(v *EnvironmentSandbox) *template.Strings { return &v.SftpCommand }, DefaultEnvironmentSandboxSftpCommand),
+		fixedDefault("directory", func(v *EnvironmentSandbox) *template.String { return &v.Directory }, DefaultEnvironmentSandboxDirectory),
+		fixedDefault("user", func(v *EnvironmentSandbox) *template.String { return &v.User }, DefaultEnvironmentSandboxUser),
+
+		fixedDefault("banner", func(v *EnvironmentSandbox) *template.String { return &v.Banner }, DefaultEnvironmentSandboxBanner),
+
+		fixedDefault("portForwardingAllowed", func(v *EnvironmentSandbox) *template.Bool { return &v.PortForwardingAllowed }, DefaultEnvironmentSandboxPortForwardingAllowed),
+		func(v *EnvironmentSandbox) (string, defaulter) { return "terminals", &v.Terminals },
+
+		fixedDefault("cleanOrphan", func(v *EnvironmentSandbox) *template.Bool { return &v.CleanOrphan }, DefaultEnvironmentSandboxCleanOrphan),
+	)
+}
+
+func (this *EnvironmentSandbox) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentSandbox]("loginAllowed"),
+
+		noopTrim[EnvironmentSandbox]("stateDirectory"),
+
+		noopTrim[EnvironmentSandbox]("rootFilesystem"),
+		noopTrim[EnvironmentSandbox]("overlay"),
+		noopTrim[EnvironmentSandbox]("bindMounts"),
+		noopTrim[EnvironmentSandbox]("tmpfs"),
+		noopTrim[EnvironmentSandbox]("hostname"),
+		noopTrim[EnvironmentSandbox]("shareHostNetwork"),
+
+		noopTrim[EnvironmentSandbox]("hostUid"),
+		noopTrim[EnvironmentSandbox]("hostGid"),
+		noopTrim[EnvironmentSandbox]("idMappingSize"),
+		noopTrim[EnvironmentSandbox]("hostRootAllowed"),
+
+		noopTrim[EnvironmentSandbox]("capabilities"),
+		noopTrim[EnvironmentSandbox]("deniedSyscalls"),
+
+		noopTrim[EnvironmentSandbox]("shellCommand"),
+		noopTrim[EnvironmentSandbox]("execCommand"),
+		noopTrim[EnvironmentSandbox]("sftpCommand"),
+		noopTrim[EnvironmentSandbox]("directory"),
+		noopTrim[EnvironmentSandbox]("user"),
+
+		noopTrim[EnvironmentSandbox]("banner"),
+
+		noopTrim[EnvironmentSandbox]("portForwardingAllowed"),
+		func(v *EnvironmentSandbox) (string, trimmer) { return "terminals", &v.Terminals },
+
+		noopTrim[EnvironmentSandbox]("cleanOrphan"),
+	)
+}
+
+func (this *EnvironmentSandbox) Validate() error {
+	return validate(this,
+		func(v *EnvironmentSandbox) (string, validator) { return "loginAllowed", &v.LoginAllowed },
+
+		noopValidate[EnvironmentSandbox]("stateDirectory"),
+
+		func(v *EnvironmentSandbox) (string, validator) { return "rootFilesystem", &v.RootFilesystem },
+		notZeroValidate("rootFilesystem", func(v *EnvironmentSandbox) *template.String { return &v.RootFilesystem }),
+		func(v *EnvironmentSandbox) (string, validator) { return "overlay", &v.Overlay },
+		func(v *EnvironmentSandbox) (string, validator) { return "bindMounts", &v.BindMounts },
+		func(v *EnvironmentSandbox) (string, validator) { return "tmpfs", &v.Tmpfs },
+		func(v *EnvironmentSandbox) (string, validator) { return "hostname", &v.Hostname },
+		func(v *EnvironmentSandbox) (string, validator) { return "shareHostNetwork", &v.ShareHostNetwork },
+
+		func(v *EnvironmentSandbox) (string, validator) {
+			return "hostUid", validatorFunc(func() error {
+				if v.HostUid == 0 && !v.HostRootAllowed {
+					return fmt.Errorf("the root user of the host is only allowed if hostRootAllowed is true")
+				}
+				return nil
+			})
+		},
+		func(v *EnvironmentSandbox) (string, validator) {
+			return "hostGid", validatorFunc(func() error {
+				if v.HostGid == 0 && !v.HostRootAllowed {
+					return fmt.Errorf("the root group of the host is only allowed if hostRootAllowed is true")
+				}
+				return nil
+			})
+		},
+		noopValidate[EnvironmentSandbox]("idMappingSize"),
+		noopValidate[EnvironmentSandbox]("hostRootAllowed"),
+
+		func(v *EnvironmentSandbox) (string, validator) { return "capabilities", &v.Capabilities },
+		func(v *EnvironmentSandbox) (string, validator) { return "deniedSyscalls", &v.DeniedSyscalls },
+
+		func(v *EnvironmentSandbox) (string, validator) { return "shellCommand", &v.ShellCommand },
+		notZeroValidate("shellCommand", func(v *EnvironmentSandbox) *template.Strings { return &v.ShellCommand }),
+		func(v *EnvironmentSandbox) (string, validator) { return "execCommand", &v.ExecCommand },
+		notZeroValidate("execCommand", func(v *EnvironmentSandbox) *template.Strings { return &v.ExecCommand }),
+		func(v *EnvironmentSandbox) (string, validator) { return "sftpCommand", &v.SftpCommand },
+		func(v *EnvironmentSandbox) (string, validator) { return "directory", &v.Directory },
+		func(v *EnvironmentSandbox) (string, validator) { return "user", &v.User },
+
+		func(v *EnvironmentSandbox) (string, validator) { return "banner", &v.Banner },
+
+		func(v *EnvironmentSandbox) (string, validator) {
+			return "portForwardingAllowed", &v.PortForwardingAllowed
+		},
+		func(v *EnvironmentSandbox) (string, validator) { return "terminals", &v.Terminals },
+
+		func(v *EnvironmentSandbox) (string, validator) { return "cleanOrphan", &v.CleanOrphan },
+	)
+}
+
+func (this *EnvironmentSandbox) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentSandbox, node *yaml.Node) error {
+		type raw EnvironmentSandbox
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentSandbox) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentSandbox:
+		return this.isEqualTo(&v)
+	case *EnvironmentSandbox:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentSandbox) isEqualTo(other *EnvironmentSandbox) bool {
+	return isEqual(&this.LoginAllowed, &other.LoginAllowed) &&
+		this.StateDirectory == other.StateDirectory &&
+		isEqual(&this.RootFilesystem, &other.RootFilesystem) &&
+		isEqual(&this.Overlay, &other.Overlay) &&
+		isEqual(&this.BindMounts, &other.BindMounts) &&
+		isEqual(&this.Tmpfs, &other.Tmpfs) &&
+		isEqual(&this.Hostname, &other.Hostname) &&
+		isEqual(&this.ShareHostNetwork, &other.ShareHostNetwork) &&
+		this.HostUid == other.HostUid &&
+		this.HostGid == other.HostGid &&
+		this.IdMappingSize == other.IdMappingSize &&
+		this.HostRootAllowed == other.HostRootAllowed &&
+		isEqual(&this.Capabilities, &other.Capabilities) &&
+		isEqual(&this.DeniedSyscalls, &other.DeniedSyscalls) &&
+		isEqual(&this.ShellCommand, &other.ShellCommand) &&
+		isEqual(&this.ExecCommand, &other.ExecCommand) &&
+		isEqual(&this.SftpCommand, &other.SftpCommand) &&
+		isEqual(&this.Directory, &other.Directory) &&
+		isEqual(&this.User, &other.User) &&
+		isEqual(&this.Banner, &other.Banner) &&
+		isEqual(&this.PortForwardingAllowed, &other.PortForwardingAllowed) &&
+		isEqual(&this.Terminals, &other.Terminals) &&
+		isEqual(&this.CleanOrphan, &other.CleanOrphan)
+}
+
+func (this EnvironmentSandbox) Types() []string {
+	return []string{"sandbox"}
+}
+
+func (this EnvironmentSandbox) FeatureFlags() []string {
+	return []string{"sandbox"}
+}
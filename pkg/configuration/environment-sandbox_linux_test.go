@@ -0,0 +1,55 @@
+//go:build linux
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/echocat/slf4g/sdk/testlog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentSandbox_SetDefaults_idMapping(t *testing.T) {
+	var actual EnvironmentSandbox
+	require.NoError(t, actual.SetDefaults())
+	require.NoError(t, actual.Validate())
+
+	// The root of the sandbox must never be the root of the host by default;
+	// otherwise it could read everything of the host, like /etc/shadow.
+	require.NotZero(t, actual.HostUid)
+	require.NotZero(t, actual.HostGid)
+	require.False(t, actual.HostRootAllowed)
+}
+
+func TestEnvironmentSandbox_UnmarshalYAML_hostRoot(t *testing.T) {
+	testlog.Hook(t)
+
+	expectedHostRoot := func() EnvironmentSandbox {
+		var result EnvironmentSandbox
+		require.NoError(t, result.SetDefaults())
+		result.HostUid = 0
+		result.HostGid = 0
+		result.HostRootAllowed = true
+		return result
+	}
+
+	runUnmarshalYamlTests(t,
+		unmarshalYamlTestCase[EnvironmentSandbox]{
+			name:          "host-root-user",
+			yaml:          `hostUid: 0`,
+			expectedError: `[hostUid] the root user of the host is only allowed if hostRootAllowed is true`,
+		},
+		unmarshalYamlTestCase[EnvironmentSandbox]{
+			name:          "host-root-group",
+			yaml:          `hostGid: 0`,
+			expectedError: `[hostGid] the root group of the host is only allowed if hostRootAllowed is true`,
+		},
+		unmarshalYamlTestCase[EnvironmentSandbox]{
+			name: "host-root-allowed",
+			yaml: `hostUid: 0
+hostGid: 0
+hostRootAllowed: true`,
+			expected: expectedHostRoot(),
+		},
+	)
+}
@@ -0,0 +1,101 @@
+package configuration
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	// DefaultEnvironmentTerminalsPersistent is the default setting for EnvironmentTerminals.Persistent.
+	DefaultEnvironmentTerminalsPersistent = template.BoolOf(false)
+
+	// DefaultEnvironmentTerminalsIdleTimeout is the default setting for EnvironmentTerminals.IdleTimeout.
+	DefaultEnvironmentTerminalsIdleTimeout = common.DurationOf(time.Hour)
+
+	// DefaultEnvironmentTerminalsScrollback is the default setting for EnvironmentTerminals.Scrollback.
+	DefaultEnvironmentTerminalsScrollback = uint32(64 * 1024)
+)
+
+// EnvironmentTerminals defines how interactive shells are run inside
+// environments which are managed by an imp (like EnvironmentDocker and
+// EnvironmentKubernetes).
+type EnvironmentTerminals struct {
+	// Persistent, if true, runs each interactive shell inside a terminal
+	// managed by the imp. Such a terminal survives the end of its connection
+	// (it becomes detached) and can be reattached by another connection of
+	// the same session. Defaults to DefaultEnvironmentTerminalsPersistent.
+	Persistent template.Bool `yaml:"persistent,omitempty"`
+
+	// IdleTimeout defines for how long a terminal can be detached before it is
+	// terminated. 0 means it is kept until the environment is disposed.
+	// Defaults to DefaultEnvironmentTerminalsIdleTimeout.
+	IdleTimeout common.Duration `yaml:"idleTimeout,omitempty"`
+
+	// Scrollback defines how many bytes of the most recent output of a
+	// terminal are kept to be replayed once it is reattached. Defaults to
+	// DefaultEnvironmentTerminalsScrollback.
+	Scrollback uint32 `yaml:"scrollback,omitempty"`
+}
+
+func (this *EnvironmentTerminals) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("persistent", func(v *EnvironmentTerminals) *template.Bool { return &v.Persistent }, DefaultEnvironmentTerminalsPersistent),
+		fixedDefault("idleTimeout", func(v *EnvironmentTerminals) *common.Duration { return &v.IdleTimeout }, DefaultEnvironmentTerminalsIdleTimeout),
+		fixedDefault("scrollback", func(v *EnvironmentTerminals) *uint32 { return &v.Scrollback }, DefaultEnvironmentTerminalsScrollback),
+	)
+}
+
+func (this *EnvironmentTerminals) Trim() error {
+	return trim(this,
+		noopTrim[EnvironmentTerminals]("persistent"),
+		noopTrim[EnvironmentTerminals]("idleTimeout"),
+		noopTrim[EnvironmentTerminals]("scrollback"),
+	)
+}
+
+func (this *EnvironmentTerminals) Validate() error {
+	return validate(this,
+		func(v *EnvironmentTerminals) (string, validator) { return "persistent", &v.Persistent },
+		func(v *EnvironmentTerminals) (string, validator) {
+			return "idleTimeout", validatorFunc(func() error {
+				if v.IdleTimeout.Native() < 0 {
+					return fmt.Errorf("cannot be negative, but got: %v", v.IdleTimeout)
+				}
+				return nil
+			})
+		},
+		noopValidate[EnvironmentTerminals]("scrollback"),
+	)
+}
+
+func (this *EnvironmentTerminals) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EnvironmentTerminals, node *yaml.Node) error {
+		type raw EnvironmentTerminals
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EnvironmentTerminals) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EnvironmentTerminals:
+		return this.isEqualTo(&v)
+	case *EnvironmentTerminals:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EnvironmentTerminals) isEqualTo(other *EnvironmentTerminals) bool {
+	return isEqual(&this.Persistent, &other.Persistent) &&
+		isEqual(&this.IdleTimeout, &other.IdleTimeout) &&
+		this.Scrollback == other.Scrollback
+}
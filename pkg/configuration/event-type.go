@@ -0,0 +1,133 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+// EventType identifies a lifecycle event of the service which can be
+// delivered to event sinks. See Events.
+type EventType uint8
+
+const (
+	EventTypeLoginSucceeded EventType = iota
+	EventTypeLoginFailed
+	EventTypeSessionNew
+	EventTypeSessionRestored
+	EventTypeSessionDisposed
+	EventTypeEnvironmentCreated
+	EventTypeEnvironmentRemoved
+	EventTypeCommandStarted
+	EventTypeCommandEnded
+	EventTypeShareStarted
+	EventTypeShareEnded
+	EventTypeShareJoined
+	EventTypeShareLeft
+	EventTypePortForwardingRejected
+)
+
+var (
+	eventTypeToName = map[EventType]string{
+		EventTypeLoginSucceeded:         "loginSucceeded",
+		EventTypeLoginFailed:            "loginFailed",
+		EventTypeSessionNew:             "sessionNew",
+		EventTypeSessionRestored:        "sessionRestored",
+		EventTypeSessionDisposed:        "sessionDisposed",
+		EventTypeEnvironmentCreated:     "environmentCreated",
+		EventTypeEnvironmentRemoved:     "environmentRemoved",
+		EventTypeCommandStarted:         "commandStarted",
+		EventTypeCommandEnded:           "commandEnded",
+		EventTypeShareStarted:           "shareStarted",
+		EventTypeShareEnded:             "shareEnded",
+		EventTypeShareJoined:            "shareJoined",
+		EventTypeShareLeft:              "shareLeft",
+		EventTypePortForwardingRejected: "portForwardingRejected",
+	}
+	nameToEventType = func(in map[EventType]string) map[string]EventType {
+		result := make(map[string]EventType, len(in))
+		for k, v := range in {
+			result[v] = k
+		}
+		return result
+	}(eventTypeToName)
+)
+
+func (this EventType) MarshalText() (text []byte, err error) {
+	v, ok := eventTypeToName[this]
+	if !ok {
+		return nil, errors.Config.Newf("illegal event type: %d", this)
+	}
+	return []byte(v), nil
+}
+
+func (this EventType) String() string {
+	v, ok := eventTypeToName[this]
+	if !ok {
+		return fmt.Sprintf("illegal-event-type-%d", this)
+	}
+	return v
+}
+
+func (this *EventType) UnmarshalText(text []byte) error {
+	v, ok := nameToEventType[string(text)]
+	if !ok {
+		return errors.Config.Newf("illegal event type: %s", string(text))
+	}
+	*this = v
+	return nil
+}
+
+func (this *EventType) Set(text string) error {
+	return this.UnmarshalText([]byte(text))
+}
+
+func (this EventType) Validate() error {
+	_, err := this.MarshalText()
+	return err
+}
+
+func (this EventType) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EventType:
+		return this == v
+	case *EventType:
+		return this == *v
+	default:
+		return false
+	}
+}
+
+// EventTypes is a set of EventType. An empty set matches every EventType.
+type EventTypes []EventType
+
+func (this EventTypes) Matches(t EventType) bool {
+	return len(this) == 0 || slices.Contains(this, t)
+}
+
+func (this EventTypes) Validate() error {
+	for i, v := range this {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("[%d] %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (this EventTypes) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EventTypes:
+		return slices.Equal(this, v)
+	case *EventTypes:
+		return slices.Equal(this, *v)
+	default:
+		return false
+	}
+}
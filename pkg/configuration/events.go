@@ -0,0 +1,330 @@
+package configuration
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	DefaultEventWebhookMethod      = "POST"
+	DefaultEventWebhookFlow        = common.MustNewRegexp(`.*`)
+	DefaultEventWebhookContentType = "application/json"
+	DefaultEventWebhookPayload     = template.MustNewString("{{ toJson . }}")
+	DefaultEventWebhookTimeout     = common.DurationOf(10 * time.Second)
+	DefaultEventWebhookRetries     = uint8(5)
+	DefaultEventWebhookRetryDelay  = common.DurationOf(2 * time.Second)
+)
+
+// Events defines where lifecycle events (like logins, new sessions or executed
+// commands) of the service are delivered to.
+type Events struct {
+	// Spool is a directory where events are stored which cannot be delivered
+	// (yet). They will be delivered again later, also after a restart of the
+	// service. If empty, such events are dropped.
+	Spool string `yaml:"spool,omitempty"`
+
+	// Webhooks to which events are delivered via HTTP.
+	Webhooks EventWebhooks `yaml:"webhooks,omitempty"`
+}
+
+func (this *Events) SetDefaults() error {
+	return setDefaults(this,
+		noopSetDefault[Events]("spool"),
+		noopSetDefault[Events]("webhooks"),
+	)
+}
+
+func (this *Events) Trim() error {
+	return trim(this,
+		func(v *Events) (string, trimmer) { return "spool", &stringTrimmer{&v.Spool} },
+		func(v *Events) (string, trimmer) { return "webhooks", &v.Webhooks },
+	)
+}
+
+func (this *Events) Validate() error {
+	return validate(this,
+		noopValidate[Events]("spool"),
+		func(v *Events) (string, validator) { return "webhooks", &v.Webhooks },
+	)
+}
+
+func (this *Events) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *Events, node *yaml.Node) error {
+		type raw Events
+		return node.Decode((*raw)(target))
+	})
+}
+
+// IsEnabled returns true if there is at least one sink configured.
+func (this Events) IsEnabled() bool {
+	return len(this.Webhooks) > 0
+}
+
+func (this Events) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case Events:
+		return this.isEqualTo(&v)
+	case *Events:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this Events) isEqualTo(other *Events) bool {
+	return this.Spool == other.Spool &&
+		isEqual(&this.Webhooks, &other.Webhooks)
+}
+
+// EventWebhook delivers events via HTTP requests to a remote endpoint.
+type EventWebhook struct {
+	// Name identifies this webhook inside logs and the Events.Spool.
+	Name string `yaml:"name"`
+
+	// Url the events are delivered to.
+	Url string `yaml:"url"`
+
+	// Method of the HTTP request. Defaults to DefaultEventWebhookMethod.
+	Method string `yaml:"method,omitempty"`
+
+	// Headers which are sent together with each request.
+	Headers EventWebhookHeaders `yaml:"headers,omitempty"`
+
+	// Types of events which should be delivered. If empty, all are delivered.
+	Types EventTypes `yaml:"types,omitempty"`
+
+	// Flow restricts the delivered events to the flows matching this
+	// expression. Events without a flow (like failed logins) always match.
+	// Defaults to DefaultEventWebhookFlow.
+	Flow common.Regexp `yaml:"flow,omitempty"`
+
+	// ContentType of the rendered Payload. Defaults to DefaultEventWebhookContentType.
+	ContentType string `yaml:"contentType,omitempty"`
+
+	// Payload is the body of the HTTP request. Defaults to DefaultEventWebhookPayload.
+	Payload template.String `yaml:"payload,omitempty"`
+
+	// Timeout of each single HTTP request. Defaults to DefaultEventWebhookTimeout.
+	Timeout common.Duration `yaml:"timeout,omitempty"`
+
+	// Retries defines how often a failed delivery is retried, before it is
+	// moved to the Events.Spool. Defaults to DefaultEventWebhookRetries.
+	Retries uint8 `yaml:"retries,omitempty"`
+
+	// RetryDelay is the initial delay between two retries. It doubles with
+	// each retry. Defaults to DefaultEventWebhookRetryDelay.
+	RetryDelay common.Duration `yaml:"retryDelay,omitempty"`
+}
+
+func (this *EventWebhook) SetDefaults() error {
+	return setDefaults(this,
+		noopSetDefault[EventWebhook]("name"),
+		noopSetDefault[EventWebhook]("url"),
+		fixedDefault("method", func(v *EventWebhook) *string { return &v.Method }, DefaultEventWebhookMethod),
+		noopSetDefault[EventWebhook]("headers"),
+		noopSetDefault[EventWebhook]("types"),
+		fixedDefault("flow", func(v *EventWebhook) *common.Regexp { return &v.Flow }, DefaultEventWebhookFlow),
+		fixedDefault("contentType", func(v *EventWebhook) *string { return &v.ContentType }, DefaultEventWebhookContentType),
+		fixedDefault("payload", func(v *EventWebhook) *template.String { return &v.Payload }, DefaultEventWebhookPayload),
+		fixedDefault("timeout", func(v *EventWebhook) *common.Duration { return &v.Timeout }, DefaultEventWebhookTimeout),
+		fixedDefault("retries", func(v *EventWebhook) *uint8 { return &v.Retries }, DefaultEventWebhookRetries),
+		fixedDefault("retryDelay", func(v *EventWebhook) *common.Duration { return &v.RetryDelay }, DefaultEventWebhookRetryDelay),
+	)
+}
+
+func (this *EventWebhook) Trim() error {
+	return trim(this,
+		func(v *EventWebhook) (string, trimmer) { return "name", &stringTrimmer{&v.Name} },
+		func(v *EventWebhook) (string, trimmer) { return "url", &stringTrimmer{&v.Url} },
+		func(v *EventWebhook) (string, trimmer) { return "method", &stringTrimmer{&v.Method} },
+		noopTrim[EventWebhook]("headers"),
+		noopTrim[EventWebhook]("types"),
+		noopTrim[EventWebhook]("flow"),
+		func(v *EventWebhook) (string, trimmer) { return "contentType", &stringTrimmer{&v.ContentType} },
+		noopTrim[EventWebhook]("payload"),
+		noopTrim[EventWebhook]("timeout"),
+		noopTrim[EventWebhook]("retries"),
+		noopTrim[EventWebhook]("retryDelay"),
+	)
+}
+
+func (this *EventWebhook) Validate() error {
+	return validate(this,
+		notEmptyStringValidate("name", func(v *EventWebhook) *string { return &v.Name }),
+		func(v *EventWebhook) (string, validator) {
+			return "name", validatorFunc(func() error {
+				if strings.ContainsAny(v.Name, `/\:*?"<>|`) {
+					return fmt.Errorf("illegal name %q: must not contain any of /\\:*?\"<>|", v.Name)
+				}
+				return nil
+			})
+		},
+		notEmptyStringValidate("url", func(v *EventWebhook) *string { return &v.Url }),
+		func(v *EventWebhook) (string, validator) {
+			return "url", validatorFunc(func() error {
+				u, err := url.Parse(v.Url)
+				if err != nil {
+					return fmt.Errorf("illegal url: %w", err)
+				}
+				if u.Scheme != "http" && u.Scheme != "https" {
+					return fmt.Errorf("illegal url %q: only http and https are supported", v.Url)
+				}
+				return nil
+			})
+		},
+		notEmptyStringValidate("method", func(v *EventWebhook) *string { return &v.Method }),
+		func(v *EventWebhook) (string, validator) { return "headers", &v.Headers },
+		func(v *EventWebhook) (string, validator) { return "types", &v.Types },
+		func(v *EventWebhook) (string, validator) { return "flow", &v.Flow },
+		noopValidate[EventWebhook]("contentType"),
+		func(v *EventWebhook) (string, validator) { return "payload", &v.Payload },
+		noopValidate[EventWebhook]("timeout"),
+		noopValidate[EventWebhook]("retries"),
+		noopValidate[EventWebhook]("retryDelay"),
+	)
+}
+
+func (this *EventWebhook) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *EventWebhook, node *yaml.Node) error {
+		type raw EventWebhook
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EventWebhook) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EventWebhook:
+		return this.isEqualTo(&v)
+	case *EventWebhook:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EventWebhook) isEqualTo(other *EventWebhook) bool {
+	return this.Name == other.Name &&
+		this.Url == other.Url &&
+		this.Method == other.Method &&
+		isEqual(&this.Headers, &other.Headers) &&
+		isEqual(&this.Types, &other.Types) &&
+		isEqual(&this.Flow, &other.Flow) &&
+		this.ContentType == other.ContentType &&
+		isEqual(&this.Payload, &other.Payload) &&
+		this.Timeout == other.Timeout &&
+		this.Retries == other.Retries &&
+		this.RetryDelay == other.RetryDelay
+}
+
+// EventWebhooks defines a set of EventWebhook instances.
+type EventWebhooks []EventWebhook
+
+func (this *EventWebhooks) SetDefaults() error {
+	return setSliceDefaults(this)
+}
+
+func (this *EventWebhooks) Trim() error {
+	return trimSlice(this)
+}
+
+func (this EventWebhooks) Validate() error {
+	if err := validateSlice(this); err != nil {
+		return err
+	}
+	names := make(map[string]struct{}, len(this))
+	for i, v := range this {
+		if _, ok := names[v.Name]; ok {
+			return fmt.Errorf("[%d] duplicate name %q", i, v.Name)
+		}
+		names[v.Name] = struct{}{}
+	}
+	return nil
+}
+
+func (this *EventWebhooks) UnmarshalYAML(node *yaml.Node) error {
+	// Clear the entries before...
+	*this = EventWebhooks{}
+	return unmarshalYAML(this, node, func(target *EventWebhooks, node *yaml.Node) error {
+		type raw EventWebhooks
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this EventWebhooks) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EventWebhooks:
+		return this.isEqualTo(&v)
+	case *EventWebhooks:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EventWebhooks) isEqualTo(other *EventWebhooks) bool {
+	if len(this) != len(*other) {
+		return false
+	}
+	for i, tv := range this {
+		if !tv.IsEqualTo((*other)[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// EventWebhookHeaders are additional HTTP headers of EventWebhook. Each value
+// is a template which is rendered with the delivered event.
+type EventWebhookHeaders map[string]template.String
+
+func (this EventWebhookHeaders) Validate() error {
+	for k := range this {
+		if k == "" || strings.ContainsAny(k, " \t\r\n:") {
+			return fmt.Errorf("illegal header name %q", k)
+		}
+	}
+	return nil
+}
+
+func (this EventWebhookHeaders) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case EventWebhookHeaders:
+		return this.isEqualTo(&v)
+	case *EventWebhookHeaders:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this EventWebhookHeaders) isEqualTo(other *EventWebhookHeaders) bool {
+	if len(this) != len(*other) {
+		return false
+	}
+	for k, tv := range this {
+		ov, ok := (*other)[k]
+		if !ok || !tv.IsEqualTo(ov) {
+			return false
+		}
+	}
+	return true
+}
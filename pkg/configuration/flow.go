@@ -28,6 +28,15 @@ type Flow struct {
 
 	// Environment defines to which Environment the connection will be connected ones every step before was successful.
 	Environment Environment `yaml:"environment"`
+
+	// SessionTags are attached to each new session of this flow, once it was
+	// authorized.
+	SessionTags SessionTags `yaml:"sessionTags,omitempty"`
+
+	// PortForwardingRules allow or deny port forwarding requests by their
+	// destination or bind address, if the Environment allows port forwarding
+	// at all.
+	PortForwardingRules PortForwardingRules `yaml:"portForwardingRules,omitempty"`
 }
 
 func (this *Flow) SetDefaults() error {
@@ -37,6 +46,8 @@ func (this *Flow) SetDefaults() error {
 		func(v *Flow) (string, defaulter) { return "requirement", &v.Requirement },
 		func(v *Flow) (string, defaulter) { return "authorization", &v.Authorization },
 		func(v *Flow) (string, defaulter) { return "environment", &v.Environment },
+		noopSetDefault[Flow]("sessionTags"),
+		func(v *Flow) (string, defaulter) { return "portForwardingRules", &v.PortForwardingRules },
 	)
 }
 
@@ -47,6 +58,8 @@ func (this *Flow) Trim() error {
 		func(v *Flow) (string, trimmer) { return "requirement", &v.Requirement },
 		func(v *Flow) (string, trimmer) { return "authorization", &v.Authorization },
 		func(v *Flow) (string, trimmer) { return "environment", &v.Environment },
+		noopTrim[Flow]("sessionTags"),
+		func(v *Flow) (string, trimmer) { return "portForwardingRules", &v.PortForwardingRules },
 	)
 }
 
@@ -57,6 +70,8 @@ func (this *Flow) Validate() error {
 		func(v *Flow) (string, validator) { return "requirement", &v.Requirement },
 		func(v *Flow) (string, validator) { return "authorization", &v.Authorization },
 		func(v *Flow) (string, validator) { return "environment", &v.Environment },
+		func(v *Flow) (string, validator) { return "sessionTags", &v.SessionTags },
+		func(v *Flow) (string, validator) { return "portForwardingRules", &v.PortForwardingRules },
 	)
 }
 
@@ -85,7 +100,9 @@ func (this Flow) isEqualTo(other *Flow) bool {
 	return isEqual(&this.Name, &other.Name) &&
 		isEqual(&this.Requirement, &other.Requirement) &&
 		isEqual(&this.Authorization, &other.Authorization) &&
-		isEqual(&this.Environment, &other.Environment)
+		isEqual(&this.Environment, &other.Environment) &&
+		isEqual(&this.SessionTags, &other.SessionTags) &&
+		isEqual(&this.PortForwardingRules, &other.PortForwardingRules)
 }
 
 // Flows defines a set of Flow instances.
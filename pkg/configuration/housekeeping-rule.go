@@ -0,0 +1,137 @@
+package configuration
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+)
+
+// HouseKeepingRule overrides HouseKeeping.KeepExpiredFor for all sessions
+// which have the defined Tags.
+type HouseKeepingRule struct {
+	// Tags a session needs to have that this rule applies. An empty value
+	// matches every value of a tag with the same key.
+	Tags map[string]string `yaml:"tags"`
+
+	// KeepExpiredFor defines for how long a matching session should be kept
+	// before it will finally be deleted, although it is already expired.
+	KeepExpiredFor common.Duration `yaml:"keepExpiredFor"`
+}
+
+func (this *HouseKeepingRule) SetDefaults() error {
+	return setDefaults(this,
+		noopSetDefault[HouseKeepingRule]("tags"),
+		fixedDefault("keepExpiredFor", func(v *HouseKeepingRule) *common.Duration { return &v.KeepExpiredFor }, DefaultHouseKeepingKeepExpiredFor),
+	)
+}
+
+func (this *HouseKeepingRule) Trim() error {
+	return trim(this,
+		noopTrim[HouseKeepingRule]("tags"),
+		noopTrim[HouseKeepingRule]("keepExpiredFor"),
+	)
+}
+
+func (this *HouseKeepingRule) Validate() error {
+	return validate(this,
+		func(v *HouseKeepingRule) (string, validator) {
+			return "tags", validatorFunc(func() error {
+				if len(v.Tags) == 0 {
+					return fmt.Errorf("required but absent")
+				}
+				for k := range v.Tags {
+					if err := ValidateSessionTagKey(k); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+		noopValidate[HouseKeepingRule]("keepExpiredFor"),
+	)
+}
+
+func (this *HouseKeepingRule) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *HouseKeepingRule, node *yaml.Node) error {
+		type raw HouseKeepingRule
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this HouseKeepingRule) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case HouseKeepingRule:
+		return this.isEqualTo(&v)
+	case *HouseKeepingRule:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this HouseKeepingRule) isEqualTo(other *HouseKeepingRule) bool {
+	if len(this.Tags) != len(other.Tags) {
+		return false
+	}
+	for k, v := range this.Tags {
+		if ov, ok := other.Tags[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return isEqual(&this.KeepExpiredFor, &other.KeepExpiredFor)
+}
+
+// HouseKeepingRules defines a set of HouseKeepingRule instances.
+type HouseKeepingRules []HouseKeepingRule
+
+func (this *HouseKeepingRules) SetDefaults() error {
+	return setSliceDefaults(this) // Empty, be default.
+}
+
+func (this *HouseKeepingRules) Trim() error {
+	return trimSlice(this)
+}
+
+func (this HouseKeepingRules) Validate() error {
+	return validateSlice(this)
+}
+
+func (this *HouseKeepingRules) UnmarshalYAML(node *yaml.Node) error {
+	// Clear the entries before...
+	*this = HouseKeepingRules{}
+	return unmarshalYAML(this, node, func(target *HouseKeepingRules, node *yaml.Node) error {
+		type raw HouseKeepingRules
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this HouseKeepingRules) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case HouseKeepingRules:
+		return this.isEqualTo(&v)
+	case *HouseKeepingRules:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this HouseKeepingRules) isEqualTo(other *HouseKeepingRules) bool {
+	if len(this) != len(*other) {
+		return false
+	}
+	for i, tv := range this {
+		if !tv.IsEqualTo((*other)[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -29,6 +29,10 @@ type HouseKeeping struct {
 	// is already expired. In case of 0 it will be deleted immediately.
 	// Defaults to DefaultHouseKeepingKeepExpiredFor
 	KeepExpiredFor common.Duration `yaml:"keepExpiredFor"`
+
+	// Rules override KeepExpiredFor for sessions with specific tags. The
+	// first matching rule wins.
+	Rules HouseKeepingRules `yaml:"rules,omitempty"`
 }
 
 func (this *HouseKeeping) SetDefaults() error {
@@ -37,6 +41,7 @@ func (this *HouseKeeping) SetDefaults() error {
 		fixedDefault("initialDelay", func(v *HouseKeeping) *common.Duration { return &v.InitialDelay }, DefaultHouseKeepingInitialDelay),
 		fixedDefault("autoRepair", func(v *HouseKeeping) *bool { return &v.AutoRepair }, DefaultHouseKeepingAutoRepair),
 		fixedDefault("keepExpiredFor", func(v *HouseKeeping) *common.Duration { return &v.KeepExpiredFor }, DefaultHouseKeepingKeepExpiredFor),
+		func(v *HouseKeeping) (string, defaulter) { return "rules", &v.Rules },
 	)
 }
 
@@ -46,6 +51,7 @@ func (this *HouseKeeping) Trim() error {
 		noopTrim[HouseKeeping]("initialDelay"),
 		noopTrim[HouseKeeping]("autoRepair"),
 		noopTrim[HouseKeeping]("keepExpiredFor"),
+		func(v *HouseKeeping) (string, trimmer) { return "rules", &v.Rules },
 	)
 }
 
@@ -55,6 +61,7 @@ func (this *HouseKeeping) Validate() error {
 		noopValidate[HouseKeeping]("initialDelay"),
 		noopValidate[HouseKeeping]("autoRepair"),
 		noopValidate[HouseKeeping]("keepExpiredFor"),
+		func(v *HouseKeeping) (string, validator) { return "rules", &v.Rules },
 	)
 }
 
@@ -83,5 +90,6 @@ func (this HouseKeeping) isEqualTo(other *HouseKeeping) bool {
 	return isEqual(&this.Every, &other.Every) &&
 		isEqual(&this.InitialDelay, &other.InitialDelay) &&
 		this.AutoRepair == other.AutoRepair &&
-		isEqual(&this.KeepExpiredFor, &other.KeepExpiredFor)
+		isEqual(&this.KeepExpiredFor, &other.KeepExpiredFor) &&
+		isEqual(&this.Rules, &other.Rules)
 }
@@ -0,0 +1,91 @@
+package configuration
+
+import (
+	"fmt"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+// PortForwardingAction defines what happens with a port forwarding request
+// which matches a PortForwardingRule.
+type PortForwardingAction uint8
+
+const (
+	PortForwardingActionAllow PortForwardingAction = iota
+	PortForwardingActionDeny
+)
+
+var (
+	portForwardingActionToName = map[PortForwardingAction]string{
+		PortForwardingActionAllow: "allow",
+		PortForwardingActionDeny:  "deny",
+	}
+	nameToPortForwardingAction = func(in map[PortForwardingAction]string) map[string]PortForwardingAction {
+		result := make(map[string]PortForwardingAction, len(in))
+		for k, v := range in {
+			result[v] = k
+		}
+		result[""] = PortForwardingActionAllow
+		return result
+	}(portForwardingActionToName)
+)
+
+func (this PortForwardingAction) IsZero() bool {
+	return false
+}
+
+func (this PortForwardingAction) MarshalText() (text []byte, err error) {
+	v, ok := portForwardingActionToName[this]
+	if !ok {
+		return nil, errors.Config.Newf("illegal port-forwarding-action: %d", this)
+	}
+	return []byte(v), nil
+}
+
+func (this PortForwardingAction) String() string {
+	v, ok := portForwardingActionToName[this]
+	if !ok {
+		return fmt.Sprintf("illegal-port-forwarding-action-%d", this)
+	}
+	return v
+}
+
+func (this *PortForwardingAction) UnmarshalText(text []byte) error {
+	v, ok := nameToPortForwardingAction[string(text)]
+	if !ok {
+		return errors.Config.Newf("illegal port-forwarding-action: %s", string(text))
+	}
+	*this = v
+	return nil
+}
+
+func (this *PortForwardingAction) Set(text string) error {
+	return this.UnmarshalText([]byte(text))
+}
+
+func (this PortForwardingAction) Validate() error {
+	_, err := this.MarshalText()
+	return err
+}
+
+func (this PortForwardingAction) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case PortForwardingAction:
+		return this.isEqualTo(&v)
+	case *PortForwardingAction:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this PortForwardingAction) isEqualTo(other *PortForwardingAction) bool {
+	return this == *other
+}
+
+func (this PortForwardingAction) Clone() PortForwardingAction {
+	return this
+}
@@ -0,0 +1,154 @@
+package configuration
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	// DefaultPortForwardingRuleEnabled is the default setting for PortForwardingRule.Enabled.
+	DefaultPortForwardingRuleEnabled = template.BoolOf(true)
+
+	// DefaultPortForwardingRuleAction is the default setting for PortForwardingRule.Action.
+	DefaultPortForwardingRuleAction = PortForwardingActionAllow
+
+	// DefaultPortForwardingRuleHosts is the default setting for PortForwardingRule.Hosts.
+	DefaultPortForwardingRuleHosts = template.MustNewStrings()
+
+	// DefaultPortForwardingRulePorts is the default setting for PortForwardingRule.Ports.
+	DefaultPortForwardingRulePorts = template.MustNewStrings()
+)
+
+// PortForwardingRule allows or denies port forwarding requests of a Flow by
+// their destination (local forwarding) or bind address (remote forwarding).
+// The first matching rule of PortForwardingRules decides.
+type PortForwardingRule struct {
+	// Name is used to identify this rule inside of logs and events. If empty,
+	// the rule is identified by its position.
+	Name string `yaml:"name,omitempty"`
+
+	// Enabled defines whether this rule is evaluated at all.
+	Enabled template.Bool `yaml:"enabled,omitempty"`
+
+	// Action defines whether a matching request is allowed or denied.
+	Action PortForwardingAction `yaml:"action,omitempty"`
+
+	// Hosts a request has to match, like exact hosts (example.org),
+	// wildcards (*.example.org) or CIDRs (10.0.0.0/8). If empty, every host
+	// matches.
+	Hosts template.Strings `yaml:"hosts,omitempty"`
+
+	// Ports a request has to match, like single ports (443) or port ranges
+	// (8000-8999). If empty, every port matches.
+	Ports template.Strings `yaml:"ports,omitempty"`
+}
+
+func (this *PortForwardingRule) SetDefaults() error {
+	return setDefaults(this,
+		noopSetDefault[PortForwardingRule]("name"),
+		fixedDefault("enabled", func(v *PortForwardingRule) *template.Bool { return &v.Enabled }, DefaultPortForwardingRuleEnabled),
+		fixedDefault("action", func(v *PortForwardingRule) *PortForwardingAction { return &v.Action }, DefaultPortForwardingRuleAction),
+		fixedDefault("hosts", func(v *PortForwardingRule) *template.Strings { return &v.Hosts }, DefaultPortForwardingRuleHosts),
+		fixedDefault("ports", func(v *PortForwardingRule) *template.Strings { return &v.Ports }, DefaultPortForwardingRulePorts),
+	)
+}
+
+func (this *PortForwardingRule) Trim() error {
+	return trim(this,
+		noopTrim[PortForwardingRule]("name"),
+		noopTrim[PortForwardingRule]("enabled"),
+		noopTrim[PortForwardingRule]("action"),
+		noopTrim[PortForwardingRule]("hosts"),
+		noopTrim[PortForwardingRule]("ports"),
+	)
+}
+
+func (this *PortForwardingRule) Validate() error {
+	return validate(this,
+		noopValidate[PortForwardingRule]("name"),
+		func(v *PortForwardingRule) (string, validator) { return "enabled", &v.Enabled },
+		func(v *PortForwardingRule) (string, validator) { return "action", &v.Action },
+		func(v *PortForwardingRule) (string, validator) { return "hosts", &v.Hosts },
+		func(v *PortForwardingRule) (string, validator) { return "ports", &v.Ports },
+	)
+}
+
+func (this *PortForwardingRule) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *PortForwardingRule, node *yaml.Node) error {
+		type raw PortForwardingRule
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this PortForwardingRule) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case PortForwardingRule:
+		return this.isEqualTo(&v)
+	case *PortForwardingRule:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this PortForwardingRule) isEqualTo(other *PortForwardingRule) bool {
+	return this.Name == other.Name &&
+		isEqual(&this.Enabled, &other.Enabled) &&
+		isEqual(&this.Action, &other.Action) &&
+		isEqual(&this.Hosts, &other.Hosts) &&
+		isEqual(&this.Ports, &other.Ports)
+}
+
+// PortForwardingRules defines a set of PortForwardingRule instances.
+type PortForwardingRules []PortForwardingRule
+
+func (this *PortForwardingRules) SetDefaults() error {
+	return setSliceDefaults(this) // Empty, be default.
+}
+
+func (this *PortForwardingRules) Trim() error {
+	return trimSlice(this)
+}
+
+func (this PortForwardingRules) Validate() error {
+	return validateSlice(this)
+}
+
+func (this *PortForwardingRules) UnmarshalYAML(node *yaml.Node) error {
+	// Clear the entries before...
+	*this = PortForwardingRules{}
+	return unmarshalYAML(this, node, func(target *PortForwardingRules, node *yaml.Node) error {
+		type raw PortForwardingRules
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this PortForwardingRules) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case PortForwardingRules:
+		return this.isEqualTo(&v)
+	case *PortForwardingRules:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this PortForwardingRules) isEqualTo(other *PortForwardingRules) bool {
+	if len(this) != len(*other) {
+		return false
+	}
+	for i, tv := range this {
+		if !tv.IsEqualTo((*other)[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package configuration
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+var (
+	// DefaultSessionBoltFile is the default setting for SessionBolt.File.
+	DefaultSessionBoltFile = defaultSessionBoltFile
+	// DefaultSessionBoltFileMode is the default setting for SessionBolt.FileMode.
+	DefaultSessionBoltFileMode = sys.FileMode(0600)
+
+	_ = RegisterSessionV(func() SessionV {
+		return &SessionBolt{}
+	})
+)
+
+// SessionBolt defines an implementation of Session which is stored inside an
+// embedded transactional database (bbolt) file.
+type SessionBolt struct {
+	// IdleTimeout represents the duration a session can be idle until it will be forcibly closed,
+	// cleaned up and no new access is possible. 0 means no limitation at all.
+	// Defaults to DefaultSessionIdleTimeout
+	IdleTimeout common.Duration `yaml:"idleTimeout"`
+
+	// MaxTimeout represents the maximum duration a whole session can last, regardless if it is idle
+	// or active until it will be forcibly closed, cleaned up and no new access is possible. 0 means
+	// no limitation at all. Defaults to DefaultSessionMaxTimeout
+	MaxTimeout common.Duration `yaml:"maxTimeout"`
+
+	// MaxConnections represents the maximum amount of connections that are related to one session. More than
+	// this amount means that all new connections will be forcibly closed while connection process.
+	// 0 means no limitation at all. Defaults to DefaultSessionMaxConnections
+	MaxConnections uint16 `yaml:"maxConnections"`
+
+	// File defines where the database file is stored. Defaults to DefaultSessionBoltFile
+	File string `yaml:"file"`
+
+	// FileMode defines with which permissions the database file should be stored. Defaults to
+	// DefaultSessionBoltFileMode.
+	FileMode sys.FileMode `yaml:"fileMode"`
+
+	// Encryption defines how tokens of sessions are encrypted at rest. By default, they are not encrypted.
+	Encryption SessionEncryption `yaml:"encryption,omitempty"`
+}
+
+func (this *SessionBolt) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("idleTimeout", func(v *SessionBolt) *common.Duration { return &v.IdleTimeout }, DefaultSessionIdleTimeout),
+		fixedDefault("maxTimeout", func(v *SessionBolt) *common.Duration { return &v.MaxTimeout }, DefaultSessionMaxTimeout),
+		fixedDefault("maxConnections", func(v *SessionBolt) *uint16 { return &v.MaxConnections }, DefaultSessionMaxConnections),
+		fixedDefault("file", func(v *SessionBolt) *string { return &v.File }, DefaultSessionBoltFile),
+		fixedDefault("fileMode", func(v *SessionBolt) *sys.FileMode { return &v.FileMode }, DefaultSessionBoltFileMode),
+		func(v *SessionBolt) (string, defaulter) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionBolt) Trim() error {
+	return trim(this,
+		noopTrim[SessionBolt]("idleTimeout"),
+		noopTrim[SessionBolt]("maxTimeout"),
+		noopTrim[SessionBolt]("maxConnections"),
+		func(v *SessionBolt) (string, trimmer) { return "file", &stringTrimmer{&v.File} },
+		noopTrim[SessionBolt]("fileMode"),
+		func(v *SessionBolt) (string, trimmer) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionBolt) Validate() error {
+	return validate(this,
+		noopValidate[SessionBolt]("idleTimeout"),
+		noopValidate[SessionBolt]("maxTimeout"),
+		noopValidate[SessionBolt]("maxConnections"),
+		notEmptyStringValidate("file", func(v *SessionBolt) *string { return &v.File }),
+		noopValidate[SessionBolt]("fileMode"),
+		func(v *SessionBolt) (string, validator) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionBolt) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *SessionBolt, node *yaml.Node) error {
+		type raw SessionBolt
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this SessionBolt) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SessionBolt:
+		return this.isEqualTo(&v)
+	case *SessionBolt:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this SessionBolt) isEqualTo(other *SessionBolt) bool {
+	return isEqual(&this.IdleTimeout, &other.IdleTimeout) &&
+		isEqual(&this.MaxTimeout, &other.MaxTimeout) &&
+		this.MaxConnections == other.MaxConnections &&
+		this.File == other.File &&
+		this.FileMode == other.FileMode &&
+		isEqual(&this.Encryption, &other.Encryption)
+}
+
+func (this SessionBolt) Types() []string {
+	return []string{"bolt", "bbolt"}
+}
+
+func (this SessionBolt) FeatureFlags() []string {
+	return []string{"bolt"}
+}
@@ -0,0 +1,7 @@
+//go:build unix
+
+package configuration
+
+var (
+	defaultSessionBoltFile = "/var/lib/engity/bifroest/sessions.db"
+)
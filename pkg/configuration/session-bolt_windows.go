@@ -0,0 +1,7 @@
+//go:build windows
+
+package configuration
+
+var (
+	defaultSessionBoltFile = `C:\ProgramData\Engity\Bifroest\sessions.db`
+)
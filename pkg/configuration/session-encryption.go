@@ -0,0 +1,232 @@
+package configuration
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SessionEncryption defines how tokens of sessions (authorization and
+// environment tokens) are encrypted at rest. Each token is encrypted with its
+// own random data key, which is itself encrypted (wrapped) with Key.
+type SessionEncryption struct {
+	// Key is used to encrypt all tokens which are written. If absent, tokens
+	// are stored unencrypted.
+	Key SessionEncryptionKey `yaml:"key,omitempty"`
+
+	// PreviousKeys are only used to decrypt tokens which were encrypted before
+	// Key was rotated. Tokens are re-encrypted using Key once they are
+	// accessed, or by running `bifroest sessions rewrap`.
+	PreviousKeys SessionEncryptionKeys `yaml:"previousKeys,omitempty"`
+}
+
+func (this *SessionEncryption) SetDefaults() error {
+	return setDefaults(this,
+		noopSetDefault[SessionEncryption]("key"),
+		noopSetDefault[SessionEncryption]("previousKeys"),
+	)
+}
+
+func (this *SessionEncryption) Trim() error {
+	return trim(this,
+		func(v *SessionEncryption) (string, trimmer) { return "key", &v.Key },
+		func(v *SessionEncryption) (string, trimmer) { return "previousKeys", &v.PreviousKeys },
+	)
+}
+
+func (this *SessionEncryption) Validate() error {
+	return validate(this,
+		func(v *SessionEncryption) (string, validator) { return "key", &v.Key },
+		func(v *SessionEncryption) (string, validator) { return "previousKeys", &v.PreviousKeys },
+	)
+}
+
+func (this *SessionEncryption) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *SessionEncryption, node *yaml.Node) error {
+		type raw SessionEncryption
+		return node.Decode((*raw)(target))
+	})
+}
+
+// IsEnabled returns true if at least one key is configured.
+func (this SessionEncryption) IsEnabled() bool {
+	return !this.Key.IsZero() || len(this.PreviousKeys) > 0
+}
+
+func (this SessionEncryption) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SessionEncryption:
+		return this.isEqualTo(&v)
+	case *SessionEncryption:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this SessionEncryption) isEqualTo(other *SessionEncryption) bool {
+	return isEqual(&this.Key, &other.Key) &&
+		isEqual(&this.PreviousKeys, &other.PreviousKeys)
+}
+
+// SessionEncryptionKey defines from where the material of a key of
+// SessionEncryption is taken. Exactly one source has to be set, unless it is
+// not configured at all.
+type SessionEncryptionKey struct {
+	// File containing the key material. Leading and trailing whitespaces are ignored.
+	File string `yaml:"file,omitempty"`
+
+	// Env is the name of an environment variable containing the key material.
+	Env string `yaml:"env,omitempty"`
+
+	// HostKey is a file containing an SSH private key (like the host key of
+	// the service), from which the key is derived.
+	HostKey string `yaml:"hostKey,omitempty"`
+}
+
+func (this *SessionEncryptionKey) Trim() error {
+	return trim(this,
+		func(v *SessionEncryptionKey) (string, trimmer) { return "file", &stringTrimmer{&v.File} },
+		func(v *SessionEncryptionKey) (string, trimmer) { return "env", &stringTrimmer{&v.Env} },
+		func(v *SessionEncryptionKey) (string, trimmer) { return "hostKey", &stringTrimmer{&v.HostKey} },
+	)
+}
+
+func (this SessionEncryptionKey) Validate() error {
+	if this.IsZero() {
+		return nil
+	}
+	n := 0
+	for _, v := range []string{this.File, this.Env, this.HostKey} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("exactly one of file, env or hostKey is required")
+	}
+	return nil
+}
+
+func (this SessionEncryptionKey) IsZero() bool {
+	return this.File == "" && this.Env == "" && this.HostKey == ""
+}
+
+func (this SessionEncryptionKey) String() string {
+	switch {
+	case this.File != "":
+		return "file:" + this.File
+	case this.Env != "":
+		return "env:" + this.Env
+	case this.HostKey != "":
+		return "hostKey:" + this.HostKey
+	default:
+		return ""
+	}
+}
+
+// Set parses the given text in the format of String, like file:<path>,
+// env:<name> or hostKey:<path>.
+func (this *SessionEncryptionKey) Set(text string) error {
+	kind, value, _ := strings.Cut(text, ":")
+	if value == "" {
+		return fmt.Errorf("illegal session encryption key %q: expected file:<path>, env:<name> or hostKey:<path>", text)
+	}
+	var buf SessionEncryptionKey
+	switch kind {
+	case "file":
+		buf.File = value
+	case "env":
+		buf.Env = value
+	case "hostKey":
+		buf.HostKey = value
+	default:
+		return fmt.Errorf("illegal session encryption key %q: expected file:<path>, env:<name> or hostKey:<path>", text)
+	}
+	*this = buf
+	return nil
+}
+
+func (this SessionEncryptionKey) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SessionEncryptionKey:
+		return this.isEqualTo(&v)
+	case *SessionEncryptionKey:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this SessionEncryptionKey) isEqualTo(other *SessionEncryptionKey) bool {
+	return this.File == other.File &&
+		this.Env == other.Env &&
+		this.HostKey == other.HostKey
+}
+
+// SessionEncryptionKeys is a list of SessionEncryptionKey.
+type SessionEncryptionKeys []SessionEncryptionKey
+
+func (this *SessionEncryptionKeys) Trim() error {
+	return trimSlice(this)
+}
+
+func (this SessionEncryptionKeys) Validate() error {
+	for i, v := range this {
+		if v.IsZero() {
+			return fmt.Errorf("[%d] exactly one of file, env or hostKey is required", i)
+		}
+	}
+	return validateSlice(this)
+}
+
+// Set adds the key parsed from the given text (see SessionEncryptionKey.Set).
+func (this *SessionEncryptionKeys) Set(text string) error {
+	var buf SessionEncryptionKey
+	if err := buf.Set(text); err != nil {
+		return err
+	}
+	*this = append(*this, buf)
+	return nil
+}
+
+func (this SessionEncryptionKeys) String() string {
+	result := make([]string, len(this))
+	for i, v := range this {
+		result[i] = v.String()
+	}
+	return strings.Join(result, ",")
+}
+
+// IsCumulative tells kingpin that Set can be called multiple times.
+func (this SessionEncryptionKeys) IsCumulative() bool {
+	return true
+}
+
+func (this SessionEncryptionKeys) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SessionEncryptionKeys:
+		return this.isEqualTo(v)
+	case *SessionEncryptionKeys:
+		return this.isEqualTo(*v)
+	default:
+		return false
+	}
+}
+
+func (this SessionEncryptionKeys) isEqualTo(other SessionEncryptionKeys) bool {
+	return slices.EqualFunc(this, other, func(a, b SessionEncryptionKey) bool {
+		return a.isEqualTo(&b)
+	})
+}
@@ -40,6 +40,9 @@ type SessionFs struct {
 
 	// FileMode defines with which permissions the files should be stored. Defaults to DefaultSessionFsFileMode.
 	FileMode sys.FileMode `yaml:"fileMode"`
+
+	// Encryption defines how tokens of sessions are encrypted at rest. By default, they are not encrypted.
+	Encryption SessionEncryption `yaml:"encryption,omitempty"`
 }
 
 func (this *SessionFs) SetDefaults() error {
@@ -49,6 +52,7 @@ func (this *SessionFs) SetDefaults() error {
 		fixedDefault("maxConnections", func(v *SessionFs) *uint16 { return &v.MaxConnections }, DefaultSessionMaxConnections),
 		fixedDefault("storage", func(v *SessionFs) *string { return &v.Storage }, DefaultSessionFsStorage),
 		fixedDefault("fileMode", func(v *SessionFs) *sys.FileMode { return &v.FileMode }, DefaultSessionFsFileMode),
+		func(v *SessionFs) (string, defaulter) { return "encryption", &v.Encryption },
 	)
 }
 
@@ -59,6 +63,7 @@ func (this *SessionFs) Trim() error {
 		noopTrim[SessionFs]("maxConnections"),
 		noopTrim[SessionFs]("storage"),
 		noopTrim[SessionFs]("fileMode"),
+		func(v *SessionFs) (string, trimmer) { return "encryption", &v.Encryption },
 	)
 }
 
@@ -69,6 +74,7 @@ func (this *SessionFs) Validate() error {
 		noopValidate[SessionFs]("maxConnections"),
 		noopValidate[SessionFs]("storage"),
 		noopValidate[SessionFs]("fileMode"),
+		func(v *SessionFs) (string, validator) { return "encryption", &v.Encryption },
 	)
 }
 
@@ -98,7 +104,8 @@ func (this SessionFs) isEqualTo(other *SessionFs) bool {
 		isEqual(&this.MaxTimeout, &other.MaxTimeout) &&
 		this.MaxConnections == other.MaxConnections &&
 		this.Storage == other.Storage &&
-		this.FileMode == other.FileMode
+		this.FileMode == other.FileMode &&
+		isEqual(&this.Encryption, &other.Encryption)
 }
 
 func (this SessionFs) Types() []string {
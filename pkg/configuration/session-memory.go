@@ -0,0 +1,119 @@
+package configuration
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+var (
+	// DefaultSessionMemorySnapshotFileMode is the default setting for SessionMemory.SnapshotFileMode.
+	DefaultSessionMemorySnapshotFileMode = sys.FileMode(0600)
+
+	_ = RegisterSessionV(func() SessionV {
+		return &SessionMemory{}
+	})
+)
+
+// SessionMemory defines an implementation of Session which is only held in
+// memory. All sessions are lost on restart, unless a Snapshot is configured.
+type SessionMemory struct {
+	// IdleTimeout represents the duration a session can be idle until it will be forcibly closed,
+	// cleaned up and no new access is possible. 0 means no limitation at all.
+	// Defaults to DefaultSessionIdleTimeout
+	IdleTimeout common.Duration `yaml:"idleTimeout"`
+
+	// MaxTimeout represents the maximum duration a whole session can last, regardless if it is idle
+	// or active until it will be forcibly closed, cleaned up and no new access is possible. 0 means
+	// no limitation at all. Defaults to DefaultSessionMaxTimeout
+	MaxTimeout common.Duration `yaml:"maxTimeout"`
+
+	// MaxConnections represents the maximum amount of connections that are related to one session. More than
+	// this amount means that all new connections will be forcibly closed while connection process.
+	// 0 means no limitation at all. Defaults to DefaultSessionMaxConnections
+	MaxConnections uint16 `yaml:"maxConnections"`
+
+	// Snapshot is an optional file to which all sessions are written on
+	// shutdown and from which they are restored on start. If empty, all
+	// sessions are lost on restart.
+	Snapshot string `yaml:"snapshot,omitempty"`
+
+	// SnapshotFileMode defines with which permissions the Snapshot file should be stored. Defaults to
+	// DefaultSessionMemorySnapshotFileMode.
+	SnapshotFileMode sys.FileMode `yaml:"snapshotFileMode"`
+
+	// Encryption defines how tokens of sessions are encrypted at rest. By default, they are not encrypted.
+	Encryption SessionEncryption `yaml:"encryption,omitempty"`
+}
+
+func (this *SessionMemory) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("idleTimeout", func(v *SessionMemory) *common.Duration { return &v.IdleTimeout }, DefaultSessionIdleTimeout),
+		fixedDefault("maxTimeout", func(v *SessionMemory) *common.Duration { return &v.MaxTimeout }, DefaultSessionMaxTimeout),
+		fixedDefault("maxConnections", func(v *SessionMemory) *uint16 { return &v.MaxConnections }, DefaultSessionMaxConnections),
+		fixedDefault("snapshot", func(v *SessionMemory) *string { return &v.Snapshot }, ""),
+		fixedDefault("snapshotFileMode", func(v *SessionMemory) *sys.FileMode { return &v.SnapshotFileMode }, DefaultSessionMemorySnapshotFileMode),
+		func(v *SessionMemory) (string, defaulter) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionMemory) Trim() error {
+	return trim(this,
+		noopTrim[SessionMemory]("idleTimeout"),
+		noopTrim[SessionMemory]("maxTimeout"),
+		noopTrim[SessionMemory]("maxConnections"),
+		func(v *SessionMemory) (string, trimmer) { return "snapshot", &stringTrimmer{&v.Snapshot} },
+		noopTrim[SessionMemory]("snapshotFileMode"),
+		func(v *SessionMemory) (string, trimmer) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionMemory) Validate() error {
+	return validate(this,
+		noopValidate[SessionMemory]("idleTimeout"),
+		noopValidate[SessionMemory]("maxTimeout"),
+		noopValidate[SessionMemory]("maxConnections"),
+		noopValidate[SessionMemory]("snapshot"),
+		noopValidate[SessionMemory]("snapshotFileMode"),
+		func(v *SessionMemory) (string, validator) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionMemory) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *SessionMemory, node *yaml.Node) error {
+		type raw SessionMemory
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this SessionMemory) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SessionMemory:
+		return this.isEqualTo(&v)
+	case *SessionMemory:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this SessionMemory) isEqualTo(other *SessionMemory) bool {
+	return isEqual(&this.IdleTimeout, &other.IdleTimeout) &&
+		isEqual(&this.MaxTimeout, &other.MaxTimeout) &&
+		this.MaxConnections == other.MaxConnections &&
+		this.Snapshot == other.Snapshot &&
+		this.SnapshotFileMode == other.SnapshotFileMode &&
+		isEqual(&this.Encryption, &other.Encryption)
+}
+
+func (this SessionMemory) Types() []string {
+	return []string{"memory"}
+}
+
+func (this SessionMemory) FeatureFlags() []string {
+	return []string{"memory"}
+}
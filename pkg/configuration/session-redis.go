@@ -0,0 +1,158 @@
+package configuration
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+)
+
+var (
+	// DefaultSessionRedisUrl is the default setting for SessionRedis.Url.
+	DefaultSessionRedisUrl = "redis://localhost:6379/0"
+	// DefaultSessionRedisKeyPrefix is the default setting for SessionRedis.KeyPrefix.
+	DefaultSessionRedisKeyPrefix = "bifroest:"
+	// DefaultSessionRedisLeaseTimeout is the default setting for SessionRedis.LeaseTimeout.
+	DefaultSessionRedisLeaseTimeout = common.DurationOf(30 * time.Second)
+
+	_ = RegisterSessionV(func() SessionV {
+		return &SessionRedis{}
+	})
+)
+
+// SessionRedis defines an implementation of Session which is stored inside a
+// server speaking the Redis protocol. It can be shared by several instances of
+// Bifröst at the same time.
+type SessionRedis struct {
+	// IdleTimeout represents the duration a session can be idle until it will be forcibly closed,
+	// cleaned up and no new access is possible. 0 means no limitation at all.
+	// Defaults to DefaultSessionIdleTimeout
+	IdleTimeout common.Duration `yaml:"idleTimeout"`
+
+	// MaxTimeout represents the maximum duration a whole session can last, regardless if it is idle
+	// or active until it will be forcibly closed, cleaned up and no new access is possible. 0 means
+	// no limitation at all. Defaults to DefaultSessionMaxTimeout
+	MaxTimeout common.Duration `yaml:"maxTimeout"`
+
+	// MaxConnections represents the maximum amount of connections that are related to one session,
+	// across all instances sharing the same server. More than this amount means that all new
+	// connections will be forcibly closed while connection process. 0 means no limitation at all.
+	// Defaults to DefaultSessionMaxConnections
+	MaxConnections uint16 `yaml:"maxConnections"`
+
+	// Url of the server, in the format redis[s]://[[<user>]:<password>@]<host>[:<port>][/<db>].
+	// Defaults to DefaultSessionRedisUrl
+	Url string `yaml:"url"`
+
+	// KeyPrefix is prepended to all keys stored inside the server. Instances
+	// sharing the same sessions need the same prefix. Defaults to DefaultSessionRedisKeyPrefix
+	KeyPrefix string `yaml:"keyPrefix"`
+
+	// LeaseTimeout defines for how long an instance keeps its connections and
+	// its housekeeping leadership registered at the server without renewing
+	// them. If an instance disappears, others will take over after this
+	// duration. Defaults to DefaultSessionRedisLeaseTimeout
+	LeaseTimeout common.Duration `yaml:"leaseTimeout"`
+
+	// Encryption defines how tokens of sessions are encrypted at rest. By default, they are not encrypted.
+	Encryption SessionEncryption `yaml:"encryption,omitempty"`
+}
+
+func (this *SessionRedis) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("idleTimeout", func(v *SessionRedis) *common.Duration { return &v.IdleTimeout }, DefaultSessionIdleTimeout),
+		fixedDefault("maxTimeout", func(v *SessionRedis) *common.Duration { return &v.MaxTimeout }, DefaultSessionMaxTimeout),
+		fixedDefault("maxConnections", func(v *SessionRedis) *uint16 { return &v.MaxConnections }, DefaultSessionMaxConnections),
+		fixedDefault("url", func(v *SessionRedis) *string { return &v.Url }, DefaultSessionRedisUrl),
+		fixedDefault("keyPrefix", func(v *SessionRedis) *string { return &v.KeyPrefix }, DefaultSessionRedisKeyPrefix),
+		fixedDefault("leaseTimeout", func(v *SessionRedis) *common.Duration { return &v.LeaseTimeout }, DefaultSessionRedisLeaseTimeout),
+		func(v *SessionRedis) (string, defaulter) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionRedis) Trim() error {
+	return trim(this,
+		noopTrim[SessionRedis]("idleTimeout"),
+		noopTrim[SessionRedis]("maxTimeout"),
+		noopTrim[SessionRedis]("maxConnections"),
+		func(v *SessionRedis) (string, trimmer) { return "url", &stringTrimmer{&v.Url} },
+		noopTrim[SessionRedis]("keyPrefix"),
+		noopTrim[SessionRedis]("leaseTimeout"),
+		func(v *SessionRedis) (string, trimmer) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionRedis) Validate() error {
+	return validate(this,
+		noopValidate[SessionRedis]("idleTimeout"),
+		noopValidate[SessionRedis]("maxTimeout"),
+		noopValidate[SessionRedis]("maxConnections"),
+		func(v *SessionRedis) (string, validator) {
+			return "url", validatorFunc(func() error {
+				u, err := url.Parse(v.Url)
+				if err != nil {
+					return fmt.Errorf("illegal url: %w", err)
+				}
+				if u.Scheme != "redis" && u.Scheme != "rediss" {
+					return fmt.Errorf("illegal url %q: only redis and rediss are supported", v.Url)
+				}
+				if u.Host == "" {
+					return fmt.Errorf("illegal url %q: host required but absent", v.Url)
+				}
+				return nil
+			})
+		},
+		noopValidate[SessionRedis]("keyPrefix"),
+		func(v *SessionRedis) (string, validator) {
+			return "leaseTimeout", validatorFunc(func() error {
+				if v.LeaseTimeout.Native() <= 0 {
+					return fmt.Errorf("needs to be a positive duration, but got: %v", v.LeaseTimeout)
+				}
+				return nil
+			})
+		},
+		func(v *SessionRedis) (string, validator) { return "encryption", &v.Encryption },
+	)
+}
+
+func (this *SessionRedis) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *SessionRedis, node *yaml.Node) error {
+		type raw SessionRedis
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this SessionRedis) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SessionRedis:
+		return this.isEqualTo(&v)
+	case *SessionRedis:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this SessionRedis) isEqualTo(other *SessionRedis) bool {
+	return isEqual(&this.IdleTimeout, &other.IdleTimeout) &&
+		isEqual(&this.MaxTimeout, &other.MaxTimeout) &&
+		this.MaxConnections == other.MaxConnections &&
+		this.Url == other.Url &&
+		this.KeyPrefix == other.KeyPrefix &&
+		isEqual(&this.LeaseTimeout, &other.LeaseTimeout) &&
+		isEqual(&this.Encryption, &other.Encryption)
+}
+
+func (this SessionRedis) Types() []string {
+	return []string{"redis"}
+}
+
+func (this SessionRedis) FeatureFlags() []string {
+	return []string{"redis"}
+}
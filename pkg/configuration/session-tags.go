@@ -0,0 +1,62 @@
+package configuration
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	sessionTagKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-/]*$`)
+)
+
+// ValidateSessionTagKey returns an error if the given key cannot be used as
+// key of a tag of a session.
+func ValidateSessionTagKey(key string) error {
+	if !sessionTagKeyRegexp.MatchString(key) {
+		return fmt.Errorf("illegal session tag key %q", key)
+	}
+	return nil
+}
+
+// SessionTags are tags which are attached to a new session of a Flow, once
+// it was authorized. Each value is a template which is rendered with the
+// authorization and connection. Tags with empty values are not attached.
+type SessionTags map[string]template.String
+
+func (this SessionTags) Validate() error {
+	for k := range this {
+		if err := ValidateSessionTagKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this SessionTags) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SessionTags:
+		return this.isEqualTo(&v)
+	case *SessionTags:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this SessionTags) isEqualTo(other *SessionTags) bool {
+	if len(this) != len(*other) {
+		return false
+	}
+	for k, tv := range this {
+		ov, ok := (*other)[k]
+		if !ok || !tv.IsEqualTo(ov) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package configuration
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	// DefaultSshExpiryWarnBefore is the default setting for SshExpiry.WarnBefore.
+	DefaultSshExpiryWarnBefore = SshExpiryWarnBefore{
+		common.DurationOf(15 * time.Minute),
+		common.DurationOf(5 * time.Minute),
+		common.DurationOf(1 * time.Minute),
+	}
+
+	// DefaultSshExpiryWarning is the default setting for SshExpiry.Warning.
+	DefaultSshExpiryWarning = template.MustNewString("This session expires in {{.remaining}} (at {{.expiresAt | format `dateTimeT`}}). Please save your work.")
+
+	// DefaultSshExpiryMessage is the default setting for SshExpiry.Message.
+	DefaultSshExpiryMessage = template.MustNewString("This session has expired. Please reconnect.")
+
+	// DefaultSshExpiryExitCode is the default setting for SshExpiry.ExitCode.
+	DefaultSshExpiryExitCode = uint8(64)
+)
+
+// SshExpiry defines how interactive sessions are informed about their
+// upcoming expiry and how they are ended, once they are expired. An expiry is
+// caused by either Ssh.MaxTimeout or by the validity of the session itself.
+type SshExpiry struct {
+	// WarnBefore defines at which remaining durations before the expiry a
+	// Warning is written into the terminal. If empty, no warnings are written
+	// at all. Defaults to DefaultSshExpiryWarnBefore.
+	WarnBefore SshExpiryWarnBefore `yaml:"warnBefore"`
+
+	// Warning is the message written into the terminal when one of
+	// WarnBefore is reached. Defaults to DefaultSshExpiryWarning.
+	Warning template.String `yaml:"warning"`
+
+	// Message is written into the terminal when the session has expired,
+	// before it is ended. Defaults to DefaultSshExpiryMessage.
+	Message template.String `yaml:"message"`
+
+	// ExitCode is reported to the client when the session has expired.
+	// Defaults to DefaultSshExpiryExitCode.
+	ExitCode uint8 `yaml:"exitCode"`
+}
+
+func (this *SshExpiry) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("warnBefore", func(v *SshExpiry) *SshExpiryWarnBefore { return &v.WarnBefore }, DefaultSshExpiryWarnBefore),
+		fixedDefault("warning", func(v *SshExpiry) *template.String { return &v.Warning }, DefaultSshExpiryWarning),
+		fixedDefault("message", func(v *SshExpiry) *template.String { return &v.Message }, DefaultSshExpiryMessage),
+		fixedDefault("exitCode", func(v *SshExpiry) *uint8 { return &v.ExitCode }, DefaultSshExpiryExitCode),
+	)
+}
+
+func (this *SshExpiry) Trim() error {
+	return trim(this,
+		func(v *SshExpiry) (string, trimmer) { return "warnBefore", &v.WarnBefore },
+		noopTrim[SshExpiry]("warning"),
+		noopTrim[SshExpiry]("message"),
+		noopTrim[SshExpiry]("exitCode"),
+	)
+}
+
+func (this *SshExpiry) Validate() error {
+	return validate(this,
+		func(v *SshExpiry) (string, validator) { return "warnBefore", &v.WarnBefore },
+		func(v *SshExpiry) (string, validator) { return "warning", &v.Warning },
+		func(v *SshExpiry) (string, validator) { return "message", &v.Message },
+		noopValidate[SshExpiry]("exitCode"),
+	)
+}
+
+func (this *SshExpiry) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *SshExpiry, node *yaml.Node) error {
+		type raw SshExpiry
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this SshExpiry) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SshExpiry:
+		return this.isEqualTo(&v)
+	case *SshExpiry:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this SshExpiry) isEqualTo(other *SshExpiry) bool {
+	return isEqual(&this.WarnBefore, &other.WarnBefore) &&
+		isEqual(&this.Warning, &other.Warning) &&
+		isEqual(&this.Message, &other.Message) &&
+		this.ExitCode == other.ExitCode
+}
+
+// SshExpiryWarnBefore holds the durations before an expiry when a warning
+// should be written. After Trim they are ordered from the longest to the
+// shortest duration without duplicates.
+type SshExpiryWarnBefore []common.Duration
+
+func (this *SshExpiryWarnBefore) Trim() error {
+	buf := slices.Clone(*this)
+	slices.SortFunc(buf, func(a, b common.Duration) int {
+		return cmp.Compare(b.Native(), a.Native())
+	})
+	*this = slices.CompactFunc(buf, func(a, b common.Duration) bool {
+		return a.Native() == b.Native()
+	})
+	return nil
+}
+
+func (this SshExpiryWarnBefore) Validate() error {
+	for i, v := range this {
+		if v.Native() <= 0 {
+			return fmt.Errorf("[%d] needs to be a positive duration, but got: %v", i, v)
+		}
+	}
+	return nil
+}
+
+func (this SshExpiryWarnBefore) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SshExpiryWarnBefore:
+		return this.isEqualTo(v)
+	case *SshExpiryWarnBefore:
+		return this.isEqualTo(*v)
+	default:
+		return false
+	}
+}
+
+func (this SshExpiryWarnBefore) isEqualTo(other SshExpiryWarnBefore) bool {
+	return slices.EqualFunc(this, other, func(a, b common.Duration) bool {
+		return a.Native() == b.Native()
+	})
+}
@@ -0,0 +1,147 @@
+package configuration
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+var (
+	// DefaultSshSharingAllowed is the default setting for SshSharing.Allowed.
+	DefaultSshSharingAllowed = template.BoolOf(false)
+
+	// DefaultSshSharingReadWriteAllowed is the default setting for SshSharing.ReadWriteAllowed.
+	DefaultSshSharingReadWriteAllowed = template.BoolOf(false)
+
+	// DefaultSshSharingJoinAllowed is the default setting for SshSharing.JoinAllowed.
+	DefaultSshSharingJoinAllowed = template.BoolOf(false)
+
+	// DefaultSshSharingJoinOtherFlowsAllowed is the default setting for SshSharing.JoinOtherFlowsAllowed.
+	DefaultSshSharingJoinOtherFlowsAllowed = template.BoolOf(false)
+
+	// DefaultSshSharingControlKey is the default setting for SshSharing.ControlKey.
+	DefaultSshSharingControlKey = "^]"
+
+	// DefaultSshSharingMaxParticipants is the default setting for SshSharing.MaxParticipants.
+	DefaultSshSharingMaxParticipants = uint16(10)
+)
+
+// SshSharing defines if and how the owner of an interactive shell can share
+// it with other users, which can then watch or even co-drive it.
+type SshSharing struct {
+	// Allowed defines if the owner of an interactive shell is allowed to
+	// share it. Defaults to DefaultSshSharingAllowed.
+	Allowed template.Bool `yaml:"allowed"`
+
+	// ReadWriteAllowed defines if the owner of an interactive shell is
+	// allowed to share it in read-write mode, which means participants can
+	// type into it, too. Defaults to DefaultSshSharingReadWriteAllowed.
+	ReadWriteAllowed template.Bool `yaml:"readWriteAllowed"`
+
+	// JoinAllowed defines if a user is allowed to join a shared shell.
+	// Defaults to DefaultSshSharingJoinAllowed.
+	JoinAllowed template.Bool `yaml:"joinAllowed"`
+
+	// JoinOtherFlowsAllowed defines if a user is allowed to join a shared
+	// shell of an owner who was authorized by another flow. If false, users
+	// can only join shared shells of their own flow. Defaults to
+	// DefaultSshSharingJoinOtherFlowsAllowed.
+	JoinOtherFlowsAllowed template.Bool `yaml:"joinOtherFlowsAllowed"`
+
+	// ControlKey is the key which opens the sharing control prompt inside of
+	// an interactive shell in notation ^<char> (for example ^] for Ctrl+]).
+	// Pressing it twice sends the key itself to the shell. Defaults to
+	// DefaultSshSharingControlKey.
+	ControlKey string `yaml:"controlKey"`
+
+	// MaxParticipants defines how many users can join a shared shell at the
+	// same time. Defaults to DefaultSshSharingMaxParticipants.
+	MaxParticipants uint16 `yaml:"maxParticipants"`
+}
+
+// ControlKeyByte returns the byte which is sent by a terminal if ControlKey
+// was pressed.
+func (this SshSharing) ControlKeyByte() (byte, error) {
+	v := this.ControlKey
+	if len(v) != 2 || v[0] != '^' || v[1] < '@' || v[1] > '_' {
+		return 0, fmt.Errorf("illegal control key %q; expected ^@, ^A-^Z, ^[, ^\\, ^], ^^ or ^_", v)
+	}
+	return v[1] - '@', nil
+}
+
+func (this *SshSharing) SetDefaults() error {
+	return setDefaults(this,
+		fixedDefault("allowed", func(v *SshSharing) *template.Bool { return &v.Allowed }, DefaultSshSharingAllowed),
+		fixedDefault("readWriteAllowed", func(v *SshSharing) *template.Bool { return &v.ReadWriteAllowed }, DefaultSshSharingReadWriteAllowed),
+		fixedDefault("joinAllowed", func(v *SshSharing) *template.Bool { return &v.JoinAllowed }, DefaultSshSharingJoinAllowed),
+		fixedDefault("joinOtherFlowsAllowed", func(v *SshSharing) *template.Bool { return &v.JoinOtherFlowsAllowed }, DefaultSshSharingJoinOtherFlowsAllowed),
+		fixedDefault("controlKey", func(v *SshSharing) *string { return &v.ControlKey }, DefaultSshSharingControlKey),
+		fixedDefault("maxParticipants", func(v *SshSharing) *uint16 { return &v.MaxParticipants }, DefaultSshSharingMaxParticipants),
+	)
+}
+
+func (this *SshSharing) Trim() error {
+	return trim(this,
+		noopTrim[SshSharing]("allowed"),
+		noopTrim[SshSharing]("readWriteAllowed"),
+		noopTrim[SshSharing]("joinAllowed"),
+		noopTrim[SshSharing]("joinOtherFlowsAllowed"),
+		noopTrim[SshSharing]("controlKey"),
+		noopTrim[SshSharing]("maxParticipants"),
+	)
+}
+
+func (this *SshSharing) Validate() error {
+	return validate(this,
+		func(v *SshSharing) (string, validator) { return "allowed", &v.Allowed },
+		func(v *SshSharing) (string, validator) { return "readWriteAllowed", &v.ReadWriteAllowed },
+		func(v *SshSharing) (string, validator) { return "joinAllowed", &v.JoinAllowed },
+		func(v *SshSharing) (string, validator) { return "joinOtherFlowsAllowed", &v.JoinOtherFlowsAllowed },
+		func(v *SshSharing) (string, validator) {
+			return "controlKey", validatorFunc(func() error {
+				_, err := v.ControlKeyByte()
+				return err
+			})
+		},
+		func(v *SshSharing) (string, validator) {
+			return "maxParticipants", validatorFunc(func() error {
+				if v.MaxParticipants == 0 {
+					return fmt.Errorf("at least one participant is required")
+				}
+				return nil
+			})
+		},
+	)
+}
+
+func (this *SshSharing) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *SshSharing, node *yaml.Node) error {
+		type raw SshSharing
+		return node.Decode((*raw)(target))
+	})
+}
+
+func (this SshSharing) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case SshSharing:
+		return this.isEqualTo(&v)
+	case *SshSharing:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this SshSharing) isEqualTo(other *SshSharing) bool {
+	return isEqual(&this.Allowed, &other.Allowed) &&
+		isEqual(&this.ReadWriteAllowed, &other.ReadWriteAllowed) &&
+		isEqual(&this.JoinAllowed, &other.JoinAllowed) &&
+		isEqual(&this.JoinOtherFlowsAllowed, &other.JoinOtherFlowsAllowed) &&
+		this.ControlKey == other.ControlKey &&
+		this.MaxParticipants == other.MaxParticipants
+}
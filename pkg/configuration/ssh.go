@@ -52,6 +52,13 @@ type Ssh struct {
 	// until it will be forcibly closed. 0 means no limitation at all. Defaults to DefaultSshMaxTimeout.
 	MaxTimeout common.Duration `yaml:"maxTimeout"`
 
+	// Expiry defines how interactive sessions are warned before they expire and how they are ended once they
+	// are expired.
+	Expiry SshExpiry `yaml:"expiry"`
+
+	// Sharing defines if and how interactive shells can be shared with other users.
+	Sharing SshSharing `yaml:"sharing"`
+
 	// MaxAuthTries represents the maximum amount of tries a client can do while a connection with different
 	// authorizations before the connection will be forcibly closed. 0 means no limitation at all.
 	// Defaults to DefaultSshMaxAuthTries.
@@ -80,6 +87,8 @@ func (this *Ssh) SetDefaults() error {
 		func(v *Ssh) (string, defaulter) { return "messages", &v.Messages },
 		fixedDefault("idleTimeout", func(v *Ssh) *common.Duration { return &v.IdleTimeout }, DefaultSshIdleTimeout),
 		fixedDefault("maxTimeout", func(v *Ssh) *common.Duration { return &v.MaxTimeout }, DefaultSshMaxTimeout),
+		func(v *Ssh) (string, defaulter) { return "expiry", &v.Expiry },
+		func(v *Ssh) (string, defaulter) { return "sharing", &v.Sharing },
 		fixedDefault("maxAuthTries", func(v *Ssh) *uint8 { return &v.MaxAuthTries }, DefaultSshMaxAuthTries),
 		fixedDefault("maxConnections", func(v *Ssh) *uint32 { return &v.MaxConnections }, DefaultSshMaxConnections),
 		fixedDefault("proxyProtocol", func(v *Ssh) *bool { return &v.ProxyProtocol }, DefaultProxyProtocol),
@@ -95,6 +104,8 @@ func (this *Ssh) Trim() error {
 		func(v *Ssh) (string, trimmer) { return "messages", &v.Messages },
 		noopTrim[Ssh]("idleTimeout"),
 		noopTrim[Ssh]("maxTimeout"),
+		func(v *Ssh) (string, trimmer) { return "expiry", &v.Expiry },
+		func(v *Ssh) (string, trimmer) { return "sharing", &v.Sharing },
 		noopTrim[Ssh]("maxAuthTries"),
 		noopTrim[Ssh]("maxConnections"),
 		noopTrim[Ssh]("proxyProtocol"),
@@ -110,6 +121,8 @@ func (this *Ssh) Validate() error {
 		func(v *Ssh) (string, validator) { return "messages", &v.Messages },
 		func(v *Ssh) (string, validator) { return "idleTimeout", &v.IdleTimeout },
 		func(v *Ssh) (string, validator) { return "maxTimeout", &v.MaxTimeout },
+		func(v *Ssh) (string, validator) { return "expiry", &v.Expiry },
+		func(v *Ssh) (string, validator) { return "sharing", &v.Sharing },
 		noopValidate[Ssh]("maxAuthTries"),
 		noopValidate[Ssh]("maxConnections"),
 		noopValidate[Ssh]("proxyProtocol"),
@@ -145,6 +158,8 @@ func (this Ssh) isEqualTo(other *Ssh) bool {
 		isEqual(&this.Messages, &other.Messages) &&
 		isEqual(&this.IdleTimeout, &other.IdleTimeout) &&
 		isEqual(&this.MaxTimeout, &other.MaxTimeout) &&
+		isEqual(&this.Expiry, &other.Expiry) &&
+		isEqual(&this.Sharing, &other.Sharing) &&
 		this.MaxAuthTries == other.MaxAuthTries &&
 		this.MaxConnections == other.MaxConnections &&
 		this.ProxyProtocol == other.ProxyProtocol &&
@@ -0,0 +1,150 @@
+package configuration
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tracing defines how the service exports traces via OpenTelemetry (OTLP).
+type Tracing struct {
+	// Endpoint is the URL of the OTLP/HTTP collector the traces are exported to,
+	// like `http://localhost:4318`. If the URL does not contain a path, the default
+	// one (`/v1/traces`) will be used. If empty, tracing is disabled.
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Headers which are sent together with each export request, for example
+	// to authenticate against the collector.
+	Headers TracingHeaders `yaml:"headers,omitempty"`
+
+	// ServiceName is reported as `service.name` of all exported traces. If empty,
+	// DefaultTracingServiceName is used.
+	ServiceName string `yaml:"serviceName,omitempty"`
+}
+
+const (
+	DefaultTracingServiceName = "bifroest"
+)
+
+func (this *Tracing) SetDefaults() error {
+	return setDefaults(this,
+		noopSetDefault[Tracing]("endpoint"),
+		noopSetDefault[Tracing]("headers"),
+		noopSetDefault[Tracing]("serviceName"),
+	)
+}
+
+func (this *Tracing) Trim() error {
+	return trim(this,
+		func(v *Tracing) (string, trimmer) { return "endpoint", &stringTrimmer{&v.Endpoint} },
+		func(v *Tracing) (string, trimmer) { return "headers", &v.Headers },
+		func(v *Tracing) (string, trimmer) { return "serviceName", &stringTrimmer{&v.ServiceName} },
+	)
+}
+
+func (this *Tracing) Validate() error {
+	return validate(this,
+		func(v *Tracing) (string, validator) {
+			return "endpoint", validatorFunc(func() error {
+				if v.Endpoint == "" {
+					return nil
+				}
+				u, err := url.Parse(v.Endpoint)
+				if err != nil {
+					return fmt.Errorf("illegal endpoint: %w", err)
+				}
+				if u.Scheme != "http" && u.Scheme != "https" {
+					return fmt.Errorf("illegal endpoint %q: only http and https are supported", v.Endpoint)
+				}
+				if u.Host == "" {
+					return fmt.Errorf("illegal endpoint %q: host required but absent", v.Endpoint)
+				}
+				return nil
+			})
+		},
+		func(v *Tracing) (string, validator) { return "headers", &v.Headers },
+	)
+}
+
+func (this *Tracing) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAML(this, node, func(target *Tracing, node *yaml.Node) error {
+		type raw Tracing
+		return node.Decode((*raw)(target))
+	})
+}
+
+// IsEnabled returns true if an endpoint is configured.
+func (this Tracing) IsEnabled() bool {
+	return this.Endpoint != ""
+}
+
+// GetServiceName returns ServiceName or DefaultTracingServiceName if empty.
+func (this Tracing) GetServiceName() string {
+	if v := this.ServiceName; v != "" {
+		return v
+	}
+	return DefaultTracingServiceName
+}
+
+func (this Tracing) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case Tracing:
+		return this.isEqualTo(&v)
+	case *Tracing:
+		return this.isEqualTo(v)
+	default:
+		return false
+	}
+}
+
+func (this Tracing) isEqualTo(other *Tracing) bool {
+	return this.Endpoint == other.Endpoint &&
+		isEqual(&this.Headers, &other.Headers) &&
+		this.ServiceName == other.ServiceName
+}
+
+// TracingHeaders are additional HTTP headers of Tracing.
+type TracingHeaders map[string]string
+
+func (this *TracingHeaders) Trim() error {
+	if this == nil || *this == nil {
+		return nil
+	}
+	result := make(TracingHeaders, len(*this))
+	for k, v := range *this {
+		if k = strings.TrimSpace(k); k != "" {
+			result[k] = strings.TrimSpace(v)
+		}
+	}
+	*this = result
+	return nil
+}
+
+func (this TracingHeaders) Validate() error {
+	for k := range this {
+		if strings.ContainsAny(k, " \t\r\n:") {
+			return fmt.Errorf("illegal header name %q", k)
+		}
+	}
+	return nil
+}
+
+func (this TracingHeaders) IsEqualTo(other any) bool {
+	if other == nil {
+		return false
+	}
+	switch v := other.(type) {
+	case TracingHeaders:
+		return maps.Equal(this, v)
+	case *TracingHeaders:
+		return maps.Equal(this, *v)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,279 @@
+package environment
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/docker/cli/opts"
+	"github.com/docker/docker/api/types/container"
+	mobycontainer "github.com/moby/moby/api/types/container"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+var (
+	// dockerReservedEnvVars are used by Bifröst itself and cannot be
+	// overwritten by the configuration.
+	dockerReservedEnvVars = []string{
+		imp.EnvVarMasterPublicKey,
+		session.EnvName,
+	}
+
+	// dockerSecurityOptionKeys are all keys of security options supported by
+	// the Docker daemon.
+	dockerSecurityOptionKeys = []string{
+		"no-new-privileges",
+		"seccomp",
+		"apparmor",
+		"label",
+		"systempaths",
+		"writable-cgroups",
+	}
+)
+
+// applyContainerOptions applies the additional environment variables and
+// labels of the configuration to the given container config.
+func (this *DockerRepository) applyContainerOptions(req Request, target *container.Config) error {
+	failf := func(msg string, args ...any) error {
+		return errors.Config.Newf(msg, args...)
+	}
+
+	if raws, err := this.conf.Env.Render(req); err != nil {
+		return failf("cannot evaluate env: %w", err)
+	} else if vs, err := parseDockerEnv(raws); err != nil {
+		return failf("illegal env: %w", err)
+	} else {
+		target.Env = append(target.Env, vs...)
+	}
+
+	if raws, err := this.conf.Labels.Render(req); err != nil {
+		return failf("cannot evaluate labels: %w", err)
+	} else if vs, err := parseDockerLabels(raws); err != nil {
+		return failf("illegal labels: %w", err)
+	} else {
+		for k, v := range vs {
+			target.Labels[k] = v
+		}
+	}
+
+	return nil
+}
+
+// applyHostOptions applies the resource limits and security related options
+// of the configuration to the given host config.
+func (this *DockerRepository) applyHostOptions(req Request, target *container.HostConfig) error {
+	failf := func(msg string, args ...any) error {
+		return errors.Config.Newf(msg, args...)
+	}
+
+	if plain, err := this.conf.Memory.Render(req); err != nil {
+		return failf("cannot evaluate memory: %w", err)
+	} else if plain != "" {
+		var buf opts.MemBytes
+		if err := buf.Set(plain); err != nil {
+			return failf("illegal memory %q: %w", plain, err)
+		}
+		target.Memory = buf.Value()
+	}
+	if plain, err := this.conf.Cpus.Render(req); err != nil {
+		return failf("cannot evaluate cpus: %w", err)
+	} else if plain != "" {
+		var buf opts.NanoCPUs
+		if err := buf.Set(plain); err != nil {
+			return failf("illegal cpus %q: %w", plain, err)
+		}
+		target.NanoCPUs = buf.Value()
+	}
+	if v, err := this.conf.PidsLimit.Render(req); err != nil {
+		return failf("cannot evaluate pidsLimit: %w", err)
+	} else if v < 0 {
+		return failf("pidsLimit cannot be negative, but got: %d", v)
+	} else if v > 0 {
+		target.PidsLimit = &v
+	}
+	if raws, err := this.conf.Ulimits.Render(req); err != nil {
+		return failf("cannot evaluate ulimits: %w", err)
+	} else {
+		values := map[string]*mobycontainer.Ulimit{}
+		buf := opts.NewUlimitOpt(&values)
+		for _, raw := range raws {
+			if err := buf.Set(raw); err != nil {
+				return failf("illegal ulimit %q: %w", raw, err)
+			}
+		}
+		for _, v := range buf.GetList() {
+			target.Ulimits = append(target.Ulimits, &container.Ulimit{
+				Name: v.Name,
+				Soft: v.Soft,
+				Hard: v.Hard,
+			})
+		}
+	}
+	if raws, err := this.conf.DeviceRequests.Render(req); err != nil {
+		return failf("cannot evaluate deviceRequests: %w", err)
+	} else {
+		var buf opts.GpuOpts
+		for _, raw := range raws {
+			if err := buf.Set(raw); err != nil {
+				return failf("illegal deviceRequest %q: %w", raw, err)
+			}
+		}
+		for _, v := range buf.Value() {
+			target.DeviceRequests = append(target.DeviceRequests, container.DeviceRequest{
+				Driver:       v.Driver,
+				Count:        v.Count,
+				DeviceIDs:    v.DeviceIDs,
+				Capabilities: v.Capabilities,
+				Options:      v.Options,
+			})
+		}
+	}
+
+	var err error
+	if target.ReadonlyRootfs, err = this.conf.ReadOnlyRootfs.Render(req); err != nil {
+		return failf("cannot evaluate readOnlyRootfs: %w", err)
+	}
+	if raws, err := this.conf.Tmpfs.Render(req); err != nil {
+		return failf("cannot evaluate tmpfs: %w", err)
+	} else if target.Tmpfs, err = parseDockerTmpfs(raws); err != nil {
+		return failf("illegal tmpfs: %w", err)
+	}
+	if target.ReadonlyRootfs && this.hostOs == sys.OsLinux {
+		ensureDockerWritableStateDirectory(target)
+	}
+	if raws, err := this.conf.SecurityOptions.Render(req); err != nil {
+		return failf("cannot evaluate securityOptions: %w", err)
+	} else if target.SecurityOpt, err = parseDockerSecurityOptions(raws); err != nil {
+		return failf("illegal securityOptions: %w", err)
+	}
+	if v, err := this.conf.Init.Render(req); err != nil {
+		return failf("cannot evaluate init: %w", err)
+	} else if v {
+		target.Init = common.P(true)
+	}
+
+	return nil
+}
+
+func parseDockerEnv(raws []string) ([]string, error) {
+	result := make([]string, 0, len(raws))
+	for _, raw := range raws {
+		k, _, ok := strings.Cut(raw, "=")
+		if !ok || k == "" {
+			return nil, errors.Config.Newf("%q is not in the format <key>=<value>", raw)
+		}
+		if slices.Contains(dockerReservedEnvVars, k) {
+			return nil, errors.Config.Newf("environment variable %q is reserved", k)
+		}
+		result = append(result, raw)
+	}
+	return result, nil
+}
+
+func parseDockerLabels(raws []string) (map[string]string, error) {
+	result := make(map[string]string, len(raws))
+	for _, raw := range raws {
+		k, v, ok := strings.Cut(raw, "=")
+		if !ok || k == "" {
+			return nil, errors.Config.Newf("%q is not in the format <key>=<value>", raw)
+		}
+		if strings.HasPrefix(k, DockerLabelPrefix) {
+			return nil, errors.Config.Newf("label %q is reserved", k)
+		}
+		result[k] = v
+	}
+	return result, nil
+}
+
+func parseDockerTmpfs(raws []string) (map[string]string, error) {
+	if len(raws) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]string, len(raws))
+	for _, raw := range raws {
+		p, options, _ := strings.Cut(raw, ":")
+		if p == "" {
+			return nil, errors.Config.Newf("%q does not contain a path", raw)
+		}
+		if !path.IsAbs(p) {
+			return nil, errors.Config.Newf("path of %q is not absolute", raw)
+		}
+		result[p] = options
+	}
+	return result, nil
+}
+
+// ensureDockerWritableStateDirectory mounts a tmpfs at imp.StateDirectoryUnix
+// if it is not already covered by any other tmpfs, mount or volume of the
+// given config. Otherwise, the exit codes of the processes inside a container
+// with a read-only root filesystem would silently get lost.
+func ensureDockerWritableStateDirectory(target *container.HostConfig) {
+	covers := func(candidate string) bool {
+		candidate = path.Clean(candidate)
+		return candidate == "/" || imp.StateDirectoryUnix == candidate || strings.HasPrefix(imp.StateDirectoryUnix, candidate+"/")
+	}
+
+	for p := range target.Tmpfs {
+		if covers(p) {
+			return
+		}
+	}
+	for _, m := range target.Mounts {
+		if !m.ReadOnly && covers(m.Target) {
+			return
+		}
+	}
+	for _, bind := range target.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) < 2 {
+			continue
+		}
+		if len(parts) > 2 && slices.Contains(strings.Split(parts[2], ","), "ro") {
+			continue
+		}
+		if covers(parts[1]) {
+			return
+		}
+	}
+
+	if target.Tmpfs == nil {
+		target.Tmpfs = map[string]string{}
+	}
+	target.Tmpfs[imp.StateDirectoryUnix] = ""
+}
+
+// parseDockerSecurityOptions validates the given security options. Like the
+// Docker CLI does, a seccomp profile which is neither unconfined nor a JSON
+// document is read from the file with the given name.
+func parseDockerSecurityOptions(raws []string) ([]string, error) {
+	result := make([]string, 0, len(raws))
+	for _, raw := range raws {
+		k, v, ok := strings.Cut(raw, "=")
+		if !ok {
+			// Legacy format, like no-new-privileges:true
+			k, v, _ = strings.Cut(raw, ":")
+		}
+		if !slices.Contains(dockerSecurityOptionKeys, k) {
+			return nil, errors.Config.Newf("unsupported security option %q", raw)
+		}
+		if k == "seccomp" && v != "" && v != "unconfined" && v != "builtin" && !strings.HasPrefix(strings.TrimSpace(v), "{") {
+			b, err := os.ReadFile(v)
+			if err != nil {
+				return nil, errors.Config.Newf("cannot read seccomp profile %q: %w", v, err)
+			}
+			if !json.Valid(b) {
+				return nil, errors.Config.Newf("seccomp profile %q is not a valid JSON document", v)
+			}
+			raw = k + "=" + string(b)
+		}
+		result = append(result, raw)
+	}
+	return result, nil
+}
@@ -0,0 +1,139 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/imp"
+)
+
+func TestParseDockerEnv(t *testing.T) {
+	actual, err := parseDockerEnv([]string{"FOO=bar", "EMPTY=", "WITH=equals=sign"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"FOO=bar", "EMPTY=", "WITH=equals=sign"}, actual)
+
+	_, err = parseDockerEnv([]string{"FOO"})
+	require.ErrorContains(t, err, `"FOO" is not in the format <key>=<value>`)
+
+	_, err = parseDockerEnv([]string{"BIFROEST_SESSION_ID=foo"})
+	require.ErrorContains(t, err, `environment variable "BIFROEST_SESSION_ID" is reserved`)
+}
+
+func TestParseDockerLabels(t *testing.T) {
+	actual, err := parseDockerLabels([]string{"team=a", "empty="})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"team": "a", "empty": ""}, actual)
+
+	_, err = parseDockerLabels([]string{"=a"})
+	require.ErrorContains(t, err, `"=a" is not in the format <key>=<value>`)
+
+	_, err = parseDockerLabels([]string{DockerLabelFlow + "=foo"})
+	require.ErrorContains(t, err, `label "org.engity.bifroest/flow" is reserved`)
+}
+
+func TestParseDockerTmpfs(t *testing.T) {
+	actual, err := parseDockerTmpfs(nil)
+	require.NoError(t, err)
+	require.Nil(t, actual)
+
+	actual, err = parseDockerTmpfs([]string{"/tmp:size=64m,mode=1777", "/run"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/tmp": "size=64m,mode=1777", "/run": ""}, actual)
+
+	_, err = parseDockerTmpfs([]string{"tmp"})
+	require.ErrorContains(t, err, `path of "tmp" is not absolute`)
+}
+
+func TestEnsureDockerWritableStateDirectory(t *testing.T) {
+	cases := []struct {
+		name     string
+		given    container.HostConfig
+		expected map[string]string
+	}{{
+		name:     "empty",
+		expected: map[string]string{imp.StateDirectoryUnix: ""},
+	}, {
+		name:     "other-tmpfs",
+		given:    container.HostConfig{Tmpfs: map[string]string{"/tmp": ""}},
+		expected: map[string]string{"/tmp": "", imp.StateDirectoryUnix: ""},
+	}, {
+		name:     "covered-by-tmpfs",
+		given:    container.HostConfig{Tmpfs: map[string]string{"/var/lib": ""}},
+		expected: map[string]string{"/var/lib": ""},
+	}, {
+		name:  "covered-by-mount",
+		given: container.HostConfig{Mounts: []mount.Mount{{Type: mount.TypeVolume, Target: imp.StateDirectoryUnix}}},
+	}, {
+		name:     "read-only-mount",
+		given:    container.HostConfig{Mounts: []mount.Mount{{Type: mount.TypeVolume, Target: "/var", ReadOnly: true}}},
+		expected: map[string]string{imp.StateDirectoryUnix: ""},
+	}, {
+		name:  "covered-by-bind",
+		given: container.HostConfig{Binds: []string{"/data:/var/lib/engity"}},
+	}, {
+		name:     "read-only-bind",
+		given:    container.HostConfig{Binds: []string{"/data:/var/lib/engity:ro"}},
+		expected: map[string]string{imp.StateDirectoryUnix: ""},
+	}, {
+		name:     "only-sub-directory-covered",
+		given:    container.HostConfig{Binds: []string{"/data:" + imp.StateDirectoryUnix + "/homes"}},
+		expected: map[string]string{imp.StateDirectoryUnix: ""},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual := c.given
+			ensureDockerWritableStateDirectory(&actual)
+			require.Equal(t, c.expected, actual.Tmpfs)
+		})
+	}
+}
+
+func TestParseDockerSecurityOptions(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "profile.json")
+	require.NoError(t, os.WriteFile(profile, []byte(`{"defaultAction":"SCMP_ACT_ALLOW"}`), 0600))
+
+	cases := []struct {
+		name        string
+		given       []string
+		expected    []string
+		expectedErr string
+	}{{
+		name:     "simple",
+		given:    []string{"no-new-privileges", "no-new-privileges:true", "apparmor=unconfined", "seccomp=unconfined"},
+		expected: []string{"no-new-privileges", "no-new-privileges:true", "apparmor=unconfined", "seccomp=unconfined"},
+	}, {
+		name:     "seccomp-inline",
+		given:    []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`},
+		expected: []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`},
+	}, {
+		name:     "seccomp-file",
+		given:    []string{"seccomp=" + profile},
+		expected: []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`},
+	}, {
+		name:        "seccomp-missing-file",
+		given:       []string{"seccomp=" + profile + ".missing"},
+		expectedErr: "cannot read seccomp profile",
+	}, {
+		name:        "unknown",
+		given:       []string{"foo=bar"},
+		expectedErr: `unsupported security option "foo=bar"`,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := parseDockerSecurityOptions(c.given)
+			if c.expectedErr != "" {
+				require.ErrorContains(t, err, c.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/echocat/slf4g"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+// resolveHomeMount ensures that the volume of the persistent home of the
+// requesting user exists and records its login. It returns nil if no
+// persistent home is configured.
+func (this *DockerRepository) resolveHomeMount(req Request) (*mount.Mount, error) {
+	fail := func(err error) (*mount.Mount, error) {
+		return nil, err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (*mount.Mount, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	key, err := this.conf.Home.Key.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate home.key: %w", err)
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	directory, err := this.conf.Home.Directory.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate home.directory: %w", err)
+	}
+	if directory == "" {
+		if directory, err = this.conf.Directory.Render(req); err != nil {
+			return failf(errors.Config, "cannot evaluate directory: %w", err)
+		}
+	}
+	if directory == "" {
+		return failf(errors.Config, "neither home.directory nor directory is defined; cannot mount home")
+	}
+
+	driver, err := this.conf.Home.Driver.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate home.driver: %w", err)
+	}
+
+	name := homeVolumeNameOf(this.flow, key)
+	// If the volume already exists, it will be just returned.
+	if _, err := this.apiClient.VolumeCreate(req.Context(), volume.CreateOptions{
+		Name:   name,
+		Driver: driver,
+		Labels: map[string]string{
+			DockerLabelFlow:    this.flow.String(),
+			DockerLabelHomeKey: key,
+		},
+	}); err != nil {
+		return failf(errors.System, "cannot ensure home volume %q: %w", name, err)
+	}
+
+	if err := this.touchHomeLastLogin(name); err != nil {
+		return fail(err)
+	}
+
+	return &mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: name,
+		Target: directory,
+	}, nil
+}
+
+func (this *DockerRepository) homeLastLoginFile(name string) string {
+	return filepath.Join(this.conf.Home.StateDirectory, name)
+}
+
+func (this *DockerRepository) touchHomeLastLogin(name string) error {
+	fn := this.homeLastLoginFile(name)
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return errors.System.Newf("cannot create home state directory: %w", err)
+	}
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.System.Newf("cannot record last login of home %q: %w", name, err)
+	}
+	_ = f.Close()
+	now := time.Now()
+	if err := os.Chtimes(fn, now, now); err != nil {
+		return errors.System.Newf("cannot record last login of home %q: %w", name, err)
+	}
+	return nil
+}
+
+func (this *DockerRepository) homeLastLogin(v *volume.Volume) (time.Time, error) {
+	var result time.Time
+	if v.CreatedAt != "" {
+		if t, err := time.Parse(time.RFC3339, v.CreatedAt); err == nil {
+			result = t
+		}
+	}
+	fi, err := os.Stat(this.homeLastLoginFile(v.Name))
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.System.Newf("cannot read last login of home %q: %w", v.Name, err)
+	}
+	if t := fi.ModTime(); t.After(result) {
+		result = t
+	}
+	return result, nil
+}
+
+// cleanupHomes removes all home volumes of this flow whose owners have not
+// logged in for longer than the configured retention.
+func (this *DockerRepository) cleanupHomes(ctx context.Context, l log.Logger) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot cleanup expired homes: %w", err)
+	}
+
+	retention := this.conf.Home.Retention.Native()
+	if retention <= 0 {
+		return nil
+	}
+
+	list, err := this.apiClient.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", DockerLabelHomeKey),
+			filters.Arg("label", DockerLabelFlow+"="+this.flow.String()),
+		),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, v := range list.Volumes {
+		vl := l.With("volume", v.Name).
+			With("homeKey", v.Labels[DockerLabelHomeKey])
+
+		lastLogin, err := this.homeLastLogin(v)
+		if err != nil {
+			vl.WithError(err).
+				Warn("cannot determine last login of home; skipping...")
+			continue
+		}
+		vl = vl.With("lastLogin", lastLogin)
+		if time.Since(lastLogin) <= retention {
+			vl.Debug("found home within retention; ignoring...")
+			continue
+		}
+
+		users, err := this.apiClient.ContainerList(ctx, container.ListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("volume", v.Name)),
+		})
+		if err != nil {
+			return fail(err)
+		}
+		if len(users) > 0 {
+			vl.Debug("found expired home which is still in use; ignoring...")
+			continue
+		}
+
+		if err := this.apiClient.VolumeRemove(ctx, v.Name, false); err != nil {
+			vl.WithError(err).
+				Warn("cannot remove expired home; this message might continue appearing until manually fixed; skipping...")
+			continue
+		}
+		if err := os.Remove(this.homeLastLoginFile(v.Name)); err != nil && !os.IsNotExist(err) {
+			vl.WithError(err).
+				Warn("cannot remove last login record of removed home; ignoring...")
+		}
+		vl.Info("expired home removed")
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package environment
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+const (
+	dockerPoolMemberNamePrefix        = "bifroest-pool-"
+	dockerClaimedPoolMemberNamePrefix = "bifroest-session-"
+)
+
+// claimPoolMember tries to claim one unclaimed container of the pool for the
+// given session. As the labels of a container cannot be changed once it is
+// created, the container is bound to the session by renaming it (see
+// dockerClaimedPoolMemberNameOf). If there is no claimable container
+// available, nil is returned.
+func (this *DockerRepository) claimPoolMember(req Request, sess session.Session) (*container.Summary, error) {
+	fail := func(err error) (*container.Summary, error) {
+		return nil, errors.System.Newf("cannot claim container of pool for session %v: %w", sess, err)
+	}
+
+	if !this.conf.Pool.IsEnabled() {
+		return nil, nil
+	}
+
+	// The docker daemon does not ensure that a container which is renamed by
+	// two requests at the same time ends up with the name of only one of them.
+	this.poolClaimMutex.Lock()
+	defer this.poolClaimMutex.Unlock()
+
+	list, err := this.apiClient.ContainerList(req.Context(), container.ListOptions{
+		Filters: this.poolMemberFilters(),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	claimedName := dockerClaimedPoolMemberNameOf(sess.Id())
+	for _, candidate := range selectClaimableDockerPoolMembers(list) {
+		// Renaming by the old name ensures that a container, which was
+		// claimed by somebody else in the meanwhile, cannot be found anymore.
+		err := this.apiClient.ContainerRename(req.Context(), dockerContainerNameOf(candidate), claimedName)
+		if cerrdefs.IsNotFound(err) || cerrdefs.IsConflict(err) {
+			// Somebody else was faster, or it was removed meanwhile; try the next one...
+			continue
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		claimed, exitCode, err := this.findContainerById(req.Context(), candidate.ID)
+		if err != nil {
+			return fail(err)
+		}
+		if claimed == nil || exitCode >= 0 || dockerContainerNameOf(claimed) != claimedName {
+			continue
+		}
+
+		return claimed, nil
+	}
+
+	return nil, nil
+}
+
+// refillPool triggers asynchronously the creation of missing containers of
+// the pool. If a refill is already running, nothing happens.
+func (this *DockerRepository) refillPool() {
+	if !this.conf.Pool.IsEnabled() || this.poolCtx == nil {
+		return
+	}
+
+	go func() {
+		if err := this.fillPool(this.poolCtx); err != nil && !errors.Is(err, context.Canceled) {
+			this.logger().WithError(err).
+				Warn("cannot refill pool; will be retried with the next cleanup")
+		}
+	}()
+}
+
+// fillPool removes all dead and surplus containers of the pool and creates
+// the missing ones until the configured size is reached.
+func (this *DockerRepository) fillPool(ctx context.Context) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot fill pool: %w", err)
+	}
+
+	if !this.conf.Pool.IsEnabled() {
+		return nil
+	}
+	if !this.poolFilling.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer this.poolFilling.Store(false)
+
+	list, err := this.apiClient.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: this.poolMemberFilters(),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	var alive uint32
+	for _, candidate := range list {
+		if !isUnclaimedDockerPoolMember(&candidate) {
+			continue
+		}
+		cl := this.logger().
+			With("containerId", candidate.ID).
+			With("containerName", dockerContainerNameOf(&candidate))
+
+		switch candidate.State {
+		case container.StateCreated, container.StateRunning, container.StateRestarting:
+			if alive < this.conf.Pool.Size {
+				alive++
+				continue
+			}
+			if ok, err := this.removeContainer(ctx, candidate.ID); err != nil {
+				return fail(err)
+			} else if ok {
+				cl.Info("surplus container of pool removed")
+			}
+		default:
+			if ok, err := this.removeContainer(ctx, candidate.ID); err != nil {
+				return fail(err)
+			} else if ok {
+				cl.Info("dead container of pool removed")
+			}
+		}
+	}
+
+	for ; alive < this.conf.Pool.Size; alive++ {
+		created, err := this.createContainerBy(newPoolRequest(ctx, this.logger()), nil)
+		if err != nil {
+			return fail(err)
+		}
+		this.logger().
+			With("containerId", created.ID).
+			With("containerName", dockerContainerNameOf(created)).
+			Debug("container of pool created")
+	}
+
+	return nil
+}
+
+func (this *DockerRepository) poolMemberFilters() filters.Args {
+	return filters.NewArgs(
+		filters.Arg("label", DockerLabelFlow+"="+this.flow.String()),
+		filters.Arg("label", DockerLabelPool+"=true"),
+	)
+}
+
+// selectClaimableDockerPoolMembers selects all running and unclaimed
+// containers out of the given candidates. The oldest ones are first.
+func selectClaimableDockerPoolMembers(candidates []container.Summary) []*container.Summary {
+	var result []*container.Summary
+	for i, candidate := range candidates {
+		if candidate.State != container.StateRunning || !isUnclaimedDockerPoolMember(&candidate) {
+			continue
+		}
+		result = append(result, &candidates[i])
+	}
+	slices.SortStableFunc(result, func(a, b *container.Summary) int {
+		return cmp.Compare(a.Created, b.Created)
+	})
+	return result
+}
+
+func isUnclaimedDockerPoolMember(c *container.Summary) bool {
+	return c.Labels[DockerLabelPool] == "true" && strings.HasPrefix(dockerContainerNameOf(c), dockerPoolMemberNamePrefix)
+}
+
+// dockerClaimedPoolMemberNameOf returns the name of a container of the pool,
+// once it was claimed by the session with the given ID.
+func dockerClaimedPoolMemberNameOf(sessionId session.Id) string {
+	return dockerClaimedPoolMemberNamePrefix + sessionId.String()
+}
+
+// dockerClaimedPoolMemberSessionIdOf returns the ID of the session which
+// claimed the given container of the pool. If it was not claimed yet, a zero
+// ID is returned.
+func dockerClaimedPoolMemberSessionIdOf(c *container.Summary) (session.Id, error) {
+	var result session.Id
+	plain, ok := strings.CutPrefix(dockerContainerNameOf(c), dockerClaimedPoolMemberNamePrefix)
+	if c.Labels[DockerLabelPool] != "true" || !ok {
+		return result, nil
+	}
+	if err := result.UnmarshalText([]byte(plain)); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func dockerContainerNameOf(c *container.Summary) string {
+	if len(c.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
@@ -0,0 +1,71 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+func TestSelectClaimableDockerPoolMembers(t *testing.T) {
+	newContainer := func(name string, created int64, state container.ContainerState) container.Summary {
+		return container.Summary{
+			Names:   []string{"/" + name},
+			Created: created,
+			State:   state,
+			Labels:  map[string]string{DockerLabelPool: "true"},
+		}
+	}
+	claimed := newContainer(dockerClaimedPoolMemberNameOf(session.MustNewId()), 1, container.StateRunning)
+
+	actual := selectClaimableDockerPoolMembers([]container.Summary{
+		newContainer(dockerPoolMemberNamePrefix+"young", 200, container.StateRunning),
+		claimed,
+		newContainer(dockerPoolMemberNamePrefix+"created", 1, container.StateCreated),
+		newContainer(dockerPoolMemberNamePrefix+"exited", 1, container.StateExited),
+		newContainer(dockerPoolMemberNamePrefix+"old", 100, container.StateRunning),
+	})
+
+	var names []string
+	for _, v := range actual {
+		names = append(names, dockerContainerNameOf(v))
+	}
+	require.Equal(t, []string{dockerPoolMemberNamePrefix + "old", dockerPoolMemberNamePrefix + "young"}, names)
+}
+
+func TestDocker_parseContainer_claimedPoolMember(t *testing.T) {
+	sessionId := session.MustNewId()
+	impSessionId := session.MustNewId()
+	repository := &DockerRepository{
+		flow: configuration.FlowName("foo"),
+		conf: &configuration.EnvironmentDocker{},
+	}
+
+	c := container.Summary{
+		ID:    "abc",
+		Names: []string{"/" + dockerClaimedPoolMemberNameOf(sessionId)},
+		Labels: map[string]string{
+			DockerLabelFlow:         "foo",
+			DockerLabelPool:         "true",
+			DockerLabelImpSessionId: impSessionId.String(),
+			DockerLabelShellCommand: `["/bin/sh"]`,
+			DockerLabelExecCommand:  `["/bin/sh","-c"]`,
+		},
+		NetworkSettings: &container.NetworkSettingsSummary{
+			Networks: map[string]*network.EndpointSettings{"bridge": {IPAddress: "172.17.0.2"}},
+		},
+	}
+
+	actual := &docker{repository: repository}
+	require.NoError(t, actual.parseContainer(&c))
+	require.Equal(t, sessionId, actual.sessionId)
+	require.Equal(t, impSessionId, actual.SessionId())
+
+	// An unclaimed container of the pool does not belong to any session.
+	c.Names = []string{"/" + dockerPoolMemberNamePrefix + impSessionId.String()}
+	require.ErrorContains(t, (&docker{repository: repository}).parseContainer(&c), "missing label "+DockerLabelSessionId)
+}
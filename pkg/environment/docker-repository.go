@@ -5,11 +5,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	gonet "net"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	cerrdefs "github.com/containerd/errdefs"
 	"github.com/docker/cli/opts"
@@ -47,6 +49,8 @@ const (
 	DockerLabelSessionId         = DockerLabelPrefix + "session-id"
 	DockerLabelCreatedRemoteUser = DockerLabelPrefix + "created-remote-user"
 	DockerLabelCreatedRemoteHost = DockerLabelPrefix + "created-remote-host"
+	DockerLabelPool              = DockerLabelPrefix + "pool"
+	DockerLabelImpSessionId      = DockerLabelPrefix + "imp-session-id"
 
 	DockerLabelShellCommand          = DockerLabelPrefix + "shellCommand"
 	DockerLabelExecCommand           = DockerLabelPrefix + "execCommand"
@@ -54,6 +58,11 @@ const (
 	DockerLabelUser                  = DockerLabelPrefix + "user"
 	DockerLabelDirectory             = DockerLabelPrefix + "directory"
 	DockerLabelPortForwardingAllowed = DockerLabelPrefix + "portForwardingAllowed"
+	DockerLabelSocketForwardingPaths = DockerLabelPrefix + "socketForwardingPaths"
+	DockerLabelX11ForwardingAllowed  = DockerLabelPrefix + "x11ForwardingAllowed"
+	DockerLabelTerminalsPersistent   = DockerLabelPrefix + "terminalsPersistent"
+
+	DockerLabelHomeKey = DockerLabelPrefix + "home-key"
 )
 
 type DockerRepository struct {
@@ -73,6 +82,11 @@ type DockerRepository struct {
 	sessionIdMutex  common.KeyedMutex[session.Id]
 	activeInstances sync.Map
 
+	poolCtx        context.Context
+	poolCancel     context.CancelFunc
+	poolFilling    atomic.Bool
+	poolClaimMutex sync.Mutex
+
 	rawDialer gonet.Dialer
 }
 
@@ -106,6 +120,7 @@ func NewDockerRepository(ctx context.Context, flow configuration.FlowName, conf
 		apiClient:    apiClient,
 		hostVersion:  &hostVersion,
 	}
+	result.poolCtx, result.poolCancel = context.WithCancel(context.Background())
 
 	if err = result.hostOs.SetOci(result.hostVersion.Os); err != nil {
 		return failf("cannot parse docker host's os: %w", err)
@@ -177,6 +192,12 @@ func (this *DockerRepository) createContainerBy(req Request, sess session.Sessio
 	if err != nil {
 		return fail(err)
 	}
+	// Containers of the pool are bound to their session by their name, once
+	// they are claimed; see claimPoolMember.
+	var name string
+	if sess == nil {
+		name = dockerPoolMemberNamePrefix + config.Labels[DockerLabelImpSessionId]
+	}
 	hostConfig, err := this.resolveHostConfig(req)
 	if err != nil {
 		return fail(err)
@@ -192,12 +213,12 @@ func (this *DockerRepository) createContainerBy(req Request, sess session.Sessio
 		}
 	}
 	success := false
-	cr, err := this.apiClient.ContainerCreate(req.Context(), config, hostConfig, networkingConfig, nil, "")
+	cr, err := this.apiClient.ContainerCreate(req.Context(), config, hostConfig, networkingConfig, nil, name)
 	if this.isNoSuchImageError(err) && this.conf.ImagePullPolicy != configuration.PullPolicyAlways && this.conf.ImagePullPolicy != configuration.PullPolicyNever {
 		if err := this.pullImage(req, config.Image); err != nil {
 			return failf(errors.System, "cannot pull container image %s: %w", config.Image, err)
 		}
-		cr, err = this.apiClient.ContainerCreate(req.Context(), config, hostConfig, networkingConfig, nil, "")
+		cr, err = this.apiClient.ContainerCreate(req.Context(), config, hostConfig, networkingConfig, nil, name)
 	}
 	if err != nil {
 		return failf(errors.System, "cannot create container: %w", err)
@@ -263,6 +284,10 @@ func (this *DockerRepository) pullImage(req Request, ref string) error {
 	defer common.IgnoreCloseError(rc)
 
 	if progress == nil {
+		// The image is only pulled completely, if its stream is consumed.
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			return fail(err)
+		}
 		return nil
 	}
 
@@ -284,6 +309,18 @@ func (this *DockerRepository) resolvePullCredentials(req Request, _ string) (str
 		return fail(err)
 	}
 
+	result, err := encodeRegistryAuth(plain)
+	if err != nil {
+		return fail(err)
+	}
+	return result, nil
+}
+
+// encodeRegistryAuth transforms the given plain credentials into the format
+// expected by the X-Registry-Auth header of the Docker (and Podman) API. It
+// accepts either already encoded credentials, a JSON document or a direct
+// auth string.
+func encodeRegistryAuth(plain string) (string, error) {
 	if plain == "" {
 		return "", nil
 	}
@@ -301,11 +338,7 @@ func (this *DockerRepository) resolvePullCredentials(req Request, _ string) (str
 
 	// Seems to be direct auth string...
 	buf.Auth = plain
-	result, err := registry.EncodeAuthConfig(buf)
-	if err != nil {
-		return fail(err)
-	}
-	return result, nil
+	return registry.EncodeAuthConfig(buf)
 }
 
 func (this *DockerRepository) resolveContainerConfig(req Request, sess session.Session) (_ *container.Config, err error) {
@@ -318,13 +351,24 @@ func (this *DockerRepository) resolveContainerConfig(req Request, sess session.S
 
 	var result container.Config
 
-	remote := req.Connection().Remote()
+	// Containers of the pool do not belong to any session before they are
+	// claimed. Their IMP is bound to a generated session ID instead.
 	result.Labels = map[string]string{
-		DockerLabelFlow:      this.flow.String(),
-		DockerLabelSessionId: sess.Id().String(),
-
-		DockerLabelCreatedRemoteUser: remote.User(),
-		DockerLabelCreatedRemoteHost: remote.Host().String(),
+		DockerLabelFlow: this.flow.String(),
+	}
+	var impSessionId session.Id
+	if sess != nil {
+		impSessionId = sess.Id()
+		remote := req.Connection().Remote()
+		result.Labels[DockerLabelSessionId] = sess.Id().String()
+		result.Labels[DockerLabelCreatedRemoteUser] = remote.User()
+		result.Labels[DockerLabelCreatedRemoteHost] = remote.Host().String()
+	} else {
+		if impSessionId, err = session.NewId(); err != nil {
+			return fail(err)
+		}
+		result.Labels[DockerLabelPool] = "true"
+		result.Labels[DockerLabelImpSessionId] = impSessionId.String()
 	}
 	if result.Labels[DockerLabelShellCommand], err = this.resolveEncodedShellCommand(req); err != nil {
 		return fail(err)
@@ -346,6 +390,25 @@ func (this *DockerRepository) resolveContainerConfig(req Request, sess session.S
 	} else if v {
 		result.Labels[DockerLabelPortForwardingAllowed] = "true"
 	}
+	if v, err := this.conf.SocketForwardingPaths.Render(req); err != nil {
+		return failf("cannot evaluate socketForwardingPaths: %w", err)
+	} else if len(v) > 0 {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return failf("cannot encode socketForwardingPaths: %w", err)
+		}
+		result.Labels[DockerLabelSocketForwardingPaths] = string(b)
+	}
+	if v, err := this.conf.X11ForwardingAllowed.Render(req); err != nil {
+		return failf("cannot evaluate x11ForwardingAllowed: %w", err)
+	} else if v {
+		result.Labels[DockerLabelX11ForwardingAllowed] = "true"
+	}
+	if v, err := this.conf.Terminals.Persistent.Render(req); err != nil {
+		return failf("cannot evaluate terminals.persistent: %w", err)
+	} else if v {
+		result.Labels[DockerLabelTerminalsPersistent] = "true"
+	}
 
 	result.ExposedPorts = map[nat.Port]struct{}{
 		nat.Port(fmt.Sprintf("%d/tcp", imp.ServicePort)): {},
@@ -375,7 +438,11 @@ func (this *DockerRepository) resolveContainerConfig(req Request, sess session.S
 
 	result.Env = []string{
 		imp.EnvVarMasterPublicKey + "=" + base64.RawStdEncoding.EncodeToString(masterPub.Marshal()),
-		session.EnvName + "=" + sess.Id().String(),
+		session.EnvName + "=" + impSessionId.String(),
+	}
+
+	if err := this.applyContainerOptions(req, &result); err != nil {
+		return fail(err)
 	}
 
 	return &result, nil
@@ -412,6 +479,11 @@ func (this *DockerRepository) resolveHostConfig(req Request) (_ *container.HostC
 			result.Mounts[i] = toDockerMount(value)
 		}
 	}
+	if v, err := this.resolveHomeMount(req); err != nil {
+		return fail(err)
+	} else if v != nil {
+		result.Mounts = append(result.Mounts, *v)
+	}
 	if result.CapAdd, err = this.conf.Capabilities.Render(req); err != nil {
 		return failf("cannot evaluate capabilities: %w", err)
 	}
@@ -424,6 +496,9 @@ func (this *DockerRepository) resolveHostConfig(req Request) (_ *container.HostC
 	if result.DNSSearch, err = this.conf.DnsSearch.Render(req); err != nil {
 		return failf("cannot evaluate dnsSearch: %w", err)
 	}
+	if err := this.applyHostOptions(req, &result); err != nil {
+		return fail(err)
+	}
 
 	// result.PortBindings = nat.PortMap{nat.Port(fmt.Sprintf("%d/tcp", imp.ServicePort)): {{
 	// 	HostIP:   impBinding.Host.String(),
@@ -676,10 +751,15 @@ func (this *DockerRepository) findOrEnsureBySession(ctx context.Context, sess se
 	}
 
 	if c == nil {
-		c, err = this.createContainerBy(createUsing, sess)
-		if err != nil {
+		if c, err = this.claimPoolMember(createUsing, sess); err != nil {
 			return fail(err)
 		}
+		if c == nil {
+			if c, err = this.createContainerBy(createUsing, sess); err != nil {
+				return fail(err)
+			}
+		}
+		this.refillPool()
 	}
 
 	logger := this.logger().
@@ -729,8 +809,16 @@ func (this *DockerRepository) removeContainer(ctx context.Context, id string) (b
 }
 
 func (this *DockerRepository) findContainerBySession(ctx context.Context, sess session.Session) (c *container.Summary, exitCode int, err error) {
-	return this.findContainerBy(ctx, filters.NewArgs(
+	if c, exitCode, err = this.findContainerBy(ctx, filters.NewArgs(
 		filters.Arg("label", DockerLabelSessionId+"="+sess.Id().String()),
+	)); err != nil || c != nil {
+		return c, exitCode, err
+	}
+	// Claimed containers of the pool carry their session only in their name.
+	return this.findContainerBy(ctx, filters.NewArgs(
+		filters.Arg("label", DockerLabelFlow+"="+this.flow.String()),
+		filters.Arg("label", DockerLabelPool+"=true"),
+		filters.Arg("name", "^/"+dockerClaimedPoolMemberNameOf(sess.Id())+"$"),
 	))
 }
 func (this *DockerRepository) findContainerById(ctx context.Context, id string) (c *container.Summary, exitCode int, err error) {
@@ -768,6 +856,9 @@ func (this *DockerRepository) findContainerBy(ctx context.Context, filters filte
 }
 
 func (this *DockerRepository) Close() error {
+	if v := this.poolCancel; v != nil {
+		v()
+	}
 	return nil
 }
 
@@ -837,6 +928,10 @@ func (this *DockerRepository) Cleanup(ctx context.Context, opts *CleanupOpts) er
 		}
 	}
 
+	if err := this.cleanupHomes(ctx, l); err != nil {
+		return err
+	}
+	this.refillPool()
 	return nil
 }
 
@@ -3,6 +3,7 @@ package environment
 import (
 	"context"
 	"io"
+	gonet "net"
 	"os"
 	"slices"
 	"strings"
@@ -67,7 +68,11 @@ func (this *docker) Run(t Task) (exitCode int, rErr error) {
 	ev.AddAllOf(t.Authorization().EnvVars())
 	ev.Add(t.SshSession().Environ()...)
 	ev.Set(session.EnvName, sess.Id().String())
-	ev.Set(connection.EnvName, t.Connection().Id().String())
+
+	binding := connectionBindingOf(t, this.terminalsPersistent, this.x11ForwardingAllowed)
+	if binding.connectionId {
+		ev.Set(connection.EnvName, t.Connection().Id().String())
+	}
 
 	switch t.TaskType() {
 	case TaskTypeShell:
@@ -82,7 +87,7 @@ func (this *docker) Run(t Task) (exitCode int, rErr error) {
 		return failf("illegal task type: %v", t.TaskType())
 	}
 
-	if ssh.AgentRequested(sshSess) {
+	if binding.agent {
 		ln, err := this.impSession.InitiateNamedPipe(t.Context(), t.Connection().Id(), "ssh-agent")
 		var re errors.RemoteError
 		if errors.As(err, &re) {
@@ -96,6 +101,36 @@ func (this *docker) Run(t Task) (exitCode int, rErr error) {
 		}
 	}
 
+	if x11 := binding.x11; x11 != nil {
+		user, group, _ := strings.Cut(this.user, ":")
+		ln, err := this.impSession.InitiateX11Listen(t.Context(), t.Connection().Id(), x11.AuthProtocol(), x11.Cookie(), user, group)
+		var re errors.RemoteError
+		if errors.As(err, &re) {
+			l.WithError(err).Warn("it was not possible to initiate X11 display; X11 forwarding deactivated")
+		} else if err != nil {
+			return fail(err)
+		} else {
+			defer common.IgnoreCloseError(ln)
+			go ssh.ForwardX11Connections(ln, l, sshSess, x11)
+			ev.Set(
+				ssh.DisplayEnvName, x11.Display(ln.Display()),
+				ssh.XAuthorityEnvName, ln.Authority(),
+			)
+		}
+	}
+
+	if binding.persistentTerminal {
+		ptyReq, _, _ := sshSess.Pty()
+		ev.Set("TERM", ptyReq.Term)
+		start := imp.TerminalStart{
+			Argv: opts.Cmd,
+			Dir:  opts.WorkingDir,
+			Env:  ev,
+		}
+		start.User, start.Group, _ = strings.Cut(opts.User, ":")
+		return runPersistentTerminal(t, this.impSession, &this.repository.conf.Terminals, start)
+	}
+
 	var execId string
 	if ptyReq, winCh, isPty := sshSess.Pty(); isPty {
 		ev.Set("TERM", ptyReq.Term)
@@ -251,3 +286,58 @@ func (this *docker) NewDestinationConnection(ctx context.Context, dest net.HostP
 
 	return this.impSession.InitiateTcpForward(ctx, connId, dest)
 }
+
+func (this *docker) NewRemoteListener(ctx context.Context, bind net.HostPort) (RemoteListener, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := this.impSession.InitiateTcpListen(ctx, connId, bind)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *docker) IsSocketForwardingAllowed(path string) (bool, error) {
+	return this.portForwardingAllowed && net.IsSocketPathAllowed(this.socketForwardingPaths, path), nil
+}
+
+func (this *docker) NewDestinationSocketConnection(ctx context.Context, path string) (io.ReadWriteCloser, error) {
+	if ok, _ := this.IsSocketForwardingAllowed(path); !ok {
+		return nil, errors.Newf(errors.Permission, "socket forwarding to %s not allowed", path)
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	return this.impSession.InitiateStreamLocalForward(ctx, connId, path, this.socketForwardingPaths)
+}
+
+func (this *docker) NewRemoteSocketListener(ctx context.Context, path string) (gonet.Listener, error) {
+	if ok, _ := this.IsSocketForwardingAllowed(path); !ok {
+		return nil, errors.Newf(errors.Permission, "socket forwarding at %s not allowed", path)
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := this.impSession.InitiateStreamLocalListen(ctx, connId, path, this.socketForwardingPaths)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *docker) IsX11ForwardingAllowed() (bool, error) {
+	return this.x11ForwardingAllowed, nil
+}
@@ -29,6 +29,10 @@ type docker struct {
 	containerId string
 	sessionId   session.Id
 
+	// impSessionId is the session ID the IMP was started with. It differs
+	// from sessionId if the container was claimed from the pool.
+	impSessionId session.Id
+
 	remoteUser string
 	remoteHost net.Host
 
@@ -39,6 +43,9 @@ type docker struct {
 	directory    string
 
 	portForwardingAllowed bool
+	socketForwardingPaths []string
+	x11ForwardingAllowed  bool
+	terminalsPersistent   bool
 
 	impBinding net.HostPort
 	impSession imp.Session
@@ -47,7 +54,7 @@ type docker struct {
 }
 
 func (this *docker) SessionId() session.Id {
-	return this.sessionId
+	return this.impSessionId
 }
 
 func (this *docker) PublicKey() crypto.PublicKey {
@@ -166,17 +173,31 @@ func (this *docker) parseContainer(container *types.Container) (err error) {
 	} else if v != this.repository.flow.String() {
 		return failf("expected flow: %v; bot container had: %v", this.repository.flow, v)
 	}
-	if v := labels[DockerLabelSessionId]; v == "" {
+	claimedBy, err := dockerClaimedPoolMemberSessionIdOf(container)
+	if err != nil {
+		return failf("cannot decode session of claimed container of pool: %w", err)
+	}
+	if v := labels[DockerLabelSessionId]; v == "" && !claimedBy.IsZero() {
+		this.sessionId = claimedBy
+	} else if v == "" {
 		return failf("missing label %s", DockerLabelSessionId)
 	} else if err = this.sessionId.UnmarshalText([]byte(v)); err != nil {
 		return failf("cannot decode label %s: %w", DockerLabelSessionId, err)
 	}
+	if v := labels[DockerLabelImpSessionId]; v == "" {
+		this.impSessionId = this.sessionId
+	} else if err = this.impSessionId.UnmarshalText([]byte(v)); err != nil {
+		return failf("cannot decode label %s: %w", DockerLabelImpSessionId, err)
+	}
 
+	// Containers of the pool were not created for any remote.
 	this.remoteUser = labels[DockerLabelCreatedRemoteUser]
-	if v := labels[DockerLabelCreatedRemoteHost]; v == "" {
+	if v := labels[DockerLabelCreatedRemoteHost]; v != "" {
+		if err = this.remoteHost.Set(v); err != nil {
+			return failf("cannot decode label %s: %w", DockerLabelCreatedRemoteHost, err)
+		}
+	} else if claimedBy.IsZero() {
 		return failf("missing label %s", DockerLabelCreatedRemoteHost)
-	} else if err = this.remoteHost.Set(v); err != nil {
-		return failf("cannot decode label %s: %w", DockerLabelCreatedRemoteHost, err)
 	}
 
 	if v := labels[DockerLabelShellCommand]; v == "" {
@@ -198,6 +219,13 @@ func (this *docker) parseContainer(container *types.Container) (err error) {
 	this.user = labels[DockerLabelUser]
 	this.directory = labels[DockerLabelDirectory]
 	this.portForwardingAllowed = labels[DockerLabelPortForwardingAllowed] == "true"
+	if v := labels[DockerLabelSocketForwardingPaths]; v == "" {
+		this.socketForwardingPaths = nil
+	} else if this.socketForwardingPaths, err = decodeStrings(v); err != nil {
+		return failf("cannot decode label %s: %w", DockerLabelSocketForwardingPaths, err)
+	}
+	this.x11ForwardingAllowed = labels[DockerLabelX11ForwardingAllowed] == "true"
+	this.terminalsPersistent = labels[DockerLabelTerminalsPersistent] == "true"
 
 	if this.impBinding, err = this.resolveImpBinding(container); err != nil {
 		return fail(err)
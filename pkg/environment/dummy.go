@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"math"
+	gonet "net"
 	"strings"
 
 	"github.com/engity-com/bifroest/pkg/net"
@@ -45,6 +46,26 @@ func (this *dummy) NewDestinationConnection(context.Context, net.HostPort) (io.R
 	return nil, nil
 }
 
+func (this *dummy) NewRemoteListener(context.Context, net.HostPort) (RemoteListener, error) {
+	return nil, nil
+}
+
+func (this *dummy) IsSocketForwardingAllowed(string) (bool, error) {
+	return false, nil
+}
+
+func (this *dummy) NewDestinationSocketConnection(context.Context, string) (io.ReadWriteCloser, error) {
+	return nil, nil
+}
+
+func (this *dummy) NewRemoteSocketListener(context.Context, string) (gonet.Listener, error) {
+	return nil, nil
+}
+
+func (this *dummy) IsX11ForwardingAllowed() (bool, error) {
+	return false, nil
+}
+
 func (this *dummy) Dispose(context.Context) (bool, error) {
 	return false, nil
 }
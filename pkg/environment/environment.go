@@ -3,6 +3,7 @@ package environment
 import (
 	"context"
 	"io"
+	gonet "net"
 
 	"github.com/engity-com/bifroest/pkg/net"
 )
@@ -14,6 +15,24 @@ type Environment interface {
 	IsPortForwardingAllowed(net.HostPort) (bool, error)
 	NewDestinationConnection(context.Context, net.HostPort) (io.ReadWriteCloser, error)
 
+	// NewRemoteListener listens at the given bind address inside this
+	// environment, to serve a remote port forwarding requested by the client.
+	NewRemoteListener(context.Context, net.HostPort) (RemoteListener, error)
+
+	// IsSocketForwardingAllowed checks if the unix socket at the given path
+	// can be forwarded (in both directions).
+	IsSocketForwardingAllowed(path string) (bool, error)
+	NewDestinationSocketConnection(ctx context.Context, path string) (io.ReadWriteCloser, error)
+
+	// NewRemoteSocketListener listens at a unix socket at the given path
+	// inside this environment, to serve a remote socket forwarding requested
+	// by the client.
+	NewRemoteSocketListener(ctx context.Context, path string) (gonet.Listener, error)
+
+	// IsX11ForwardingAllowed checks if a X11 forwarding, requested by the
+	// client for a session, is provided to the processes of that session.
+	IsX11ForwardingAllowed() (bool, error)
+
 	// Dispose will fully dispose this instance.
 	// It does also implicitly call Close() to ensure everything happens
 	// in the correct synchronized context.
@@ -0,0 +1,50 @@
+package environment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+)
+
+const (
+	homeVolumeNamePrefix = "bifroest-home-"
+	homeVolumeName       = "home"
+)
+
+// homeVolumeNameOf creates the name of the volume which holds the
+// persistent home of the given key. The result is valid as name of Docker
+// volumes and of Kubernetes PersistentVolumeClaims.
+func homeVolumeNameOf(flow configuration.FlowName, key string) string {
+	hash := sha256.Sum256([]byte(flow.String() + "\x00" + key))
+
+	var buf strings.Builder
+	buf.WriteString(homeVolumeNamePrefix)
+	for _, part := range []string{sanitizeHomeVolumeNamePart(flow.String(), 20), sanitizeHomeVolumeNamePart(key, 24)} {
+		if part != "" {
+			buf.WriteString(part)
+			buf.WriteByte('-')
+		}
+	}
+	buf.WriteString(hex.EncodeToString(hash[:6]))
+	return buf.String()
+}
+
+func sanitizeHomeVolumeNamePart(in string, maxLen int) string {
+	var buf strings.Builder
+	lastWasDash := true
+	for _, c := range strings.ToLower(in) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			buf.WriteRune(c)
+			lastWasDash = false
+		} else if !lastWasDash {
+			buf.WriteByte('-')
+			lastWasDash = true
+		}
+		if buf.Len() >= maxLen {
+			break
+		}
+	}
+	return strings.TrimRight(buf.String(), "-")
+}
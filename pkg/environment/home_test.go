@@ -0,0 +1,44 @@
+package environment
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+)
+
+func TestHomeVolumeNameOf(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+	cases := []struct {
+		flow           string
+		key            string
+		expectedPrefix string
+	}{{
+		flow:           "default",
+		key:            "Foo.Bar@example.com",
+		expectedPrefix: "bifroest-home-default-foo-bar-example-com-",
+	}, {
+		flow:           "default",
+		key:            "@@@",
+		expectedPrefix: "bifroest-home-default-",
+	}, {
+		flow:           "my_flow",
+		key:            "a-very-long-key-which-has-to-be-truncated@example.com",
+		expectedPrefix: "bifroest-home-my-flow-a-very-long-key-which-ha-",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			flow := configuration.FlowName(c.flow)
+			actual := homeVolumeNameOf(flow, c.key)
+
+			require.Regexp(t, valid, actual)
+			require.Equal(t, c.expectedPrefix, actual[:len(actual)-12])
+			require.Equal(t, actual, homeVolumeNameOf(flow, c.key))
+			require.NotEqual(t, actual, homeVolumeNameOf(flow, c.key+"x"))
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	glssh "github.com/gliderlabs/ssh"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/engity-com/bifroest/pkg/alternatives"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	bkp "github.com/engity-com/bifroest/pkg/kubernetes"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+var (
+	_ = RegisterRepository(NewKubernetesAttachRepository)
+)
+
+const (
+	// kubernetesDefaultContainerAnnotation is used by kubectl to select the
+	// container of a POD if none was specified.
+	kubernetesDefaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+)
+
+type KubernetesAttachRepository struct {
+	flow configuration.FlowName
+	conf *configuration.EnvironmentKubernetesAttach
+
+	client bkp.Client
+}
+
+func NewKubernetesAttachRepository(_ context.Context, flow configuration.FlowName, conf *configuration.EnvironmentKubernetesAttach, _ alternatives.Provider, _ imp.Imp) (*KubernetesAttachRepository, error) {
+	fail := func(err error) (*KubernetesAttachRepository, error) {
+		return nil, err
+	}
+	failf := func(msg string, args ...any) (*KubernetesAttachRepository, error) {
+		return fail(fmt.Errorf(msg, args...))
+	}
+
+	if conf == nil {
+		return failf("nil configuration")
+	}
+
+	core := struct{}{}
+	kCfg, err := conf.Config.Render(&core)
+	if err != nil {
+		return fail(err)
+	}
+
+	kCtx, err := conf.Context.Render(&core)
+	if err != nil {
+		return fail(err)
+	}
+
+	client, err := kCfg.GetClient(kCtx)
+	if err != nil {
+		return fail(err)
+	}
+
+	return &KubernetesAttachRepository{
+		flow:   flow,
+		conf:   conf,
+		client: client,
+	}, nil
+}
+
+func (this *KubernetesAttachRepository) WillBeAccepted(ctx Context) (ok bool, err error) {
+	fail := func(err error) (bool, error) {
+		return false, err
+	}
+
+	if ok, err = this.conf.LoginAllowed.Render(ctx); err != nil {
+		return fail(fmt.Errorf("cannot evaluate if user is allowed to login or not: %w", err))
+	}
+
+	return ok, nil
+}
+
+func (this *KubernetesAttachRepository) DoesSupportPty(Context, glssh.Pty) (bool, error) {
+	return true, nil
+}
+
+func (this *KubernetesAttachRepository) Ensure(req Request) (Environment, error) {
+	fail := func(err error) (Environment, error) {
+		return nil, err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (Environment, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	if ok, err := this.WillBeAccepted(req); err != nil {
+		return fail(err)
+	} else if !ok {
+		return fail(ErrNotAcceptable)
+	}
+
+	namespace, err := this.conf.Namespace.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate namespace: %w", err)
+	}
+	if namespace == "" {
+		namespace = this.client.Namespace()
+	}
+
+	containerName, err := this.conf.Container.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate container: %w", err)
+	}
+
+	pod, err := this.findTarget(req, namespace, containerName)
+	if err != nil {
+		return fail(err)
+	}
+	if containerName, err = kubernetesAttachContainerOf(pod, containerName); err != nil {
+		return fail(err)
+	}
+
+	result := kubernetesAttach{
+		repository: this,
+		namespace:  pod.Namespace,
+		name:       pod.Name,
+		container:  containerName,
+	}
+	if result.shellCommand, err = this.conf.ShellCommand.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate shellCommand: %w", err)
+	}
+	if len(result.shellCommand) == 0 {
+		return failf(errors.Config, "shellCommand evaluated to an empty command")
+	}
+	if result.execCommand, err = this.conf.ExecCommand.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate execCommand: %w", err)
+	}
+	if len(result.execCommand) == 0 {
+		return failf(errors.Config, "execCommand evaluated to an empty command")
+	}
+	if result.sftpCommand, err = this.conf.SftpCommand.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate sftpCommand: %w", err)
+	}
+	if result.portForwardingAllowed, err = this.conf.PortForwardingAllowed.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate portForwardingAllowed: %w", err)
+	}
+
+	req.Connection().Logger().
+		With("namespace", result.namespace).
+		With("pod", result.name).
+		With("container", result.container).
+		Info("attached to existing pod")
+
+	return &result, nil
+}
+
+func (this *KubernetesAttachRepository) findTarget(req Request, namespace, containerName string) (*v1.Pod, error) {
+	fail := func(err error) (*v1.Pod, error) {
+		return nil, err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (*v1.Pod, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+	pods := clientSet.CoreV1().Pods(namespace)
+
+	podName, err := this.conf.Pod.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate pod: %w", err)
+	}
+	selector, err := this.conf.Selector.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate selector: %w", err)
+	}
+	owner, err := this.conf.Owner.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate owner: %w", err)
+	}
+
+	var candidates []v1.Pod
+	switch {
+	case podName != "":
+		pod, err := pods.Get(req.Context(), podName, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return failf(errors.User, "pod %v/%v does not exist", namespace, podName)
+		} else if err != nil {
+			return failf(errors.System, "cannot get pod %v/%v: %w", namespace, podName, err)
+		}
+		candidates = []v1.Pod{*pod}
+	case selector != "":
+		if _, err := labels.Parse(selector); err != nil {
+			return failf(errors.Config, "illegal selector %q: %w", selector, err)
+		}
+	case owner != "":
+		if selector, err = this.selectorOfOwner(req.Context(), namespace, owner); err != nil {
+			return fail(err)
+		}
+	default:
+		return failf(errors.Config, "neither pod, selector nor owner evaluated to a value")
+	}
+
+	if candidates == nil {
+		list, err := pods.List(req.Context(), metav1.ListOptions{
+			LabelSelector: selector,
+		})
+		if err != nil {
+			return failf(errors.System, "cannot list pods of %v matching %q: %w", namespace, selector, err)
+		}
+		candidates = list.Items
+	}
+
+	result := selectAttachablePod(candidates, containerName)
+	if result == nil {
+		return failf(errors.User, "there is no running pod in namespace %v to attach to", namespace)
+	}
+	return result, nil
+}
+
+func (this *KubernetesAttachRepository) selectorOfOwner(ctx context.Context, namespace, owner string) (string, error) {
+	fail := func(err error) (string, error) {
+		return "", err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (string, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	kind, name, ok := strings.Cut(owner, "/")
+	if !ok || name == "" {
+		return failf(errors.Config, "illegal owner %q; expected format: <kind>/<name>", owner)
+	}
+
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+
+	var selector *metav1.LabelSelector
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments", "deploy":
+		v, err := clientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return failf(errors.System, "cannot get owner %v/%v: %w", namespace, owner, err)
+		}
+		selector = v.Spec.Selector
+	case "statefulset", "statefulsets", "sts":
+		v, err := clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return failf(errors.System, "cannot get owner %v/%v: %w", namespace, owner, err)
+		}
+		selector = v.Spec.Selector
+	case "daemonset", "daemonsets", "ds":
+		v, err := clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return failf(errors.System, "cannot get owner %v/%v: %w", namespace, owner, err)
+		}
+		selector = v.Spec.Selector
+	case "replicaset", "replicasets", "rs":
+		v, err := clientSet.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return failf(errors.System, "cannot get owner %v/%v: %w", namespace, owner, err)
+		}
+		selector = v.Spec.Selector
+	case "job", "jobs":
+		v, err := clientSet.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return failf(errors.System, "cannot get owner %v/%v: %w", namespace, owner, err)
+		}
+		selector = v.Spec.Selector
+	default:
+		return failf(errors.Config, "unsupported kind of owner %q", owner)
+	}
+
+	result, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return failf(errors.System, "owner %v/%v has an illegal selector: %w", namespace, owner, err)
+	}
+	if result.Empty() {
+		return failf(errors.System, "owner %v/%v has an empty selector", namespace, owner)
+	}
+	return result.String(), nil
+}
+
+// selectAttachablePod selects a running POD out of the given candidates. If
+// containerName is provided, this container has to be ready. Otherwise, the
+// whole POD has to be ready. To always hit the same POD for the same set of
+// candidates, the first one by name is selected.
+func selectAttachablePod(candidates []v1.Pod, containerName string) *v1.Pod {
+	isReady := func(pod *v1.Pod) bool {
+		if containerName != "" {
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name == containerName {
+					return status.Ready
+				}
+			}
+			return false
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodReady {
+				return condition.Status == v1.ConditionTrue
+			}
+		}
+		return false
+	}
+
+	var result *v1.Pod
+	for i, candidate := range candidates {
+		if candidate.DeletionTimestamp != nil || candidate.Status.Phase != v1.PodRunning || !isReady(&candidate) {
+			continue
+		}
+		if result == nil || candidate.Name < result.Name {
+			result = &candidates[i]
+		}
+	}
+	return result
+}
+
+// kubernetesAttachContainerOf resolves the container of the given POD to
+// attach to.
+func kubernetesAttachContainerOf(pod *v1.Pod, containerName string) (string, error) {
+	if containerName == "" {
+		containerName = pod.Annotations[kubernetesDefaultContainerAnnotation]
+	}
+	if containerName == "" {
+		if len(pod.Spec.Containers) == 0 {
+			return "", errors.System.Newf("pod %v/%v does not contain any containers", pod.Namespace, pod.Name)
+		}
+		return pod.Spec.Containers[0].Name, nil
+	}
+	if !slices.ContainsFunc(pod.Spec.Containers, func(candidate v1.Container) bool {
+		return candidate.Name == containerName
+	}) {
+		return "", errors.Config.Newf("pod %v/%v does not contain container %q", pod.Namespace, pod.Name, containerName)
+	}
+	return containerName, nil
+}
+
+func (this *KubernetesAttachRepository) FindBySession(context.Context, session.Session, *FindOpts) (Environment, error) {
+	// Attached PODs are not owned by Bifröst. There is nothing which
+	// survives a connection and can be found again.
+	return nil, ErrNoSuchEnvironment
+}
+
+func (this *KubernetesAttachRepository) Cleanup(context.Context, *CleanupOpts) error {
+	// Attached PODs are not owned by Bifröst; there is nothing to clean up.
+	return nil
+}
+
+func (this *KubernetesAttachRepository) Close() error {
+	return nil
+}
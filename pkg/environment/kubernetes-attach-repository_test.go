@@ -0,0 +1,101 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelectAttachablePod(t *testing.T) {
+	newPod := func(name string, phase v1.PodPhase, ready bool, readyContainers ...string) v1.Pod {
+		result := v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+		status := v1.ConditionFalse
+		if ready {
+			status = v1.ConditionTrue
+		}
+		result.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: status}}
+		for _, c := range readyContainers {
+			result.Status.ContainerStatuses = append(result.Status.ContainerStatuses, v1.ContainerStatus{Name: c, Ready: true})
+		}
+		return result
+	}
+	deleted := newPod("a-deleted", v1.PodRunning, true, "app")
+	deleted.DeletionTimestamp = &metav1.Time{}
+
+	cases := []struct {
+		name          string
+		candidates    []v1.Pod
+		containerName string
+		expected      string
+	}{{
+		name: "none",
+	}, {
+		name: "first-by-name",
+		candidates: []v1.Pod{
+			newPod("c", v1.PodRunning, true),
+			newPod("b", v1.PodRunning, true),
+			newPod("d", v1.PodRunning, true),
+		},
+		expected: "b",
+	}, {
+		name: "skip-not-running-and-deleted",
+		candidates: []v1.Pod{
+			deleted,
+			newPod("a-pending", v1.PodPending, false),
+			newPod("a-not-ready", v1.PodRunning, false),
+			newPod("z", v1.PodRunning, true),
+		},
+		expected: "z",
+	}, {
+		name: "container-ready",
+		candidates: []v1.Pod{
+			newPod("a", v1.PodRunning, true, "sidecar"),
+			newPod("b", v1.PodRunning, false, "app"),
+		},
+		containerName: "app",
+		expected:      "b",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual := selectAttachablePod(c.candidates, c.containerName)
+			if c.expected == "" {
+				require.Nil(t, actual)
+				return
+			}
+			require.NotNil(t, actual)
+			require.Equal(t, c.expected, actual.Name)
+		})
+	}
+}
+
+func TestKubernetesAttachContainerOf(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"},
+		Spec: v1.PodSpec{Containers: []v1.Container{
+			{Name: "sidecar"},
+			{Name: "app"},
+		}},
+	}
+
+	actual, err := kubernetesAttachContainerOf(pod, "")
+	require.NoError(t, err)
+	require.Equal(t, "sidecar", actual)
+
+	actual, err = kubernetesAttachContainerOf(pod, "app")
+	require.NoError(t, err)
+	require.Equal(t, "app", actual)
+
+	_, err = kubernetesAttachContainerOf(pod, "other")
+	require.ErrorContains(t, err, `pod default/pod does not contain container "other"`)
+
+	pod.Annotations = map[string]string{kubernetesDefaultContainerAnnotation: "app"}
+	actual, err = kubernetesAttachContainerOf(pod, "")
+	require.NoError(t, err)
+	require.Equal(t, "app", actual)
+}
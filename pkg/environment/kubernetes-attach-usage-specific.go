@@ -0,0 +1,169 @@
+package environment
+
+import (
+	"context"
+	"io"
+	gonet "net"
+	"slices"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	kexec "k8s.io/client-go/util/exec"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/ssh"
+)
+
+func (this *kubernetesAttach) Banner(req Request) (io.ReadCloser, error) {
+	b, err := this.repository.conf.Banner.Render(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(strings.NewReader(b)), nil
+}
+
+func (this *kubernetesAttach) Run(t Task) (exitCode int, rErr error) {
+	fail := func(err error) (int, error) {
+		return -1, err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (int, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	sshSess := t.SshSession()
+	l := t.Connection().Logger().
+		With("namespace", this.namespace).
+		With("pod", this.name).
+		With("container", this.container)
+
+	var command []string
+	switch t.TaskType() {
+	case TaskTypeShell:
+		if v := sshSess.RawCommand(); len(v) > 0 {
+			command = append(slices.Clone(this.execCommand), v)
+		} else {
+			command = slices.Clone(this.shellCommand)
+		}
+	case TaskTypeSftp:
+		if len(this.sftpCommand) == 0 {
+			return failf(errors.Config, "sftp is not supported by this environment, because there is no sftpCommand defined")
+		}
+		command = slices.Clone(this.sftpCommand)
+	default:
+		return failf(errors.System, "illegal task type: %v", t.TaskType())
+	}
+
+	if ssh.AgentRequested(sshSess) {
+		l.Debug("agent forwarding is not supported for existing pods; ignoring")
+	}
+
+	clientSet, err := this.repository.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+
+	req := clientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(this.namespace).
+		Name(this.name).
+		SubResource("exec")
+
+	opts := v1.PodExecOptions{
+		Container: this.container,
+		Command:   command,
+		Stdin:     true,
+		Stdout:    true,
+		Stderr:    true,
+	}
+
+	streamOpts := remotecommand.StreamOptions{
+		Stdin:  sshSess,
+		Stdout: sshSess,
+		Stderr: sshSess.Stderr(),
+	}
+
+	if _, winCh, isPty := sshSess.Pty(); isPty {
+		// With a TTY, stderr is always merged into stdout.
+		opts.TTY, opts.Stderr = true, false
+		streamOpts.Tty, streamOpts.Stderr = true, nil
+		streamOpts.TerminalSizeQueue = &terminalQueueSizeFromSsh{winCh}
+	}
+
+	req.VersionedParams(&opts, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(this.repository.client.RestConfig(), "POST", req.URL())
+	if err != nil {
+		return fail(err)
+	}
+
+	l.With("command", command).
+		With("tty", opts.TTY).
+		Info("executing command inside existing pod")
+
+	err = exec.StreamWithContext(t.Context(), streamOpts)
+	_ = sshSess.CloseWrite()
+
+	var exitErr kexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if t.Context().Err() != nil {
+		return -2, nil
+	}
+	if err != nil {
+		return failf(errors.Network, "cannot execute command inside pod %v/%v: %w", this.namespace, this.name, err)
+	}
+	return 0, nil
+}
+
+func (this *kubernetesAttach) IsPortForwardingAllowed(dest net.HostPort) (bool, error) {
+	// Kubernetes is only able to forward to ports of the POD itself.
+	return this.portForwardingAllowed && isLoopbackHost(dest.Host), nil
+}
+
+func (this *kubernetesAttach) NewDestinationConnection(ctx context.Context, dest net.HostPort) (io.ReadWriteCloser, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+	if !isLoopbackHost(dest.Host) {
+		return nil, errors.Newf(errors.Permission, "port forwarding is only allowed to ports of the pod itself (localhost)")
+	}
+
+	result, err := this.repository.client.DialPod(ctx, this.namespace, this.name, strconv.FormatUint(uint64(dest.Port), 10))
+	if err != nil {
+		return nil, errors.Network.Newf("cannot connect to port %d of pod %v/%v: %w", dest.Port, this.namespace, this.name, err)
+	}
+	return result, nil
+}
+
+func (this *kubernetesAttach) NewRemoteListener(context.Context, net.HostPort) (RemoteListener, error) {
+	// Kubernetes is only able to forward to ports of the POD, but not from it.
+	return nil, errors.Newf(errors.Permission, "remote port forwarding is not supported for attached pods")
+}
+
+func (this *kubernetesAttach) IsSocketForwardingAllowed(string) (bool, error) {
+	return false, nil
+}
+
+func (this *kubernetesAttach) NewDestinationSocketConnection(context.Context, string) (io.ReadWriteCloser, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by kubernetesAttach environments")
+}
+
+func (this *kubernetesAttach) NewRemoteSocketListener(context.Context, string) (gonet.Listener, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by kubernetesAttach environments")
+}
+
+func (this *kubernetesAttach) IsX11ForwardingAllowed() (bool, error) {
+	return false, nil
+}
+
+func isLoopbackHost(host net.Host) bool {
+	if ip := host.IP; len(ip) > 0 {
+		return ip.IsLoopback()
+	}
+	return strings.EqualFold(host.Dns, "localhost")
+}
@@ -0,0 +1,28 @@
+package environment
+
+import (
+	"context"
+)
+
+type kubernetesAttach struct {
+	repository *KubernetesAttachRepository
+
+	namespace string
+	name      string
+	container string
+
+	shellCommand []string
+	execCommand  []string
+	sftpCommand  []string
+
+	portForwardingAllowed bool
+}
+
+func (this *kubernetesAttach) Dispose(context.Context) (bool, error) {
+	// The attached POD is not owned by Bifröst; it will never be removed.
+	return false, nil
+}
+
+func (this *kubernetesAttach) Close() error {
+	return nil
+}
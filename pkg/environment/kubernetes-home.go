@@ -0,0 +1,236 @@
+package environment
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/echocat/slf4g"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+var kubernetesHomeAccessModes = []v1.PersistentVolumeAccessMode{
+	v1.ReadWriteOnce,
+	v1.ReadWriteMany,
+	v1.ReadWriteOncePod,
+}
+
+// ensureHomeClaim ensures that the PersistentVolumeClaim of the persistent
+// home of the requesting user exists in the given namespace and records its
+// login. It returns an empty claimName if no persistent home is configured.
+func (this *KubernetesRepository) ensureHomeClaim(req Request, namespace string) (claimName, directory string, err error) {
+	fail := func(err error) (string, string, error) {
+		return "", "", err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (string, string, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	key, err := this.conf.Home.Key.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate home.key: %w", err)
+	}
+	if key == "" {
+		return "", "", nil
+	}
+
+	if directory, err = this.conf.Home.Directory.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate home.directory: %w", err)
+	}
+	if directory == "" {
+		if directory, err = this.conf.Directory.Render(req); err != nil {
+			return failf(errors.Config, "cannot evaluate directory: %w", err)
+		}
+	}
+	if directory == "" {
+		return failf(errors.Config, "neither home.directory nor directory is defined; cannot mount home")
+	}
+
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+	client := clientSet.CoreV1().PersistentVolumeClaims(namespace)
+	claimName = homeVolumeNameOf(this.flow, key)
+	lastLogin := time.Now().UTC().Format(time.RFC3339)
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				KubernetesAnnotationHomeLastLogin: lastLogin,
+			},
+		},
+	})
+	if err != nil {
+		return fail(err)
+	}
+	_, err = client.Patch(req.Context(), claimName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err == nil {
+		return claimName, directory, nil
+	}
+	if !kerrors.IsNotFound(err) {
+		return failf(errors.System, "cannot record last login of home %v/%v: %w", namespace, claimName, err)
+	}
+
+	// Ok... not there, create it...
+	storageClass, err := this.conf.Home.StorageClass.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate home.storageClass: %w", err)
+	}
+	var size resource.Quantity
+	if plain, err := this.conf.Home.Size.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate home.size: %w", err)
+	} else if size, err = resource.ParseQuantity(plain); err != nil {
+		return failf(errors.Config, "illegal home.size %q: %w", plain, err)
+	}
+	var accessMode v1.PersistentVolumeAccessMode
+	if plain, err := this.conf.Home.AccessMode.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate home.accessMode: %w", err)
+	} else if accessMode = v1.PersistentVolumeAccessMode(plain); !slices.Contains(kubernetesHomeAccessModes, accessMode) {
+		return failf(errors.Config, "illegal home.accessMode: %q", plain)
+	}
+
+	claim := v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				KubernetesLabelFlow: this.flow.String(),
+				KubernetesLabelHome: "true",
+			},
+			Annotations: map[string]string{
+				KubernetesAnnotationHomeKey:       key,
+				KubernetesAnnotationHomeLastLogin: lastLogin,
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{accessMode},
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+	if storageClass != "" {
+		claim.Spec.StorageClassName = &storageClass
+	}
+
+	if _, err := client.Create(req.Context(), &claim, metav1.CreateOptions{
+		FieldValidation: "Strict",
+	}); err != nil && !kerrors.IsAlreadyExists(err) {
+		return failf(errors.System, "cannot create home %v/%v: %w", namespace, claimName, err)
+	}
+
+	return claimName, directory, nil
+}
+
+// cleanupHomes removes all homes of this flow whose owners have not logged in
+// for longer than the configured retention.
+func (this *KubernetesRepository) cleanupHomes(ctx context.Context, l log.Logger) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot cleanup expired homes: %w", err)
+	}
+
+	retention := this.conf.Home.Retention.Native()
+	if retention <= 0 {
+		return nil
+	}
+
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+	claims := clientSet.CoreV1().PersistentVolumeClaims(this.lookupNamespace())
+
+	listOpts := metav1.ListOptions{
+		LabelSelector: KubernetesLabelHome + "," + KubernetesLabelFlow + "=" + this.flow.String(),
+	}
+	for {
+		list, err := claims.List(ctx, listOpts)
+		if err != nil {
+			return fail(err)
+		}
+
+		for _, candidate := range list.Items {
+			cl := l.With("namespace", candidate.Namespace).
+				With("name", candidate.Name).
+				With("homeKey", candidate.Annotations[KubernetesAnnotationHomeKey])
+
+			lastLogin := candidate.CreationTimestamp.Time
+			if plain := candidate.Annotations[KubernetesAnnotationHomeLastLogin]; plain != "" {
+				if v, err := time.Parse(time.RFC3339, plain); err != nil {
+					cl.WithError(err).
+						Warnf("home does have an illegal %v annotation; this warn message will appear again until this is fixed; skipping...", KubernetesAnnotationHomeLastLogin)
+					continue
+				} else if v.After(lastLogin) {
+					lastLogin = v
+				}
+			}
+			cl = cl.With("lastLogin", lastLogin)
+			if time.Since(lastLogin) <= retention {
+				cl.Debug("found home within retention; ignoring...")
+				continue
+			}
+
+			inUse, err := this.isHomeClaimInUse(ctx, candidate.Namespace, candidate.Name)
+			if err != nil {
+				return fail(err)
+			}
+			if inUse {
+				cl.Debug("found expired home which is still in use; ignoring...")
+				continue
+			}
+
+			if err := claims.Delete(ctx, candidate.Name, metav1.DeleteOptions{}); kerrors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				cl.WithError(err).
+					Warn("cannot remove expired home; this message might continue appearing until manually fixed; skipping...")
+				continue
+			}
+			cl.Info("expired home removed")
+		}
+
+		if list.Continue == "" {
+			return nil
+		}
+		listOpts.Continue = list.Continue
+	}
+}
+
+func (this *KubernetesRepository) isHomeClaimInUse(ctx context.Context, namespace, claimName string) (bool, error) {
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return false, err
+	}
+	pods := clientSet.CoreV1().Pods(namespace)
+
+	listOpts := metav1.ListOptions{
+		LabelSelector: KubernetesLabelFlow,
+	}
+	for {
+		list, err := pods.List(ctx, listOpts)
+		if err != nil {
+			return false, err
+		}
+		for _, pod := range list.Items {
+			for _, v := range pod.Spec.Volumes {
+				if pvc := v.PersistentVolumeClaim; pvc != nil && pvc.ClaimName == claimName {
+					return true, nil
+				}
+			}
+		}
+		if list.Continue == "" {
+			return false, nil
+		}
+		listOpts.Continue = list.Continue
+	}
+}
@@ -0,0 +1,417 @@
+package environment
+
+import (
+	"context"
+	"maps"
+	"path"
+	"strings"
+
+	"github.com/echocat/slf4g"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/yaml"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+const (
+	kubernetesIsolationKubeconfigName   = "bifroest-kubeconfig"
+	kubernetesIsolationKubeconfigKey    = "config"
+	kubernetesIsolationKubeconfigVolume = "kubeconfig"
+
+	kubernetesInClusterServer              = "https://kubernetes.default.svc"
+	kubernetesServiceAccountTokenDirectory = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// kubernetesIsolation holds everything of an isolated namespace which has to
+// be referenced by the POD of the session.
+type kubernetesIsolation struct {
+	namespace          string
+	serviceAccountName string
+	kubeconfig         string
+}
+
+// resolveIsolation evaluates if the session should be isolated in its own
+// namespace. It returns nil if no isolation is configured.
+func (this *KubernetesRepository) resolveIsolation(req Request) (*kubernetesIsolation, error) {
+	fail := func(err error) (*kubernetesIsolation, error) {
+		return nil, err
+	}
+	failf := func(msg string, args ...any) (*kubernetesIsolation, error) {
+		return fail(errors.Config.Newf(msg, args...))
+	}
+
+	namespace, err := this.conf.Isolation.Namespace.Render(req)
+	if err != nil {
+		return failf("cannot evaluate isolation.namespace: %w", err)
+	}
+	if namespace == "" {
+		return nil, nil
+	}
+
+	if key, err := this.conf.Home.Key.Render(req); err != nil {
+		return failf("cannot evaluate home.key: %w", err)
+	} else if key != "" {
+		return failf("home cannot be combined with isolation.namespace, because the home would be removed together with the namespace")
+	}
+
+	result := kubernetesIsolation{
+		namespace: namespace,
+	}
+	if result.kubeconfig, err = this.conf.Isolation.Kubeconfig.Render(req); err != nil {
+		return failf("cannot evaluate isolation.kubeconfig: %w", err)
+	}
+
+	return &result, nil
+}
+
+// ensureIsolation ensures that the isolated namespace of the given session
+// exists, together with all of its resources.
+func (this *KubernetesRepository) ensureIsolation(req Request, sess session.Session, isolation *kubernetesIsolation) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot ensure isolated namespace %q: %w", isolation.namespace, err)
+	}
+	failf := func(t errors.Type, msg string, args ...any) error {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+
+	labels := map[string]string{
+		KubernetesLabelFlow:      this.flow.String(),
+		KubernetesLabelSessionId: sess.Id().String(),
+		KubernetesLabelIsolation: "true",
+	}
+
+	namespaces := clientSet.CoreV1().Namespaces()
+	if existing, err := namespaces.Get(req.Context(), isolation.namespace, metav1.GetOptions{}); kerrors.IsNotFound(err) {
+		if _, err := namespaces.Create(req.Context(), &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   isolation.namespace,
+				Labels: labels,
+			},
+		}, metav1.CreateOptions{
+			FieldValidation: "Strict",
+		}); err != nil {
+			return fail(err)
+		}
+	} else if err != nil {
+		return fail(err)
+	} else if existing.Labels[KubernetesLabelSessionId] != sess.Id().String() {
+		return failf(errors.Config, "namespace already exists and is not owned by session %v", sess.Id())
+	} else if existing.Status.Phase == v1.NamespaceTerminating {
+		return failf(errors.System, "namespace is still terminating")
+	}
+
+	create := func(kind string, err error) error {
+		if err != nil && !kerrors.IsAlreadyExists(err) {
+			return failf(errors.System, "cannot create %s: %w", kind, err)
+		}
+		return nil
+	}
+
+	var serviceAccount v1.ServiceAccount
+	if ok, err := this.renderIsolationManifest(req, "serviceAccount", &this.conf.Isolation.ServiceAccount, &serviceAccount.ObjectMeta, &serviceAccount, isolation.namespace, labels); err != nil {
+		return fail(err)
+	} else if !ok {
+		return failf(errors.Config, "isolation.serviceAccount evaluated to an empty manifest")
+	}
+	_, err = clientSet.CoreV1().ServiceAccounts(isolation.namespace).Create(req.Context(), &serviceAccount, metav1.CreateOptions{FieldValidation: "Strict"})
+	if err := create("service account", err); err != nil {
+		return err
+	}
+	isolation.serviceAccountName = serviceAccount.Name
+
+	var roleBinding rbacv1.RoleBinding
+	if ok, err := this.renderIsolationManifest(req, "roleBinding", &this.conf.Isolation.RoleBinding, &roleBinding.ObjectMeta, &roleBinding, isolation.namespace, labels); err != nil {
+		return fail(err)
+	} else if ok {
+		for i, subject := range roleBinding.Subjects {
+			if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace == "" {
+				roleBinding.Subjects[i].Namespace = isolation.namespace
+			}
+		}
+		_, err = clientSet.RbacV1().RoleBindings(isolation.namespace).Create(req.Context(), &roleBinding, metav1.CreateOptions{FieldValidation: "Strict"})
+		if err := create("role binding", err); err != nil {
+			return err
+		}
+	}
+
+	var resourceQuota v1.ResourceQuota
+	if ok, err := this.renderIsolationManifest(req, "resourceQuota", &this.conf.Isolation.ResourceQuota, &resourceQuota.ObjectMeta, &resourceQuota, isolation.namespace, labels); err != nil {
+		return fail(err)
+	} else if ok {
+		_, err = clientSet.CoreV1().ResourceQuotas(isolation.namespace).Create(req.Context(), &resourceQuota, metav1.CreateOptions{FieldValidation: "Strict"})
+		if err := create("resource quota", err); err != nil {
+			return err
+		}
+	}
+
+	var networkPolicy networkingv1.NetworkPolicy
+	if ok, err := this.renderIsolationManifest(req, "networkPolicy", &this.conf.Isolation.NetworkPolicy, &networkPolicy.ObjectMeta, &networkPolicy, isolation.namespace, labels); err != nil {
+		return fail(err)
+	} else if ok {
+		_, err = clientSet.NetworkingV1().NetworkPolicies(isolation.namespace).Create(req.Context(), &networkPolicy, metav1.CreateOptions{FieldValidation: "Strict"})
+		if err := create("network policy", err); err != nil {
+			return err
+		}
+	}
+
+	if isolation.kubeconfig != "" {
+		kubeconfig, err := kubernetesIsolationKubeconfigOf(isolation.namespace)
+		if err != nil {
+			return fail(err)
+		}
+		_, err = clientSet.CoreV1().ConfigMaps(isolation.namespace).Create(req.Context(), &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      kubernetesIsolationKubeconfigName,
+				Namespace: isolation.namespace,
+				Labels:    labels,
+			},
+			Data: map[string]string{
+				kubernetesIsolationKubeconfigKey: string(kubeconfig),
+			},
+		}, metav1.CreateOptions{FieldValidation: "Strict"})
+		if err := create("kubeconfig", err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renderIsolationManifest renders the given manifest template into target.
+// It returns false if the template evaluated to an empty manifest.
+func (this *KubernetesRepository) renderIsolationManifest(req Request, property string, tmpl *template.String, meta *metav1.ObjectMeta, target any, namespace string, labels map[string]string) (bool, error) {
+	plain, err := tmpl.Render(req)
+	if err != nil {
+		return false, errors.Config.Newf("cannot evaluate isolation.%s: %w", property, err)
+	}
+	if strings.TrimSpace(plain) == "" {
+		return false, nil
+	}
+	if err := decodeKubernetesIsolationManifest(plain, meta, target, namespace, labels); err != nil {
+		return false, errors.Config.Newf("illegal isolation.%s: %w", property, err)
+	}
+	return true, nil
+}
+
+// decodeKubernetesIsolationManifest decodes the given manifest into target.
+// The namespace is always enforced and the given labels are added.
+func decodeKubernetesIsolationManifest(plain string, meta *metav1.ObjectMeta, target any, namespace string, labels map[string]string) error {
+	if err := yaml.UnmarshalStrict([]byte(plain), target); err != nil {
+		return err
+	}
+	if meta.Name == "" {
+		return errors.Config.Newf("metadata.name is required")
+	}
+	if meta.Namespace != "" && meta.Namespace != namespace {
+		return errors.Config.Newf("metadata.namespace cannot be changed")
+	}
+	meta.Namespace = namespace
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	maps.Copy(meta.Labels, labels)
+	return nil
+}
+
+// kubernetesIsolationKubeconfigOf creates a kubeconfig which uses the token
+// of the service account of the POD and is scoped to the given namespace.
+func kubernetesIsolationKubeconfigOf(namespace string) ([]byte, error) {
+	result := clientcmdapi.NewConfig()
+	result.Clusters["default"] = &clientcmdapi.Cluster{
+		Server:               kubernetesInClusterServer,
+		CertificateAuthority: path.Join(kubernetesServiceAccountTokenDirectory, "ca.crt"),
+	}
+	result.AuthInfos["default"] = &clientcmdapi.AuthInfo{
+		TokenFile: path.Join(kubernetesServiceAccountTokenDirectory, "token"),
+	}
+	result.Contexts["default"] = &clientcmdapi.Context{
+		Cluster:   "default",
+		AuthInfo:  "default",
+		Namespace: namespace,
+	}
+	result.CurrentContext = "default"
+
+	b, err := clientcmd.Write(*result)
+	if err != nil {
+		return nil, errors.System.Newf("cannot create kubeconfig for namespace %q: %w", namespace, err)
+	}
+	return b, nil
+}
+
+// applyIsolation makes the given POD using the resources of the given
+// isolated namespace.
+func (this *KubernetesRepository) applyIsolation(isolation *kubernetesIsolation, pod *v1.Pod) {
+	pod.Annotations[KubernetesAnnotationIsolated] = "true"
+	pod.Spec.ServiceAccountName = isolation.serviceAccountName
+	pod.Spec.AutomountServiceAccountToken = common.P(true)
+
+	if isolation.kubeconfig == "" {
+		return
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: kubernetesIsolationKubeconfigVolume,
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: kubernetesIsolationKubeconfigName},
+			},
+		},
+	})
+	container := &pod.Spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      kubernetesIsolationKubeconfigVolume,
+		MountPath: isolation.kubeconfig,
+		SubPath:   kubernetesIsolationKubeconfigKey,
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env, v1.EnvVar{
+		Name:  "KUBECONFIG",
+		Value: isolation.kubeconfig,
+	})
+}
+
+// removeIsolatedNamespace removes the given namespace together with all of
+// its content. It does not wait until the namespace is completely gone.
+func (this *KubernetesRepository) removeIsolatedNamespace(ctx context.Context, namespace string) (bool, error) {
+	fail := func(err error) (bool, error) {
+		return false, errors.System.Newf("cannot remove isolated namespace %q: %w", namespace, err)
+	}
+
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := clientSet.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{
+		PropagationPolicy: common.P(metav1.DeletePropagationBackground),
+	}); kerrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return fail(err)
+	}
+
+	return true, nil
+}
+
+// cleanupIsolatedNamespaces removes all isolated namespaces whose sessions do
+// not exist anymore, as well as orphans of flows which do not exist anymore.
+func (this *KubernetesRepository) cleanupIsolatedNamespaces(ctx context.Context, opts *CleanupOpts, l log.Logger) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot cleanup potential orphan isolated namespaces: %w", err)
+	}
+
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+	client := clientSet.CoreV1().Namespaces()
+
+	listOpts := metav1.ListOptions{
+		LabelSelector: KubernetesLabelIsolation,
+	}
+	for {
+		list, err := client.List(ctx, listOpts)
+		if kerrors.IsForbidden(err) && this.conf.Isolation.Namespace.IsZero() {
+			// Without isolation configured, Bifröst might not have the
+			// permissions to list namespaces. That's fine.
+			l.WithError(err).Debug("not allowed to list namespaces; skipping cleanup of isolated namespaces...")
+			return nil
+		} else if err != nil {
+			return fail(err)
+		}
+
+		for _, candidate := range list.Items {
+			cl := l.With("namespace", candidate.Name)
+
+			if candidate.Status.Phase == v1.NamespaceTerminating {
+				continue
+			}
+
+			var flow configuration.FlowName
+			if err := flow.Set(candidate.Labels[KubernetesLabelFlow]); err != nil || flow.IsZero() {
+				cl.WithError(err).
+					Warnf("isolated namespace does have an illegal %v label; this warn message will appear again until this is fixed; skipping...", KubernetesLabelFlow)
+				continue
+			}
+			cl = cl.With("flow", flow)
+
+			remove := func(reason string) {
+				if ok, err := this.removeIsolatedNamespace(ctx, candidate.Name); err != nil {
+					cl.WithError(err).
+						Warnf("cannot remove %s; this message might continue appearing until manually fixed; skipping...", reason)
+				} else if ok {
+					cl.Infof("%s removed", reason)
+				}
+			}
+
+			if flow.IsEqualTo(this.flow) {
+				var sessionId session.Id
+				if err := sessionId.Set(candidate.Labels[KubernetesLabelSessionId]); err != nil || sessionId.IsZero() {
+					remove("isolated namespace without session id")
+					continue
+				}
+				cl = cl.With("session", sessionId)
+
+				ok, err := opts.HasSession(ctx, this.flow, sessionId)
+				if err != nil {
+					cl.WithError(err).
+						Warn("cannot if the session of isolated namespace exists; this message might continue appearing until manually fixed; skipping...")
+					continue
+				}
+				if ok != nil && !*ok {
+					remove("isolated namespace without valid session")
+				} else {
+					cl.Debug("found isolated namespace that is owned by this flow environment; ignoring...")
+				}
+				continue
+			}
+
+			globalHasFlow, err := opts.HasFlowOfName(flow)
+			if err != nil {
+				return fail(err)
+			}
+			if globalHasFlow {
+				cl.Debug("found isolated namespace that is owned by another environment; ignoring...")
+				continue
+			}
+
+			// The namespace is presented like a POD without containers, to
+			// provide the same context to cleanOrphan.
+			shouldBeCleaned, err := this.conf.CleanOrphan.Render(kubernetesPodContext{&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: candidate.Name,
+					Name:      candidate.Name,
+					Labels:    candidate.Labels,
+				},
+			}})
+			if err != nil {
+				return fail(err)
+			}
+			if !shouldBeCleaned {
+				cl.Debug("found isolated namespace that isn't owned by anybody, but should be kept; ignoring...")
+				continue
+			}
+
+			remove("orphan isolated namespace")
+		}
+
+		if list.Continue == "" {
+			return nil
+		}
+		listOpts.Continue = list.Continue
+	}
+}
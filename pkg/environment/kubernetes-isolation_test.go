@@ -0,0 +1,75 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestDecodeKubernetesIsolationManifest(t *testing.T) {
+	labels := map[string]string{KubernetesLabelFlow: "foo"}
+
+	cases := []struct {
+		name           string
+		plain          string
+		expectedName   string
+		expectedLabels map[string]string
+		expectedErr    string
+	}{{
+		name:           "simple",
+		plain:          "metadata:\n  name: user\n",
+		expectedName:   "user",
+		expectedLabels: map[string]string{KubernetesLabelFlow: "foo"},
+	}, {
+		name:           "labels-merged",
+		plain:          "metadata:\n  name: user\n  labels:\n    a: b\n    org.engity.bifroest/flow: other\n",
+		expectedName:   "user",
+		expectedLabels: map[string]string{KubernetesLabelFlow: "foo", "a": "b"},
+	}, {
+		name:           "same-namespace",
+		plain:          "metadata:\n  name: user\n  namespace: session\n",
+		expectedName:   "user",
+		expectedLabels: map[string]string{KubernetesLabelFlow: "foo"},
+	}, {
+		name:        "other-namespace",
+		plain:       "metadata:\n  name: user\n  namespace: kube-system\n",
+		expectedErr: "metadata.namespace cannot be changed",
+	}, {
+		name:        "without-name",
+		plain:       "metadata:\n  labels:\n    a: b\n",
+		expectedErr: "metadata.name is required",
+	}, {
+		name:        "unknown-field",
+		plain:       "metadata:\n  name: user\nfoo: bar\n",
+		expectedErr: `unknown field "foo"`,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var actual v1.ServiceAccount
+			err := decodeKubernetesIsolationManifest(c.plain, &actual.ObjectMeta, &actual, "session", labels)
+			if c.expectedErr != "" {
+				require.ErrorContains(t, err, c.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedName, actual.Name)
+			require.Equal(t, "session", actual.Namespace)
+			require.Equal(t, c.expectedLabels, actual.Labels)
+		})
+	}
+}
+
+func TestKubernetesIsolationKubeconfigOf(t *testing.T) {
+	b, err := kubernetesIsolationKubeconfigOf("session")
+	require.NoError(t, err)
+
+	actual, err := clientcmd.Load(b)
+	require.NoError(t, err)
+	require.Equal(t, "default", actual.CurrentContext)
+	require.Equal(t, "session", actual.Contexts["default"].Namespace)
+	require.Equal(t, kubernetesInClusterServer, actual.Clusters["default"].Server)
+	require.Equal(t, "/var/run/secrets/kubernetes.io/serviceaccount/token", actual.AuthInfos["default"].TokenFile)
+}
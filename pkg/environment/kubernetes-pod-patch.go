@@ -0,0 +1,193 @@
+package environment
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+func (this *KubernetesRepository) applyPodPatch(req Request, pod *v1.Pod) (*v1.Pod, error) {
+	patch, err := this.conf.PodPatch.Render(req)
+	if err != nil {
+		return nil, errors.Config.Newf("cannot evaluate podPatch: %w", err)
+	}
+	return patchPod(pod, []byte(patch))
+}
+
+// patchPod applies the given strategic merge patch (in YAML or JSON) to the
+// given pod. The result is rejected if the patch modifies anything Bifröst
+// relies on.
+func patchPod(pod *v1.Pod, patch []byte) (*v1.Pod, error) {
+	fail := func(err error) (*v1.Pod, error) {
+		return nil, errors.Config.Newf("cannot apply podPatch: %w", err)
+	}
+	failf := func(msg string, args ...any) (*v1.Pod, error) {
+		return fail(errors.Config.Newf(msg, args...))
+	}
+
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return pod, nil
+	}
+
+	patchJson, err := yaml.YAMLToJSON(patch)
+	if err != nil {
+		return failf("illegal patch: %w", err)
+	}
+	originalJson, err := json.Marshal(pod)
+	if err != nil {
+		return fail(err)
+	}
+	patchedJson, err := strategicpatch.StrategicMergePatch(originalJson, patchJson, v1.Pod{})
+	if err != nil {
+		return fail(err)
+	}
+
+	var result v1.Pod
+	decoder := json.NewDecoder(bytes.NewReader(patchedJson))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return fail(err)
+	}
+
+	if err := validatePatchedPod(pod, &result); err != nil {
+		return fail(err)
+	}
+
+	return &result, nil
+}
+
+func validatePatchedPod(original, patched *v1.Pod) error {
+	failf := func(msg string, args ...any) error {
+		return errors.Config.Newf(msg, args...)
+	}
+
+	if patched.Name != original.Name || patched.Namespace != original.Namespace {
+		return failf("name and namespace of the POD cannot be changed")
+	}
+	if err := validatePatchedPodMetadata("label", original.Labels, patched.Labels); err != nil {
+		return err
+	}
+	if err := validatePatchedPodMetadata("annotation", original.Annotations, patched.Annotations); err != nil {
+		return err
+	}
+
+	if patched.Spec.RestartPolicy != original.Spec.RestartPolicy {
+		return failf("restartPolicy of the POD cannot be changed")
+	}
+	if !equality.Semantic.DeepEqual(patched.Spec.OS, original.Spec.OS) {
+		return failf("os of the POD cannot be changed")
+	}
+	for k, v := range original.Spec.NodeSelector {
+		if pv, ok := patched.Spec.NodeSelector[k]; !ok || pv != v {
+			return failf("nodeSelector %q of the POD cannot be changed", k)
+		}
+	}
+
+	for _, v := range original.Spec.Volumes {
+		if pv := findPodVolume(patched.Spec.Volumes, v.Name); pv == nil || !equality.Semantic.DeepEqual(*pv, v) {
+			return failf("volume %q of the POD cannot be changed", v.Name)
+		}
+	}
+	for _, v := range original.Spec.InitContainers {
+		if pv := findPodContainer(patched.Spec.InitContainers, v.Name); pv == nil || !equality.Semantic.DeepEqual(*pv, v) {
+			return failf("init container %q of the POD cannot be changed", v.Name)
+		}
+	}
+	for _, v := range original.Spec.Containers {
+		pv := findPodContainer(patched.Spec.Containers, v.Name)
+		if pv == nil {
+			return failf("container %q of the POD cannot be removed", v.Name)
+		}
+		if err := validatePatchedPodContainer(&v, pv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validatePatchedPodMetadata(kind string, original, patched map[string]string) error {
+	for k, v := range original {
+		if pv, ok := patched[k]; !ok || pv != v {
+			return errors.Config.Newf("%s %q of the POD cannot be changed", kind, k)
+		}
+	}
+	for k := range patched {
+		if _, ok := original[k]; !ok && strings.HasPrefix(k, KubernetesLabelPrefix) {
+			return errors.Config.Newf("%s %q of the POD is reserved", kind, k)
+		}
+	}
+	return nil
+}
+
+func validatePatchedPodContainer(original, patched *v1.Container) error {
+	failf := func(msg string, args ...any) error {
+		return errors.Config.Newf("container %q of the POD: "+msg, append([]any{original.Name}, args...)...)
+	}
+
+	if patched.Image != original.Image {
+		return failf("image cannot be changed")
+	}
+	if !equality.Semantic.DeepEqual(patched.Command, original.Command) {
+		return failf("command cannot be changed")
+	}
+	if !equality.Semantic.DeepEqual(patched.Args, original.Args) {
+		return failf("args cannot be changed")
+	}
+	if !equality.Semantic.DeepEqual(patched.LivenessProbe, original.LivenessProbe) {
+		return failf("livenessProbe cannot be changed")
+	}
+	if !equality.Semantic.DeepEqual(patched.StartupProbe, original.StartupProbe) {
+		return failf("startupProbe cannot be changed")
+	}
+	for _, v := range original.Ports {
+		if !containsEqual(patched.Ports, v) {
+			return failf("port %q cannot be changed", v.Name)
+		}
+	}
+	for _, v := range original.Env {
+		if !containsEqual(patched.Env, v) {
+			return failf("environment variable %q cannot be changed", v.Name)
+		}
+	}
+	for _, v := range original.VolumeMounts {
+		if !containsEqual(patched.VolumeMounts, v) {
+			return failf("volume mount %q cannot be changed", v.MountPath)
+		}
+	}
+	return nil
+}
+
+func findPodVolume(in []v1.Volume, name string) *v1.Volume {
+	for i, v := range in {
+		if v.Name == name {
+			return &in[i]
+		}
+	}
+	return nil
+}
+
+func findPodContainer(in []v1.Container, name string) *v1.Container {
+	for i, v := range in {
+		if v.Name == name {
+			return &in[i]
+		}
+	}
+	return nil
+}
+
+func containsEqual[T any](in []T, what T) bool {
+	for _, v := range in {
+		if equality.Semantic.DeepEqual(v, what) {
+			return true
+		}
+	}
+	return false
+}
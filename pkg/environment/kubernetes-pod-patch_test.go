@@ -0,0 +1,163 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPatchPod(t *testing.T) {
+	newPod := func() *v1.Pod {
+		return &v1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "bifroest-1",
+				Namespace: "default",
+				Labels: map[string]string{
+					KubernetesLabelFlow: "default",
+				},
+				Annotations: map[string]string{
+					KubernetesAnnotationUser: "root",
+				},
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{
+					Name:    "bifroest",
+					Image:   "alpine",
+					Command: []string{"/bifroest"},
+					Args:    []string{"imp"},
+					Env:     []v1.EnvVar{{Name: "A", Value: "1"}},
+					VolumeMounts: []v1.VolumeMount{{
+						Name:      "imp",
+						MountPath: "/bifroest",
+						SubPath:   "bifroest",
+						ReadOnly:  true,
+					}},
+				}},
+				InitContainers: []v1.Container{{
+					Name:  "init",
+					Image: "bifroest",
+				}},
+				Volumes: []v1.Volume{{
+					Name:         "imp",
+					VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+				}},
+				RestartPolicy: v1.RestartPolicyNever,
+				OS:            &v1.PodOS{Name: v1.Linux},
+				NodeSelector:  map[string]string{v1.LabelOSStable: "linux"},
+			},
+		}
+	}
+
+	cases := []struct {
+		name          string
+		patch         string
+		expectedError string
+		check         func(t *testing.T, actual *v1.Pod)
+	}{{
+		name:  "empty",
+		patch: "  \n",
+		check: func(t *testing.T, actual *v1.Pod) {
+			require.Equal(t, newPod(), actual)
+		},
+	}, {
+		name: "resources",
+		patch: `
+spec:
+  containers:
+    - name: bifroest
+      resources:
+        limits:
+          memory: 1Gi`,
+		check: func(t *testing.T, actual *v1.Pod) {
+			require.Len(t, actual.Spec.Containers, 1)
+			require.Equal(t, resource.MustParse("1Gi"), actual.Spec.Containers[0].Resources.Limits[v1.ResourceMemory])
+			require.Equal(t, "alpine", actual.Spec.Containers[0].Image)
+		},
+	}, {
+		name: "additions",
+		patch: `
+metadata:
+  labels:
+    team: a
+spec:
+  tolerations:
+    - key: dedicated
+      operator: Exists
+  containers:
+    - name: bifroest
+      env:
+        - name: B
+          value: "2"
+  volumes:
+    - name: cache
+      emptyDir: {}`,
+		check: func(t *testing.T, actual *v1.Pod) {
+			require.Equal(t, "a", actual.Labels["team"])
+			require.Len(t, actual.Spec.Tolerations, 1)
+			require.Len(t, actual.Spec.Containers[0].Env, 2)
+			require.Len(t, actual.Spec.Volumes, 2)
+		},
+	}, {
+		name:          "illegal",
+		patch:         `spec: [`,
+		expectedError: "cannot apply podPatch: illegal patch",
+	}, {
+		name:          "unknown-field",
+		patch:         `spec: {foo: bar}`,
+		expectedError: `unknown field "foo"`,
+	}, {
+		name:          "changed-name",
+		patch:         `metadata: {name: other}`,
+		expectedError: "name and namespace of the POD cannot be changed",
+	}, {
+		name:          "changed-label",
+		patch:         `metadata: {labels: {"org.engity.bifroest/flow": other}}`,
+		expectedError: `label "org.engity.bifroest/flow" of the POD cannot be changed`,
+	}, {
+		name:          "added-annotation",
+		patch:         `metadata: {annotations: {"org.engity.bifroest/portForwardingAllowed": "true"}}`,
+		expectedError: `annotation "org.engity.bifroest/portForwardingAllowed" of the POD is reserved`,
+	}, {
+		name:          "changed-image",
+		patch:         `spec: {containers: [{name: bifroest, image: other}]}`,
+		expectedError: `container "bifroest" of the POD: image cannot be changed`,
+	}, {
+		name:          "changed-env",
+		patch:         `spec: {containers: [{name: bifroest, env: [{name: A, value: "2"}]}]}`,
+		expectedError: `container "bifroest" of the POD: environment variable "A" cannot be changed`,
+	}, {
+		name:          "removed-container",
+		patch:         `spec: {containers: [{name: bifroest, $patch: delete}]}`,
+		expectedError: `container "bifroest" of the POD cannot be removed`,
+	}, {
+		name:          "changed-init-container",
+		patch:         `spec: {initContainers: [{name: init, image: other}]}`,
+		expectedError: `init container "init" of the POD cannot be changed`,
+	}, {
+		name:          "changed-volume",
+		patch:         `spec: {volumes: [{name: imp, emptyDir: {medium: Memory}}]}`,
+		expectedError: `volume "imp" of the POD cannot be changed`,
+	}, {
+		name:          "changed-restart-policy",
+		patch:         `spec: {restartPolicy: Always}`,
+		expectedError: "restartPolicy of the POD cannot be changed",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			original := newPod()
+			actual, err := patchPod(original, []byte(c.patch))
+			if c.expectedError != "" {
+				require.ErrorContains(t, err, c.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, newPod(), original)
+			c.check(t, actual)
+		})
+	}
+}
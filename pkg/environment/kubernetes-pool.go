@@ -0,0 +1,203 @@
+package environment
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+const (
+	kubernetesPoolMemberNamePrefix = "bifroest-pool-"
+)
+
+// claimPoolMember tries to claim one unclaimed POD of the pool for the given
+// session. The POD is bound to the session by relabeling it. If there is no
+// claimable POD available, nil is returned.
+func (this *KubernetesRepository) claimPoolMember(req Request, sess session.Session) (*v1.Pod, error) {
+	fail := func(err error) (*v1.Pod, error) {
+		return nil, errors.System.Newf("cannot claim pod of pool for session %v: %w", sess, err)
+	}
+
+	if !this.conf.Pool.IsEnabled() {
+		return nil, nil
+	}
+
+	clientSet, err := this.client.ClientSet()
+	if err != nil {
+		return fail(err)
+	}
+	client, err := this.podsClient()
+	if err != nil {
+		return fail(err)
+	}
+
+	list, err := client.List(req.Context(), metav1.ListOptions{
+		LabelSelector: this.poolMemberLabelSelector(),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	remote := req.Connection().Remote()
+	for _, candidate := range selectClaimablePoolMembers(list.Items) {
+		patch, err := kubernetesPoolClaimPatchOf(candidate, sess.Id(), remote)
+		if err != nil {
+			return fail(err)
+		}
+
+		claimed, err := clientSet.CoreV1().Pods(candidate.Namespace).Patch(req.Context(), candidate.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if kerrors.IsConflict(err) || kerrors.IsNotFound(err) {
+			// Somebody else was faster, or it was removed meanwhile; try the next one...
+			continue
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		return claimed, nil
+	}
+
+	return nil, nil
+}
+
+// refillPool triggers asynchronously the creation of missing PODs of the
+// pool. If a refill is already running, nothing happens.
+func (this *KubernetesRepository) refillPool() {
+	if !this.conf.Pool.IsEnabled() || this.poolCtx == nil {
+		return
+	}
+
+	go func() {
+		if err := this.fillPool(this.poolCtx); err != nil && !errors.Is(err, context.Canceled) {
+			this.logger().WithError(err).
+				Warn("cannot refill pool; will be retried with the next cleanup")
+		}
+	}()
+}
+
+// fillPool removes all dead and surplus PODs of the pool and creates the
+// missing ones until the configured size is reached.
+func (this *KubernetesRepository) fillPool(ctx context.Context) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot fill pool: %w", err)
+	}
+
+	if !this.conf.Pool.IsEnabled() {
+		return nil
+	}
+	if !this.poolFilling.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer this.poolFilling.Store(false)
+
+	client, err := this.podsClient()
+	if err != nil {
+		return fail(err)
+	}
+
+	list, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: this.poolMemberLabelSelector(),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	var alive uint32
+	for _, candidate := range list.Items {
+		cl := this.logger().
+			With("namespace", candidate.Namespace).
+			With("name", candidate.Name)
+
+		switch candidate.Status.Phase {
+		case v1.PodPending, v1.PodRunning:
+			if candidate.DeletionTimestamp != nil {
+				continue
+			}
+			if alive < this.conf.Pool.Size {
+				alive++
+				continue
+			}
+			if ok, err := this.removePod(ctx, candidate.Namespace, candidate.Name, nil); err != nil {
+				return fail(err)
+			} else if ok {
+				cl.Info("surplus pod of pool removed")
+			}
+		default:
+			if ok, err := this.removePod(ctx, candidate.Namespace, candidate.Name, nil); err != nil {
+				return fail(err)
+			} else if ok {
+				cl.Info("dead pod of pool removed")
+			}
+		}
+	}
+
+	for ; alive < this.conf.Pool.Size; alive++ {
+		created, err := this.createPodBy(newPoolRequest(ctx, this.logger()), nil)
+		if err != nil {
+			return fail(err)
+		}
+		this.logger().
+			With("namespace", created.Namespace).
+			With("name", created.Name).
+			Debug("pod of pool created")
+	}
+
+	return nil
+}
+
+func (this *KubernetesRepository) poolMemberLabelSelector() string {
+	return KubernetesLabelFlow + "=" + this.flow.String() + "," + KubernetesLabelPool + "=true"
+}
+
+// selectClaimablePoolMembers selects all running and ready PODs out of the
+// given candidates. The oldest ones are first.
+func selectClaimablePoolMembers(candidates []v1.Pod) []*v1.Pod {
+	isReady := func(pod *v1.Pod) bool {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodReady {
+				return condition.Status == v1.ConditionTrue
+			}
+		}
+		return false
+	}
+
+	var result []*v1.Pod
+	for i, candidate := range candidates {
+		if candidate.DeletionTimestamp != nil || candidate.Status.Phase != v1.PodRunning || !isReady(&candidate) {
+			continue
+		}
+		result = append(result, &candidates[i])
+	}
+	slices.SortStableFunc(result, func(a, b *v1.Pod) int {
+		return a.CreationTimestamp.Compare(b.CreationTimestamp.Time)
+	})
+	return result
+}
+
+// kubernetesPoolClaimPatchOf creates a merge patch which binds the given POD
+// to the given session. The resourceVersion is part of the patch to ensure
+// that the POD cannot be claimed by two sessions at the same time.
+func kubernetesPoolClaimPatchOf(pod *v1.Pod, sessionId session.Id, remote net.Remote) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"resourceVersion": pod.ResourceVersion,
+			"labels": map[string]any{
+				KubernetesLabelPool:      nil,
+				KubernetesLabelSessionId: sessionId.String(),
+			},
+			"annotations": map[string]any{
+				KubernetesAnnotationCreatedRemoteUser: remote.User(),
+				KubernetesAnnotationCreatedRemoteHost: remote.Host().String(),
+			},
+		},
+	})
+}
@@ -0,0 +1,74 @@
+package environment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+func TestSelectClaimablePoolMembers(t *testing.T) {
+	now := time.Now()
+	newPod := func(name string, age time.Duration, phase v1.PodPhase, ready bool) v1.Pod {
+		status := v1.ConditionFalse
+		if ready {
+			status = v1.ConditionTrue
+		}
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))},
+			Status: v1.PodStatus{
+				Phase:      phase,
+				Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+			},
+		}
+	}
+	deleted := newPod("deleted", time.Hour, v1.PodRunning, true)
+	deleted.DeletionTimestamp = &metav1.Time{}
+
+	actual := selectClaimablePoolMembers([]v1.Pod{
+		newPod("young", time.Minute, v1.PodRunning, true),
+		deleted,
+		newPod("pending", time.Hour, v1.PodPending, false),
+		newPod("not-ready", time.Hour, v1.PodRunning, false),
+		newPod("old", time.Hour, v1.PodRunning, true),
+		newPod("failed", time.Hour, v1.PodFailed, false),
+	})
+
+	var names []string
+	for _, v := range actual {
+		names = append(names, v.Name)
+	}
+	require.Equal(t, []string{"old", "young"}, names)
+}
+
+func TestKubernetesPoolClaimPatchOf(t *testing.T) {
+	sessionId := session.MustNewId()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "4711"}}
+
+	actual, err := kubernetesPoolClaimPatchOf(pod, sessionId, testKubernetesPoolRemote{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"metadata":{
+		"resourceVersion":"4711",
+		"labels":{"org.engity.bifroest/pool":null,"org.engity.bifroest/session-id":"`+sessionId.String()+`"},
+		"annotations":{"org.engity.bifroest/created-remote-user":"foo","org.engity.bifroest/created-remote-host":"1.2.3.4"}
+	}}`, string(actual))
+}
+
+type testKubernetesPoolRemote struct{}
+
+func (this testKubernetesPoolRemote) User() string {
+	return "foo"
+}
+
+func (this testKubernetesPoolRemote) Host() net.Host {
+	return net.MustNewHost("1.2.3.4")
+}
+
+func (this testKubernetesPoolRemote) String() string {
+	return "foo@1.2.3.4"
+}
@@ -22,6 +22,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
 	watch2 "k8s.io/apimachinery/pkg/watch"
 	corev1 "k8s.io/client-go/applyconfigurations/core/v1"
 	v2 "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -45,6 +46,9 @@ const (
 	KubernetesLabelPrefix    = "org.engity.bifroest/"
 	KubernetesLabelFlow      = KubernetesLabelPrefix + "flow"
 	KubernetesLabelSessionId = KubernetesLabelPrefix + "session-id"
+	KubernetesLabelHome      = KubernetesLabelPrefix + "home"
+	KubernetesLabelIsolation = KubernetesLabelPrefix + "isolation"
+	KubernetesLabelPool      = KubernetesLabelPrefix + "pool"
 
 	KubernetesAnnotationPrefix                = KubernetesLabelPrefix
 	KubernetesAnnotationCreatedRemoteUser     = KubernetesAnnotationPrefix + "created-remote-user"
@@ -56,6 +60,13 @@ const (
 	KubernetesAnnotationGroup                 = KubernetesAnnotationPrefix + "group"
 	KubernetesAnnotationDirectory             = KubernetesAnnotationPrefix + "directory"
 	KubernetesAnnotationPortForwardingAllowed = KubernetesAnnotationPrefix + "portForwardingAllowed"
+	KubernetesAnnotationSocketForwardingPaths = KubernetesAnnotationPrefix + "socketForwardingPaths"
+	KubernetesAnnotationX11ForwardingAllowed  = KubernetesAnnotationPrefix + "x11ForwardingAllowed"
+	KubernetesAnnotationTerminalsPersistent   = KubernetesAnnotationPrefix + "terminalsPersistent"
+	KubernetesAnnotationHomeKey               = KubernetesAnnotationPrefix + "home-key"
+	KubernetesAnnotationHomeLastLogin         = KubernetesAnnotationPrefix + "home-last-login"
+	KubernetesAnnotationIsolated              = KubernetesAnnotationPrefix + "isolated"
+	KubernetesAnnotationImpSessionId          = KubernetesAnnotationPrefix + "imp-session-id"
 
 	amountOfEnsureTries = 5
 )
@@ -73,6 +84,10 @@ type KubernetesRepository struct {
 
 	sessionIdMutex  common.KeyedMutex[session.Id]
 	activeInstances sync.Map
+
+	poolCtx     context.Context
+	poolCancel  context.CancelFunc
+	poolFilling atomic.Bool
 }
 
 func NewKubernetesRepository(_ context.Context, flow configuration.FlowName, conf *configuration.EnvironmentKubernetes, ap alternatives.Provider, i imp.Imp) (*KubernetesRepository, error) {
@@ -110,6 +125,7 @@ func NewKubernetesRepository(_ context.Context, flow configuration.FlowName, con
 		imp:          i,
 		client:       client,
 	}
+	result.poolCtx, result.poolCancel = context.WithCancel(context.Background())
 
 	lp := result.logger().GetProvider()
 	if la, ok := lp.(level.Aware); ok {
@@ -205,6 +221,12 @@ func (this *KubernetesRepository) createPodBy(req Request, sess session.Session)
 		}()
 	}
 
+	patched, err := this.applyPodPatch(req, config)
+	if err != nil {
+		return fail(err)
+	}
+	config = patched
+
 	clientSet, err := this.client.ClientSet()
 	if err != nil {
 		return fail(err)
@@ -227,7 +249,13 @@ func (this *KubernetesRepository) createPodBy(req Request, sess session.Session)
 		return failf(errors.System, "cannot create POD: %w", err)
 	}
 	defer func() {
-		if !success {
+		if !success && config.Annotations[KubernetesAnnotationIsolated] == "true" {
+			if _, err := this.removeIsolatedNamespace(req.Context(), config.Namespace); err != nil {
+				req.Connection().Logger().WithError(err).
+					With("namespace", config.Namespace).
+					Warn("cannot delete orphan isolated namespace; it might stay and needs to be removed manually")
+			}
+		} else if !success {
 			if err := this.deletePod(req.Context(), config); err != nil {
 				req.Connection().Logger().WithError(err).
 					With("namespace", config.Namespace).
@@ -537,8 +565,19 @@ func (this *KubernetesRepository) resolvePodConfig(req Request, sess session.Ses
 		},
 	}
 
-	if result.Name, err = this.conf.Name.Render(req); err != nil {
-		return fail(err)
+	// PODs of the pool do not belong to any session before they are claimed.
+	// Their IMP is bound to a generated session ID instead.
+	var impSessionId session.Id
+	if sess != nil {
+		impSessionId = sess.Id()
+		if result.Name, err = this.conf.Name.Render(req); err != nil {
+			return fail(err)
+		}
+	} else {
+		if impSessionId, err = session.NewId(); err != nil {
+			return fail(err)
+		}
+		result.Name = kubernetesPoolMemberNamePrefix + rand.String(10)
 	}
 	if result.Namespace, err = this.conf.Namespace.Render(req); err != nil {
 		return fail(err)
@@ -547,19 +586,31 @@ func (this *KubernetesRepository) resolvePodConfig(req Request, sess session.Ses
 		result.Namespace = this.client.Namespace()
 	}
 
-	if err := this.ensureNamespace(req.Context(), result.Namespace); err != nil {
+	isolation, err := this.resolveIsolation(req)
+	if err != nil {
+		return fail(err)
+	}
+	if isolation != nil {
+		result.Namespace = isolation.namespace
+		if err := this.ensureIsolation(req, sess, isolation); err != nil {
+			return fail(err)
+		}
+	} else if err := this.ensureNamespace(req.Context(), result.Namespace); err != nil {
 		return failf("cannot ensure POD's namespace (%q): %w", result.Namespace, err)
 	}
 
-	remote := req.Connection().Remote()
 	result.Labels = map[string]string{
-		KubernetesLabelFlow:      this.flow.String(),
-		KubernetesLabelSessionId: sess.Id().String(),
-	}
-
-	result.Annotations = map[string]string{
-		KubernetesAnnotationCreatedRemoteUser: remote.User(),
-		KubernetesAnnotationCreatedRemoteHost: remote.Host().String(),
+		KubernetesLabelFlow: this.flow.String(),
+	}
+	result.Annotations = map[string]string{}
+	if sess != nil {
+		remote := req.Connection().Remote()
+		result.Labels[KubernetesLabelSessionId] = sess.Id().String()
+		result.Annotations[KubernetesAnnotationCreatedRemoteUser] = remote.User()
+		result.Annotations[KubernetesAnnotationCreatedRemoteHost] = remote.Host().String()
+	} else {
+		result.Labels[KubernetesLabelPool] = "true"
+		result.Annotations[KubernetesAnnotationImpSessionId] = impSessionId.String()
 	}
 	if result.Annotations[KubernetesAnnotationShellCommand], err = this.resolveEncodedShellCommand(req); err != nil {
 		return fail(err)
@@ -584,9 +635,28 @@ func (this *KubernetesRepository) resolvePodConfig(req Request, sess session.Ses
 	} else if v {
 		result.Annotations[KubernetesAnnotationPortForwardingAllowed] = "true"
 	}
+	if v, err := this.conf.SocketForwardingPaths.Render(req); err != nil {
+		return failf("cannot evaluate socketForwardingPaths: %w", err)
+	} else if len(v) > 0 {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return failf("cannot encode socketForwardingPaths: %w", err)
+		}
+		result.Annotations[KubernetesAnnotationSocketForwardingPaths] = string(b)
+	}
+	if v, err := this.conf.X11ForwardingAllowed.Render(req); err != nil {
+		return failf("cannot evaluate x11ForwardingAllowed: %w", err)
+	} else if v {
+		result.Annotations[KubernetesAnnotationX11ForwardingAllowed] = "true"
+	}
+	if v, err := this.conf.Terminals.Persistent.Render(req); err != nil {
+		return failf("cannot evaluate terminals.persistent: %w", err)
+	} else if v {
+		result.Annotations[KubernetesAnnotationTerminalsPersistent] = "true"
+	}
 
 	var containerImage string
-	if v, err := this.resolveContainerConfig(req, sess); err != nil {
+	if v, err := this.resolveContainerConfig(req, impSessionId); err != nil {
 		return fail(err)
 	} else {
 		result.Spec.Containers = []v1.Container{v}
@@ -637,6 +707,27 @@ func (this *KubernetesRepository) resolvePodConfig(req Request, sess session.Ses
 	}}
 	// TODO! Maybe, allow other volumens?
 
+	if claimName, directory, err := this.ensureHomeClaim(req, result.Namespace); err != nil {
+		return fail(err)
+	} else if claimName != "" {
+		result.Spec.Volumes = append(result.Spec.Volumes, v1.Volume{
+			Name: homeVolumeName,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claimName,
+				},
+			},
+		})
+		result.Spec.Containers[0].VolumeMounts = append(result.Spec.Containers[0].VolumeMounts, v1.VolumeMount{
+			Name:      homeVolumeName,
+			MountPath: directory,
+		})
+	}
+
+	if isolation != nil {
+		this.applyIsolation(isolation, result)
+	}
+
 	result.Spec.DNSConfig = &v1.PodDNSConfig{}
 	if result.Spec.DNSConfig.Nameservers, err = this.conf.DnsServers.Render(req); err != nil {
 		return failf("cannot evaluate dnsServer: %w", err)
@@ -744,7 +835,7 @@ func (this *KubernetesRepository) resolveInitContainerConfig(req Request, _ sess
 	return result, nil
 }
 
-func (this *KubernetesRepository) resolveContainerConfig(req Request, sess session.Session) (result v1.Container, err error) {
+func (this *KubernetesRepository) resolveContainerConfig(req Request, impSessionId session.Id) (result v1.Container, err error) {
 	fail := func(err error) (v1.Container, error) {
 		return v1.Container{}, err
 	}
@@ -822,7 +913,7 @@ func (this *KubernetesRepository) resolveContainerConfig(req Request, sess sessi
 		Value: base64.RawStdEncoding.EncodeToString(masterPub.Marshal()),
 	}, {
 		Name:  session.EnvName,
-		Value: sess.Id().String(),
+		Value: impSessionId.String(),
 	}}
 
 	result.VolumeMounts = []v1.VolumeMount{{
@@ -1001,10 +1092,15 @@ func (this *KubernetesRepository) findOrEnsureBySession(ctx context.Context, ses
 	}
 
 	if existing == nil {
-		existing, err = this.createPodBy(createUsing, sess)
-		if err != nil {
+		if existing, err = this.claimPoolMember(createUsing, sess); err != nil {
 			return fail(err)
 		}
+		if existing == nil {
+			if existing, err = this.createPodBy(createUsing, sess); err != nil {
+				return fail(err)
+			}
+		}
+		this.refillPool()
 	}
 
 	logger := this.logger().
@@ -1149,20 +1245,33 @@ func (this *KubernetesRepository) podsClient() (v2.PodInterface, error) {
 		return nil, err
 	}
 
+	return clientSet.CoreV1().Pods(this.lookupNamespace()), nil
+}
+
+// lookupNamespace returns the namespace where all resources of this
+// repository are located in, or an empty string for all namespaces.
+func (this *KubernetesRepository) lookupNamespace() string {
+	if !this.conf.Isolation.Namespace.IsZero() {
+		// Each session has its own namespace.
+		return ""
+	}
 	if v := this.conf.Namespace; v.IsHardCoded() {
 		if !v.IsZero() {
-			return clientSet.CoreV1().Pods(v.String()), nil
+			return v.String()
 		}
 		if cv := this.client.Namespace(); cv != "" {
-			return clientSet.CoreV1().Pods(cv), nil
+			return cv
 		}
 	}
 
 	// All namespaces fallback
-	return clientSet.CoreV1().Pods(""), nil
+	return ""
 }
 
 func (this *KubernetesRepository) Close() error {
+	if v := this.poolCancel; v != nil {
+		v()
+	}
 	return nil
 }
 
@@ -1200,8 +1309,16 @@ func (this *KubernetesRepository) Cleanup(ctx context.Context, opts *CleanupOpts
 
 			cl = cl.With("flow", flow)
 
+			isPoolMember := candidate.Labels[KubernetesLabelPool] == "true"
+			if isPoolMember && flow.IsEqualTo(this.flow) {
+				cl.Debug("found unclaimed pod of the pool of this flow environment; ignoring...")
+				continue
+			}
+
 			var sessionId session.Id
-			if err := sessionId.Set(candidate.Labels[KubernetesLabelSessionId]); err != nil || flow.IsZero() {
+			if isPoolMember {
+				cl = cl.With("pool", true)
+			} else if err := sessionId.Set(candidate.Labels[KubernetesLabelSessionId]); err != nil || flow.IsZero() {
 				if ok, err := this.removePod(ctx, candidate.Namespace, candidate.Name, nil); err != nil {
 					cl.WithError(err).
 						Warn("cannot remove dead pod; this message might continue appearing until manually fixed; skipping...")
@@ -1274,6 +1391,13 @@ func (this *KubernetesRepository) Cleanup(ctx context.Context, opts *CleanupOpts
 		}
 
 		if list.Continue == "" {
+			if err := this.cleanupIsolatedNamespaces(ctx, opts, l); err != nil {
+				return err
+			}
+			if err := this.cleanupHomes(ctx, l); err != nil {
+				return err
+			}
+			this.refillPool()
 			return nil
 		}
 		listOpts.Continue = list.Continue
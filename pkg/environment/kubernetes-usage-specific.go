@@ -3,6 +3,7 @@ package environment
 import (
 	"context"
 	"io"
+	gonet "net"
 	"os"
 	"path/filepath"
 	"slices"
@@ -84,6 +85,8 @@ func (this *kubernetes) Run(t Task) (exitCode int, rErr error) {
 	ev.Add(t.SshSession().Environ()...)
 	ev.Set(session.EnvName, sess.Id().String())
 
+	binding := connectionBindingOf(t, this.terminalsPersistent, this.x11ForwardingAllowed)
+
 	var path string
 	var command []string
 	switch t.TaskType() {
@@ -107,7 +110,7 @@ func (this *kubernetes) Run(t Task) (exitCode int, rErr error) {
 		}
 	}
 
-	if ssh.AgentRequested(sshSess) {
+	if binding.agent {
 		ln, err := this.impSession.InitiateNamedPipe(t.Context(), t.Connection().Id(), "ssh-agent")
 		var re errors.RemoteError
 		if errors.As(err, &re) {
@@ -121,6 +124,42 @@ func (this *kubernetes) Run(t Task) (exitCode int, rErr error) {
 		}
 	}
 
+	if x11 := binding.x11; x11 != nil {
+		var user, group string
+		if this.repository.conf.Os == sys.OsLinux {
+			user, group = this.user, this.group
+		}
+		ln, err := this.impSession.InitiateX11Listen(t.Context(), t.Connection().Id(), x11.AuthProtocol(), x11.Cookie(), user, group)
+		var re errors.RemoteError
+		if errors.As(err, &re) {
+			l.WithError(err).Warn("it was not possible to initiate X11 display; X11 forwarding deactivated")
+		} else if err != nil {
+			return fail(err)
+		} else {
+			defer common.IgnoreCloseError(ln)
+			go ssh.ForwardX11Connections(ln, l, sshSess, x11)
+			ev.Set(
+				ssh.DisplayEnvName, x11.Display(ln.Display()),
+				ssh.XAuthorityEnvName, ln.Authority(),
+			)
+		}
+	}
+
+	if binding.persistentTerminal {
+		ptyReq, _, _ := sshSess.Pty()
+		ev.Set("TERM", ptyReq.Term)
+		start := imp.TerminalStart{
+			Path: path,
+			Argv: command,
+			Dir:  this.directory,
+			Env:  ev,
+		}
+		if this.repository.conf.Os == sys.OsLinux {
+			start.User, start.Group = this.user, this.group
+		}
+		return runPersistentTerminal(t, this.impSession, &this.repository.conf.Terminals, start)
+	}
+
 	if ptyReq, winCh, isPty := sshSess.Pty(); isPty {
 		ev.Set("TERM", ptyReq.Term)
 		opts.TTY = true
@@ -277,3 +316,58 @@ func (this *kubernetes) NewDestinationConnection(ctx context.Context, dest net.H
 
 	return this.impSession.InitiateTcpForward(ctx, connId, dest)
 }
+
+func (this *kubernetes) NewRemoteListener(ctx context.Context, bind net.HostPort) (RemoteListener, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := this.impSession.InitiateTcpListen(ctx, connId, bind)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *kubernetes) IsSocketForwardingAllowed(path string) (bool, error) {
+	return this.portForwardingAllowed && net.IsSocketPathAllowed(this.socketForwardingPaths, path), nil
+}
+
+func (this *kubernetes) NewDestinationSocketConnection(ctx context.Context, path string) (io.ReadWriteCloser, error) {
+	if ok, _ := this.IsSocketForwardingAllowed(path); !ok {
+		return nil, errors.Newf(errors.Permission, "socket forwarding to %s not allowed", path)
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	return this.impSession.InitiateStreamLocalForward(ctx, connId, path, this.socketForwardingPaths)
+}
+
+func (this *kubernetes) NewRemoteSocketListener(ctx context.Context, path string) (gonet.Listener, error) {
+	if ok, _ := this.IsSocketForwardingAllowed(path); !ok {
+		return nil, errors.Newf(errors.Permission, "socket forwarding at %s not allowed", path)
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := this.impSession.InitiateStreamLocalListen(ctx, connId, path, this.socketForwardingPaths)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *kubernetes) IsX11ForwardingAllowed() (bool, error) {
+	return this.x11ForwardingAllowed, nil
+}
@@ -33,6 +33,10 @@ type kubernetes struct {
 	namespace string
 	sessionId session.Id
 
+	// impSessionId is the session ID the IMP was started with. It differs
+	// from sessionId if the POD was claimed from the pool.
+	impSessionId session.Id
+
 	remoteUser string
 	remoteHost net.Host
 
@@ -44,6 +48,10 @@ type kubernetes struct {
 	directory    string
 
 	portForwardingAllowed bool
+	socketForwardingPaths []string
+	x11ForwardingAllowed  bool
+	terminalsPersistent   bool
+	isolated              bool
 
 	impSession imp.Session
 	environ    sys.EnvVars
@@ -52,7 +60,7 @@ type kubernetes struct {
 }
 
 func (this *kubernetes) SessionId() session.Id {
-	return this.sessionId
+	return this.impSessionId
 }
 
 func (this *kubernetes) PublicKey() crypto.PublicKey {
@@ -135,6 +143,11 @@ func (this *kubernetes) Dispose(ctx context.Context) (_ bool, rErr error) {
 	if err != nil {
 		return fail(err)
 	}
+	if this.isolated {
+		if _, err := this.repository.removeIsolatedNamespace(ctx, this.namespace); err != nil {
+			return fail(err)
+		}
+	}
 
 	return ok, nil
 }
@@ -227,6 +240,19 @@ func (this *kubernetes) parsePod(pod *v1.Pod) (err error) {
 	this.group = annotations[KubernetesAnnotationGroup]
 	this.directory = annotations[KubernetesAnnotationDirectory]
 	this.portForwardingAllowed = annotations[KubernetesAnnotationPortForwardingAllowed] == "true"
+	if v := annotations[KubernetesAnnotationSocketForwardingPaths]; v == "" {
+		this.socketForwardingPaths = nil
+	} else if this.socketForwardingPaths, err = decodeStrings(v); err != nil {
+		return failf("cannot decode annotation %s: %w", KubernetesAnnotationSocketForwardingPaths, err)
+	}
+	this.x11ForwardingAllowed = annotations[KubernetesAnnotationX11ForwardingAllowed] == "true"
+	this.terminalsPersistent = annotations[KubernetesAnnotationTerminalsPersistent] == "true"
+	this.isolated = annotations[KubernetesAnnotationIsolated] == "true"
+	if v := annotations[KubernetesAnnotationImpSessionId]; v == "" {
+		this.impSessionId = this.sessionId
+	} else if err = this.impSessionId.UnmarshalText([]byte(v)); err != nil {
+		return failf("cannot decode annotation %s: %w", KubernetesAnnotationImpSessionId, err)
+	}
 
 	return nil
 }
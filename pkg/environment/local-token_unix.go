@@ -10,6 +10,8 @@ import (
 type localToken struct {
 	User                  localTokenUser `json:"user"`
 	PortForwardingAllowed bool           `json:"portForwardingAllowed"`
+	SocketForwardingPaths []string       `json:"socketForwardingPaths,omitempty"`
+	X11ForwardingAllowed  bool           `json:"x11ForwardingAllowed,omitempty"`
 }
 
 type localTokenUser struct {
@@ -31,6 +33,16 @@ func (this *LocalRepository) newLocalToken(u *user.User, req Request, userIsMana
 		return fail(err)
 	}
 
+	socketForwardingPaths, err := this.conf.SocketForwardingPaths.Render(req)
+	if err != nil {
+		return fail(err)
+	}
+
+	x11ForwardingAllowed, err := this.conf.X11ForwardingAllowed.Render(req)
+	if err != nil {
+		return fail(err)
+	}
+
 	deleteOnDispose, err := this.conf.Dispose.DeleteManagedUser.Render(req)
 	if err != nil {
 		return fail(err)
@@ -54,5 +66,7 @@ func (this *LocalRepository) newLocalToken(u *user.User, req Request, userIsMana
 			killProcessesOnDispose && userIsManaged,
 		},
 		portForwardingAllowed,
+		socketForwardingPaths,
+		x11ForwardingAllowed,
 	}, nil
 }
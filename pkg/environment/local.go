@@ -6,6 +6,7 @@ import (
 	gonet "net"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -82,6 +83,15 @@ func (this *local) Run(t Task) (exitCode int, rErr error) {
 		ev.Set(ssh.AuthSockEnvName, ln.Path())
 	}
 
+	if x11 := ssh.X11Requested(sshSess); x11 != nil {
+		display, err := this.startX11Forwarding(t, x11, ev)
+		if err != nil {
+			l.WithError(err).Warn("it was not possible to initiate X11 display; X11 forwarding deactivated")
+		} else {
+			defer common.IgnoreCloseError(display)
+		}
+	}
+
 	cmd.Stdin = sshSess
 	cmd.Stdout = sshSess
 	if t.TaskType() == TaskTypeSftp {
@@ -254,3 +264,16 @@ func (this *local) NewDestinationConnection(ctx context.Context, dest net.HostPo
 	var dialer gonet.Dialer
 	return dialer.DialContext(ctx, "tcp", dest.String())
 }
+
+func (this *local) NewRemoteListener(ctx context.Context, bind net.HostPort) (RemoteListener, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	var lc gonet.ListenConfig
+	ln, err := lc.Listen(ctx, "tcp", gonet.JoinHostPort(bind.Host.String(), strconv.FormatUint(uint64(bind.Port), 10)))
+	if err != nil {
+		return nil, err
+	}
+	return &netRemoteListener{ln}, nil
+}
@@ -4,6 +4,8 @@ package environment
 
 import (
 	"context"
+	"io"
+	gonet "net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,7 +16,9 @@ import (
 
 	"github.com/engity-com/bifroest/pkg/common"
 	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
 	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/ssh"
 	"github.com/engity-com/bifroest/pkg/sys"
 	"github.com/engity-com/bifroest/pkg/user"
 )
@@ -24,6 +28,8 @@ type local struct {
 	session                    session.Session
 	user                       *user.User
 	portForwardingAllowed      bool
+	socketForwardingPaths      []string
+	x11ForwardingAllowed       bool
 	deleteUserOnDispose        bool
 	deleteUserHomeDirOnDispose bool
 	killUserProcessesOnDispose bool
@@ -35,6 +41,8 @@ func (this *LocalRepository) new(u *user.User, sess session.Session, portForward
 		sess,
 		u,
 		portForwardingAllowed,
+		lt.SocketForwardingPaths,
+		lt.X11ForwardingAllowed,
 		lt.User.DeleteOnDispose,
 		lt.User.DeleteHomeDirOnDispose,
 		lt.User.KillProcessesOnDispose,
@@ -135,3 +143,117 @@ func (this *local) dispose(ctx context.Context) (bool, error) {
 
 	return disposed, nil
 }
+
+func (this *local) IsSocketForwardingAllowed(path string) (bool, error) {
+	return this.portForwardingAllowed && net.IsSocketPathAllowed(this.socketForwardingPaths, path), nil
+}
+
+func (this *local) NewDestinationSocketConnection(ctx context.Context, path string) (io.ReadWriteCloser, error) {
+	if ok, _ := this.IsSocketForwardingAllowed(path); !ok {
+		return nil, errors.Newf(errors.Permission, "socket forwarding to %s not allowed", path)
+	}
+
+	resolved, err := net.ResolveAllowedSocketPath(this.socketForwardingPaths, path, false)
+	if err != nil {
+		return nil, err
+	}
+	// Bifröst itself is usually much more privileged than the user; connecting
+	// requires write permission on the socket.
+	if err := this.checkAccess(resolved, sys.AccessWrite); err != nil {
+		return nil, err
+	}
+
+	var dialer gonet.Dialer
+	return dialer.DialContext(ctx, "unix", resolved)
+}
+
+func (this *local) NewRemoteSocketListener(ctx context.Context, path string) (gonet.Listener, error) {
+	if ok, _ := this.IsSocketForwardingAllowed(path); !ok {
+		return nil, errors.Newf(errors.Permission, "socket forwarding at %s not allowed", path)
+	}
+
+	resolved, err := net.ResolveAllowedSocketPath(this.socketForwardingPaths, path, true)
+	if err != nil {
+		return nil, err
+	}
+	// Creating the socket requires write permission on its directory.
+	if err := this.checkAccess(filepath.Dir(resolved), sys.AccessWrite|sys.AccessExecute); err != nil {
+		return nil, err
+	}
+
+	var lc gonet.ListenConfig
+	ln, err := lc.Listen(ctx, "unix", resolved)
+	if err != nil {
+		return nil, err
+	}
+	// The socket should belong to the user and not to Bifröst itself. Lchown
+	// ensures that a symlink, which replaced the socket in the meanwhile, is
+	// never followed.
+	if err := os.Lchown(resolved, int(this.user.Uid), int(this.user.Group.Gid)); err != nil {
+		common.IgnoreCloseError(ln)
+		return nil, errors.System.Newf("cannot change owner of socket %s: %w", resolved, err)
+	}
+	return ln, nil
+}
+
+// checkAccess checks if the user of this environment can access the given
+// path with the given mode, as the socket operations are performed by Bifröst
+// itself and not by the user.
+func (this *local) checkAccess(path string, mode sys.AccessMode) error {
+	creds := this.user.ToCredentials()
+	if err := sys.CheckAccess(&creds, path, mode); errors.Is(err, syscall.EACCES) {
+		return errors.Newf(errors.Permission, "user %v is not allowed to access %s", this.user, path)
+	} else if err != nil {
+		return errors.System.Newf("cannot check access of user %v to %s: %w", this.user, path, err)
+	}
+	return nil
+}
+
+func (this *local) IsX11ForwardingAllowed() (bool, error) {
+	return this.x11ForwardingAllowed, nil
+}
+
+// startX11Forwarding allocates a X11 display at the host for the given
+// X11Forwarding and provides it via ev to the process of the user.
+func (this *local) startX11Forwarding(t Task, x11 *ssh.X11Forwarding, ev *sys.EnvVars) (io.Closer, error) {
+	if !this.x11ForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "X11 forwarding not allowed")
+	}
+
+	ln, display, err := ssh.ListenX11Display()
+	if err != nil {
+		return nil, err
+	}
+	result := &localX11Display{listener: ln}
+	success := false
+	defer common.IgnoreCloseErrorIfFalse(&success, result)
+
+	if result.authority, err = ssh.NewXAuthorityFile(display, x11.AuthProtocol(), x11.Cookie()); err != nil {
+		return nil, err
+	}
+	// The Xauthority file should belong to the user and not to Bifröst itself.
+	if err := os.Chown(result.authority, int(this.user.Uid), int(this.user.Group.Gid)); err != nil {
+		return nil, errors.System.Newf("cannot change owner of Xauthority file %s: %w", result.authority, err)
+	}
+
+	go ssh.ForwardX11Connections(ln, t.Connection().Logger(), t.SshSession(), x11)
+	ev.Set(
+		ssh.DisplayEnvName, x11.Display(display),
+		ssh.XAuthorityEnvName, result.authority,
+	)
+
+	success = true
+	return result, nil
+}
+
+type localX11Display struct {
+	listener  gonet.Listener
+	authority string
+}
+
+func (this *localX11Display) Close() error {
+	if this.authority != "" {
+		_ = os.Remove(this.authority)
+	}
+	return this.listener.Close()
+}
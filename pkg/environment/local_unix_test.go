@@ -0,0 +1,63 @@
+//go:build unix
+
+package environment
+
+import (
+	"context"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/user"
+)
+
+func TestLocal_socketForwarding_respectsAccessOfUser(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(filepath.Dir(dir), 0711))
+	require.NoError(t, os.Chmod(dir, 0755))
+
+	self := &user.User{Uid: user.Id(os.Getuid()), Group: user.Group{Gid: user.GroupId(os.Getgid())}}
+	other := &user.User{Uid: self.Uid + 4242, Group: user.Group{Gid: self.Group.Gid + 4242}}
+	newLocal := func(u *user.User) *local {
+		return &local{
+			user:                  u,
+			portForwardingAllowed: true,
+			socketForwardingPaths: []string{filepath.ToSlash(dir) + "/*.sock"},
+		}
+	}
+
+	existing := filepath.Join(dir, "existing.sock")
+	ln, err := gonet.Listen("unix", existing)
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(ln)
+	require.NoError(t, os.Chmod(existing, 0600))
+
+	t.Run("dial-denied", func(t *testing.T) {
+		_, actualErr := newLocal(other).NewDestinationSocketConnection(context.Background(), existing)
+		require.True(t, errors.IsType(actualErr, errors.Permission), "expected permission error but got: %v", actualErr)
+	})
+
+	t.Run("dial-allowed", func(t *testing.T) {
+		conn, actualErr := newLocal(self).NewDestinationSocketConnection(context.Background(), existing)
+		require.NoError(t, actualErr)
+		require.NoError(t, conn.Close())
+	})
+
+	t.Run("listen-denied", func(t *testing.T) {
+		fn := filepath.Join(dir, "new-denied.sock")
+		_, actualErr := newLocal(other).NewRemoteSocketListener(context.Background(), fn)
+		require.True(t, errors.IsType(actualErr, errors.Permission), "expected permission error but got: %v", actualErr)
+		require.NoFileExists(t, fn)
+	})
+
+	t.Run("listen-allowed", func(t *testing.T) {
+		actual, actualErr := newLocal(self).NewRemoteSocketListener(context.Background(), filepath.Join(dir, "new-allowed.sock"))
+		require.NoError(t, actualErr)
+		require.NoError(t, actual.Close())
+	})
+}
@@ -4,6 +4,8 @@ package environment
 
 import (
 	"context"
+	"io"
+	gonet "net"
 	"os"
 	"os/exec"
 	"syscall"
@@ -14,6 +16,7 @@ import (
 	"github.com/engity-com/bifroest/pkg/configuration"
 	"github.com/engity-com/bifroest/pkg/errors"
 	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/ssh"
 	"github.com/engity-com/bifroest/pkg/sys"
 	"github.com/engity-com/bifroest/pkg/template"
 )
@@ -139,3 +142,23 @@ func (this *local) signal(cmd *exec.Cmd, logger log.Logger, signal glssh.Signal)
 func (this *local) dispose(_ context.Context) (bool, error) {
 	return true, nil
 }
+
+func (this *local) IsSocketForwardingAllowed(string) (bool, error) {
+	return false, nil
+}
+
+func (this *local) NewDestinationSocketConnection(context.Context, string) (io.ReadWriteCloser, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by local environments on windows")
+}
+
+func (this *local) NewRemoteSocketListener(context.Context, string) (gonet.Listener, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by local environments on windows")
+}
+
+func (this *local) IsX11ForwardingAllowed() (bool, error) {
+	return false, nil
+}
+
+func (this *local) startX11Forwarding(Task, *ssh.X11Forwarding, *sys.EnvVars) (io.Closer, error) {
+	return nil, errors.Newf(errors.Permission, "X11 forwarding is not supported by local environments on Windows")
+}
@@ -0,0 +1,560 @@
+package environment
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	gonet "net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+const (
+	podmanApiVersion = "v4.0.0"
+
+	podmanDefaultRootfulSocket = "/run/podman/podman.sock"
+)
+
+// podmanApiClient is a minimal client of the libpod REST API of Podman
+// which is served over a unix socket. It only covers the endpoints the
+// PodmanRepository requires.
+type podmanApiClient struct {
+	socket     string
+	dialer     gonet.Dialer
+	httpClient http.Client
+}
+
+func newPodmanApiClient(conf *configuration.EnvironmentPodman) (_ *podmanApiClient, err error) {
+	fail := func(err error) (*podmanApiClient, error) {
+		return nil, err
+	}
+	failf := func(msg string, args ...any) (*podmanApiClient, error) {
+		return fail(errors.Config.Newf(msg, args...))
+	}
+
+	host, err := conf.Host.Render(struct{}{})
+	if err != nil {
+		return failf("cannot evaluate host: %w", err)
+	}
+	socket, err := resolvePodmanSocket(host)
+	if err != nil {
+		return fail(err)
+	}
+
+	return newPodmanApiClientFor(socket), nil
+}
+
+func newPodmanApiClientFor(socket string) *podmanApiClient {
+	result := &podmanApiClient{
+		socket: socket,
+	}
+	result.httpClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (gonet.Conn, error) {
+			return result.dial(ctx)
+		},
+	}
+	return result
+}
+
+// resolvePodmanSocket returns the path of the unix socket the given host
+// points to. If host is empty, the socket of the rootless service of the
+// current user is used (if XDG_RUNTIME_DIR is set and Bifröst does not run
+// as root), otherwise the one of the rootful service.
+func resolvePodmanSocket(host string) (string, error) {
+	if host == "" {
+		if v := os.Getenv("XDG_RUNTIME_DIR"); v != "" && os.Geteuid() != 0 {
+			return filepath.Join(v, "podman", "podman.sock"), nil
+		}
+		return podmanDefaultRootfulSocket, nil
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", errors.Config.Newf("illegal host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "":
+		return host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", errors.Config.Newf("illegal host %q: missing path", host)
+		}
+		return u.Path, nil
+	default:
+		return "", errors.Config.Newf("illegal host %q: only unix sockets are supported", host)
+	}
+}
+
+func (this *podmanApiClient) dial(ctx context.Context) (gonet.Conn, error) {
+	return this.dialer.DialContext(ctx, "unix", this.socket)
+}
+
+func (this *podmanApiClient) newRequest(ctx context.Context, method, path string, query url.Values, body any) (*http.Request, error) {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     "d",
+		Path:     "/" + podmanApiVersion + "/libpod" + path,
+		RawQuery: query.Encode(),
+	}
+
+	var br io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		br = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), br)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func (this *podmanApiClient) do(req *http.Request, expectedStatus ...int) (*http.Response, error) {
+	resp, err := this.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Network.Newf("%s %s failed: %w", req.Method, req.URL.Path, err)
+	}
+	for _, s := range expectedStatus {
+		if resp.StatusCode == s {
+			return resp, nil
+		}
+	}
+	defer common.IgnoreCloseError(resp.Body)
+	return nil, newPodmanApiError(req, resp)
+}
+
+func (this *podmanApiClient) doJson(ctx context.Context, method, path string, query url.Values, body any, result any, expectedStatus ...int) error {
+	req, err := this.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	resp, err := this.do(req, expectedStatus...)
+	if err != nil {
+		return err
+	}
+	defer common.IgnoreCloseError(resp.Body)
+
+	if result == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.System.Newf("cannot decode response of %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+func (this *podmanApiClient) exists(ctx context.Context, path string) (bool, error) {
+	req, err := this.newRequest(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := this.do(req, http.StatusNoContent)
+	if isPodmanNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	common.IgnoreCloseError(resp.Body)
+	return true, nil
+}
+
+func (this *podmanApiClient) Info(ctx context.Context) (*podmanInfo, error) {
+	var result podmanInfo
+	if err := this.doJson(ctx, http.MethodGet, "/info", nil, nil, &result, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (this *podmanApiClient) ImageExists(ctx context.Context, ref string) (bool, error) {
+	return this.exists(ctx, "/images/"+url.PathEscape(ref)+"/exists")
+}
+
+// ImagePull starts to pull the given image. The returned reader contains
+// the progress as a stream of podmanImagePullReport.
+func (this *podmanApiClient) ImagePull(ctx context.Context, ref string, auth string) (io.ReadCloser, error) {
+	req, err := this.newRequest(ctx, http.MethodPost, "/images/pull", url.Values{
+		"reference": {ref},
+		"policy":    {"always"},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth != "" {
+		req.Header.Set("X-Registry-Auth", auth)
+	}
+	resp, err := this.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (this *podmanApiClient) ContainerCreate(ctx context.Context, spec *podmanContainerSpec) (string, error) {
+	var result podmanIdResponse
+	if err := this.doJson(ctx, http.MethodPost, "/containers/create", nil, spec, &result, http.StatusCreated); err != nil {
+		return "", err
+	}
+	return result.Id, nil
+}
+
+func (this *podmanApiClient) ContainerStart(ctx context.Context, id string) error {
+	return this.doJson(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil, nil, http.StatusNoContent, http.StatusNotModified)
+}
+
+func (this *podmanApiClient) ContainerList(ctx context.Context, filters map[string][]string) ([]podmanContainer, error) {
+	query := url.Values{"all": {"true"}}
+	if len(filters) > 0 {
+		b, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(b))
+	}
+	var result []podmanContainer
+	if err := this.doJson(ctx, http.MethodGet, "/containers/json", query, nil, &result, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *podmanApiClient) ContainerInspect(ctx context.Context, id string) (*podmanContainerInspect, error) {
+	var result podmanContainerInspect
+	if err := this.doJson(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, nil, &result, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (this *podmanApiClient) ContainerRemove(ctx context.Context, id string) error {
+	return this.doJson(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id), url.Values{
+		"force": {"true"},
+		"v":     {"true"},
+	}, nil, nil, http.StatusOK, http.StatusNoContent)
+}
+
+func (this *podmanApiClient) PodExists(ctx context.Context, name string) (bool, error) {
+	return this.exists(ctx, "/pods/"+url.PathEscape(name)+"/exists")
+}
+
+func (this *podmanApiClient) PodCreate(ctx context.Context, spec *podmanPodSpec) (string, error) {
+	var result podmanIdResponse
+	if err := this.doJson(ctx, http.MethodPost, "/pods/create", nil, spec, &result, http.StatusCreated); err != nil {
+		return "", err
+	}
+	return result.Id, nil
+}
+
+func (this *podmanApiClient) PodInspect(ctx context.Context, name string) (*podmanPodInspect, error) {
+	var result podmanPodInspect
+	if err := this.doJson(ctx, http.MethodGet, "/pods/"+url.PathEscape(name)+"/json", nil, nil, &result, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (this *podmanApiClient) PodList(ctx context.Context, filters map[string][]string) ([]podmanPod, error) {
+	query := url.Values{}
+	if len(filters) > 0 {
+		b, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(b))
+	}
+	var result []podmanPod
+	if err := this.doJson(ctx, http.MethodGet, "/pods/json", query, nil, &result, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *podmanApiClient) PodRemove(ctx context.Context, name string) error {
+	return this.doJson(ctx, http.MethodDelete, "/pods/"+url.PathEscape(name), url.Values{
+		"force": {"true"},
+	}, nil, nil, http.StatusOK)
+}
+
+func (this *podmanApiClient) ExecCreate(ctx context.Context, containerId string, config *podmanExecConfig) (string, error) {
+	var result podmanIdResponse
+	if err := this.doJson(ctx, http.MethodPost, "/containers/"+url.PathEscape(containerId)+"/exec", nil, config, &result, http.StatusCreated); err != nil {
+		return "", err
+	}
+	return result.Id, nil
+}
+
+// ExecStart starts the given execution and hijacks the underlying
+// connection. The returned connection carries stdin towards and the output
+// from the execution. If tty is false, the output is multiplexed in the same
+// way as the Docker API does (see stdcopy).
+func (this *podmanApiClient) ExecStart(ctx context.Context, execId string, tty bool, width, height uint) (*podmanHijackedConn, error) {
+	fail := func(err error) (*podmanHijackedConn, error) {
+		return nil, err
+	}
+
+	body := podmanExecStart{Tty: tty}
+	if tty {
+		body.Width, body.Height = width, height
+	}
+	req, err := this.newRequest(ctx, http.MethodPost, "/exec/"+url.PathEscape(execId)+"/start", nil, body)
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := this.dial(ctx)
+	if err != nil {
+		return fail(errors.Network.Newf("cannot connect to %s: %w", this.socket, err))
+	}
+	success := false
+	defer func() {
+		if !success {
+			common.IgnoreCloseError(conn)
+		}
+	}()
+
+	if err := req.Write(conn); err != nil {
+		return fail(errors.Network.Newf("%s %s failed: %w", req.Method, req.URL.Path, err))
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return fail(errors.Network.Newf("%s %s failed: %w", req.Method, req.URL.Path, err))
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusSwitchingProtocols {
+		defer common.IgnoreCloseError(resp.Body)
+		return fail(newPodmanApiError(req, resp))
+	}
+
+	success = true
+	return &podmanHijackedConn{conn, br}, nil
+}
+
+func (this *podmanApiClient) ExecResize(ctx context.Context, execId string, width, height uint) error {
+	return this.doJson(ctx, http.MethodPost, "/exec/"+url.PathEscape(execId)+"/resize", url.Values{
+		"w": {strconv.FormatUint(uint64(width), 10)},
+		"h": {strconv.FormatUint(uint64(height), 10)},
+	}, nil, nil, http.StatusOK, http.StatusCreated)
+}
+
+func (this *podmanApiClient) ExecInspect(ctx context.Context, execId string) (*podmanExecInspect, error) {
+	var result podmanExecInspect
+	if err := this.doJson(ctx, http.MethodGet, "/exec/"+url.PathEscape(execId)+"/json", nil, nil, &result, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+type podmanHijackedConn struct {
+	gonet.Conn
+	reader *bufio.Reader
+}
+
+func (this *podmanHijackedConn) Read(p []byte) (int, error) {
+	return this.reader.Read(p)
+}
+
+func (this *podmanHijackedConn) CloseWrite() error {
+	if cw, ok := this.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+type podmanApiError struct {
+	StatusCode int
+	Message    string
+}
+
+func newPodmanApiError(req *http.Request, resp *http.Response) *podmanApiError {
+	var payload struct {
+		Cause   string `json:"cause"`
+		Message string `json:"message"`
+	}
+	result := podmanApiError{StatusCode: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err == nil && payload.Message != "" {
+		result.Message = payload.Message
+	} else {
+		result.Message = fmt.Sprintf("%s %s: unexpected status %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	return &result
+}
+
+func (this *podmanApiError) Error() string {
+	return this.Message
+}
+
+func isPodmanNotFoundError(err error) bool {
+	var pae *podmanApiError
+	return errors.As(err, &pae) && pae.StatusCode == http.StatusNotFound
+}
+
+type podmanInfo struct {
+	Host struct {
+		Arch     string `json:"arch"`
+		Os       string `json:"os"`
+		Security struct {
+			Rootless bool `json:"rootless"`
+		} `json:"security"`
+	} `json:"host"`
+	Version struct {
+		Version string `json:"Version"`
+	} `json:"version"`
+}
+
+type podmanIdResponse struct {
+	Id string `json:"Id"`
+}
+
+type podmanContainer struct {
+	Id       string              `json:"Id"`
+	Names    []string            `json:"Names"`
+	Image    string              `json:"Image"`
+	Labels   map[string]string   `json:"Labels"`
+	State    string              `json:"State"`
+	Exited   bool                `json:"Exited"`
+	ExitCode int                 `json:"ExitCode"`
+	Pod      string              `json:"Pod"`
+	PodName  string              `json:"PodName"`
+	Ports    []podmanPortMapping `json:"Ports"`
+}
+
+type podmanContainerInspect struct {
+	Id              string `json:"Id"`
+	NetworkSettings struct {
+		IPAddress string `json:"IPAddress"`
+		Networks  map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+type podmanPortMapping struct {
+	HostIp        string `json:"host_ip,omitempty"`
+	ContainerPort uint16 `json:"container_port"`
+	HostPort      uint16 `json:"host_port"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+type podmanMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type podmanNamedVolume struct {
+	Name    string   `json:"Name"`
+	Dest    string   `json:"Dest"`
+	Options []string `json:"Options,omitempty"`
+}
+
+type podmanNetworkOptions struct{}
+
+// podmanContainerSpec is the subset of the SpecGenerator of libpod used to
+// create containers.
+type podmanContainerSpec struct {
+	Name         string                          `json:"name,omitempty"`
+	Image        string                          `json:"image"`
+	Pod          string                          `json:"pod,omitempty"`
+	Labels       map[string]string               `json:"labels,omitempty"`
+	Entrypoint   []string                        `json:"entrypoint,omitempty"`
+	Command      []string                        `json:"command,omitempty"`
+	Env          map[string]string               `json:"env,omitempty"`
+	User         string                          `json:"user,omitempty"`
+	Remove       bool                            `json:"remove,omitempty"`
+	Mounts       []podmanMount                   `json:"mounts,omitempty"`
+	Volumes      []podmanNamedVolume             `json:"volumes,omitempty"`
+	CapAdd       []string                        `json:"cap_add,omitempty"`
+	Privileged   bool                            `json:"privileged,omitempty"`
+	DnsServer    []string                        `json:"dns_server,omitempty"`
+	DnsSearch    []string                        `json:"dns_search,omitempty"`
+	Networks     map[string]podmanNetworkOptions `json:"Networks,omitempty"`
+	PortMappings []podmanPortMapping             `json:"portmappings,omitempty"`
+}
+
+// podmanPodSpec is the subset of the PodSpecGenerator of libpod used to
+// create pods. Containers inside a pod are sharing its network, therefore
+// all network related settings are defined here instead of on the
+// container.
+type podmanPodSpec struct {
+	Name         string                          `json:"name"`
+	Labels       map[string]string               `json:"labels,omitempty"`
+	DnsServer    []string                        `json:"dns_server,omitempty"`
+	DnsSearch    []string                        `json:"dns_search,omitempty"`
+	Networks     map[string]podmanNetworkOptions `json:"Networks,omitempty"`
+	PortMappings []podmanPortMapping             `json:"portmappings,omitempty"`
+}
+
+type podmanPod struct {
+	Id         string            `json:"Id"`
+	Name       string            `json:"Name"`
+	Labels     map[string]string `json:"Labels"`
+	InfraId    string            `json:"InfraId"`
+	Containers []struct {
+		Id string `json:"Id"`
+	} `json:"Containers"`
+}
+
+type podmanPodInspect struct {
+	Id               string `json:"Id"`
+	Name             string `json:"Name"`
+	InfraContainerId string `json:"InfraContainerID"`
+}
+
+type podmanExecConfig struct {
+	AttachStdin  bool     `json:"AttachStdin"`
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+	Cmd          []string `json:"Cmd"`
+	Env          []string `json:"Env,omitempty"`
+	Tty          bool     `json:"Tty"`
+	User         string   `json:"User,omitempty"`
+	WorkingDir   string   `json:"WorkingDir,omitempty"`
+}
+
+type podmanExecStart struct {
+	Detach bool `json:"Detach"`
+	Tty    bool `json:"Tty"`
+	Height uint `json:"h,omitempty"`
+	Width  uint `json:"w,omitempty"`
+}
+
+type podmanExecInspect struct {
+	ExitCode int  `json:"ExitCode"`
+	Running  bool `json:"Running"`
+}
+
+// podmanImagePullReport is a single entry of the stream which is returned
+// while pulling an image.
+type podmanImagePullReport struct {
+	Stream string   `json:"stream,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Images []string `json:"images,omitempty"`
+	Id     string   `json:"id,omitempty"`
+}
+
+func (this podmanImagePullReport) message() string {
+	return strings.TrimSpace(this.Stream)
+}
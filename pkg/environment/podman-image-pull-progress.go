@@ -0,0 +1,97 @@
+package environment
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	log "github.com/echocat/slf4g"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+// handlePodmanImagePullProgress consumes the stream of podmanImagePullReport
+// and reports the progress to the given target. In contrast to the Docker
+// API, Podman does not report the downloaded bytes of each layer. Therefore,
+// the progress is estimated based on the copied blobs (layers) until the
+// config of the image is copied.
+func handlePodmanImagePullProgress(reader io.Reader, target PreparationProgress, logger log.Logger) error {
+	const (
+		progressBlobs    = float32(0.9)
+		progressManifest = float32(0.95)
+	)
+
+	blobs := map[string]bool{}
+	calculateBlobsProgress := func() float32 {
+		if len(blobs) == 0 {
+			return 0.0
+		}
+		var complete int
+		for _, v := range blobs {
+			if v {
+				complete++
+			}
+		}
+		return progressBlobs * float32(complete) / float32(len(blobs))
+	}
+
+	var progress float32 = 0.0
+	finished := false
+
+	dec := json.NewDecoder(reader)
+	for {
+		var report podmanImagePullReport
+		if err := dec.Decode(&report); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if v := report.Error; v != "" {
+			return errors.System.Newf("%s", v)
+		}
+
+		progressCandidate := progress
+		msg := report.message()
+		if len(report.Images) > 0 || report.Id != "" {
+			finished = true
+		} else if rest, ok := strings.CutPrefix(msg, "Copying blob "); ok {
+			id, state, _ := strings.Cut(rest, " ")
+			blobs[id] = blobs[id] || state == "done" || strings.HasPrefix(state, "skipped")
+			progressCandidate = calculateBlobsProgress()
+		} else if strings.HasPrefix(msg, "Copying config ") {
+			progressCandidate = progressBlobs
+		} else if strings.HasPrefix(msg, "Writing manifest ") || strings.HasPrefix(msg, "Storing signatures") {
+			progressCandidate = progressManifest
+		} else if msg == "" ||
+			strings.HasPrefix(msg, "Trying to pull ") ||
+			strings.HasPrefix(msg, "Resolving ") ||
+			strings.HasPrefix(msg, "Getting image source signatures") {
+			// Ignore, usually the start
+		} else {
+			logger.With("payload", report).
+				Warn("received an unknown payload from podman service while pulling image")
+		}
+
+		if progressCandidate > progress {
+			progress = progressCandidate
+			if err := target.Report(progress); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !finished {
+		return io.ErrUnexpectedEOF
+	}
+
+	return target.Done()
+}
+
+// noopPreparationProgress is used if the request does not support
+// PreparationProgress, but the progress has to be consumed anyway.
+type noopPreparationProgress struct{}
+
+func (noopPreparationProgress) Report(float32) error { return nil }
+func (noopPreparationProgress) Done() error          { return nil }
+func (noopPreparationProgress) Error(error) error    { return nil }
@@ -0,0 +1,935 @@
+package environment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	gonet "net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/echocat/slf4g"
+	"github.com/echocat/slf4g/level"
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/alternatives"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+var (
+	_ = RegisterRepository(NewPodmanRepository)
+
+	// podmanRootlessPublishHost is used to publish the port of the imp if
+	// the Podman service runs rootless and EnvironmentPodman.ImpPublishHost
+	// is not set. In this case the container networks are not reachable
+	// from the host.
+	podmanRootlessPublishHost = net.MustNewHost("127.0.0.1")
+)
+
+type PodmanRepository struct {
+	flow         configuration.FlowName
+	conf         *configuration.EnvironmentPodman
+	alternatives alternatives.Provider
+	imp          imp.Imp
+
+	apiClient   *podmanApiClient
+	hostOs      sys.Os
+	hostArch    sys.Arch
+	hostVersion string
+	rootless    bool
+	publishHost net.Host
+
+	Logger              log.Logger
+	defaultLogLevelName string
+
+	sessionIdMutex  common.KeyedMutex[session.Id]
+	podNameMutex    common.KeyedMutex[string]
+	activeInstances sync.Map
+
+	rawDialer gonet.Dialer
+}
+
+func NewPodmanRepository(ctx context.Context, flow configuration.FlowName, conf *configuration.EnvironmentPodman, ap alternatives.Provider, i imp.Imp) (*PodmanRepository, error) {
+	fail := func(err error) (*PodmanRepository, error) {
+		return nil, err
+	}
+	failf := func(msg string, args ...any) (*PodmanRepository, error) {
+		return fail(fmt.Errorf(msg, args...))
+	}
+
+	if conf == nil {
+		return failf("nil configuration")
+	}
+
+	apiClient, err := newPodmanApiClient(conf)
+	if err != nil {
+		return fail(err)
+	}
+
+	info, err := apiClient.Info(ctx)
+	if err != nil {
+		return failf("cannot retrieve podman host's info: %w", err)
+	}
+
+	result := PodmanRepository{
+		flow:         flow,
+		conf:         conf,
+		alternatives: ap,
+		imp:          i,
+		apiClient:    apiClient,
+		hostVersion:  info.Version.Version,
+		rootless:     info.Host.Security.Rootless,
+		publishHost:  conf.ImpPublishHost.Clone(),
+	}
+
+	if err = result.hostOs.SetOci(info.Host.Os); err != nil {
+		return failf("cannot parse podman host's os: %w", err)
+	}
+	if err = result.hostArch.SetOci(info.Host.Arch); err != nil {
+		return failf("cannot parse podman host's arch: %w", err)
+	}
+	if result.publishHost.IsZero() && result.rootless {
+		result.publishHost = podmanRootlessPublishHost.Clone()
+	}
+
+	lp := result.logger().GetProvider()
+	if la, ok := lp.(level.Aware); ok {
+		if lna, ok := lp.(level.NamesAware); ok {
+			lvl := la.GetLevel()
+			if result.defaultLogLevelName, err = lna.GetLevelNames().ToName(lvl); err != nil {
+				return failf("cannot transform to name of level %v: %w", lvl, err)
+			}
+		}
+	}
+
+	return &result, nil
+}
+
+func (this *PodmanRepository) WillBeAccepted(ctx Context) (ok bool, err error) {
+	fail := func(err error) (bool, error) {
+		return false, err
+	}
+
+	if ok, err = this.conf.LoginAllowed.Render(ctx); err != nil {
+		return fail(fmt.Errorf("cannot evaluate if user is allowed to login or not: %w", err))
+	}
+
+	return ok, nil
+}
+
+func (this *PodmanRepository) DoesSupportPty(Context, glssh.Pty) (bool, error) {
+	return true, nil
+}
+
+func (this *PodmanRepository) Ensure(req Request) (Environment, error) {
+	fail := func(err error) (Environment, error) {
+		return nil, err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (Environment, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	if ok, err := this.WillBeAccepted(req); err != nil {
+		return fail(err)
+	} else if !ok {
+		return fail(ErrNotAcceptable)
+	}
+
+	sess := req.Authorization().FindSession()
+	if sess == nil {
+		return failf(errors.System, "authorization without session")
+	}
+
+	return this.findOrEnsureBySession(req.Context(), sess, nil, req, true)
+}
+
+func (this *PodmanRepository) createContainerBy(req Request, sess session.Session) (*podmanContainer, error) {
+	fail := func(err error) (*podmanContainer, error) {
+		return nil, err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (*podmanContainer, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	spec, err := this.resolveContainerSpec(req, sess)
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := this.ensureImage(req, spec.Image); err != nil {
+		return fail(err)
+	}
+
+	success := false
+	if podName, err := this.conf.Pod.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate pod: %w", err)
+	} else if podName != "" {
+		// Several sessions can share one pod. Therefore, the pod must not be
+		// removed by cleanupPods until the container of this session is
+		// created inside it.
+		defer this.podNameMutex.Lock(podName)()
+
+		created, err := this.ensurePod(req, sess, podName, spec)
+		if err != nil {
+			return fail(err)
+		}
+		if created {
+			defer func() {
+				if !success {
+					if err := this.apiClient.PodRemove(req.Context(), podName); err != nil && !isPodmanNotFoundError(err) {
+						req.Connection().Logger().
+							WithError(err).
+							Warn("cannot remove orphan pod within emergency cleanup; pod could still be there")
+					}
+				}
+			}()
+		}
+	}
+
+	containerId, err := this.apiClient.ContainerCreate(req.Context(), spec)
+	if err != nil {
+		return failf(errors.System, "cannot create container: %w", err)
+	}
+	defer func() {
+		if !success {
+			if _, err := this.removeContainer(req.Context(), containerId); err != nil {
+				req.Connection().Logger().
+					WithError(err).
+					Warn("cannot remove orphan container within emergency cleanup; container could still be there")
+			}
+		}
+	}()
+
+	if err := this.apiClient.ContainerStart(req.Context(), containerId); err != nil {
+		return failf(errors.System, "cannot start container #%s: %w", containerId, err)
+	}
+	c, _, err := this.findContainerById(req.Context(), containerId)
+	if err != nil {
+		return fail(err)
+	}
+	if c == nil {
+		return failf(errors.System, "container %v died instant after initial start", containerId)
+	}
+
+	success = true
+	return c, nil
+}
+
+func (this *PodmanRepository) ensureImage(req Request, ref string) error {
+	switch this.conf.ImagePullPolicy {
+	case configuration.PullPolicyNever:
+		return nil
+	case configuration.PullPolicyIfAbsent:
+		if exists, err := this.apiClient.ImageExists(req.Context(), ref); err != nil {
+			return errors.System.Newf("cannot check if container image %s exists: %w", ref, err)
+		} else if exists {
+			return nil
+		}
+	}
+
+	if err := this.pullImage(req, ref); err != nil {
+		return errors.System.Newf("cannot pull container image %s: %w", ref, err)
+	}
+	return nil
+}
+
+func (this *PodmanRepository) pullImage(req Request, ref string) error {
+	var progress PreparationProgress
+	fail := func(err error) error {
+		if progress != nil {
+			_ = progress.Error(err)
+		}
+		return err
+	}
+
+	var err error
+	progress, err = req.StartPreparation(
+		"pull-image",
+		fmt.Sprintf("Pulling image %s", ref),
+		PreparationProgressAttributes{
+			"image": ref,
+		},
+	)
+	if err != nil {
+		return fail(err)
+	}
+
+	auth, err := this.resolvePullCredentials(req)
+	if err != nil {
+		return fail(err)
+	}
+
+	rc, err := this.apiClient.ImagePull(req.Context(), ref, auth)
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseError(rc)
+
+	if progress == nil {
+		progress = noopPreparationProgress{}
+	}
+
+	l := req.Connection().Logger().
+		With("image", ref)
+	if err := handlePodmanImagePullProgress(rc, progress, l); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+func (this *PodmanRepository) resolvePullCredentials(req Request) (string, error) {
+	fail := func(err error) (string, error) {
+		return "", errors.Config.Newf("cannot resolve image pull credentials: %w", err)
+	}
+
+	plain, err := this.conf.ImagePullCredentials.Render(req)
+	if err != nil {
+		return fail(err)
+	}
+
+	result, err := encodeRegistryAuth(plain)
+	if err != nil {
+		return fail(err)
+	}
+	return result, nil
+}
+
+// ensurePod ensures that the pod with the given name exists and moves all
+// network related settings of the given spec to the pod, because these are
+// shared by all containers of a pod. It returns true if the pod was created.
+func (this *PodmanRepository) ensurePod(req Request, sess session.Session, name string, spec *podmanContainerSpec) (bool, error) {
+	fail := func(err error) (bool, error) {
+		return false, err
+	}
+	failf := func(msg string, args ...any) (bool, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	podSpec := podmanPodSpec{
+		Name: name,
+		Labels: map[string]string{
+			DockerLabelFlow:      this.flow.String(),
+			DockerLabelSessionId: sess.Id().String(),
+		},
+		DnsServer:    spec.DnsServer,
+		DnsSearch:    spec.DnsSearch,
+		Networks:     spec.Networks,
+		PortMappings: spec.PortMappings,
+	}
+	spec.Pod = name
+	spec.DnsServer = nil
+	spec.DnsSearch = nil
+	spec.Networks = nil
+	spec.PortMappings = nil
+
+	if exists, err := this.apiClient.PodExists(req.Context(), name); err != nil {
+		return failf("cannot check if pod %s exists: %w", name, err)
+	} else if exists {
+		return false, nil
+	}
+
+	if _, err := this.apiClient.PodCreate(req.Context(), &podSpec); err != nil {
+		return failf("cannot create pod %s: %w", name, err)
+	}
+	return true, nil
+}
+
+func (this *PodmanRepository) resolveContainerSpec(req Request, sess session.Session) (_ *podmanContainerSpec, err error) {
+	fail := func(err error) (*podmanContainerSpec, error) {
+		return nil, err
+	}
+	failf := func(msg string, args ...any) (*podmanContainerSpec, error) {
+		return fail(errors.Config.Newf(msg, args...))
+	}
+
+	var result podmanContainerSpec
+
+	remote := req.Connection().Remote()
+	result.Labels = map[string]string{
+		DockerLabelFlow:      this.flow.String(),
+		DockerLabelSessionId: sess.Id().String(),
+
+		DockerLabelCreatedRemoteUser: remote.User(),
+		DockerLabelCreatedRemoteHost: remote.Host().String(),
+	}
+	if result.Labels[DockerLabelShellCommand], err = this.resolveEncodedShellCommand(req); err != nil {
+		return fail(err)
+	}
+	if result.Labels[DockerLabelExecCommand], err = this.resolveEncodedExecCommand(req); err != nil {
+		return fail(err)
+	}
+	if result.Labels[DockerLabelSftpCommand], err = this.resolveEncodedSftpCommand(req); err != nil {
+		return fail(err)
+	}
+	if result.Labels[DockerLabelUser], err = this.conf.User.Render(req); err != nil {
+		return failf("cannot evaluate user: %w", err)
+	}
+	if result.Labels[DockerLabelDirectory], err = this.conf.Directory.Render(req); err != nil {
+		return failf("cannot evaluate directory: %w", err)
+	}
+	if v, err := this.conf.PortForwardingAllowed.Render(req); err != nil {
+		return failf("cannot evaluate portForwardingAllowed: %w", err)
+	} else if v {
+		result.Labels[DockerLabelPortForwardingAllowed] = "true"
+	}
+	if v, err := this.conf.Terminals.Persistent.Render(req); err != nil {
+		return failf("cannot evaluate terminals.persistent: %w", err)
+	} else if v {
+		result.Labels[DockerLabelTerminalsPersistent] = "true"
+	}
+
+	if result.Image, err = this.conf.Image.Render(req); err != nil {
+		return failf("cannot evaluate image: %w", err)
+	}
+	targetPath := sys.BifroestBinaryFileLocation(this.hostOs)
+	if targetPath == "" {
+		return failf("cannot resolve target path for host %s/%s", this.hostOs, this.hostArch)
+	}
+	result.Entrypoint = []string{targetPath}
+	result.Command = []string{`imp`, `--log.colorMode=always`}
+	if this.defaultLogLevelName != "" {
+		result.Command = append(result.Command, `--log.level=`+this.defaultLogLevelName)
+	}
+	if this.hostOs == sys.OsLinux {
+		result.User = "root"
+	}
+	result.Remove = true
+
+	masterPub, err := this.imp.GetMasterPublicKey()
+	if err != nil {
+		return fail(err)
+	}
+	result.Env = map[string]string{
+		imp.EnvVarMasterPublicKey: base64.RawStdEncoding.EncodeToString(masterPub.Marshal()),
+		session.EnvName:           sess.Id().String(),
+	}
+
+	if raws, err := this.conf.Volumes.Render(req); err != nil {
+		return failf("cannot evaluate volumes: %w", err)
+	} else {
+		for i, raw := range raws {
+			if err := result.addVolume(raw); err != nil {
+				return failf("cannot evaluate volume %d: %w", i, err)
+			}
+		}
+	}
+	if result.CapAdd, err = this.conf.Capabilities.Render(req); err != nil {
+		return failf("cannot evaluate capabilities: %w", err)
+	}
+	if result.Privileged, err = this.conf.Privileged.Render(req); err != nil {
+		return failf("cannot evaluate privileged: %w", err)
+	}
+	if result.DnsServer, err = this.conf.DnsServers.Render(req); err != nil {
+		return failf("cannot evaluate dnsServers: %w", err)
+	}
+	if result.DnsSearch, err = this.conf.DnsSearch.Render(req); err != nil {
+		return failf("cannot evaluate dnsSearch: %w", err)
+	}
+	if vs, err := this.conf.Networks.Render(req); err != nil {
+		return failf("cannot evaluate networks: %w", err)
+	} else if len(vs) > 0 {
+		result.Networks = make(map[string]podmanNetworkOptions, len(vs))
+		for _, v := range vs {
+			result.Networks[v] = podmanNetworkOptions{}
+		}
+	}
+
+	if !this.publishHost.IsZero() {
+		pm := podmanPortMapping{
+			ContainerPort: imp.ServicePort,
+			Protocol:      "tcp",
+		}
+		if ip := this.publishHost.IP; len(ip) > 0 {
+			pm.HostIp = ip.String()
+		}
+		result.PortMappings = []podmanPortMapping{pm}
+	}
+
+	impBinaryPath, err := this.alternatives.FindBinaryFor(req.Context(), this.hostOs, this.hostArch)
+	if err != nil {
+		return failf("cannot resolve imp binary path: %w", err)
+	}
+	if impBinaryPath != "" {
+		impBinaryPath, err = filepath.Abs(impBinaryPath)
+		if err != nil {
+			return failf("cannot resolve full imp binary path: %w", err)
+		}
+		result.Mounts = append(result.Mounts, podmanMount{
+			Type:        "bind",
+			Source:      impBinaryPath,
+			Destination: targetPath,
+			Options:     []string{"ro"},
+		})
+	}
+
+	return &result, nil
+}
+
+// addVolume adds the given volume in format <source>:<target>[:<options>]
+// (like the -v flag of Podman) to this spec. If source is a path, a bind
+// mount is added, otherwise a named volume.
+func (this *podmanContainerSpec) addVolume(plain string) error {
+	parts := strings.Split(plain, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("illegal volume %q; expected format <source>:<target>[:<options>]", plain)
+	}
+	var options []string
+	if len(parts) == 3 && parts[2] != "" {
+		options = strings.Split(parts[2], ",")
+	}
+	if strings.HasPrefix(parts[0], "/") || strings.HasPrefix(parts[0], ".") {
+		this.Mounts = append(this.Mounts, podmanMount{
+			Type:        "bind",
+			Source:      parts[0],
+			Destination: parts[1],
+			Options:     options,
+		})
+	} else {
+		this.Volumes = append(this.Volumes, podmanNamedVolume{
+			Name:    parts[0],
+			Dest:    parts[1],
+			Options: options,
+		})
+	}
+	return nil
+}
+
+func (this *PodmanRepository) resolveEncodedShellCommand(req Request) (string, error) {
+	failf := func(msg string, args ...any) (string, error) {
+		return "", errors.Config.Newf(msg, args...)
+	}
+
+	v, err := this.conf.ShellCommand.Render(req)
+	if err != nil {
+		return failf("cannot evaluate shellCommand: %w", err)
+	}
+	if len(v) == 0 {
+		switch this.hostOs {
+		case sys.OsLinux:
+			v = []string{`/bin/sh`}
+		default:
+			return failf("shellCommand was not defined for podman environment and default cannot be resolved for %s/%s", this.hostOs, this.hostArch)
+		}
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (this *PodmanRepository) resolveEncodedExecCommand(req Request) (string, error) {
+	failf := func(msg string, args ...any) (string, error) {
+		return "", errors.Config.Newf(msg, args...)
+	}
+
+	v, err := this.conf.ExecCommand.Render(req)
+	if err != nil {
+		return failf("cannot evaluate execCommand: %w", err)
+	}
+	if len(v) == 0 {
+		switch this.hostOs {
+		case sys.OsLinux:
+			v = []string{`/bin/sh`, `-c`}
+		default:
+			return failf("execCommand was not defined for podman environment and default cannot be resolved for %s/%s", this.hostOs, this.hostArch)
+		}
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (this *PodmanRepository) resolveEncodedSftpCommand(req Request) (string, error) {
+	failf := func(msg string, args ...any) (string, error) {
+		return "", errors.Config.Newf(msg, args...)
+	}
+
+	v, err := this.conf.SftpCommand.Render(req)
+	if err != nil {
+		return failf("cannot evaluate sftpCommand: %w", err)
+	}
+	if len(v) == 0 {
+		v = []string{sys.BifroestBinaryFileLocation(this.hostOs), `sftp-server`}
+		if len(v[0]) == 0 {
+			return failf("sftpCommand was not defined for podman environment and default cannot be resolved for %s/%s", this.hostOs, this.hostArch)
+		}
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (this *PodmanRepository) FindBySession(ctx context.Context, sess session.Session, opts *FindOpts) (Environment, error) {
+	return this.findOrEnsureBySession(ctx, sess, opts, nil, false)
+}
+
+func (this *PodmanRepository) findOrEnsureBySession(ctx context.Context, sess session.Session, opts *FindOpts, createUsing Request, retryAllowed bool) (Environment, error) {
+	fail := func(err error) (Environment, error) {
+		return nil, err
+	}
+
+	sessId := sess.Id()
+	rUnlocker := this.sessionIdMutex.RLock(sessId)
+	rUnlock := func() {
+		if rUnlocker != nil {
+			rUnlocker()
+		}
+		rUnlocker = nil
+	}
+	defer rUnlock()
+
+	ip, ok := this.activeInstances.Load(sessId)
+	if ok {
+		instance := ip.(*podman)
+		instance.owners.Add(1)
+		return instance, nil
+	}
+
+	c, exitCode, err := this.findContainerBySession(ctx, sess)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil && createUsing == nil {
+		return fail(ErrNoSuchEnvironment)
+	}
+	rUnlock()
+
+	defer this.sessionIdMutex.Lock(sessId)()
+
+	ip, ok = this.activeInstances.Load(sessId)
+	if ok {
+		instance := ip.(*podman)
+		instance.owners.Add(1)
+		return instance, nil
+	}
+
+	if c != nil && exitCode >= 0 {
+		if opts.IsAutoCleanUpAllowed() {
+			if _, err := this.removeContainer(ctx, c.Id); err != nil {
+				return fail(err)
+			}
+		}
+		if createUsing == nil {
+			return fail(ErrNoSuchEnvironment)
+		}
+		c = nil
+	}
+
+	if c == nil {
+		c, err = this.createContainerBy(createUsing, sess)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	logger := this.logger().
+		With("containerId", c.Id).
+		With("sessionId", sessId)
+
+	removeContainerUnchecked := func() {
+		if _, err := this.removeContainer(ctx, c.Id); err != nil {
+			logger.
+				WithError(err).
+				Warnf("cannot remove broken container; need to be done manually")
+		}
+	}
+
+	instance, err := this.new(ctx, c, logger)
+	if err != nil {
+		if errors.Is(err, containerContainsProblemsErr) {
+			if createUsing != nil {
+				removeContainerUnchecked()
+				if !retryAllowed {
+					return fail(err)
+				}
+				return this.findOrEnsureBySession(ctx, sess, opts, createUsing, false)
+			} else if opts.IsAutoCleanUpAllowed() {
+				removeContainerUnchecked()
+				return fail(ErrNoSuchEnvironment)
+			}
+		}
+		return fail(err)
+	}
+
+	this.activeInstances.Store(sessId, instance)
+
+	return instance, nil
+}
+
+func (this *PodmanRepository) removeContainer(ctx context.Context, id string) (bool, error) {
+	if err := this.apiClient.ContainerRemove(ctx, id); isPodmanNotFoundError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.System.Newf("cannot remove container #%s: %w", id, err)
+	}
+	return true, nil
+}
+
+// removePodIfEmpty removes the pod with the given id if it does not contain
+// any other container than its infra container.
+func (this *PodmanRepository) removePodIfEmpty(ctx context.Context, id string) (bool, error) {
+	fail := func(err error) (bool, error) {
+		return false, errors.System.Newf("cannot remove pod #%s: %w", id, err)
+	}
+
+	pods, err := this.apiClient.PodList(ctx, map[string][]string{
+		"id": {id},
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if len(pods) == 0 || !pods[0].isEmpty() {
+		return false, nil
+	}
+
+	if err := this.apiClient.PodRemove(ctx, id); isPodmanNotFoundError(err) {
+		return false, nil
+	} else if err != nil {
+		return fail(err)
+	}
+	return true, nil
+}
+
+func (this *PodmanRepository) findContainerBySession(ctx context.Context, sess session.Session) (c *podmanContainer, exitCode int, err error) {
+	return this.findContainerBy(ctx, map[string][]string{
+		"label": {DockerLabelSessionId + "=" + sess.Id().String()},
+	})
+}
+
+func (this *PodmanRepository) findContainerById(ctx context.Context, id string) (c *podmanContainer, exitCode int, err error) {
+	return this.findContainerBy(ctx, map[string][]string{
+		"id": {id},
+	})
+}
+
+func (this *PodmanRepository) findContainerBy(ctx context.Context, filters map[string][]string) (c *podmanContainer, exitCode int, err error) {
+	list, err := this.apiClient.ContainerList(ctx, filters)
+	if err != nil {
+		return nil, -1, errors.System.Newf("cannot list container by %v: %w", filters, err)
+	}
+	if len(list) == 0 {
+		return nil, -1, nil
+	}
+
+	c = &list[0]
+	exitCode = -1
+	if c.Exited || c.State == "exited" || c.State == "stopped" {
+		exitCode = c.ExitCode
+	}
+
+	return c, exitCode, nil
+}
+
+func (this *PodmanRepository) Close() error {
+	return nil
+}
+
+func (this *PodmanRepository) Cleanup(ctx context.Context, opts *CleanupOpts) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot cleanup potential orphan podman containers: %w", err)
+	}
+
+	list, err := this.apiClient.ContainerList(ctx, map[string][]string{
+		"label": {DockerLabelFlow},
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	l := opts.GetLogger(this.logger)
+
+	for _, c := range list {
+		cl := l.With("containerId", c.Id)
+		if ns := c.Names; len(ns) > 0 {
+			cl = cl.With("containerName", ns[0])
+		}
+
+		if ok, err := this.isOrphan(c.Labels, podmanContainerContext{&c}, cl, opts); err != nil {
+			return fail(err)
+		} else if !ok {
+			continue
+		}
+
+		if ok, err := this.removeContainer(ctx, c.Id); err != nil {
+			cl.WithError(err).
+				Warn("cannot remove orphan container; this message might continue appearing until manually fixed; skipping...")
+			continue
+		} else if ok {
+			cl.Info("orphan container removed")
+		}
+	}
+
+	if err := this.cleanupPods(ctx, opts, l); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+func (this *PodmanRepository) cleanupPods(ctx context.Context, opts *CleanupOpts, l log.Logger) error {
+	pods, err := this.apiClient.PodList(ctx, map[string][]string{
+		"label": {DockerLabelFlow},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pods {
+		pl := l.With("podId", p.Id).
+			With("podName", p.Name)
+
+		var sessId session.Id
+		if err := sessId.UnmarshalText([]byte(p.Labels[DockerLabelSessionId])); err != nil || sessId.IsZero() {
+			pl.WithError(err).
+				Warnf("pod does have an illegal %v label; this warn message will appear again until this is fixed; skipping...", DockerLabelSessionId)
+			continue
+		}
+
+		if p.Labels[DockerLabelFlow] == this.flow.String() {
+			// The pod might be just created or reused for a new container
+			// of any session, therefore we have to ensure that nobody is
+			// working on it.
+			unlock := this.podNameMutex.Lock(p.Name)
+			ok, err := this.removePodIfEmpty(ctx, p.Id)
+			unlock()
+			if err != nil {
+				pl.WithError(err).
+					Warn("cannot remove empty pod; this message might continue appearing until manually fixed; skipping...")
+			} else if ok {
+				pl.Info("empty pod removed")
+			}
+			continue
+		}
+
+		if ok, err := this.isOrphan(p.Labels, podmanPodContext{&p}, pl, opts); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		if err := this.apiClient.PodRemove(ctx, p.Id); isPodmanNotFoundError(err) {
+			// Ok.
+		} else if err != nil {
+			pl.WithError(err).
+				Warn("cannot remove orphan pod; this message might continue appearing until manually fixed; skipping...")
+		} else {
+			pl.Info("orphan pod removed")
+		}
+	}
+
+	return nil
+}
+
+func (this *PodmanRepository) isOrphan(labels map[string]string, context any, l log.Logger, opts *CleanupOpts) (bool, error) {
+	var flow configuration.FlowName
+	if err := flow.Set(labels[DockerLabelFlow]); err != nil || flow.IsZero() {
+		l.WithError(err).
+			Warnf("resource does have an illegal %v label; this warn message will appear again until this is fixed; skipping...", DockerLabelFlow)
+		return false, nil
+	}
+
+	l = l.With("flow", flow)
+
+	if flow.IsEqualTo(this.flow) {
+		l.Debug("found resource that is owned by this flow environment; ignoring...")
+		return false, nil
+	}
+
+	globalHasFlow, err := opts.HasFlowOfName(flow)
+	if err != nil {
+		return false, err
+	}
+
+	if globalHasFlow {
+		l.Debug("found resource that is owned by another environment; ignoring...")
+		return false, nil
+	}
+
+	shouldBeCleaned, err := this.conf.CleanOrphan.Render(context)
+	if err != nil {
+		return false, err
+	}
+
+	if !shouldBeCleaned {
+		l.Debug("found resource that isn't owned by anybody, but should be kept; ignoring...")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (this *PodmanRepository) logger() log.Logger {
+	if v := this.Logger; v != nil {
+		return v
+	}
+	return log.GetLogger("podman-repository")
+}
+
+func (this podmanPod) isEmpty() bool {
+	for _, c := range this.Containers {
+		if c.Id != this.InfraId {
+			return false
+		}
+	}
+	return true
+}
+
+type podmanContainerContext struct {
+	container *podmanContainer
+}
+
+func (this podmanContainerContext) GetField(name string) (any, bool, error) {
+	switch name {
+	case "id":
+		return this.container.Id, true, nil
+	case "image":
+		return this.container.Image, true, nil
+	case "name":
+		if len(this.container.Names) == 0 {
+			return nil, true, nil
+		}
+		return this.container.Names[0], true, nil
+	case "flow":
+		return podmanFlowOfLabels(this.container.Labels)
+	default:
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
+
+type podmanPodContext struct {
+	pod *podmanPod
+}
+
+func (this podmanPodContext) GetField(name string) (any, bool, error) {
+	switch name {
+	case "id":
+		return this.pod.Id, true, nil
+	case "image":
+		return nil, true, nil
+	case "name":
+		return this.pod.Name, true, nil
+	case "flow":
+		return podmanFlowOfLabels(this.pod.Labels)
+	default:
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
+
+func podmanFlowOfLabels(labels map[string]string) (any, bool, error) {
+	plain, ok := labels[DockerLabelFlow]
+	if !ok {
+		return nil, true, nil
+	}
+	var flow configuration.FlowName
+	if err := flow.Set(plain); err != nil {
+		return nil, false, err
+	}
+	if flow.IsZero() {
+		return nil, true, nil
+	}
+	return flow, true, nil
+}
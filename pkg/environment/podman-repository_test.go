@@ -0,0 +1,446 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	gonet "net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/echocat/slf4g/sdk/testlog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+// fakePodmanService serves a small subset of the libpod REST API over a unix
+// socket to test the podman client and repository without a real Podman.
+type fakePodmanService struct {
+	socket   string
+	rootless bool
+
+	mutex             sync.Mutex
+	containers        []podmanContainer
+	pods              []podmanPod
+	removedContainers []string
+	removedPods       []string
+	pullReports       []podmanImagePullReport
+}
+
+func newFakePodmanService(t *testing.T) *fakePodmanService {
+	result := &fakePodmanService{
+		socket: filepath.Join(t.TempDir(), "podman.sock"),
+	}
+
+	ln, err := gonet.Listen("unix", result.socket)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	prefix := "/" + podmanApiVersion + "/libpod"
+	mux.HandleFunc("GET "+prefix+"/info", result.handleInfo)
+	mux.HandleFunc("GET "+prefix+"/containers/json", result.handleContainerList)
+	mux.HandleFunc("DELETE "+prefix+"/containers/{id}", result.handleContainerRemove)
+	mux.HandleFunc("GET "+prefix+"/pods/json", result.handlePodList)
+	mux.HandleFunc("DELETE "+prefix+"/pods/{id}", result.handlePodRemove)
+	mux.HandleFunc("GET "+prefix+"/images/{name}/exists", result.handleImageExists)
+	mux.HandleFunc("POST "+prefix+"/images/pull", result.handleImagePull)
+	mux.HandleFunc("POST "+prefix+"/exec/{id}/start", result.handleExecStart)
+
+	srv := http.Server{Handler: mux}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return result
+}
+
+func (this *fakePodmanService) writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (this *fakePodmanService) notFound(w http.ResponseWriter) {
+	this.writeJson(w, http.StatusNotFound, map[string]any{"message": "no such object", "response": http.StatusNotFound})
+}
+
+func (this *fakePodmanService) handleInfo(w http.ResponseWriter, _ *http.Request) {
+	var result podmanInfo
+	result.Host.Os = "linux"
+	result.Host.Arch = "amd64"
+	result.Host.Security.Rootless = this.rootless
+	result.Version.Version = "5.0.0"
+	this.writeJson(w, http.StatusOK, result)
+}
+
+func (this *fakePodmanService) matches(r *http.Request, id string, labels map[string]string) bool {
+	var filters map[string][]string
+	if v := r.URL.Query().Get("filters"); v != "" {
+		if err := json.Unmarshal([]byte(v), &filters); err != nil {
+			return false
+		}
+	}
+	for _, v := range filters["id"] {
+		if v != id {
+			return false
+		}
+	}
+	for _, v := range filters["label"] {
+		key, value, withValue := strings.Cut(v, "=")
+		actual, ok := labels[key]
+		if !ok || (withValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *fakePodmanService) handleContainerList(w http.ResponseWriter, r *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	result := []podmanContainer{}
+	for _, c := range this.containers {
+		if this.matches(r, c.Id, c.Labels) {
+			result = append(result, c)
+		}
+	}
+	this.writeJson(w, http.StatusOK, result)
+}
+
+func (this *fakePodmanService) handleContainerRemove(w http.ResponseWriter, r *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	id := r.PathValue("id")
+	i := slices.IndexFunc(this.containers, func(c podmanContainer) bool { return c.Id == id })
+	if i < 0 {
+		this.notFound(w)
+		return
+	}
+	this.containers = slices.Delete(this.containers, i, i+1)
+	this.removedContainers = append(this.removedContainers, id)
+	this.writeJson(w, http.StatusOK, []any{})
+}
+
+func (this *fakePodmanService) handlePodList(w http.ResponseWriter, r *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	result := []podmanPod{}
+	for _, p := range this.pods {
+		if this.matches(r, p.Id, p.Labels) {
+			result = append(result, p)
+		}
+	}
+	this.writeJson(w, http.StatusOK, result)
+}
+
+func (this *fakePodmanService) handlePodRemove(w http.ResponseWriter, r *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	id := r.PathValue("id")
+	i := slices.IndexFunc(this.pods, func(p podmanPod) bool { return p.Id == id || p.Name == id })
+	if i < 0 {
+		this.notFound(w)
+		return
+	}
+	this.pods = slices.Delete(this.pods, i, i+1)
+	this.removedPods = append(this.removedPods, id)
+	this.writeJson(w, http.StatusOK, map[string]any{"Id": id})
+}
+
+func (this *fakePodmanService) handleImageExists(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("name") != "alpine" {
+		this.notFound(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (this *fakePodmanService) handleImagePull(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("reference") == "" {
+		this.writeJson(w, http.StatusBadRequest, map[string]any{"message": "missing reference"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for _, report := range this.pullReports {
+		_ = enc.Encode(report)
+	}
+}
+
+// handleExecStart hijacks the connection and echoes everything back.
+func (this *fakePodmanService) handleExecStart(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("id") != "exec1" {
+		this.notFound(w)
+		return
+	}
+	_, _ = io.Copy(io.Discard, r.Body)
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer common.IgnoreCloseError(conn)
+	_, _ = buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	_ = buf.Flush()
+	_, _ = io.Copy(conn, buf)
+}
+
+func newTestPodmanRepository(t *testing.T, service *fakePodmanService) *PodmanRepository {
+	var conf configuration.EnvironmentPodman
+	require.NoError(t, conf.SetDefaults())
+	conf.Host = template.MustNewString("unix://" + service.socket)
+
+	result, err := NewPodmanRepository(context.Background(), "test", &conf, nil, nil)
+	require.NoError(t, err)
+	result.Logger = testlog.NewLogger(t)
+	return result
+}
+
+type recordingPreparationProgress struct {
+	reports []float32
+	done    bool
+	err     error
+}
+
+func (this *recordingPreparationProgress) Report(progress float32) error {
+	this.reports = append(this.reports, progress)
+	return nil
+}
+
+func (this *recordingPreparationProgress) Done() error {
+	this.done = true
+	return nil
+}
+
+func (this *recordingPreparationProgress) Error(err error) error {
+	this.err = err
+	return nil
+}
+
+func TestNewPodmanRepository(t *testing.T) {
+	testlog.Hook(t)
+
+	service := newFakePodmanService(t)
+
+	rootful := newTestPodmanRepository(t, service)
+	require.Equal(t, "linux", rootful.hostOs.String())
+	require.Equal(t, "5.0.0", rootful.hostVersion)
+	require.False(t, rootful.rootless)
+	require.True(t, rootful.publishHost.IsZero())
+
+	service.rootless = true
+	rootless := newTestPodmanRepository(t, service)
+	require.True(t, rootless.rootless)
+	require.Equal(t, "127.0.0.1", rootless.publishHost.String())
+}
+
+func TestPodmanRepository_findContainerBySession(t *testing.T) {
+	testlog.Hook(t)
+
+	service := newFakePodmanService(t)
+	instance := newTestPodmanRepository(t, service)
+
+	running := session.MustNewId()
+	exited := session.MustNewId()
+	service.containers = []podmanContainer{
+		{Id: "c1", State: "running", Labels: map[string]string{DockerLabelFlow: "test", DockerLabelSessionId: running.String()}},
+		{Id: "c2", State: "exited", Exited: true, ExitCode: 3, Labels: map[string]string{DockerLabelFlow: "test", DockerLabelSessionId: exited.String()}},
+	}
+
+	actual, exitCode, err := instance.findContainerBySession(context.Background(), &sessionWithId{id: running})
+	require.NoError(t, err)
+	require.NotNil(t, actual)
+	require.Equal(t, "c1", actual.Id)
+	require.Equal(t, -1, exitCode)
+
+	actual, exitCode, err = instance.findContainerBySession(context.Background(), &sessionWithId{id: exited})
+	require.NoError(t, err)
+	require.NotNil(t, actual)
+	require.Equal(t, "c2", actual.Id)
+	require.Equal(t, 3, exitCode)
+
+	actual, exitCode, err = instance.findContainerBySession(context.Background(), &sessionWithId{id: session.MustNewId()})
+	require.NoError(t, err)
+	require.Nil(t, actual)
+	require.Equal(t, -1, exitCode)
+}
+
+func TestPodmanRepository_Cleanup(t *testing.T) {
+	testlog.Hook(t)
+
+	service := newFakePodmanService(t)
+	instance := newTestPodmanRepository(t, service)
+
+	labels := func(flow string) map[string]string {
+		return map[string]string{DockerLabelFlow: flow, DockerLabelSessionId: session.MustNewId().String()}
+	}
+	service.containers = []podmanContainer{
+		{Id: "own", Labels: labels("test")},
+		{Id: "other", Labels: labels("other")},
+		{Id: "orphan", Labels: labels("gone")},
+		{Id: "foreign"},
+	}
+	service.pods = []podmanPod{
+		{Id: "ownEmpty", InfraId: "infra1", Labels: labels("test"), Containers: []struct {
+			Id string `json:"Id"`
+		}{{Id: "infra1"}}},
+		{Id: "ownInUse", InfraId: "infra2", Labels: labels("test"), Containers: []struct {
+			Id string `json:"Id"`
+		}{{Id: "infra2"}, {Id: "own"}}},
+		{Id: "otherPod", Labels: labels("other")},
+		{Id: "orphanPod", Labels: labels("gone")},
+	}
+
+	require.NoError(t, instance.Cleanup(context.Background(), &CleanupOpts{
+		FlowOfNamePredicate: func(name configuration.FlowName) (bool, error) {
+			return name == "other", nil
+		},
+	}))
+
+	require.Equal(t, []string{"orphan"}, service.removedContainers)
+	require.Equal(t, []string{"ownEmpty", "orphanPod"}, service.removedPods)
+}
+
+func TestPodmanRepository_Cleanup_waitsForPodInUse(t *testing.T) {
+	testlog.Hook(t)
+
+	service := newFakePodmanService(t)
+	instance := newTestPodmanRepository(t, service)
+
+	service.pods = []podmanPod{
+		{Id: "shared1", Name: "shared", InfraId: "infra1", Labels: map[string]string{DockerLabelFlow: "test", DockerLabelSessionId: session.MustNewId().String()}, Containers: []struct {
+			Id string `json:"Id"`
+		}{{Id: "infra1"}}},
+	}
+
+	// Simulates another session which is just creating its container in
+	// the pod.
+	unlock := instance.podNameMutex.Lock("shared")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- instance.Cleanup(context.Background(), &CleanupOpts{})
+	}()
+
+	select {
+	case err := <-done:
+		unlock()
+		t.Fatalf("cleanup finished while the pod was in use: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	service.mutex.Lock()
+	require.Empty(t, service.removedPods)
+	service.mutex.Unlock()
+
+	unlock()
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"shared1"}, service.removedPods)
+}
+
+func TestPodmanApiClient_ImageExists(t *testing.T) {
+	service := newFakePodmanService(t)
+	instance := newPodmanApiClientFor(service.socket)
+
+	actual, err := instance.ImageExists(context.Background(), "alpine")
+	require.NoError(t, err)
+	require.True(t, actual)
+
+	actual, err = instance.ImageExists(context.Background(), "ubuntu")
+	require.NoError(t, err)
+	require.False(t, actual)
+}
+
+func TestPodmanApiClient_ImagePull(t *testing.T) {
+	testlog.Hook(t)
+
+	service := newFakePodmanService(t)
+	service.pullReports = []podmanImagePullReport{
+		{Stream: "Trying to pull docker.io/library/alpine:latest...\n"},
+		{Stream: "Getting image source signatures\n"},
+		{Stream: "Copying blob sha256:aaa\n"},
+		{Stream: "Copying blob sha256:bbb\n"},
+		{Stream: "Copying blob sha256:aaa done\n"},
+		{Stream: "Copying blob sha256:bbb skipped: already exists\n"},
+		{Stream: "Copying config sha256:ccc\n"},
+		{Stream: "Writing manifest to image destination\n"},
+		{Images: []string{"ccc"}, Id: "ccc"},
+	}
+	instance := newPodmanApiClientFor(service.socket)
+
+	rc, err := instance.ImagePull(context.Background(), "alpine", "")
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(rc)
+
+	var progress recordingPreparationProgress
+	require.NoError(t, handlePodmanImagePullProgress(rc, &progress, testlog.NewLogger(t)))
+	require.Equal(t, []float32{0.45, 0.9, 0.95}, progress.reports)
+	require.True(t, progress.done)
+}
+
+func TestPodmanApiClient_ImagePull_failing(t *testing.T) {
+	testlog.Hook(t)
+
+	service := newFakePodmanService(t)
+	service.pullReports = []podmanImagePullReport{
+		{Stream: "Trying to pull docker.io/library/foo:latest...\n"},
+		{Error: "requested access to the resource is denied"},
+	}
+	instance := newPodmanApiClientFor(service.socket)
+
+	rc, err := instance.ImagePull(context.Background(), "foo", "")
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(rc)
+
+	var progress recordingPreparationProgress
+	require.ErrorContains(t, handlePodmanImagePullProgress(rc, &progress, testlog.NewLogger(t)), "requested access to the resource is denied")
+	require.False(t, progress.done)
+}
+
+func TestPodmanApiClient_ExecStart(t *testing.T) {
+	service := newFakePodmanService(t)
+	instance := newPodmanApiClientFor(service.socket)
+
+	conn, err := instance.ExecStart(context.Background(), "exec1", true, 80, 40)
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(conn)
+
+	_, err = conn.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	actual, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", actual)
+
+	_, err = instance.ExecStart(context.Background(), "unknown", true, 80, 40)
+	require.True(t, isPodmanNotFoundError(err))
+}
+
+func Test_resolvePodmanSocket(t *testing.T) {
+	actual, err := resolvePodmanSocket("unix:///run/user/1000/podman/podman.sock")
+	require.NoError(t, err)
+	require.Equal(t, "/run/user/1000/podman/podman.sock", actual)
+
+	actual, err = resolvePodmanSocket("/run/podman/podman.sock")
+	require.NoError(t, err)
+	require.Equal(t, "/run/podman/podman.sock", actual)
+
+	_, err = resolvePodmanSocket("tcp://localhost:8080")
+	require.Error(t, err)
+}
+
+type sessionWithId struct {
+	session.Session
+	id session.Id
+}
+
+func (this *sessionWithId) Id() session.Id {
+	return this.id
+}
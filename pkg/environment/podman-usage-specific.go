@@ -0,0 +1,298 @@
+package environment
+
+import (
+	"context"
+	"io"
+	gonet "net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	log "github.com/echocat/slf4g"
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/ssh"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+func (this *podman) Banner(req Request) (io.ReadCloser, error) {
+	b, err := this.repository.conf.Banner.Render(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(strings.NewReader(b)), nil
+}
+
+func (this *podman) Run(t Task) (exitCode int, rErr error) {
+	fail := func(err error) (int, error) {
+		return -1, err
+	}
+	failf := func(msg string, args ...any) (int, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	apiClient := this.repository.apiClient
+
+	auth := t.Authorization()
+	sess := auth.FindSession()
+	if sess == nil {
+		return failf("authorization without session is not supported to run podman environment")
+	}
+	sshSess := t.SshSession()
+	l := t.Connection().Logger()
+
+	config := podmanExecConfig{
+		User:         this.user,
+		WorkingDir:   this.directory,
+		AttachStdin:  true,
+		AttachStderr: true,
+		AttachStdout: true,
+	}
+
+	ev := sys.EnvVars{}
+	if v, ok := os.LookupEnv("TZ"); ok {
+		ev.Set("TZ", v)
+	}
+	ev.AddAllOf(t.Authorization().EnvVars())
+	ev.Add(t.SshSession().Environ()...)
+	ev.Set(session.EnvName, sess.Id().String())
+
+	binding := connectionBindingOf(t, this.terminalsPersistent, false)
+	if binding.connectionId {
+		ev.Set(connection.EnvName, t.Connection().Id().String())
+	}
+
+	switch t.TaskType() {
+	case TaskTypeShell:
+		if v := sshSess.RawCommand(); len(v) > 0 {
+			config.Cmd = append(slices.Clone(this.execCommand), v)
+		} else {
+			config.Cmd = slices.Clone(this.shellCommand)
+		}
+	case TaskTypeSftp:
+		config.Cmd = slices.Clone(this.sftpCommand)
+	default:
+		return failf("illegal task type: %v", t.TaskType())
+	}
+
+	if binding.agent {
+		ln, err := this.impSession.InitiateNamedPipe(t.Context(), t.Connection().Id(), "ssh-agent")
+		var re errors.RemoteError
+		if errors.As(err, &re) {
+			l.WithError(err).Warn("it was not possible to initiate named pipe for agent; agent deactivated")
+		} else if err != nil {
+			return fail(err)
+		} else {
+			defer common.IgnoreCloseError(ln)
+			go ssh.ForwardAgentConnections(ln, l, sshSess)
+			ev.Set(ssh.AuthSockEnvName, ln.Path())
+		}
+	}
+
+	if binding.persistentTerminal {
+		ptyReq, _, _ := sshSess.Pty()
+		ev.Set("TERM", ptyReq.Term)
+		start := imp.TerminalStart{
+			Argv: config.Cmd,
+			Dir:  config.WorkingDir,
+			Env:  ev,
+		}
+		start.User, start.Group, _ = strings.Cut(config.User, ":")
+		return runPersistentTerminal(t, this.impSession, &this.repository.conf.Terminals, start)
+	}
+
+	var width, height uint = 80, 40
+	ptyReq, winCh, isPty := sshSess.Pty()
+	if isPty {
+		ev.Set("TERM", ptyReq.Term)
+		config.Tty = true
+		if ptyReq.Window.Width > 0 && ptyReq.Window.Height > 0 {
+			width, height = uint(ptyReq.Window.Width), uint(ptyReq.Window.Height)
+		}
+	}
+	config.Env = ev.Strings()
+
+	execId, err := apiClient.ExecCreate(t.Context(), this.containerId, &config)
+	if err != nil {
+		return failf("cannot execute command: %w", err)
+	}
+
+	conn, err := apiClient.ExecStart(t.Context(), execId, config.Tty, width, height)
+	if err != nil {
+		return failf("cannot start execution #%v: %w", execId, err)
+	}
+	defer common.IgnoreCloseError(conn)
+
+	if isPty {
+		go func() {
+			for {
+				win, ok := <-winCh
+				if !ok {
+					return
+				}
+				if err := apiClient.ExecResize(sshSess.Context(), execId, uint(win.Width), uint(win.Height)); err != nil {
+					l.WithError(err).Warn("cannot set window size; ignoring")
+				}
+			}
+		}()
+	}
+
+	signals := make(chan glssh.Signal, 1)
+	copyDone := make(chan error, 2)
+	var activeRoutines sync.WaitGroup
+	defer func() {
+		go func() {
+			activeRoutines.Wait()
+			defer close(signals)
+			defer close(copyDone)
+		}()
+	}()
+
+	activeRoutines.Add(1)
+	go func() {
+		defer activeRoutines.Done()
+		var cErr error
+		if config.Tty {
+			_, cErr = io.Copy(sshSess, conn)
+		} else {
+			_, cErr = stdcopy.StdCopy(sshSess, sshSess.Stderr(), conn)
+		}
+		if this.isRelevantError(cErr) {
+			copyDone <- cErr
+		} else {
+			copyDone <- nil
+		}
+		l.Trace("finished copy output")
+	}()
+	activeRoutines.Add(1)
+	go func() {
+		defer activeRoutines.Done()
+		if _, err := io.Copy(conn, sshSess); this.isRelevantError(err) {
+			copyDone <- err
+		} else {
+			copyDone <- nil
+		}
+		l.Trace("finished copy input")
+	}()
+
+	finish := func() (int, error) {
+		ei, iErr := apiClient.ExecInspect(sshSess.Context(), execId)
+		if iErr != nil {
+			return failf("cannot inspect execution #%s: %w", execId, iErr)
+		}
+		if ei.Running {
+			return -1, nil
+		}
+		return ei.ExitCode, nil
+	}
+
+	sshSess.Signals(signals)
+	for {
+		select {
+		case s, ok := <-signals:
+			if ok {
+				this.signal(t.Context(), l, t.Connection(), s)
+			}
+		case <-t.Context().Done():
+			go this.signalDetached(l, t.Connection())
+
+			return -2, rErr
+		case err, ok := <-copyDone:
+			_ = conn.CloseWrite()
+
+			this.signalDetached(l, t.Connection())
+
+			if ok && err != nil && rErr == nil {
+				return -1, err
+			}
+			if rErr == nil {
+				if ec, err := finish(); err != nil {
+					return -1, err
+				} else if ec >= 0 {
+					return ec, nil
+				}
+			}
+		}
+	}
+}
+
+func (this *podman) signalDetached(logger log.Logger, conn connection.Connection) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelFunc()
+	this.signal(ctx, logger, conn, glssh.SIGINT)
+}
+
+func (this *podman) signal(ctx context.Context, logger log.Logger, conn connection.Connection, sshSignal glssh.Signal) {
+	var signal sys.Signal
+	if err := signal.Set(string(sshSignal)); err != nil {
+		signal = sys.SIGKILL
+	}
+
+	if err := this.impSession.Kill(ctx, conn.Id(), 0, signal); (err != nil && err.Error() == imp.ErrNoSuchProcess.Error()) || errors.Is(err, context.DeadlineExceeded) {
+		// Ok.
+	} else if err != nil {
+		logger.WithError(err).
+			With("signal", signal).
+			Warn("cannot send signal to process")
+	}
+}
+
+func (this *podman) IsPortForwardingAllowed(_ net.HostPort) (bool, error) {
+	return this.portForwardingAllowed, nil
+}
+
+func (this *podman) NewDestinationConnection(ctx context.Context, dest net.HostPort) (io.ReadWriteCloser, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	return this.impSession.InitiateTcpForward(ctx, connId, dest)
+}
+
+func (this *podman) NewRemoteListener(ctx context.Context, bind net.HostPort) (RemoteListener, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := this.impSession.InitiateTcpListen(ctx, connId, bind)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *podman) IsSocketForwardingAllowed(string) (bool, error) {
+	return false, nil
+}
+
+func (this *podman) NewDestinationSocketConnection(context.Context, string) (io.ReadWriteCloser, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by podman environments")
+}
+
+func (this *podman) NewRemoteSocketListener(context.Context, string) (gonet.Listener, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by podman environments")
+}
+
+func (this *podman) IsX11ForwardingAllowed() (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,292 @@
+package environment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	gonet "net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/echocat/slf4g"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/crypto"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+type podman struct {
+	repository *PodmanRepository
+
+	containerId string
+	podId       string
+	sessionId   session.Id
+
+	remoteUser string
+	remoteHost net.Host
+
+	shellCommand []string
+	execCommand  []string
+	sftpCommand  []string
+	user         string
+	directory    string
+
+	portForwardingAllowed bool
+	terminalsPersistent   bool
+
+	impBinding net.HostPort
+	impSession imp.Session
+
+	owners atomic.Int32
+}
+
+func (this *podman) SessionId() session.Id {
+	return this.sessionId
+}
+
+func (this *podman) PublicKey() crypto.PublicKey {
+	return nil
+}
+
+func (this *podman) Dial(ctx context.Context) (gonet.Conn, error) {
+	return this.repository.rawDialer.DialContext(ctx, "tcp", this.impBinding.String())
+}
+
+func (this *PodmanRepository) new(ctx context.Context, container *podmanContainer, logger log.Logger) (*podman, error) {
+	fail := func(err error) (*podman, error) {
+		return nil, errors.System.Newf("cannot create environment from container %s of flow %v: %w", container.Id, this.flow, err)
+	}
+
+	result := &podman{
+		repository: this,
+	}
+	if err := result.parseContainer(ctx, container); err != nil {
+		return fail(err)
+	}
+	var err error
+	if result.impSession, err = this.imp.Open(ctx, result); err != nil {
+		return fail(err)
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return fail(err)
+	}
+
+	for try := 1; try <= 200; try++ {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+
+		if err := result.impSession.Ping(ctx, connId); err == nil {
+			break
+		} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// try waiting...
+		} else {
+			return fail(err)
+		}
+		l := logger.With("try", try)
+		if try <= 2 {
+			l.Debug("waiting for container's imp getting ready...")
+		} else {
+			l.Info("still waiting for container's imp getting ready...")
+		}
+
+		if err := common.Sleep(ctx, 500*time.Millisecond); err != nil {
+			return fail(err)
+		}
+	}
+
+	result.owners.Add(1)
+
+	return result, nil
+}
+
+func (this *podman) Dispose(ctx context.Context) (_ bool, rErr error) {
+	fail := func(err error) (bool, error) {
+		return false, errors.Newf(errors.System, "cannot dispose environment: %w", err)
+	}
+
+	defer this.repository.sessionIdMutex.Lock(this.sessionId)()
+	defer common.KeepError(&rErr, this.closeGuarded)
+
+	ok, err := this.repository.removeContainer(ctx, this.containerId)
+	if err != nil {
+		return fail(err)
+	}
+
+	if this.podId != "" {
+		if _, err := this.repository.removePodIfEmpty(ctx, this.podId); err != nil {
+			return fail(err)
+		}
+	}
+
+	return ok, nil
+}
+
+func (this *podman) Close() (rErr error) {
+	defer this.repository.sessionIdMutex.Lock(this.sessionId)()
+
+	return this.closeGuarded()
+}
+
+func (this *podman) closeGuarded() error {
+	if this.owners.Add(-1) > 0 {
+		return nil
+	}
+	this.repository.activeInstances.Delete(this.sessionId)
+	return nil
+}
+
+func (this *podman) isRelevantError(err error) bool {
+	return err != nil && !errors.Is(err, syscall.EIO) && !sys.IsClosedError(err)
+}
+
+func (this *podman) parseContainer(ctx context.Context, container *podmanContainer) (err error) {
+	fail := func(err error) error {
+		return fmt.Errorf("%w: %v", containerContainsProblemsErr, err)
+	}
+	failf := func(msg string, args ...any) error {
+		return fail(errors.System.Newf(msg, args...))
+	}
+	decodeStrings := func(in string) (result []string, err error) {
+		err = json.Unmarshal([]byte(in), &result)
+		return result, err
+	}
+
+	this.containerId = container.Id
+	this.podId = container.Pod
+
+	labels := container.Labels
+	if v := labels[DockerLabelFlow]; v == "" {
+		return failf("missing label %s", DockerLabelFlow)
+	} else if v != this.repository.flow.String() {
+		return failf("expected flow: %v; but container had: %v", this.repository.flow, v)
+	}
+	if v := labels[DockerLabelSessionId]; v == "" {
+		return failf("missing label %s", DockerLabelSessionId)
+	} else if err = this.sessionId.UnmarshalText([]byte(v)); err != nil {
+		return failf("cannot decode label %s: %w", DockerLabelSessionId, err)
+	}
+
+	this.remoteUser = labels[DockerLabelCreatedRemoteUser]
+	if v := labels[DockerLabelCreatedRemoteHost]; v == "" {
+		return failf("missing label %s", DockerLabelCreatedRemoteHost)
+	} else if err = this.remoteHost.Set(v); err != nil {
+		return failf("cannot decode label %s: %w", DockerLabelCreatedRemoteHost, err)
+	}
+
+	if v := labels[DockerLabelShellCommand]; v == "" {
+		return failf("missing label %s", DockerLabelShellCommand)
+	} else if this.shellCommand, err = decodeStrings(v); err != nil {
+		return failf("cannot decode label %s: %w", DockerLabelShellCommand, err)
+	}
+	if v := labels[DockerLabelExecCommand]; v == "" {
+		return failf("missing label %s", DockerLabelExecCommand)
+	} else if this.execCommand, err = decodeStrings(v); err != nil {
+		return failf("cannot decode label %s: %w", DockerLabelExecCommand, err)
+	}
+	if v := labels[DockerLabelSftpCommand]; v == "" {
+		this.sftpCommand = nil
+	} else if this.sftpCommand, err = decodeStrings(v); err != nil {
+		return failf("cannot decode label %s: %w", DockerLabelSftpCommand, err)
+	}
+
+	this.user = labels[DockerLabelUser]
+	this.directory = labels[DockerLabelDirectory]
+	this.portForwardingAllowed = labels[DockerLabelPortForwardingAllowed] == "true"
+	this.terminalsPersistent = labels[DockerLabelTerminalsPersistent] == "true"
+
+	if this.impBinding, err = this.resolveImpBinding(ctx, container); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// resolveImpBinding resolves the address where the imp can be reached. If the
+// container is part of a pod, the network is owned by the infra container of
+// this pod.
+func (this *podman) resolveImpBinding(ctx context.Context, container *podmanContainer) (net.HostPort, error) {
+	fail := func(err error) (net.HostPort, error) {
+		return net.HostPort{}, err
+	}
+	failf := func(msg string, args ...any) (net.HostPort, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	apiClient := this.repository.apiClient
+	networkContainerId := container.Id
+	if v := container.Pod; v != "" {
+		pod, err := apiClient.PodInspect(ctx, v)
+		if err != nil {
+			return failf("cannot inspect pod %s of container %v: %w", v, container.Id, err)
+		}
+		if pod.InfraContainerId != "" {
+			networkContainerId = pod.InfraContainerId
+		}
+	}
+
+	publishHost := this.repository.publishHost
+	if publishHost.IsZero() {
+		ci, err := apiClient.ContainerInspect(ctx, networkContainerId)
+		if err != nil {
+			return failf("cannot inspect container %v: %w", networkContainerId, err)
+		}
+		result := net.HostPort{Port: imp.ServicePort}
+		ns := ci.NetworkSettings
+		for n, candidate := range ns.Networks {
+			if ip := candidate.IPAddress; ip != "" {
+				if err := result.Host.Set(ip); err != nil {
+					return failf("cannot parse ip address of network %s to: %w", n, err)
+				}
+				return result, nil
+			}
+		}
+		if ip := ns.IPAddress; ip != "" {
+			if err := result.Host.Set(ip); err != nil {
+				return failf("cannot parse ip address of container: %w", err)
+			}
+			return result, nil
+		}
+		return failf("network configuration of container does not any valid network configuration")
+	}
+
+	ports := container.Ports
+	if networkContainerId != container.Id {
+		nc, _, err := this.repository.findContainerById(ctx, networkContainerId)
+		if err != nil {
+			return fail(err)
+		}
+		if nc == nil {
+			return failf("infra container %v of container %v does not exist", networkContainerId, container.Id)
+		}
+		ports = nc.Ports
+	}
+
+	for _, candidate := range ports {
+		if candidate.ContainerPort != imp.ServicePort {
+			continue
+		}
+		if candidate.Protocol != "" && candidate.Protocol != "tcp" {
+			continue
+		}
+		result := net.HostPort{Port: candidate.HostPort}
+		if err := result.Host.Set(candidate.HostIp); err != nil {
+			return failf("cannot parse ip address where the host is bound to: %w", err)
+		}
+		if result.Host.IsZero() || result.Host.IP.IsUnspecified() {
+			result.Host = publishHost.Clone()
+		}
+		return result, nil
+	}
+
+	return failf("container does not have any valid published port")
+}
@@ -0,0 +1,108 @@
+package environment
+
+import (
+	"context"
+	gonet "net"
+	"sync"
+
+	log "github.com/echocat/slf4g"
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+// poolRequest is used to create members of a warm pool (like PODs or
+// containers), which do not belong to any connection or session.
+type poolRequest struct {
+	logger log.Logger
+	ctx    *poolContext
+}
+
+func newPoolRequest(ctx context.Context, logger log.Logger) *poolRequest {
+	return &poolRequest{logger, &poolContext{Context: ctx}}
+}
+
+func (this *poolRequest) Connection() connection.Connection {
+	return poolConnection{this.logger}
+}
+
+func (this *poolRequest) Context() glssh.Context {
+	return this.ctx
+}
+
+func (this *poolRequest) Authorization() authorization.Authorization {
+	return nil
+}
+
+func (this *poolRequest) StartPreparation(string, string, PreparationProgressAttributes) (PreparationProgress, error) {
+	return nil, nil
+}
+
+type poolConnection struct {
+	logger log.Logger
+}
+
+func (this poolConnection) Id() connection.Id {
+	return connection.Id{}
+}
+
+func (this poolConnection) Remote() net.Remote {
+	return poolRemote{}
+}
+
+func (this poolConnection) Logger() log.Logger {
+	return this.logger
+}
+
+type poolRemote struct{}
+
+func (this poolRemote) User() string {
+	return ""
+}
+
+func (this poolRemote) Host() net.Host {
+	return net.Host{}
+}
+
+func (this poolRemote) String() string {
+	return ""
+}
+
+type poolContext struct {
+	context.Context
+	sync.Mutex
+}
+
+func (this *poolContext) User() string {
+	return ""
+}
+
+func (this *poolContext) SessionID() string {
+	return ""
+}
+
+func (this *poolContext) ClientVersion() string {
+	return ""
+}
+
+func (this *poolContext) ServerVersion() string {
+	return ""
+}
+
+func (this *poolContext) RemoteAddr() gonet.Addr {
+	return nil
+}
+
+func (this *poolContext) LocalAddr() gonet.Addr {
+	return nil
+}
+
+func (this *poolContext) Permissions() *glssh.Permissions {
+	return &glssh.Permissions{}
+}
+
+func (this *poolContext) SetValue(key, value any) {
+	this.Context = context.WithValue(this.Context, key, value)
+}
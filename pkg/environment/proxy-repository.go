@@ -0,0 +1,413 @@
+package environment
+
+import (
+	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	gonet "net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/engity-com/bifroest/pkg/alternatives"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/crypto"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/ssh"
+)
+
+var (
+	_ = RegisterRepository(NewProxyRepository)
+
+	// proxyCertificateExtensions are the permissions of each certificate
+	// which is signed for the upstream server. The upstream server is still
+	// able to restrict them further.
+	proxyCertificateExtensions = map[string]string{
+		"permit-X11-forwarding":   "",
+		"permit-agent-forwarding": "",
+		"permit-port-forwarding":  "",
+		"permit-pty":              "",
+		"permit-user-rc":          "",
+	}
+)
+
+type ProxyRepository struct {
+	flow configuration.FlowName
+	conf *configuration.EnvironmentProxy
+
+	connectionIdMutex common.KeyedMutex[connection.Id]
+	activeInstances   sync.Map
+
+	rawDialer gonet.Dialer
+}
+
+func NewProxyRepository(_ context.Context, flow configuration.FlowName, conf *configuration.EnvironmentProxy, _ alternatives.Provider, _ imp.Imp) (*ProxyRepository, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("nil configuration")
+	}
+
+	return &ProxyRepository{
+		flow: flow,
+		conf: conf,
+	}, nil
+}
+
+func (this *ProxyRepository) WillBeAccepted(ctx Context) (ok bool, err error) {
+	fail := func(err error) (bool, error) {
+		return false, err
+	}
+
+	if ok, err = this.conf.LoginAllowed.Render(ctx); err != nil {
+		return fail(fmt.Errorf("cannot evaluate if user is allowed to login or not: %w", err))
+	}
+
+	return ok, nil
+}
+
+func (this *ProxyRepository) DoesSupportPty(Context, glssh.Pty) (bool, error) {
+	return true, nil
+}
+
+func (this *ProxyRepository) Ensure(req Request) (Environment, error) {
+	fail := func(err error) (Environment, error) {
+		return nil, err
+	}
+
+	if ok, err := this.WillBeAccepted(req); err != nil {
+		return fail(err)
+	} else if !ok {
+		return fail(ErrNotAcceptable)
+	}
+
+	// Each connection of the user shares exactly one connection to the
+	// upstream server, regardless how many channels it opens.
+	connId := req.Connection().Id()
+	defer this.connectionIdMutex.Lock(connId)()
+
+	if existing, ok := this.activeInstances.Load(connId); ok {
+		result := existing.(*proxy)
+		result.owners.Add(1)
+		return result, nil
+	}
+
+	opts, err := this.resolveDialOpts(req)
+	if err != nil {
+		return fail(err)
+	}
+
+	client, err := this.dial(req, opts)
+	if err != nil {
+		return fail(err)
+	}
+
+	result := &proxy{
+		repository:            this,
+		connectionId:          connId,
+		client:                client,
+		portForwardingAllowed: opts.portForwardingAllowed,
+	}
+	result.owners.Add(1)
+	this.activeInstances.Store(connId, result)
+
+	l := req.Connection().Logger()
+	go ssh.ForwardUpstreamAgentConnections(req.Context(), l, client, result.isAgentForwardingActive)
+	go func() {
+		// If the upstream server goes away, following channels of the same
+		// connection should not try to use this dead client anymore.
+		_ = client.Wait()
+		this.activeInstances.CompareAndDelete(connId, result)
+		l.Debug("connection to upstream server closed")
+	}()
+
+	return result, nil
+}
+
+type proxyDialOpts struct {
+	address         string
+	user            string
+	hostKeyCallback gossh.HostKeyCallback
+	signers         []gossh.Signer
+	agent           io.Closer
+
+	portForwardingAllowed bool
+}
+
+func (this *ProxyRepository) resolveDialOpts(req Request) (result proxyDialOpts, err error) {
+	fail := func(err error) (proxyDialOpts, error) {
+		if result.agent != nil {
+			_ = result.agent.Close()
+		}
+		return proxyDialOpts{}, err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (proxyDialOpts, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	host, err := this.conf.Host.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate host: %w", err)
+	}
+	if host == "" {
+		return failf(errors.Config, "host evaluates to empty")
+	}
+	port, err := this.conf.Port.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate port: %w", err)
+	}
+	if port == 0 || port > 65535 {
+		return failf(errors.Config, "port evaluates to illegal value: %d", port)
+	}
+	result.address = gonet.JoinHostPort(host, strconv.FormatUint(port, 10))
+
+	if result.user, err = this.conf.User.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate user: %w", err)
+	}
+	if result.user == "" {
+		return failf(errors.Config, "user evaluates to empty")
+	}
+
+	knownHostsFiles, err := this.conf.KnownHostsFiles.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate knownHostsFiles: %w", err)
+	}
+	if len(knownHostsFiles) == 0 {
+		return failf(errors.Config, "knownHostsFiles evaluates to empty")
+	}
+	if result.hostKeyCallback, err = knownhosts.New(knownHostsFiles...); err != nil {
+		return failf(errors.Config, "cannot read knownHostsFiles: %w", err)
+	}
+
+	if err := this.resolveCertificateSigner(req, &result); err != nil {
+		return fail(err)
+	}
+
+	if fn, err := this.conf.PrivateKeyFile.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate privateKeyFile: %w", err)
+	} else if fn != "" {
+		pk, err := crypto.EnsureKeyFile(fn, &crypto.KeyRequirement{
+			Type: crypto.KeyTypeEd25519,
+		}, nil)
+		if err != nil {
+			return failf(errors.System, "cannot ensure private key: %w", err)
+		}
+		result.signers = append(result.signers, pk.ToSsh())
+	}
+
+	if useAgent, err := this.conf.UseAgent.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate useAgent: %w", err)
+	} else if useAgent && !ssh.AgentRequestedBy(req.Context()) {
+		req.Connection().Logger().
+			Debug("client does not forward its agent; it cannot be used to authorize against upstream server")
+	} else if useAgent {
+		agent, closer, err := ssh.OpenAgent(req.Context())
+		if err != nil {
+			return failf(errors.Network, "cannot open client's agent: %w", err)
+		}
+		result.agent = closer
+		signers, err := agent.Signers()
+		if err != nil {
+			return failf(errors.Network, "cannot retrieve signers of client's agent: %w", err)
+		}
+		result.signers = append(result.signers, signers...)
+	}
+
+	if len(result.signers) == 0 {
+		return failf(errors.Config, "there are no credentials available to authorize against upstream server %s", result.address)
+	}
+
+	if result.portForwardingAllowed, err = this.conf.PortForwardingAllowed.Render(req); err != nil {
+		return failf(errors.Config, "cannot evaluate portForwardingAllowed: %w", err)
+	}
+
+	return result, nil
+}
+
+func (this *ProxyRepository) resolveCertificateSigner(req Request, target *proxyDialOpts) error {
+	fail := func(err error) error {
+		return err
+	}
+	failf := func(t errors.Type, msg string, args ...any) error {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	fn, err := this.conf.CertificateAuthorityFile.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate certificateAuthorityFile: %w", err)
+	}
+	if fn == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(fn)
+	if err != nil {
+		return failf(errors.Config, "cannot read certificate authority %q: %w", fn, err)
+	}
+	ca, err := gossh.ParsePrivateKey(raw)
+	if err != nil {
+		return failf(errors.Config, "cannot parse certificate authority %q: %w", fn, err)
+	}
+
+	principals, err := this.conf.CertificatePrincipals.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate certificatePrincipals: %w", err)
+	}
+	if len(principals) == 0 {
+		principals = []string{target.user}
+	}
+	validity, err := this.conf.CertificateValidity.Render(req)
+	if err != nil {
+		return failf(errors.Config, "cannot evaluate certificateValidity: %w", err)
+	}
+	if validity <= 0 {
+		return failf(errors.Config, "certificateValidity evaluates to illegal value: %v", validity)
+	}
+
+	pk, err := crypto.KeyRequirement{Type: crypto.KeyTypeEd25519}.GenerateKey(nil)
+	if err != nil {
+		return failf(errors.System, "cannot generate key for certificate: %w", err)
+	}
+
+	var serial [8]byte
+	if _, err := crand.Read(serial[:]); err != nil {
+		return failf(errors.System, "cannot generate serial for certificate: %w", err)
+	}
+
+	// Tolerate a little clock skew between us and the upstream server.
+	now := time.Now().Add(-time.Minute)
+	cert := gossh.Certificate{
+		Key:             pk.PublicKey().ToSsh(),
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        gossh.UserCert,
+		KeyId:           fmt.Sprintf("bifroest:%v:%v", this.flow, req.Connection().Id()),
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Unix()),
+		ValidBefore:     uint64(now.Add(validity + time.Minute).Unix()),
+		Permissions: gossh.Permissions{
+			Extensions: proxyCertificateExtensions,
+		},
+	}
+	if err := cert.SignCert(crand.Reader, ca); err != nil {
+		return failf(errors.System, "cannot sign certificate: %w", err)
+	}
+
+	signer, err := gossh.NewCertSigner(&cert, pk.ToSsh())
+	if err != nil {
+		return failf(errors.System, "cannot create signer for certificate: %w", err)
+	}
+	target.signers = append(target.signers, signer)
+
+	return nil
+}
+
+func (this *ProxyRepository) dial(req Request, opts proxyDialOpts) (_ *gossh.Client, rErr error) {
+	fail := func(err error) (*gossh.Client, error) {
+		return nil, errors.Network.Newf("cannot connect to upstream server %s: %w", opts.address, err)
+	}
+
+	if opts.agent != nil {
+		// The agent of the client is only required for the authorization.
+		defer common.IgnoreCloseError(opts.agent)
+	}
+
+	var ctx context.Context = req.Context()
+	if v := this.conf.ConnectTimeout; v > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v)
+		defer cancel()
+	}
+
+	conn, err := this.rawDialer.DialContext(ctx, "tcp", opts.address)
+	if err != nil {
+		return fail(err)
+	}
+	success := false
+	defer func() {
+		if !success {
+			_ = conn.Close()
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return fail(err)
+		}
+	}
+
+	c, chans, reqs, err := gossh.NewClientConn(conn, opts.address, &gossh.ClientConfig{
+		User:              opts.user,
+		Auth:              []gossh.AuthMethod{gossh.PublicKeys(opts.signers...)},
+		HostKeyCallback:   opts.hostKeyCallback,
+		HostKeyAlgorithms: proxyHostKeyAlgorithmsOf(opts.hostKeyCallback, opts.address, conn.RemoteAddr()),
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = c.Close()
+		return fail(err)
+	}
+
+	success = true
+	return gossh.NewClient(c, chans, reqs), nil
+}
+
+// proxyHostKeyAlgorithmsOf returns the host key algorithms of all keys
+// which are known for the given address. This makes the upstream server
+// present exactly one of these keys, instead of one which is maybe not
+// known, yet. This is the same behavior as OpenSSH has.
+func proxyHostKeyAlgorithmsOf(callback gossh.HostKeyCallback, address string, remote gonet.Addr) []string {
+	probe, err := gossh.NewPublicKey(make(ed25519.PublicKey, ed25519.PublicKeySize))
+	if err != nil {
+		return nil
+	}
+
+	var ke *knownhosts.KeyError
+	if err := callback(address, remote, probe); !errors.As(err, &ke) {
+		return nil
+	}
+
+	var result []string
+	add := func(algorithms ...string) {
+		for _, candidate := range algorithms {
+			if !slices.Contains(result, candidate) {
+				result = append(result, candidate)
+			}
+		}
+	}
+	for _, want := range ke.Want {
+		switch t := want.Key.Type(); t {
+		case gossh.KeyAlgoRSA:
+			add(gossh.KeyAlgoRSASHA512, gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSA)
+		default:
+			add(t)
+		}
+	}
+	return result
+}
+
+func (this *ProxyRepository) FindBySession(context.Context, session.Session, *FindOpts) (Environment, error) {
+	// There is nothing which survives the connection of the user.
+	return nil, ErrNoSuchEnvironment
+}
+
+func (this *ProxyRepository) Close() error {
+	return nil
+}
+
+func (this *ProxyRepository) Cleanup(context.Context, *CleanupOpts) error {
+	return nil
+}
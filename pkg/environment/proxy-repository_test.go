@@ -0,0 +1,57 @@
+package environment
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestProxyHostKeyAlgorithmsOf(t *testing.T) {
+	edPub, _, err := ed25519.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	rsaPriv, err := rsa.GenerateKey(crand.Reader, 2048)
+	require.NoError(t, err)
+
+	line := func(address string, key any) string {
+		pub, err := gossh.NewPublicKey(key)
+		require.NoError(t, err)
+		return knownhosts.Line([]string{address}, pub)
+	}
+
+	fn := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(fn, []byte(strings.Join([]string{
+		line("[upstream]:2222", edPub),
+		line("[upstream]:2222", &ecPriv.PublicKey),
+		line("other", &rsaPriv.PublicKey),
+	}, "\n")), 0600))
+
+	callback, err := knownhosts.New(fn)
+	require.NoError(t, err)
+	remote := &gonet.TCPAddr{IP: gonet.IPv4(127, 0, 0, 1), Port: 2222}
+
+	require.ElementsMatch(t, []string{
+		gossh.KeyAlgoED25519,
+		gossh.KeyAlgoECDSA256,
+	}, proxyHostKeyAlgorithmsOf(callback, "upstream:2222", remote))
+
+	require.Equal(t, []string{
+		gossh.KeyAlgoRSASHA512,
+		gossh.KeyAlgoRSASHA256,
+		gossh.KeyAlgoRSA,
+	}, proxyHostKeyAlgorithmsOf(callback, "other:22", &gonet.TCPAddr{IP: gonet.IPv4(127, 0, 0, 1), Port: 22}))
+
+	require.Nil(t, proxyHostKeyAlgorithmsOf(callback, "unknown:22", &gonet.TCPAddr{IP: gonet.IPv4(127, 0, 0, 1), Port: 22}))
+}
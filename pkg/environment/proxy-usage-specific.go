@@ -0,0 +1,302 @@
+package environment
+
+import (
+	"context"
+	"io"
+	gonet "net"
+	"strconv"
+	"strings"
+	"sync"
+
+	glssh "github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/ssh"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	proxySessionChannelType = "session"
+	proxyAgentRequestType   = "auth-agent-req@openssh.com"
+
+	// proxyEmptyTerminalModes contains only TTY_OP_END, which lets the
+	// upstream server use its defaults.
+	proxyEmptyTerminalModes = "\x00"
+)
+
+type proxyEnvRequest struct {
+	Name  string
+	Value string
+}
+
+type proxyPtyRequest struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	Width    uint32
+	Height   uint32
+	Modelist string
+}
+
+type proxyWindowChangeRequest struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type proxyExecRequest struct {
+	Command string
+}
+
+type proxySubsystemRequest struct {
+	Name string
+}
+
+type proxySignalRequest struct {
+	Signal string
+}
+
+type proxyExitStatusRequest struct {
+	Status uint32
+}
+
+type proxyExitSignalRequest struct {
+	Signal     string
+	CoreDumped bool
+	Error      string
+	Lang       string
+}
+
+func (this *proxy) Banner(req Request) (io.ReadCloser, error) {
+	b, err := this.repository.conf.Banner.Render(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(strings.NewReader(b)), nil
+}
+
+func (this *proxy) Run(t Task) (exitCode int, rErr error) {
+	fail := func(err error) (int, error) {
+		return -1, err
+	}
+	failf := func(msg string, args ...any) (int, error) {
+		return fail(errors.Network.Newf(msg, args...))
+	}
+
+	sshSess := t.SshSession()
+	l := t.Connection().Logger()
+
+	// We do not use gossh.Session here, because it does neither support to
+	// wait for subsystems (like sftp) nor to report signals of the process.
+	channel, reqs, err := this.client.OpenChannel(proxySessionChannelType, nil)
+	if err != nil {
+		return failf("cannot open session at upstream server: %w", err)
+	}
+	defer common.IgnoreCloseError(channel)
+
+	request := func(name string, wantReply bool, payload any) error {
+		var raw []byte
+		if payload != nil {
+			raw = gossh.Marshal(payload)
+		}
+		ok, err := channel.SendRequest(name, wantReply, raw)
+		if err != nil {
+			return err
+		}
+		if wantReply && !ok {
+			return errors.Network.Newf("upstream server rejected %s request", name)
+		}
+		return nil
+	}
+
+	ev := sys.EnvVars{}
+	ev.AddAllOf(t.Authorization().EnvVars())
+	ev.Add(sshSess.Environ()...)
+	for k, v := range ev {
+		// Most servers only accept a small set of variables. This is
+		// nothing which should prevent the user from doing its work.
+		if err := request("env", true, proxyEnvRequest{k, v}); err != nil {
+			l.With("name", k).
+				Trace("upstream server rejected environment variable; ignoring")
+		}
+	}
+
+	if ssh.AgentRequested(sshSess) {
+		if err := request(proxyAgentRequestType, true, nil); err != nil {
+			l.WithError(err).Warn("upstream server rejected agent forwarding; agent deactivated")
+		} else {
+			this.agentForwardings.Add(1)
+			defer this.agentForwardings.Add(-1)
+		}
+	}
+
+	ptyReq, winCh, isPty := sshSess.Pty()
+	if isPty {
+		width, height := 80, 40
+		if ptyReq.Window.Width > 0 && ptyReq.Window.Height > 0 {
+			width, height = ptyReq.Window.Width, ptyReq.Window.Height
+		}
+		if err := request("pty-req", true, proxyPtyRequest{
+			Term:     ptyReq.Term,
+			Columns:  uint32(width),
+			Rows:     uint32(height),
+			Modelist: proxyEmptyTerminalModes,
+		}); err != nil {
+			return failf("cannot request pty at upstream server: %w", err)
+		}
+		go func() {
+			for {
+				win, ok := <-winCh
+				if !ok {
+					return
+				}
+				if err := request("window-change", false, proxyWindowChangeRequest{
+					Columns: uint32(win.Width),
+					Rows:    uint32(win.Height),
+				}); err != nil && !sys.IsClosedError(err) {
+					l.WithError(err).Debug("cannot change window size at upstream server; ignoring")
+				}
+			}
+		}()
+	}
+
+	switch t.TaskType() {
+	case TaskTypeShell:
+		if v := sshSess.RawCommand(); len(v) > 0 {
+			err = request("exec", true, proxyExecRequest{v})
+		} else {
+			err = request("shell", true, nil)
+		}
+	case TaskTypeSftp:
+		err = request("subsystem", true, proxySubsystemRequest{"sftp"})
+	default:
+		return fail(errors.System.Newf("illegal task type: %v", t.TaskType()))
+	}
+	if err != nil {
+		return failf("cannot start %v at upstream server: %w", t.TaskType(), err)
+	}
+	l.Debug("upstream session started")
+
+	doCopy := func(from io.Reader, to io.Writer, name string) {
+		if _, err := io.Copy(to, from); err != nil && !sys.IsClosedError(err) {
+			l.WithError(err).Debugf("cannot copy %s", name)
+		}
+		l.Tracef("finished copy %s", name)
+	}
+
+	go func() {
+		doCopy(sshSess, channel, "ssh -> upstream")
+		_ = channel.CloseWrite()
+	}()
+	var outputs sync.WaitGroup
+	outputs.Add(2)
+	go func() {
+		defer outputs.Done()
+		doCopy(channel, sshSess, "upstream -> ssh")
+	}()
+	go func() {
+		defer outputs.Done()
+		doCopy(channel.Stderr(), sshSess.Stderr(), "upstream stderr -> ssh")
+	}()
+
+	type doneT struct {
+		exitCode int
+		err      error
+	}
+	processDone := make(chan doneT, 1)
+	go func() {
+		result := doneT{-1, errors.Network.Newf("upstream session ended without exit status")}
+		for req := range reqs {
+			switch req.Type {
+			case "exit-status":
+				var payload proxyExitStatusRequest
+				if err := gossh.Unmarshal(req.Payload, &payload); err == nil {
+					result = doneT{int(payload.Status), nil}
+				}
+			case "exit-signal":
+				var payload proxyExitSignalRequest
+				if err := gossh.Unmarshal(req.Payload, &payload); err == nil {
+					var signal sys.Signal
+					if err := signal.Set(payload.Signal); err != nil {
+						signal = sys.SIGKILL
+					}
+					result = doneT{128 + int(signal), nil}
+				}
+			}
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+		// Ensure everything the process has written reaches the client
+		// before we report its end.
+		outputs.Wait()
+		processDone <- result
+	}()
+
+	signals := make(chan glssh.Signal, 1)
+	sshSess.Signals(signals)
+	defer sshSess.Signals(nil)
+	for {
+		select {
+		case s := <-signals:
+			if err := request("signal", false, proxySignalRequest{string(s)}); err != nil && !sys.IsClosedError(err) {
+				l.WithError(err).
+					With("signal", s).
+					Warn("cannot send signal to upstream server")
+			}
+		case <-t.Context().Done():
+			return -2, nil
+		case status := <-processDone:
+			return status.exitCode, status.err
+		}
+	}
+}
+
+func (this *proxy) IsPortForwardingAllowed(_ net.HostPort) (bool, error) {
+	return this.portForwardingAllowed, nil
+}
+
+func (this *proxy) NewDestinationConnection(ctx context.Context, dest net.HostPort) (io.ReadWriteCloser, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	result, err := this.client.DialContext(ctx, "tcp", dest.String())
+	if err != nil {
+		return nil, errors.Network.Newf("cannot connect to %v via upstream server: %w", dest, err)
+	}
+	return result, nil
+}
+
+func (this *proxy) NewRemoteListener(_ context.Context, bind net.HostPort) (RemoteListener, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	ln, err := this.client.Listen("tcp", gonet.JoinHostPort(bind.Host.String(), strconv.FormatUint(uint64(bind.Port), 10)))
+	if err != nil {
+		return nil, errors.Network.Newf("cannot listen at %v of upstream server: %w", bind, err)
+	}
+	return &netRemoteListener{ln}, nil
+}
+
+func (this *proxy) IsSocketForwardingAllowed(string) (bool, error) {
+	return false, nil
+}
+
+func (this *proxy) NewDestinationSocketConnection(context.Context, string) (io.ReadWriteCloser, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by proxy environments")
+}
+
+func (this *proxy) NewRemoteSocketListener(context.Context, string) (gonet.Listener, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by proxy environments")
+}
+
+func (this *proxy) IsX11ForwardingAllowed() (bool, error) {
+	return false, nil
+}
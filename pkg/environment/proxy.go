@@ -0,0 +1,54 @@
+package environment
+
+import (
+	"context"
+	"sync/atomic"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+type proxy struct {
+	repository *ProxyRepository
+
+	connectionId connection.Id
+	client       *gossh.Client
+
+	portForwardingAllowed bool
+
+	owners atomic.Int32
+	// agentForwardings is the number of currently running sessions which
+	// requested agent forwarding. The upstream server can only open agent
+	// channels while this is positive.
+	agentForwardings atomic.Int32
+}
+
+func (this *proxy) isAgentForwardingActive() bool {
+	return this.agentForwardings.Load() > 0
+}
+
+func (this *proxy) Dispose(context.Context) (bool, error) {
+	defer this.repository.connectionIdMutex.Lock(this.connectionId)()
+
+	this.repository.activeInstances.CompareAndDelete(this.connectionId, this)
+	if err := this.client.Close(); err != nil && !sys.IsClosedError(err) {
+		return false, errors.Network.Newf("cannot close connection to upstream server: %w", err)
+	}
+	return true, nil
+}
+
+func (this *proxy) Close() error {
+	defer this.repository.connectionIdMutex.Lock(this.connectionId)()
+
+	if this.owners.Add(-1) > 0 {
+		return nil
+	}
+	this.repository.activeInstances.CompareAndDelete(this.connectionId, this)
+	if err := this.client.Close(); err != nil && !sys.IsClosedError(err) {
+		return errors.Network.Newf("cannot close connection to upstream server: %w", err)
+	}
+	return nil
+}
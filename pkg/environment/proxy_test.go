@@ -0,0 +1,493 @@
+package environment
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"fmt"
+	"io"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	glssh "github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+func TestProxy_Run_shell(t *testing.T) {
+	client := newTestProxyClient(t, newTestProxyFront(t, newTestProxyUpstream(t)))
+
+	sess, err := client.NewSession()
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(sess)
+	var stdout bytes.Buffer
+	sess.Stdout = &stdout
+	sess.Stdin = strings.NewReader("hello\n")
+
+	require.NoError(t, sess.Shell())
+	require.NoError(t, sess.Wait())
+	require.Equal(t, "hello\n", stdout.String())
+}
+
+func TestProxy_Run_exec(t *testing.T) {
+	client := newTestProxyClient(t, newTestProxyFront(t, newTestProxyUpstream(t)))
+
+	cases := []struct {
+		name             string
+		command          string
+		env              map[string]string
+		expectedStdout   string
+		expectedStderr   string
+		expectedExitCode int
+	}{{
+		name:           "env",
+		command:        "env",
+		env:            map[string]string{"FOO": "bar"},
+		expectedStdout: "FOO=bar\n",
+	}, {
+		name:             "stderr-and-exit-code",
+		command:          "fail",
+		expectedStderr:   "failed",
+		expectedExitCode: 3,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sess, err := client.NewSession()
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(sess)
+			for k, v := range c.env {
+				require.NoError(t, sess.Setenv(k, v))
+			}
+			var stdout, stderr bytes.Buffer
+			sess.Stdout, sess.Stderr = &stdout, &stderr
+
+			err = sess.Run(c.command)
+			if c.expectedExitCode == 0 {
+				require.NoError(t, err)
+			} else {
+				var ee *gossh.ExitError
+				require.ErrorAs(t, err, &ee)
+				require.Equal(t, c.expectedExitCode, ee.ExitStatus())
+			}
+			require.Equal(t, c.expectedStdout, stdout.String())
+			require.Equal(t, c.expectedStderr, stderr.String())
+		})
+	}
+}
+
+func TestProxy_Run_sftp(t *testing.T) {
+	client := newTestProxyClient(t, newTestProxyFront(t, newTestProxyUpstream(t)))
+
+	sc, err := sftp.NewClient(client)
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(sc)
+
+	fn := filepath.Join(t.TempDir(), "foo")
+	f, err := sc.Create(fn)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("bar"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	actual, err := os.ReadFile(fn)
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(actual))
+}
+
+func TestProxy_NewDestinationConnection(t *testing.T) {
+	client := newTestProxyClient(t, newTestProxyFront(t, newTestProxyUpstream(t)))
+	target := newTestProxyEchoServer(t)
+
+	conn, err := client.Dial("tcp", target)
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(conn)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	actual := make([]byte, 4)
+	_, err = io.ReadFull(conn, actual)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(actual))
+}
+
+func TestProxy_agentForwarding(t *testing.T) {
+	cases := []struct {
+		name           string
+		requestAgent   bool
+		expectedStdout string
+	}{{
+		name:           "requested",
+		requestAgent:   true,
+		expectedStdout: "test-key\n",
+	}, {
+		name:           "not-requested",
+		requestAgent:   false,
+		expectedStdout: "rejected\n",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := newTestProxyClient(t, newTestProxyFront(t, newTestProxyUpstream(t)))
+
+			_, pk, err := ed25519.GenerateKey(crand.Reader)
+			require.NoError(t, err)
+			keyring := agent.NewKeyring()
+			require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: pk, Comment: "test-key"}))
+			// The client is always able to serve its agent, but only
+			// sessions which request it should make it available upstream.
+			require.NoError(t, agent.ForwardToAgent(client, keyring))
+
+			sess, err := client.NewSession()
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(sess)
+			if c.requestAgent {
+				require.NoError(t, agent.RequestAgentForwarding(sess))
+			}
+			var stdout bytes.Buffer
+			sess.Stdout = &stdout
+
+			require.NoError(t, sess.Run("agent"))
+			require.Equal(t, c.expectedStdout, stdout.String())
+		})
+	}
+}
+
+type testProxyUpstream struct {
+	address        string
+	knownHostsFile string
+}
+
+// newTestProxyUpstream starts an SSH server which acts as the upstream
+// server of a proxy.
+func newTestProxyUpstream(t *testing.T) testProxyUpstream {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := gossh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+
+	server := &glssh.Server{
+		Handler: func(s glssh.Session) {
+			switch s.RawCommand() {
+			case "":
+				_, _ = io.Copy(s, s)
+				_ = s.Exit(0)
+			case "env":
+				for _, v := range s.Environ() {
+					_, _ = fmt.Fprintln(s, v)
+				}
+				_ = s.Exit(0)
+			case "fail":
+				_, _ = fmt.Fprint(s.Stderr(), "failed")
+				_ = s.Exit(3)
+			case "agent":
+				// This is deliberately done even if the client has not
+				// requested agent forwarding, like a malicious server would.
+				conn := s.Context().Value(glssh.ContextKeyConn).(gossh.Conn)
+				channel, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+				if err != nil {
+					_, _ = fmt.Fprintln(s, "rejected")
+					_ = s.Exit(0)
+					return
+				}
+				defer common.IgnoreCloseError(channel)
+				go gossh.DiscardRequests(reqs)
+				keys, err := agent.NewClient(channel).List()
+				if err != nil {
+					_, _ = fmt.Fprintln(s.Stderr(), err)
+					_ = s.Exit(1)
+					return
+				}
+				for _, key := range keys {
+					_, _ = fmt.Fprintln(s, key.Comment)
+				}
+				_ = s.Exit(0)
+			default:
+				_ = s.Exit(127)
+			}
+		},
+		SubsystemHandlers: map[string]glssh.SubsystemHandler{
+			"sftp": func(s glssh.Session) {
+				server, err := sftp.NewServer(s)
+				if err != nil {
+					_ = s.Exit(1)
+					return
+				}
+				_ = server.Serve()
+				_ = s.Exit(0)
+			},
+		},
+		ChannelHandlers: map[string]glssh.ChannelHandler{
+			"session":      glssh.DefaultSessionHandler,
+			"direct-tcpip": glssh.DirectTCPIPHandler,
+		},
+		LocalPortForwardingCallback: func(glssh.Context, string, uint32) bool {
+			return true
+		},
+		PublicKeyHandler: func(ctx glssh.Context, _ glssh.PublicKey) bool {
+			return ctx.User() == "upstream"
+		},
+	}
+	server.AddHostKey(hostSigner)
+
+	ln, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = server.Serve(ln) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	result := testProxyUpstream{
+		address:        ln.Addr().String(),
+		knownHostsFile: filepath.Join(t.TempDir(), "known_hosts"),
+	}
+	require.NoError(t, os.WriteFile(result.knownHostsFile, []byte(knownhosts.Line([]string{knownhosts.Normalize(result.address)}, hostSigner.PublicKey())+"\n"), 0600))
+
+	return result
+}
+
+// newTestProxyFront starts an SSH server which relays everything to the
+// given upstream server using a ProxyRepository, like the service does.
+func newTestProxyFront(t *testing.T, upstream testProxyUpstream) string {
+	t.Helper()
+
+	host, port, err := gonet.SplitHostPort(upstream.address)
+	require.NoError(t, err)
+
+	conf := configuration.EnvironmentProxy{
+		LoginAllowed:             template.BoolOf(true),
+		Host:                     template.MustNewString(host),
+		Port:                     template.MustNewUint64(port),
+		User:                     template.MustNewString("upstream"),
+		KnownHostsFiles:          template.MustNewStrings(upstream.knownHostsFile),
+		ConnectTimeout:           5 * time.Second,
+		PrivateKeyFile:           template.MustNewString(filepath.Join(t.TempDir(), "id_ed25519")),
+		CertificateAuthorityFile: template.MustNewString(""),
+		UseAgent:                 template.BoolOf(false),
+		Banner:                   template.MustNewString(""),
+		PortForwardingAllowed:    template.BoolOf(true),
+	}
+	repo, err := NewProxyRepository(t.Context(), "test", &conf, nil, nil)
+	require.NoError(t, err)
+
+	front := &testProxyFront{repository: repo}
+
+	_, hostKey, err := ed25519.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := gossh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+
+	server := &glssh.Server{
+		Handler: func(s glssh.Session) {
+			front.run(s, TaskTypeShell)
+		},
+		SubsystemHandlers: map[string]glssh.SubsystemHandler{
+			"sftp": func(s glssh.Session) {
+				front.run(s, TaskTypeSftp)
+			},
+		},
+		ChannelHandlers: map[string]glssh.ChannelHandler{
+			"session":      glssh.DefaultSessionHandler,
+			"direct-tcpip": front.directTcpip,
+		},
+		ConnCallback: func(ctx glssh.Context, conn gonet.Conn) gonet.Conn {
+			ctx.SetValue(testProxyConnectionIdKey, connection.MustNewId())
+			return conn
+		},
+		PublicKeyHandler: func(glssh.Context, glssh.PublicKey) bool {
+			return true
+		},
+	}
+	server.AddHostKey(hostSigner)
+
+	ln, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = server.Serve(ln) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return ln.Addr().String()
+}
+
+func newTestProxyClient(t *testing.T, address string) *gossh.Client {
+	t.Helper()
+
+	_, pk, err := ed25519.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	signer, err := gossh.NewSignerFromKey(pk)
+	require.NoError(t, err)
+
+	result, err := gossh.Dial("tcp", address, &gossh.ClientConfig{
+		User:            "user",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = result.Close() })
+	return result
+}
+
+func newTestProxyEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer common.IgnoreCloseError(conn)
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+var testProxyConnectionIdKey = &struct{ name string }{"connectionId"}
+
+type testProxyFront struct {
+	repository *ProxyRepository
+}
+
+func (this *testProxyFront) run(s glssh.Session, taskType TaskType) {
+	task := &testProxyTask{testProxyRequest{s.Context()}, s, taskType}
+	env, err := this.repository.Ensure(task)
+	if err != nil {
+		_, _ = fmt.Fprintln(s.Stderr(), err)
+		_ = s.Exit(255)
+		return
+	}
+	defer common.IgnoreCloseError(env)
+
+	exitCode, err := env.Run(task)
+	if err != nil {
+		_, _ = fmt.Fprintln(s.Stderr(), err)
+	}
+	_ = s.Exit(exitCode)
+}
+
+func (this *testProxyFront) directTcpip(_ *glssh.Server, _ *gossh.ServerConn, nc gossh.NewChannel, ctx glssh.Context) {
+	var payload struct {
+		DestAddr   string
+		DestPort   uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+	if err := gossh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+		_ = nc.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+
+	env, err := this.repository.Ensure(testProxyRequest{ctx})
+	if err != nil {
+		_ = nc.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	defer common.IgnoreCloseError(env)
+
+	dest, err := net.NewHostPort(gonet.JoinHostPort(payload.DestAddr, fmt.Sprint(payload.DestPort)))
+	if err != nil {
+		_ = nc.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := env.NewDestinationConnection(ctx, dest)
+	if err != nil {
+		_ = nc.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	defer common.IgnoreCloseError(conn)
+
+	channel, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer common.IgnoreCloseError(channel)
+	go gossh.DiscardRequests(reqs)
+
+	go func() {
+		_, _ = io.Copy(conn, channel)
+	}()
+	_, _ = io.Copy(channel, conn)
+}
+
+type testProxyRequest struct {
+	context glssh.Context
+}
+
+func (this testProxyRequest) Connection() connection.Connection {
+	return testProxyConnection{this.context.Value(testProxyConnectionIdKey).(connection.Id)}
+}
+
+func (this testProxyRequest) Context() glssh.Context {
+	return this.context
+}
+
+func (this testProxyRequest) Authorization() authorization.Authorization {
+	return authorization.Forbidden(testProxyRemote{})
+}
+
+func (this testProxyRequest) StartPreparation(string, string, PreparationProgressAttributes) (PreparationProgress, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+type testProxyTask struct {
+	testProxyRequest
+	sshSession glssh.Session
+	taskType   TaskType
+}
+
+func (this *testProxyTask) SshSession() glssh.Session {
+	return this.sshSession
+}
+
+func (this *testProxyTask) TaskType() TaskType {
+	return this.taskType
+}
+
+type testProxyConnection struct {
+	id connection.Id
+}
+
+func (this testProxyConnection) Id() connection.Id {
+	return this.id
+}
+
+func (this testProxyConnection) Remote() net.Remote {
+	return testProxyRemote{}
+}
+
+func (this testProxyConnection) Logger() log.Logger {
+	return log.GetLogger("test")
+}
+
+type testProxyRemote struct{}
+
+func (this testProxyRemote) User() string {
+	return "user"
+}
+
+func (this testProxyRemote) Host() net.Host {
+	return net.MustNewHost("127.0.0.1")
+}
+
+func (this testProxyRemote) String() string {
+	return "user@127.0.0.1"
+}
@@ -0,0 +1,51 @@
+package environment
+
+import (
+	"io"
+	gonet "net"
+
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+// RemoteListener accepts connections of a remote port forwarding (see
+// Environment.NewRemoteListener) inside an environment.
+type RemoteListener interface {
+	io.Closer
+
+	// Addr returns the address the listener is bound to. If port 0 was
+	// requested, it contains the actually allocated port.
+	Addr() net.HostPort
+
+	// Accept waits for the next connection and returns it together with the
+	// address of its origin.
+	Accept() (gonet.Conn, net.HostPort, error)
+}
+
+// netRemoteListener adapts a plain gonet.Listener to RemoteListener.
+type netRemoteListener struct {
+	gonet.Listener
+}
+
+func (this *netRemoteListener) Addr() net.HostPort {
+	result, _ := hostPortOfNetAddr(this.Listener.Addr())
+	return result
+}
+
+func (this *netRemoteListener) Accept() (gonet.Conn, net.HostPort, error) {
+	conn, err := this.Listener.Accept()
+	if err != nil {
+		return nil, net.HostPort{}, err
+	}
+	origin, _ := hostPortOfNetAddr(conn.RemoteAddr())
+	return conn, origin, nil
+}
+
+func hostPortOfNetAddr(addr gonet.Addr) (result net.HostPort, err error) {
+	if err := result.Host.SetNetAddr(addr); err != nil {
+		return result, err
+	}
+	if v, ok := addr.(*gonet.TCPAddr); ok {
+		result.Port = uint16(v.Port)
+	}
+	return result, nil
+}
@@ -0,0 +1,543 @@
+//go:build linux
+
+package environment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/echocat/slf4g"
+	"github.com/echocat/slf4g/level"
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/alternatives"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/sandbox"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+const (
+	// sandboxStateFilename is the name of the file inside the directory of
+	// each sandbox which contains the sandboxState.
+	sandboxStateFilename = "sandbox.json"
+)
+
+var (
+	_ = RegisterRepository(NewSandboxRepository)
+)
+
+type SandboxRepository struct {
+	flow configuration.FlowName
+	conf *configuration.EnvironmentSandbox
+	imp  imp.Imp
+
+	Logger              log.Logger
+	defaultLogLevelName string
+
+	sessionIdMutex  common.KeyedMutex[session.Id]
+	activeInstances sync.Map
+
+	rawDialer gonet.Dialer
+}
+
+// sandboxState is stored on the host inside the directory of each sandbox.
+// It plays the same role as the labels of a container.
+type sandboxState struct {
+	Flow      configuration.FlowName `json:"flow"`
+	SessionId session.Id             `json:"sessionId"`
+	Pid       int                    `json:"pid"`
+
+	RemoteUser string `json:"remoteUser,omitempty"`
+	RemoteHost string `json:"remoteHost"`
+
+	RootFilesystem string `json:"rootFilesystem"`
+
+	ShellCommand []string `json:"shellCommand"`
+	ExecCommand  []string `json:"execCommand"`
+	SftpCommand  []string `json:"sftpCommand,omitempty"`
+	User         string   `json:"user,omitempty"`
+	Directory    string   `json:"directory,omitempty"`
+
+	PortForwardingAllowed bool `json:"portForwardingAllowed,omitempty"`
+	TerminalsPersistent   bool `json:"terminalsPersistent,omitempty"`
+}
+
+func NewSandboxRepository(_ context.Context, flow configuration.FlowName, conf *configuration.EnvironmentSandbox, _ alternatives.Provider, i imp.Imp) (*SandboxRepository, error) {
+	fail := func(err error) (*SandboxRepository, error) {
+		return nil, err
+	}
+	failf := func(msg string, args ...any) (*SandboxRepository, error) {
+		return fail(fmt.Errorf(msg, args...))
+	}
+
+	if conf == nil {
+		return failf("nil configuration")
+	}
+
+	if err := os.MkdirAll(conf.StateDirectory, 0700); err != nil {
+		return failf("cannot create state directory %s: %w", conf.StateDirectory, err)
+	}
+
+	result := SandboxRepository{
+		flow: flow,
+		conf: conf,
+		imp:  i,
+	}
+
+	lp := result.logger().GetProvider()
+	if la, ok := lp.(level.Aware); ok {
+		if lna, ok := lp.(level.NamesAware); ok {
+			lvl := la.GetLevel()
+			var err error
+			if result.defaultLogLevelName, err = lna.GetLevelNames().ToName(lvl); err != nil {
+				return failf("cannot transform to name of level %v: %w", lvl, err)
+			}
+		}
+	}
+
+	return &result, nil
+}
+
+func (this *SandboxRepository) WillBeAccepted(ctx Context) (ok bool, err error) {
+	fail := func(err error) (bool, error) {
+		return false, err
+	}
+
+	if ok, err = this.conf.LoginAllowed.Render(ctx); err != nil {
+		return fail(fmt.Errorf("cannot evaluate if user is allowed to login or not: %w", err))
+	}
+
+	return ok, nil
+}
+
+func (this *SandboxRepository) DoesSupportPty(Context, glssh.Pty) (bool, error) {
+	return true, nil
+}
+
+func (this *SandboxRepository) Ensure(req Request) (Environment, error) {
+	fail := func(err error) (Environment, error) {
+		return nil, err
+	}
+	failf := func(t errors.Type, msg string, args ...any) (Environment, error) {
+		return fail(errors.Newf(t, msg, args...))
+	}
+
+	if ok, err := this.WillBeAccepted(req); err != nil {
+		return fail(err)
+	} else if !ok {
+		return fail(ErrNotAcceptable)
+	}
+
+	sess := req.Authorization().FindSession()
+	if sess == nil {
+		return failf(errors.System, "authorization without session")
+	}
+
+	return this.findOrEnsureBySession(req.Context(), sess, nil, req, true)
+}
+
+func (this *SandboxRepository) directoryOf(sessionId session.Id) string {
+	return filepath.Join(this.conf.StateDirectory, sessionId.String())
+}
+
+func (this *SandboxRepository) createSandboxBy(req Request, sess session.Session) (*sandboxState, error) {
+	fail := func(err error) (*sandboxState, error) {
+		return nil, err
+	}
+	failf := func(msg string, args ...any) (*sandboxState, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	state, opts, err := this.resolveStartOpts(req, sess)
+	if err != nil {
+		return fail(err)
+	}
+
+	if state.Pid, err = sandbox.Start(*opts); err != nil {
+		return fail(err)
+	}
+	success := false
+	defer func() {
+		if !success {
+			if err := sandbox.Stop(opts.Directory, state.Pid); err != nil {
+				req.Connection().Logger().
+					WithError(err).
+					Warn("cannot stop orphan sandbox within emergency cleanup; sandbox could still be there")
+			}
+		}
+	}()
+
+	if err := this.writeState(opts.Directory, state); err != nil {
+		return failf("cannot store state of sandbox %s: %w", opts.Directory, err)
+	}
+
+	success = true
+	return state, nil
+}
+
+func (this *SandboxRepository) resolveStartOpts(req Request, sess session.Session) (_ *sandboxState, _ *sandbox.StartOpts, err error) {
+	fail := func(err error) (*sandboxState, *sandbox.StartOpts, error) {
+		return nil, nil, err
+	}
+	failf := func(msg string, args ...any) (*sandboxState, *sandbox.StartOpts, error) {
+		return fail(errors.Config.Newf(msg, args...))
+	}
+
+	remote := req.Connection().Remote()
+	state := sandboxState{
+		Flow:       this.flow,
+		SessionId:  sess.Id(),
+		RemoteUser: remote.User(),
+		RemoteHost: remote.Host().String(),
+	}
+	if state.ShellCommand, err = this.conf.ShellCommand.Render(req); err != nil {
+		return failf("cannot evaluate shellCommand: %w", err)
+	}
+	if state.ExecCommand, err = this.conf.ExecCommand.Render(req); err != nil {
+		return failf("cannot evaluate execCommand: %w", err)
+	}
+	if state.SftpCommand, err = this.conf.SftpCommand.Render(req); err != nil {
+		return failf("cannot evaluate sftpCommand: %w", err)
+	}
+	if state.User, err = this.conf.User.Render(req); err != nil {
+		return failf("cannot evaluate user: %w", err)
+	}
+	if state.Directory, err = this.conf.Directory.Render(req); err != nil {
+		return failf("cannot evaluate directory: %w", err)
+	}
+	if state.PortForwardingAllowed, err = this.conf.PortForwardingAllowed.Render(req); err != nil {
+		return failf("cannot evaluate portForwardingAllowed: %w", err)
+	}
+	if state.TerminalsPersistent, err = this.conf.Terminals.Persistent.Render(req); err != nil {
+		return failf("cannot evaluate terminals.persistent: %w", err)
+	}
+
+	opts := sandbox.StartOpts{
+		Directory: this.directoryOf(sess.Id()),
+		IdMapping: sandbox.IdMapping{
+			HostUid: this.conf.HostUid,
+			HostGid: this.conf.HostGid,
+			Size:    this.conf.IdMappingSize,
+		},
+	}
+	if this.defaultLogLevelName != "" {
+		opts.Args = append(opts.Args, `--log.level=`+this.defaultLogLevelName)
+	}
+
+	spec := &opts.Spec
+	if spec.RootFilesystem, err = this.conf.RootFilesystem.Render(req); err != nil {
+		return failf("cannot evaluate rootFilesystem: %w", err)
+	}
+	if !filepath.IsAbs(spec.RootFilesystem) {
+		return failf("rootFilesystem has to be an absolute path; but got: %q", spec.RootFilesystem)
+	}
+	state.RootFilesystem = spec.RootFilesystem
+	if spec.Overlay, err = this.conf.Overlay.Render(req); err != nil {
+		return failf("cannot evaluate overlay: %w", err)
+	}
+	if raws, err := this.conf.BindMounts.Render(req); err != nil {
+		return failf("cannot evaluate bindMounts: %w", err)
+	} else {
+		for i, raw := range raws {
+			m, err := sandbox.ParseMount(raw)
+			if err != nil {
+				return failf("cannot evaluate bindMount %d: %w", i, err)
+			}
+			spec.Mounts = append(spec.Mounts, m)
+		}
+	}
+	if raws, err := this.conf.Tmpfs.Render(req); err != nil {
+		return failf("cannot evaluate tmpfs: %w", err)
+	} else {
+		for i, raw := range raws {
+			t, err := sandbox.ParseTmpfs(raw)
+			if err != nil {
+				return failf("cannot evaluate tmpfs %d: %w", i, err)
+			}
+			spec.Tmpfs = append(spec.Tmpfs, t)
+		}
+	}
+	if spec.Hostname, err = this.conf.Hostname.Render(req); err != nil {
+		return failf("cannot evaluate hostname: %w", err)
+	}
+	if spec.ShareHostNetwork, err = this.conf.ShareHostNetwork.Render(req); err != nil {
+		return failf("cannot evaluate shareHostNetwork: %w", err)
+	}
+	if spec.Capabilities, err = this.conf.Capabilities.Render(req); err != nil {
+		return failf("cannot evaluate capabilities: %w", err)
+	}
+	if spec.DeniedSyscalls, err = this.conf.DeniedSyscalls.Render(req); err != nil {
+		return failf("cannot evaluate deniedSyscalls: %w", err)
+	}
+	// The states of all sandboxes must never be visible to any of them.
+	spec.Hidden = []string{this.conf.StateDirectory}
+
+	masterPub, err := this.imp.GetMasterPublicKey()
+	if err != nil {
+		return fail(err)
+	}
+	opts.Env = []string{
+		imp.EnvVarMasterPublicKey + "=" + base64.RawStdEncoding.EncodeToString(masterPub.Marshal()),
+		session.EnvName + "=" + sess.Id().String(),
+	}
+
+	return &state, &opts, nil
+}
+
+func (this *SandboxRepository) FindBySession(ctx context.Context, sess session.Session, opts *FindOpts) (Environment, error) {
+	return this.findOrEnsureBySession(ctx, sess, opts, nil, false)
+}
+
+func (this *SandboxRepository) findOrEnsureBySession(ctx context.Context, sess session.Session, opts *FindOpts, createUsing Request, retryAllowed bool) (Environment, error) {
+	fail := func(err error) (Environment, error) {
+		return nil, err
+	}
+
+	sessId := sess.Id()
+	rUnlocker := this.sessionIdMutex.RLock(sessId)
+	rUnlock := func() {
+		if rUnlocker != nil {
+			rUnlocker()
+		}
+		rUnlocker = nil
+	}
+	defer rUnlock()
+
+	ip, ok := this.activeInstances.Load(sessId)
+	if ok {
+		instance := ip.(*sandboxEnvironment)
+		instance.owners.Add(1)
+		return instance, nil
+	}
+
+	directory := this.directoryOf(sessId)
+	state, err := this.readState(directory)
+	if err != nil {
+		return fail(err)
+	}
+	if state == nil && createUsing == nil {
+		return fail(ErrNoSuchEnvironment)
+	}
+	rUnlock()
+
+	defer this.sessionIdMutex.Lock(sessId)()
+
+	ip, ok = this.activeInstances.Load(sessId)
+	if ok {
+		instance := ip.(*sandboxEnvironment)
+		instance.owners.Add(1)
+		return instance, nil
+	}
+
+	if state != nil && !sandbox.IsRunning(directory, state.Pid) {
+		if opts.IsAutoCleanUpAllowed() {
+			if err := sandbox.Stop(directory, state.Pid); err != nil {
+				return fail(err)
+			}
+		}
+		if createUsing == nil {
+			return fail(ErrNoSuchEnvironment)
+		}
+		state = nil
+	}
+
+	if state == nil {
+		state, err = this.createSandboxBy(createUsing, sess)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	logger := this.logger().
+		With("sandbox", directory).
+		With("sessionId", sessId)
+
+	stopSandboxUnchecked := func() {
+		if err := sandbox.Stop(directory, state.Pid); err != nil {
+			logger.
+				WithError(err).
+				Warnf("cannot stop broken sandbox; need to be done manually")
+		}
+	}
+
+	instance, err := this.new(ctx, directory, state, logger)
+	if err != nil {
+		if errors.Is(err, containerContainsProblemsErr) {
+			if createUsing != nil {
+				stopSandboxUnchecked()
+				if !retryAllowed {
+					return fail(err)
+				}
+				return this.findOrEnsureBySession(ctx, sess, opts, createUsing, false)
+			} else if opts.IsAutoCleanUpAllowed() {
+				stopSandboxUnchecked()
+				return fail(ErrNoSuchEnvironment)
+			}
+		}
+		return fail(err)
+	}
+
+	this.activeInstances.Store(sessId, instance)
+
+	return instance, nil
+}
+
+// readState reads the sandboxState of the given directory. It returns nil
+// if there is no sandbox inside this directory.
+func (this *SandboxRepository) readState(directory string) (*sandboxState, error) {
+	fail := func(err error) (*sandboxState, error) {
+		return nil, errors.System.Newf("cannot read state of sandbox %s: %w", directory, err)
+	}
+
+	f, err := os.Open(filepath.Join(directory, sandboxStateFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseError(f)
+
+	var result sandboxState
+	if err := json.NewDecoder(f).Decode(&result); err != nil {
+		return fail(err)
+	}
+	return &result, nil
+}
+
+func (this *SandboxRepository) writeState(directory string, state *sandboxState) (rErr error) {
+	f, err := os.OpenFile(filepath.Join(directory, sandboxStateFilename), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer common.KeepCloseError(&rErr, f)
+
+	return json.NewEncoder(f).Encode(state)
+}
+
+func (this *SandboxRepository) Close() error {
+	return nil
+}
+
+func (this *SandboxRepository) Cleanup(_ context.Context, opts *CleanupOpts) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot cleanup potential orphan sandboxes: %w", err)
+	}
+
+	entries, err := os.ReadDir(this.conf.StateDirectory)
+	if err != nil {
+		return fail(err)
+	}
+
+	l := opts.GetLogger(this.logger)
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		directory := filepath.Join(this.conf.StateDirectory, entry.Name())
+		sl := l.With("sandbox", directory)
+
+		state, err := this.readState(directory)
+		if err != nil {
+			sl.WithError(err).
+				Warn("sandbox does have an illegal state; this warn message will appear again until this is fixed; skipping...")
+			continue
+		}
+		if state == nil {
+			sl.Warnf("sandbox does not have a %s file; this warn message will appear again until this is fixed; skipping...", sandboxStateFilename)
+			continue
+		}
+
+		if ok, err := this.isOrphan(state, sandboxContext{entry.Name(), state}, sl, opts); err != nil {
+			return fail(err)
+		} else if !ok {
+			continue
+		}
+
+		if err := sandbox.Stop(directory, state.Pid); err != nil {
+			sl.WithError(err).
+				Warn("cannot stop orphan sandbox; this message might continue appearing until manually fixed; skipping...")
+			continue
+		}
+		sl.Info("orphan sandbox stopped")
+	}
+
+	return nil
+}
+
+func (this *SandboxRepository) isOrphan(state *sandboxState, context any, l log.Logger, opts *CleanupOpts) (bool, error) {
+	flow := state.Flow
+	if flow.IsZero() {
+		l.Warn("sandbox does not have a flow; this warn message will appear again until this is fixed; skipping...")
+		return false, nil
+	}
+
+	l = l.With("flow", flow)
+
+	if flow.IsEqualTo(this.flow) {
+		l.Debug("found sandbox that is owned by this flow environment; ignoring...")
+		return false, nil
+	}
+
+	globalHasFlow, err := opts.HasFlowOfName(flow)
+	if err != nil {
+		return false, err
+	}
+
+	if globalHasFlow {
+		l.Debug("found sandbox that is owned by another environment; ignoring...")
+		return false, nil
+	}
+
+	shouldBeCleaned, err := this.conf.CleanOrphan.Render(context)
+	if err != nil {
+		return false, err
+	}
+
+	if !shouldBeCleaned {
+		l.Debug("found sandbox that isn't owned by anybody, but should be kept; ignoring...")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (this *SandboxRepository) logger() log.Logger {
+	if v := this.Logger; v != nil {
+		return v
+	}
+	return log.GetLogger("sandbox-repository")
+}
+
+type sandboxContext struct {
+	name  string
+	state *sandboxState
+}
+
+func (this sandboxContext) GetField(name string) (any, bool, error) {
+	switch name {
+	case "id":
+		return this.state.SessionId.String(), true, nil
+	case "image":
+		return this.state.RootFilesystem, true, nil
+	case "name":
+		return this.name, true, nil
+	case "flow":
+		if this.state.Flow.IsZero() {
+			return nil, true, nil
+		}
+		return this.state.Flow, true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
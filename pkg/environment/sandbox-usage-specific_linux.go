@@ -0,0 +1,344 @@
+//go:build linux
+
+package environment
+
+import (
+	"context"
+	"io"
+	gonet "net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/creack/pty"
+	log "github.com/echocat/slf4g"
+	"github.com/echocat/slf4g/level"
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/sandbox"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/ssh"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+func (this *sandboxEnvironment) Banner(req Request) (io.ReadCloser, error) {
+	b, err := this.repository.conf.Banner.Render(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(strings.NewReader(b)), nil
+}
+
+func (this *sandboxEnvironment) Run(t Task) (exitCode int, rErr error) {
+	fail := func(err error) (int, error) {
+		return -1, err
+	}
+	failf := func(msg string, args ...any) (int, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	auth := t.Authorization()
+	sess := auth.FindSession()
+	if sess == nil {
+		return failf("authorization without session is not supported to run sandbox environment")
+	}
+	sshSess := t.SshSession()
+	l := t.Connection().Logger()
+
+	req := sandbox.ExecuteRequest{
+		Dir:          this.workingDir,
+		ConnectionId: t.Connection().Id(),
+	}
+	req.User, req.Group, _ = strings.Cut(this.user, ":")
+
+	ev := sys.EnvVars{}
+	if v, ok := os.LookupEnv("TZ"); ok {
+		ev.Set("TZ", v)
+	}
+	ev.AddAllOf(t.Authorization().EnvVars())
+	ev.Add(t.SshSession().Environ()...)
+	ev.Set(session.EnvName, sess.Id().String())
+
+	binding := connectionBindingOf(t, this.terminalsPersistent, false)
+	if binding.connectionId {
+		ev.Set(connection.EnvName, t.Connection().Id().String())
+	}
+
+	switch t.TaskType() {
+	case TaskTypeShell:
+		if v := sshSess.RawCommand(); len(v) > 0 {
+			req.Argv = append(slices.Clone(this.execCommand), v)
+		} else {
+			req.Argv = slices.Clone(this.shellCommand)
+		}
+	case TaskTypeSftp:
+		if len(this.sftpCommand) == 0 {
+			return failf("sftp is not supported by this sandbox environment")
+		}
+		req.Argv = slices.Clone(this.sftpCommand)
+	default:
+		return failf("illegal task type: %v", t.TaskType())
+	}
+
+	if binding.agent {
+		ln, err := this.impSession.InitiateNamedPipe(t.Context(), t.Connection().Id(), "ssh-agent")
+		var re errors.RemoteError
+		if errors.As(err, &re) {
+			l.WithError(err).Warn("it was not possible to initiate named pipe for agent; agent deactivated")
+		} else if err != nil {
+			return fail(err)
+		} else {
+			defer common.IgnoreCloseError(ln)
+			go ssh.ForwardAgentConnections(ln, l, sshSess)
+			ev.Set(ssh.AuthSockEnvName, ln.Path())
+		}
+	}
+
+	if binding.persistentTerminal {
+		ptyReq, _, _ := sshSess.Pty()
+		ev.Set("TERM", ptyReq.Term)
+		start := imp.TerminalStart{
+			Argv:  req.Argv,
+			Dir:   req.Dir,
+			Env:   ev,
+			User:  req.User,
+			Group: req.Group,
+		}
+		return runPersistentTerminal(t, this.impSession, &this.repository.conf.Terminals, start)
+	}
+
+	ptyReq, winCh, isPty := sshSess.Pty()
+	if isPty {
+		ev.Set("TERM", ptyReq.Term)
+		req.Tty = true
+		req.Width, req.Height = 80, 40
+		if ptyReq.Window.Width > 0 && ptyReq.Window.Height > 0 {
+			req.Width, req.Height = uint16(ptyReq.Window.Width), uint16(ptyReq.Window.Height)
+		}
+	}
+	req.Env = ev
+
+	type doneT struct {
+		exitCode int
+		err      error
+	}
+	signals := make(chan glssh.Signal, 1)
+	processDone := make(chan doneT, 1)
+	inputDone := make(chan error, 1)
+	var outputs, activeRoutines sync.WaitGroup
+	defer func() {
+		go func() {
+			activeRoutines.Wait()
+			defer close(signals)
+			defer close(inputDone)
+			defer close(processDone)
+		}()
+	}()
+
+	doCopy := func(from io.Reader, to io.Writer, name string, done chan<- error) {
+		defer activeRoutines.Done()
+		_, err := io.Copy(to, from)
+		if done != nil {
+			if this.isRelevantError(err) {
+				done <- err
+			} else {
+				done <- nil
+			}
+		} else if this.isRelevantError(err) {
+			l.WithError(err).Debugf("cannot copy %s", name)
+		}
+		l.Tracef("finished copy %s", name)
+	}
+
+	var execution *sandbox.Execution
+	if isPty {
+		var err error
+		if execution, err = sandbox.Execute(t.Context(), this.directory, req, nil, nil, nil); err != nil {
+			return fail(err)
+		}
+		defer common.IgnoreCloseError(execution)
+		fPty := execution.Pty()
+
+		go func() {
+			for {
+				win, ok := <-winCh
+				if !ok {
+					return
+				}
+				size := pty.Winsize{Rows: uint16(win.Height), Cols: uint16(win.Width)}
+				if err := pty.Setsize(fPty, &size); err != nil {
+					l.WithError(err).Warn("cannot set winsize; ignoring")
+				}
+			}
+		}()
+
+		outputs.Add(1)
+		activeRoutines.Add(1)
+		go func() {
+			defer outputs.Done()
+			doCopy(fPty, sshSess, "pty -> ssh", nil)
+		}()
+		activeRoutines.Add(1)
+		go doCopy(sshSess, fPty, "ssh -> pty", inputDone)
+	} else {
+		var stderr io.Writer = sshSess.Stderr()
+		if t.TaskType() == TaskTypeSftp {
+			stderr = &log.LoggingWriter{
+				Logger:         l,
+				LevelExtractor: level.FixedLevelExtractor(level.Error),
+			}
+		}
+
+		var pipes [3][2]*os.File
+		defer func() {
+			for _, p := range pipes {
+				for _, f := range p {
+					if f != nil {
+						_ = f.Close()
+					}
+				}
+			}
+		}()
+		for i := range pipes {
+			var err error
+			if pipes[i][0], pipes[i][1], err = os.Pipe(); err != nil {
+				return failf("cannot create pipe: %w", err)
+			}
+		}
+
+		var err error
+		if execution, err = sandbox.Execute(t.Context(), this.directory, req, pipes[0][0], pipes[1][1], pipes[2][1]); err != nil {
+			return fail(err)
+		}
+		defer common.IgnoreCloseError(execution)
+		// Only the process inside the sandbox should hold these ends now;
+		// otherwise we will never see EOF on its output.
+		for _, f := range []**os.File{&pipes[0][0], &pipes[1][1], &pipes[2][1]} {
+			_ = (*f).Close()
+			*f = nil
+		}
+
+		stdin := pipes[0][1]
+		activeRoutines.Add(1)
+		go func() {
+			doCopy(sshSess, stdin, "ssh -> stdin", inputDone)
+			_ = stdin.Close()
+		}()
+		outputs.Add(2)
+		activeRoutines.Add(2)
+		go func() {
+			defer outputs.Done()
+			doCopy(pipes[1][0], sshSess, "stdout -> ssh", nil)
+		}()
+		go func() {
+			defer outputs.Done()
+			doCopy(pipes[2][0], stderr, "stderr -> ssh", nil)
+		}()
+	}
+	l.With("pid", execution.Pid()).
+		Debug("user's process started")
+
+	activeRoutines.Add(1)
+	go func() {
+		defer activeRoutines.Done()
+		ec, err := execution.Wait()
+		processDone <- doneT{ec, err}
+		l.Trace("finished process")
+	}()
+
+	sshSess.Signals(signals)
+	for {
+		select {
+		case s, ok := <-signals:
+			if ok {
+				this.signal(execution, l, s)
+			}
+		case <-t.Context().Done():
+			return -2, rErr
+		case status, ok := <-processDone:
+			if ok {
+				if status.err != nil {
+					return -1, status.err
+				}
+				// Ensure everything the process has written reaches the
+				// client before we report its end.
+				outputs.Wait()
+				return status.exitCode, nil
+			}
+		case err, ok := <-inputDone:
+			if ok && err != nil && rErr == nil {
+				return -1, err
+			}
+		}
+	}
+}
+
+func (this *sandboxEnvironment) signal(execution *sandbox.Execution, logger log.Logger, sshSignal glssh.Signal) {
+	var signal sys.Signal
+	if err := signal.Set(string(sshSignal)); err != nil {
+		signal = sys.SIGKILL
+	}
+
+	if err := execution.Signal(signal); err != nil && !sys.IsClosedError(err) {
+		logger.WithError(err).
+			With("signal", signal).
+			Warn("cannot send signal to process")
+	}
+}
+
+func (this *sandboxEnvironment) IsPortForwardingAllowed(_ net.HostPort) (bool, error) {
+	return this.portForwardingAllowed, nil
+}
+
+func (this *sandboxEnvironment) NewDestinationConnection(ctx context.Context, dest net.HostPort) (io.ReadWriteCloser, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	return this.impSession.InitiateTcpForward(ctx, connId, dest)
+}
+
+func (this *sandboxEnvironment) NewRemoteListener(ctx context.Context, bind net.HostPort) (RemoteListener, error) {
+	if !this.portForwardingAllowed {
+		return nil, errors.Newf(errors.Permission, "port forwarding not allowed")
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := this.impSession.InitiateTcpListen(ctx, connId, bind)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *sandboxEnvironment) IsSocketForwardingAllowed(string) (bool, error) {
+	return false, nil
+}
+
+func (this *sandboxEnvironment) NewDestinationSocketConnection(context.Context, string) (io.ReadWriteCloser, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by sandbox environments")
+}
+
+func (this *sandboxEnvironment) NewRemoteSocketListener(context.Context, string) (gonet.Listener, error) {
+	return nil, errors.Newf(errors.Permission, "socket forwarding is not supported by sandbox environments")
+}
+
+func (this *sandboxEnvironment) IsX11ForwardingAllowed() (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,189 @@
+//go:build linux
+
+package environment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	gonet "net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/echocat/slf4g"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/crypto"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/sandbox"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+type sandboxEnvironment struct {
+	repository *SandboxRepository
+
+	directory string
+	pid       int
+	sessionId session.Id
+
+	remoteUser string
+	remoteHost net.Host
+
+	shellCommand []string
+	execCommand  []string
+	sftpCommand  []string
+	user         string
+	workingDir   string
+
+	portForwardingAllowed bool
+	terminalsPersistent   bool
+
+	impSession imp.Session
+
+	owners atomic.Int32
+}
+
+func (this *sandboxEnvironment) SessionId() session.Id {
+	return this.sessionId
+}
+
+func (this *sandboxEnvironment) PublicKey() crypto.PublicKey {
+	return nil
+}
+
+func (this *sandboxEnvironment) Dial(ctx context.Context) (gonet.Conn, error) {
+	return this.repository.rawDialer.DialContext(ctx, "unix", sandbox.ImpSocketOf(this.directory))
+}
+
+func (this *SandboxRepository) new(ctx context.Context, directory string, state *sandboxState, logger log.Logger) (*sandboxEnvironment, error) {
+	fail := func(err error) (*sandboxEnvironment, error) {
+		return nil, errors.System.Newf("cannot create environment from sandbox %s of flow %v: %w", directory, this.flow, err)
+	}
+
+	result := &sandboxEnvironment{
+		repository: this,
+		directory:  directory,
+	}
+	if err := result.parseState(state); err != nil {
+		return fail(err)
+	}
+	var err error
+	if result.impSession, err = this.imp.Open(ctx, result); err != nil {
+		return fail(err)
+	}
+
+	connId, err := connection.NewId()
+	if err != nil {
+		return fail(err)
+	}
+
+	for try := 1; try <= 200; try++ {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+
+		if err := result.impSession.Ping(ctx, connId); err == nil {
+			break
+		} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			// try waiting...
+		} else {
+			return fail(err)
+		}
+		if !sandbox.IsRunning(directory, result.pid) {
+			return fail(fmt.Errorf("%w: sandbox died while waiting for its imp", containerContainsProblemsErr))
+		}
+		l := logger.With("try", try)
+		if try <= 2 {
+			l.Debug("waiting for sandbox's imp getting ready...")
+		} else {
+			l.Info("still waiting for sandbox's imp getting ready...")
+		}
+
+		if err := common.Sleep(ctx, 500*time.Millisecond); err != nil {
+			return fail(err)
+		}
+	}
+
+	result.owners.Add(1)
+
+	return result, nil
+}
+
+func (this *sandboxEnvironment) Dispose(context.Context) (_ bool, rErr error) {
+	fail := func(err error) (bool, error) {
+		return false, errors.Newf(errors.System, "cannot dispose environment: %w", err)
+	}
+
+	defer this.repository.sessionIdMutex.Lock(this.sessionId)()
+	defer common.KeepError(&rErr, this.closeGuarded)
+
+	running := sandbox.IsRunning(this.directory, this.pid)
+	if err := sandbox.Stop(this.directory, this.pid); err != nil {
+		return fail(err)
+	}
+
+	return running, nil
+}
+
+func (this *sandboxEnvironment) Close() (rErr error) {
+	defer this.repository.sessionIdMutex.Lock(this.sessionId)()
+
+	return this.closeGuarded()
+}
+
+func (this *sandboxEnvironment) closeGuarded() error {
+	if this.owners.Add(-1) > 0 {
+		return nil
+	}
+	this.repository.activeInstances.Delete(this.sessionId)
+	return nil
+}
+
+func (this *sandboxEnvironment) isRelevantError(err error) bool {
+	return err != nil && !errors.Is(err, syscall.EIO) && !sys.IsClosedError(err)
+}
+
+func (this *sandboxEnvironment) parseState(state *sandboxState) error {
+	fail := func(err error) error {
+		return fmt.Errorf("%w: %v", containerContainsProblemsErr, err)
+	}
+	failf := func(msg string, args ...any) error {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	if !state.Flow.IsEqualTo(this.repository.flow) {
+		return failf("expected flow: %v; but sandbox had: %v", this.repository.flow, state.Flow)
+	}
+	if state.SessionId.IsZero() {
+		return failf("missing session id")
+	}
+	this.sessionId = state.SessionId
+	this.pid = state.Pid
+
+	this.remoteUser = state.RemoteUser
+	if err := this.remoteHost.Set(state.RemoteHost); err != nil {
+		return failf("cannot decode remote host: %w", err)
+	}
+
+	if len(state.ShellCommand) == 0 {
+		return failf("missing shell command")
+	}
+	this.shellCommand = state.ShellCommand
+	if len(state.ExecCommand) == 0 {
+		return failf("missing exec command")
+	}
+	this.execCommand = state.ExecCommand
+	this.sftpCommand = state.SftpCommand
+
+	this.user = state.User
+	this.workingDir = state.Directory
+	this.portForwardingAllowed = state.PortForwardingAllowed
+	this.terminalsPersistent = state.TerminalsPersistent
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package environment
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/imp"
+	"github.com/engity-com/bifroest/pkg/ssh"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	// TerminalEnvName is the name of the environment variable a client can
+	// send to select the terminal to attach to without being asked. It is
+	// either the ID of an existing terminal or TerminalEnvValueNew.
+	TerminalEnvName = "BIFROEST_TERMINAL"
+
+	// TerminalEnvValueNew as value of TerminalEnvName always starts a new
+	// terminal.
+	TerminalEnvValueNew = "new"
+)
+
+// isPersistentTerminalTask returns true if the given Task should be run
+// inside a persistent terminal, if these are enabled for the environment.
+func isPersistentTerminalTask(t Task) bool {
+	if t.TaskType() != TaskTypeShell {
+		return false
+	}
+	sshSess := t.SshSession()
+	if len(sshSess.RawCommand()) > 0 {
+		return false
+	}
+	_, _, isPty := sshSess.Pty()
+	return isPty
+}
+
+// connectionBinding decides which resources, that belong only to the
+// connection of a Task, are passed to the process of the Task.
+type connectionBinding struct {
+	// persistentTerminal is true if the Task runs inside a persistent
+	// terminal (see runPersistentTerminal).
+	persistentTerminal bool
+
+	// connectionId is true if connection.EnvName should be set.
+	connectionId bool
+
+	// agent is true if the agent of the connection should be forwarded
+	// (ssh.AuthSockEnvName).
+	agent bool
+
+	// x11 is the X11 display of the connection which should be forwarded
+	// (ssh.DisplayEnvName and ssh.XAuthorityEnvName), if any.
+	x11 *ssh.X11Forwarding
+}
+
+// connectionBindingOf returns the connectionBinding of the given Task.
+// Persistent terminals are not bound to the connection which started them,
+// because they survive it and can be attached by other connections.
+// Therefore, they never get its ID, its forwarded agent or its X11 display.
+func connectionBindingOf(t Task, terminalsPersistent, x11ForwardingAllowed bool) connectionBinding {
+	if terminalsPersistent && isPersistentTerminalTask(t) {
+		return connectionBinding{persistentTerminal: true}
+	}
+	result := connectionBinding{
+		connectionId: true,
+		agent:        ssh.AgentRequested(t.SshSession()),
+	}
+	if x11ForwardingAllowed {
+		result.x11 = ssh.X11Requested(t.SshSession())
+	}
+	return result
+}
+
+// runPersistentTerminal runs the shell of the given Task inside a terminal
+// which is managed by the imp of the environment. If the connection ends, the
+// terminal will survive and can be attached again by another connection of
+// the same session.
+func runPersistentTerminal(t Task, impSession imp.Session, conf *configuration.EnvironmentTerminals, start imp.TerminalStart) (exitCode int, rErr error) {
+	fail := func(err error) (int, error) {
+		return -1, err
+	}
+	failf := func(msg string, args ...any) (int, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	sshSess := t.SshSession()
+	connId := t.Connection().Id()
+	l := t.Connection().Logger()
+
+	ptyReq, winCh, _ := sshSess.Pty()
+	req := imp.TerminalAttach{
+		Width:  uint16(ptyReq.Window.Width),
+		Height: uint16(ptyReq.Window.Height),
+	}
+
+	id, err := selectPersistentTerminal(t, impSession)
+	if errors.Is(err, ssh.ErrTerminalLineCanceled) {
+		return 130, nil
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if id.IsZero() {
+		start.IdleTimeout = conf.IdleTimeout.Native()
+		start.Scrollback = conf.Scrollback
+		req.Start = &start
+	} else {
+		req.Id = id
+	}
+
+	term, err := impSession.AttachTerminal(t.Context(), connId, req)
+	if errors.Is(err, imp.ErrNoSuchTerminal) {
+		_, _ = fmt.Fprintf(sshSess, "Terminal %v does not exist (anymore).\r\n", id)
+		return 1, nil
+	}
+	if err != nil {
+		return failf("cannot attach terminal: %w", err)
+	}
+	defer func() {
+		if err := term.Close(); err != nil && !sys.IsClosedError(err) && rErr == nil {
+			rErr = err
+		}
+	}()
+	l = l.With("terminal", term.Info().Id)
+	l.Debug("terminal attached")
+
+	outputDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(sshSess, term)
+		outputDone <- err
+	}()
+	go func() {
+		// Will end, once the connection or the terminal is closed.
+		_, _ = io.Copy(term, sshSess)
+	}()
+
+	signals := make(chan glssh.Signal, 1)
+	sshSess.Signals(signals)
+	defer sshSess.Signals(nil)
+
+	for {
+		select {
+		case win, ok := <-winCh:
+			if !ok {
+				winCh = nil
+			} else if err := term.Resize(uint16(win.Width), uint16(win.Height)); err != nil {
+				l.WithError(err).Warn("cannot set window size; ignoring")
+			}
+		case s := <-signals:
+			var signal sys.Signal
+			if err := signal.Set(string(s)); err != nil {
+				l.WithError(err).With("signal", s).Debug("unsupported signal; ignoring")
+			} else if err := term.Signal(signal); err != nil {
+				l.WithError(err).With("signal", signal).Warn("cannot send signal to terminal; ignoring")
+			}
+		case <-t.Context().Done():
+			l.Debug("connection ended; terminal detached")
+			return -2, nil
+		case err := <-outputDone:
+			if errors.Is(err, imp.ErrTerminalDetached) {
+				_, _ = fmt.Fprintf(sshSess, "\r\n[Terminal %v was attached by another connection.]\r\n", term.Info().Id)
+				return 0, nil
+			}
+			if err != nil && !sys.IsClosedError(err) {
+				return failf("cannot read from terminal %v: %w", term.Info().Id, err)
+			}
+			if ec, ok := term.ExitCode(); ok {
+				return ec, nil
+			}
+			return -2, nil
+		}
+	}
+}
+
+// selectPersistentTerminal returns the ID of the terminal to attach to or 0 if
+// a new one should be started. If not selected by the client (see
+// TerminalEnvName) and terminals are available, the user will be asked.
+func selectPersistentTerminal(t Task, impSession imp.Session) (imp.TerminalId, error) {
+	fail := func(err error) (imp.TerminalId, error) {
+		return 0, err
+	}
+
+	sshSess := t.SshSession()
+
+	var ev sys.EnvVars
+	ev.Add(sshSess.Environ()...)
+	if v := ev[TerminalEnvName]; v == TerminalEnvValueNew {
+		return 0, nil
+	} else if v != "" {
+		var result imp.TerminalId
+		if err := result.Set(v); err != nil {
+			return fail(errors.User.Newf("illegal value of environment variable %s: %w", TerminalEnvName, err))
+		}
+		return result, nil
+	}
+
+	candidates, err := impSession.ListTerminals(t.Context(), t.Connection().Id())
+	if err != nil {
+		return fail(errors.System.Newf("cannot list terminals: %w", err))
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	var buf strings.Builder
+	buf.WriteString("Running terminals of this session:\r\n")
+	now := time.Now()
+	for _, c := range candidates {
+		state := "attached"
+		if !c.Attached {
+			state = "detached " + now.Sub(c.Detached).Truncate(time.Second).String() + " ago"
+		}
+		_, _ = fmt.Fprintf(&buf, "  %3v) %s (started %s, %s)\r\n", c.Id, strings.Join(c.Command, " "), c.Started.Format(time.DateTime), state)
+	}
+	buf.WriteString("Select terminal to attach to or press enter to start a new one: ")
+	if _, err := io.WriteString(sshSess, buf.String()); err != nil {
+		return fail(err)
+	}
+
+	for {
+		line, err := ssh.ReadTerminalLine(sshSess, sshSess)
+		if err != nil {
+			return fail(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line == TerminalEnvValueNew {
+			return 0, nil
+		}
+		var result imp.TerminalId
+		if err := result.Set(line); err == nil {
+			return result, nil
+		}
+		if _, err := io.WriteString(sshSess, "Illegal terminal; try again: "); err != nil {
+			return fail(err)
+		}
+	}
+}
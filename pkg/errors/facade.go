@@ -17,3 +17,8 @@ func As(err error, target any) bool {
 func Unwrap(err error) error {
 	return errors.Unwrap(err)
 }
+
+// Join just a facade for errors.Join
+func Join(errs ...error) error {
+	return errors.Join(errs...)
+}
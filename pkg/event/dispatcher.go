@@ -0,0 +1,89 @@
+package event
+
+import (
+	"context"
+	"io"
+
+	log "github.com/echocat/slf4g"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+)
+
+// Dispatcher delivers Event instances to all configured sinks.
+type Dispatcher interface {
+	// IsInterestedIn returns true if at least one sink would receive an
+	// Event of the given type and flow. This can be used to prevent
+	// expensive preparation of events nobody will receive.
+	IsInterestedIn(configuration.EventType, configuration.FlowName) bool
+
+	// Emit hands the given Event over to all interested sinks. It does not
+	// block; the actual delivery happens asynchronously.
+	Emit(Event)
+}
+
+type CloseableDispatcher interface {
+	Dispatcher
+	io.Closer
+}
+
+// NewDispatcher creates a new CloseableDispatcher based on the given
+// configuration.Events.
+func NewDispatcher(ctx context.Context, conf *configuration.Events, logger log.Logger) (CloseableDispatcher, error) {
+	fail := func(err error) (CloseableDispatcher, error) {
+		return nil, err
+	}
+
+	if logger == nil {
+		logger = log.GetLogger("events")
+	}
+
+	result := &dispatcher{}
+	success := false
+	defer common.IgnoreCloseErrorIfFalse(&success, result)
+
+	var sp *spool
+	if v := conf.Spool; v != "" {
+		sp = &spool{v}
+	}
+
+	for i := range conf.Webhooks {
+		sink, err := newWebhookSink(ctx, &conf.Webhooks[i], sp, logger)
+		if err != nil {
+			return fail(err)
+		}
+		result.webhooks = append(result.webhooks, sink)
+	}
+
+	success = true
+	return result, nil
+}
+
+type dispatcher struct {
+	webhooks []*webhookSink
+}
+
+func (this *dispatcher) IsInterestedIn(t configuration.EventType, flow configuration.FlowName) bool {
+	for _, sink := range this.webhooks {
+		if sink.matches(t, flow) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *dispatcher) Emit(e Event) {
+	for _, sink := range this.webhooks {
+		if sink.matches(e.Type, e.Flow) {
+			sink.enqueue(e)
+		}
+	}
+}
+
+func (this *dispatcher) Close() (rErr error) {
+	for _, sink := range this.webhooks {
+		//goland:noinspection GoDeferInLoop
+		defer common.KeepCloseError(&rErr, sink)
+	}
+	return nil
+}
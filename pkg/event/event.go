@@ -0,0 +1,76 @@
+package event
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+)
+
+// Event is a lifecycle event of the service which is delivered to all
+// interested sinks of a Dispatcher.
+type Event struct {
+	Id         string                  `json:"id"`
+	Type       configuration.EventType `json:"type"`
+	Time       time.Time               `json:"time"`
+	Flow       configuration.FlowName  `json:"flow,omitempty"`
+	Session    string                  `json:"session,omitempty"`
+	Connection string                  `json:"connection,omitempty"`
+	Remote     string                  `json:"remote,omitempty"`
+
+	// Attributes contains additional information which are specific to the
+	// Type, like the command of EventTypeCommandStarted.
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// New creates a new Event of the given type which occurred now.
+func New(t configuration.EventType) Event {
+	return Event{
+		Id:   uuid.New().String(),
+		Type: t,
+		Time: time.Now(),
+	}
+}
+
+// With returns a copy of this Event with the given attribute set.
+func (this Event) With(key string, value any) Event {
+	attrs := make(map[string]any, len(this.Attributes)+1)
+	for k, v := range this.Attributes {
+		attrs[k] = v
+	}
+	attrs[key] = value
+	this.Attributes = attrs
+	return this
+}
+
+func (this Event) GetField(name string) (any, bool, error) {
+	switch name {
+	case "id":
+		return this.Id, true, nil
+	case "type":
+		return this.Type, true, nil
+	case "time":
+		return this.Time, true, nil
+	case "flow":
+		return this.Flow, true, nil
+	case "session":
+		return this.Session, true, nil
+	case "connection":
+		return this.Connection, true, nil
+	case "remote":
+		return this.Remote, true, nil
+	case "attributes":
+		return this.Attributes, true, nil
+	default:
+		if v, ok := this.Attributes[name]; ok {
+			return v, true, nil
+		}
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
+
+func (this Event) String() string {
+	return this.Type.String() + ":" + this.Id
+}
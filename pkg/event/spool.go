@@ -0,0 +1,111 @@
+package event
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	spoolFileSuffix = ".json"
+)
+
+// delivery is one rendered request of a sink. It is stored as it is inside
+// the spool, if it cannot be delivered (yet).
+type delivery struct {
+	Id       string            `json:"id"`
+	Event    string            `json:"event"`
+	Created  time.Time         `json:"created"`
+	Method   string            `json:"method"`
+	Url      string            `json:"url"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     []byte            `json:"body,omitempty"`
+	Attempts uint              `json:"attempts"`
+}
+
+// spool stores deliveries of sinks inside a directory (one subdirectory per
+// sink) until they can be delivered.
+type spool struct {
+	directory string
+}
+
+func (this *spool) sinkDirectory(sink string) string {
+	return filepath.Join(this.directory, sink)
+}
+
+func (this *spool) store(sink string, d *delivery) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot store delivery %s of sink %q into spool: %w", d.Id, sink, err)
+	}
+
+	dir := this.sinkDirectory(sink)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fail(err)
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return fail(err)
+	}
+
+	// The name starts with the creation time to deliver older entries first.
+	fn := filepath.Join(dir, d.Created.UTC().Format("20060102T150405.000000000")+"-"+d.Id+spoolFileSuffix)
+	fnBuf := fn + "~"
+	if err := os.WriteFile(fnBuf, b, 0600); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(fnBuf, fn); err != nil {
+		_ = os.Remove(fnBuf)
+		return fail(err)
+	}
+	return nil
+}
+
+// list returns the files of all spooled deliveries of the given sink, oldest
+// first.
+func (this *spool) list(sink string) ([]string, error) {
+	entries, err := os.ReadDir(this.sinkDirectory(sink))
+	if sys.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.System.Newf("cannot list spool of sink %q: %w", sink, err)
+	}
+	var result []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolFileSuffix) {
+			continue
+		}
+		result = append(result, filepath.Join(this.sinkDirectory(sink), entry.Name()))
+	}
+	slices.Sort(result)
+	return result, nil
+}
+
+func (this *spool) load(fn string) (_ *delivery, rErr error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, errors.System.Newf("cannot open spooled delivery %q: %w", fn, err)
+	}
+	defer common.KeepCloseError(&rErr, f)
+
+	var result delivery
+	if err := json.NewDecoder(f).Decode(&result); err != nil {
+		return nil, errors.System.Newf("cannot decode spooled delivery %q: %w", fn, err)
+	}
+	return &result, nil
+}
+
+func (this *spool) remove(fn string) error {
+	if err := os.Remove(fn); err != nil && !sys.IsNotExist(err) {
+		return errors.System.Newf("cannot remove spooled delivery %q: %w", fn, err)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	"github.com/google/uuid"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/tracing"
+)
+
+const (
+	webhookQueueSize     = 256
+	webhookSpoolInterval = time.Minute
+	webhookCloseTimeout  = 5 * time.Second
+)
+
+type webhookSink struct {
+	conf   *configuration.EventWebhook
+	spool  *spool
+	logger log.Logger
+	client *http.Client
+	queue  chan *delivery
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newWebhookSink(_ context.Context, conf *configuration.EventWebhook, sp *spool, logger log.Logger) (*webhookSink, error) {
+	result := &webhookSink{
+		conf:   conf,
+		spool:  sp,
+		logger: logger.With("webhook", conf.Name),
+		client: &http.Client{
+			Transport: tracing.WrapTransport(http.DefaultTransport),
+		},
+		queue: make(chan *delivery, webhookQueueSize),
+		done:  make(chan struct{}),
+	}
+	// The sink lives as long as it is not closed; independent of the context it was created in.
+	result.ctx, result.cancel = context.WithCancel(context.Background())
+
+	go result.run()
+
+	return result, nil
+}
+
+func (this *webhookSink) matches(t configuration.EventType, flow configuration.FlowName) bool {
+	if !this.conf.Types.Matches(t) {
+		return false
+	}
+	return flow.IsZero() || this.conf.Flow.MatchString(flow.String())
+}
+
+func (this *webhookSink) enqueue(e Event) {
+	d, err := this.render(e)
+	if err != nil {
+		this.logger.
+			WithError(err).
+			With("event", e).
+			Error("cannot render event; dropping it")
+		return
+	}
+
+	select {
+	case this.queue <- d:
+	default:
+		this.toSpool(d, errors.System.Newf("queue is full"))
+	}
+}
+
+func (this *webhookSink) render(e Event) (*delivery, error) {
+	fail := func(err error) (*delivery, error) {
+		return nil, errors.Config.Newf("cannot render event %v for webhook %q: %w", e, this.conf.Name, err)
+	}
+
+	body, err := this.conf.Payload.Render(e)
+	if err != nil {
+		return fail(err)
+	}
+
+	headers := make(map[string]string, len(this.conf.Headers)+1)
+	if v := this.conf.ContentType; v != "" {
+		headers["Content-Type"] = v
+	}
+	for k, tmpl := range this.conf.Headers {
+		if headers[k], err = tmpl.Render(e); err != nil {
+			return fail(err)
+		}
+	}
+
+	return &delivery{
+		Id:      uuid.New().String(),
+		Event:   e.String(),
+		Created: e.Time,
+		Method:  this.conf.Method,
+		Url:     this.conf.Url,
+		Headers: headers,
+		Body:    []byte(body),
+	}, nil
+}
+
+func (this *webhookSink) run() {
+	defer close(this.done)
+
+	ticker := time.NewTicker(webhookSpoolInterval)
+	defer ticker.Stop()
+
+	this.flushSpool()
+	for {
+		select {
+		case d := <-this.queue:
+			this.deliverWithRetries(d)
+		case <-ticker.C:
+			this.flushSpool()
+		case <-this.ctx.Done():
+			return
+		}
+	}
+}
+
+func (this *webhookSink) deliverWithRetries(d *delivery) {
+	delay := this.conf.RetryDelay.Native()
+	for {
+		err := this.deliver(this.ctx, d)
+		if err == nil {
+			return
+		}
+		if this.ctx.Err() != nil || d.Attempts > uint(this.conf.Retries) {
+			this.toSpool(d, err)
+			return
+		}
+
+		this.logger.
+			WithError(err).
+			With("delivery", d.Id).
+			With("event", d.Event).
+			With("attempts", d.Attempts).
+			With("retryIn", delay).
+			Debug("cannot deliver event; retrying...")
+
+		select {
+		case <-time.After(delay):
+		case <-this.ctx.Done():
+			this.toSpool(d, err)
+			return
+		}
+		delay *= 2
+	}
+}
+
+func (this *webhookSink) deliver(ctx context.Context, d *delivery) (rErr error) {
+	fail := func(err error) error {
+		return errors.Network.Newf("cannot deliver event %s to webhook %q: %w", d.Event, this.conf.Name, err)
+	}
+
+	d.Attempts++
+
+	if v := this.conf.Timeout; !v.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.Native())
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, d.Method, d.Url, bytes.NewReader(d.Body))
+	if err != nil {
+		return fail(err)
+	}
+	for k, v := range d.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseError(resp.Body)
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fail(errors.Network.Newf("unexpected status %d", resp.StatusCode))
+	}
+
+	this.logger.
+		With("delivery", d.Id).
+		With("event", d.Event).
+		With("status", resp.StatusCode).
+		Trace("event delivered")
+	return nil
+}
+
+// flushSpool tries to deliver all spooled deliveries of this sink. It stops
+// at the first failed delivery to keep the order.
+func (this *webhookSink) flushSpool() {
+	if this.spool == nil {
+		return
+	}
+
+	fns, err := this.spool.list(this.conf.Name)
+	if err != nil {
+		this.logger.WithError(err).Warn("cannot flush spool")
+		return
+	}
+
+	for _, fn := range fns {
+		d, err := this.spool.load(fn)
+		if err != nil {
+			this.logger.WithError(err).Warn("spooled delivery is broken; removing it...")
+			if err := this.spool.remove(fn); err != nil {
+				this.logger.WithError(err).Warn("cannot remove broken spooled delivery")
+				return
+			}
+			continue
+		}
+		if err := this.deliver(this.ctx, d); err != nil {
+			this.logger.
+				WithError(err).
+				With("delivery", d.Id).
+				With("event", d.Event).
+				Debug("cannot deliver spooled event; will try again later")
+			return
+		}
+		if err := this.spool.remove(fn); err != nil {
+			this.logger.WithError(err).Warn("cannot remove delivered event from spool")
+			return
+		}
+	}
+}
+
+func (this *webhookSink) toSpool(d *delivery, cause error) {
+	l := this.logger.
+		WithError(cause).
+		With("delivery", d.Id).
+		With("event", d.Event).
+		With("attempts", d.Attempts)
+
+	if this.spool == nil {
+		l.Warn("cannot deliver event; dropping it as no spool is configured")
+		return
+	}
+	if err := this.spool.store(this.conf.Name, d); err != nil {
+		l.With("spoolError", err).Error("cannot deliver event nor store it in spool; dropping it")
+		return
+	}
+	l.Info("cannot deliver event; stored it in spool for later delivery")
+}
+
+// Close stops this sink. All deliveries which are still queued are tried once
+// more and are stored in the spool if this fails.
+func (this *webhookSink) Close() error {
+	this.cancel()
+	<-this.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookCloseTimeout)
+	defer cancel()
+	for {
+		select {
+		case d := <-this.queue:
+			if err := this.deliver(ctx, d); err != nil {
+				this.toSpool(d, err)
+			}
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package event
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/echocat/slf4g/sdk/testlog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+func newTestWebhookConfiguration(t *testing.T, url string) configuration.EventWebhook {
+	var result configuration.EventWebhook
+	require.NoError(t, result.SetDefaults())
+	result.Name = "test"
+	result.Url = url
+	result.Flow = common.MustNewRegexp(`^prod-.*$`)
+	result.Types = configuration.EventTypes{configuration.EventTypeCommandStarted}
+	result.Payload = template.MustNewString(`{{.type}} {{.flow}} {{.command}}`)
+	result.Retries = 0
+	return result
+}
+
+func TestWebhookSink_delivers(t *testing.T) {
+	testlog.Hook(t)
+
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received <- r.Header.Get("Content-Type") + "|" + string(b)
+	}))
+	defer srv.Close()
+
+	conf := configuration.Events{Webhooks: configuration.EventWebhooks{newTestWebhookConfiguration(t, srv.URL)}}
+	instance, err := NewDispatcher(context.Background(), &conf, nil)
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(instance)
+
+	require.True(t, instance.IsInterestedIn(configuration.EventTypeCommandStarted, "prod-a"))
+	require.False(t, instance.IsInterestedIn(configuration.EventTypeCommandStarted, "dev-a"))
+	require.False(t, instance.IsInterestedIn(configuration.EventTypeCommandEnded, "prod-a"))
+
+	e := New(configuration.EventTypeCommandStarted).With("command", "ls")
+	e.Flow = "prod-a"
+	instance.Emit(e)
+
+	select {
+	case actual := <-received:
+		require.Equal(t, "application/json|commandStarted prod-a ls", actual)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
+
+func TestWebhookSink_spools(t *testing.T) {
+	testlog.Hook(t)
+
+	var available atomic.Bool
+	var delivered atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered.Add(1)
+	}))
+	defer srv.Close()
+
+	sp := &spool{t.TempDir()}
+	wConf := newTestWebhookConfiguration(t, srv.URL)
+	sink, err := newWebhookSink(context.Background(), &wConf, sp, testlog.NewLogger(t))
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(sink)
+
+	e := New(configuration.EventTypeCommandStarted).With("command", "ls")
+	e.Flow = "prod-a"
+	sink.enqueue(e)
+
+	require.Eventually(t, func() bool {
+		fns, err := sp.list("test")
+		require.NoError(t, err)
+		return len(fns) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	available.Store(true)
+	sink.flushSpool()
+
+	require.Equal(t, int32(1), delivered.Load())
+	fns, err := sp.list("test")
+	require.NoError(t, err)
+	require.Empty(t, fns)
+}
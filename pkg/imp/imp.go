@@ -15,10 +15,17 @@ const (
 
 	DefaultInitPathUnix    = `/var/lib/engity/bifroest/init`
 	DefaultInitPathWindows = `C:\ProgramData\Engity\Bifroest\init`
+
+	// StateDirectoryUnix is the directory inside the environment where the
+	// imp and bifroest exec store their state, like the exit codes of the
+	// executed processes. It has to be writable.
+	StateDirectoryUnix = `/var/lib/engity/bifroest`
 )
 
 var (
-	ErrNoSuchProcess = protocol.ErrNoSuchProcess
+	ErrNoSuchProcess    = protocol.ErrNoSuchProcess
+	ErrNoSuchTerminal   = protocol.ErrNoSuchTerminal
+	ErrTerminalDetached = protocol.ErrTerminalDetached
 )
 
 type Ref interface {
@@ -1,6 +1,7 @@
 package imp
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
@@ -12,6 +13,9 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -29,6 +33,7 @@ import (
 	"github.com/engity-com/bifroest/pkg/crypto"
 	"github.com/engity-com/bifroest/pkg/net"
 	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/ssh"
 	"github.com/engity-com/bifroest/pkg/sys"
 )
 
@@ -229,6 +234,224 @@ func runRoundtripMaster(t *testing.T, impPreparation func(crypto.PublicKey, sess
 		common.SleepSilently(ctx, time.Millisecond*100)
 	})
 
+	t.Run("tcp-listen", func(t *testing.T) {
+		testlog.Hook(t)
+		connId, err := connection.NewId()
+		require.NoError(t, err)
+
+		ln, err := sess.InitiateTcpListen(ctx, connId, net.HostPort{Host: net.Host{IP: gonet.IPv4(127, 0, 0, 1)}})
+		require.NoError(t, err)
+		defer common.IgnoreCloseError(ln)
+		require.NotEqual(t, uint16(0), ln.Addr().Port)
+
+		clientDone := make(chan struct{})
+		defer func() { <-clientDone }()
+		go func() {
+			defer close(clientDone)
+			client, err := gonet.Dial("tcp", gonet.JoinHostPort("127.0.0.1", strconv.Itoa(int(ln.Addr().Port))))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer common.IgnoreCloseError(client)
+			_, err = client.Write([]byte("ping"))
+			assert.NoError(t, err)
+			b, err := io.ReadAll(client)
+			assert.NoError(t, err)
+			assert.Equal(t, "pong", string(b))
+		}()
+
+		accepted, origin, err := ln.Accept()
+		require.NoError(t, err)
+		defer common.IgnoreCloseError(accepted)
+		assert.Equal(t, "127.0.0.1", origin.Host.String())
+
+		b := make([]byte, 4)
+		_, err = io.ReadFull(accepted, b)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(b))
+		_, err = accepted.Write([]byte("pong"))
+		require.NoError(t, err)
+		require.NoError(t, accepted.Close())
+
+		common.SleepSilently(ctx, time.Millisecond*100)
+	})
+
+	if runtime.GOOS != "windows" {
+		t.Run("stream-local", func(t *testing.T) {
+			testlog.Hook(t)
+			connId, err := connection.NewId()
+			require.NoError(t, err)
+
+			dir, err := os.MkdirTemp("", "bifroest-imp-")
+			require.NoError(t, err)
+			defer func() { _ = os.RemoveAll(dir) }()
+			path := filepath.Join(dir, "test.sock")
+			allowedPaths := []string{filepath.ToSlash(dir) + "/*.sock"}
+
+			ln, err := sess.InitiateStreamLocalListen(ctx, connId, path, allowedPaths)
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(ln)
+
+			clientDone := make(chan struct{})
+			defer func() { <-clientDone }()
+			go func() {
+				defer close(clientDone)
+				client, err := sess.InitiateStreamLocalForward(ctx, connId, path, allowedPaths)
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer common.IgnoreCloseError(client)
+				_, err = client.Write([]byte("ping"))
+				assert.NoError(t, err)
+				b := make([]byte, 4)
+				_, err = io.ReadFull(client, b)
+				assert.NoError(t, err)
+				assert.Equal(t, "pong", string(b))
+			}()
+
+			accepted, err := ln.Accept()
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(accepted)
+
+			b := make([]byte, 4)
+			_, err = io.ReadFull(accepted, b)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(b))
+			_, err = accepted.Write([]byte("pong"))
+			require.NoError(t, err)
+			require.NoError(t, accepted.Close())
+
+			common.SleepSilently(ctx, time.Millisecond*100)
+		})
+
+		t.Run("stream-local-not-allowed", func(t *testing.T) {
+			testlog.Hook(t)
+			connId, err := connection.NewId()
+			require.NoError(t, err)
+
+			dir, err := os.MkdirTemp("", "bifroest-imp-")
+			require.NoError(t, err)
+			defer func() { _ = os.RemoveAll(dir) }()
+			otherDir, err := os.MkdirTemp("", "bifroest-imp-")
+			require.NoError(t, err)
+			defer func() { _ = os.RemoveAll(otherDir) }()
+			allowedPaths := []string{filepath.ToSlash(dir) + "/*.sock"}
+
+			other, err := gonet.Listen("unix", filepath.Join(otherDir, "other.sock"))
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(other)
+			require.NoError(t, os.Symlink(filepath.Join(otherDir, "other.sock"), filepath.Join(dir, "link.sock")))
+			require.NoError(t, os.Symlink(otherDir, filepath.Join(dir, "sub")))
+
+			_, err = sess.InitiateStreamLocalForward(ctx, connId, filepath.Join(otherDir, "other.sock"), allowedPaths)
+			require.ErrorContains(t, err, "not allowed")
+
+			_, err = sess.InitiateStreamLocalForward(ctx, connId, filepath.Join(dir, "link.sock"), allowedPaths)
+			require.ErrorContains(t, err, "not allowed")
+
+			_, err = sess.InitiateStreamLocalListen(ctx, connId, filepath.Join(dir, "sub", "new.sock"), []string{filepath.ToSlash(dir) + "/*/*"})
+			require.ErrorContains(t, err, "not allowed")
+		})
+
+		t.Run("x11-listen", func(t *testing.T) {
+			testlog.Hook(t)
+			connId, err := connection.NewId()
+			require.NoError(t, err)
+
+			cookie := []byte{1, 2, 3, 4}
+			ln, err := sess.InitiateX11Listen(ctx, connId, "MIT-MAGIC-COOKIE-1", cookie, "", "")
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(ln)
+			assert.GreaterOrEqual(t, ln.Display(), uint16(ssh.X11DisplayOffset))
+
+			var expectedAuthority bytes.Buffer
+			require.NoError(t, ssh.WriteXAuthority(&expectedAuthority, ln.Display(), "MIT-MAGIC-COOKIE-1", cookie))
+			actualAuthority, err := os.ReadFile(ln.Authority())
+			require.NoError(t, err)
+			assert.Equal(t, expectedAuthority.Bytes(), actualAuthority)
+
+			client, err := gonet.Dial("tcp", ln.Addr().String())
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(client)
+
+			accepted, err := ln.Accept()
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(accepted)
+			assert.Equal(t, client.LocalAddr().String(), accepted.RemoteAddr().String())
+
+			_, err = client.Write([]byte("ping"))
+			require.NoError(t, err)
+			b := make([]byte, 4)
+			_, err = io.ReadFull(accepted, b)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(b))
+
+			require.NoError(t, accepted.Close())
+			require.NoError(t, ln.Close())
+			require.Eventually(t, func() bool {
+				_, err := os.Stat(ln.Authority())
+				return os.IsNotExist(err)
+			}, time.Second*5, time.Millisecond*50)
+		})
+	}
+
+	if runtime.GOOS != "windows" {
+		t.Run("terminal", func(t *testing.T) {
+			testlog.Hook(t)
+			connId, err := connection.NewId()
+			require.NoError(t, err)
+
+			first, err := sess.AttachTerminal(ctx, connId, TerminalAttach{
+				Start: &TerminalStart{
+					Argv:       []string{"sh", "-c", `echo ready; read x; echo "got $x"; exit 4`},
+					Scrollback: 1024,
+				},
+				Width:  80,
+				Height: 24,
+			})
+			require.NoError(t, err)
+			id := first.Info().Id
+
+			readUntil := func(t *testing.T, r io.Reader, expected string) {
+				var buf []byte
+				b := make([]byte, 1024)
+				for !strings.Contains(string(buf), expected) {
+					n, err := r.Read(b)
+					require.NoError(t, err, "expected %q; but got: %q", expected, string(buf))
+					buf = append(buf, b[:n]...)
+				}
+			}
+			readUntil(t, first, "ready")
+			require.NoError(t, first.Close())
+
+			require.EventuallyWithT(t, func(t *assert.CollectT) {
+				candidates, err := sess.ListTerminals(ctx, connId)
+				assert.NoError(t, err)
+				if assert.Len(t, candidates, 1) {
+					assert.Equal(t, id, candidates[0].Id)
+					assert.False(t, candidates[0].Attached)
+				}
+			}, 10*time.Second, 50*time.Millisecond)
+
+			second, err := sess.AttachTerminal(ctx, connId, TerminalAttach{Id: id})
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(second)
+			readUntil(t, second, "ready")
+
+			_, err = second.Write([]byte("foo\n"))
+			require.NoError(t, err)
+			rest, err := io.ReadAll(second)
+			require.NoError(t, err)
+			assert.Contains(t, string(rest), "got foo")
+			exitCode, exited := second.ExitCode()
+			assert.True(t, exited)
+			assert.Equal(t, 4, exitCode)
+
+			_, err = sess.AttachTerminal(ctx, connId, TerminalAttach{Id: id})
+			require.ErrorIs(t, err, ErrNoSuchTerminal)
+		})
+	}
+
 	t.Run("named-pipe", func(t *testing.T) {
 		testlog.Hook(t)
 		connId, err := connection.NewId()
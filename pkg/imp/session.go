@@ -5,6 +5,7 @@ import (
 	"io"
 	gonet "net"
 
+	"github.com/engity-com/bifroest/internal/imp/protocol"
 	"github.com/engity-com/bifroest/pkg/connection"
 	"github.com/engity-com/bifroest/pkg/net"
 	"github.com/engity-com/bifroest/pkg/sys"
@@ -14,6 +15,28 @@ type Session interface {
 	io.Closer
 	Ping(ctx context.Context, connectionId connection.Id) error
 	InitiateTcpForward(ctx context.Context, connectionId connection.Id, target net.HostPort) (gonet.Conn, error)
+
+	// InitiateTcpListen listens at the given bind address inside the
+	// environment of the imp. If the port of bind is 0, a port is allocated
+	// dynamically; see TcpListener.Addr.
+	InitiateTcpListen(ctx context.Context, connectionId connection.Id, bind net.HostPort) (*TcpListener, error)
+
+	// InitiateStreamLocalForward connects to the unix socket at the given path
+	// inside the environment of the imp. The path is only accepted, if it
+	// matches one of allowedPaths after its symlinks were resolved (see
+	// net.ResolveAllowedSocketPath).
+	InitiateStreamLocalForward(ctx context.Context, connectionId connection.Id, path string, allowedPaths []string) (gonet.Conn, error)
+
+	// InitiateStreamLocalListen listens at a unix socket at the given path
+	// inside the environment of the imp. The path is checked like by
+	// InitiateStreamLocalForward.
+	InitiateStreamLocalListen(ctx context.Context, connectionId connection.Id, path string, allowedPaths []string) (*StreamLocalListener, error)
+
+	// InitiateX11Listen allocates a X11 display inside the environment of the
+	// imp, which can be accessed with the given cookie by the given user.
+	// If user is empty, the user of the imp itself is used.
+	InitiateX11Listen(ctx context.Context, connectionId connection.Id, authProtocol string, cookie []byte, user, group string) (*X11Listener, error)
+
 	InitiateNamedPipe(ctx context.Context, connectionId connection.Id, purpose net.Purpose) (net.NamedPipe, error)
 
 	// GetConnectionExitCode will return either the exitCode (if found)
@@ -26,4 +49,24 @@ type Session interface {
 	// If pid is 0, the process will be resolved by its connection.EnvVar that is matching the provided
 	// connectionId.
 	Kill(ctx context.Context, connectionId connection.Id, pid int, signal sys.Signal) error
+
+	// ListTerminals returns all terminals which are currently managed by the imp.
+	ListTerminals(ctx context.Context, connectionId connection.Id) ([]TerminalInfo, error)
+
+	// AttachTerminal attaches to an existing terminal or starts a new one (see
+	// TerminalAttach). If the requested terminal does not exist (anymore),
+	// [ErrNoSuchTerminal] is returned.
+	AttachTerminal(ctx context.Context, connectionId connection.Id, req TerminalAttach) (*Terminal, error)
 }
+
+type (
+	TerminalId     = protocol.TerminalId
+	TerminalInfo   = protocol.TerminalInfo
+	TerminalStart  = protocol.TerminalStart
+	TerminalAttach = protocol.TerminalAttach
+	Terminal       = protocol.MasterTerminal
+	TcpListener    = protocol.MasterTcpListener
+
+	StreamLocalListener = protocol.MasterStreamLocalListener
+	X11Listener         = protocol.MasterX11Listener
+)
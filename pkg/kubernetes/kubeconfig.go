@@ -16,6 +16,7 @@ import (
 
 	"github.com/engity-com/bifroest/pkg/errors"
 	"github.com/engity-com/bifroest/pkg/sys"
+	"github.com/engity-com/bifroest/pkg/tracing"
 )
 
 const (
@@ -243,6 +244,7 @@ func (this *Kubeconfig) getClient(contextName string) (*client, error) {
 	if err != nil {
 		return nil, err
 	}
+	restConfig.Wrap(tracing.WrapTransport)
 	return &client{
 		restConfig:  restConfig,
 		contextName: rc.CurrentContext,
@@ -50,6 +50,13 @@ func (this Address) String() string {
 	}
 }
 
+// IsLoopback returns true if this address is bound to a loopback interface
+// only and is therefore not reachable from other hosts.
+func (this Address) IsLoopback() bool {
+	v, ok := this.v.(*gonet.TCPAddr)
+	return ok && v.IP.IsLoopback()
+}
+
 func (this Address) Listen() (gonet.Listener, error) {
 	pv := this.v
 	if pv == nil {
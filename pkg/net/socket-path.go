@@ -0,0 +1,88 @@
+package net
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+// IsSocketPathAllowed checks if the given path of a unix socket matches at
+// least one of the given patterns (see path.Match), like /var/run/*.sock.
+// Relative paths are never allowed. The path is only checked lexically; use
+// ResolveAllowedSocketPath to get the path which can be safely dialed or
+// listened at.
+func IsSocketPathAllowed(patterns []string, candidate string) bool {
+	if !path.IsAbs(candidate) {
+		return false
+	}
+	candidate = path.Clean(candidate)
+
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, candidate); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveAllowedSocketPath resolves all symlinks of the given path of a unix
+// socket and returns the result, if both the given and the resolved path are
+// allowed by IsSocketPathAllowed. This prevents symlinks inside allowed
+// directories from pointing to sockets which are not allowed. If listen is
+// true, the socket does not need to exist yet and only its directory is
+// resolved. The directories of patterns are resolved, too; so a pattern like
+// /var/run/docker.sock still matches, if /var/run is a symlink to /run.
+func ResolveAllowedSocketPath(patterns []string, candidate string, listen bool) (string, error) {
+	if !IsSocketPathAllowed(patterns, candidate) {
+		return "", errors.Permission.Newf("socket path %s not allowed", candidate)
+	}
+	candidate = path.Clean(candidate)
+
+	resolved, err := resolveSocketPath(candidate, listen)
+	if err != nil {
+		return "", errors.Network.Newf("cannot resolve socket path %s: %w", candidate, err)
+	}
+	if !IsSocketPathAllowed(resolveSocketPathPatterns(patterns), resolved) {
+		return "", errors.Permission.Newf("socket path %s resolves to %s which is not allowed", candidate, resolved)
+	}
+	return resolved, nil
+}
+
+func resolveSocketPath(candidate string, listen bool) (string, error) {
+	if !listen {
+		result, err := filepath.EvalSymlinks(filepath.FromSlash(candidate))
+		if err != nil {
+			return "", err
+		}
+		return filepath.ToSlash(result), nil
+	}
+
+	dir, err := filepath.EvalSymlinks(filepath.FromSlash(path.Dir(candidate)))
+	if err != nil {
+		return "", err
+	}
+	return path.Join(filepath.ToSlash(dir), path.Base(candidate)), nil
+}
+
+// resolveSocketPathPatterns returns the given patterns plus the ones whose
+// directory (if it does not contain any wildcard) has its symlinks resolved.
+func resolveSocketPathPatterns(patterns []string) []string {
+	result := make([]string, 0, len(patterns)*2)
+	for _, pattern := range patterns {
+		result = append(result, pattern)
+		dir := path.Dir(pattern)
+		if !path.IsAbs(dir) || strings.ContainsAny(dir, `*?[\`) {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(filepath.FromSlash(dir))
+		if err != nil {
+			continue
+		}
+		if resolved = filepath.ToSlash(resolved); resolved != dir {
+			result = append(result, path.Join(resolved, path.Base(pattern)))
+		}
+	}
+	return result
+}
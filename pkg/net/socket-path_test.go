@@ -0,0 +1,125 @@
+package net
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSocketPathAllowed(t *testing.T) {
+	patterns := []string{"/var/run/docker.sock", "/run/postgresql/*"}
+
+	cases := []struct {
+		name     string
+		path     string
+		expected bool
+	}{{
+		name:     "exact",
+		path:     "/var/run/docker.sock",
+		expected: true,
+	}, {
+		name:     "wildcard",
+		path:     "/run/postgresql/.s.PGSQL.5432",
+		expected: true,
+	}, {
+		name:     "wildcard-does-not-cross-directories",
+		path:     "/run/postgresql/sub/.s.PGSQL.5432",
+		expected: false,
+	}, {
+		name:     "traversal",
+		path:     "/run/postgresql/../../etc/secret.sock",
+		expected: false,
+	}, {
+		name:     "relative",
+		path:     "var/run/docker.sock",
+		expected: false,
+	}, {
+		name:     "other",
+		path:     "/tmp/other.sock",
+		expected: false,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.expected, IsSocketPathAllowed(patterns, c.path))
+		})
+	}
+}
+
+func TestResolveAllowedSocketPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets and symlinks are not supported")
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	dir = filepath.ToSlash(dir)
+	require.NoError(t, os.Mkdir(dir+"/allowed", 0700))
+	require.NoError(t, os.Mkdir(dir+"/other", 0700))
+	require.NoError(t, os.WriteFile(dir+"/allowed/a.sock", nil, 0600))
+	require.NoError(t, os.WriteFile(dir+"/other/secret.sock", nil, 0600))
+	require.NoError(t, os.Symlink(dir+"/other/secret.sock", dir+"/allowed/link.sock"))
+	require.NoError(t, os.Symlink(dir+"/other", dir+"/allowed/sub.sock"))
+	require.NoError(t, os.Symlink(dir+"/allowed", dir+"/alias"))
+
+	cases := []struct {
+		name          string
+		patterns      []string
+		path          string
+		listen        bool
+		expected      string
+		expectedError string
+	}{{
+		name:     "exact",
+		patterns: []string{dir + "/allowed/*.sock"},
+		path:     dir + "/allowed/a.sock",
+		expected: dir + "/allowed/a.sock",
+	}, {
+		name:          "not-allowed",
+		patterns:      []string{dir + "/allowed/*.sock"},
+		path:          dir + "/other/secret.sock",
+		expectedError: "socket path " + dir + "/other/secret.sock not allowed",
+	}, {
+		name:          "symlink-to-other",
+		patterns:      []string{dir + "/allowed/*.sock"},
+		path:          dir + "/allowed/link.sock",
+		expectedError: "resolves to " + dir + "/other/secret.sock which is not allowed",
+	}, {
+		name:          "listen-in-symlinked-directory",
+		patterns:      []string{dir + "/allowed/*.sock/*"},
+		path:          dir + "/allowed/sub.sock/new.sock",
+		listen:        true,
+		expectedError: "resolves to " + dir + "/other/new.sock which is not allowed",
+	}, {
+		name:     "listen",
+		patterns: []string{dir + "/allowed/*.sock"},
+		path:     dir + "/allowed/new.sock",
+		listen:   true,
+		expected: dir + "/allowed/new.sock",
+	}, {
+		name:     "symlinked-directory-of-pattern",
+		patterns: []string{dir + "/alias/a.sock"},
+		path:     dir + "/alias/a.sock",
+		expected: dir + "/allowed/a.sock",
+	}, {
+		name:          "missing",
+		patterns:      []string{dir + "/allowed/*.sock"},
+		path:          dir + "/allowed/missing.sock",
+		expectedError: "cannot resolve socket path " + dir + "/allowed/missing.sock",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, actualErr := ResolveAllowedSocketPath(c.patterns, c.path, c.listen)
+			if c.expectedError != "" {
+				require.ErrorContains(t, actualErr, c.expectedError)
+			} else {
+				require.NoError(t, actualErr)
+				require.Equal(t, c.expected, actual)
+			}
+		})
+	}
+}
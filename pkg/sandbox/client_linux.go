@@ -0,0 +1,124 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	gonet "net"
+	"os"
+	"sync"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+// Execution is a process which runs inside a sandbox. See Execute.
+type Execution struct {
+	conn *gonet.UnixConn
+	pid  int
+	pty  *os.File
+
+	writeMutex sync.Mutex
+}
+
+// Execute executes a process as described by the given request inside the
+// sandbox of the given directory. If ExecuteRequest.Tty is false, the given
+// files will be used as stdin, stdout and stderr of the process. These files
+// can be closed after this method returns.
+func Execute(ctx context.Context, directory string, req ExecuteRequest, stdin, stdout, stderr *os.File) (_ *Execution, rErr error) {
+	fail := func(err error) (*Execution, error) {
+		return nil, errors.System.Newf("cannot execute %v inside sandbox %s: %w", req.Argv, directory, err)
+	}
+	failf := func(msg string, args ...any) (*Execution, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	var dialer gonet.Dialer
+	plainConn, err := dialer.DialContext(ctx, "unixpacket", controlSocketOf(directory))
+	if err != nil {
+		return fail(err)
+	}
+	conn := plainConn.(*gonet.UnixConn)
+	success := false
+	defer common.IgnoreCloseErrorIfFalse(&success, conn)
+
+	var files []*os.File
+	if !req.Tty {
+		files = []*os.File{stdin, stdout, stderr}
+	}
+	if err := writeControlMessage(conn, &controlMessage{Request: &req}, files...); err != nil {
+		return fail(err)
+	}
+
+	resp, respFiles, err := readControlMessage(conn)
+	if err != nil {
+		return fail(err)
+	}
+	if resp.Error != "" {
+		closeFiles(respFiles)
+		return failf("%s", resp.Error)
+	}
+
+	result := &Execution{
+		conn: conn,
+		pid:  resp.Pid,
+	}
+	if req.Tty {
+		if len(respFiles) != 1 {
+			closeFiles(respFiles)
+			return failf("expected pty but got %d files", len(respFiles))
+		}
+		result.pty = respFiles[0]
+	} else {
+		closeFiles(respFiles)
+	}
+
+	success = true
+	return result, nil
+}
+
+// Pid returns the pid of the process inside the sandbox.
+func (this *Execution) Pid() int {
+	return this.pid
+}
+
+// Pty returns the master of the pseudo terminal of the process if
+// ExecuteRequest.Tty was requested.
+func (this *Execution) Pty() *os.File {
+	return this.pty
+}
+
+// Signal sends the given signal to the process.
+func (this *Execution) Signal(signal sys.Signal) error {
+	this.writeMutex.Lock()
+	defer this.writeMutex.Unlock()
+
+	return writeControlMessage(this.conn, &controlMessage{Signal: signal})
+}
+
+// Wait waits until the process ends and returns its exit code.
+func (this *Execution) Wait() (int, error) {
+	for {
+		msg, files, err := readControlMessage(this.conn)
+		if err != nil {
+			return -1, errors.System.Newf("cannot wait for process %d: %w", this.pid, err)
+		}
+		closeFiles(files)
+		if msg.Error != "" {
+			return -1, errors.System.Newf("process %d failed: %s", this.pid, msg.Error)
+		}
+		if msg.ExitCode != nil {
+			return *msg.ExitCode, nil
+		}
+	}
+}
+
+// Close releases all resources of this execution. If the process is still
+// running, it receives SIGHUP.
+func (this *Execution) Close() (rErr error) {
+	if v := this.pty; v != nil {
+		defer common.KeepCloseError(&rErr, v)
+	}
+	return this.conn.Close()
+}
@@ -0,0 +1,279 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+const (
+	// InitCommand is the command of the bifroest binary which initializes
+	// the sandbox. See Init and Serve.
+	InitCommand = "sandbox-init"
+
+	// stopTimeout is the duration a sandbox has to end after it received
+	// SIGKILL.
+	stopTimeout = 10 * time.Second
+)
+
+// StartOpts contains everything which is required to start a sandbox using
+// Start.
+type StartOpts struct {
+	// Directory is the directory on the host where the state of the
+	// sandbox will be stored.
+	Directory string
+
+	Spec      Spec
+	IdMapping IdMapping
+
+	// Env is the environment the init process of the sandbox is started
+	// with. It will be inherited by the imp.
+	Env []string
+
+	// Args are additional arguments (like logging flags) for the init
+	// command.
+	Args []string
+}
+
+// Start starts a new sandbox and returns the pid (on the host) of its init
+// process.
+func Start(opts StartOpts) (int, error) {
+	fail := func(err error) (int, error) {
+		return 0, errors.System.Newf("cannot start sandbox inside %s: %w", opts.Directory, err)
+	}
+	failf := func(msg string, args ...any) (int, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return failf("cannot resolve own executable: %w", err)
+	}
+
+	spec := opts.Spec
+	privileged := os.Geteuid() == 0
+	uid, gid := opts.IdMapping.HostUid, opts.IdMapping.HostGid
+	if !privileged {
+		uid, gid = uint32(os.Getuid()), uint32(os.Getgid())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Directory), 0700); err != nil {
+		return fail(err)
+	}
+	if err := ensureSearchable(filepath.Dir(opts.Directory)); err != nil {
+		return fail(err)
+	}
+	if err := mkdirOwnedBy(opts.Directory, 0700, uid, gid); err != nil {
+		return fail(err)
+	}
+	success := false
+	defer func() {
+		if !success {
+			_ = os.RemoveAll(opts.Directory)
+		}
+	}()
+
+	runDir := filepath.Join(opts.Directory, runDirectoryName)
+	rootDir := filepath.Join(opts.Directory, rootDirectoryName)
+	if err := mkdirOwnedBy(runDir, 0711, uid, gid); err != nil {
+		return fail(err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, binaryFilename), nil, 0755); err != nil {
+		return fail(err)
+	}
+	if err := mkdirOwnedBy(rootDir, 0755, uid, gid); err != nil {
+		return fail(err)
+	}
+	if spec.Overlay {
+		if err := mkdirOwnedBy(filepath.Join(opts.Directory, upperDirectoryName), 0755, uid, gid); err != nil {
+			return fail(err)
+		}
+		if err := mkdirOwnedBy(filepath.Join(opts.Directory, workDirectoryName), 0700, uid, gid); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Overlayfs refuses to use a directory as lower layer inside a user
+	// namespace if it contains further mounts (like the root of the host
+	// does). Therefore, we have to prepare the overlay here, if we're
+	// allowed to.
+	if spec.Overlay && privileged {
+		if err := mountOverlay(spec.RootFilesystem, opts.Directory, rootDir, false); err != nil {
+			return fail(err)
+		}
+		// The init process gets its own copy of this mount while it is
+		// cloned, so we can always remove it from the host afterward.
+		defer func() {
+			_ = unix.Unmount(rootDir, unix.MNT_DETACH)
+			_ = unix.Unmount(rootDir, unix.MNT_DETACH)
+		}()
+		spec.PreparedRoot = true
+	} else if spec.Overlay && filepath.Clean(spec.RootFilesystem) == "/" {
+		return failf("an overlay of the host's root filesystem requires to run as root; either run as root, disable the overlay or use another root filesystem")
+	}
+
+	encodedSpec, err := json.Marshal(spec)
+	if err != nil {
+		return fail(err)
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(opts.Directory, logFilename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseError(logFile)
+
+	cloneFlags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC)
+	if !spec.ShareHostNetwork {
+		cloneFlags |= syscall.CLONE_NEWNET
+	}
+	size := opts.IdMapping.Size
+	if !privileged {
+		size = 1
+	}
+
+	cmd := exec.Command(self, append([]string{InitCommand, opts.Directory}, opts.Args...)...)
+	cmd.Env = opts.Env
+	cmd.Dir = "/"
+	cmd.Stdin = bytes.NewReader(encodedSpec)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:     true,
+		Cloneflags: cloneFlags,
+		UidMappings: []syscall.SysProcIDMap{{
+			ContainerID: 0,
+			HostID:      int(uid),
+			Size:        int(size),
+		}},
+		GidMappings: []syscall.SysProcIDMap{{
+			ContainerID: 0,
+			HostID:      int(gid),
+			Size:        int(size),
+		}},
+		GidMappingsEnableSetgroups: privileged,
+		// The init process has to become root inside the sandbox, because
+		// the user of Bifröst itself is not always mapped into it (see
+		// IdMapping).
+		Credential: &syscall.Credential{
+			Uid:         0,
+			Gid:         0,
+			NoSetGroups: !privileged,
+		},
+	}
+
+	if err := cmd.Start(); err != nil {
+		return failf("cannot start init process: %w", err)
+	}
+	// Reap the process if it ends while we're still running.
+	go func() { _ = cmd.Wait() }()
+
+	success = true
+	return cmd.Process.Pid, nil
+}
+
+// IsRunning reports whether the init process of the sandbox with the given
+// pid inside the given directory is still running.
+func IsRunning(directory string, pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return false
+	}
+	// Ensure that the pid was not reused by another process.
+	args := bytes.Split(bytes.TrimRight(b, "\x00"), []byte{0})
+	if len(args) < 3 {
+		return false
+	}
+	return string(args[1]) == InitCommand && string(args[2]) == directory
+}
+
+// Stop kills all processes of the sandbox and removes its state from the
+// given directory.
+func Stop(directory string, pid int) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot stop sandbox inside %s: %w", directory, err)
+	}
+
+	if IsRunning(directory, pid) {
+		// All other processes of the sandbox are killed by the kernel, once
+		// its init process (pid 1 inside the sandbox) dies.
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fail(err)
+		}
+		deadline := time.Now().Add(stopTimeout)
+		for IsRunning(directory, pid) {
+			if time.Now().After(deadline) {
+				return fail(errors.System.Newf("process %d is still running", pid))
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	if err := os.RemoveAll(directory); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// ImpSocketOf returns the location of the imp's socket of the sandbox with
+// the given directory on the host.
+func ImpSocketOf(directory string) string {
+	return filepath.Join(directory, runDirectoryName, impSocketFilename)
+}
+
+func controlSocketOf(directory string) string {
+	return filepath.Join(directory, runDirectoryName, controlSocketFilename)
+}
+
+func mkdirOwnedBy(path string, mode os.FileMode, uid, gid uint32) error {
+	if err := os.Mkdir(path, mode); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Chown(path, int(uid), int(gid))
+}
+
+// ensureSearchable ensures that the given directory and all of its parents
+// can be traversed by everyone, because the init process runs as the
+// (unprivileged) user the sandbox is mapped to.
+func ensureSearchable(path string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	for {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.Mode().Perm()&0001 == 0 {
+			if err := os.Chmod(path, fi.Mode()|0001); err != nil {
+				return err
+			}
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return nil
+		}
+		path = parent
+	}
+}
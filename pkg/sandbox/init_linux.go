@@ -0,0 +1,325 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+const (
+	// ServeFlag is the flag of the InitCommand which indicates that the init
+	// process was already prepared and should now Serve.
+	ServeFlag = "--serve"
+)
+
+var devicesToBind = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// Init prepares the sandbox of the given directory. It has to be called by
+// the init process of the sandbox as started by Start. The Spec is read from
+// the given reader. On success, it replaces the current process with the
+// given command, which has to call Serve.
+func Init(directory string, specReader io.Reader, args []string) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot initialize sandbox %s: %w", directory, err)
+	}
+
+	var spec Spec
+	if err := json.NewDecoder(specReader).Decode(&spec); err != nil {
+		return fail(errors.System.Newf("cannot decode spec: %w", err))
+	}
+
+	// Everything regarding the restrictions is bound to the current thread.
+	runtime.LockOSThread()
+
+	if err := prepareMounts(directory, &spec); err != nil {
+		return fail(err)
+	}
+	if v := spec.Hostname; v != "" {
+		if err := unix.Sethostname([]byte(v)); err != nil {
+			return fail(errors.System.Newf("cannot set hostname: %w", err))
+		}
+	}
+	if !spec.ShareHostNetwork {
+		if err := bringLoopbackUp(); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := installSeccompFilter(spec.DeniedSyscalls); err != nil {
+		return fail(err)
+	}
+	if err := dropCapabilities(spec.Capabilities); err != nil {
+		return fail(err)
+	}
+
+	if err := syscall.Exec(BinaryFileLocation, args, os.Environ()); err != nil {
+		return fail(errors.System.Newf("cannot execute %s: %w", BinaryFileLocation, err))
+	}
+	return nil
+}
+
+func prepareMounts(directory string, spec *Spec) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot prepare mounts: %w", err)
+	}
+
+	// Nothing we do here should ever be visible to the host.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fail(err)
+	}
+
+	root := filepath.Join(directory, rootDirectoryName)
+	switch {
+	case spec.PreparedRoot:
+		// The host has already mounted it for us, but as it was inherited
+		// from a more privileged mount namespace, it is locked. Binding it
+		// again creates a mount we're allowed to pivot to.
+		if err := unix.Mount(root, root, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fail(errors.System.Newf("cannot bind prepared root: %w", err))
+		}
+	case spec.Overlay:
+		if err := mountOverlay(spec.RootFilesystem, directory, root, true); err != nil {
+			return fail(err)
+		}
+	default:
+		if err := unix.Mount(spec.RootFilesystem, root, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fail(errors.System.Newf("cannot bind root filesystem %s: %w", spec.RootFilesystem, err))
+		}
+	}
+
+	for _, hidden := range spec.Hidden {
+		target := inRoot(root, hidden)
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			continue
+		}
+		if err := mountTmpfs(target, 0700); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := mountProc(root); err != nil {
+		return fail(err)
+	}
+	if err := mountSys(root, spec.ShareHostNetwork); err != nil {
+		return fail(err)
+	}
+	if err := mountDev(root); err != nil {
+		return fail(err)
+	}
+
+	for _, t := range spec.Tmpfs {
+		if err := mountTmpfs(inRoot(root, t.Target), t.Mode); err != nil {
+			return fail(err)
+		}
+	}
+	for _, m := range spec.Mounts {
+		if err := bindMount(m.Source, inRoot(root, m.Target), m.ReadOnly); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := bindMount(filepath.Join(directory, runDirectoryName), inRoot(root, RunDirectory), false); err != nil {
+		return fail(err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fail(errors.System.Newf("cannot resolve own executable: %w", err))
+	}
+	if err := bindMount(self, inRoot(root, BinaryFileLocation), true); err != nil {
+		return fail(err)
+	}
+
+	if err := pivotRoot(root); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+func mountProc(root string) error {
+	target := inRoot(root, "/proc")
+	if err := ensureMountTarget(target, true); err != nil {
+		return errors.System.Newf("cannot mount proc: %w", err)
+	}
+	if err := unix.Mount("proc", target, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return errors.System.Newf("cannot mount proc: %w", err)
+	}
+	return nil
+}
+
+func mountSys(root string, shareHostNetwork bool) error {
+	target := inRoot(root, "/sys")
+	if err := ensureMountTarget(target, true); err != nil {
+		return errors.System.Newf("cannot mount sys: %w", err)
+	}
+	// A fresh sysfs can only be mounted if we own the network namespace.
+	if !shareHostNetwork {
+		if err := unix.Mount("sysfs", target, "sysfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC|unix.MS_RDONLY, ""); err == nil {
+			return nil
+		}
+	}
+	return bindMount("/sys", target, true)
+}
+
+func mountDev(root string) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot prepare /dev: %w", err)
+	}
+
+	dev := inRoot(root, "/dev")
+	if err := mountTmpfs(dev, 0755); err != nil {
+		return fail(err)
+	}
+	// Device nodes cannot be created inside a user namespace, but we can
+	// bind the ones of the host.
+	for _, name := range devicesToBind {
+		if err := bindMount("/dev/"+name, filepath.Join(dev, name), false); err != nil {
+			return fail(err)
+		}
+	}
+
+	pts := filepath.Join(dev, "pts")
+	if err := os.Mkdir(pts, 0755); err != nil {
+		return fail(err)
+	}
+	if err := unix.Mount("devpts", pts, "devpts", unix.MS_NOSUID|unix.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		return fail(err)
+	}
+	if err := os.Mkdir(filepath.Join(dev, "shm"), 01777); err != nil {
+		return fail(err)
+	}
+
+	for name, target := range map[string]string{
+		"ptmx":   "pts/ptmx",
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return fail(err)
+		}
+	}
+	return nil
+}
+
+func pivotRoot(root string) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot pivot root to %s: %w", root, err)
+	}
+
+	if err := os.Chdir(root); err != nil {
+		return fail(err)
+	}
+	// Stacking the old root on top of the new root, allows us to remove it
+	// without requiring any temporary directory.
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fail(err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fail(err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+func bringLoopbackUp() error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot bring loopback interface up: %w", err)
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fail(err)
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return fail(err)
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fail(err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP | unix.IFF_RUNNING)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+func dropCapabilities(keep []string) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot drop capabilities: %w", err)
+	}
+
+	keepSet, err := parseCapabilities(keep)
+	if err != nil {
+		return fail(err)
+	}
+
+	last, err := lastCapability()
+	if err != nil {
+		return fail(err)
+	}
+	for c := 0; c <= last; c++ {
+		if keepSet[c] {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			return fail(errors.System.Newf("cannot drop capability %d: %w", c, err))
+		}
+	}
+	return nil
+}
+
+func lastCapability() (int, error) {
+	b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if os.IsNotExist(err) {
+		return unix.CAP_LAST_CAP, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	result, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, errors.System.Newf("cannot parse cap_last_cap: %w", err)
+	}
+	return result, nil
+}
+
+func installSeccompFilter(deniedSyscalls []string) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot install seccomp filter: %w", err)
+	}
+
+	if len(deniedSyscalls) == 0 {
+		return nil
+	}
+	program, err := newSeccompProgram(deniedSyscalls)
+	if err != nil {
+		return fail(err)
+	}
+
+	prog := unix.SockFprog{
+		Len:    uint16(len(program)),
+		Filter: &program[0],
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fail(errno)
+	}
+	return nil
+}
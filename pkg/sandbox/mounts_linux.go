@@ -0,0 +1,125 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+// mountOverlay mounts an overlay with the given lower directory to the given
+// target. The upper and work directories are located inside the given
+// directory.
+func mountOverlay(lower, directory, target string, insideUserNamespace bool) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot mount overlay of %s to %s: %w", lower, target, err)
+	}
+
+	if !insideUserNamespace {
+		// Ensure that the overlay is not propagated to other mount
+		// namespaces of the host.
+		if err := unix.Mount(target, target, "", unix.MS_BIND, ""); err != nil {
+			return fail(err)
+		}
+		if err := unix.Mount("", target, "", unix.MS_PRIVATE, ""); err != nil {
+			_ = unix.Unmount(target, unix.MNT_DETACH)
+			return fail(err)
+		}
+	}
+
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		escapeOverlayPath(lower),
+		escapeOverlayPath(filepath.Join(directory, upperDirectoryName)),
+		escapeOverlayPath(filepath.Join(directory, workDirectoryName)),
+	)
+	if insideUserNamespace {
+		opts += ",userxattr"
+	}
+	if err := unix.Mount("overlay", target, "overlay", 0, opts); err != nil {
+		if !insideUserNamespace {
+			_ = unix.Unmount(target, unix.MNT_DETACH)
+		}
+		return fail(err)
+	}
+	return nil
+}
+
+func escapeOverlayPath(in string) string {
+	return strings.NewReplacer(`\`, `\\`, `,`, `\,`, `:`, `\:`).Replace(in)
+}
+
+// bindMount binds the given source to the given target. If the target does
+// not exist, it will be created using the same type as the source.
+func bindMount(source, target string, readOnly bool) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot bind %s to %s: %w", source, target, err)
+	}
+
+	fi, err := os.Stat(source)
+	if err != nil {
+		return fail(err)
+	}
+	if err := ensureMountTarget(target, fi.IsDir()); err != nil {
+		return fail(err)
+	}
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fail(err)
+	}
+	if readOnly {
+		// Using mount_setattr(2) will keep all flags which are locked,
+		// because they were inherited from a less restricted mount
+		// namespace.
+		if err := unix.MountSetattr(-1, target, unix.AT_RECURSIVE, &unix.MountAttr{
+			Attr_set: unix.MOUNT_ATTR_RDONLY,
+		}); err != nil {
+			return fail(err)
+		}
+	}
+	return nil
+}
+
+func mountTmpfs(target string, mode uint32) error {
+	if err := ensureMountTarget(target, true); err != nil {
+		return errors.System.Newf("cannot mount tmpfs to %s: %w", target, err)
+	}
+	if err := unix.Mount("tmpfs", target, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, fmt.Sprintf("mode=%o", mode)); err != nil {
+		return errors.System.Newf("cannot mount tmpfs to %s: %w", target, err)
+	}
+	return nil
+}
+
+func ensureMountTarget(target string, isDir bool) (rErr error) {
+	if fi, err := os.Stat(target); err == nil {
+		if fi.IsDir() != isDir {
+			return errors.System.Newf("%s exists but has another type", target)
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if isDir {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer common.KeepCloseError(&rErr, f)
+	return nil
+}
+
+// inRoot returns the given path of the sandbox relative to the given root on
+// the host.
+func inRoot(root, path string) string {
+	return filepath.Join(root, filepath.Clean("/"+path))
+}
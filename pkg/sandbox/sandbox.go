@@ -0,0 +1,200 @@
+// Package sandbox runs processes inside their own set of Linux namespaces
+// (user, mount, pid, network and uts) without requiring any container
+// runtime.
+//
+// Each sandbox is represented by a directory on the host which contains its
+// state. The first process inside the sandbox (see Init) prepares the mounts,
+// restricts itself and afterward serves the imp and a control socket (see
+// Serve) which is used by the host to execute processes inside the sandbox.
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	// RunDirectory is the directory inside the sandbox where the run
+	// directory of the sandbox's state is mounted to.
+	RunDirectory = "/run/bifroest"
+
+	// BinaryFileLocation is the location of the bifroest binary inside the
+	// sandbox.
+	BinaryFileLocation = RunDirectory + "/bifroest"
+
+	// ImpSocketFileLocation is the location of the unix socket inside the
+	// sandbox where the imp is listening to.
+	ImpSocketFileLocation = RunDirectory + "/" + impSocketFilename
+
+	// ExitCodesDirectoryLocation is the location of the directory inside the
+	// sandbox where the exit codes of processes are stored in by their
+	// connection.Id.
+	ExitCodesDirectoryLocation = RunDirectory + "/exitcodes"
+
+	controlSocketFileLocation = RunDirectory + "/" + controlSocketFilename
+
+	impSocketFilename     = "imp.sock"
+	controlSocketFilename = "control.sock"
+	binaryFilename        = "bifroest"
+
+	runDirectoryName   = "run"
+	rootDirectoryName  = "root"
+	upperDirectoryName = "upper"
+	workDirectoryName  = "work"
+	logFilename        = "sandbox.log"
+)
+
+// Spec describes how a sandbox should look like.
+type Spec struct {
+	// RootFilesystem is the directory on the host which will become the root
+	// of the sandbox.
+	RootFilesystem string `json:"rootFilesystem"`
+
+	// Overlay mounts RootFilesystem as lower directory of an overlay, which
+	// means that all modifications are stored in the sandbox's state instead
+	// of RootFilesystem itself.
+	Overlay bool `json:"overlay,omitempty"`
+
+	// PreparedRoot indicates that the host has already mounted the root of
+	// the sandbox.
+	PreparedRoot bool `json:"preparedRoot,omitempty"`
+
+	Mounts []Mount `json:"mounts,omitempty"`
+	Tmpfs  []Tmpfs `json:"tmpfs,omitempty"`
+
+	// Hidden contains directories of the host which should not be visible
+	// inside the sandbox. These will be covered by an empty tmpfs.
+	Hidden []string `json:"hidden,omitempty"`
+
+	Hostname         string `json:"hostname,omitempty"`
+	ShareHostNetwork bool   `json:"shareHostNetwork,omitempty"`
+
+	// Capabilities contains all capabilities which should be kept. All
+	// others will be dropped.
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// DeniedSyscalls contains all syscalls which will fail with EPERM.
+	DeniedSyscalls []string `json:"deniedSyscalls,omitempty"`
+}
+
+// Mount is a bind mount of a path of the host into the sandbox.
+type Mount struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+// ParseMount parses a Mount of the format <source>:<target>[:ro|rw].
+func ParseMount(plain string) (Mount, error) {
+	fail := func(err error) (Mount, error) {
+		return Mount{}, errors.Config.Newf("illegal bind mount %q: %w", plain, err)
+	}
+	failf := func(msg string, args ...any) (Mount, error) {
+		return fail(fmt.Errorf(msg, args...))
+	}
+
+	parts := strings.Split(plain, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return failf("expected <source>:<target>[:ro|rw]")
+	}
+	result := Mount{
+		Source: parts[0],
+		Target: parts[1],
+	}
+	if !path.IsAbs(result.Source) {
+		return failf("source has to be an absolute path")
+	}
+	if !path.IsAbs(result.Target) {
+		return failf("target has to be an absolute path")
+	}
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "ro":
+			result.ReadOnly = true
+		case "rw":
+			result.ReadOnly = false
+		default:
+			return failf("unknown option %q", parts[2])
+		}
+	}
+	return result, nil
+}
+
+func (this Mount) String() string {
+	if this.ReadOnly {
+		return this.Source + ":" + this.Target + ":ro"
+	}
+	return this.Source + ":" + this.Target
+}
+
+// Tmpfs is an empty tmpfs mounted inside the sandbox.
+type Tmpfs struct {
+	Target string `json:"target"`
+	// Mode is the unix mode of the root directory of the tmpfs, like 01777.
+	Mode uint32 `json:"mode"`
+}
+
+// DefaultTmpfsMode is the mode of a Tmpfs if no mode was provided.
+const DefaultTmpfsMode = uint32(01777)
+
+// ParseTmpfs parses a Tmpfs of the format <target>[:<octal mode>].
+func ParseTmpfs(plain string) (Tmpfs, error) {
+	fail := func(err error) (Tmpfs, error) {
+		return Tmpfs{}, errors.Config.Newf("illegal tmpfs %q: %w", plain, err)
+	}
+	failf := func(msg string, args ...any) (Tmpfs, error) {
+		return fail(fmt.Errorf(msg, args...))
+	}
+
+	target, plainMode, hasMode := strings.Cut(plain, ":")
+	result := Tmpfs{
+		Target: target,
+		Mode:   DefaultTmpfsMode,
+	}
+	if !path.IsAbs(result.Target) {
+		return failf("target has to be an absolute path")
+	}
+	if hasMode {
+		v, err := strconv.ParseUint(plainMode, 8, 32)
+		if err != nil || v > 07777 {
+			return failf("illegal mode %q", plainMode)
+		}
+		result.Mode = uint32(v)
+	}
+	return result, nil
+}
+
+func (this Tmpfs) String() string {
+	return this.Target + ":" + strconv.FormatUint(uint64(this.Mode), 8)
+}
+
+// IdMapping defines how the root user and group inside the sandbox is
+// mapped to the host.
+type IdMapping struct {
+	HostUid uint32
+	HostGid uint32
+	Size    uint32
+}
+
+// ExecuteRequest describes a process which should be executed inside the
+// sandbox.
+type ExecuteRequest struct {
+	Argv         []string      `json:"argv"`
+	Env          sys.EnvVars   `json:"env,omitempty"`
+	Dir          string        `json:"dir,omitempty"`
+	User         string        `json:"user,omitempty"`
+	Group        string        `json:"group,omitempty"`
+	ConnectionId connection.Id `json:"connectionId"`
+
+	// Tty requests a new pseudo terminal inside the sandbox. Its master is
+	// provided by Execution.Pty.
+	Tty    bool   `json:"tty,omitempty"`
+	Width  uint16 `json:"width,omitempty"`
+	Height uint16 `json:"height,omitempty"`
+}
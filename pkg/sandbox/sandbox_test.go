@@ -0,0 +1,89 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMount(t *testing.T) {
+	cases := []struct {
+		in          string
+		expected    Mount
+		expectedErr string
+	}{{
+		in:       "/foo:/bar",
+		expected: Mount{Source: "/foo", Target: "/bar"},
+	}, {
+		in:       "/foo:/bar:ro",
+		expected: Mount{Source: "/foo", Target: "/bar", ReadOnly: true},
+	}, {
+		in:       "/foo:/bar:rw",
+		expected: Mount{Source: "/foo", Target: "/bar"},
+	}, {
+		in:          "/foo:/bar:xx",
+		expectedErr: `illegal bind mount "/foo:/bar:xx": unknown option "xx"`,
+	}, {
+		in:          "foo:/bar",
+		expectedErr: `illegal bind mount "foo:/bar": source has to be an absolute path`,
+	}, {
+		in:          "/foo:bar",
+		expectedErr: `illegal bind mount "/foo:bar": target has to be an absolute path`,
+	}, {
+		in:          "/foo",
+		expectedErr: `illegal bind mount "/foo": expected <source>:<target>[:ro|rw]`,
+	}, {
+		in:          "/foo:/bar:ro:rw",
+		expectedErr: `illegal bind mount "/foo:/bar:ro:rw": expected <source>:<target>[:ro|rw]`,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			actual, actualErr := ParseMount(c.in)
+			if expected := c.expectedErr; expected != "" {
+				require.EqualError(t, actualErr, expected)
+			} else {
+				require.NoError(t, actualErr)
+				require.Equal(t, c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	cases := []struct {
+		in          string
+		expected    Tmpfs
+		expectedErr string
+	}{{
+		in:       "/tmp",
+		expected: Tmpfs{Target: "/tmp", Mode: 01777},
+	}, {
+		in:       "/run:0755",
+		expected: Tmpfs{Target: "/run", Mode: 0755},
+	}, {
+		in:       "/secret:700",
+		expected: Tmpfs{Target: "/secret", Mode: 0700},
+	}, {
+		in:          "/run:0789",
+		expectedErr: `illegal tmpfs "/run:0789": illegal mode "0789"`,
+	}, {
+		in:          "/run:17777",
+		expectedErr: `illegal tmpfs "/run:17777": illegal mode "17777"`,
+	}, {
+		in:          "tmp",
+		expectedErr: `illegal tmpfs "tmp": target has to be an absolute path`,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			actual, actualErr := ParseTmpfs(c.in)
+			if expected := c.expectedErr; expected != "" {
+				require.EqualError(t, actualErr, expected)
+			} else {
+				require.NoError(t, actualErr)
+				require.Equal(t, c.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"runtime"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+const (
+	// seccompUnavailableSyscall marks a syscall which does not exist on the
+	// current architecture and therefore does not need to be denied.
+	seccompUnavailableSyscall = ^uint32(0)
+
+	// seccompX32SyscallBit marks syscalls of the x32 ABI on amd64.
+	seccompX32SyscallBit = 0x40000000
+
+	// Offsets of the fields inside struct seccomp_data.
+	seccompDataNrOffset   = 0
+	seccompDataArchOffset = 4
+)
+
+// newSeccompProgram creates a seccomp filter program which lets all of the
+// given syscalls fail with EPERM and allows all others.
+func newSeccompProgram(deniedSyscalls []string) ([]unix.SockFilter, error) {
+	fail := func(err error) ([]unix.SockFilter, error) {
+		return nil, err
+	}
+	failf := func(msg string, args ...any) ([]unix.SockFilter, error) {
+		return fail(errors.Config.Newf(msg, args...))
+	}
+
+	if seccompAuditArch == 0 {
+		return failf("denying syscalls is not supported on %s", runtime.GOARCH)
+	}
+
+	deny := bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)}
+	instructions := []bpf.Instruction{
+		// Processes of other architectures would otherwise be able to
+		// bypass the filter.
+		bpf.LoadAbsolute{Off: seccompDataArchOffset, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: seccompAuditArch, SkipTrue: 1},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_KILL_PROCESS},
+		bpf.LoadAbsolute{Off: seccompDataNrOffset, Size: 4},
+	}
+	if seccompX32Abi {
+		instructions = append(instructions,
+			bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: seccompX32SyscallBit, SkipFalse: 1},
+			deny,
+		)
+	}
+
+	for _, name := range deniedSyscalls {
+		nr, ok := seccompSyscalls[name]
+		if !ok {
+			return failf("unknown syscall %q", name)
+		}
+		if nr == seccompUnavailableSyscall {
+			continue
+		}
+		instructions = append(instructions,
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: nr, SkipFalse: 1},
+			deny,
+		)
+	}
+	instructions = append(instructions, bpf.RetConstant{Val: unix.SECCOMP_RET_ALLOW})
+
+	raws, err := bpf.Assemble(instructions)
+	if err != nil {
+		return fail(errors.System.Newf("cannot assemble seccomp filter: %w", err))
+	}
+	result := make([]unix.SockFilter, len(raws))
+	for i, raw := range raws {
+		result[i] = unix.SockFilter{
+			Code: raw.Op,
+			Jt:   raw.Jt,
+			Jf:   raw.Jf,
+			K:    raw.K,
+		}
+	}
+	return result, nil
+}
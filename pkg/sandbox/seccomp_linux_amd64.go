@@ -0,0 +1,76 @@
+//go:build linux && amd64
+
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const (
+	seccompAuditArch = unix.AUDIT_ARCH_X86_64
+	seccompX32Abi    = true
+)
+
+// seccompSyscalls contains all syscalls which are supported by
+// Spec.DeniedSyscalls on this architecture.
+var seccompSyscalls = map[string]uint32{
+	"acct":              unix.SYS_ACCT,
+	"add_key":           unix.SYS_ADD_KEY,
+	"bpf":               unix.SYS_BPF,
+	"chroot":            unix.SYS_CHROOT,
+	"clock_adjtime":     unix.SYS_CLOCK_ADJTIME,
+	"clock_settime":     unix.SYS_CLOCK_SETTIME,
+	"create_module":     unix.SYS_CREATE_MODULE,
+	"delete_module":     unix.SYS_DELETE_MODULE,
+	"finit_module":      unix.SYS_FINIT_MODULE,
+	"fanotify_init":     unix.SYS_FANOTIFY_INIT,
+	"fsconfig":          unix.SYS_FSCONFIG,
+	"fsmount":           unix.SYS_FSMOUNT,
+	"fsopen":            unix.SYS_FSOPEN,
+	"fspick":            unix.SYS_FSPICK,
+	"get_kernel_syms":   unix.SYS_GET_KERNEL_SYMS,
+	"init_module":       unix.SYS_INIT_MODULE,
+	"ioperm":            unix.SYS_IOPERM,
+	"iopl":              unix.SYS_IOPL,
+	"kcmp":              unix.SYS_KCMP,
+	"kexec_file_load":   unix.SYS_KEXEC_FILE_LOAD,
+	"kexec_load":        unix.SYS_KEXEC_LOAD,
+	"keyctl":            unix.SYS_KEYCTL,
+	"lookup_dcookie":    unix.SYS_LOOKUP_DCOOKIE,
+	"mbind":             unix.SYS_MBIND,
+	"migrate_pages":     unix.SYS_MIGRATE_PAGES,
+	"mknod":             unix.SYS_MKNOD,
+	"mknodat":           unix.SYS_MKNODAT,
+	"mount":             unix.SYS_MOUNT,
+	"mount_setattr":     unix.SYS_MOUNT_SETATTR,
+	"move_mount":        unix.SYS_MOVE_MOUNT,
+	"move_pages":        unix.SYS_MOVE_PAGES,
+	"name_to_handle_at": unix.SYS_NAME_TO_HANDLE_AT,
+	"nfsservctl":        unix.SYS_NFSSERVCTL,
+	"open_by_handle_at": unix.SYS_OPEN_BY_HANDLE_AT,
+	"open_tree":         unix.SYS_OPEN_TREE,
+	"perf_event_open":   unix.SYS_PERF_EVENT_OPEN,
+	"personality":       unix.SYS_PERSONALITY,
+	"pidfd_getfd":       unix.SYS_PIDFD_GETFD,
+	"pivot_root":        unix.SYS_PIVOT_ROOT,
+	"process_vm_readv":  unix.SYS_PROCESS_VM_READV,
+	"process_vm_writev": unix.SYS_PROCESS_VM_WRITEV,
+	"ptrace":            unix.SYS_PTRACE,
+	"quotactl":          unix.SYS_QUOTACTL,
+	"reboot":            unix.SYS_REBOOT,
+	"request_key":       unix.SYS_REQUEST_KEY,
+	"set_mempolicy":     unix.SYS_SET_MEMPOLICY,
+	"setdomainname":     unix.SYS_SETDOMAINNAME,
+	"sethostname":       unix.SYS_SETHOSTNAME,
+	"setns":             unix.SYS_SETNS,
+	"settimeofday":      unix.SYS_SETTIMEOFDAY,
+	"swapoff":           unix.SYS_SWAPOFF,
+	"swapon":            unix.SYS_SWAPON,
+	"_sysctl":           unix.SYS__SYSCTL,
+	"sysfs":             unix.SYS_SYSFS,
+	"syslog":            unix.SYS_SYSLOG,
+	"umount2":           unix.SYS_UMOUNT2,
+	"unshare":           unix.SYS_UNSHARE,
+	"uselib":            unix.SYS_USELIB,
+	"userfaultfd":       unix.SYS_USERFAULTFD,
+	"ustat":             unix.SYS_USTAT,
+	"vhangup":           unix.SYS_VHANGUP,
+}
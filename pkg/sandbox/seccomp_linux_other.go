@@ -0,0 +1,12 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+const (
+	// seccompAuditArch is zero, because seccomp filters are not supported on
+	// this architecture.
+	seccompAuditArch = 0
+	seccompX32Abi    = false
+)
+
+var seccompSyscalls = map[string]uint32{}
@@ -0,0 +1,99 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+)
+
+func TestNewSeccompProgram(t *testing.T) {
+	if seccompAuditArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+
+	vm := newSeccompTestVm(t, "mount", "unshare")
+
+	require.Equal(t, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM), vm.run(t, unix.SYS_MOUNT, seccompAuditArch))
+	require.Equal(t, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM), vm.run(t, unix.SYS_UNSHARE, seccompAuditArch))
+	require.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), vm.run(t, unix.SYS_GETPID, seccompAuditArch))
+	require.Equal(t, uint32(unix.SECCOMP_RET_KILL_PROCESS), vm.run(t, unix.SYS_GETPID, seccompAuditArch+1))
+	if seccompX32Abi {
+		require.Equal(t, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM), vm.run(t, seccompX32SyscallBit|unix.SYS_GETPID, seccompAuditArch))
+	}
+}
+
+func TestNewSeccompProgram_unknownSyscall(t *testing.T) {
+	if seccompAuditArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+
+	_, actualErr := newSeccompProgram([]string{"mount", "foo"})
+	require.EqualError(t, actualErr, `unknown syscall "foo"`)
+}
+
+func TestNewSeccompProgram_defaults(t *testing.T) {
+	if seccompAuditArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+
+	deniedSyscalls, err := configuration.DefaultEnvironmentSandboxDeniedSyscalls.Render(nil)
+	require.NoError(t, err)
+
+	_, actualErr := newSeccompProgram(deniedSyscalls)
+	require.NoError(t, actualErr)
+}
+
+func TestParseCapabilities(t *testing.T) {
+	actual, actualErr := parseCapabilities([]string{"CAP_CHOWN", "kill", "Net_Raw"})
+	require.NoError(t, actualErr)
+	require.Equal(t, map[int]bool{
+		unix.CAP_CHOWN:   true,
+		unix.CAP_KILL:    true,
+		unix.CAP_NET_RAW: true,
+	}, actual)
+
+	_, actualErr = parseCapabilities([]string{"CAP_FOO"})
+	require.EqualError(t, actualErr, `unknown capability "CAP_FOO"`)
+
+	defaults, err := configuration.DefaultEnvironmentSandboxCapabilities.Render(nil)
+	require.NoError(t, err)
+	_, actualErr = parseCapabilities(defaults)
+	require.NoError(t, actualErr)
+}
+
+type seccompTestVm struct {
+	*bpf.VM
+}
+
+func newSeccompTestVm(t *testing.T, deniedSyscalls ...string) seccompTestVm {
+	program, err := newSeccompProgram(deniedSyscalls)
+	require.NoError(t, err)
+
+	instructions := make([]bpf.Instruction, len(program))
+	for i, raw := range program {
+		instructions[i] = bpf.RawInstruction{Op: raw.Code, Jt: raw.Jt, Jf: raw.Jf, K: raw.K}.Disassemble()
+	}
+	vm, err := bpf.NewVM(instructions)
+	require.NoError(t, err)
+	return seccompTestVm{vm}
+}
+
+func (this seccompTestVm) run(t *testing.T, nr, arch uint32) uint32 {
+	// The VM always loads in network byte order, while the kernel uses the
+	// native one. So we simply provide the seccomp_data in network byte
+	// order.
+	data := make([]byte, 64)
+	binary.BigEndian.PutUint32(data[seccompDataNrOffset:], nr)
+	binary.BigEndian.PutUint32(data[seccompDataArchOffset:], arch)
+
+	result, err := this.Run(data)
+	require.NoError(t, err)
+	return uint32(result)
+}
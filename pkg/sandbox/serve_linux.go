@@ -0,0 +1,442 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	log "github.com/echocat/slf4g"
+	"golang.org/x/sys/unix"
+
+	"github.com/engity-com/bifroest/internal/imp/protocol"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	controlMessageMaxSize = 1024 * 1024
+)
+
+// Serve has to be called by the init process of the sandbox after it was
+// prepared by Init. It runs the imp and serves the control socket which is
+// used by Execute. It returns once the imp ends. The given args are passed
+// to the imp.
+func Serve(logger log.Logger, args []string) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot serve sandbox: %w", err)
+	}
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseError(devNull)
+
+	r := &reaper{
+		logger:  logger,
+		waiting: map[int]chan syscall.WaitStatus{},
+	}
+	go r.run()
+
+	impArgs := append([]string{
+		BinaryFileLocation,
+		"imp",
+		"--addr=" + protocol.UnixAddrPrefix + ImpSocketFileLocation,
+		"--exitCodeByConnectionIdPath=" + ExitCodesDirectoryLocation,
+	}, args...)
+	_, impDone, err := r.start(impArgs, &syscall.ProcAttr{
+		Dir:   "/",
+		Env:   os.Environ(),
+		Files: []uintptr{devNull.Fd(), os.Stdout.Fd(), os.Stderr.Fd()},
+	})
+	if err != nil {
+		return fail(errors.System.Newf("cannot start imp: %w", err))
+	}
+
+	_ = os.Remove(controlSocketFileLocation)
+	ln, err := gonet.ListenUnix("unixpacket", &gonet.UnixAddr{Name: controlSocketFileLocation, Net: "unixpacket"})
+	if err != nil {
+		return fail(err)
+	}
+	defer common.IgnoreCloseError(ln)
+	if err := os.Chmod(controlSocketFileLocation, 0600); err != nil {
+		return fail(err)
+	}
+
+	s := &server{
+		reaper: r,
+		logger: logger,
+	}
+	go s.serve(ln)
+
+	status := <-impDone
+	if code := exitCodeOf(status); code != 0 {
+		return fail(errors.System.Newf("imp ended with exit code %d", code))
+	}
+	return nil
+}
+
+// reaper is responsible to reap all processes of the sandbox, because we're
+// its init process. It also dispatches the status of the processes it knows.
+type reaper struct {
+	logger log.Logger
+
+	mutex   sync.Mutex
+	waiting map[int]chan syscall.WaitStatus
+}
+
+func (this *reaper) start(argv []string, attr *syscall.ProcAttr) (int, <-chan syscall.WaitStatus, error) {
+	// Holding the lock while forking ensures that run() cannot dispatch the
+	// status of this process before we've registered it.
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	pid, err := syscall.ForkExec(argv[0], argv, attr)
+	if err != nil {
+		return 0, nil, err
+	}
+	result := make(chan syscall.WaitStatus, 1)
+	this.waiting[pid] = result
+	return pid, result, nil
+}
+
+func (this *reaper) signal(pid int, signal syscall.Signal) error {
+	return this.signalPid(pid, pid, signal)
+}
+
+// signalGroup sends the given signal to the whole process group of the given
+// process. Every process started by start() leads its own group.
+func (this *reaper) signalGroup(pid int, signal syscall.Signal) error {
+	return this.signalPid(pid, -pid, signal)
+}
+
+func (this *reaper) signalPid(pid, target int, signal syscall.Signal) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// Never send a signal to a pid which was already reaped and could have
+	// been reused by another process.
+	if _, ok := this.waiting[pid]; !ok {
+		return os.ErrProcessDone
+	}
+	return syscall.Kill(target, signal)
+}
+
+func (this *reaper) run() {
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if errors.Is(err, syscall.ECHILD) {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			this.logger.WithError(err).Warn("cannot wait for child processes")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		this.mutex.Lock()
+		ch, ok := this.waiting[pid]
+		delete(this.waiting, pid)
+		this.mutex.Unlock()
+		if ok {
+			ch <- status
+		}
+	}
+}
+
+// controlMessage is exchanged between Execute and the server using the
+// control socket.
+type controlMessage struct {
+	Request  *ExecuteRequest `json:"request,omitempty"`
+	Pid      int             `json:"pid,omitempty"`
+	Signal   sys.Signal      `json:"signal,omitempty"`
+	ExitCode *int            `json:"exitCode,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func readControlMessage(conn *gonet.UnixConn) (*controlMessage, []*os.File, error) {
+	buf := make([]byte, controlMessageMaxSize)
+	oob := make([]byte, unix.CmsgSpace(3*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n == 0 {
+		return nil, nil, os.ErrClosed
+	}
+
+	var files []*os.File
+	if oobn > 0 {
+		scms, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, scm := range scms {
+			fds, err := unix.ParseUnixRights(&scm)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, fd := range fds {
+				files = append(files, os.NewFile(uintptr(fd), "fd"+strconv.Itoa(fd)))
+			}
+		}
+	}
+
+	var result controlMessage
+	if err := json.Unmarshal(buf[:n], &result); err != nil {
+		closeFiles(files)
+		return nil, nil, err
+	}
+	return &result, files, nil
+}
+
+func writeControlMessage(conn *gonet.UnixConn, msg *controlMessage, files ...*os.File) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var oob []byte
+	if len(files) > 0 {
+		fds := make([]int, len(files))
+		for i, f := range files {
+			fds[i] = int(f.Fd())
+		}
+		oob = unix.UnixRights(fds...)
+	}
+	_, _, err = conn.WriteMsgUnix(b, oob, nil)
+	return err
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		common.IgnoreCloseError(f)
+	}
+}
+
+type server struct {
+	reaper *reaper
+	logger log.Logger
+}
+
+func (this *server) serve(ln *gonet.UnixListener) {
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if !sys.IsClosedError(err) {
+				this.logger.WithError(err).Error("cannot accept control connection")
+			}
+			return
+		}
+		go this.handle(conn)
+	}
+}
+
+func (this *server) handle(conn *gonet.UnixConn) {
+	defer common.IgnoreCloseError(conn)
+
+	msg, files, err := readControlMessage(conn)
+	if err != nil {
+		this.logger.WithError(err).Warn("cannot read control request")
+		return
+	}
+	defer closeFiles(files)
+
+	req := msg.Request
+	if req == nil {
+		_ = writeControlMessage(conn, &controlMessage{Error: "no request provided"})
+		return
+	}
+	l := this.logger.
+		With("connectionId", req.ConnectionId).
+		With("command", req.Argv)
+
+	pid, done, ptyFile, err := this.execute(req, files)
+	if err != nil {
+		l.WithError(err).Warn("cannot execute process")
+		_ = writeControlMessage(conn, &controlMessage{Error: err.Error()})
+		return
+	}
+	l = l.With("pid", pid)
+	l.Debug("process started")
+
+	var responseFiles []*os.File
+	if ptyFile != nil {
+		defer common.IgnoreCloseError(ptyFile)
+		responseFiles = append(responseFiles, ptyFile)
+	}
+	if err := writeControlMessage(conn, &controlMessage{Pid: pid}, responseFiles...); err != nil {
+		l.WithError(err).Warn("cannot respond to control request")
+		_ = this.reaper.signal(pid, syscall.SIGKILL)
+	}
+	if ptyFile != nil {
+		// The master is now owned by the host.
+		_ = ptyFile.Close()
+	}
+
+	go func() {
+		for {
+			msg, files, err := readControlMessage(conn)
+			if err != nil {
+				// If the host has gone, the process behaves like its
+				// terminal was closed, which also affects all processes
+				// it has spawned.
+				_ = this.reaper.signalGroup(pid, syscall.SIGHUP)
+				return
+			}
+			closeFiles(files)
+			if msg.Signal != 0 {
+				if err := this.reaper.signal(pid, msg.Signal.Native()); err != nil && !errors.Is(err, os.ErrProcessDone) {
+					l.WithError(err).
+						With("signal", msg.Signal).
+						Warn("cannot send signal to process")
+				}
+			}
+		}
+	}()
+
+	exitCode := this.resolveExitCode(req, <-done)
+	l.With("exitCode", exitCode).Debug("process ended")
+	if err := writeControlMessage(conn, &controlMessage{ExitCode: &exitCode}); err != nil && !sys.IsClosedError(err) {
+		l.WithError(err).Warn("cannot send exit code of process")
+	}
+}
+
+func (this *server) execute(req *ExecuteRequest, files []*os.File) (_ int, _ <-chan syscall.WaitStatus, _ *os.File, rErr error) {
+	fail := func(err error) (int, <-chan syscall.WaitStatus, *os.File, error) {
+		return 0, nil, nil, err
+	}
+	failf := func(msg string, args ...any) (int, <-chan syscall.WaitStatus, *os.File, error) {
+		return fail(errors.System.Newf(msg, args...))
+	}
+
+	if len(req.Argv) == 0 {
+		return failf("no command provided")
+	}
+
+	ev := sys.EnvVars{}
+	ev.AddAllOf(req.Env)
+	if _, ok := ev["PATH"]; !ok {
+		ev.Set("PATH", defaultPath)
+	}
+
+	lookupUser := req.User
+	if lookupUser == "" {
+		lookupUser = "0"
+	}
+	credential, home, err := sys.LookupCredential(lookupUser, req.Group)
+	if err != nil {
+		return fail(err)
+	}
+	if _, ok := ev["HOME"]; !ok && home != "" {
+		ev.Set("HOME", home)
+	}
+	dir := req.Dir
+	if dir == "" {
+		dir = home
+	}
+	if dir == "" {
+		dir = "/"
+	}
+
+	argv := []string{BinaryFileLocation, "exec",
+		"-c", req.ConnectionId.String(),
+		"-x",
+		"--exitCodeByConnectionIdPath=" + ExitCodesDirectoryLocation,
+		"-d", dir,
+	}
+	if v := req.User; v != "" {
+		argv = append(argv, "-u", v)
+	}
+	if v := req.Group; v != "" {
+		argv = append(argv, "-g", v)
+	}
+	for k, v := range ev {
+		argv = append(argv, "-e", k+"="+v)
+	}
+	argv = append(argv, "--")
+	argv = append(argv, req.Argv...)
+
+	attr := syscall.ProcAttr{
+		Dir: "/",
+		// bifroest exec is resolving the executable using its own PATH.
+		Env: []string{"PATH=" + ev["PATH"]},
+		Sys: &syscall.SysProcAttr{
+			Setsid: true,
+		},
+	}
+
+	var ptyFile *os.File
+	if req.Tty {
+		var ttyFile *os.File
+		if ptyFile, ttyFile, err = pty.Open(); err != nil {
+			return failf("cannot allocate pty: %w", err)
+		}
+		defer common.IgnoreCloseError(ttyFile)
+		defer func() {
+			if rErr != nil {
+				common.IgnoreCloseError(ptyFile)
+			}
+		}()
+		if req.User != "" {
+			if err := ttyFile.Chown(int(credential.Uid), -1); err != nil {
+				return failf("cannot change owner of tty: %w", err)
+			}
+		}
+		if req.Width > 0 && req.Height > 0 {
+			if err := pty.Setsize(ptyFile, &pty.Winsize{Rows: req.Height, Cols: req.Width}); err != nil {
+				return failf("cannot set size of pty: %w", err)
+			}
+		}
+		attr.Files = []uintptr{ttyFile.Fd(), ttyFile.Fd(), ttyFile.Fd()}
+		attr.Sys.Setctty = true
+		attr.Sys.Ctty = 0
+	} else {
+		if len(files) != 3 {
+			return failf("expected 3 files for stdin, stdout and stderr but got %d", len(files))
+		}
+		attr.Files = []uintptr{files[0].Fd(), files[1].Fd(), files[2].Fd()}
+	}
+
+	pid, done, err := this.reaper.start(argv, &attr)
+	if err != nil {
+		return failf("cannot start process: %w", err)
+	}
+	return pid, done, ptyFile, nil
+}
+
+// resolveExitCode prefers the exit code which was stored by bifroest exec,
+// because it reflects the exit code of the actual process.
+func (this *server) resolveExitCode(req *ExecuteRequest, status syscall.WaitStatus) int {
+	fn := filepath.Join(ExitCodesDirectoryLocation, req.ConnectionId.String())
+	if b, err := os.ReadFile(fn); err == nil {
+		_ = os.Remove(fn)
+		if v, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			return v
+		}
+	}
+	return exitCodeOf(status)
+}
+
+func exitCodeOf(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
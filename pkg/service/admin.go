@@ -0,0 +1,496 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/echocat/slf4g"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	bconn "github.com/engity-com/bifroest/pkg/connection"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+const (
+	adminApiPrefix = "/api/v1"
+
+	maxAdminRequestBodySize = 64 * 1024
+)
+
+// adminServer serves the administrative HTTP API as configured by
+// configuration.Admin.
+type adminServer struct {
+	service *service
+	server  *http.Server
+	wg      sync.WaitGroup
+}
+
+func (this *adminServer) init(service *service) error {
+	this.service = service
+	conf := &service.Configuration.Admin
+	if !conf.IsEnabled() {
+		return nil
+	}
+
+	tlsConfig, err := this.tlsConfig(conf)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+adminApiPrefix+"/connections", this.handleListConnections)
+	mux.HandleFunc("GET "+adminApiPrefix+"/connections/{id}", this.handleGetConnection)
+	mux.HandleFunc("DELETE "+adminApiPrefix+"/connections/{id}", this.handleDisconnectConnection)
+	mux.HandleFunc("POST "+adminApiPrefix+"/connections/{id}/messages", this.handleSendMessageToConnection)
+	mux.HandleFunc("GET "+adminApiPrefix+"/sessions", this.handleListSessions)
+	mux.HandleFunc("GET "+adminApiPrefix+"/sessions/{flow}/{id}", this.handleGetSession)
+	mux.HandleFunc("POST "+adminApiPrefix+"/sessions/{flow}/{id}/notes", this.handleAddSessionNote)
+	mux.HandleFunc("POST "+adminApiPrefix+"/sessions/{flow}/{id}/dispose", this.handleDisposeSession)
+	mux.HandleFunc("POST "+adminApiPrefix+"/housekeeping", this.handleHousekeeping)
+
+	this.server = &http.Server{
+		Handler:           this.authenticated(mux),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return nil
+}
+
+func (this *adminServer) tlsConfig(conf *configuration.Admin) (*tls.Config, error) {
+	if !conf.Tls.IsEnabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.Tls.CertificateFile, conf.Tls.PrivateKeyFile)
+	if err != nil {
+		return nil, errors.Config.Newf("cannot load admin API certificate: %w", err)
+	}
+	result := tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if fn := conf.Tls.ClientCaFile; fn != "" {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, errors.Config.Newf("cannot read admin API client CA file %q: %w", fn, err)
+		}
+		result.ClientCAs = x509.NewCertPool()
+		if !result.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, errors.Config.Newf("admin API client CA file %q does not contain any certificate", fn)
+		}
+		if len(conf.Tokens) > 0 {
+			// Clients can also authenticate via tokens...
+			result.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			result.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return &result, nil
+}
+
+func (this *adminServer) start() error {
+	if this.server == nil {
+		return nil
+	}
+
+	for _, addr := range this.service.Configuration.Admin.Addresses {
+		ln, err := addr.Listen()
+		if err != nil {
+			return fmt.Errorf("cannot listen to %v for admin API: %w", addr, err)
+		}
+
+		this.wg.Add(1)
+		go func() {
+			defer this.wg.Done()
+			l := this.logger().With("address", addr)
+
+			l.Info("admin API listening...")
+			var err error
+			if this.server.TLSConfig != nil {
+				err = this.server.ServeTLS(ln, "", "")
+			} else {
+				err = this.server.Serve(ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) && this.service.isProblematicError(err) {
+				l.WithError(err).Error("admin API listening... FAILED!")
+				return
+			}
+			l.Info("admin API listening... DONE!")
+		}()
+	}
+
+	return nil
+}
+
+func (this *adminServer) Close() (rErr error) {
+	if this.server == nil {
+		return nil
+	}
+	defer this.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := this.server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (this *adminServer) authenticated(next http.Handler) http.Handler {
+	tokens := this.service.Configuration.Admin.Tokens
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if plain, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			for _, candidate := range tokens {
+				if subtle.ConstantTimeCompare([]byte(candidate), []byte(plain)) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		this.logger().
+			With("remote", r.RemoteAddr).
+			With("path", r.URL.Path).
+			Info("unauthenticated request to admin API rejected")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="bifroest"`)
+		this.respondError(w, http.StatusUnauthorized, "unauthenticated")
+	})
+}
+
+type adminConnection struct {
+	Id           bconn.Id               `json:"id"`
+	Remote       string                 `json:"remote"`
+	Flow         configuration.FlowName `json:"flow,omitempty"`
+	Session      string                 `json:"session,omitempty"`
+	Created      time.Time              `json:"created"`
+	LastActivity time.Time              `json:"lastActivity"`
+	Channels     int32                  `json:"channels"`
+	Terminals    int                    `json:"terminals"`
+	BytesRead    int64                  `json:"bytesRead"`
+	BytesWritten int64                  `json:"bytesWritten"`
+}
+
+func (this *adminServer) describeConnection(conn *connection) adminConnection {
+	result := adminConnection{
+		Id:           conn.id,
+		Remote:       conn.Remote().String(),
+		Created:      time.UnixMilli(conn.created),
+		LastActivity: time.UnixMilli(conn.lastActivity.Load()),
+		Channels:     conn.channels.Load(),
+		Terminals:    conn.numberOfTerminals(),
+		BytesRead:    conn.read.Load(),
+		BytesWritten: conn.written.Load(),
+	}
+	if auth := conn.Authorization(); auth != nil {
+		result.Flow = auth.Flow()
+		if sess := auth.FindSession(); sess != nil {
+			result.Session = sess.String()
+		}
+	}
+	return result
+}
+
+func (this *adminServer) handleListConnections(w http.ResponseWriter, _ *http.Request) {
+	conns := this.service.allConnections()
+	result := make([]adminConnection, len(conns))
+	for i, conn := range conns {
+		result[i] = this.describeConnection(conn)
+	}
+	this.respond(w, http.StatusOK, result)
+}
+
+func (this *adminServer) handleGetConnection(w http.ResponseWriter, r *http.Request) {
+	conn := this.resolveConnection(w, r)
+	if conn == nil {
+		return
+	}
+	this.respond(w, http.StatusOK, this.describeConnection(conn))
+}
+
+func (this *adminServer) handleDisconnectConnection(w http.ResponseWriter, r *http.Request) {
+	conn := this.resolveConnection(w, r)
+	if conn == nil {
+		return
+	}
+	conn.logger.
+		With("requestedBy", r.RemoteAddr).
+		Info("connection will be closed as requested via admin API")
+	if err := conn.Close(); err != nil && this.service.isRelevantError(err) {
+		this.respondFailure(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (this *adminServer) handleSendMessageToConnection(w http.ResponseWriter, r *http.Request) {
+	conn := this.resolveConnection(w, r)
+	if conn == nil {
+		return
+	}
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestBodySize)).Decode(&body); err != nil {
+		this.respondError(w, http.StatusBadRequest, "illegal request body: %v", err)
+		return
+	}
+	if body.Message == "" {
+		this.respondError(w, http.StatusBadRequest, "message required but absent")
+		return
+	}
+
+	n, err := conn.sendMessage(body.Message)
+	if err != nil {
+		this.respondFailure(w, err)
+		return
+	}
+	this.respond(w, http.StatusOK, map[string]any{"terminals": n})
+}
+
+type adminSession struct {
+	Flow         configuration.FlowName `json:"flow"`
+	Id           session.Id             `json:"id"`
+	State        session.State          `json:"state"`
+	Created      time.Time              `json:"created"`
+	LastAccessed time.Time              `json:"lastAccessed"`
+	ValidUntil   *time.Time             `json:"validUntil,omitempty"`
+	Tags         session.Tags           `json:"tags"`
+	Notes        []session.Note         `json:"notes"`
+}
+
+func (this *adminServer) describeSession(ctx context.Context, sess session.Session) (*adminSession, error) {
+	info, err := sess.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := adminSession{
+		Flow:  info.Flow(),
+		Id:    info.Id(),
+		State: info.State(),
+	}
+	if v, err := info.Created(ctx); err != nil {
+		return nil, err
+	} else if v != nil {
+		result.Created = v.At()
+	}
+	if v, err := info.LastAccessed(ctx); err != nil {
+		return nil, err
+	} else if v != nil {
+		result.LastAccessed = v.At()
+	}
+	if v, err := info.ValidUntil(ctx); err != nil {
+		return nil, err
+	} else if !v.IsZero() {
+		result.ValidUntil = &v
+	}
+	if result.Tags, err = info.Tags(ctx); err != nil {
+		return nil, err
+	}
+	if result.Tags == nil {
+		result.Tags = session.Tags{}
+	}
+	if result.Notes, err = info.Notes(ctx); err != nil {
+		return nil, err
+	}
+	if result.Notes == nil {
+		result.Notes = []session.Note{}
+	}
+	return &result, nil
+}
+
+func (this *adminServer) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	var opts session.FindOpts
+	if v := r.URL.Query().Get("flow"); v != "" {
+		var flow configuration.FlowName
+		if err := flow.Set(v); err != nil {
+			this.respondError(w, http.StatusBadRequest, "illegal flow: %v", err)
+			return
+		}
+		opts.Predicates = append(opts.Predicates, session.IsFlow(flow))
+	}
+	var tags session.Tags
+	for _, v := range r.URL.Query()["tag"] {
+		if err := tags.Set(v); err != nil {
+			this.respondError(w, http.StatusBadRequest, "illegal tag: %v", err)
+			return
+		}
+	}
+	if len(tags) > 0 {
+		opts.Predicates = append(opts.Predicates, session.HasTags(tags))
+	}
+
+	result := []*adminSession{}
+	if err := this.service.sessions.FindAll(r.Context(), func(ctx context.Context, sess session.Session) (bool, error) {
+		d, err := this.describeSession(ctx, sess)
+		if err != nil {
+			return false, err
+		}
+		result = append(result, d)
+		return true, nil
+	}, &opts); err != nil {
+		this.respondFailure(w, err)
+		return
+	}
+	this.respond(w, http.StatusOK, result)
+}
+
+func (this *adminServer) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	sess := this.resolveSession(w, r)
+	if sess == nil {
+		return
+	}
+	d, err := this.describeSession(r.Context(), sess)
+	if err != nil {
+		this.respondFailure(w, err)
+		return
+	}
+	this.respond(w, http.StatusOK, d)
+}
+
+func (this *adminServer) handleAddSessionNote(w http.ResponseWriter, r *http.Request) {
+	sess := this.resolveSession(w, r)
+	if sess == nil {
+		return
+	}
+
+	var body struct {
+		Text   string `json:"text"`
+		Author string `json:"author"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestBodySize)).Decode(&body); err != nil {
+		this.respondError(w, http.StatusBadRequest, "illegal request body: %v", err)
+		return
+	}
+	if body.Text = strings.TrimSpace(body.Text); body.Text == "" {
+		this.respondError(w, http.StatusBadRequest, "text required but absent")
+		return
+	}
+	if body.Author == "" {
+		body.Author = "admin@" + r.RemoteAddr
+	}
+
+	note := session.Note{
+		At:     time.Now().Truncate(time.Millisecond),
+		Author: body.Author,
+		Text:   body.Text,
+	}
+	if err := sess.AddNote(r.Context(), note); err != nil {
+		this.respondFailure(w, err)
+		return
+	}
+	this.logger().
+		With("session", sess).
+		With("requestedBy", r.RemoteAddr).
+		Info("note added to session as requested via admin API")
+	this.respond(w, http.StatusCreated, note)
+}
+
+func (this *adminServer) handleDisposeSession(w http.ResponseWriter, r *http.Request) {
+	sess := this.resolveSession(w, r)
+	if sess == nil {
+		return
+	}
+
+	ctx := r.Context()
+	logger := this.logger().
+		With("session", sess).
+		With("requestedBy", r.RemoteAddr)
+	disposed, err := this.service.houseKeeper.dispose(ctx, logger, sess)
+	if err != nil {
+		this.respondFailure(w, err)
+		return
+	}
+	logger.With("disposed", disposed).Info("session disposed as requested via admin API")
+	this.respond(w, http.StatusOK, map[string]any{"disposed": disposed})
+}
+
+func (this *adminServer) handleHousekeeping(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if _, err := this.service.houseKeeper.checkedRun(r.Context()); err != nil {
+		this.respondFailure(w, err)
+		return
+	}
+	this.respond(w, http.StatusOK, map[string]any{
+		"duration": time.Since(started).Truncate(time.Microsecond).String(),
+	})
+}
+
+func (this *adminServer) resolveConnection(w http.ResponseWriter, r *http.Request) *connection {
+	var id bconn.Id
+	if err := id.UnmarshalText([]byte(r.PathValue("id"))); err != nil {
+		this.respondError(w, http.StatusBadRequest, "illegal connection id: %v", err)
+		return nil
+	}
+	conn := this.service.findConnection(id)
+	if conn == nil {
+		this.respondError(w, http.StatusNotFound, "connection %v does not exist", id)
+		return nil
+	}
+	return conn
+}
+
+func (this *adminServer) resolveSession(w http.ResponseWriter, r *http.Request) session.Session {
+	var flow configuration.FlowName
+	if err := flow.Set(r.PathValue("flow")); err != nil {
+		this.respondError(w, http.StatusBadRequest, "illegal flow: %v", err)
+		return nil
+	}
+	var id session.Id
+	if err := id.Set(r.PathValue("id")); err != nil {
+		this.respondError(w, http.StatusBadRequest, "illegal session id: %v", err)
+		return nil
+	}
+
+	sess, err := this.service.sessions.FindBy(r.Context(), flow, id, nil)
+	if errors.Is(err, session.ErrNoSuchSession) {
+		this.respondError(w, http.StatusNotFound, "session %v/%v does not exist", flow, id)
+		return nil
+	}
+	if err != nil {
+		this.respondFailure(w, err)
+		return nil
+	}
+	return sess
+}
+
+func (this *adminServer) respond(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil && this.service.isRelevantError(err) {
+		this.logger().WithError(err).Debug("cannot write admin API response")
+	}
+}
+
+func (this *adminServer) respondError(w http.ResponseWriter, status int, msg string, args ...any) {
+	this.respond(w, status, map[string]any{"error": fmt.Sprintf(msg, args...)})
+}
+
+func (this *adminServer) respondFailure(w http.ResponseWriter, err error) {
+	this.logger().WithError(err).Error("cannot handle admin API request")
+	this.respondError(w, http.StatusInternalServerError, "internal error")
+}
+
+func (this *adminServer) logger() log.Logger {
+	if v := this.service.Logger; v != nil {
+		return v
+	}
+	return log.GetLogger("admin")
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	gonet "net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	log "github.com/echocat/slf4g"
+	glssh "github.com/gliderlabs/ssh"
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+const testAdminToken = "0123456789abcdef"
+
+func TestAdminServer_authenticated(t *testing.T) {
+	server := newTestAdminServer(t)
+
+	cases := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{{
+		name:     "absent",
+		expected: http.StatusUnauthorized,
+	}, {
+		name:          "wrong-token",
+		authorization: "Bearer fedcba9876543210",
+		expected:      http.StatusUnauthorized,
+	}, {
+		name:          "wrong-scheme",
+		authorization: "Basic " + testAdminToken,
+		expected:      http.StatusUnauthorized,
+	}, {
+		name:          "prefix-of-token",
+		authorization: "Bearer " + testAdminToken[:8],
+		expected:      http.StatusUnauthorized,
+	}, {
+		name:          "valid-token",
+		authorization: "Bearer " + testAdminToken,
+		expected:      http.StatusOK,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+adminApiPrefix+"/connections", nil)
+			require.NoError(t, err)
+			if c.authorization != "" {
+				req.Header.Set("Authorization", c.authorization)
+			}
+			rsp, err := server.Client().Do(req)
+			require.NoError(t, err)
+			defer common.IgnoreCloseError(rsp.Body)
+
+			require.Equal(t, c.expected, rsp.StatusCode)
+			if c.expected == http.StatusUnauthorized {
+				require.Equal(t, `Bearer realm="bifroest"`, rsp.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAdminServer_handleDisconnectConnection(t *testing.T) {
+	server := newTestAdminServer(t)
+	conn := newTestConnection(t, server.service)
+
+	var listed []adminConnection
+	server.do(t, http.MethodGet, "/connections", http.StatusOK, &listed)
+	require.Len(t, listed, 1)
+	require.Equal(t, conn.id, listed[0].Id)
+
+	server.do(t, http.MethodDelete, "/connections/"+conn.id.String(), http.StatusNoContent, nil)
+	require.True(t, conn.closed.Load())
+	require.Nil(t, server.service.findConnection(conn.id))
+
+	server.do(t, http.MethodDelete, "/connections/"+conn.id.String(), http.StatusNotFound, nil)
+	server.do(t, http.MethodDelete, "/connections/illegal", http.StatusBadRequest, nil)
+}
+
+func TestAdminServer_handleDisposeSession(t *testing.T) {
+	server := newTestAdminServer(t)
+	sess := newTestAdministrationSession(t, &Administration{server.service})
+	path := "/sessions/" + sess.Flow().String() + "/" + sess.Id().String()
+
+	var disposed map[string]any
+	server.do(t, http.MethodPost, path+"/dispose", http.StatusOK, &disposed)
+	require.Contains(t, disposed, "disposed")
+
+	var actual adminSession
+	server.do(t, http.MethodGet, path, http.StatusOK, &actual)
+	require.Equal(t, sess.Id(), actual.Id)
+	require.Equal(t, session.StateDisposed, actual.State)
+
+	server.do(t, http.MethodPost, "/sessions/"+sess.Flow().String()+"/"+session.Id{}.String()+"/dispose", http.StatusNotFound, nil)
+}
+
+func TestAdminServer_handleHousekeeping(t *testing.T) {
+	server := newTestAdminServer(t)
+	sess := newTestAdministrationSession(t, &Administration{server.service})
+	_, err := server.service.houseKeeper.dispose(context.Background(), log.GetLogger("test"), sess)
+	require.NoError(t, err)
+
+	var actual map[string]any
+	server.do(t, http.MethodPost, "/housekeeping", http.StatusOK, &actual)
+	require.Contains(t, actual, "duration")
+
+	// The disposed session is expired and immediately deleted, because
+	// keepExpiredFor is zero.
+	_, err = server.service.sessions.FindBy(context.Background(), sess.Flow(), sess.Id(), nil)
+	require.ErrorIs(t, err, session.ErrNoSuchSession)
+}
+
+type testAdminServer struct {
+	*httptest.Server
+	service *service
+}
+
+func newTestAdminServer(t *testing.T) *testAdminServer {
+	t.Helper()
+	svc := newTestAdministration(t).svc
+	svc.Configuration.Admin = configuration.Admin{
+		Addresses: net.NetAddresses{net.MustNewAddress("127.0.0.1:0")},
+		Tokens:    configuration.AdminTokens{testAdminToken},
+	}
+	svc.Configuration.HouseKeeping.KeepExpiredFor = common.DurationOf(0)
+
+	var instance adminServer
+	require.NoError(t, instance.init(svc))
+
+	result := testAdminServer{httptest.NewServer(instance.server.Handler), svc}
+	t.Cleanup(result.Close)
+	return &result
+}
+
+func (this *testAdminServer) do(t *testing.T, method, path string, expectedStatus int, target any) {
+	t.Helper()
+	req, err := http.NewRequest(method, this.URL+adminApiPrefix+path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+
+	rsp, err := this.Client().Do(req)
+	require.NoError(t, err)
+	defer common.IgnoreCloseError(rsp.Body)
+
+	require.Equal(t, expectedStatus, rsp.StatusCode)
+	if target != nil {
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(target))
+	}
+}
+
+func newTestConnection(t *testing.T, svc *service) *connection {
+	t.Helper()
+	server, client := gonet.Pipe()
+	t.Cleanup(func() { common.IgnoreCloseError(client) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	result, err := svc.newConnection(server, &testSshContext{Context: ctx}, log.GetLogger("test"))
+	require.NoError(t, err)
+	t.Cleanup(func() { common.IgnoreCloseError(result) })
+	return result.(*connection)
+}
+
+type testSshContext struct {
+	context.Context
+	sync.Mutex
+}
+
+func (this *testSshContext) User() string          { return "user" }
+func (this *testSshContext) SessionID() string     { return "test" }
+func (this *testSshContext) ClientVersion() string { return "SSH-2.0-test" }
+func (this *testSshContext) ServerVersion() string { return "SSH-2.0-test" }
+func (this *testSshContext) RemoteAddr() gonet.Addr {
+	return &gonet.TCPAddr{IP: gonet.IPv4(127, 0, 0, 1), Port: 22}
+}
+func (this *testSshContext) LocalAddr() gonet.Addr {
+	return &gonet.TCPAddr{IP: gonet.IPv4(127, 0, 0, 1), Port: 2222}
+}
+func (this *testSshContext) Permissions() *glssh.Permissions { return &glssh.Permissions{} }
+func (this *testSshContext) SetValue(any, any)               {}
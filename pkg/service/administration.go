@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+// Administration provides access to the sessions of a Service (including
+// their authorizations and environments) without serving any connection.
+// It is intended to be used by administrative tools like the CLI.
+type Administration struct {
+	svc *service
+}
+
+// OpenAdministration creates a new Administration based on the
+// Configuration of this Service. The returned instance has to be
+// closed after usage.
+func (this *Service) OpenAdministration(ctx context.Context) (*Administration, error) {
+	fail := func(err error) (*Administration, error) {
+		return nil, fmt.Errorf("cannot prepare administration: %w", err)
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	svc := &service{Service: this}
+	svc.houseKeeper.service = svc
+	if _, err := this.prepareRepositories(ctx, svc); err != nil {
+		_ = svc.Close()
+		return fail(err)
+	}
+
+	return &Administration{svc}, nil
+}
+
+// Sessions returns the session.Repository as it is also used by the Service.
+func (this *Administration) Sessions() session.Repository {
+	return this.svc.sessions
+}
+
+// FlowExists returns true if a flow with the given name is configured.
+func (this *Administration) FlowExists(name configuration.FlowName) bool {
+	_, ok := this.svc.knownFlows[name]
+	return ok
+}
+
+// DisposeSession disposes the given session.Session in the same way as
+// the housekeeping does: First its environment, then its authorization
+// and finally the session itself. The session will NOT be deleted.
+func (this *Administration) DisposeSession(ctx context.Context, sess session.Session) (bool, error) {
+	return this.svc.houseKeeper.dispose(ctx, this.svc.houseKeeper.logger().With("session", sess), sess)
+}
+
+// DeleteSession disposes the given session.Session (see DisposeSession)
+// and deletes it afterward entirely.
+func (this *Administration) DeleteSession(ctx context.Context, sess session.Session) error {
+	if _, err := this.DisposeSession(ctx, sess); err != nil {
+		return err
+	}
+	if err := this.svc.sessions.Delete(ctx, sess); err != nil {
+		return errors.System.Newf("cannot delete session %v: %w", sess, err)
+	}
+	return nil
+}
+
+func (this *Administration) Close() error {
+	return this.svc.Close()
+}
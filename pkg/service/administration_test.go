@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+func TestOpenAdministration(t *testing.T) {
+	admin := newTestAdministration(t)
+
+	require.True(t, admin.FlowExists("foo"))
+	require.False(t, admin.FlowExists("bar"))
+	require.NotNil(t, admin.Sessions())
+}
+
+func TestAdministration_DisposeSession(t *testing.T) {
+	ctx := context.Background()
+	admin := newTestAdministration(t)
+	sess := newTestAdministrationSession(t, admin)
+
+	_, err := admin.DisposeSession(ctx, sess)
+	require.NoError(t, err)
+
+	actual, err := admin.Sessions().FindBy(ctx, sess.Flow(), sess.Id(), nil)
+	require.NoError(t, err)
+	info, err := actual.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, session.StateDisposed, info.State())
+}
+
+func TestAdministration_DeleteSession(t *testing.T) {
+	ctx := context.Background()
+	admin := newTestAdministration(t)
+	sess := newTestAdministrationSession(t, admin)
+
+	require.NoError(t, admin.DeleteSession(ctx, sess))
+
+	_, err := admin.Sessions().FindBy(ctx, sess.Flow(), sess.Id(), nil)
+	require.ErrorIs(t, err, session.ErrNoSuchSession)
+}
+
+func newTestAdministration(t *testing.T) *Administration {
+	t.Helper()
+	dir := t.TempDir()
+
+	confFn := filepath.Join(dir, "configuration.yaml")
+	require.NoError(t, os.WriteFile(confFn, []byte(`ssh:
+  keys:
+    hostKeys: [`+filepath.Join(dir, "host-key")+`]
+session:
+  type: fs
+  storage: `+filepath.Join(dir, "sessions")+`
+flows:
+  - name: foo
+    authorization:
+      type: none
+    environment:
+      type: dummy
+`), 0600))
+
+	var conf configuration.Configuration
+	require.NoError(t, conf.LoadFromFile(confFn))
+
+	svc := Service{Configuration: conf}
+	result, err := svc.OpenAdministration(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(func() { common.IgnoreCloseError(result) })
+
+	return result
+}
+
+func newTestAdministrationSession(t *testing.T, admin *Administration) session.Session {
+	t.Helper()
+	result, err := admin.Sessions().Create(context.Background(), "foo", testRemote{"user", net.MustNewHost("127.0.0.1")}, nil)
+	require.NoError(t, err)
+	return result
+}
+
+type testRemote struct {
+	user string
+	host net.Host
+}
+
+func (this testRemote) User() string   { return this.user }
+func (this testRemote) Host() net.Host { return this.host }
+func (this testRemote) String() string { return this.user + "@" + this.host.String() }
@@ -2,15 +2,20 @@ package service
 
 import (
 	"fmt"
+	"io"
 	gonet "net"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	log "github.com/echocat/slf4g"
 	"github.com/echocat/slf4g/fields"
 	glssh "github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/engity-com/bifroest/pkg/authorization"
 	bconn "github.com/engity-com/bifroest/pkg/connection"
@@ -102,23 +107,54 @@ func (this *service) newConnection(orig gonet.Conn, ctx glssh.Context, logger lo
 		return nil, err
 	}
 
+	traced, span := startSpan(ctx, "ssh.connection",
+		attribute.String("bifroest.connection.id", id.String()),
+		attribute.String("network.peer.address", orig.RemoteAddr().String()),
+	)
+
 	now := time.Now().UnixMilli()
 	result := &connection{
 		Conn:    orig,
 		id:      id,
-		context: ctx,
+		context: traced,
+		span:    span,
 		logger:  logger,
 		service: this,
 		created: now,
 	}
 	result.lastActivity.Store(now)
+
+	this.connectionsMutex.Lock()
+	defer this.connectionsMutex.Unlock()
+	if this.connections == nil {
+		this.connections = make(map[bconn.Id]*connection)
+	}
+	this.connections[id] = result
+
 	return result, nil
 }
 
+func (this *service) findConnection(id bconn.Id) *connection {
+	this.connectionsMutex.RLock()
+	defer this.connectionsMutex.RUnlock()
+	return this.connections[id]
+}
+
+func (this *service) allConnections() []*connection {
+	this.connectionsMutex.RLock()
+	defer this.connectionsMutex.RUnlock()
+	result := make([]*connection, 0, len(this.connections))
+	for _, v := range this.connections {
+		result = append(result, v)
+	}
+	return result
+}
+
 type connection struct {
 	gonet.Conn
 	id      bconn.Id
 	context glssh.Context
+	span    trace.Span
 	logger  log.Logger
 	service *service
 	created int64
@@ -130,6 +166,22 @@ type connection struct {
 
 	read    atomic.Int64
 	written atomic.Int64
+
+	channels  atomic.Int32
+	terminals sync.Map
+
+	// remoteListeners holds all environment.RemoteListener of remote port
+	// forwardings requested by the client, by their bind address.
+	remoteListeners sync.Map
+	// remoteSocketListeners holds all gonet.Listener of remote socket
+	// forwardings requested by the client, by their path.
+	remoteSocketListeners sync.Map
+
+	sessionAnnounced atomic.Bool
+	loginSucceeded   atomic.Bool
+	// loginFailedMethod holds the method of the last rejected authorization
+	// attempt, which was not reported yet (see service.emitFinalLoginFailure).
+	loginFailedMethod atomic.Pointer[string]
 }
 
 func (this *connection) Id() bconn.Id {
@@ -144,6 +196,65 @@ func (this *connection) Logger() log.Logger {
 	return this.logger
 }
 
+func (this *connection) Authorization() authorization.Authorization {
+	v, _ := this.context.Value(authorizationCtxKey).(authorization.Authorization)
+	return v
+}
+
+// onChannel has to be called by every handler of a new channel. The returned
+// function has to be called once the channel was closed.
+func (this *connection) onChannel() (onClosed func()) {
+	this.channels.Add(1)
+	return func() {
+		this.channels.Add(-1)
+	}
+}
+
+// onTerminal registers the given glssh.Session as terminal of this connection,
+// which can receive messages via sendMessage. The returned function has to be
+// called once the glssh.Session was ended.
+func (this *connection) onTerminal(sess glssh.Session) (onEnded func()) {
+	this.terminals.Store(sess, struct{}{})
+	return func() {
+		this.terminals.Delete(sess)
+	}
+}
+
+func (this *connection) numberOfTerminals() (result int) {
+	this.terminals.Range(func(any, any) bool {
+		result++
+		return true
+	})
+	return result
+}
+
+// sendMessage writes the given message to all terminals of this connection
+// and returns to how many terminals it was sent.
+func (this *connection) sendMessage(msg string) (n int, rErr error) {
+	this.terminals.Range(func(key, _ any) bool {
+		if err := writeTerminalMessage(key.(glssh.Session), msg); err != nil {
+			rErr = errors.Network.Newf("cannot send message to terminal of connection %v: %w", this.id, err)
+			return false
+		}
+		n++
+		return true
+	})
+	return n, rErr
+}
+
+// writeTerminalMessage writes the given message to stderr of the given
+// glssh.Session, on its own line(s).
+func writeTerminalMessage(sess glssh.Session, msg string) error {
+	buf := msg
+	if _, _, isPty := sess.Pty(); isPty {
+		buf = "\r\n" + strings.ReplaceAll(strings.ReplaceAll(msg, "\r\n", "\n"), "\n", "\r\n") + "\r\n"
+	} else if !strings.HasSuffix(buf, "\n") {
+		buf += "\n"
+	}
+	_, err := io.WriteString(sess.Stderr(), buf)
+	return err
+}
+
 func (this *connection) doWithInterceptor(consumer func(session.ConnectionInterceptor) error) error {
 	if v := this.interceptorP.Load(); v != nil {
 		return consumer(*v)
@@ -288,11 +399,17 @@ func (this *connection) Close() (rErr error) {
 	if !this.closed.CompareAndSwap(false, true) {
 		return nil
 	}
+	defer this.endSpan()
+	defer this.service.emitFinalLoginFailure(this)
 	defer func(target *error) {
 		if err := this.doWithInterceptor(session.ConnectionInterceptor.Close); err != nil && *target == nil {
 			*target = err
 		}
 	}(&rErr)
+	this.service.connectionsMutex.Lock()
+	delete(this.service.connections, this.id)
+	this.service.connectionsMutex.Unlock()
+
 	if v := this.service.activeConnections.Add(-1); v < 0 {
 		panic(fmt.Errorf("trying to close more connections that are actually opened; currently: %d", v))
 	}
@@ -300,6 +417,20 @@ func (this *connection) Close() (rErr error) {
 	return this.Conn.Close()
 }
 
+func (this *connection) endSpan() {
+	this.span.SetAttributes(
+		attribute.Int64("bifroest.connection.bytesRead", this.read.Load()),
+		attribute.Int64("bifroest.connection.bytesWritten", this.written.Load()),
+	)
+	if auth := this.Authorization(); auth != nil {
+		this.span.SetAttributes(attribute.String("bifroest.flow", auth.Flow().String()))
+		if sess := auth.FindSession(); sess != nil {
+			this.span.SetAttributes(attribute.String("bifroest.session", sess.String()))
+		}
+	}
+	this.span.End()
+}
+
 func (this *connection) SetDeadline(time.Time) error {
 	// We'll ignore them, because this should be handled only by session.ConnectionInterceptor.
 	return nil
@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"time"
 
 	glssh "github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
@@ -32,6 +34,13 @@ func (this *remote) GetField(name string) (any, bool, error) {
 	}
 }
 
+// User returns the name of the remote user without the code of a shared shell
+// to join (see cutShareJoinUser).
+func (this *remote) User() string {
+	result, _, _ := cutShareJoinUser(this.Context.User())
+	return result
+}
+
 func (this *remote) Host() net.Host {
 	var result net.Host
 	_ = result.SetNetAddr(this.RemoteAddr())
@@ -45,6 +54,8 @@ func (this *remote) String() string {
 type authorizeRequest struct {
 	service    *service
 	connection *connection
+	// context overrides the context of the connection if set.
+	context glssh.Context
 }
 
 func (this *authorizeRequest) GetField(name string) (any, bool, error) {
@@ -59,6 +70,9 @@ func (this *authorizeRequest) GetField(name string) (any, bool, error) {
 }
 
 func (this *authorizeRequest) Context() glssh.Context {
+	if v := this.context; v != nil {
+		return v
+	}
 	return this.connection.context
 }
 
@@ -75,6 +89,7 @@ func (this *authorizeRequest) Validate(auth authorization.Authorization) (bool,
 		service:       this.service,
 		connection:    this.connection,
 		authorization: auth,
+		context:       this.context,
 	}
 	return this.service.environments.WillBeAccepted(&ctx)
 }
@@ -146,12 +161,14 @@ type environmentContext struct {
 	service       *service
 	connection    *connection
 	authorization authorization.Authorization
+	// context overrides the context of the connection if set.
+	context glssh.Context
 }
 
 func (this *environmentContext) GetField(name string) (any, bool, error) {
 	switch name {
 	case "context":
-		return this.connection.context, true, nil
+		return this.Context(), true, nil
 	case "connection":
 		return this.connection, true, nil
 	case "remote":
@@ -166,6 +183,9 @@ func (this *environmentContext) GetField(name string) (any, bool, error) {
 }
 
 func (this *environmentContext) Context() glssh.Context {
+	if v := this.context; v != nil {
+		return v
+	}
 	return this.connection.context
 }
 
@@ -389,3 +409,55 @@ func (this *connectionContext) GetField(name string) (any, bool, error) {
 
 type noopContext struct {
 }
+
+type expiryContext struct {
+	context       glssh.Context
+	authorization authorization.Authorization
+	expiresAt     time.Time
+	reason        string
+}
+
+func (this *expiryContext) GetField(name string) (any, bool, error) {
+	switch name {
+	case "authorization":
+		return this.authorization, true, nil
+	case "expiresAt":
+		return this.expiresAt, true, nil
+	case "remaining":
+		return max(time.Until(this.expiresAt).Round(time.Second), 0), true, nil
+	case "reason":
+		return this.reason, true, nil
+	case "session":
+		sess := this.authorization.FindSession()
+		if sess == nil {
+			return nil, true, nil
+		}
+		si, err := sess.Info(this.context)
+		if err != nil {
+			return nil, false, err
+		}
+		return &sessionContext{si, false}, true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
+
+// cancelableContext is a glssh.Context which can be canceled independently of
+// the glssh.Context it is based on.
+type cancelableContext struct {
+	glssh.Context
+	cancelable context.Context
+}
+
+func withCancel(ctx glssh.Context) (glssh.Context, context.CancelFunc) {
+	cancelable, cancel := context.WithCancel(ctx)
+	return &cancelableContext{ctx, cancelable}, cancel
+}
+
+func (this *cancelableContext) Done() <-chan struct{} {
+	return this.cancelable.Done()
+}
+
+func (this *cancelableContext) Err() error {
+	return this.cancelable.Err()
+}
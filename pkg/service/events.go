@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/environment"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/event"
+	"github.com/engity-com/bifroest/pkg/session"
+	"github.com/engity-com/bifroest/pkg/tracing"
+)
+
+func (this *service) emit(e event.Event) {
+	if v := this.events; v != nil {
+		v.Emit(e)
+	}
+}
+
+func (this *service) isInterestedIn(t configuration.EventType, flow configuration.FlowName) bool {
+	if v := this.events; v != nil {
+		return v.IsInterestedIn(t, flow)
+	}
+	return false
+}
+
+// newConnectionEvent creates a new event.Event for the given connection. The
+// authorization can be nil, if not (yet) present.
+func (this *service) newConnectionEvent(t configuration.EventType, conn *connection, auth authorization.Authorization) event.Event {
+	result := event.New(t)
+	result.Connection = conn.id.String()
+	result.Remote = conn.Remote().String()
+	if auth != nil {
+		result.Flow = auth.Flow()
+		if sess := auth.FindSession(); sess != nil {
+			result.Session = sess.Id().String()
+		}
+	}
+	return result
+}
+
+func newSessionEvent(t configuration.EventType, sess session.Session) event.Event {
+	result := event.New(t)
+	result.Flow = sess.Flow()
+	result.Session = sess.Id().String()
+	return result
+}
+
+// afterAuthorize has to be called after each call of the authorizer. It ends
+// the given span and emits the corresponding login event.
+//
+// A rejection without any error is not reported immediately, because clients
+// are probing their keys and methods (and OpenSSH is doing partial
+// authorizations). It is only reported via emitFinalLoginFailure, if the
+// connection was never authorized.
+func (this *service) afterAuthorize(conn *connection, span trace.Span, method string, auth authorization.Authorization, err error) {
+	authorized := err == nil && auth != nil && auth.IsAuthorized()
+	span.SetAttributes(attribute.Bool("bifroest.authorization.authorized", authorized))
+	if authorized {
+		span.SetAttributes(attribute.String("bifroest.flow", auth.Flow().String()))
+	}
+	tracing.End(span, err)
+
+	if authorized {
+		conn.loginSucceeded.Store(true)
+		this.emit(this.newConnectionEvent(configuration.EventTypeLoginSucceeded, conn, auth).
+			With("method", method))
+	} else if err != nil && errors.IsType(err, errors.User) {
+		conn.loginFailedMethod.Store(nil)
+		this.emit(this.newConnectionEvent(configuration.EventTypeLoginFailed, conn, nil).
+			With("method", method).
+			With("reason", err.Error()))
+	} else if !this.isSilentError(err) {
+		conn.loginFailedMethod.Store(&method)
+	}
+}
+
+// emitFinalLoginFailure emits the rejection recorded by afterAuthorize, if
+// the given connection was never authorized. It has to be called once the
+// connection is closed.
+func (this *service) emitFinalLoginFailure(conn *connection) {
+	if conn.loginSucceeded.Load() {
+		return
+	}
+	method := conn.loginFailedMethod.Swap(nil)
+	if method == nil {
+		return
+	}
+	this.emit(this.newConnectionEvent(configuration.EventTypeLoginFailed, conn, nil).
+		With("method", *method))
+}
+
+// announceSession emits, once per connection, whether the session of the
+// connection was newly created or restored.
+func (this *service) announceSession(conn *connection, auth authorization.Authorization, oldState session.State) {
+	if conn == nil || !conn.sessionAnnounced.CompareAndSwap(false, true) {
+		return
+	}
+	t := configuration.EventTypeSessionRestored
+	if oldState == session.StateNew {
+		t = configuration.EventTypeSessionNew
+	}
+	this.emit(this.newConnectionEvent(t, conn, auth))
+}
+
+// environmentExists is used to detect if an environment will be created. It
+// is only evaluated if someone is interested in
+// configuration.EventTypeEnvironmentCreated, because it can be expensive.
+func (this *service) environmentExists(ctx context.Context, auth authorization.Authorization) (bool, error) {
+	sess := auth.FindSession()
+	if sess == nil {
+		return false, nil
+	}
+	env, err := this.environments.FindBySession(ctx, sess, nil)
+	if errors.Is(err, environment.ErrNoSuchEnvironment) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := env.Close(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
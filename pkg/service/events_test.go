@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/event"
+)
+
+func TestService_afterAuthorize(t *testing.T) {
+	type attempt struct {
+		method     string
+		authorized bool
+		err        error
+	}
+
+	cases := []struct {
+		name     string
+		attempts []attempt
+		expected []map[string]any
+	}{{
+		name: "probes-followed-by-success",
+		attempts: []attempt{
+			{method: "publicKey"},
+			{method: "publicKey"},
+			{method: "password", authorized: true},
+		},
+		expected: []map[string]any{
+			{"type": configuration.EventTypeLoginSucceeded, "method": "password"},
+		},
+	}, {
+		name: "finally-rejected",
+		attempts: []attempt{
+			{method: "publicKey"},
+			{method: "password"},
+		},
+		expected: []map[string]any{
+			{"type": configuration.EventTypeLoginFailed, "method": "password"},
+		},
+	}, {
+		name: "user-error",
+		attempts: []attempt{
+			{method: "password", err: errors.User.Newf("wrong password")},
+		},
+		expected: []map[string]any{
+			{"type": configuration.EventTypeLoginFailed, "method": "password", "reason": "wrong password"},
+		},
+	}, {
+		name: "user-error-followed-by-success",
+		attempts: []attempt{
+			{method: "password", err: errors.User.Newf("wrong password")},
+			{method: "interactive", authorized: true},
+		},
+		expected: []map[string]any{
+			{"type": configuration.EventTypeLoginFailed, "method": "password", "reason": "wrong password"},
+			{"type": configuration.EventTypeLoginSucceeded, "method": "interactive"},
+		},
+	}, {
+		name: "canceled",
+		attempts: []attempt{
+			{method: "interactive", err: context.Canceled},
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc := newTestAdministration(t).svc
+			events := &testEventDispatcher{}
+			svc.events = events
+			conn := newTestConnection(t, svc)
+
+			for _, a := range c.attempts {
+				var auth authorization.Authorization = authorization.Forbidden(conn.Remote())
+				if a.authorized {
+					auth = &testAuthorization{auth}
+				}
+				_, span := startSpan(conn.context, "test")
+				svc.afterAuthorize(conn, span, a.method, auth, a.err)
+			}
+			require.NoError(t, conn.Close())
+
+			require.Equal(t, c.expected, events.summaries())
+		})
+	}
+}
+
+type testAuthorization struct {
+	authorization.Authorization
+}
+
+func (this *testAuthorization) IsAuthorized() bool {
+	return true
+}
+
+func (this *testAuthorization) Flow() configuration.FlowName {
+	return "foo"
+}
+
+type testEventDispatcher struct {
+	mutex  sync.Mutex
+	events []event.Event
+}
+
+func (this *testEventDispatcher) IsInterestedIn(configuration.EventType, configuration.FlowName) bool {
+	return true
+}
+
+func (this *testEventDispatcher) Emit(e event.Event) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.events = append(this.events, e)
+}
+
+func (this *testEventDispatcher) Close() error {
+	return nil
+}
+
+func (this *testEventDispatcher) summaries() (result []map[string]any) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, e := range this.events {
+		summary := map[string]any{"type": e.Type}
+		for k, v := range e.Attributes {
+			summary[k] = v
+		}
+		result = append(result, summary)
+	}
+	return result
+}
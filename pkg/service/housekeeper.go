@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,6 +21,7 @@ type houseKeeper struct {
 	service       *service
 	closed        atomic.Bool
 	contextCancel context.CancelFunc
+	runMutex      sync.Mutex
 }
 
 func (this *houseKeeper) init(service *service) error {
@@ -61,6 +63,10 @@ func (this *houseKeeper) loop(ctx context.Context, firstIn time.Duration) {
 }
 
 func (this *houseKeeper) checkedRun(ctx context.Context) (nextRunIn time.Duration, rErr error) {
+	// Runs can also be triggered on demand (see adminServer); they should never overlap.
+	this.runMutex.Lock()
+	defer this.runMutex.Unlock()
+
 	l := this.logger()
 	started := time.Now()
 	defer func() {
@@ -91,7 +97,12 @@ func (this *houseKeeper) checkedRun(ctx context.Context) (nextRunIn time.Duratio
 }
 
 func (this *houseKeeper) run(logger log.Logger, ctx context.Context) error {
-	if err := this.inspectSessions(logger, ctx); err != nil {
+	if leader, err := this.isLeader(ctx); err != nil {
+		return err
+	} else if !leader {
+		// Another instance shares the same sessions and takes care of them.
+		logger.Debug("not the leader of the shared sessions; skipping inspection of sessions")
+	} else if err := this.inspectSessions(logger, ctx); err != nil {
 		return err
 	}
 	if err := this.cleanup(logger, ctx); err != nil {
@@ -100,6 +111,13 @@ func (this *houseKeeper) run(logger log.Logger, ctx context.Context) error {
 	return nil
 }
 
+func (this *houseKeeper) isLeader(ctx context.Context) (bool, error) {
+	if v, ok := this.service.sessions.(session.Elector); ok {
+		return v.IsLeader(ctx)
+	}
+	return true, nil
+}
+
 func (this *houseKeeper) inspectSessions(logger log.Logger, ctx context.Context) error {
 	return this.service.sessions.FindAll(ctx, this.inspectSession, &session.FindOpts{
 		AutoCleanUpAllowed: common.P(this.service.Configuration.HouseKeeping.AutoRepair),
@@ -120,7 +138,12 @@ func (this *houseKeeper) inspectSession(ctx context.Context, sess session.Sessio
 
 	logger.Debug("inspecting session...")
 
-	if shouldBeDeleted, err := session.IsExpiredWithThreshold(this.service.Configuration.HouseKeeping.KeepExpiredFor.Native())(ctx, sess); err != nil {
+	keepExpiredFor, err := this.keepExpiredFor(ctx, sess)
+	if err != nil {
+		return reportAndContinue(err)
+	}
+
+	if shouldBeDeleted, err := session.IsExpiredWithThreshold(keepExpiredFor)(ctx, sess); err != nil {
 		return reportAndContinue(err)
 	} else if shouldBeDeleted {
 		if _, err := this.dispose(ctx, logger, sess); err != nil {
@@ -155,6 +178,20 @@ func (this *houseKeeper) inspectSession(ctx context.Context, sess session.Sessio
 	return true, nil
 }
 
+// keepExpiredFor returns for how long the given session.Session should be kept
+// after it has expired, based on the first matching rule or the default.
+func (this *houseKeeper) keepExpiredFor(ctx context.Context, sess session.Session) (time.Duration, error) {
+	conf := &this.service.Configuration.HouseKeeping
+	for _, rule := range conf.Rules {
+		if ok, err := session.HasTags(rule.Tags)(ctx, sess); err != nil {
+			return 0, err
+		} else if ok {
+			return rule.KeepExpiredFor.Native(), nil
+		}
+	}
+	return conf.KeepExpiredFor.Native(), nil
+}
+
 // dispose will dispose a given session.Session but NOT delete it.
 func (this *houseKeeper) dispose(ctx context.Context, logger log.Logger, sess session.Session) (bool, error) {
 	fail := func(err error) (bool, error) {
@@ -174,6 +211,13 @@ func (this *houseKeeper) dispose(ctx context.Context, logger log.Logger, sess se
 		return fail(err)
 	}
 
+	if environmentDisposed {
+		this.service.emit(newSessionEvent(configuration.EventTypeEnvironmentRemoved, sess))
+	}
+	if sessionDisposed {
+		this.service.emit(newSessionEvent(configuration.EventTypeSessionDisposed, sess))
+	}
+
 	return environmentDisposed || authorizationDisposed || sessionDisposed, nil
 }
 
@@ -261,7 +305,9 @@ func (this *houseKeeper) Close() error {
 	if !this.closed.CompareAndSwap(false, true) {
 		return nil
 	}
-	this.contextCancel()
+	if v := this.contextCancel; v != nil {
+		v()
+	}
 	return nil
 }
 
@@ -2,6 +2,7 @@ package service
 
 import (
 	glssh "github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
 	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/engity-com/bifroest/pkg/authorization"
@@ -29,12 +30,15 @@ func (this *service) handlePublicKey(ctx glssh.Context, key glssh.PublicKey) boo
 		ctx.SetValue(handshakeKeyCtxKey, key)
 	}
 
+	authCtx, span := startSpan(conn.context, "ssh.authorize", attribute.String("bifroest.authorization.method", "publicKey"))
 	authReq := authorizeRequest{
 		service:    this,
 		connection: conn,
+		context:    authCtx,
 	}
 
 	auth, err := this.authorizer.AuthorizePublicKey(&publicKeyAuthorizeRequest{authReq, key})
+	this.afterAuthorize(conn, span, "publicKey", auth, err)
 	if err != nil {
 		if errors.IsType(err, errors.User) {
 			l.WithError(err).Debug("public key failed by user")
@@ -63,13 +67,16 @@ func (this *service) handlePassword(ctx glssh.Context, password string) bool {
 	conn := this.connection(ctx)
 	l := conn.logger
 
+	authCtx, span := startSpan(conn.context, "ssh.authorize", attribute.String("bifroest.authorization.method", "password"))
 	auth, err := this.authorizer.AuthorizePassword(&passwordAuthorizeRequest{
 		authorizeRequest: authorizeRequest{
 			service:    this,
 			connection: conn,
+			context:    authCtx,
 		},
 		password: password,
 	})
+	this.afterAuthorize(conn, span, "password", auth, err)
 	if err != nil {
 		if errors.IsType(err, errors.User) {
 			l.WithError(err).Debug("password failed by user")
@@ -95,13 +102,16 @@ func (this *service) handleKeyboardInteractiveChallenge(ctx glssh.Context, chall
 	conn := this.connection(ctx)
 	l := conn.logger
 
+	authCtx, span := startSpan(conn.context, "ssh.authorize", attribute.String("bifroest.authorization.method", "interactive"))
 	auth, err := this.authorizer.AuthorizeInteractive(&interactiveAuthorizeRequest{
 		authorizeRequest: authorizeRequest{
 			service:    this,
 			connection: conn,
+			context:    authCtx,
 		},
 		challenger: challenger,
 	})
+	this.afterAuthorize(conn, span, "interactive", auth, err)
 	if err != nil {
 		if errors.IsType(err, errors.User) {
 			l.WithError(err).Debug("interactive failed by user")
@@ -148,7 +158,11 @@ func (this *service) resolveAuthorizationAndSession(ctx glssh.Context) (authoriz
 				return failf(errors.System, "cannot add public key to session: %w", err)
 			}
 		}
+		if err := this.applySessionTags(ctx, this.connection(ctx), auth, sess); err != nil {
+			return nil, nil, 0, err
+		}
 	}
+	this.announceSession(this.connection(ctx), auth, oldState)
 
 	return auth, sess, oldState, nil
 }
@@ -166,9 +180,9 @@ func (this *service) onPtyRequest(ctx glssh.Context, pty glssh.Pty) bool {
 	logger := conn.Logger()
 
 	ok, err := this.environments.DoesSupportPty(&environmentContext{
-		this,
-		conn,
-		auth,
+		service:       this,
+		connection:    conn,
+		authorization: auth,
 	}, pty)
 	if this.isRelevantError(err) {
 		logger.WithError(err).Warn("cannot evaluate if PTY is allowed or not for request")
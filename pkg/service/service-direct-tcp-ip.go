@@ -6,7 +6,9 @@ import (
 	"syscall"
 	"time"
 
+	log "github.com/echocat/slf4g"
 	glssh "github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
 	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/engity-com/bifroest/pkg/common"
@@ -35,6 +37,7 @@ func (this localForwardChannelData) dest() (net.HostPort, error) {
 func (this *service) handleNewDirectTcpIp(_ *glssh.Server, _ *gossh.ServerConn, newChan gossh.NewChannel, ctx glssh.Context) {
 	conn := this.connection(ctx)
 	l := conn.logger
+	defer conn.onChannel()()
 
 	auth, _, _, err := this.resolveAuthorizationAndSession(ctx)
 	if err != nil {
@@ -61,16 +64,34 @@ func (this *service) handleNewDirectTcpIp(_ *glssh.Server, _ *gossh.ServerConn,
 
 	l = l.With("dest", dest)
 
+	ok, rule, destinations, err := this.evaluatePortForwardingRules(ctx, conn, auth, dest)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot check if port forwarding is allowed; rejecting...")
+		_ = newChan.Reject(gossh.ConnectionFailed, "port forwarding is disabled")
+		return
+	} else if !ok {
+		this.onPortForwardingRejected(conn, auth, portForwardingDirectionLocal, dest, rule)
+		_ = newChan.Reject(gossh.Prohibited, fmt.Sprintf("port forwarding to %v is not allowed", dest))
+		return
+	}
+
+	fwdCtx, span := startSpan(conn.context, "ssh.directTcpIp",
+		attribute.String("bifroest.forward.destination", dest.String()),
+	)
+	defer span.End()
+
 	req := environmentRequest{
 		environmentContext{
 			service:       this,
 			connection:    conn,
 			authorization: auth,
+			context:       fwdCtx,
 		},
 		nil,
 	}
 
-	env, err := this.environments.Ensure(&req)
+	env, err := this.ensureEnvironment(&req)
 	if err != nil {
 		l.WithError(err).
 			Error("cannot ensure environment; rejecting...")
@@ -90,7 +111,7 @@ func (this *service) handleNewDirectTcpIp(_ *glssh.Server, _ *gossh.ServerConn,
 		return
 	}
 
-	dConn, err := env.NewDestinationConnection(ctx, dest)
+	dConn, err := newPortForwardingDestinationConnection(fwdCtx, env, destinations)
 	if err != nil {
 		var re errors.RemoteError
 		if errors.As(err, &re) {
@@ -115,6 +136,12 @@ func (this *service) handleNewDirectTcpIp(_ *glssh.Server, _ *gossh.ServerConn,
 	}
 	defer common.IgnoreCloseError(dConn)
 
+	this.forwardFromClient(ctx, l, newChan, dConn)
+}
+
+// forwardFromClient accepts the given channel of the client and copies
+// everything between it and the given dConn.
+func (this *service) forwardFromClient(ctx glssh.Context, l log.Logger, newChan gossh.NewChannel, dConn io.ReadWriteCloser) {
 	sConn, reqs, err := newChan.Accept()
 	if err != nil {
 		return
@@ -162,11 +189,6 @@ func (this *service) handleNewDirectTcpIp(_ *glssh.Server, _ *gossh.ServerConn,
 	})
 }
 
-func (this *service) onReversePortForwardingRequested(_ glssh.Context, _ string, _ uint32) bool {
-	// TODO! Maybe more checks here in the future?
-	return true
-}
-
 func (this *service) reWrapUserFacingErrors(err error) *errors.Error {
 	if err == nil {
 		return nil
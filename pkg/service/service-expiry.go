@@ -0,0 +1,209 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+const (
+	// expiryLead is how long before the actual expiry an interactive session
+	// is ended, because the connection itself will be closed exactly at the
+	// expiry, which would leave no room to tell the client what happened.
+	expiryLead = 2 * time.Second
+
+	// expiryRecheckInterval is the maximum duration between two evaluations of
+	// the expiry, because the validity of a session can change over time.
+	expiryRecheckInterval = time.Minute
+
+	expiryReasonConnection = "connection"
+	expiryReasonSession    = "session"
+)
+
+var errSessionExpired = errors.User.Newf("session expired")
+
+// expiryOf returns when the given connection will be closed because of
+// configuration.Ssh.MaxTimeout or because its session is no longer valid. The
+// result is zero if it never expires.
+func (this *service) expiryOf(conn *connection, auth authorization.Authorization) (result time.Time, reason string, _ error) {
+	if v := this.Configuration.Ssh.MaxTimeout; !v.IsZero() {
+		result = time.UnixMilli(conn.created + v.Native().Milliseconds())
+		reason = expiryReasonConnection
+	}
+
+	sess := auth.FindSession()
+	if sess == nil {
+		return result, reason, nil
+	}
+	si, err := sess.Info(conn.context)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	validUntil, err := si.ValidUntil(conn.context)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if !validUntil.IsZero() && (result.IsZero() || validUntil.Before(result)) {
+		result = validUntil
+		reason = expiryReasonSession
+	}
+	return result, reason, nil
+}
+
+// watchExpiry writes warnings to the terminal of the given glssh.Session
+// before its connection expires. Shortly before the expiry onExpired is
+// called, to give the session the chance to end gracefully. The returned
+// expiryWatcher has to be stopped once the glssh.Session has ended.
+func (this *service) watchExpiry(sshSess glssh.Session, conn *connection, auth authorization.Authorization, onExpired func()) *expiryWatcher {
+	result := &expiryWatcher{
+		service:       this,
+		sshSession:    sshSess,
+		connection:    conn,
+		authorization: auth,
+		onExpired:     onExpired,
+		done:          make(chan struct{}),
+	}
+	go result.run()
+	return result
+}
+
+type expiryWatcher struct {
+	service       *service
+	sshSession    glssh.Session
+	connection    *connection
+	authorization authorization.Authorization
+	onExpired     func()
+
+	expired  atomic.Bool
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// warned is the amount of configuration.SshExpiry.WarnBefore entries
+	// which were already announced.
+	warned int
+}
+
+func (this *expiryWatcher) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-this.done:
+			return
+		case <-this.sshSession.Context().Done():
+			return
+		case <-timer.C:
+		}
+
+		next, err := this.check()
+		if err != nil {
+			this.connection.logger.
+				WithError(err).
+				Warn("cannot evaluate expiry of session; will try again later")
+			next = expiryRecheckInterval
+		}
+		if next < 0 {
+			return
+		}
+		timer.Reset(next)
+	}
+}
+
+// check warns or expires if required and returns when it should be called
+// again. A negative result means it is already expired.
+func (this *expiryWatcher) check() (time.Duration, error) {
+	expiresAt, reason, err := this.service.expiryOf(this.connection, this.authorization)
+	if err != nil {
+		return 0, err
+	}
+	if expiresAt.IsZero() {
+		this.warned = 0
+		return expiryRecheckInterval, nil
+	}
+
+	conf := &this.service.Configuration.Ssh.Expiry
+	ctx := &expiryContext{
+		context:       this.sshSession.Context(),
+		authorization: this.authorization,
+		expiresAt:     expiresAt,
+		reason:        reason,
+	}
+
+	remaining := time.Until(expiresAt)
+	if remaining <= expiryLead {
+		this.expire(&conf.Message, ctx)
+		return -1, nil
+	}
+
+	// WarnBefore is ordered from the longest to the shortest duration, which
+	// means all already reached ones are at the beginning.
+	reached := 0
+	for _, v := range conf.WarnBefore {
+		if remaining <= v.Native() {
+			reached++
+		}
+	}
+	if reached > this.warned {
+		this.write(&conf.Warning, ctx, "warning")
+	}
+	// If the session was extended in the meantime, the warnings will start again.
+	this.warned = reached
+
+	next := min(remaining-expiryLead, expiryRecheckInterval)
+	if reached < len(conf.WarnBefore) {
+		next = min(next, remaining-conf.WarnBefore[reached].Native())
+	}
+	return next, nil
+}
+
+func (this *expiryWatcher) expire(message *template.String, ctx *expiryContext) {
+	this.write(message, ctx, "message")
+	this.expired.Store(true)
+	this.connection.logger.
+		With("reason", ctx.reason).
+		With("expiresAt", ctx.expiresAt).
+		Info("session expired; ending it...")
+	this.onExpired()
+}
+
+func (this *expiryWatcher) write(tmpl *template.String, ctx *expiryContext, kind string) {
+	l := this.connection.logger.With("kind", kind)
+	msg, err := tmpl.Render(ctx)
+	if err != nil {
+		l.WithError(err).Warn("cannot render expiry message; showing none")
+		return
+	}
+	if msg == "" {
+		return
+	}
+	if err := writeTerminalMessage(this.sshSession, msg); err != nil {
+		l.WithError(err).Debug("cannot write expiry message to terminal")
+	}
+}
+
+func (this *expiryWatcher) hasExpired() bool {
+	return this != nil && this.expired.Load()
+}
+
+// result returns the given exit code and error of the run of a session,
+// unless it has expired. In this case configuration.SshExpiry.ExitCode and
+// errSessionExpired are returned instead.
+func (this *expiryWatcher) result(exitCode int, err error) (int, error) {
+	if !this.hasExpired() {
+		return exitCode, err
+	}
+	return int(this.service.Configuration.Ssh.Expiry.ExitCode), errSessionExpired
+}
+
+func (this *expiryWatcher) stop() {
+	this.stopOnce.Do(func() {
+		close(this.done)
+	})
+}
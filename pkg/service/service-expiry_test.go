@@ -0,0 +1,209 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+func TestExpiryWatcher_check(t *testing.T) {
+	cases := []struct {
+		name            string
+		maxTimeout      time.Duration
+		age             time.Duration
+		alreadyWarned   int
+		expectedNext    time.Duration
+		expectedOutput  string
+		expectedWarned  int
+		expectedExpired bool
+	}{{
+		name:         "never-expires",
+		expectedNext: expiryRecheckInterval,
+	}, {
+		name:         "far-away",
+		maxTimeout:   time.Hour,
+		expectedNext: expiryRecheckInterval,
+	}, {
+		name:           "first-warning",
+		maxTimeout:     time.Hour,
+		age:            50 * time.Minute,
+		expectedNext:   expiryRecheckInterval,
+		expectedOutput: "\r\nwarning: connection expires in 10m0s\r\n",
+		expectedWarned: 1,
+	}, {
+		name:           "first-warning-already-written",
+		maxTimeout:     time.Hour,
+		age:            50 * time.Minute,
+		alreadyWarned:  1,
+		expectedNext:   expiryRecheckInterval,
+		expectedWarned: 1,
+	}, {
+		name:           "all-warnings-reached-at-once",
+		maxTimeout:     time.Hour,
+		age:            time.Hour - 30*time.Second,
+		expectedNext:   30*time.Second - expiryLead,
+		expectedOutput: "\r\nwarning: connection expires in 30s\r\n",
+		expectedWarned: 3,
+	}, {
+		name:            "expired",
+		maxTimeout:      time.Hour,
+		age:             time.Hour,
+		expectedNext:    -1,
+		expectedOutput:  "\r\nexpired: connection\r\n",
+		expectedExpired: true,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			watcher, sshSess := newTestExpiryWatcher(t, c.maxTimeout, configuration.DefaultSshExpiryWarnBefore)
+			watcher.connection.created = time.Now().Add(-c.age).UnixMilli()
+			watcher.warned = c.alreadyWarned
+
+			actualNext, actualErr := watcher.check()
+			require.NoError(t, actualErr)
+
+			require.InDelta(t, c.expectedNext, actualNext, float64(time.Second))
+			require.Equal(t, c.expectedOutput, sshSess.stderr.String())
+			require.Equal(t, c.expectedWarned, watcher.warned)
+			require.Equal(t, c.expectedExpired, watcher.hasExpired())
+			require.Equal(t, c.expectedExpired, sshSess.expiredCalls.Load() > 0)
+		})
+	}
+}
+
+func TestExpiryWatcher_run(t *testing.T) {
+	watcher, sshSess := newTestExpiryWatcher(t, expiryLead+time.Second, configuration.SshExpiryWarnBefore{
+		common.DurationOf(expiryLead + 900*time.Millisecond),
+		common.DurationOf(expiryLead + 500*time.Millisecond),
+	})
+	go watcher.run()
+	defer watcher.stop()
+
+	select {
+	case <-sshSess.expired:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "session did not expire")
+	}
+
+	require.True(t, watcher.hasExpired())
+	require.Equal(t, int64(1), sshSess.expiredCalls.Load())
+	output := sshSess.stderr.String()
+	require.Equal(t, 2, strings.Count(output, "warning: connection expires in"), output)
+	require.True(t, strings.HasSuffix(output, "\r\nexpired: connection\r\n"), output)
+
+	actualExitCode, actualErr := watcher.result(0, nil)
+	require.Equal(t, 66, actualExitCode)
+	require.ErrorIs(t, actualErr, errSessionExpired)
+}
+
+func TestExpiryWatcher_stop(t *testing.T) {
+	watcher, sshSess := newTestExpiryWatcher(t, expiryLead+500*time.Millisecond, nil)
+	go watcher.run()
+	watcher.stop()
+	watcher.stop()
+
+	time.Sleep(time.Second)
+	require.False(t, watcher.hasExpired())
+	require.Zero(t, sshSess.expiredCalls.Load())
+
+	actualExitCode, actualErr := watcher.result(1, io.EOF)
+	require.Equal(t, 1, actualExitCode)
+	require.ErrorIs(t, actualErr, io.EOF)
+}
+
+func TestExpiryWatcher_result_nil(t *testing.T) {
+	var watcher *expiryWatcher
+	actualExitCode, actualErr := watcher.result(2, nil)
+	require.Equal(t, 2, actualExitCode)
+	require.NoError(t, actualErr)
+}
+
+func newTestExpiryWatcher(t *testing.T, maxTimeout time.Duration, warnBefore configuration.SshExpiryWarnBefore) (*expiryWatcher, *testExpirySshSession) {
+	t.Helper()
+	svc := newTestAdministration(t).svc
+	svc.Configuration.Ssh.MaxTimeout = common.DurationOf(maxTimeout)
+	svc.Configuration.Ssh.Expiry = configuration.SshExpiry{
+		WarnBefore: warnBefore,
+		Warning:    template.MustNewString("warning: {{.reason}} expires in {{.remaining}}"),
+		Message:    template.MustNewString("expired: {{.reason}}"),
+		ExitCode:   66,
+	}
+	conn := newTestConnection(t, svc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sshSess := &testExpirySshSession{
+		context: &testSshContext{Context: ctx},
+		expired: make(chan struct{}),
+	}
+
+	result := &expiryWatcher{
+		service:       svc,
+		sshSession:    sshSess,
+		connection:    conn,
+		authorization: authorization.Forbidden(conn.Remote()),
+		onExpired: func() {
+			if sshSess.expiredCalls.Add(1) == 1 {
+				close(sshSess.expired)
+			}
+		},
+		done: make(chan struct{}),
+	}
+	return result, sshSess
+}
+
+type testExpirySshSession struct {
+	glssh.Session
+	context      glssh.Context
+	stderr       testSyncBuffer
+	expired      chan struct{}
+	expiredCalls atomic.Int64
+}
+
+func (this *testExpirySshSession) Context() glssh.Context {
+	return this.context
+}
+
+func (this *testExpirySshSession) Pty() (glssh.Pty, <-chan glssh.Window, bool) {
+	return glssh.Pty{Term: "xterm"}, nil, true
+}
+
+func (this *testExpirySshSession) Stderr() io.ReadWriter {
+	return &this.stderr
+}
+
+type testSyncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (this *testSyncBuffer) Read(p []byte) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.buf.Read(p)
+}
+
+func (this *testSyncBuffer) Write(p []byte) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.buf.Write(p)
+}
+
+func (this *testSyncBuffer) String() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.buf.String()
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"io"
+	gonet "net"
+	"path"
+	"strconv"
+	"strings"
+
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/environment"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+const (
+	portForwardingDirectionLocal  = "local"
+	portForwardingDirectionRemote = "remote"
+)
+
+// evaluatePortForwardingRules evaluates the configuration.PortForwardingRules
+// of the flow of the given authorization against the given target, which is
+// either the destination (local forwarding) or the bind address (remote
+// forwarding). The first matching rule decides; it is returned as rule. If no
+// rule matches, the request is allowed.
+//
+// If any rule matches IPs, a host name is resolved and it is only allowed if
+// all of its addresses are allowed. This prevents that a host name is used to
+// reach an IP which is forbidden. In this case, the resolved addresses are
+// returned as checked, which have to be used instead of the target;
+// otherwise, checked only contains the target itself.
+func (this *service) evaluatePortForwardingRules(ctx glssh.Context, conn *connection, auth authorization.Authorization, target net.HostPort) (allowed bool, rule string, checked []net.HostPort, err error) {
+	fail := func(err error) (bool, string, []net.HostPort, error) {
+		return false, rule, nil, errors.Config.Newf("cannot evaluate port forwarding rule %s: %w", rule, err)
+	}
+
+	flow := this.flowConfiguration(auth.Flow())
+	if flow == nil || len(flow.PortForwardingRules) == 0 {
+		return true, "", []net.HostPort{target}, nil
+	}
+
+	data := &environmentContext{
+		service:       this,
+		connection:    conn,
+		authorization: auth,
+		context:       ctx,
+	}
+	var rules []portForwardingRule
+	for i, candidate := range flow.PortForwardingRules {
+		rule = candidate.Name
+		if rule == "" {
+			rule = "#" + strconv.Itoa(i+1)
+		}
+
+		if enabled, err := candidate.Enabled.Render(data); err != nil {
+			return fail(err)
+		} else if !enabled {
+			continue
+		}
+		hosts, err := candidate.Hosts.Render(data)
+		if err != nil {
+			return fail(err)
+		}
+		ports, err := candidate.Ports.Render(data)
+		if err != nil {
+			return fail(err)
+		}
+		rules = append(rules, portForwardingRule{
+			name:  rule,
+			hosts: hosts,
+			ports: ports,
+			allow: candidate.Action == configuration.PortForwardingActionAllow,
+		})
+	}
+	rule = ""
+
+	addresses := []portForwardingAddress{portForwardingAddressOf(target)}
+	checked = []net.HostPort{target}
+	if len(target.Host.IP) == 0 && target.Host.Dns != "" && portForwardingRulesMatchIps(rules) {
+		if addresses, err = resolvePortForwardingAddresses(ctx, target); err != nil {
+			return false, "", nil, err
+		}
+		checked = make([]net.HostPort, len(addresses))
+		for i, address := range addresses {
+			checked[i] = net.HostPort{Host: net.Host{IP: address.ip}, Port: address.port}
+		}
+	}
+
+	if allowed, rule, err = evaluatePortForwardingRulesFor(rules, addresses); err != nil || !allowed {
+		return false, rule, nil, err
+	}
+	return true, rule, checked, nil
+}
+
+// newPortForwardingDestinationConnection connects inside the given
+// environment to the first of the given destinations (as checked by
+// evaluatePortForwardingRules) which is reachable. Using the checked
+// addresses ensures that the environment never resolves a host name to
+// another address than the one which was checked.
+func newPortForwardingDestinationConnection(ctx context.Context, env environment.Environment, destinations []net.HostPort) (io.ReadWriteCloser, error) {
+	var lastErr error
+	for _, destination := range destinations {
+		conn, err := env.NewDestinationConnection(ctx, destination)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// portForwardingRule is a rendered configuration.PortForwardingRule.
+type portForwardingRule struct {
+	name  string
+	hosts []string
+	ports []string
+	allow bool
+}
+
+// portForwardingAddress is one address a port forwarding target refers to.
+// name is empty if the target was requested as an IP; ip is nil if the target
+// is a host name which was not resolved.
+type portForwardingAddress struct {
+	name string
+	ip   gonet.IP
+	port uint16
+}
+
+func portForwardingAddressOf(target net.HostPort) portForwardingAddress {
+	if len(target.Host.IP) > 0 {
+		return portForwardingAddress{ip: target.Host.IP, port: target.Port}
+	}
+	return portForwardingAddress{name: target.Host.Dns, port: target.Port}
+}
+
+// resolvePortForwardingAddresses resolves the host name of the given target
+// into all of its addresses.
+func resolvePortForwardingAddresses(ctx context.Context, target net.HostPort) ([]portForwardingAddress, error) {
+	ips, err := gonet.DefaultResolver.LookupIP(ctx, "ip", target.Host.Dns)
+	if err != nil {
+		return nil, errors.Network.Newf("cannot resolve %s to evaluate port forwarding rules: %w", target.Host.Dns, err)
+	}
+	result := make([]portForwardingAddress, len(ips))
+	for i, ip := range ips {
+		result[i] = portForwardingAddress{name: target.Host.Dns, ip: ip, port: target.Port}
+	}
+	return result, nil
+}
+
+// portForwardingRulesMatchIps reports whether any of the given rules contains
+// a host pattern which is an IP, a CIDR or a wildcard of an IP (10.1.2.*).
+func portForwardingRulesMatchIps(rules []portForwardingRule) bool {
+	for _, rule := range rules {
+		for _, pattern := range rule.hosts {
+			pattern = strings.TrimSpace(pattern)
+			if strings.ContainsRune(pattern, '/') ||
+				gonet.ParseIP(pattern) != nil ||
+				(strings.ContainsAny(pattern, "*?[") && strings.Trim(pattern, "0123456789.:*?[]-") == "") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// evaluatePortForwardingRulesFor evaluates the given rules against each of
+// the given addresses. The request is only allowed if it is allowed for all
+// addresses; otherwise the rule which rejected it is returned.
+func evaluatePortForwardingRulesFor(rules []portForwardingRule, addresses []portForwardingAddress) (allowed bool, rule string, err error) {
+	for _, address := range addresses {
+		for _, candidate := range rules {
+			ok, err := portForwardingRuleMatches(candidate.hosts, candidate.ports, address)
+			if err != nil {
+				return false, candidate.name, errors.Config.Newf("cannot evaluate port forwarding rule %s: %w", candidate.name, err)
+			}
+			if !ok {
+				continue
+			}
+			if !candidate.allow {
+				return false, candidate.name, nil
+			}
+			rule = candidate.name
+			break
+		}
+	}
+	return true, rule, nil
+}
+
+// onPortForwardingRejected logs and emits the rejection of a port forwarding
+// request by the given rule.
+func (this *service) onPortForwardingRejected(conn *connection, auth authorization.Authorization, direction string, target net.HostPort, rule string) {
+	conn.logger.
+		With("direction", direction).
+		With("target", target).
+		With("rule", rule).
+		Info("port forwarding requested by client was rejected by rule")
+
+	this.emit(this.newConnectionEvent(configuration.EventTypePortForwardingRejected, conn, auth).
+		With("direction", direction).
+		With("target", target.String()).
+		With("rule", rule))
+}
+
+func portForwardingRuleMatches(hosts, ports []string, target portForwardingAddress) (bool, error) {
+	if len(hosts) > 0 {
+		matches := false
+		for _, pattern := range hosts {
+			if ok, err := portForwardingHostMatches(pattern, target); err != nil {
+				return false, err
+			} else if ok {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return false, nil
+		}
+	}
+
+	if len(ports) > 0 {
+		matches := false
+		for _, pattern := range ports {
+			if ok, err := portForwardingPortMatches(pattern, target.port); err != nil {
+				return false, err
+			} else if ok {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// portForwardingHostMatches checks the given address against the given
+// pattern, which is either a CIDR (10.0.0.0/8), a wildcard (*.example.org),
+// an IP or an exact host name. CIDRs and IPs only match the IP of the
+// address, host names only its name, if it was requested by name. Wildcards
+// match both.
+func portForwardingHostMatches(pattern string, address portForwardingAddress) (bool, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return false, errors.Config.Newf("empty host pattern")
+	}
+
+	if strings.ContainsRune(pattern, '/') {
+		_, cidr, err := gonet.ParseCIDR(pattern)
+		if err != nil {
+			return false, errors.Config.Newf("illegal host pattern %q: %w", pattern, err)
+		}
+		return len(address.ip) > 0 && cidr.Contains(address.ip), nil
+	}
+
+	if strings.ContainsAny(pattern, "*?[") {
+		var subjects []string
+		if address.name != "" {
+			subjects = append(subjects, address.name)
+		}
+		if len(address.ip) > 0 {
+			subjects = append(subjects, address.ip.String())
+		}
+		for _, subject := range subjects {
+			ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(subject))
+			if err != nil {
+				return false, errors.Config.Newf("illegal host pattern %q: %w", pattern, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if ip := gonet.ParseIP(pattern); ip != nil {
+		return ip.Equal(address.ip), nil
+	}
+	return address.name != "" && strings.EqualFold(strings.TrimSuffix(pattern, "."), strings.TrimSuffix(address.name, ".")), nil
+}
+
+// portForwardingPortMatches checks the given port against the given pattern,
+// which is either a single port (443) or a range of ports (8000-8999).
+func portForwardingPortMatches(pattern string, port uint16) (bool, error) {
+	pattern = strings.TrimSpace(pattern)
+	parse := func(in string) (uint16, error) {
+		v, err := strconv.ParseUint(strings.TrimSpace(in), 10, 16)
+		if err != nil {
+			return 0, errors.Config.Newf("illegal port pattern %q: %w", pattern, err)
+		}
+		return uint16(v), nil
+	}
+
+	if from, to, ok := strings.Cut(pattern, "-"); ok {
+		min, err := parse(from)
+		if err != nil {
+			return false, err
+		}
+		max, err := parse(to)
+		if err != nil {
+			return false, err
+		}
+		if min > max {
+			return false, errors.Config.Newf("illegal port pattern %q: start is greater than end", pattern)
+		}
+		return port >= min && port <= max, nil
+	}
+
+	v, err := parse(pattern)
+	if err != nil {
+		return false, err
+	}
+	return port == v, nil
+}
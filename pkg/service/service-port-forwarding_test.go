@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"io"
+	gonet "net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/environment"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+func TestPortForwardingRuleMatches(t *testing.T) {
+	cases := []struct {
+		name          string
+		hosts         []string
+		ports         []string
+		target        string
+		expected      bool
+		expectedError string
+	}{{
+		name:     "everything",
+		target:   "example.org:22",
+		expected: true,
+	}, {
+		name:     "exact-host",
+		hosts:    []string{"EXAMPLE.org"},
+		target:   "example.org:22",
+		expected: true,
+	}, {
+		name:     "exact-host-mismatch",
+		hosts:    []string{"example.com"},
+		target:   "example.org:22",
+		expected: false,
+	}, {
+		name:     "wildcard",
+		hosts:    []string{"*.example.org"},
+		target:   "db.example.org:5432",
+		expected: true,
+	}, {
+		name:     "wildcard-does-not-match-parent",
+		hosts:    []string{"*.example.org"},
+		target:   "example.org:5432",
+		expected: false,
+	}, {
+		name:     "cidr",
+		hosts:    []string{"10.0.0.0/8"},
+		target:   "10.1.2.3:80",
+		expected: true,
+	}, {
+		name:     "cidr-does-not-match-unresolved-name",
+		hosts:    []string{"127.0.0.0/8"},
+		target:   "localhost:80",
+		expected: false,
+	}, {
+		name:     "ip",
+		hosts:    []string{"::1"},
+		target:   "[::1]:80",
+		expected: true,
+	}, {
+		name:     "port-range",
+		hosts:    []string{"example.com", "example.org"},
+		ports:    []string{"22", "8000-8999"},
+		target:   "example.org:8080",
+		expected: true,
+	}, {
+		name:     "port-mismatch",
+		ports:    []string{"22", "8000-8999"},
+		target:   "example.org:9000",
+		expected: false,
+	}, {
+		name:          "illegal-port",
+		ports:         []string{"http"},
+		target:        "example.org:80",
+		expectedError: `illegal port pattern "http"`,
+	}, {
+		name:          "illegal-port-range",
+		ports:         []string{"90-80"},
+		target:        "example.org:80",
+		expectedError: `illegal port pattern "90-80": start is greater than end`,
+	}, {
+		name:          "illegal-cidr",
+		hosts:         []string{"10.0.0.0/33"},
+		target:        "10.0.0.1:80",
+		expectedError: `illegal host pattern "10.0.0.0/33"`,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := portForwardingRuleMatches(c.hosts, c.ports, portForwardingAddressOf(net.MustNewHostPort(c.target)))
+			if c.expectedError != "" {
+				require.ErrorContains(t, err, c.expectedError)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestEvaluatePortForwardingRulesFor(t *testing.T) {
+	rules := []portForwardingRule{{
+		name:  "internal",
+		hosts: []string{"10.0.0.0/8"},
+		allow: true,
+	}, {
+		name:  "db",
+		hosts: []string{"db.example.org"},
+		ports: []string{"5432"},
+		allow: true,
+	}, {
+		name: "everything-else",
+	}}
+	address := func(name, ip string, port uint16) portForwardingAddress {
+		return portForwardingAddress{name: name, ip: gonet.ParseIP(ip), port: port}
+	}
+
+	cases := []struct {
+		name         string
+		addresses    []portForwardingAddress
+		expected     bool
+		expectedRule string
+	}{{
+		name:         "ip",
+		addresses:    []portForwardingAddress{address("", "10.1.2.3", 80)},
+		expected:     true,
+		expectedRule: "internal",
+	}, {
+		name:         "name-resolved-to-allowed-ips",
+		addresses:    []portForwardingAddress{address("foo.example.org", "10.1.2.3", 80), address("foo.example.org", "10.3.2.1", 80)},
+		expected:     true,
+		expectedRule: "internal",
+	}, {
+		name:         "name-resolved-to-one-forbidden-ip",
+		addresses:    []portForwardingAddress{address("foo.example.org", "10.1.2.3", 80), address("foo.example.org", "192.168.0.1", 80)},
+		expected:     false,
+		expectedRule: "everything-else",
+	}, {
+		name:         "name-resolved-to-ip-matching-wildcard",
+		addresses:    []portForwardingAddress{address("foo.example.org", "10.1.2.3", 80)},
+		expected:     true,
+		expectedRule: "internal",
+	}, {
+		name:         "name-allowed-by-name",
+		addresses:    []portForwardingAddress{address("db.example.org", "192.168.0.1", 5432)},
+		expected:     true,
+		expectedRule: "db",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, actualRule, err := evaluatePortForwardingRulesFor(rules, c.addresses)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+			require.Equal(t, c.expectedRule, actualRule)
+		})
+	}
+}
+
+func TestService_evaluatePortForwardingRules_hostname(t *testing.T) {
+	svc := newTestAdministration(t).svc
+	conn := newTestConnection(t, svc)
+	auth := &testAuthorization{authorization.Forbidden(conn.Remote())}
+	svc.Configuration.Flows[0].PortForwardingRules = configuration.PortForwardingRules{{
+		Name:    "no-loopback",
+		Enabled: template.BoolOf(true),
+		Action:  configuration.PortForwardingActionDeny,
+		Hosts:   template.MustNewStrings("127.0.0.0/8", "::1/128"),
+	}, {
+		Name:    "no-internal",
+		Enabled: template.BoolOf(true),
+		Action:  configuration.PortForwardingActionDeny,
+		Hosts:   template.MustNewStrings("192.168.*"),
+	}}
+
+	cases := []struct {
+		target       string
+		expected     bool
+		expectedRule string
+	}{
+		{"127.0.0.1:80", false, "no-loopback"},
+		// localhost has to be resolved, otherwise it would bypass the rule.
+		{"localhost:80", false, "no-loopback"},
+		{"10.0.0.1:80", true, ""},
+		{"192.168.0.1:80", false, "no-internal"},
+	}
+	for _, c := range cases {
+		t.Run(c.target, func(t *testing.T) {
+			actual, actualRule, _, err := svc.evaluatePortForwardingRules(conn.context, conn, auth, net.MustNewHostPort(c.target))
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+			require.Equal(t, c.expectedRule, actualRule)
+		})
+	}
+
+	_, _, _, err := svc.evaluatePortForwardingRules(conn.context, conn, auth, net.MustNewHostPort("does-not-exist.invalid:80"))
+	require.ErrorContains(t, err, "cannot resolve does-not-exist.invalid")
+}
+
+func TestService_portForwarding_dialsCheckedAddresses(t *testing.T) {
+	svc := newTestAdministration(t).svc
+	conn := newTestConnection(t, svc)
+	auth := &testAuthorization{authorization.Forbidden(conn.Remote())}
+	svc.Configuration.Flows[0].PortForwardingRules = configuration.PortForwardingRules{{
+		Name:    "no-metadata",
+		Enabled: template.BoolOf(true),
+		Action:  configuration.PortForwardingActionDeny,
+		Hosts:   template.MustNewStrings("169.254.0.0/16"),
+	}}
+
+	allowed, _, destinations, err := svc.evaluatePortForwardingRules(conn.context, conn, auth, net.MustNewHostPort("localhost:80"))
+	require.NoError(t, err)
+	require.True(t, allowed)
+	require.NotEmpty(t, destinations)
+
+	// Inside the environment, localhost resolves to the forbidden address.
+	env := &testResolvingEnvironment{hosts: map[string]gonet.IP{
+		"localhost": gonet.ParseIP("169.254.169.254"),
+	}}
+	actual, err := newPortForwardingDestinationConnection(conn.context, env, destinations)
+	require.NoError(t, err)
+	require.NoError(t, actual.Close())
+
+	require.NotEmpty(t, env.dialed)
+	for _, ip := range env.dialed {
+		require.True(t, ip.IsLoopback(), "%v", ip)
+	}
+}
+
+// testResolvingEnvironment simulates an environment which resolves host
+// names on its own; it records the IPs it was asked to connect to.
+type testResolvingEnvironment struct {
+	environment.Environment
+	hosts  map[string]gonet.IP
+	dialed []gonet.IP
+}
+
+func (this *testResolvingEnvironment) NewDestinationConnection(_ context.Context, dest net.HostPort) (io.ReadWriteCloser, error) {
+	ip := dest.Host.IP
+	if len(ip) == 0 {
+		ip = this.hosts[dest.Host.Dns]
+	}
+	this.dialed = append(this.dialed, ip)
+	client, server := gonet.Pipe()
+	common.IgnoreCloseError(server)
+	return client, nil
+}
+
+func TestResolvePortForwardingAddresses(t *testing.T) {
+	actual, err := resolvePortForwardingAddresses(context.Background(), net.MustNewHostPort("localhost:80"))
+	require.NoError(t, err)
+	require.NotEmpty(t, actual)
+	for _, address := range actual {
+		require.Equal(t, "localhost", address.name)
+		require.True(t, address.ip.IsLoopback(), "%v", address.ip)
+		require.Equal(t, uint16(80), address.port)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/session"
+)
+
+const (
+	// sessionNoteCommand is the command which can be executed to add a note
+	// to the session of the connection. Example: bifroest-note deployed v1.2.3
+	sessionNoteCommand = "bifroest-note"
+)
+
+func (this *service) flowConfiguration(name configuration.FlowName) *configuration.Flow {
+	for i, candidate := range this.Configuration.Flows {
+		if candidate.Name.IsEqualTo(name) {
+			return &this.Configuration.Flows[i]
+		}
+	}
+	return nil
+}
+
+// applySessionTags renders the configuration.SessionTags of the flow of the
+// given authorization and attaches them to its new session.Session.
+func (this *service) applySessionTags(ctx glssh.Context, conn *connection, auth authorization.Authorization, sess session.Session) error {
+	fail := func(err error) error {
+		return errors.System.Newf("cannot apply tags to session %v: %w", sess, err)
+	}
+
+	flow := this.flowConfiguration(auth.Flow())
+	if flow == nil || len(flow.SessionTags) == 0 {
+		return nil
+	}
+
+	data := &environmentContext{
+		service:       this,
+		connection:    conn,
+		authorization: auth,
+		context:       ctx,
+	}
+	tags := make(session.Tags, len(flow.SessionTags))
+	for k, tmpl := range flow.SessionTags {
+		v, err := tmpl.Render(data)
+		if err != nil {
+			return fail(errors.Config.Newf("cannot render tag %q: %w", k, err))
+		}
+		if v = strings.TrimSpace(v); v != "" {
+			tags[k] = v
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	if err := sess.AddTags(ctx, tags); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// isSessionNoteRequest returns true if the given glssh.Session executes
+// sessionNoteCommand.
+func isSessionNoteRequest(sshSess glssh.Session) bool {
+	args := sshSess.Command()
+	return len(args) > 0 && args[0] == sessionNoteCommand
+}
+
+// addSessionNote adds the arguments of sessionNoteCommand as session.Note to
+// the given session.Session.
+func (this *service) addSessionNote(sshSess glssh.Session, conn *connection, sess session.Session) (int, error) {
+	text := strings.TrimSpace(strings.Join(sshSess.Command()[1:], " "))
+	if text == "" {
+		if err := writeTerminalMessage(sshSess, "Usage: "+sessionNoteCommand+" <text>"); err != nil {
+			return -1, errors.Network.Newf("cannot send message to terminal: %w", err)
+		}
+		return 1, nil
+	}
+
+	if err := sess.AddNote(sshSess.Context(), session.Note{
+		At:     time.Now().Truncate(time.Millisecond),
+		Author: conn.Remote().String(),
+		Text:   text,
+	}); err != nil {
+		return -1, err
+	}
+	conn.logger.Info("note added to session")
+
+	if err := writeTerminalMessage(sshSess, "Note added to session "+sess.Id().String()+"."); err != nil {
+		return -1, errors.Network.Newf("cannot send message to terminal: %w", err)
+	}
+	return 0, nil
+}
@@ -3,17 +3,75 @@ package service
 import (
 	"context"
 	"io"
+	"time"
 
 	glssh "github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
 	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
 	"github.com/engity-com/bifroest/pkg/environment"
 	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/ssh"
+	"github.com/engity-com/bifroest/pkg/tracing"
 )
 
 func (this *service) handleNewSshSession(srv *glssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx glssh.Context) {
-	glssh.DefaultSessionHandler(srv, conn, newChan, ctx)
+	if v := this.connection(ctx); v != nil {
+		defer v.onChannel()()
+	}
+	sessCtx, sessChan := ssh.WithX11Forwarding(ctx, newChan, this.onX11Requested)
+	glssh.DefaultSessionHandler(srv, conn, sessChan, sessCtx)
+}
+
+// onX11Requested decides whether the x11-req request of a session is
+// accepted, which is the case if the environment of the connection allows it.
+func (this *service) onX11Requested(ctx glssh.Context, req ssh.X11Request) bool {
+	conn := this.connection(ctx)
+	l := conn.logger.
+		With("screen", req.ScreenNumber).
+		With("singleConnection", req.SingleConnection)
+
+	auth, _, _, err := this.resolveAuthorizationAndSession(ctx)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot resolve active authorization and its session; rejecting X11 forwarding...")
+		return false
+	}
+
+	x11Ctx, span := startSpan(conn.context, "ssh.x11Request")
+	defer span.End()
+
+	envReq := environmentRequest{
+		environmentContext{
+			service:       this,
+			connection:    conn,
+			authorization: auth,
+			context:       x11Ctx,
+		},
+		nil,
+	}
+
+	env, err := this.ensureEnvironment(&envReq)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot ensure environment; rejecting X11 forwarding...")
+		return false
+	}
+	defer common.IgnoreCloseError(env)
+
+	if ok, err := env.IsX11ForwardingAllowed(); err != nil {
+		l.WithError(err).
+			Error("cannot check if X11 forwarding is allowed; rejecting...")
+		return false
+	} else if !ok {
+		l.Info("X11 forwarding requested by client was rejected")
+		return false
+	}
+
+	l.Debug("X11 forwarding requested by client was accepted")
+	return true
 }
 
 func (this *service) handleSshShellSession(sess glssh.Session) {
@@ -28,6 +86,10 @@ func (this *service) uncheckedExecuteSshSession(sshSess glssh.Session, taskType
 	conn := this.connection(sshSess.Context())
 	l := conn.logger
 
+	if taskType == environment.TaskTypeShell {
+		defer conn.onTerminal(sshSess)()
+	}
+
 	handled := false
 	defer func() {
 		if !handled {
@@ -41,6 +103,13 @@ func (this *service) uncheckedExecuteSshSession(sshSess glssh.Session, taskType
 		Info("new remote session")
 
 	if exitCode, err := this.executeSession(sshSess, conn, taskType); err != nil {
+		if errors.Is(err, errSessionExpired) {
+			l.With("exitCode", exitCode).
+				Info("session ended because it expired")
+			_ = sshSess.Exit(exitCode)
+			handled = true
+			return
+		}
 		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 			l.Info("session ended unexpectedly; maybe timeout")
 			if exitCode < 0 {
@@ -80,6 +149,14 @@ func (this *service) executeSession(sshSess glssh.Session, conn *connection, tas
 		return fail(errors.Newf(t, msg, args...))
 	}
 
+	sessCtx, span := startSpan(conn.context, "ssh.session",
+		attribute.String("bifroest.task.type", taskType.String()),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("bifroest.task.exitCode", exitCode))
+		tracing.EndWith(span, &rErr)
+	}()
+
 	auth, sess, oldState, err := this.resolveAuthorizationAndSession(sshSess.Context())
 	if err != nil {
 		return fail(err)
@@ -94,11 +171,19 @@ func (this *service) executeSession(sshSess glssh.Session, conn *connection, tas
 			service:       this,
 			connection:    conn,
 			authorization: auth,
+			context:       sessCtx,
 		},
 		sshSess,
 	}
 
-	env, err := this.environments.Ensure(&req)
+	if code, ok := shareJoinRequestOf(sshSess); ok && taskType == environment.TaskTypeShell {
+		return this.joinShare(sshSess, conn, &req.environmentContext, code)
+	}
+	if isSessionNoteRequest(sshSess) && taskType == environment.TaskTypeShell {
+		return this.addSessionNote(sshSess, conn, sess)
+	}
+
+	env, err := this.ensureEnvironment(&req)
 	if err != nil {
 		return fail(err)
 	}
@@ -122,9 +207,80 @@ func (this *service) executeSession(sshSess glssh.Session, conn *connection, tas
 		sshSession:         sshSess,
 		taskType:           taskType,
 	}
-	if exitCode, err := env.Run(&t); err != nil {
+	if _, _, isPty := sshSess.Pty(); isPty && len(sshSess.RawCommand()) == 0 && taskType == environment.TaskTypeShell {
+		sharing, err := this.newSharingSshSession(sshSess, conn, &req.environmentContext)
+		if err != nil {
+			return fail(err)
+		}
+		if sharing != nil {
+			defer sharing.close()
+			t.sshSession = sharing
+		}
+	}
+	this.emit(this.newConnectionEvent(configuration.EventTypeCommandStarted, conn, auth).
+		With("taskType", taskType).
+		With("command", sshSess.RawCommand()))
+	started := time.Now()
+
+	runCtx, runSpan := startSpan(sessCtx, "environment.run")
+	runCtx, cancelRun := withCancel(runCtx)
+	defer cancelRun()
+	t.context = runCtx
+
+	var expiry *expiryWatcher
+	if _, _, isPty := sshSess.Pty(); isPty && taskType == environment.TaskTypeShell {
+		expiry = this.watchExpiry(sshSess, conn, auth, cancelRun)
+		defer expiry.stop()
+	}
+
+	exitCode, err = expiry.result(env.Run(&t))
+	tracing.End(runSpan, err)
+
+	ended := this.newConnectionEvent(configuration.EventTypeCommandEnded, conn, auth).
+		With("taskType", taskType).
+		With("command", sshSess.RawCommand()).
+		With("exitCode", exitCode).
+		With("duration", time.Since(started).Truncate(time.Millisecond).String())
+	if err != nil {
+		ended = ended.With("error", err.Error())
+	}
+	this.emit(ended)
+
+	if errors.Is(err, errSessionExpired) {
+		return exitCode, err
+	}
+	if err != nil {
 		return failf(errors.System, "run of environment failed: %w", err)
-	} else {
-		return exitCode, nil
 	}
+	return exitCode, nil
+}
+
+// ensureEnvironment calls environment.Repository.Ensure of this service
+// within its own span.
+func (this *service) ensureEnvironment(req *environmentRequest) (_ environment.Environment, rErr error) {
+	ctx, span := startSpan(req.Context(), "environment.ensure",
+		attribute.String("bifroest.flow", req.authorization.Flow().String()),
+	)
+	defer tracing.EndWith(span, &rErr)
+
+	sub := *req
+	sub.context = ctx
+
+	existed := true
+	if this.isInterestedIn(configuration.EventTypeEnvironmentCreated, req.authorization.Flow()) {
+		var err error
+		if existed, err = this.environmentExists(ctx, req.authorization); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := this.environments.Ensure(&sub)
+	if err != nil {
+		return nil, err
+	}
+
+	if !existed {
+		this.emit(this.newConnectionEvent(configuration.EventTypeEnvironmentCreated, req.connection, req.authorization))
+	}
+	return result, nil
 }
@@ -0,0 +1,777 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/ssh"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	// shareJoinUserSeparator separates the name of the user from the code of
+	// the shared shell to join, inside the requested user name. Example:
+	// foo+join-K7QX9M2P
+	shareJoinUserSeparator = "+join-"
+
+	// shareJoinCommand is the command which can be executed to join a shared
+	// shell. Example: bifroest-join K7QX9M2P
+	shareJoinCommand = "bifroest-join"
+
+	shareCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	shareCodeLength   = 8
+
+	// shareParticipantBacklog is the amount of output chunks which can be
+	// queued for a participant before it is considered too slow and removed.
+	shareParticipantBacklog = 1024
+)
+
+// cutShareJoinUser splits the given requested user name into the actual name
+// of the user and the code of the shared shell to join, if any.
+func cutShareJoinUser(user string) (name, code string, ok bool) {
+	i := strings.LastIndex(user, shareJoinUserSeparator)
+	if i <= 0 {
+		return user, "", false
+	}
+	return user[:i], user[i+len(shareJoinUserSeparator):], true
+}
+
+// shareJoinRequestOf returns the code of the shared shell the given
+// glssh.Session wants to join, either by the requested user name or by
+// executing shareJoinCommand.
+func shareJoinRequestOf(sshSess glssh.Session) (code string, ok bool) {
+	if _, code, ok := cutShareJoinUser(sshSess.User()); ok {
+		return normalizeShareCode(code), true
+	}
+	if args := sshSess.Command(); len(args) > 0 && args[0] == shareJoinCommand {
+		if len(args) != 2 {
+			return "", true
+		}
+		return normalizeShareCode(args[1]), true
+	}
+	return "", false
+}
+
+func normalizeShareCode(in string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(in), "-", ""))
+}
+
+func formatShareCode(in string) string {
+	return in[:shareCodeLength/2] + "-" + in[shareCodeLength/2:]
+}
+
+func newShareCode() (string, error) {
+	buf := make([]byte, shareCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = shareCodeAlphabet[int(b)%len(shareCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// shares holds all shared shells of this instance by their code.
+type shares struct {
+	mutex  sync.Mutex
+	byCode map[string]*share
+}
+
+func (this *shares) register(s *share) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.byCode == nil {
+		this.byCode = make(map[string]*share)
+	}
+	for {
+		code, err := newShareCode()
+		if err != nil {
+			return errors.System.Newf("cannot generate share code: %w", err)
+		}
+		if _, exists := this.byCode[code]; !exists {
+			s.code = code
+			this.byCode[code] = s
+			return nil
+		}
+	}
+}
+
+func (this *shares) get(code string) *share {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.byCode[code]
+}
+
+func (this *shares) remove(s *share) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.byCode[s.code] == s {
+		delete(this.byCode, s.code)
+	}
+}
+
+// share is an interactive shell which is shared by its owner with other
+// participants.
+type share struct {
+	owner *sharingSshSession
+	code  string
+
+	mutex             sync.Mutex
+	readWrite         bool
+	lastParticipantId uint32
+	participants      map[uint32]*shareParticipant
+	ended             bool
+}
+
+func (this *share) isReadWrite() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.readWrite
+}
+
+func (this *share) isEnded() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.ended
+}
+
+func (this *share) setReadWrite(v bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.readWrite = v
+}
+
+func (this *share) add(p *shareParticipant) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.ended {
+		return errNoSuchShare
+	}
+	if len(this.participants) >= int(this.owner.maxParticipants) {
+		return errShareFull
+	}
+	this.lastParticipantId++
+	p.id = this.lastParticipantId
+	if this.participants == nil {
+		this.participants = make(map[uint32]*shareParticipant)
+	}
+	this.participants[p.id] = p
+	return nil
+}
+
+func (this *share) get(id uint32) *shareParticipant {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.participants[id]
+}
+
+func (this *share) list() []*shareParticipant {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	result := slices.Collect(maps.Values(this.participants))
+	slices.SortFunc(result, func(a, b *shareParticipant) int {
+		return int(a.id) - int(b.id)
+	})
+	return result
+}
+
+// remove removes the given participant and reports if it was still part of
+// this share.
+func (this *share) remove(p *shareParticipant) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.participants[p.id] != p {
+		return false
+	}
+	delete(this.participants, p.id)
+	return true
+}
+
+// broadcast sends the given output of the shared shell to all participants.
+func (this *share) broadcast(b []byte) {
+	var tooSlow []*shareParticipant
+
+	this.mutex.Lock()
+	for _, p := range this.participants {
+		select {
+		case p.output <- bytes.Clone(b):
+		default:
+			tooSlow = append(tooSlow, p)
+		}
+	}
+	this.mutex.Unlock()
+
+	for _, p := range tooSlow {
+		p.end("You were removed from the shared shell, because your connection cannot keep up with its output.", 1)
+	}
+}
+
+// end ends this share and removes all its participants.
+func (this *share) end(msg string) {
+	this.mutex.Lock()
+	if this.ended {
+		this.mutex.Unlock()
+		return
+	}
+	this.ended = true
+	participants := slices.Collect(maps.Values(this.participants))
+	this.mutex.Unlock()
+
+	this.owner.service.shares.remove(this)
+	for _, p := range participants {
+		p.end(msg, 0)
+	}
+	this.owner.emit(configuration.EventTypeShareEnded, this)
+}
+
+func (this *share) mode() string {
+	return shareModeName(this.isReadWrite())
+}
+
+func shareModeName(readWrite bool) string {
+	if readWrite {
+		return "read-write"
+	}
+	return "read-only"
+}
+
+var (
+	errNoSuchShare = errors.User.Newf("no such shared shell")
+	errShareFull   = errors.User.Newf("maximum number of participants of shared shell reached")
+)
+
+// newSharingSshSession wraps the given glssh.Session of an interactive shell
+// which allows its owner to share it via the control prompt. It returns nil
+// if sharing is not allowed for the given authorization.
+func (this *service) newSharingSshSession(sshSess glssh.Session, conn *connection, ctx *environmentContext) (*sharingSshSession, error) {
+	fail := func(err error) (*sharingSshSession, error) {
+		return nil, errors.System.Newf("cannot prepare sharing of shell: %w", err)
+	}
+
+	conf := &this.Configuration.Ssh.Sharing
+	if allowed, err := conf.Allowed.Render(ctx); err != nil {
+		return fail(err)
+	} else if !allowed {
+		return nil, nil
+	}
+	readWriteAllowed, err := conf.ReadWriteAllowed.Render(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	controlKey, err := conf.ControlKeyByte()
+	if err != nil {
+		return fail(err)
+	}
+
+	result := &sharingSshSession{
+		Session:          sshSess,
+		service:          this,
+		connection:       conn,
+		context:          ctx,
+		controlKey:       controlKey,
+		readWriteAllowed: readWriteAllowed,
+		maxParticipants:  conf.MaxParticipants,
+		ownerInput:       make(chan []byte),
+		participantInput: make(chan []byte, 64),
+		done:             make(chan struct{}),
+	}
+	go result.pump()
+
+	return result, nil
+}
+
+// sharingSshSession is the glssh.Session of the owner of an interactive shell
+// which can be shared. All output of the shell is sent to the participants of
+// the current share, and the input of participants, which are allowed to
+// write, is sent to the shell.
+type sharingSshSession struct {
+	glssh.Session
+
+	service          *service
+	connection       *connection
+	context          *environmentContext
+	controlKey       byte
+	readWriteAllowed bool
+	maxParticipants  uint16
+
+	ownerInput       chan []byte
+	ownerInputErr    error
+	participantInput chan []byte
+	pending          []byte
+	done             chan struct{}
+	closeOnce        sync.Once
+
+	share atomic.Pointer[share]
+}
+
+func (this *sharingSshSession) Read(p []byte) (int, error) {
+	for len(this.pending) == 0 {
+		select {
+		case b, ok := <-this.ownerInput:
+			if !ok {
+				return 0, this.ownerInputErr
+			}
+			this.pending = b
+		case b := <-this.participantInput:
+			this.pending = b
+		case <-this.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, this.pending)
+	this.pending = this.pending[n:]
+	return n, nil
+}
+
+func (this *sharingSshSession) Write(p []byte) (int, error) {
+	n, err := this.Session.Write(p)
+	if n > 0 {
+		if s := this.share.Load(); s != nil {
+			s.broadcast(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (this *sharingSshSession) close() {
+	this.closeOnce.Do(func() {
+		close(this.done)
+		if s := this.share.Swap(nil); s != nil {
+			s.end("The shared shell has ended.")
+		}
+	})
+}
+
+// pump reads all input of the owner and handles the control key.
+func (this *sharingSshSession) pump() {
+	defer close(this.ownerInput)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := this.Session.Read(buf)
+		if n > 0 {
+			if !this.onOwnerInput(buf[:n]) {
+				this.ownerInputErr = io.EOF
+				return
+			}
+		}
+		if err != nil {
+			this.ownerInputErr = err
+			return
+		}
+	}
+}
+
+func (this *sharingSshSession) onOwnerInput(p []byte) bool {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, this.controlKey)
+		if i < 0 {
+			return this.deliver(p)
+		}
+		if i > 0 && !this.deliver(p[:i]) {
+			return false
+		}
+		var ok bool
+		if p, ok = this.control(p[i+1:]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver sends the given input of the owner to the shell.
+func (this *sharingSshSession) deliver(p []byte) bool {
+	select {
+	case this.ownerInput <- bytes.Clone(p):
+		return true
+	case <-this.done:
+		return false
+	}
+}
+
+// control handles the control prompt of the owner, after the control key was
+// pressed. It returns the input which was read but not used by the prompt.
+func (this *sharingSshSession) control(rest []byte) ([]byte, bool) {
+	in := &controlInput{pending: rest, Reader: this.Session}
+
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(in, first); err != nil {
+		return nil, false
+	}
+	if first[0] == this.controlKey {
+		return in.pending, this.deliver(first)
+	}
+
+	// The prompt is only written to the owner, not to the participants.
+	w := this.Session
+	if _, err := io.WriteString(w, "\r\n[bifroest] "); err != nil {
+		return nil, false
+	}
+	line, err := ssh.ReadTerminalLine(io.MultiReader(bytes.NewReader(first), in), w)
+	if errors.Is(err, ssh.ErrTerminalLineCanceled) {
+		return in.pending, true
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	if msg := this.execute(strings.Fields(line)); msg != "" {
+		if _, err := io.WriteString(w, strings.ReplaceAll(msg, "\n", "\r\n")+"\r\n"); err != nil {
+			return nil, false
+		}
+	}
+	return in.pending, true
+}
+
+func (this *sharingSshSession) execute(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	switch args[0] {
+	case "share":
+		return this.executeShare(args[1:])
+	case "unshare":
+		return this.executeUnshare()
+	case "participants", "who":
+		return this.executeParticipants()
+	case "kick":
+		return this.executeKick(args[1:])
+	case "help", "?":
+		return this.help()
+	default:
+		return fmt.Sprintf("Unknown command %q.\n%s", args[0], this.help())
+	}
+}
+
+func (this *sharingSshSession) help() string {
+	return "Available commands:\n" +
+		"  share [ro|rw]  Share this shell read-only (default) or read-write or change the mode.\n" +
+		"  unshare        Stop sharing this shell; all participants will be removed.\n" +
+		"  participants   List all participants.\n" +
+		"  kick <id>      Remove the participant with the given ID.\n" +
+		"Press " + this.service.Configuration.Ssh.Sharing.ControlKey + " twice to send it to the shell."
+}
+
+func (this *sharingSshSession) executeShare(args []string) string {
+	readWrite := false
+	if len(args) > 1 {
+		return "Usage: share [ro|rw]"
+	} else if len(args) == 1 {
+		switch args[0] {
+		case "ro", "read-only":
+		case "rw", "read-write":
+			readWrite = true
+		default:
+			return "Usage: share [ro|rw]"
+		}
+	}
+	if readWrite && !this.readWriteAllowed {
+		return "You are not allowed to share this shell read-write."
+	}
+
+	if s := this.share.Load(); s != nil {
+		s.setReadWrite(readWrite)
+		return fmt.Sprintf("This shell is now shared %s.", shareModeName(readWrite))
+	}
+
+	s := &share{
+		owner:     this,
+		readWrite: readWrite,
+	}
+	if err := this.service.shares.register(s); err != nil {
+		this.connection.logger.WithError(err).Error("cannot share shell")
+		return "Cannot share this shell."
+	}
+	this.share.Store(s)
+	this.emit(configuration.EventTypeShareStarted, s)
+	this.connection.logger.With("mode", s.mode()).Info("shell shared")
+
+	code := formatShareCode(s.code)
+	return fmt.Sprintf("This shell is now shared %s using code: %s\n"+
+		"Others can join it with: ssh -t <host> %s %s\n"+
+		"                     or: ssh <user>%s%s@<host>", s.mode(), code, shareJoinCommand, code, shareJoinUserSeparator, code)
+}
+
+func (this *sharingSshSession) executeUnshare() string {
+	s := this.share.Swap(nil)
+	if s == nil {
+		return "This shell is not shared."
+	}
+	s.end("The owner has stopped sharing the shell.")
+	this.connection.logger.Info("shell unshared")
+	return "This shell is no longer shared."
+}
+
+func (this *sharingSshSession) executeParticipants() string {
+	s := this.share.Load()
+	if s == nil {
+		return "This shell is not shared."
+	}
+	participants := s.list()
+	if len(participants) == 0 {
+		return fmt.Sprintf("This shell is shared %s using code %s, but nobody has joined yet.", s.mode(), formatShareCode(s.code))
+	}
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "This shell is shared %s using code %s with:", s.mode(), formatShareCode(s.code))
+	for _, p := range participants {
+		_, _ = fmt.Fprintf(&buf, "\n  %3d) %s (since %v)", p.id, p.name(), time.Since(p.joined).Truncate(time.Second))
+	}
+	return buf.String()
+}
+
+func (this *sharingSshSession) executeKick(args []string) string {
+	s := this.share.Load()
+	if s == nil {
+		return "This shell is not shared."
+	}
+	if len(args) != 1 {
+		return "Usage: kick <id>"
+	}
+	id, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return "Usage: kick <id>"
+	}
+	p := s.get(uint32(id))
+	if p == nil {
+		return fmt.Sprintf("There is no participant with ID %d.", id)
+	}
+	p.end("You were removed from the shared shell by its owner.", 1)
+	return fmt.Sprintf("%s was removed.", p.name())
+}
+
+// notify writes the given message to the terminal of the owner.
+func (this *sharingSshSession) notify(msg string) {
+	if err := writeTerminalMessage(this.Session, "[bifroest] "+msg); err != nil {
+		this.connection.logger.WithError(err).Debug("cannot notify owner of shared shell")
+	}
+}
+
+func (this *sharingSshSession) emit(t configuration.EventType, s *share) {
+	this.service.emit(this.service.newConnectionEvent(t, this.connection, this.context.authorization).
+		With("mode", s.mode()))
+}
+
+// controlInput provides input which was already read, before it continues to
+// read from its Reader.
+type controlInput struct {
+	io.Reader
+	pending []byte
+}
+
+func (this *controlInput) Read(p []byte) (int, error) {
+	if len(this.pending) > 0 {
+		n := copy(p, this.pending)
+		this.pending = this.pending[n:]
+		return n, nil
+	}
+	return this.Reader.Read(p)
+}
+
+// joinShare lets the given glssh.Session join the shared shell with the given
+// code.
+func (this *service) joinShare(sshSess glssh.Session, conn *connection, ctx *environmentContext, code string) (int, error) {
+	fail := func(err error) (int, error) {
+		return -1, err
+	}
+	failUser := func(msg string, args ...any) (int, error) {
+		if err := writeTerminalMessage(sshSess, fmt.Sprintf(msg, args...)); err != nil {
+			return fail(errors.Network.Newf("cannot send message to terminal: %w", err))
+		}
+		return 1, nil
+	}
+
+	conf := &this.Configuration.Ssh.Sharing
+	if allowed, err := conf.JoinAllowed.Render(ctx); err != nil {
+		return fail(errors.System.Newf("cannot evaluate if joining shared shells is allowed: %w", err))
+	} else if !allowed {
+		return failUser("You are not allowed to join shared shells.")
+	}
+	if code == "" {
+		return failUser("Usage: %s <code>", shareJoinCommand)
+	}
+
+	s := this.shares.get(code)
+	if s == nil {
+		return failUser("There is no shared shell with code %s.", code)
+	}
+	if allowed, err := this.isJoinOfShareAllowed(s, ctx); err != nil {
+		return fail(err)
+	} else if !allowed {
+		// Do not reveal that a shared shell with this code exists.
+		return failUser("There is no shared shell with code %s.", code)
+	}
+
+	p := &shareParticipant{
+		share:      s,
+		sshSession: sshSess,
+		connection: conn,
+		context:    ctx,
+		joined:     time.Now(),
+		output:     make(chan []byte, shareParticipantBacklog),
+		ended:      make(chan struct{}),
+	}
+	if err := s.add(p); errors.Is(err, errShareFull) {
+		return failUser("The maximum number of participants of this shared shell is reached.")
+	} else if err != nil {
+		return failUser("There is no shared shell with code %s.", code)
+	}
+
+	return p.run()
+}
+
+// isJoinOfShareAllowed reports if the user of the given environmentContext
+// is allowed to join the given share. Shares can only be joined by users of
+// the same flow as the owner, unless SshSharing.JoinOtherFlowsAllowed says
+// otherwise.
+func (this *service) isJoinOfShareAllowed(s *share, ctx *environmentContext) (bool, error) {
+	if ctx.authorization.Flow() == s.owner.context.authorization.Flow() {
+		return true, nil
+	}
+	allowed, err := this.Configuration.Ssh.Sharing.JoinOtherFlowsAllowed.Render(ctx)
+	if err != nil {
+		return false, errors.System.Newf("cannot evaluate if joining shared shells of other flows is allowed: %w", err)
+	}
+	return allowed, nil
+}
+
+// shareParticipant is a connection which has joined a share.
+type shareParticipant struct {
+	id         uint32
+	share      *share
+	sshSession glssh.Session
+	connection *connection
+	context    *environmentContext
+	joined     time.Time
+
+	output    chan []byte
+	ended     chan struct{}
+	endOnce   sync.Once
+	endMsg    string
+	endResult int
+}
+
+func (this *shareParticipant) name() string {
+	return this.connection.Remote().String()
+}
+
+func (this *shareParticipant) run() (int, error) {
+	l := this.connection.logger.
+		With("owner", this.share.owner.connection.id).
+		With("participant", this.id)
+	owner := this.share.owner
+
+	controlKey := this.share.owner.service.Configuration.Ssh.Sharing.ControlKey
+	if err := writeTerminalMessage(this.sshSession, fmt.Sprintf("[bifroest] Joined the shell of %s (%s) as #%d. Press %s to leave.",
+		owner.connection.Remote(), this.share.mode(), this.id, controlKey)); err != nil {
+		this.end("", 0)
+		return -1, errors.Network.Newf("cannot send message to terminal: %w", err)
+	}
+
+	owner.notify(fmt.Sprintf("%s joined as #%d.", this.name(), this.id))
+	this.emit(configuration.EventTypeShareJoined)
+	l.Info("joined shared shell")
+
+	go this.forwardOutput()
+	go this.forwardInput()
+
+	select {
+	case <-this.ended:
+	case <-this.sshSession.Context().Done():
+		this.end("", 0)
+	}
+
+	this.share.remove(this)
+	if !this.share.isEnded() {
+		owner.notify(fmt.Sprintf("%s (#%d) left.", this.name(), this.id))
+	}
+	this.emit(configuration.EventTypeShareLeft)
+	l.Info("left shared shell")
+
+	if msg := this.endMsg; msg != "" {
+		_ = writeTerminalMessage(this.sshSession, "[bifroest] "+msg)
+	}
+	return this.endResult, nil
+}
+
+// end removes this participant from its share. The given msg is written to
+// the participant's terminal and exitCode is reported to its client.
+func (this *shareParticipant) end(msg string, exitCode int) {
+	this.endOnce.Do(func() {
+		this.endMsg = msg
+		this.endResult = exitCode
+		this.share.remove(this)
+		close(this.ended)
+	})
+}
+
+func (this *shareParticipant) forwardOutput() {
+	for {
+		select {
+		case b := <-this.output:
+			if _, err := this.sshSession.Write(b); err != nil {
+				this.end("", 0)
+				return
+			}
+		case <-this.ended:
+			return
+		}
+	}
+}
+
+func (this *shareParticipant) forwardInput() {
+	controlKey := this.share.owner.controlKey
+	buf := make([]byte, 4096)
+	for {
+		n, err := this.sshSession.Read(buf)
+		if n > 0 {
+			b := buf[:n]
+			if i := bytes.IndexByte(b, controlKey); i >= 0 {
+				b = b[:i]
+				this.forwardInputToOwner(b)
+				this.end("You have left the shared shell.", 0)
+				return
+			}
+			this.forwardInputToOwner(b)
+		}
+		if err != nil {
+			if !sys.IsClosedError(err) && !errors.Is(err, io.EOF) {
+				this.connection.logger.WithError(err).Debug("cannot read input of participant")
+			}
+			this.end("", 0)
+			return
+		}
+	}
+}
+
+func (this *shareParticipant) forwardInputToOwner(b []byte) {
+	if len(b) == 0 || !this.share.isReadWrite() {
+		return
+	}
+	select {
+	case this.share.owner.participantInput <- bytes.Clone(b):
+	case <-this.ended:
+	case <-this.share.owner.done:
+	}
+}
+
+func (this *shareParticipant) emit(t configuration.EventType) {
+	owner := this.share.owner
+	owner.service.emit(owner.service.newConnectionEvent(t, this.connection, this.context.authorization).
+		With("owner", owner.connection.id.String()).
+		With("mode", this.share.mode()))
+}
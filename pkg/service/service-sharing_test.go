@@ -0,0 +1,472 @@
+package service
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/authorization"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/template"
+)
+
+func TestSharingSshSession_execute(t *testing.T) {
+	cases := []struct {
+		name             string
+		readWriteAllowed bool
+		shared           bool
+		args             []string
+		expected         string
+	}{{
+		name:     "empty",
+		expected: "",
+	}, {
+		name:     "share-read-only",
+		args:     []string{"share"},
+		expected: "This shell is now shared read-only using code: ",
+	}, {
+		name:     "share-read-write-not-allowed",
+		args:     []string{"share", "rw"},
+		expected: "You are not allowed to share this shell read-write.",
+	}, {
+		name:             "share-read-write-allowed",
+		readWriteAllowed: true,
+		args:             []string{"share", "rw"},
+		expected:         "This shell is now shared read-write using code: ",
+	}, {
+		name:             "share-change-mode",
+		readWriteAllowed: true,
+		shared:           true,
+		args:             []string{"share", "read-write"},
+		expected:         "This shell is now shared read-write.",
+	}, {
+		name:     "share-illegal-mode",
+		args:     []string{"share", "foo"},
+		expected: "Usage: share [ro|rw]",
+	}, {
+		name:     "unshare-not-shared",
+		args:     []string{"unshare"},
+		expected: "This shell is not shared.",
+	}, {
+		name:     "unshare",
+		shared:   true,
+		args:     []string{"unshare"},
+		expected: "This shell is no longer shared.",
+	}, {
+		name:     "participants-not-shared",
+		args:     []string{"participants"},
+		expected: "This shell is not shared.",
+	}, {
+		name:     "participants-nobody",
+		shared:   true,
+		args:     []string{"who"},
+		expected: "This shell is shared read-only using code ",
+	}, {
+		name:     "kick-illegal-id",
+		shared:   true,
+		args:     []string{"kick", "foo"},
+		expected: "Usage: kick <id>",
+	}, {
+		name:     "kick-unknown",
+		shared:   true,
+		args:     []string{"kick", "66"},
+		expected: "There is no participant with ID 66.",
+	}, {
+		name:     "help",
+		args:     []string{"help"},
+		expected: "Available commands:\n",
+	}, {
+		name:     "unknown",
+		args:     []string{"foo"},
+		expected: "Unknown command \"foo\".\nAvailable commands:\n",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc := newTestSharingService(t)
+			svc.Configuration.Ssh.Sharing.ReadWriteAllowed = template.BoolOf(c.readWriteAllowed)
+			owner, _ := newTestSharingOwner(t, svc)
+			if c.shared {
+				require.Contains(t, owner.execute([]string{"share"}), "now shared")
+			}
+
+			actual := owner.execute(c.args)
+			if c.expected == "" {
+				require.Empty(t, actual)
+			} else {
+				require.True(t, strings.HasPrefix(actual, c.expected), "expected prefix %q; but got: %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSharingSshSession_Read(t *testing.T) {
+	svc := newTestSharingService(t)
+	owner, sess := newTestSharingOwner(t, svc)
+
+	sess.input(t, "ls\r")
+	require.Equal(t, "ls\r", testReadSome(t, owner))
+
+	// Pressing the control key twice sends it to the shell.
+	sess.input(t, "\x1d\x1d")
+	require.Equal(t, "\x1d", testReadSome(t, owner))
+
+	// The prompt is only visible to the owner and not sent to the shell.
+	sess.input(t, "\x1dshare\rpwd\r")
+	require.Equal(t, "pwd\r", testReadSome(t, owner))
+	require.Contains(t, sess.stdout.String(), "This shell is now shared read-only using code: ")
+	require.NotNil(t, owner.share.Load())
+
+	// Canceled prompts are not executed.
+	sess.input(t, "\x1dunshare\x03")
+	sess.input(t, "id\r")
+	require.Equal(t, "id\r", testReadSome(t, owner))
+	require.NotNil(t, owner.share.Load())
+
+	owner.close()
+	_, err := owner.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestShare_add(t *testing.T) {
+	svc := newTestSharingService(t)
+	svc.Configuration.Ssh.Sharing.MaxParticipants = 1
+	owner, _ := newTestSharingOwner(t, svc)
+	s := testShare(t, owner, "share")
+
+	p1 := &shareParticipant{share: s, ended: make(chan struct{})}
+	require.NoError(t, s.add(p1))
+	require.Equal(t, uint32(1), p1.id)
+	require.ErrorIs(t, s.add(&shareParticipant{share: s, ended: make(chan struct{})}), errShareFull)
+
+	require.True(t, s.remove(p1))
+	require.False(t, s.remove(p1))
+	p2 := &shareParticipant{share: s, ended: make(chan struct{})}
+	require.NoError(t, s.add(p2))
+	require.Equal(t, uint32(2), p2.id)
+
+	owner.close()
+	require.True(t, s.isEnded())
+	require.Nil(t, svc.shares.get(s.code))
+	require.ErrorIs(t, s.add(&shareParticipant{share: s, ended: make(chan struct{})}), errNoSuchShare)
+}
+
+func TestService_joinShare(t *testing.T) {
+	cases := []struct {
+		name                  string
+		joinAllowed           bool
+		joinOtherFlowsAllowed bool
+		flow                  configuration.FlowName
+		code                  string
+		expectedResult        int
+		expected              string
+	}{{
+		name:           "not-allowed",
+		flow:           "foo",
+		expectedResult: 1,
+		expected:       "You are not allowed to join shared shells.",
+	}, {
+		name:           "without-code",
+		joinAllowed:    true,
+		flow:           "foo",
+		code:           "-",
+		expectedResult: 1,
+		expected:       "Usage: bifroest-join <code>",
+	}, {
+		name:           "unknown-code",
+		joinAllowed:    true,
+		flow:           "foo",
+		code:           "AAAAAAAA",
+		expectedResult: 1,
+		expected:       "There is no shared shell with code AAAAAAAA.",
+	}, {
+		name:           "same-flow",
+		joinAllowed:    true,
+		flow:           "foo",
+		expectedResult: 0,
+		expected:       "Joined the shell of ",
+	}, {
+		name:           "other-flow",
+		joinAllowed:    true,
+		flow:           "bar",
+		expectedResult: 1,
+		expected:       "There is no shared shell with code ",
+	}, {
+		name:                  "other-flow-allowed",
+		joinAllowed:           true,
+		joinOtherFlowsAllowed: true,
+		flow:                  "bar",
+		expectedResult:        0,
+		expected:              "Joined the shell of ",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc := newTestSharingService(t)
+			svc.Configuration.Ssh.Sharing.JoinAllowed = template.BoolOf(c.joinAllowed)
+			svc.Configuration.Ssh.Sharing.JoinOtherFlowsAllowed = template.BoolOf(c.joinOtherFlowsAllowed)
+			owner, _ := newTestSharingOwner(t, svc)
+			s := testShare(t, owner, "share")
+
+			code := s.code
+			if c.code == "-" {
+				code = ""
+			} else if c.code != "" {
+				code = c.code
+			}
+
+			sess, conn, ctx := newTestSharingParticipant(t, svc, c.flow)
+			// Without any input, the participant leaves immediately after joining.
+			require.NoError(t, sess.inputWriter.Close())
+
+			actual, err := svc.joinShare(sess, conn, ctx, code)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedResult, actual)
+			require.Contains(t, sess.stderr.String(), c.expected)
+			require.Empty(t, s.list())
+		})
+	}
+}
+
+func TestShareParticipant_run(t *testing.T) {
+	t.Run("read-write", func(t *testing.T) {
+		svc := newTestSharingService(t)
+		events := &testEventDispatcher{}
+		svc.events = events
+		svc.Configuration.Ssh.Sharing.ReadWriteAllowed = template.BoolOf(true)
+		owner, ownerSess := newTestSharingOwner(t, svc)
+		s := testShare(t, owner, "share", "rw")
+
+		sess, result := testJoinShare(t, svc, s)
+		require.Eventually(t, func() bool {
+			return strings.Contains(ownerSess.stderr.String(), "joined as #1.")
+		}, time.Second, time.Millisecond)
+		require.Contains(t, sess.stderr.String(), "Joined the shell of ")
+
+		// Output of the shell is sent to the participant...
+		_, err := owner.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return sess.stdout.String() == "hello"
+		}, time.Second, time.Millisecond)
+
+		// ... and the input of the participant to the shell.
+		sess.input(t, "ls\r")
+		require.Equal(t, "ls\r", testReadSome(t, owner))
+
+		// The control key leaves the shared shell.
+		sess.input(t, "\x1d")
+		require.Equal(t, 0, <-result)
+		require.Contains(t, sess.stderr.String(), "You have left the shared shell.")
+		require.Contains(t, ownerSess.stderr.String(), "(#1) left.")
+		require.Empty(t, s.list())
+
+		require.Equal(t, []configuration.EventType{
+			configuration.EventTypeShareStarted,
+			configuration.EventTypeShareJoined,
+			configuration.EventTypeShareLeft,
+		}, testEventTypes(events))
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		svc := newTestSharingService(t)
+		owner, _ := newTestSharingOwner(t, svc)
+		s := testShare(t, owner, "share")
+
+		sess, result := testJoinShare(t, svc, s)
+		require.Eventually(t, func() bool { return len(s.list()) == 1 }, time.Second, time.Millisecond)
+
+		sess.input(t, "rm -rf /\r")
+		sess.input(t, "\x1d")
+		require.Equal(t, 0, <-result)
+		require.Empty(t, owner.participantInput)
+	})
+
+	t.Run("kicked", func(t *testing.T) {
+		svc := newTestSharingService(t)
+		owner, _ := newTestSharingOwner(t, svc)
+		s := testShare(t, owner, "share")
+
+		sess, result := testJoinShare(t, svc, s)
+		require.Eventually(t, func() bool { return len(s.list()) == 1 }, time.Second, time.Millisecond)
+
+		require.Contains(t, owner.execute([]string{"participants"}), "\n    1) ")
+		require.Contains(t, owner.execute([]string{"kick", "1"}), "was removed.")
+		require.Equal(t, 1, <-result)
+		require.Contains(t, sess.stderr.String(), "You were removed from the shared shell by its owner.")
+	})
+
+	t.Run("unshared", func(t *testing.T) {
+		svc := newTestSharingService(t)
+		owner, _ := newTestSharingOwner(t, svc)
+		s := testShare(t, owner, "share")
+
+		sess, result := testJoinShare(t, svc, s)
+		require.Eventually(t, func() bool { return len(s.list()) == 1 }, time.Second, time.Millisecond)
+
+		owner.close()
+		require.Equal(t, 0, <-result)
+		require.Contains(t, sess.stderr.String(), "The shared shell has ended.")
+	})
+}
+
+func newTestSharingService(t *testing.T) *service {
+	t.Helper()
+	result := newTestAdministration(t).svc
+	result.Configuration.Ssh.Sharing = configuration.SshSharing{
+		Allowed:               template.BoolOf(true),
+		ReadWriteAllowed:      template.BoolOf(false),
+		JoinAllowed:           template.BoolOf(true),
+		JoinOtherFlowsAllowed: template.BoolOf(false),
+		ControlKey:            "^]",
+		MaxParticipants:       10,
+	}
+	return result
+}
+
+func newTestSharingOwner(t *testing.T, svc *service) (*sharingSshSession, *testSharingSshSession) {
+	t.Helper()
+	conn := newTestConnection(t, svc)
+	sess := newTestSharingSshSession(t, nil)
+	ctx := &environmentContext{
+		service:       svc,
+		connection:    conn,
+		authorization: &testFlowAuthorization{authorization.Forbidden(conn.Remote()), "foo"},
+	}
+
+	result, err := svc.newSharingSshSession(sess, conn, ctx)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	t.Cleanup(result.close)
+	return result, sess
+}
+
+func newTestSharingParticipant(t *testing.T, svc *service, flow configuration.FlowName) (*testSharingSshSession, *connection, *environmentContext) {
+	t.Helper()
+	conn := newTestConnection(t, svc)
+	sess := newTestSharingSshSession(t, []string{shareJoinCommand})
+	ctx := &environmentContext{
+		service:       svc,
+		connection:    conn,
+		authorization: &testFlowAuthorization{authorization.Forbidden(conn.Remote()), flow},
+	}
+	return sess, conn, ctx
+}
+
+func testShare(t *testing.T, owner *sharingSshSession, args ...string) *share {
+	t.Helper()
+	require.Contains(t, owner.execute(args), "now shared")
+	result := owner.share.Load()
+	require.NotNil(t, result)
+	return result
+}
+
+func testJoinShare(t *testing.T, svc *service, s *share) (*testSharingSshSession, <-chan int) {
+	t.Helper()
+	sess, conn, ctx := newTestSharingParticipant(t, svc, "foo")
+	result := make(chan int, 1)
+	go func() {
+		actual, err := svc.joinShare(sess, conn, ctx, s.code)
+		if err != nil {
+			actual = -1
+		}
+		result <- actual
+	}()
+	return sess, result
+}
+
+func testReadSome(t *testing.T, r io.Reader) string {
+	t.Helper()
+	buf := make([]byte, 1024)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func testEventTypes(events *testEventDispatcher) (result []configuration.EventType) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	for _, e := range events.events {
+		result = append(result, e.Type)
+	}
+	return result
+}
+
+type testFlowAuthorization struct {
+	authorization.Authorization
+	flow configuration.FlowName
+}
+
+func (this *testFlowAuthorization) IsAuthorized() bool {
+	return true
+}
+
+func (this *testFlowAuthorization) Flow() configuration.FlowName {
+	return this.flow
+}
+
+func newTestSharingSshSession(t *testing.T, command []string) *testSharingSshSession {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	r, w := io.Pipe()
+	t.Cleanup(func() { _ = w.Close() })
+
+	return &testSharingSshSession{
+		context:     &testSshContext{Context: ctx},
+		command:     command,
+		inputReader: r,
+		inputWriter: w,
+	}
+}
+
+type testSharingSshSession struct {
+	glssh.Session
+	context     glssh.Context
+	command     []string
+	inputReader *io.PipeReader
+	inputWriter *io.PipeWriter
+	stdout      testSyncBuffer
+	stderr      testSyncBuffer
+}
+
+// input sends the given input to the session, as if it was typed by the user.
+func (this *testSharingSshSession) input(t *testing.T, in string) {
+	t.Helper()
+	_, err := io.WriteString(this.inputWriter, in)
+	require.NoError(t, err)
+}
+
+func (this *testSharingSshSession) Context() glssh.Context {
+	return this.context
+}
+
+func (this *testSharingSshSession) Command() []string {
+	return this.command
+}
+
+func (this *testSharingSshSession) User() string {
+	return "user"
+}
+
+func (this *testSharingSshSession) Pty() (glssh.Pty, <-chan glssh.Window, bool) {
+	return glssh.Pty{Term: "xterm"}, nil, true
+}
+
+func (this *testSharingSshSession) Read(p []byte) (int, error) {
+	return this.inputReader.Read(p)
+}
+
+func (this *testSharingSshSession) Write(p []byte) (int, error) {
+	return this.stdout.Write(p)
+}
+
+func (this *testSharingSshSession) Stderr() io.ReadWriter {
+	return &this.stderr
+}
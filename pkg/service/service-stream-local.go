@@ -0,0 +1,248 @@
+package service
+
+import (
+	"fmt"
+	gonet "net"
+
+	log "github.com/echocat/slf4g"
+	glssh "github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+// The OpenSSH extensions for unix socket forwarding, see
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL (2.4).
+const (
+	directStreamLocalChannelType    = "direct-streamlocal@openssh.com"
+	forwardedStreamLocalChannelType = "forwarded-streamlocal@openssh.com"
+	streamLocalForwardRequestType   = "streamlocal-forward@openssh.com"
+	cancelStreamLocalForwardType    = "cancel-streamlocal-forward@openssh.com"
+)
+
+type directStreamLocalChannelData struct {
+	SocketPath string
+
+	Reserved0 string
+	Reserved1 uint32
+}
+
+type streamLocalForwardRequest struct {
+	SocketPath string
+}
+
+type forwardedStreamLocalChannelData struct {
+	SocketPath string
+
+	Reserved string
+}
+
+func (this *service) handleNewDirectStreamLocal(_ *glssh.Server, _ *gossh.ServerConn, newChan gossh.NewChannel, ctx glssh.Context) {
+	conn := this.connection(ctx)
+	l := conn.logger
+	defer conn.onChannel()()
+
+	auth, _, _, err := this.resolveAuthorizationAndSession(ctx)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot resolve active authorization and its session; rejecting...")
+		_ = newChan.Reject(gossh.ConnectionFailed, "cannot resolve authorization and its session")
+		return
+	}
+
+	d := directStreamLocalChannelData{}
+	if err := gossh.Unmarshal(newChan.ExtraData(), &d); err != nil {
+		l.WithError(err).
+			Info("cannot parse client's socket forward data; rejecting...")
+		_ = newChan.Reject(gossh.ConnectionFailed, "error parsing socket forward data: "+err.Error())
+		return
+	}
+
+	l = l.With("path", d.SocketPath)
+
+	fwdCtx, span := startSpan(conn.context, "ssh.directStreamLocal",
+		attribute.String("bifroest.forward.path", d.SocketPath),
+	)
+	defer span.End()
+
+	req := environmentRequest{
+		environmentContext{
+			service:       this,
+			connection:    conn,
+			authorization: auth,
+			context:       fwdCtx,
+		},
+		nil,
+	}
+
+	env, err := this.ensureEnvironment(&req)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot ensure environment; rejecting...")
+		_ = newChan.Reject(gossh.Prohibited, "cannot ensure environment")
+		return
+	}
+	defer common.IgnoreCloseError(env)
+
+	if ok, err := env.IsSocketForwardingAllowed(d.SocketPath); err != nil {
+		l.WithError(err).
+			Error("cannot check if socket forwarding is allowed; rejecting...")
+		_ = newChan.Reject(gossh.ConnectionFailed, "socket forwarding is disabled")
+		return
+	} else if !ok {
+		l.Info("socket forwarding requested by client was rejected")
+		_ = newChan.Reject(gossh.Prohibited, fmt.Sprintf("socket forwarding to %s is not allowed", d.SocketPath))
+		return
+	}
+
+	dConn, err := env.NewDestinationSocketConnection(fwdCtx, d.SocketPath)
+	if err != nil {
+		var re errors.RemoteError
+		if errors.As(err, &re) {
+			l.WithError(err).
+				Info("cannot connect to socket forwarding destination; rejecting...")
+			_ = newChan.Reject(gossh.ConnectionFailed, fmt.Sprintf("cannot connect to %s: %v", d.SocketPath, re))
+		} else if ufe := this.reWrapUserFacingErrors(err); ufe != nil {
+			l.WithError(ufe).
+				Info("cannot connect to socket forwarding destination; rejecting...")
+			_ = newChan.Reject(gossh.ConnectionFailed, fmt.Sprintf("cannot connect to %s: %v", d.SocketPath, ufe))
+		} else {
+			l.WithError(err).
+				Warn("cannot connect to socket forwarding destination; rejecting...")
+			_ = newChan.Reject(gossh.ConnectionFailed, fmt.Sprintf("cannot connect to %s: internal error", d.SocketPath))
+		}
+		return
+	}
+	if dConn == nil {
+		l.Info("connection rejected")
+		_ = newChan.Reject(gossh.ConnectionFailed, "rejected")
+		return
+	}
+	defer common.IgnoreCloseError(dConn)
+
+	this.forwardFromClient(ctx, l, newChan, dConn)
+}
+
+// handleStreamLocalForward handles the streamlocal-forward@openssh.com and
+// cancel-streamlocal-forward@openssh.com requests of a client. The socket is
+// listened on inside the environment of the connection (see
+// environment.Environment#NewRemoteSocketListener).
+func (this *service) handleStreamLocalForward(ctx glssh.Context, _ *glssh.Server, req *gossh.Request) (bool, []byte) {
+	conn := this.connection(ctx)
+	l := conn.logger
+
+	var payload streamLocalForwardRequest
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		l.WithError(err).
+			Info("cannot parse client's remote socket forward request; rejecting...")
+		return false, nil
+	}
+
+	switch req.Type {
+	case streamLocalForwardRequestType:
+		return this.handleStreamLocalForwardStart(ctx, conn, payload.SocketPath), nil
+	case cancelStreamLocalForwardType:
+		if v, ok := conn.remoteSocketListeners.LoadAndDelete(payload.SocketPath); ok {
+			common.IgnoreCloseError(v.(gonet.Listener))
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (this *service) handleStreamLocalForwardStart(ctx glssh.Context, conn *connection, path string) bool {
+	l := conn.logger.
+		With("path", path)
+
+	auth, _, _, err := this.resolveAuthorizationAndSession(ctx)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot resolve active authorization and its session; rejecting...")
+		return false
+	}
+
+	fwdCtx, span := startSpan(ctx, "ssh.streamLocalForward",
+		attribute.String("bifroest.forward.path", path),
+	)
+	defer span.End()
+
+	req := environmentRequest{
+		environmentContext{
+			service:       this,
+			connection:    conn,
+			authorization: auth,
+			context:       fwdCtx,
+		},
+		nil,
+	}
+
+	env, err := this.ensureEnvironment(&req)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot ensure environment; rejecting...")
+		return false
+	}
+	success := false
+	defer common.IgnoreCloseErrorIfFalse(&success, env)
+
+	if ok, err := env.IsSocketForwardingAllowed(path); err != nil {
+		l.WithError(err).
+			Error("cannot check if socket forwarding is allowed; rejecting...")
+		return false
+	} else if !ok {
+		l.Info("remote socket forwarding requested by client was rejected")
+		return false
+	}
+
+	ln, err := env.NewRemoteSocketListener(ctx, path)
+	if err != nil {
+		l.WithError(err).
+			Info("cannot listen for remote socket forwarding; rejecting...")
+		return false
+	}
+	if ln == nil {
+		l.Info("remote socket forwarding rejected")
+		return false
+	}
+
+	if existing, loaded := conn.remoteSocketListeners.Swap(path, ln); loaded {
+		common.IgnoreCloseError(existing.(gonet.Listener))
+	}
+
+	success = true
+	go func() {
+		defer common.IgnoreCloseError(env)
+		this.serveRemoteSocketListener(ctx, conn, l, path, ln)
+	}()
+
+	l.Info("remote socket forwarding started")
+	return true
+}
+
+func (this *service) serveRemoteSocketListener(ctx glssh.Context, conn *connection, l log.Logger, path string, ln gonet.Listener) {
+	defer conn.remoteSocketListeners.CompareAndDelete(path, ln)
+	defer common.IgnoreCloseError(ln)
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	payload := gossh.Marshal(&forwardedStreamLocalChannelData{SocketPath: path})
+	for {
+		dConn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil && this.isRelevantError(err) {
+				l.WithError(err).
+					Warn("cannot accept connection of remote socket forwarding; stopping...")
+			} else {
+				l.Info("remote socket forwarding stopped")
+			}
+			return
+		}
+		go this.forwardToClient(ctx, conn, l, forwardedStreamLocalChannelType, payload, dConn)
+	}
+}
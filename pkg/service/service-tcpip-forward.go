@@ -0,0 +1,257 @@
+package service
+
+import (
+	gonet "net"
+	"strconv"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	glssh "github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel/attribute"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/environment"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	forwardedTcpIpChannelType = "forwarded-tcpip"
+)
+
+type remoteForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+func (this remoteForwardRequest) bind() (net.HostPort, error) {
+	var buf net.HostPort
+	host := this.BindAddr
+	if host == "" {
+		// An empty bind address means all interfaces.
+		host = "0.0.0.0"
+	}
+	if err := buf.Host.Set(host); err != nil {
+		return net.HostPort{}, err
+	}
+	if this.BindPort > 65535 {
+		return net.HostPort{}, errors.Network.Newf("illegal port: %d", this.BindPort)
+	}
+	buf.Port = uint16(this.BindPort)
+	return buf, nil
+}
+
+func (this remoteForwardRequest) key(port uint16) string {
+	return this.BindAddr + ":" + strconv.FormatUint(uint64(port), 10)
+}
+
+type remoteForwardSuccess struct {
+	BindPort uint32
+}
+
+// handleTcpIpForward handles the tcpip-forward and cancel-tcpip-forward
+// requests of a client. Other than the glssh.ForwardedTCPHandler it does not
+// listen on the host of Bifröst, but inside the environment of the
+// connection (see environment.Environment#NewRemoteListener).
+func (this *service) handleTcpIpForward(ctx glssh.Context, _ *glssh.Server, req *gossh.Request) (bool, []byte) {
+	conn := this.connection(ctx)
+	l := conn.logger
+
+	var payload remoteForwardRequest
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		l.WithError(err).
+			Info("cannot parse client's remote forward request; rejecting...")
+		return false, nil
+	}
+
+	switch req.Type {
+	case "tcpip-forward":
+		return this.handleTcpIpForwardStart(ctx, conn, payload)
+	case "cancel-tcpip-forward":
+		if v, ok := conn.remoteListeners.LoadAndDelete(payload.key(uint16(payload.BindPort))); ok {
+			common.IgnoreCloseError(v.(environment.RemoteListener))
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (this *service) handleTcpIpForwardStart(ctx glssh.Context, conn *connection, payload remoteForwardRequest) (bool, []byte) {
+	l := conn.logger.
+		With("bindHost", payload.BindAddr).
+		With("bindPort", payload.BindPort)
+
+	auth, _, _, err := this.resolveAuthorizationAndSession(ctx)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot resolve active authorization and its session; rejecting...")
+		return false, nil
+	}
+
+	bind, err := payload.bind()
+	if err != nil {
+		l.WithError(err).
+			Info("cannot parse client's bind address; rejecting...")
+		return false, nil
+	}
+
+	if ok, rule, _, err := this.evaluatePortForwardingRules(ctx, conn, auth, bind); err != nil {
+		l.WithError(err).
+			Error("cannot check if port forwarding is allowed; rejecting...")
+		return false, nil
+	} else if !ok {
+		this.onPortForwardingRejected(conn, auth, portForwardingDirectionRemote, bind, rule)
+		return false, nil
+	}
+
+	fwdCtx, span := startSpan(ctx, "ssh.tcpIpForward",
+		attribute.String("bifroest.forward.bind", bind.String()),
+	)
+	defer span.End()
+
+	req := environmentRequest{
+		environmentContext{
+			service:       this,
+			connection:    conn,
+			authorization: auth,
+			context:       fwdCtx,
+		},
+		nil,
+	}
+
+	env, err := this.ensureEnvironment(&req)
+	if err != nil {
+		l.WithError(err).
+			Error("cannot ensure environment; rejecting...")
+		return false, nil
+	}
+	success := false
+	defer common.IgnoreCloseErrorIfFalse(&success, env)
+
+	if ok, err := env.IsPortForwardingAllowed(bind); err != nil {
+		l.WithError(err).
+			Error("cannot check if port forwarding is allowed; rejecting...")
+		return false, nil
+	} else if !ok {
+		l.Info("remote port forwarding requested by client was rejected")
+		return false, nil
+	}
+
+	ln, err := env.NewRemoteListener(ctx, bind)
+	if err != nil {
+		l.WithError(err).
+			Info("cannot listen for remote port forwarding; rejecting...")
+		return false, nil
+	}
+	if ln == nil {
+		l.Info("remote port forwarding rejected")
+		return false, nil
+	}
+
+	port := ln.Addr().Port
+	key := payload.key(port)
+	if existing, loaded := conn.remoteListeners.Swap(key, ln); loaded {
+		common.IgnoreCloseError(existing.(environment.RemoteListener))
+	}
+	l = l.With("bound", ln.Addr())
+
+	success = true
+	go func() {
+		defer common.IgnoreCloseError(env)
+		this.serveRemoteListener(ctx, conn, l, payload.BindAddr, key, ln)
+	}()
+
+	l.Info("remote port forwarding started")
+	return true, gossh.Marshal(&remoteForwardSuccess{uint32(port)})
+}
+
+func (this *service) serveRemoteListener(ctx glssh.Context, conn *connection, l log.Logger, bindAddr string, key string, ln environment.RemoteListener) {
+	defer conn.remoteListeners.CompareAndDelete(key, ln)
+	defer common.IgnoreCloseError(ln)
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	for {
+		dConn, origin, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil && this.isRelevantError(err) {
+				l.WithError(err).
+					Warn("cannot accept connection of remote port forwarding; stopping...")
+			} else {
+				l.Info("remote port forwarding stopped")
+			}
+			return
+		}
+		go this.handleForwardedTcpIp(ctx, conn, l.With("origin", origin), bindAddr, ln.Addr().Port, origin, dConn)
+	}
+}
+
+type forwardedTcpIpChannelData struct {
+	DestAddr string
+	DestPort uint32
+
+	OriginAddr string
+	OriginPort uint32
+}
+
+func (this *service) handleForwardedTcpIp(ctx glssh.Context, conn *connection, l log.Logger, bindAddr string, bindPort uint16, origin net.HostPort, dConn gonet.Conn) {
+	this.forwardToClient(ctx, conn, l, forwardedTcpIpChannelType, gossh.Marshal(&forwardedTcpIpChannelData{
+		DestAddr:   bindAddr,
+		DestPort:   uint32(bindPort),
+		OriginAddr: origin.Host.String(),
+		OriginPort: uint32(origin.Port),
+	}), dConn)
+}
+
+// forwardToClient opens a new channel of the given type to the client and
+// copies everything between it and the given dConn.
+func (this *service) forwardToClient(ctx glssh.Context, conn *connection, l log.Logger, channelType string, payload []byte, dConn gonet.Conn) {
+	defer common.IgnoreCloseError(dConn)
+	defer conn.onChannel()()
+
+	sshConn, ok := ctx.Value(glssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		l.Error("cannot resolve ssh connection; closing...")
+		return
+	}
+
+	sConn, reqs, err := sshConn.OpenChannel(channelType, payload)
+	if err != nil {
+		l.WithError(err).
+			Info("client rejected connection of remote forwarding; closing...")
+		return
+	}
+	defer common.IgnoreCloseError(sConn)
+
+	go gossh.DiscardRequests(reqs)
+
+	_ = sys.FullDuplexCopy(ctx, sConn, dConn, &sys.FullDuplexCopyOpts{
+		OnStart: func() {
+			l.Debug("remote forwarding connection started")
+		},
+		OnEnd: func(s2d, d2s int64, duration time.Duration, err error, _ *bool) {
+			ld := l.
+				With("s2d", s2d).
+				With("d2s", d2s).
+				With("duration", duration)
+			if err != nil {
+				ld.WithError(err).Error("cannot successful handle remote forwarding connection; canceling...")
+			} else {
+				ld.Info("remote forwarding connection finished")
+			}
+		},
+		OnStreamEnd: func(isL2r bool, err error) {
+			if isL2r {
+				_ = dConn.Close()
+			} else {
+				_ = sConn.Close()
+			}
+		},
+	})
+}
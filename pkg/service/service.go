@@ -19,9 +19,11 @@ import (
 	"github.com/engity-com/bifroest/pkg/authorization"
 	"github.com/engity-com/bifroest/pkg/common"
 	"github.com/engity-com/bifroest/pkg/configuration"
+	bconn "github.com/engity-com/bifroest/pkg/connection"
 	"github.com/engity-com/bifroest/pkg/crypto"
 	"github.com/engity-com/bifroest/pkg/environment"
 	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/event"
 	"github.com/engity-com/bifroest/pkg/imp"
 	bnet "github.com/engity-com/bifroest/pkg/net"
 	"github.com/engity-com/bifroest/pkg/session"
@@ -69,6 +71,12 @@ func (this *Service) Run(ctx context.Context) (rErr error) {
 		}
 	}
 
+	tracingProvider, err := this.configureTracing(ctx)
+	if err != nil {
+		return err
+	}
+	defer common.KeepCloseError(&rErr, tracingProvider)
+
 	svc, err := this.prepare()
 	if err != nil {
 		return err
@@ -107,6 +115,10 @@ func (this *Service) Run(ctx context.Context) (rErr error) {
 		lns[i].ln = ln
 	}
 
+	if err := svc.admin.start(); err != nil {
+		return err
+	}
+
 	this.logger().WithAll(sys.VersionToMap(this.Version)).Info("started")
 
 	done := make(chan error, len(lns))
@@ -171,11 +183,6 @@ func (this *Service) prepare() (svc *service, err error) {
 	ctx := context.Background()
 	svc = &service{Service: this}
 
-	svc.knownFlows = make(map[configuration.FlowName]struct{})
-	for _, flow := range this.Configuration.Flows {
-		svc.knownFlows[flow.Name] = struct{}{}
-	}
-
 	sshKeysExchanges, err := this.Configuration.Ssh.Keys.Exchanges.MarshalTexts()
 	if err != nil {
 		return fail(err)
@@ -201,34 +208,53 @@ func (this *Service) prepare() (svc *service, err error) {
 		svc.resolvedSshMessagesCiphers[i] = string(n)
 	}
 
-	hostSigners, err := this.loadHostPrivateKeys()
+	hostSigners, err := this.prepareRepositories(ctx, svc)
 	if err != nil {
 		return fail(err)
 	}
+	if err = svc.houseKeeper.init(svc); err != nil {
+		return fail(err)
+	}
+	if err := this.prepareServer(ctx, svc, hostSigners); err != nil {
+		return fail(err)
+	}
+	if err = svc.admin.init(svc); err != nil {
+		return fail(err)
+	}
+
+	return svc, nil
+}
+
+func (this *Service) prepareRepositories(ctx context.Context, svc *service) (hostSigners []crypto.PrivateKey, err error) {
+	svc.knownFlows = make(map[configuration.FlowName]struct{})
+	for _, flow := range this.Configuration.Flows {
+		svc.knownFlows[flow.Name] = struct{}{}
+	}
+
+	if hostSigners, err = this.loadHostPrivateKeys(); err != nil {
+		return nil, err
+	}
 
 	if svc.alternatives, err = alternatives.NewProvider(ctx, this.Version, &this.Configuration.Alternatives); err != nil {
-		return fail(err)
+		return nil, err
+	}
+	if svc.events, err = event.NewDispatcher(ctx, &this.Configuration.Events, nil); err != nil {
+		return nil, err
 	}
 	if svc.imp, err = imp.NewImp(ctx, hostSigners[0]); err != nil {
-		return fail(err)
+		return nil, err
 	}
 	if svc.sessions, err = session.NewFacadeRepository(ctx, &this.Configuration.Session); err != nil {
-		return fail(err)
+		return nil, err
 	}
 	if svc.authorizer, err = authorization.NewAuthorizerFacade(ctx, &this.Configuration.Flows); err != nil {
-		return fail(err)
+		return nil, err
 	}
 	if svc.environments, err = environment.NewRepositoryFacade(ctx, &this.Configuration.Flows, svc.alternatives, svc.imp); err != nil {
-		return fail(err)
-	}
-	if err = svc.houseKeeper.init(svc); err != nil {
-		return fail(err)
-	}
-	if err := this.prepareServer(ctx, svc, hostSigners); err != nil {
-		return fail(err)
+		return nil, err
 	}
 
-	return svc, nil
+	return hostSigners, nil
 }
 
 func (this *Service) prepareServer(_ context.Context, svc *service, hostPrivateKeys []crypto.PrivateKey) (err error) {
@@ -238,18 +264,22 @@ func (this *Service) prepareServer(_ context.Context, svc *service, hostPrivateK
 	svc.server.ConnCallback = svc.onNewConnConnection
 	svc.server.Handler = svc.handleSshShellSession
 	svc.server.PtyCallback = svc.onPtyRequest
-	svc.server.ReversePortForwardingCallback = svc.onReversePortForwardingRequested
 	svc.server.PublicKeyHandler = svc.handlePublicKey
 	svc.server.PasswordHandler = svc.handlePassword
 	svc.server.KeyboardInteractiveHandler = svc.handleKeyboardInteractiveChallenge
 	svc.server.BannerHandler = svc.handleBanner
 	svc.server.RequestHandlers = map[string]glssh.RequestHandler{
-		"tcpip-forward":        svc.forwardHandler.HandleSSHRequest,
-		"cancel-tcpip-forward": svc.forwardHandler.HandleSSHRequest,
+		"tcpip-forward":        svc.handleTcpIpForward,
+		"cancel-tcpip-forward": svc.handleTcpIpForward,
+
+		streamLocalForwardRequestType: svc.handleStreamLocalForward,
+		cancelStreamLocalForwardType:  svc.handleStreamLocalForward,
 	}
 	svc.server.ChannelHandlers = map[string]glssh.ChannelHandler{
 		"session":      svc.handleNewSshSession,
 		"direct-tcpip": svc.handleNewDirectTcpIp,
+
+		directStreamLocalChannelType: svc.handleNewDirectStreamLocal,
 	}
 	svc.server.SubsystemHandlers = map[string]glssh.SubsystemHandler{
 		"sftp": svc.handleSshSftpSession,
@@ -295,14 +325,16 @@ func (this *Service) loadHostPrivateKeys() ([]crypto.PrivateKey, error) {
 type service struct {
 	*Service
 
-	sessions       session.CloseableRepository
-	authorizer     authorization.CloseableAuthorizer
-	environments   environment.CloseableRepository
-	houseKeeper    houseKeeper
-	alternatives   alternatives.Provider
-	imp            imp.Imp
-	server         glssh.Server
-	forwardHandler glssh.ForwardedTCPHandler
+	sessions     session.CloseableRepository
+	authorizer   authorization.CloseableAuthorizer
+	environments environment.CloseableRepository
+	houseKeeper  houseKeeper
+	alternatives alternatives.Provider
+	imp          imp.Imp
+	server       glssh.Server
+	admin        adminServer
+	events       event.CloseableDispatcher
+	shares       shares
 
 	knownFlows map[configuration.FlowName]struct{}
 
@@ -311,6 +343,8 @@ type service struct {
 	resolvedSshMessagesCiphers         []string
 
 	activeConnections atomic.Int64
+	connections       map[bconn.Id]*connection
+	connectionsMutex  sync.RWMutex
 }
 
 func withLazyContextOrFieldExclude[C any](ctx glssh.Context, ctxKey any) fields.Lazy {
@@ -357,6 +391,8 @@ func (this *service) createNewServerConfig(glssh.Context) *gossh.ServerConfig {
 }
 
 func (this *service) Close() (rErr error) {
+	defer common.KeepCloseError(&rErr, &this.admin)
+	defer common.KeepCloseError(&rErr, this.events)
 	defer common.KeepCloseError(&rErr, this.alternatives)
 	defer common.KeepCloseError(&rErr, this.imp)
 	defer common.KeepCloseError(&rErr, this.sessions)
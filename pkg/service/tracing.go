@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	glssh "github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/tracing"
+)
+
+const (
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// configureTracing creates a new tracingProvider based on the configured
+// configuration.Tracing and registers it globally. If tracing is disabled, a
+// tracingProvider which does nothing is returned.
+func (this *Service) configureTracing(ctx context.Context) (*tracingProvider, error) {
+	fail := func(err error) (*tracingProvider, error) {
+		return nil, errors.Config.Newf("cannot configure tracing: %w", err)
+	}
+
+	conf := &this.Configuration.Tracing
+	if !conf.IsEnabled() {
+		return &tracingProvider{}, nil
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpointURL(conf.Endpoint),
+	}
+	if len(conf.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(conf.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return fail(err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(conf.GetServiceName()),
+		semconv.ServiceVersion(this.Version.Version()),
+	))
+	if err != nil {
+		return fail(err)
+	}
+
+	delegate := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(delegate)
+	otel.SetTextMapPropagator(tracing.Propagator())
+
+	this.logger().
+		With("endpoint", conf.Endpoint).
+		Info("tracing enabled")
+
+	return &tracingProvider{delegate}, nil
+}
+
+type tracingProvider struct {
+	delegate *sdktrace.TracerProvider
+}
+
+func (this *tracingProvider) Close() error {
+	if this == nil || this.delegate == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := this.delegate.Shutdown(ctx); err != nil {
+		return errors.Network.Newf("cannot shutdown tracing: %w", err)
+	}
+	return nil
+}
+
+// tracedContext is a glssh.Context which carries additionally a trace.Span
+// for everything which uses it as context.Context.
+type tracedContext struct {
+	glssh.Context
+	traced context.Context
+}
+
+func (this *tracedContext) Value(key any) any {
+	return this.traced.Value(key)
+}
+
+// startSpan starts a new span as child of the span which might be already
+// present in the given ctx. The returned glssh.Context carries the new span.
+func startSpan(ctx glssh.Context, name string, attrs ...attribute.KeyValue) (glssh.Context, trace.Span) {
+	traced, span := tracing.Start(ctx, name, attrs...)
+	return &tracedContext{ctx, traced}, span
+}
@@ -0,0 +1,557 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	"github.com/google/uuid"
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+const (
+	// boltOpenTimeout is how long it is waited for the lock of the database
+	// file, which is held by another process (like a running service).
+	boltOpenTimeout = 5 * time.Second
+)
+
+var (
+	// boltBucketSessions contains one bucket per flow, which contains all
+	// records by their Id.
+	boltBucketSessions = []byte("sessions")
+	// boltBucketPublicKeys indexes all sessions by the hash of their public keys.
+	boltBucketPublicKeys = []byte("publicKeys")
+	// boltBucketAccessTokens indexes all sessions by the hash of their authorization token.
+	boltBucketAccessTokens = []byte("accessTokens")
+
+	_ = RegisterRepository(NewBoltRepository)
+)
+
+func NewBoltRepository(_ context.Context, conf *configuration.SessionBolt) (*BoltRepository, error) {
+	fail := func(err error) (*BoltRepository, error) {
+		return nil, errors.System.Newf("cannot open session database %q: %w", conf.File, err)
+	}
+
+	cipher, err := newTokenCipher(&conf.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(conf.File), 0700); err != nil {
+		return fail(err)
+	}
+	db, err := bbolt.Open(conf.File, os.FileMode(conf.FileMode), &bbolt.Options{
+		Timeout: boltOpenTimeout,
+	})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return fail(errors.System.Newf("database is locked by another process; maybe the service is still running"))
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{boltBucketSessions, boltBucketPublicKeys, boltBucketAccessTokens} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return fail(err)
+	}
+
+	result := BoltRepository{
+		conf:   conf,
+		db:     db,
+		cipher: cipher,
+	}
+	result.interceptors = newConnectionInterceptors(conf.IdleTimeout.Native(), conf.MaxTimeout.Native(), conf.MaxConnections, result.touch)
+
+	return &result, nil
+}
+
+// BoltRepository is a Repository which stores all sessions inside an embedded
+// transactional database (bbolt). Each Session is stored as a record which is
+// always modified as a whole inside one transaction. Sessions are indexed by
+// their public keys and authorization token.
+type BoltRepository struct {
+	Logger log.Logger
+
+	conf   *configuration.SessionBolt
+	db     *bbolt.DB
+	cipher *tokenCipher
+
+	interceptors connectionInterceptors
+}
+
+func (this *BoltRepository) Create(ctx context.Context, flow configuration.FlowName, remote net.Remote, authToken []byte) (Session, error) {
+	fail := func(err error) (Session, error) {
+		return nil, fmt.Errorf("cannot create session for user %v at flow %v: %w", remote, flow, err)
+	}
+
+	vid, err := uuid.NewUUID()
+	if err != nil {
+		return fail(err)
+	}
+	id := Id(vid)
+
+	r := newRecord(remote, authToken)
+	if err := this.db.Update(func(tx *bbolt.Tx) error {
+		return this.put(tx, flow, id, r, nil)
+	}); err != nil {
+		return fail(err)
+	}
+
+	return this.session(flow, id, r), nil
+}
+
+func (this *BoltRepository) Import(ctx context.Context, from Session) (Session, error) {
+	fail := func(err error) (Session, error) {
+		return nil, fmt.Errorf("cannot import session %v: %w", from, err)
+	}
+
+	r, err := recordOf(ctx, from)
+	if err != nil {
+		return fail(err)
+	}
+	flow, id := from.Flow(), from.Id()
+	if err := this.db.Update(func(tx *bbolt.Tx) error {
+		existing, err := this.get(tx, flow, id)
+		if errors.Is(err, ErrNoSuchSession) {
+			existing = nil
+		} else if err != nil {
+			return err
+		}
+		return this.put(tx, flow, id, r, existing)
+	}); err != nil {
+		return fail(err)
+	}
+
+	return this.session(flow, id, r), nil
+}
+
+func (this *BoltRepository) FindBy(ctx context.Context, flow configuration.FlowName, id Id, opts *FindOpts) (Session, error) {
+	var r *record
+	if err := this.db.View(func(tx *bbolt.Tx) (err error) {
+		r, err = this.get(tx, flow, id)
+		return err
+	}); err != nil {
+		return nil, this.onFindFailed(ctx, flow, id, opts, err)
+	}
+
+	return this.matching(ctx, flow, id, r, opts)
+}
+
+func (this *BoltRepository) FindByPublicKey(ctx context.Context, pub ssh.PublicKey, opts *FindOpts) (Session, error) {
+	result, err := this.findByIndex(ctx, boltBucketPublicKeys, publicKeyHash(pub), func(r *record) bool {
+		return r.hasPublicKey(pub)
+	}, opts)
+	if err != nil && !errors.Is(err, ErrNoSuchSession) {
+		return nil, fmt.Errorf("cannot find session for public key: %w", err)
+	}
+	return result, err
+}
+
+func (this *BoltRepository) FindByAccessToken(ctx context.Context, t []byte, opts *FindOpts) (Session, error) {
+	result, err := this.findByIndex(ctx, boltBucketAccessTokens, hashOf(t), func(r *record) bool {
+		return bytes.Equal(r.AuthorizationToken, t)
+	}, opts)
+	if err != nil && !errors.Is(err, ErrNoSuchSession) {
+		return nil, fmt.Errorf("cannot find session for access token: %w", err)
+	}
+	return result, err
+}
+
+func (this *BoltRepository) findByIndex(ctx context.Context, index []byte, hash []byte, matches func(*record) bool, opts *FindOpts) (Session, error) {
+	type candidate struct {
+		flow   configuration.FlowName
+		id     Id
+		record *record
+	}
+
+	// We collect the candidates first, because evaluating predicates inside a
+	// transaction could lead to deadlocks.
+	var candidates []candidate
+	if err := this.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(index).Cursor()
+		for k, _ := c.Seek(hash); k != nil && bytes.HasPrefix(k, hash); k, _ = c.Next() {
+			flow, id, err := parseRecordRef(k[len(hash):])
+			if err != nil {
+				// Broken index entries are ignored.
+				continue
+			}
+			r, err := this.get(tx, flow, id)
+			if errors.Is(err, ErrNoSuchSession) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if matches(r) {
+				candidates = append(candidates, candidate{flow, id, r})
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err := this.matching(ctx, c.flow, c.id, c.record, opts)
+		if errors.Is(err, ErrNoSuchSession) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	return nil, ErrNoSuchSession
+}
+
+func (this *BoltRepository) FindAll(ctx context.Context, consumer Consumer, opts *FindOpts) error {
+	type ref struct {
+		flow configuration.FlowName
+		id   Id
+	}
+
+	// We collect the references first, to allow the consumer to modify the
+	// repository while iterating.
+	var refs []ref
+	if err := this.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucketSessions).ForEachBucket(func(rawFlow []byte) error {
+			var flow configuration.FlowName
+			if err := flow.UnmarshalText(rawFlow); err != nil {
+				// We ignore buckets which does not match Flow in their names.
+				return nil
+			}
+			return tx.Bucket(boltBucketSessions).Bucket(rawFlow).ForEach(func(rawId, _ []byte) error {
+				var id Id
+				if err := id.UnmarshalText(rawId); err != nil {
+					// We ignore entries which does not match Id in their names.
+					return nil
+				}
+				refs = append(refs, ref{flow, id})
+				return nil
+			})
+		})
+	}); err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		candidate, err := this.FindBy(ctx, r.flow, r.id, opts)
+		if errors.Is(err, ErrNoSuchSession) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		canContinue, err := consumer(ctx, candidate)
+		if err != nil {
+			return err
+		}
+		if !canContinue {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (this *BoltRepository) DeleteBy(_ context.Context, flow configuration.FlowName, id Id) error {
+	// Also tell all active connection that we do no longer like them ;-)
+	this.interceptors.dispose(flow, id)
+
+	if err := this.db.Update(func(tx *bbolt.Tx) error {
+		return this.delete(tx, flow, id)
+	}); err != nil {
+		return fmt.Errorf("cannot delete session %v/%v: %w", flow, id, err)
+	}
+	return nil
+}
+
+func (this *BoltRepository) Delete(ctx context.Context, s Session) error {
+	if s == nil {
+		return nil
+	}
+	switch v := s.(type) {
+	case *recordSession:
+		return this.DeleteBy(ctx, v.flow, v.id)
+	default:
+		return fmt.Errorf("unknown session type: %T", v)
+	}
+}
+
+func (this *BoltRepository) Close() error {
+	return this.db.Close()
+}
+
+func (this *BoltRepository) session(flow configuration.FlowName, id Id, r *record) *recordSession {
+	return &recordSession{
+		store:   this,
+		flow:    flow,
+		id:      id,
+		created: r.Created.VAt,
+	}
+}
+
+func (this *BoltRepository) info(flow configuration.FlowName, id Id, r *record) *recordInfo {
+	return &recordInfo{
+		flow:        flow,
+		id:          id,
+		record:      r,
+		idleTimeout: this.conf.IdleTimeout.Native(),
+		maxTimeout:  this.conf.MaxTimeout.Native(),
+	}
+}
+
+func (this *BoltRepository) matching(ctx context.Context, flow configuration.FlowName, id Id, r *record, opts *FindOpts) (Session, error) {
+	result := this.session(flow, id, r)
+	if ok, err := opts.GetPredicates().Matches(ctx, result); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNoSuchSession
+	}
+	return result, nil
+}
+
+func (this *BoltRepository) onFindFailed(ctx context.Context, flow configuration.FlowName, id Id, opts *FindOpts, err error) error {
+	if errors.Is(err, ErrNoSuchSession) || !opts.IsAutoCleanUpAllowed() {
+		return err
+	}
+
+	logger := opts.GetLogger(this.logger).
+		Withf("session", "%v/%v", flow, id)
+	if dErr := this.DeleteBy(ctx, flow, id); dErr != nil {
+		logger.
+			WithError(dErr).
+			Error("cannot clean up session automatically; this is really a problem because it could lead to this error shown up repeatedly and a system which gets stuck")
+	} else {
+		logger.
+			WithError(err).
+			Warn("found broken session; it was removed entirely")
+	}
+	return err
+}
+
+// update loads the record of the given session, modifies it and stores it
+// again within one transaction.
+func (this *BoltRepository) update(_ context.Context, flow configuration.FlowName, id Id, modifier func(*record) error) error {
+	return this.db.Update(func(tx *bbolt.Tx) error {
+		existing, err := this.get(tx, flow, id)
+		if err != nil {
+			return err
+		}
+		// The existing record is also used to clean up the indexes, therefore it must not be modified.
+		modified := existing.clone()
+		if err := modifier(modified); err != nil {
+			return err
+		}
+		return this.put(tx, flow, id, modified, existing)
+	})
+}
+
+// view loads the record of the given session. If its tokens are not
+// encrypted with the current key (anymore), they are encrypted again.
+func (this *BoltRepository) view(ctx context.Context, flow configuration.FlowName, id Id) (result *record, _ error) {
+	var outdated bool
+	err := this.db.View(func(tx *bbolt.Tx) (err error) {
+		result, outdated, err = this.getChecked(tx, flow, id)
+		return err
+	})
+	if err == nil && outdated {
+		this.reseal(ctx, flow, id)
+	}
+	return result, err
+}
+
+// reseal stores the record of the given session again, which encrypts its
+// tokens with the current key.
+func (this *BoltRepository) reseal(ctx context.Context, flow configuration.FlowName, id Id) {
+	err := this.update(ctx, flow, id, func(*record) error {
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrNoSuchSession) {
+		this.logger().
+			WithError(err).
+			Withf("session", "%v/%v", flow, id).
+			Warn("cannot re-encrypt session's tokens; will try again on next access")
+	}
+}
+
+func (this *BoltRepository) touch(flow configuration.FlowName, id Id, at time.Time) error {
+	err := this.update(context.Background(), flow, id, func(r *record) error {
+		r.LastAccessed.VAt = at.Truncate(time.Millisecond)
+		return nil
+	})
+	if errors.Is(err, ErrNoSuchSession) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot update last accessed time of session %v/%v: %w", flow, id, err)
+	}
+	return nil
+}
+
+func (this *BoltRepository) get(tx *bbolt.Tx, flow configuration.FlowName, id Id) (*record, error) {
+	result, _, err := this.getChecked(tx, flow, id)
+	return result, err
+}
+
+// getChecked is like get, but also reports if the tokens of the record are
+// outdated (see record.open).
+func (this *BoltRepository) getChecked(tx *bbolt.Tx, flow configuration.FlowName, id Id) (_ *record, outdated bool, _ error) {
+	rawFlow, rawId, err := boltKeysOf(flow, id)
+	if err != nil {
+		return nil, false, err
+	}
+	b := tx.Bucket(boltBucketSessions).Bucket(rawFlow)
+	if b == nil {
+		return nil, false, ErrNoSuchSession
+	}
+	raw := b.Get(rawId)
+	if raw == nil {
+		return nil, false, ErrNoSuchSession
+	}
+	var result record
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, false, errors.System.Newf("cannot decode session %v/%v: %w", flow, id, err)
+	}
+	if outdated, err = result.open(this.cipher); err != nil {
+		return nil, false, fmt.Errorf("cannot decrypt tokens of session %v/%v: %w", flow, id, err)
+	}
+	return &result, outdated, nil
+}
+
+// put stores the given record and updates the indexes. If there was a
+// previous version, it has to be provided to remove its index entries.
+func (this *BoltRepository) put(tx *bbolt.Tx, flow configuration.FlowName, id Id, r *record, previous *record) error {
+	rawFlow, rawId, err := boltKeysOf(flow, id)
+	if err != nil {
+		return err
+	}
+	sealed, err := r.sealed(this.cipher)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt tokens of session %v/%v: %w", flow, id, err)
+	}
+	raw, err := json.Marshal(sealed)
+	if err != nil {
+		return errors.System.Newf("cannot encode session %v/%v: %w", flow, id, err)
+	}
+	b, err := tx.Bucket(boltBucketSessions).CreateBucketIfNotExists(rawFlow)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(rawId, raw); err != nil {
+		return err
+	}
+
+	ref := boltRefOf(rawFlow, rawId)
+	if previous != nil {
+		if err := this.deleteIndexes(tx, ref, previous); err != nil {
+			return err
+		}
+	}
+	if len(r.AuthorizationToken) > 0 {
+		if err := tx.Bucket(boltBucketAccessTokens).Put(append(hashOf(r.AuthorizationToken), ref...), nil); err != nil {
+			return err
+		}
+	}
+	for _, pub := range r.PublicKeys {
+		if err := tx.Bucket(boltBucketPublicKeys).Put(append(hashOf(pub), ref...), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *BoltRepository) delete(tx *bbolt.Tx, flow configuration.FlowName, id Id) error {
+	rawFlow, rawId, err := boltKeysOf(flow, id)
+	if err != nil {
+		return err
+	}
+	sessions := tx.Bucket(boltBucketSessions)
+	b := sessions.Bucket(rawFlow)
+	if b == nil {
+		return nil
+	}
+
+	// A broken record is deleted anyway. Its dangling index entries are
+	// ignored while searching.
+	if existing, err := this.get(tx, flow, id); err == nil {
+		if err := this.deleteIndexes(tx, boltRefOf(rawFlow, rawId), existing); err != nil {
+			return err
+		}
+	}
+
+	if err := b.Delete(rawId); err != nil {
+		return err
+	}
+	if k, _ := b.Cursor().First(); k == nil {
+		if err := sessions.DeleteBucket(rawFlow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *BoltRepository) deleteIndexes(tx *bbolt.Tx, ref []byte, r *record) error {
+	if len(r.AuthorizationToken) > 0 {
+		if err := tx.Bucket(boltBucketAccessTokens).Delete(append(hashOf(r.AuthorizationToken), ref...)); err != nil {
+			return err
+		}
+	}
+	for _, pub := range r.PublicKeys {
+		if err := tx.Bucket(boltBucketPublicKeys).Delete(append(hashOf(pub), ref...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *BoltRepository) connectionInterceptors() *connectionInterceptors {
+	return &this.interceptors
+}
+
+func (this *BoltRepository) logger() log.Logger {
+	if v := this.Logger; v != nil {
+		return v
+	}
+	return log.GetLogger("sessions")
+}
+
+func boltKeysOf(flow configuration.FlowName, id Id) (rawFlow, rawId []byte, _ error) {
+	rawFlow, err := flow.MarshalText()
+	if err != nil {
+		return nil, nil, err
+	}
+	rawId, err = id.MarshalText()
+	if err != nil {
+		return nil, nil, err
+	}
+	return rawFlow, rawId, nil
+}
+
+// boltRefOf creates the reference of a session as it is used inside indexes.
+func boltRefOf(rawFlow, rawId []byte) []byte {
+	return []byte(string(rawFlow) + "/" + string(rawId))
+}
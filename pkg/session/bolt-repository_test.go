@@ -0,0 +1,130 @@
+package session
+
+import (
+	"context"
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+func TestBoltRepository(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	flow := configuration.FlowName("foo")
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	pub := newTestPublicKey(t)
+
+	var conf configuration.SessionBolt
+	require.NoError(t, conf.SetDefaults())
+	conf.File = filepath.Join(dir, "sessions.db")
+	instance, err := NewBoltRepository(ctx, &conf)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, instance.Close()) }()
+
+	sess, err := instance.Create(ctx, flow, remote, []byte("token"))
+	require.NoError(t, err)
+	require.NoError(t, sess.AddPublicKey(ctx, pub))
+
+	actual, err := instance.FindByPublicKey(ctx, pub, nil)
+	require.NoError(t, err)
+	require.Equal(t, sess.Id(), actual.Id())
+
+	actual, err = instance.FindByAccessToken(ctx, []byte("token"), nil)
+	require.NoError(t, err)
+	require.Equal(t, sess.Id(), actual.Id())
+
+	require.NoError(t, sess.SetAuthorizationToken(ctx, []byte("other")))
+	_, err = instance.FindByAccessToken(ctx, []byte("token"), nil)
+	require.ErrorIs(t, err, ErrNoSuchSession)
+
+	oldState, err := sess.NotifyLastAccess(ctx, remote, StateAuthorized)
+	require.NoError(t, err)
+	require.Equal(t, StateNew, oldState)
+
+	disposed, err := sess.Dispose(ctx)
+	require.NoError(t, err)
+	require.False(t, disposed)
+	disposed, err = sess.Dispose(ctx)
+	require.NoError(t, err)
+	require.True(t, disposed)
+
+	require.NoError(t, instance.Delete(ctx, sess))
+	_, err = instance.FindByPublicKey(ctx, pub, nil)
+	require.ErrorIs(t, err, ErrNoSuchSession)
+	_, err = instance.FindBy(ctx, flow, sess.Id(), nil)
+	require.ErrorIs(t, err, ErrNoSuchSession)
+}
+
+func TestBoltRepository_Import(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	flow := configuration.FlowName("foo")
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	pub := newTestPublicKey(t)
+
+	var sourceConf configuration.SessionFs
+	require.NoError(t, sourceConf.SetDefaults())
+	sourceConf.Storage = filepath.Join(dir, "sessions")
+	source, err := NewFsRepository(ctx, &sourceConf)
+	require.NoError(t, err)
+
+	original, err := source.Create(ctx, flow, remote, []byte("token"))
+	require.NoError(t, err)
+	require.NoError(t, original.AddPublicKey(ctx, pub))
+	require.NoError(t, original.SetEnvironmentToken(ctx, []byte("env")))
+
+	var conf configuration.SessionBolt
+	require.NoError(t, conf.SetDefaults())
+	conf.File = filepath.Join(dir, "sessions.db")
+	instance, err := NewBoltRepository(ctx, &conf)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, instance.Close()) }()
+
+	_, err = instance.Import(ctx, original)
+	require.NoError(t, err)
+	// Importing twice replaces the already imported one.
+	_, err = instance.Import(ctx, original)
+	require.NoError(t, err)
+
+	actual, err := instance.FindByPublicKey(ctx, pub, nil)
+	require.NoError(t, err)
+	require.Equal(t, flow, actual.Flow())
+	require.Equal(t, original.Id(), actual.Id())
+
+	envToken, err := actual.EnvironmentToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("env"), envToken)
+
+	expectedInfo, err := original.Info(ctx)
+	require.NoError(t, err)
+	expectedCreated, err := expectedInfo.Created(ctx)
+	require.NoError(t, err)
+	actualInfo, err := actual.Info(ctx)
+	require.NoError(t, err)
+	actualCreated, err := actualInfo.Created(ctx)
+	require.NoError(t, err)
+	require.True(t, expectedCreated.At().Equal(actualCreated.At()))
+}
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	raw, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	result, err := ssh.NewPublicKey(raw)
+	require.NoError(t, err)
+	return result
+}
+
+type testRemote struct {
+	user string
+	host net.Host
+}
+
+func (this testRemote) User() string   { return this.user }
+func (this testRemote) Host() net.Host { return this.host }
+func (this testRemote) String() string { return this.user + "@" + this.host.String() }
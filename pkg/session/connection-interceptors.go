@@ -0,0 +1,215 @@
+package session
+
+import (
+	"errors"
+	"io"
+	gonet "net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	"github.com/gliderlabs/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+)
+
+// touchThresholdFor returns how often the last access of a session should be
+// persisted at most, based on its idle and max timeout.
+func touchThresholdFor(idleTimeout, maxTimeout time.Duration) time.Duration {
+	result := defaultTouchThreshold
+	if idleTimeout > 0 {
+		result = idleTimeout
+	}
+	if maxTimeout > 0 && maxTimeout < result {
+		result = maxTimeout
+	}
+	if result > defaultTouchThreshold {
+		return defaultTouchThreshold
+	}
+	return result / 2
+}
+
+// connectionInterceptors keeps track of all active connections of the sessions
+// of one Repository. It is the common implementation for all repositories
+// which are not based on the file system.
+type connectionInterceptors struct {
+	idleTimeout    time.Duration
+	maxTimeout     time.Duration
+	maxConnections uint16
+	touchThreshold time.Duration
+
+	// touch persists the last access of the given session. If it returns
+	// errSessionDisposed, the session was disposed by another process.
+	touch func(flow configuration.FlowName, id Id, at time.Time) error
+
+	// lease is optional and registers one connection of the given session at
+	// a place which is shared with other processes. It is used instead of the
+	// local maxConnections check to enforce it across all of them.
+	lease func(flow configuration.FlowName, id Id) (io.Closer, error)
+
+	byFlow map[configuration.FlowName]map[Id]*connectionInterceptorStack
+	mutex  sync.Mutex
+}
+
+func newConnectionInterceptors(idleTimeout, maxTimeout time.Duration, maxConnections uint16, touch func(configuration.FlowName, Id, time.Time) error) connectionInterceptors {
+	return connectionInterceptors{
+		idleTimeout:    idleTimeout,
+		maxTimeout:     maxTimeout,
+		maxConnections: maxConnections,
+		touchThreshold: touchThresholdFor(idleTimeout, maxTimeout),
+		touch:          touch,
+	}
+}
+
+func (this *connectionInterceptors) create(flow configuration.FlowName, id Id, created time.Time) (_ ConnectionInterceptor, rErr error) {
+	var lease io.Closer
+	if this.lease != nil {
+		var err error
+		if lease, err = this.lease(flow, id); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if rErr != nil {
+				_ = lease.Close()
+			}
+		}()
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.byFlow == nil {
+		this.byFlow = make(map[configuration.FlowName]map[Id]*connectionInterceptorStack)
+	}
+	byId, ok := this.byFlow[flow]
+	if !ok {
+		byId = make(map[Id]*connectionInterceptorStack)
+		this.byFlow[flow] = byId
+	}
+	stack, ok := byId[id]
+	if !ok {
+		stack = &connectionInterceptorStack{
+			owner:   this,
+			flow:    flow,
+			id:      id,
+			created: created,
+		}
+		stack.lastActivity.Store(time.Now().UnixMilli())
+		byId[id] = stack
+	}
+
+	if n := stack.active.Add(1); this.lease == nil && this.maxConnections > 0 && n > int32(this.maxConnections) {
+		stack.active.Add(-1)
+		return nil, ErrMaxConnectionsPerSessionReached
+	}
+
+	return &connectionInterceptor{connectionInterceptorStack: stack, lease: lease}, nil
+}
+
+// dispose tells all active connections of the given session that they are no
+// longer allowed to do anything. It returns true if there was at least one.
+func (this *connectionInterceptors) dispose(flow configuration.FlowName, id Id) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	stack, ok := this.byFlow[flow][id]
+	if !ok {
+		return false
+	}
+	stack.disposed.Store(true)
+	return stack.active.Load() > 0
+}
+
+func (this *connectionInterceptors) release(stack *connectionInterceptorStack) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if stack.active.Add(-1) > 0 {
+		// Still others open, let it open...
+		return
+	}
+
+	byId := this.byFlow[stack.flow]
+	if byId[stack.id] != stack {
+		return
+	}
+	delete(byId, stack.id)
+	if len(byId) == 0 {
+		delete(this.byFlow, stack.flow)
+	}
+}
+
+type connectionInterceptorStack struct {
+	owner   *connectionInterceptors
+	flow    configuration.FlowName
+	id      Id
+	created time.Time
+	active  atomic.Int32
+
+	lastActivity atomic.Int64
+	disposed     atomic.Bool
+}
+
+func (this *connectionInterceptorStack) OnReadConnection(ssh.Context, log.Logger, gonet.Conn) (time.Time, ConnectionInterceptorResult, error) {
+	return this.onConnectionAction()
+}
+
+func (this *connectionInterceptorStack) OnWriteConnection(ssh.Context, log.Logger, gonet.Conn) (time.Time, ConnectionInterceptorResult, error) {
+	return this.onConnectionAction()
+}
+
+func (this *connectionInterceptorStack) onConnectionAction() (time.Time, ConnectionInterceptorResult, error) {
+	if this.disposed.Load() {
+		return time.Time{}, ConnectionInterceptorResultDisposed, nil
+	}
+	now := time.Now()
+	nowMilli := now.UnixMilli()
+
+	if this.lastActivity.Load()+this.owner.touchThreshold.Milliseconds() < nowMilli {
+		// We only persist the last access when the threshold is reached, otherwise
+		// we might produce too much write noise.
+		if err := this.owner.touch(this.flow, this.id, now); errors.Is(err, errSessionDisposed) {
+			this.disposed.Store(true)
+			return time.Time{}, ConnectionInterceptorResultDisposed, nil
+		} else if err != nil {
+			return time.Time{}, ConnectionInterceptorResultNone, err
+		}
+		this.lastActivity.Store(nowMilli)
+	}
+
+	var deadline time.Time
+	var t ConnectionInterceptorResult
+	if v := this.owner.idleTimeout; v > 0 {
+		deadline = now.Add(v)
+		t = ConnectionInterceptorResultIdle
+	}
+
+	if v := this.owner.maxTimeout; v > 0 {
+		maxDeadline := this.created.Add(v)
+		if deadline.IsZero() || deadline.After(maxDeadline) {
+			deadline = maxDeadline
+			t = ConnectionInterceptorResultMax
+		}
+	}
+
+	return deadline, t, nil
+}
+
+type connectionInterceptor struct {
+	*connectionInterceptorStack
+	lease  io.Closer
+	closed atomic.Bool
+}
+
+func (this *connectionInterceptor) Close() error {
+	if !this.closed.CompareAndSwap(false, true) {
+		// Already closed
+		return nil
+	}
+	this.owner.release(this.connectionInterceptorStack)
+	if v := this.lease; v != nil {
+		return v.Close()
+	}
+	return nil
+}
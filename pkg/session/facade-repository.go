@@ -56,3 +56,19 @@ func RegisterRepository[C any, R CloseableRepository](factory RepositoryFactory[
 	configurationTypeToRepositoryFactory[ct] = factory
 	return factory
 }
+
+func (this *FacadeRepository) Import(ctx context.Context, from Session) (Session, error) {
+	if v, ok := this.CloseableRepository.(Importer); ok {
+		return v.Import(ctx, from)
+	}
+	return nil, errors.Config.Newf("session repository %T does not support to import sessions", this.CloseableRepository)
+}
+
+// IsLeader delegates to the underlying Repository if it is an Elector.
+// Otherwise, this process is always the leader.
+func (this *FacadeRepository) IsLeader(ctx context.Context) (bool, error) {
+	if v, ok := this.CloseableRepository.(Elector); ok {
+		return v.IsLeader(ctx)
+	}
+	return true, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/engity-com/bifroest/pkg/common"
@@ -21,6 +23,9 @@ type fsInfo struct {
 	VRemoteUser string   `json:"remoteUser"`
 	VRemoteHost net.Host `json:"remoteHost"`
 
+	VTags  Tags   `json:"tags,omitempty"`
+	VNotes []Note `json:"notes,omitempty"`
+
 	created fsCreated
 }
 
@@ -52,6 +57,10 @@ func (this *fsInfo) GetField(name string, ce contextEnabled) (any, bool, error)
 			return nil, true, nil
 		}
 		return v, true, err
+	case "tags":
+		return this.VTags, true, nil
+	case "notes":
+		return this.VNotes, true, nil
 	default:
 		return nil, false, fmt.Errorf("unknown field %q", name)
 	}
@@ -95,6 +104,14 @@ func (this *fsInfo) ValidUntil(context.Context) (result time.Time, _ error) {
 	return result, nil
 }
 
+func (this *fsInfo) Tags(context.Context) (Tags, error) {
+	return maps.Clone(this.VTags), nil
+}
+
+func (this *fsInfo) Notes(context.Context) ([]Note, error) {
+	return slices.Clone(this.VNotes), nil
+}
+
 func (this *fsInfo) lastAccessed() (*fsLastAccessed, error) {
 	this.session.repository.mutex.RLock()
 	defer this.session.repository.mutex.RUnlock()
@@ -128,6 +145,12 @@ func (this *fsInfo) LastAccessed(context.Context) (InfoLastAccessed, error) {
 }
 
 func (this *fsInfo) save() error {
+	return this.saveAt(time.Now())
+}
+
+// saveAt saves this info with the given time as modification time, which
+// represents when the session was created.
+func (this *fsInfo) saveAt(t time.Time) error {
 	f, _, err := this.session.repository.openWrite(this.session.flow, this.session.id, FsFileSession, false)
 	if err != nil {
 		return err
@@ -138,8 +161,7 @@ func (this *fsInfo) save() error {
 		return fmt.Errorf("cannot encode session %v: %w", this, err)
 	}
 
-	now := time.Now()
-	if err := os.Chtimes(f.Name(), now, now); err != nil {
+	if err := os.Chtimes(f.Name(), t, t); err != nil {
 		return fmt.Errorf("cannot change time of session %v: %w", this, err)
 	}
 
@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -38,22 +39,15 @@ var (
 )
 
 func NewFsRepository(_ context.Context, conf *configuration.SessionFs) (*FsRepository, error) {
-	touchThreshold := defaultTouchThreshold
-	if v := conf.IdleTimeout.Native(); v > 0 {
-		touchThreshold = v
-	}
-	if v := conf.MaxTimeout.Native(); v > 0 && v < touchThreshold {
-		touchThreshold = v
-	}
-	if touchThreshold > defaultTouchThreshold {
-		touchThreshold = defaultTouchThreshold
-	} else {
-		touchThreshold = touchThreshold / 2
+	cipher, err := newTokenCipher(&conf.Encryption)
+	if err != nil {
+		return nil, err
 	}
 
 	result := FsRepository{
 		conf:           conf,
-		touchThreshold: touchThreshold,
+		cipher:         cipher,
+		touchThreshold: touchThresholdFor(conf.IdleTimeout.Native(), conf.MaxTimeout.Native()),
 	}
 
 	return &result, nil
@@ -62,7 +56,8 @@ func NewFsRepository(_ context.Context, conf *configuration.SessionFs) (*FsRepos
 type FsRepository struct {
 	Logger log.Logger
 
-	conf *configuration.SessionFs
+	conf   *configuration.SessionFs
+	cipher *tokenCipher
 
 	touchThreshold time.Duration
 
@@ -760,6 +755,46 @@ func (this *FsRepository) hasPublicKey(ctx context.Context, flow configuration.F
 	return found, nil
 }
 
+func (this *FsRepository) publicKeys(ctx context.Context, flow configuration.FlowName, id Id) (result []ssh.PublicKey, rErr error) {
+	dirName, err := this.dir(flow, id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dirName)
+	if sys.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory of session %v/%v: %w", flow, id, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), FsFilePublicKeysPrefix) {
+			continue
+		}
+		if err := func() (rErr error) {
+			f, _, err := this.openRead(flow, id, entry.Name())
+			if sys.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			defer common.KeepCloseError(&rErr, f)
+
+			_, err = this.findPublicKeyIn(ctx, flow, id, f, func(candidate ssh.PublicKey, _ int) (canContinue bool, _ error) {
+				result = append(result, candidate)
+				return true, nil
+			})
+			return err
+		}(); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
 func (this *FsRepository) addPublicKey(ctx context.Context, flow configuration.FlowName, id Id, pub ssh.PublicKey) (rErr error) {
 	if _, err := this.stat(flow, id, FsFileSession); err != nil {
 		return fmt.Errorf("cannot session's %v/%v last access because cannot stat info: %w", flow, id, err)
@@ -880,7 +915,15 @@ func (this *FsRepository) hasAccessToken(_ context.Context, flow configuration.F
 	}
 	defer common.KeepCloseError(&rErr, f)
 
-	return isReaderEqualToBytes(f, t)
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return false, err
+	}
+	plain, _, err := this.cipher.open(raw)
+	if err != nil {
+		return false, fmt.Errorf("cannot decrypt access token of session %v/%v: %w", flow, id, err)
+	}
+	return bytes.Equal(plain, t), nil
 }
 
 func (this *FsRepository) logger() log.Logger {
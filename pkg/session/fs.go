@@ -1,9 +1,11 @@
 package session
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"time"
 
@@ -68,24 +70,53 @@ func (this *fs) EnvironmentToken(ctx context.Context) ([]byte, error) {
 	return this.getToken(ctx, FsFileEnvironmentToken, "environment")
 }
 
-func (this *fs) getToken(_ context.Context, kind, name string) (_ []byte, rErr error) {
-	this.repository.mutex.RLock()
-	defer this.repository.mutex.RUnlock()
+func (this *fs) getToken(ctx context.Context, kind, name string) ([]byte, error) {
+	plain, raw, outdated, err := this.readToken(kind, name, true)
+	if err != nil || !outdated {
+		return plain, err
+	}
+
+	// The token is not encrypted with the current key (anymore). Encrypt it
+	// again, but only if nobody else has modified it in the meantime.
+	this.repository.mutex.Lock()
+	defer this.repository.mutex.Unlock()
+	_, current, _, err := this.readToken(kind, name, false)
+	if err == nil && bytes.Equal(current, raw) {
+		err = this.setToken(ctx, plain, kind, name)
+	}
+	if err != nil {
+		this.repository.logger().
+			WithError(err).
+			With("session", this).
+			Warnf("cannot re-encrypt session's %s token; will try again on next access", name)
+	}
+	return plain, nil
+}
+
+func (this *fs) readToken(kind, name string, lock bool) (plain, raw []byte, outdated bool, rErr error) {
+	if lock {
+		this.repository.mutex.RLock()
+		defer this.repository.mutex.RUnlock()
+	}
 
 	f, fn, err := this.repository.openRead(this.flow, this.id, kind)
 	if sys.IsNotExist(err) {
-		return nil, nil
+		return nil, nil, false, nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
 	}
 	defer common.KeepCloseError(&rErr, f)
 
-	data, err := io.ReadAll(f)
+	raw, err = io.ReadAll(f)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("cannot read session's %s token file (%q) of %v: %w", name, fn, this, err)
+	}
+	plain, outdated, err = this.repository.cipher.open(raw)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read session's %s token file (%q) of %v: %w", name, fn, this, err)
+		return nil, nil, false, fmt.Errorf("cannot decrypt session's %s token file (%q) of %v: %w", name, fn, this, err)
 	}
-	return data, nil
+	return plain, raw, outdated, nil
 }
 
 func (this *fs) SetAuthorizationToken(ctx context.Context, data []byte) (rErr error) {
@@ -122,13 +153,18 @@ func (this *fs) setToken(_ context.Context, data []byte, kind, name string) (rEr
 		return nil
 	}
 
+	sealed, err := this.repository.cipher.seal(data)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt session's %s token of %v: %w", name, this, err)
+	}
+
 	f, fn, err := this.repository.openWrite(this.flow, this.id, kind, false)
 	if err != nil {
 		return err
 	}
 	defer common.KeepCloseError(&rErr, f)
 
-	if _, err := f.Write(data); err != nil {
+	if _, err := f.Write(sealed); err != nil {
 		return fmt.Errorf("cannot write session's %s token file (%q) of %v: %w", name, fn, this, err)
 	}
 	return nil
@@ -141,6 +177,13 @@ func (this *fs) HasPublicKey(ctx context.Context, pub ssh.PublicKey) (bool, erro
 	return this.repository.hasPublicKey(ctx, this.flow, this.id, pub)
 }
 
+func (this *fs) PublicKeys(ctx context.Context) ([]ssh.PublicKey, error) {
+	this.repository.mutex.RLock()
+	defer this.repository.mutex.RUnlock()
+
+	return this.repository.publicKeys(ctx, this.flow, this.id)
+}
+
 func (this *fs) AddPublicKey(ctx context.Context, pub ssh.PublicKey) error {
 	this.repository.mutex.Lock()
 	defer this.repository.mutex.Unlock()
@@ -155,6 +198,46 @@ func (this *fs) DeletePublicKey(ctx context.Context, pub ssh.PublicKey) error {
 	return this.repository.deletePublicKey(ctx, this.flow, this.id, pub)
 }
 
+func (this *fs) AddTags(ctx context.Context, tags Tags) error {
+	if err := this.updateInfo(ctx, func(info *fsInfo) {
+		if info.VTags == nil {
+			info.VTags = make(Tags, len(tags))
+		}
+		maps.Copy(info.VTags, tags)
+	}); err != nil {
+		return fmt.Errorf("cannot add tags to session %v: %w", this, err)
+	}
+	return nil
+}
+
+func (this *fs) AddNote(ctx context.Context, note Note) error {
+	if err := this.updateInfo(ctx, func(info *fsInfo) {
+		info.VNotes = append(info.VNotes, note)
+	}); err != nil {
+		return fmt.Errorf("cannot add note to session %v: %w", this, err)
+	}
+	return nil
+}
+
+// updateInfo reads the current info of this session from the file system,
+// modifies it and saves it again, without changing when it was created.
+func (this *fs) updateInfo(ctx context.Context, modifier func(*fsInfo)) error {
+	this.repository.mutex.Lock()
+	defer this.repository.mutex.Unlock()
+
+	current, err := this.repository.findBy(ctx, this.flow, this.id, nil, false)
+	if err != nil {
+		return err
+	}
+	modifier(&current.info)
+	if err := current.info.saveAt(current.info.createdAt); err != nil {
+		return err
+	}
+	this.info.VTags = current.info.VTags
+	this.info.VNotes = current.info.VNotes
+	return nil
+}
+
 func (this *fs) NotifyLastAccess(_ context.Context, remote net.Remote, newState State) (State, error) {
 	return this.notifyLastAccess(remote, newState)
 }
@@ -17,6 +17,10 @@ type Info interface {
 	// ValidUntil defines until when the actual Session is valid to be used.
 	// If returned time.Time.IsZero() means forever.
 	ValidUntil(context.Context) (time.Time, error)
+	// Tags returns all tags attached to the actual Session.
+	Tags(context.Context) (Tags, error)
+	// Notes returns all notes added to the actual Session, the oldest first.
+	Notes(context.Context) ([]Note, error)
 	String() string
 }
 
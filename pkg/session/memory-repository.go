@@ -0,0 +1,404 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+var (
+	_ = RegisterRepository(NewMemoryRepository)
+)
+
+func NewMemoryRepository(_ context.Context, conf *configuration.SessionMemory) (*MemoryRepository, error) {
+	cipher, err := newTokenCipher(&conf.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	result := MemoryRepository{
+		conf:    conf,
+		cipher:  cipher,
+		records: make(map[configuration.FlowName]map[Id]*record),
+	}
+	result.interceptors = newConnectionInterceptors(conf.IdleTimeout.Native(), conf.MaxTimeout.Native(), conf.MaxConnections, result.touch)
+
+	if err := result.restore(); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// MemoryRepository is a Repository which holds all sessions only in memory.
+// Everything is lost on restart by design, unless
+// configuration.SessionMemory.Snapshot is configured.
+type MemoryRepository struct {
+	Logger log.Logger
+
+	conf   *configuration.SessionMemory
+	cipher *tokenCipher
+
+	records map[configuration.FlowName]map[Id]*record
+	mutex   sync.RWMutex
+
+	interceptors connectionInterceptors
+}
+
+func (this *MemoryRepository) Create(_ context.Context, flow configuration.FlowName, remote net.Remote, authToken []byte) (Session, error) {
+	vid, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create session for user %v at flow %v: %w", remote, flow, err)
+	}
+	id := Id(vid)
+
+	r := newRecord(remote, authToken)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.put(flow, id, r)
+
+	return this.session(flow, id, r), nil
+}
+
+func (this *MemoryRepository) Import(ctx context.Context, from Session) (Session, error) {
+	r, err := recordOf(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("cannot import session %v: %w", from, err)
+	}
+	flow, id := from.Flow(), from.Id()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.put(flow, id, r)
+
+	return this.session(flow, id, r), nil
+}
+
+func (this *MemoryRepository) FindBy(ctx context.Context, flow configuration.FlowName, id Id, opts *FindOpts) (Session, error) {
+	r, err := this.view(ctx, flow, id)
+	if err != nil {
+		return nil, err
+	}
+	return this.matching(ctx, flow, id, r, opts)
+}
+
+func (this *MemoryRepository) FindByPublicKey(ctx context.Context, pub ssh.PublicKey, opts *FindOpts) (Session, error) {
+	return this.findFirst(ctx, func(r *record) bool {
+		return r.hasPublicKey(pub)
+	}, opts)
+}
+
+func (this *MemoryRepository) FindByAccessToken(ctx context.Context, t []byte, opts *FindOpts) (Session, error) {
+	return this.findFirst(ctx, func(r *record) bool {
+		return bytes.Equal(r.AuthorizationToken, t)
+	}, opts)
+}
+
+func (this *MemoryRepository) findFirst(ctx context.Context, matches func(*record) bool, opts *FindOpts) (result Session, _ error) {
+	err := this.findAll(ctx, func(_ context.Context, candidate Session) (bool, error) {
+		result = candidate
+		return false, nil
+	}, opts, matches)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, ErrNoSuchSession
+	}
+	return result, nil
+}
+
+func (this *MemoryRepository) FindAll(ctx context.Context, consumer Consumer, opts *FindOpts) error {
+	return this.findAll(ctx, consumer, opts, nil)
+}
+
+func (this *MemoryRepository) findAll(ctx context.Context, consumer Consumer, opts *FindOpts, matches func(*record) bool) error {
+	type candidate struct {
+		flow   configuration.FlowName
+		id     Id
+		record *record
+	}
+
+	// We collect the candidates first, because the predicates and the
+	// consumer are allowed to access the repository, again.
+	var candidates []candidate
+	this.mutex.RLock()
+	for flow, byId := range this.records {
+		for id, r := range byId {
+			if matches == nil || matches(r) {
+				candidates = append(candidates, candidate{flow, id, r.clone()})
+			}
+		}
+	}
+	this.mutex.RUnlock()
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return strings.Compare(recordRefOf(a.flow, a.id), recordRefOf(b.flow, b.id))
+	})
+
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sess, err := this.matching(ctx, c.flow, c.id, c.record, opts)
+		if errors.Is(err, ErrNoSuchSession) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		canContinue, err := consumer(ctx, sess)
+		if err != nil {
+			return err
+		}
+		if !canContinue {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (this *MemoryRepository) DeleteBy(_ context.Context, flow configuration.FlowName, id Id) error {
+	// Also tell all active connection that we do no longer like them ;-)
+	this.interceptors.dispose(flow, id)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	byId := this.records[flow]
+	delete(byId, id)
+	if len(byId) == 0 {
+		delete(this.records, flow)
+	}
+	return nil
+}
+
+func (this *MemoryRepository) Delete(ctx context.Context, s Session) error {
+	if s == nil {
+		return nil
+	}
+	switch v := s.(type) {
+	case *recordSession:
+		return this.DeleteBy(ctx, v.flow, v.id)
+	default:
+		return fmt.Errorf("unknown session type: %T", v)
+	}
+}
+
+// Close writes the snapshot, if configured.
+func (this *MemoryRepository) Close() error {
+	return this.snapshot()
+}
+
+// memorySnapshot is the content of configuration.SessionMemory.Snapshot.
+type memorySnapshot struct {
+	Sessions map[configuration.FlowName]map[Id]*record `json:"sessions"`
+}
+
+func (this *MemoryRepository) snapshot() error {
+	fn := this.conf.Snapshot
+	if fn == "" {
+		return nil
+	}
+	fail := func(err error) error {
+		return errors.System.Newf("cannot write session snapshot %q: %w", fn, err)
+	}
+
+	// Tokens are only encrypted inside the snapshot, as it leaves the memory.
+	buf := memorySnapshot{make(map[configuration.FlowName]map[Id]*record)}
+	this.mutex.RLock()
+	for flow, byId := range this.records {
+		sealedById := make(map[Id]*record, len(byId))
+		for id, r := range byId {
+			sealed, err := r.sealed(this.cipher)
+			if err != nil {
+				this.mutex.RUnlock()
+				return fail(err)
+			}
+			sealedById[id] = sealed
+		}
+		buf.Sessions[flow] = sealedById
+	}
+	this.mutex.RUnlock()
+
+	raw, err := json.Marshal(buf)
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return fail(err)
+	}
+	// Write it first next to the target and replace it afterward, to never
+	// leave a half-written snapshot behind.
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, raw, os.FileMode(this.conf.SnapshotFileMode)); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		_ = os.Remove(tmp)
+		return fail(err)
+	}
+
+	this.logger().
+		With("snapshot", fn).
+		Debug("sessions written to snapshot")
+	return nil
+}
+
+func (this *MemoryRepository) restore() error {
+	fn := this.conf.Snapshot
+	if fn == "" {
+		return nil
+	}
+	fail := func(err error) error {
+		return errors.System.Newf("cannot read session snapshot %q: %w", fn, err)
+	}
+
+	raw, err := os.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fail(err)
+	}
+	var buf memorySnapshot
+	if err := json.Unmarshal(raw, &buf); err != nil {
+		return fail(err)
+	}
+	anyOutdated := false
+	for flow, byId := range buf.Sessions {
+		for id, r := range byId {
+			if r == nil {
+				continue
+			}
+			outdated, err := r.open(this.cipher)
+			if err != nil {
+				return fail(err)
+			}
+			anyOutdated = anyOutdated || outdated
+			this.put(flow, id, r)
+		}
+	}
+
+	this.logger().
+		With("snapshot", fn).
+		Debug("sessions restored from snapshot")
+
+	if anyOutdated {
+		// Some tokens are not encrypted with the current key (anymore). Write
+		// the snapshot again right now and not only on close, to not depend
+		// on the previous keys any longer.
+		if err := this.snapshot(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *MemoryRepository) session(flow configuration.FlowName, id Id, r *record) *recordSession {
+	return &recordSession{
+		store:   this,
+		flow:    flow,
+		id:      id,
+		created: r.Created.VAt,
+	}
+}
+
+func (this *MemoryRepository) info(flow configuration.FlowName, id Id, r *record) *recordInfo {
+	return &recordInfo{
+		flow:        flow,
+		id:          id,
+		record:      r,
+		idleTimeout: this.conf.IdleTimeout.Native(),
+		maxTimeout:  this.conf.MaxTimeout.Native(),
+	}
+}
+
+func (this *MemoryRepository) matching(ctx context.Context, flow configuration.FlowName, id Id, r *record, opts *FindOpts) (Session, error) {
+	result := this.session(flow, id, r)
+	if ok, err := opts.GetPredicates().Matches(ctx, result); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNoSuchSession
+	}
+	return result, nil
+}
+
+// update modifies the record of the given session atomically.
+func (this *MemoryRepository) update(_ context.Context, flow configuration.FlowName, id Id, modifier func(*record) error) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	existing, ok := this.records[flow][id]
+	if !ok {
+		return ErrNoSuchSession
+	}
+	// Work on a copy, to not leave a half modified record in case of an error.
+	modified := existing.clone()
+	if err := modifier(modified); err != nil {
+		return err
+	}
+	this.records[flow][id] = modified
+	return nil
+}
+
+// view returns a copy of the record of the given session.
+func (this *MemoryRepository) view(_ context.Context, flow configuration.FlowName, id Id) (*record, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	r, ok := this.records[flow][id]
+	if !ok {
+		return nil, ErrNoSuchSession
+	}
+	return r.clone(), nil
+}
+
+func (this *MemoryRepository) touch(flow configuration.FlowName, id Id, at time.Time) error {
+	err := this.update(context.Background(), flow, id, func(r *record) error {
+		r.LastAccessed.VAt = at.Truncate(time.Millisecond)
+		return nil
+	})
+	if errors.Is(err, ErrNoSuchSession) {
+		return nil
+	}
+	return err
+}
+
+// put stores the given record. The caller has to hold the write lock.
+func (this *MemoryRepository) put(flow configuration.FlowName, id Id, r *record) {
+	byId, ok := this.records[flow]
+	if !ok {
+		byId = make(map[Id]*record)
+		this.records[flow] = byId
+	}
+	byId[id] = r
+}
+
+func (this *MemoryRepository) connectionInterceptors() *connectionInterceptors {
+	return &this.interceptors
+}
+
+func (this *MemoryRepository) logger() log.Logger {
+	if v := this.Logger; v != nil {
+		return v
+	}
+	return log.GetLogger("sessions")
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	ctx := context.Background()
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	pub := newTestPublicKey(t)
+
+	instance := newTestMemoryRepository(t, "", 0)
+
+	sess, err := instance.Create(ctx, "foo", remote, []byte("token"))
+	require.NoError(t, err)
+	require.NoError(t, sess.AddPublicKey(ctx, pub))
+	_, err = instance.Create(ctx, "bar", remote, nil)
+	require.NoError(t, err)
+
+	actual, err := instance.FindByPublicKey(ctx, pub, nil)
+	require.NoError(t, err)
+	require.Equal(t, sess.Id(), actual.Id())
+
+	actual, err = instance.FindByAccessToken(ctx, []byte("token"), nil)
+	require.NoError(t, err)
+	require.Equal(t, sess.Id(), actual.Id())
+
+	_, err = instance.FindByPublicKey(ctx, pub, (&FindOpts{}).WithPredicate(IsFlow("bar")))
+	require.ErrorIs(t, err, ErrNoSuchSession)
+
+	var flows []configuration.FlowName
+	require.NoError(t, instance.FindAll(ctx, func(_ context.Context, candidate Session) (bool, error) {
+		flows = append(flows, candidate.Flow())
+		return true, nil
+	}, nil))
+	require.Equal(t, []configuration.FlowName{"bar", "foo"}, flows)
+
+	oldState, err := sess.NotifyLastAccess(ctx, remote, StateAuthorized)
+	require.NoError(t, err)
+	require.Equal(t, StateNew, oldState)
+
+	disposed, err := sess.Dispose(ctx)
+	require.NoError(t, err)
+	require.False(t, disposed)
+	info, err := actual.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, StateDisposed, info.State())
+
+	require.NoError(t, instance.Delete(ctx, sess))
+	_, err = instance.FindBy(ctx, "foo", sess.Id(), nil)
+	require.ErrorIs(t, err, ErrNoSuchSession)
+}
+
+func TestMemoryRepository_maxConnections(t *testing.T) {
+	ctx := context.Background()
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+
+	instance := newTestMemoryRepository(t, "", 1)
+
+	sess, err := instance.Create(ctx, "foo", remote, nil)
+	require.NoError(t, err)
+
+	ci, err := sess.ConnectionInterceptor(ctx)
+	require.NoError(t, err)
+	_, err = sess.ConnectionInterceptor(ctx)
+	require.ErrorIs(t, err, ErrMaxConnectionsPerSessionReached)
+
+	require.NoError(t, ci.Close())
+	ci, err = sess.ConnectionInterceptor(ctx)
+	require.NoError(t, err)
+	require.NoError(t, ci.Close())
+}
+
+func TestMemoryRepository_snapshot(t *testing.T) {
+	ctx := context.Background()
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	pub := newTestPublicKey(t)
+	fn := filepath.Join(t.TempDir(), "snapshot.json")
+
+	first := newTestMemoryRepository(t, fn, 0)
+	sess, err := first.Create(ctx, "foo", remote, []byte("token"))
+	require.NoError(t, err)
+	require.NoError(t, sess.AddPublicKey(ctx, pub))
+	require.NoError(t, first.Close())
+
+	second := newTestMemoryRepository(t, fn, 0)
+	actual, err := second.FindByPublicKey(ctx, pub, nil)
+	require.NoError(t, err)
+	require.Equal(t, sess.Id(), actual.Id())
+	token, err := actual.AuthorizationToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), token)
+}
+
+func TestMemoryRepository_tagsAndNotes(t *testing.T) {
+	ctx := context.Background()
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+
+	instance := newTestMemoryRepository(t, "", 0)
+
+	sess, err := instance.Create(ctx, "foo", remote, nil)
+	require.NoError(t, err)
+	_, err = instance.Create(ctx, "foo", remote, nil)
+	require.NoError(t, err)
+	require.NoError(t, sess.AddTags(ctx, Tags{"ticket": "OPS-1", "team": "a"}))
+	require.NoError(t, sess.AddTags(ctx, Tags{"team": "b"}))
+	require.NoError(t, sess.AddNote(ctx, Note{Author: "user@127.0.0.1", Text: "hello"}))
+
+	info, err := sess.Info(ctx)
+	require.NoError(t, err)
+	tags, err := info.Tags(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Tags{"ticket": "OPS-1", "team": "b"}, tags)
+	notes, err := info.Notes(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []Note{{Author: "user@127.0.0.1", Text: "hello"}}, notes)
+
+	find := func(tags Tags) (result []Id) {
+		require.NoError(t, instance.FindAll(ctx, func(_ context.Context, candidate Session) (bool, error) {
+			result = append(result, candidate.Id())
+			return true, nil
+		}, (&FindOpts{}).WithPredicate(HasTags(tags))))
+		return result
+	}
+	require.Equal(t, []Id{sess.Id()}, find(Tags{"ticket": ""}))
+	require.Equal(t, []Id{sess.Id()}, find(Tags{"ticket": "OPS-1", "team": "b"}))
+	require.Empty(t, find(Tags{"team": "a"}))
+	require.Len(t, find(nil), 2)
+}
+
+func newTestMemoryRepository(t *testing.T, snapshot string, maxConnections uint16) *MemoryRepository {
+	var conf configuration.SessionMemory
+	require.NoError(t, conf.SetDefaults())
+	conf.Snapshot = snapshot
+	conf.MaxConnections = maxConnections
+
+	result, err := NewMemoryRepository(context.Background(), &conf)
+	require.NoError(t, err)
+	return result
+}
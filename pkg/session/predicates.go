@@ -72,6 +72,28 @@ func IsRemoteName(name string) Predicate {
 	}
 }
 
+// HasTags matches every Session which has all the given Tags. An empty value
+// matches every value of a tag with the same key (see Tags.Matches).
+func HasTags(tags Tags) Predicate {
+	return func(ctx context.Context, candidate Session) (bool, error) {
+		if candidate == nil {
+			return false, nil
+		}
+		if len(tags) == 0 {
+			return true, nil
+		}
+		si, err := candidate.Info(ctx)
+		if err != nil {
+			return false, err
+		}
+		actual, err := si.Tags(ctx)
+		if err != nil {
+			return false, err
+		}
+		return tags.Matches(actual), nil
+	}
+}
+
 type Predicates []Predicate
 
 func (this Predicates) Matches(ctx context.Context, candidate Session) (bool, error) {
@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+// recordStore is implemented by all repositories which store a Session as a
+// whole record.
+type recordStore interface {
+	// view loads the record of the given session.
+	view(ctx context.Context, flow configuration.FlowName, id Id) (*record, error)
+	// update loads the record of the given session, modifies it and stores it
+	// again atomically.
+	update(ctx context.Context, flow configuration.FlowName, id Id, modifier func(*record) error) error
+	info(flow configuration.FlowName, id Id, r *record) *recordInfo
+	connectionInterceptors() *connectionInterceptors
+}
+
+// recordSession is the Session of all repositories implementing recordStore.
+type recordSession struct {
+	store recordStore
+
+	flow    configuration.FlowName
+	id      Id
+	created time.Time
+}
+
+func (this *recordSession) GetField(name string, ce contextEnabled) (any, bool, error) {
+	info, err := this.info(context.Background())
+	if err != nil {
+		return nil, false, err
+	}
+	return info.GetField(name, ce)
+}
+
+func (this *recordSession) Info(ctx context.Context) (Info, error) {
+	return this.info(ctx)
+}
+
+func (this *recordSession) info(ctx context.Context) (*recordInfo, error) {
+	r, err := this.store.view(ctx, this.flow, this.id)
+	if err != nil {
+		return nil, err
+	}
+	return this.store.info(this.flow, this.id, r), nil
+}
+
+func (this *recordSession) Flow() configuration.FlowName {
+	return this.flow
+}
+
+func (this *recordSession) Id() Id {
+	return this.id
+}
+
+func (this *recordSession) String() string {
+	return this.flow.String() + "/" + this.id.String()
+}
+
+func (this *recordSession) AuthorizationToken(ctx context.Context) ([]byte, error) {
+	r, err := this.store.view(ctx, this.flow, this.id)
+	if err != nil {
+		return nil, errors.System.Newf("cannot read access token of session %v: %w", this, err)
+	}
+	return r.AuthorizationToken, nil
+}
+
+func (this *recordSession) EnvironmentToken(ctx context.Context) ([]byte, error) {
+	r, err := this.store.view(ctx, this.flow, this.id)
+	if err != nil {
+		return nil, errors.System.Newf("cannot read environment token of session %v: %w", this, err)
+	}
+	return r.EnvironmentToken, nil
+}
+
+func (this *recordSession) SetAuthorizationToken(ctx context.Context, data []byte) error {
+	if err := this.store.update(ctx, this.flow, this.id, func(r *record) error {
+		r.AuthorizationToken = slices.Clone(data)
+		return nil
+	}); err != nil {
+		return errors.System.Newf("cannot write access token of session %v: %w", this, err)
+	}
+	return nil
+}
+
+func (this *recordSession) SetEnvironmentToken(ctx context.Context, data []byte) error {
+	if err := this.store.update(ctx, this.flow, this.id, func(r *record) error {
+		r.EnvironmentToken = slices.Clone(data)
+		return nil
+	}); err != nil {
+		return errors.System.Newf("cannot write environment token of session %v: %w", this, err)
+	}
+	return nil
+}
+
+func (this *recordSession) HasPublicKey(ctx context.Context, pub ssh.PublicKey) (bool, error) {
+	r, err := this.store.view(ctx, this.flow, this.id)
+	if err != nil {
+		return false, err
+	}
+	return r.hasPublicKey(pub), nil
+}
+
+func (this *recordSession) PublicKeys(ctx context.Context) ([]ssh.PublicKey, error) {
+	r, err := this.store.view(ctx, this.flow, this.id)
+	if err != nil {
+		return nil, err
+	}
+	return r.publicKeys()
+}
+
+func (this *recordSession) AddPublicKey(ctx context.Context, pub ssh.PublicKey) error {
+	return this.store.update(ctx, this.flow, this.id, func(r *record) error {
+		r.addPublicKey(pub)
+		return nil
+	})
+}
+
+func (this *recordSession) DeletePublicKey(ctx context.Context, pub ssh.PublicKey) error {
+	return this.store.update(ctx, this.flow, this.id, func(r *record) error {
+		r.deletePublicKey(pub)
+		return nil
+	})
+}
+
+func (this *recordSession) AddTags(ctx context.Context, tags Tags) error {
+	if err := this.store.update(ctx, this.flow, this.id, func(r *record) error {
+		r.addTags(tags)
+		return nil
+	}); err != nil {
+		return errors.System.Newf("cannot add tags to session %v: %w", this, err)
+	}
+	return nil
+}
+
+func (this *recordSession) AddNote(ctx context.Context, note Note) error {
+	if err := this.store.update(ctx, this.flow, this.id, func(r *record) error {
+		r.Notes = append(r.Notes, note)
+		return nil
+	}); err != nil {
+		return errors.System.Newf("cannot add note to session %v: %w", this, err)
+	}
+	return nil
+}
+
+func (this *recordSession) ConnectionInterceptor(context.Context) (ConnectionInterceptor, error) {
+	return this.store.connectionInterceptors().create(this.flow, this.id, this.created)
+}
+
+func (this *recordSession) NotifyLastAccess(ctx context.Context, remote net.Remote, newState State) (oldState State, _ error) {
+	if err := this.store.update(ctx, this.flow, this.id, func(r *record) error {
+		oldState = r.notifyLastAccess(remote, newState)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return oldState, nil
+}
+
+func (this *recordSession) Dispose(ctx context.Context) (bool, error) {
+	disposed := this.store.connectionInterceptors().dispose(this.flow, this.id)
+
+	err := this.store.update(ctx, this.flow, this.id, func(r *record) error {
+		if r.dispose() {
+			disposed = true
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNoSuchSession) {
+		return disposed, nil
+	}
+	if err != nil {
+		return false, errors.System.Newf("cannot dispose session %v: %w", this, err)
+	}
+	return disposed, nil
+}
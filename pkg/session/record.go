@@ -0,0 +1,376 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+// record holds the complete state of a Session. It is used by all
+// repositories which are not based on the file system, to store a Session
+// as a whole and modify it atomically.
+type record struct {
+	State              State        `json:"state"`
+	Created            recordAccess `json:"created"`
+	LastAccessed       recordAccess `json:"lastAccessed"`
+	AuthorizationToken []byte       `json:"authorizationToken,omitempty"`
+	EnvironmentToken   []byte       `json:"environmentToken,omitempty"`
+	PublicKeys         [][]byte     `json:"publicKeys,omitempty"`
+	Tags               Tags         `json:"tags,omitempty"`
+	Notes              []Note       `json:"notes,omitempty"`
+}
+
+func newRecord(remote net.Remote, authToken []byte) *record {
+	now := time.Now().Truncate(time.Millisecond)
+	access := recordAccess{
+		VAt:         now,
+		VRemoteUser: remote.User(),
+		VRemoteHost: remote.Host(),
+	}
+	return &record{
+		State:              StateNew,
+		Created:            access,
+		LastAccessed:       access,
+		AuthorizationToken: slices.Clone(authToken),
+	}
+}
+
+// recordOf creates a new record which contains everything the given Session
+// contains. It is used to import a Session of another Repository.
+func recordOf(ctx context.Context, sess Session) (*record, error) {
+	fail := func(err error) (*record, error) {
+		return nil, fmt.Errorf("cannot read session %v: %w", sess, err)
+	}
+
+	info, err := sess.Info(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	result := record{
+		State: info.State(),
+	}
+	if v, err := info.Created(ctx); err != nil {
+		return fail(err)
+	} else if v != nil {
+		result.Created = recordAccessOf(v.At(), v.Remote())
+	}
+	if v, err := info.LastAccessed(ctx); err != nil {
+		return fail(err)
+	} else if v != nil {
+		result.LastAccessed = recordAccessOf(v.At(), v.Remote())
+	}
+	if result.AuthorizationToken, err = sess.AuthorizationToken(ctx); err != nil {
+		return fail(err)
+	}
+	if result.EnvironmentToken, err = sess.EnvironmentToken(ctx); err != nil {
+		return fail(err)
+	}
+	pubs, err := sess.PublicKeys(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	for _, pub := range pubs {
+		result.addPublicKey(pub)
+	}
+	if result.Tags, err = info.Tags(ctx); err != nil {
+		return fail(err)
+	}
+	if result.Notes, err = info.Notes(ctx); err != nil {
+		return fail(err)
+	}
+	return &result, nil
+}
+
+func (this *record) clone() *record {
+	result := *this
+	result.AuthorizationToken = slices.Clone(this.AuthorizationToken)
+	result.EnvironmentToken = slices.Clone(this.EnvironmentToken)
+	result.PublicKeys = make([][]byte, len(this.PublicKeys))
+	for i, v := range this.PublicKeys {
+		result.PublicKeys[i] = slices.Clone(v)
+	}
+	result.Tags = maps.Clone(this.Tags)
+	result.Notes = slices.Clone(this.Notes)
+	return &result
+}
+
+// sealed returns a copy of this record with its tokens encrypted by the
+// given cipher, to be stored.
+func (this *record) sealed(c *tokenCipher) (*record, error) {
+	result := *this
+	var err error
+	if result.AuthorizationToken, err = c.seal(this.AuthorizationToken); err != nil {
+		return nil, err
+	}
+	if result.EnvironmentToken, err = c.seal(this.EnvironmentToken); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// open decrypts the tokens of this (stored) record in place using the given
+// cipher. outdated is true if at least one of the tokens is not encrypted
+// with the current key and the record should therefore be sealed again.
+func (this *record) open(c *tokenCipher) (outdated bool, err error) {
+	var authOutdated, envOutdated bool
+	if this.AuthorizationToken, authOutdated, err = c.open(this.AuthorizationToken); err != nil {
+		return false, err
+	}
+	if this.EnvironmentToken, envOutdated, err = c.open(this.EnvironmentToken); err != nil {
+		return false, err
+	}
+	return authOutdated || envOutdated, nil
+}
+
+func (this *record) hasPublicKey(pub ssh.PublicKey) bool {
+	return this.indexOfPublicKey(pub) >= 0
+}
+
+func (this *record) indexOfPublicKey(pub ssh.PublicKey) int {
+	raw := pub.Marshal()
+	return slices.IndexFunc(this.PublicKeys, func(candidate []byte) bool {
+		return bytes.Equal(candidate, raw)
+	})
+}
+
+func (this *record) addPublicKey(pub ssh.PublicKey) {
+	if this.hasPublicKey(pub) {
+		// We do not add this more than one time...
+		return
+	}
+	this.PublicKeys = append(this.PublicKeys, pub.Marshal())
+}
+
+func (this *record) deletePublicKey(pub ssh.PublicKey) {
+	if i := this.indexOfPublicKey(pub); i >= 0 {
+		this.PublicKeys = slices.Delete(this.PublicKeys, i, i+1)
+	}
+}
+
+func (this *record) publicKeys() ([]ssh.PublicKey, error) {
+	result := make([]ssh.PublicKey, len(this.PublicKeys))
+	for i, raw := range this.PublicKeys {
+		pub, err := ssh.ParsePublicKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse public key #%d: %w", i, err)
+		}
+		result[i] = pub
+	}
+	return result, nil
+}
+
+func (this *record) addTags(tags Tags) {
+	if len(tags) == 0 {
+		return
+	}
+	if this.Tags == nil {
+		this.Tags = make(Tags, len(tags))
+	}
+	maps.Copy(this.Tags, tags)
+}
+
+func (this *record) notifyLastAccess(remote net.Remote, newState State) (oldState State) {
+	this.LastAccessed = recordAccessOf(time.Now().Truncate(time.Millisecond), remote)
+	oldState = this.State
+	if newState != StateUnchanged {
+		this.State = newState
+	}
+	return oldState
+}
+
+// dispose marks this record as disposed and returns true if it was already
+// disposed before.
+func (this *record) dispose() (alreadyDisposed bool) {
+	if this.State == StateDisposed {
+		return true
+	}
+	this.State = StateDisposed
+	return false
+}
+
+func (this *record) validUntil(idleTimeout, maxTimeout time.Duration) (result time.Time) {
+	if idleTimeout > 0 {
+		result = this.LastAccessed.VAt.Add(idleTimeout)
+	}
+	if maxTimeout > 0 {
+		byMax := this.Created.VAt.Add(maxTimeout)
+		if result.IsZero() || byMax.Before(result) {
+			result = byMax
+		}
+	}
+	return result
+}
+
+// publicKeyHash is used to index a record by its public keys.
+func publicKeyHash(pub ssh.PublicKey) []byte {
+	return hashOf(pub.Marshal())
+}
+
+// hashOf is used to index a record by values which should not be stored as
+// they are, like tokens.
+func hashOf(v []byte) []byte {
+	result := sha256.Sum256(v)
+	return result[:]
+}
+
+// recordRefOf creates the reference of a session as it is used inside indexes.
+func recordRefOf(flow configuration.FlowName, id Id) string {
+	return flow.String() + "/" + id.String()
+}
+
+func parseRecordRef(ref []byte) (flow configuration.FlowName, id Id, _ error) {
+	rawFlow, rawId, ok := strings.Cut(string(ref), "/")
+	if !ok {
+		return flow, id, fmt.Errorf("illegal session reference: %q", string(ref))
+	}
+	if err := flow.UnmarshalText([]byte(rawFlow)); err != nil {
+		return flow, id, err
+	}
+	if err := id.UnmarshalText([]byte(rawId)); err != nil {
+		return flow, id, err
+	}
+	return flow, id, nil
+}
+
+type recordAccess struct {
+	VAt         time.Time `json:"at"`
+	VRemoteUser string    `json:"remoteUser"`
+	VRemoteHost net.Host  `json:"remoteHost"`
+}
+
+func recordAccessOf(at time.Time, remote net.Remote) recordAccess {
+	result := recordAccess{VAt: at}
+	if remote != nil {
+		result.VRemoteUser = remote.User()
+		result.VRemoteHost = remote.Host()
+	}
+	return result
+}
+
+func (this *recordAccess) At() time.Time {
+	return this.VAt
+}
+
+func (this *recordAccess) Remote() net.Remote {
+	return &recordRemote{this}
+}
+
+func (this *recordAccess) GetField(name string) (any, bool, error) {
+	switch name {
+	case "at":
+		return this.At(), true, nil
+	case "remote":
+		return this.Remote(), true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
+
+type recordRemote struct {
+	access *recordAccess
+}
+
+func (this *recordRemote) String() string {
+	return this.User() + "@" + this.Host().String()
+}
+
+func (this *recordRemote) User() string {
+	return strings.Clone(this.access.VRemoteUser)
+}
+
+func (this *recordRemote) Host() net.Host {
+	return this.access.VRemoteHost.Clone()
+}
+
+func (this *recordRemote) GetField(name string) (any, bool, error) {
+	switch name {
+	case "user":
+		return this.User(), true, nil
+	case "host":
+		return this.Host(), true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
+
+// recordInfo is the Info of a Session based on a snapshot of its record.
+type recordInfo struct {
+	flow        configuration.FlowName
+	id          Id
+	record      *record
+	idleTimeout time.Duration
+	maxTimeout  time.Duration
+}
+
+func (this *recordInfo) GetField(name string, _ contextEnabled) (any, bool, error) {
+	switch name {
+	case "flow":
+		return this.Flow(), true, nil
+	case "id":
+		return this.Id(), true, nil
+	case "state":
+		return this.State(), true, nil
+	case "created":
+		return &this.record.Created, true, nil
+	case "lastAccessed":
+		return &this.record.LastAccessed, true, nil
+	case "validUntil":
+		v := this.record.validUntil(this.idleTimeout, this.maxTimeout)
+		if v.IsZero() {
+			return nil, true, nil
+		}
+		return v, true, nil
+	case "tags":
+		return this.record.Tags, true, nil
+	case "notes":
+		return this.record.Notes, true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
+
+func (this *recordInfo) Flow() configuration.FlowName {
+	return this.flow
+}
+
+func (this *recordInfo) Id() Id {
+	return this.id
+}
+
+func (this *recordInfo) State() State {
+	return this.record.State
+}
+
+func (this *recordInfo) Created(context.Context) (InfoCreated, error) {
+	return &this.record.Created, nil
+}
+
+func (this *recordInfo) LastAccessed(context.Context) (InfoLastAccessed, error) {
+	return &this.record.LastAccessed, nil
+}
+
+func (this *recordInfo) ValidUntil(context.Context) (time.Time, error) {
+	return this.record.validUntil(this.idleTimeout, this.maxTimeout), nil
+}
+
+func (this *recordInfo) Tags(context.Context) (Tags, error) {
+	return maps.Clone(this.record.Tags), nil
+}
+
+func (this *recordInfo) Notes(context.Context) ([]Note, error) {
+	return slices.Clone(this.record.Notes), nil
+}
+
+func (this *recordInfo) String() string {
+	return this.flow.String() + "/" + this.id.String()
+}
@@ -0,0 +1,700 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/echocat/slf4g"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+const (
+	// redisMaxAttempts is how often a modification of a session is tried,
+	// if it was modified concurrently by another process.
+	redisMaxAttempts = 20
+
+	// redisRetryDelay is the base of the random delay between two attempts
+	// of a modification, which grows with each attempt.
+	redisRetryDelay = 2 * time.Millisecond
+)
+
+var (
+	// redisAcquireConnection registers a connection of a session, if the
+	// maximum amount of connections (across all processes) is not exceeded,
+	// yet. Expired registrations of gone processes are removed first.
+	redisAcquireConnection = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+local max = tonumber(ARGV[3])
+if max > 0 and redis.call("ZCARD", KEYS[1]) >= max then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+return 1
+`)
+
+	// redisRenewLeader extends the leadership if it is still held by the
+	// calling process.
+	redisRenewLeader = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+	// redisReleaseLeader gives up the leadership if it is still held by the
+	// calling process.
+	redisReleaseLeader = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+	errRedisBrokenRecord = errors.System.Newf("broken record")
+
+	_ = RegisterRepository(NewRedisRepository)
+)
+
+func NewRedisRepository(ctx context.Context, conf *configuration.SessionRedis) (*RedisRepository, error) {
+	fail := func(err error) (*RedisRepository, error) {
+		return nil, errors.System.Newf("cannot connect to session server: %w", err)
+	}
+
+	cipher, err := newTokenCipher(&conf.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := redis.ParseURL(conf.Url)
+	if err != nil {
+		return fail(errors.Config.Newf("illegal url: %w", err))
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return fail(err)
+	}
+
+	result := RedisRepository{
+		conf:    conf,
+		client:  client,
+		cipher:  cipher,
+		replica: uuid.NewString(),
+		leases:  make(map[*redisConnectionLease]struct{}),
+		done:    make(chan struct{}),
+	}
+	result.interceptors = newConnectionInterceptors(conf.IdleTimeout.Native(), conf.MaxTimeout.Native(), conf.MaxConnections, result.touch)
+	result.interceptors.lease = result.leaseConnection
+
+	result.heartbeat.Add(1)
+	go result.runHeartbeat()
+
+	return &result, nil
+}
+
+// RedisRepository is a Repository which stores all sessions inside a server
+// speaking the Redis protocol, which can be shared by several processes. Each
+// Session is stored as a record which is always modified as a whole using
+// optimistic locking. Sessions are indexed by their public keys and
+// authorization token.
+//
+// Connections of sessions are registered at the server, too. This enables
+// to enforce configuration.SessionRedis.MaxConnections across all processes.
+// Only one of the processes (the leader) is allowed to dispose sessions while
+// housekeeping (see Elector).
+type RedisRepository struct {
+	Logger log.Logger
+
+	conf    *configuration.SessionRedis
+	client  *redis.Client
+	cipher  *tokenCipher
+	replica string
+
+	interceptors connectionInterceptors
+
+	leases      map[*redisConnectionLease]struct{}
+	leasesMutex sync.Mutex
+	leader      atomic.Bool
+
+	done      chan struct{}
+	heartbeat sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func (this *RedisRepository) Create(ctx context.Context, flow configuration.FlowName, remote net.Remote, authToken []byte) (Session, error) {
+	fail := func(err error) (Session, error) {
+		return nil, fmt.Errorf("cannot create session for user %v at flow %v: %w", remote, flow, err)
+	}
+
+	vid, err := uuid.NewUUID()
+	if err != nil {
+		return fail(err)
+	}
+	id := Id(vid)
+
+	r := newRecord(remote, authToken)
+	if _, err := this.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		return this.put(ctx, p, flow, id, r, nil)
+	}); err != nil {
+		return fail(err)
+	}
+
+	return this.session(flow, id, r), nil
+}
+
+func (this *RedisRepository) Import(ctx context.Context, from Session) (Session, error) {
+	fail := func(err error) (Session, error) {
+		return nil, fmt.Errorf("cannot import session %v: %w", from, err)
+	}
+
+	r, err := recordOf(ctx, from)
+	if err != nil {
+		return fail(err)
+	}
+	flow, id := from.Flow(), from.Id()
+	if err := this.transact(ctx, flow, id, func(*record) (*record, error) {
+		return r, nil
+	}); err != nil {
+		return fail(err)
+	}
+
+	return this.session(flow, id, r), nil
+}
+
+func (this *RedisRepository) FindBy(ctx context.Context, flow configuration.FlowName, id Id, opts *FindOpts) (Session, error) {
+	r, err := this.view(ctx, flow, id)
+	if err != nil {
+		return nil, this.onFindFailed(ctx, flow, id, opts, err)
+	}
+	return this.matching(ctx, flow, id, r, opts)
+}
+
+func (this *RedisRepository) FindByPublicKey(ctx context.Context, pub ssh.PublicKey, opts *FindOpts) (Session, error) {
+	result, err := this.findByIndex(ctx, this.publicKeyKey(publicKeyHash(pub)), func(r *record) bool {
+		return r.hasPublicKey(pub)
+	}, opts)
+	if err != nil && !errors.Is(err, ErrNoSuchSession) {
+		return nil, fmt.Errorf("cannot find session for public key: %w", err)
+	}
+	return result, err
+}
+
+func (this *RedisRepository) FindByAccessToken(ctx context.Context, t []byte, opts *FindOpts) (Session, error) {
+	result, err := this.findByIndex(ctx, this.accessTokenKey(hashOf(t)), func(r *record) bool {
+		return bytes.Equal(r.AuthorizationToken, t)
+	}, opts)
+	if err != nil && !errors.Is(err, ErrNoSuchSession) {
+		return nil, fmt.Errorf("cannot find session for access token: %w", err)
+	}
+	return result, err
+}
+
+func (this *RedisRepository) findByIndex(ctx context.Context, index string, matches func(*record) bool, opts *FindOpts) (Session, error) {
+	refs, err := this.client.SMembers(ctx, index).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(refs)
+
+	for _, ref := range refs {
+		flow, id, err := parseRecordRef([]byte(ref))
+		if err != nil {
+			// Broken index entries are ignored.
+			continue
+		}
+		r, err := this.view(ctx, flow, id)
+		if errors.Is(err, ErrNoSuchSession) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !matches(r) {
+			continue
+		}
+		result, err := this.matching(ctx, flow, id, r, opts)
+		if errors.Is(err, ErrNoSuchSession) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	return nil, ErrNoSuchSession
+}
+
+func (this *RedisRepository) FindAll(ctx context.Context, consumer Consumer, opts *FindOpts) error {
+	refs, err := this.client.SMembers(ctx, this.sessionsKey()).Result()
+	if err != nil {
+		return err
+	}
+	slices.Sort(refs)
+
+	for _, ref := range refs {
+		flow, id, err := parseRecordRef([]byte(ref))
+		if err != nil {
+			// We ignore entries which does not match a session reference.
+			continue
+		}
+		candidate, err := this.FindBy(ctx, flow, id, opts)
+		if errors.Is(err, ErrNoSuchSession) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		canContinue, err := consumer(ctx, candidate)
+		if err != nil {
+			return err
+		}
+		if !canContinue {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (this *RedisRepository) DeleteBy(ctx context.Context, flow configuration.FlowName, id Id) error {
+	// Also tell all active connection that we do no longer like them ;-)
+	this.interceptors.dispose(flow, id)
+
+	if err := this.transact(ctx, flow, id, func(*record) (*record, error) {
+		return nil, nil
+	}); err != nil {
+		return fmt.Errorf("cannot delete session %v/%v: %w", flow, id, err)
+	}
+	return nil
+}
+
+func (this *RedisRepository) Delete(ctx context.Context, s Session) error {
+	if s == nil {
+		return nil
+	}
+	switch v := s.(type) {
+	case *recordSession:
+		return this.DeleteBy(ctx, v.flow, v.id)
+	default:
+		return fmt.Errorf("unknown session type: %T", v)
+	}
+}
+
+// IsLeader implements Elector.
+func (this *RedisRepository) IsLeader(ctx context.Context) (bool, error) {
+	if this.leader.Load() {
+		return this.renewLeader(ctx)
+	}
+	ok, err := this.client.SetNX(ctx, this.leaderKey(), this.replica, this.conf.LeaseTimeout.Native()).Result()
+	if err != nil {
+		return false, fmt.Errorf("cannot elect leader: %w", err)
+	}
+	this.leader.Store(ok)
+	return ok, nil
+}
+
+func (this *RedisRepository) renewLeader(ctx context.Context) (bool, error) {
+	n, err := redisRenewLeader.Run(ctx, this.client, []string{this.leaderKey()}, this.replica, this.conf.LeaseTimeout.Native().Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("cannot renew leadership: %w", err)
+	}
+	ok := n > 0
+	this.leader.Store(ok)
+	return ok, nil
+}
+
+func (this *RedisRepository) Close() (rErr error) {
+	this.closeOnce.Do(func() {
+		close(this.done)
+		this.heartbeat.Wait()
+
+		ctx := context.Background()
+		if this.leader.Swap(false) {
+			if err := redisReleaseLeader.Run(ctx, this.client, []string{this.leaderKey()}, this.replica).Err(); err != nil {
+				rErr = errors.Join(rErr, err)
+			}
+		}
+		if _, err := this.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			this.leasesMutex.Lock()
+			defer this.leasesMutex.Unlock()
+			for lease := range this.leases {
+				p.ZRem(ctx, lease.key, lease.member)
+			}
+			clear(this.leases)
+			return nil
+		}); err != nil {
+			rErr = errors.Join(rErr, err)
+		}
+		if err := this.client.Close(); err != nil {
+			rErr = errors.Join(rErr, err)
+		}
+	})
+	return rErr
+}
+
+// runHeartbeat renews all leases of this process regularly, until the
+// repository is closed.
+func (this *RedisRepository) runHeartbeat() {
+	defer this.heartbeat.Done()
+
+	t := time.NewTicker(this.conf.LeaseTimeout.Native() / 3)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-this.done:
+			return
+		case <-t.C:
+			if err := this.renewLeases(context.Background()); err != nil {
+				this.logger().
+					WithError(err).
+					Warn("cannot renew leases of session server; will try again later")
+			}
+		}
+	}
+}
+
+func (this *RedisRepository) renewLeases(ctx context.Context) error {
+	if this.leader.Load() {
+		if _, err := this.renewLeader(ctx); err != nil {
+			return err
+		}
+	}
+
+	lt := this.conf.LeaseTimeout.Native()
+	expiresAt := time.Now().Add(lt).UnixMilli()
+	_, err := this.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		this.leasesMutex.Lock()
+		defer this.leasesMutex.Unlock()
+		for lease := range this.leases {
+			p.ZAddXX(ctx, lease.key, redis.Z{Score: float64(expiresAt), Member: lease.member})
+			p.PExpire(ctx, lease.key, lt)
+		}
+		return nil
+	})
+	return err
+}
+
+func (this *RedisRepository) leaseConnection(flow configuration.FlowName, id Id) (io.Closer, error) {
+	ctx := context.Background()
+	lease := &redisConnectionLease{
+		repository: this,
+		key:        this.connectionsKey(flow, id),
+		member:     this.replica + "/" + uuid.NewString(),
+	}
+	lt := this.conf.LeaseTimeout.Native()
+	now := time.Now()
+
+	n, err := redisAcquireConnection.Run(ctx, this.client, []string{lease.key},
+		now.UnixMilli(),
+		now.Add(lt).UnixMilli(),
+		this.conf.MaxConnections,
+		lease.member,
+		lt.Milliseconds(),
+	).Int()
+	if err != nil {
+		return nil, fmt.Errorf("cannot register connection of session %v/%v: %w", flow, id, err)
+	}
+	if n == 0 {
+		return nil, ErrMaxConnectionsPerSessionReached
+	}
+
+	this.leasesMutex.Lock()
+	defer this.leasesMutex.Unlock()
+	this.leases[lease] = struct{}{}
+	return lease, nil
+}
+
+func (this *RedisRepository) session(flow configuration.FlowName, id Id, r *record) *recordSession {
+	return &recordSession{
+		store:   this,
+		flow:    flow,
+		id:      id,
+		created: r.Created.VAt,
+	}
+}
+
+func (this *RedisRepository) info(flow configuration.FlowName, id Id, r *record) *recordInfo {
+	return &recordInfo{
+		flow:        flow,
+		id:          id,
+		record:      r,
+		idleTimeout: this.conf.IdleTimeout.Native(),
+		maxTimeout:  this.conf.MaxTimeout.Native(),
+	}
+}
+
+func (this *RedisRepository) matching(ctx context.Context, flow configuration.FlowName, id Id, r *record, opts *FindOpts) (Session, error) {
+	result := this.session(flow, id, r)
+	if ok, err := opts.GetPredicates().Matches(ctx, result); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNoSuchSession
+	}
+	return result, nil
+}
+
+func (this *RedisRepository) onFindFailed(ctx context.Context, flow configuration.FlowName, id Id, opts *FindOpts, err error) error {
+	if errors.Is(err, ErrNoSuchSession) || !opts.IsAutoCleanUpAllowed() {
+		return err
+	}
+
+	logger := opts.GetLogger(this.logger).
+		Withf("session", "%v/%v", flow, id)
+	if dErr := this.DeleteBy(ctx, flow, id); dErr != nil {
+		logger.
+			WithError(dErr).
+			Error("cannot clean up session automatically; this is really a problem because it could lead to this error shown up repeatedly and a system which gets stuck")
+	} else {
+		logger.
+			WithError(err).
+			Warn("found broken session; it was removed entirely")
+	}
+	return err
+}
+
+// transact loads the record of the given session (nil if it does not exist
+// or is broken) and replaces it with the result of modifier (deletes it if
+// nil). If the session was modified by someone else in the meantime,
+// everything is retried.
+func (this *RedisRepository) transact(ctx context.Context, flow configuration.FlowName, id Id, modifier func(existing *record) (*record, error)) error {
+	key := this.sessionKey(flow, id)
+	for attempt := range redisMaxAttempts {
+		if attempt > 0 {
+			// Concurrent modifiers should not run into each other, again.
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(rand.N(redisRetryDelay * time.Duration(attempt))):
+			}
+		}
+		err := this.client.Watch(ctx, func(tx *redis.Tx) error {
+			existing, err := this.get(ctx, tx, flow, id)
+			if errors.Is(err, ErrNoSuchSession) || errors.Is(err, errRedisBrokenRecord) {
+				// A broken record is replaced anyway. Its dangling index
+				// entries are ignored while searching.
+				existing = nil
+			} else if err != nil {
+				return err
+			}
+			modified, err := modifier(existing)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				if modified == nil {
+					this.delete(ctx, p, flow, id, existing)
+					return nil
+				}
+				return this.put(ctx, p, flow, id, modified, existing)
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return errors.System.Newf("session %v/%v was modified concurrently too often; gave up after %d attempts", flow, id, redisMaxAttempts)
+}
+
+// update loads the record of the given session, modifies it and stores it
+// again atomically.
+func (this *RedisRepository) update(ctx context.Context, flow configuration.FlowName, id Id, modifier func(*record) error) error {
+	return this.transact(ctx, flow, id, func(existing *record) (*record, error) {
+		if existing == nil {
+			return nil, ErrNoSuchSession
+		}
+		// The existing record is also used to clean up the indexes, therefore it must not be modified.
+		modified := existing.clone()
+		if err := modifier(modified); err != nil {
+			return nil, err
+		}
+		return modified, nil
+	})
+}
+
+// view loads the record of the given session. If its tokens are not
+// encrypted with the current key (anymore), they are encrypted again.
+func (this *RedisRepository) view(ctx context.Context, flow configuration.FlowName, id Id) (*record, error) {
+	result, outdated, err := this.getChecked(ctx, this.client, flow, id)
+	if err == nil && outdated {
+		this.reseal(ctx, flow, id)
+	}
+	return result, err
+}
+
+// reseal stores the record of the given session again, which encrypts its
+// tokens with the current key.
+func (this *RedisRepository) reseal(ctx context.Context, flow configuration.FlowName, id Id) {
+	err := this.update(ctx, flow, id, func(*record) error {
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrNoSuchSession) {
+		this.logger().
+			WithError(err).
+			Withf("session", "%v/%v", flow, id).
+			Warn("cannot re-encrypt session's tokens; will try again on next access")
+	}
+}
+
+func (this *RedisRepository) touch(flow configuration.FlowName, id Id, at time.Time) error {
+	err := this.update(context.Background(), flow, id, func(r *record) error {
+		if r.State == StateDisposed {
+			return errSessionDisposed
+		}
+		r.LastAccessed.VAt = at.Truncate(time.Millisecond)
+		return nil
+	})
+	if errors.Is(err, ErrNoSuchSession) || errors.Is(err, errSessionDisposed) {
+		// Disposed or deleted by another process.
+		return errSessionDisposed
+	}
+	if err != nil {
+		return fmt.Errorf("cannot update last accessed time of session %v/%v: %w", flow, id, err)
+	}
+	return nil
+}
+
+func (this *RedisRepository) get(ctx context.Context, c redis.Cmdable, flow configuration.FlowName, id Id) (*record, error) {
+	result, _, err := this.getChecked(ctx, c, flow, id)
+	return result, err
+}
+
+// getChecked is like get, but also reports if the tokens of the record are
+// outdated (see record.open).
+func (this *RedisRepository) getChecked(ctx context.Context, c redis.Cmdable, flow configuration.FlowName, id Id) (_ *record, outdated bool, _ error) {
+	raw, err := c.Get(ctx, this.sessionKey(flow, id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, ErrNoSuchSession
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var result record
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, false, errors.System.Newf("cannot decode session %v/%v: %w: %w", flow, id, errRedisBrokenRecord, err)
+	}
+	if outdated, err = result.open(this.cipher); err != nil {
+		return nil, false, fmt.Errorf("cannot decrypt tokens of session %v/%v: %w", flow, id, err)
+	}
+	return &result, outdated, nil
+}
+
+// put stores the given record and updates the indexes. If there was a
+// previous version, it has to be provided to remove its index entries.
+func (this *RedisRepository) put(ctx context.Context, p redis.Pipeliner, flow configuration.FlowName, id Id, r *record, previous *record) error {
+	sealed, err := r.sealed(this.cipher)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt tokens of session %v/%v: %w", flow, id, err)
+	}
+	raw, err := json.Marshal(sealed)
+	if err != nil {
+		return errors.System.Newf("cannot encode session %v/%v: %w", flow, id, err)
+	}
+	ref := recordRefOf(flow, id)
+	p.Set(ctx, this.sessionKey(flow, id), raw, 0)
+	p.SAdd(ctx, this.sessionsKey(), ref)
+
+	if previous != nil {
+		this.deleteIndexes(ctx, p, ref, previous)
+	}
+	if len(r.AuthorizationToken) > 0 {
+		p.SAdd(ctx, this.accessTokenKey(hashOf(r.AuthorizationToken)), ref)
+	}
+	for _, pub := range r.PublicKeys {
+		p.SAdd(ctx, this.publicKeyKey(hashOf(pub)), ref)
+	}
+	return nil
+}
+
+func (this *RedisRepository) delete(ctx context.Context, p redis.Pipeliner, flow configuration.FlowName, id Id, existing *record) {
+	ref := recordRefOf(flow, id)
+	p.Del(ctx, this.sessionKey(flow, id), this.connectionsKey(flow, id))
+	p.SRem(ctx, this.sessionsKey(), ref)
+	if existing != nil {
+		this.deleteIndexes(ctx, p, ref, existing)
+	}
+}
+
+func (this *RedisRepository) deleteIndexes(ctx context.Context, p redis.Pipeliner, ref string, r *record) {
+	if len(r.AuthorizationToken) > 0 {
+		p.SRem(ctx, this.accessTokenKey(hashOf(r.AuthorizationToken)), ref)
+	}
+	for _, pub := range r.PublicKeys {
+		p.SRem(ctx, this.publicKeyKey(hashOf(pub)), ref)
+	}
+}
+
+func (this *RedisRepository) sessionKey(flow configuration.FlowName, id Id) string {
+	return this.conf.KeyPrefix + "session:" + recordRefOf(flow, id)
+}
+
+func (this *RedisRepository) sessionsKey() string {
+	return this.conf.KeyPrefix + "sessions"
+}
+
+func (this *RedisRepository) publicKeyKey(hash []byte) string {
+	return this.conf.KeyPrefix + "publicKey:" + hex.EncodeToString(hash)
+}
+
+func (this *RedisRepository) accessTokenKey(hash []byte) string {
+	return this.conf.KeyPrefix + "accessToken:" + hex.EncodeToString(hash)
+}
+
+func (this *RedisRepository) connectionsKey(flow configuration.FlowName, id Id) string {
+	return this.conf.KeyPrefix + "connections:" + recordRefOf(flow, id)
+}
+
+func (this *RedisRepository) leaderKey() string {
+	return this.conf.KeyPrefix + "leader"
+}
+
+func (this *RedisRepository) connectionInterceptors() *connectionInterceptors {
+	return &this.interceptors
+}
+
+func (this *RedisRepository) logger() log.Logger {
+	if v := this.Logger; v != nil {
+		return v
+	}
+	return log.GetLogger("sessions")
+}
+
+type redisConnectionLease struct {
+	repository *RedisRepository
+	key        string
+	member     string
+}
+
+func (this *redisConnectionLease) Close() error {
+	this.repository.leasesMutex.Lock()
+	_, ok := this.repository.leases[this]
+	delete(this.repository.leases, this)
+	this.repository.leasesMutex.Unlock()
+	if !ok {
+		// Already released.
+		return nil
+	}
+
+	if err := this.repository.client.ZRem(context.Background(), this.key, this.member).Err(); err != nil {
+		return fmt.Errorf("cannot release connection: %w", err)
+	}
+	return nil
+}
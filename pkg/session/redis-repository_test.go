@@ -0,0 +1,202 @@
+package session
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+func TestRedisRepository(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	flow := configuration.FlowName("foo")
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	pub := newTestPublicKey(t)
+
+	a := newTestRedisRepository(t, server, 0)
+	b := newTestRedisRepository(t, server, 0)
+
+	sess, err := a.Create(ctx, flow, remote, []byte("token"))
+	require.NoError(t, err)
+	require.NoError(t, sess.AddPublicKey(ctx, pub))
+
+	actual, err := b.FindByPublicKey(ctx, pub, nil)
+	require.NoError(t, err)
+	require.Equal(t, sess.Id(), actual.Id())
+
+	actual, err = b.FindByAccessToken(ctx, []byte("token"), nil)
+	require.NoError(t, err)
+	require.Equal(t, sess.Id(), actual.Id())
+
+	var all []Id
+	require.NoError(t, b.FindAll(ctx, func(_ context.Context, candidate Session) (bool, error) {
+		all = append(all, candidate.Id())
+		return true, nil
+	}, nil))
+	require.Equal(t, []Id{sess.Id()}, all)
+
+	require.NoError(t, b.Delete(ctx, actual))
+	_, err = a.FindByPublicKey(ctx, pub, nil)
+	require.ErrorIs(t, err, ErrNoSuchSession)
+	_, err = a.FindBy(ctx, flow, sess.Id(), nil)
+	require.ErrorIs(t, err, ErrNoSuchSession)
+	require.Empty(t, server.Keys())
+}
+
+func TestRedisRepository_concurrentModifications(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+
+	a := newTestRedisRepository(t, server, 0)
+	b := newTestRedisRepository(t, server, 0)
+
+	sess, err := a.Create(ctx, "foo", remote, nil)
+	require.NoError(t, err)
+	other, err := b.FindBy(ctx, "foo", sess.Id(), nil)
+	require.NoError(t, err)
+
+	pubs := make([]ssh.PublicKey, 10)
+	for i := range pubs {
+		pubs[i] = newTestPublicKey(t)
+	}
+
+	var wg sync.WaitGroup
+	for i, pub := range pubs {
+		target := sess
+		if i%2 == 1 {
+			target = other
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, target.AddPublicKey(ctx, pub))
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := target.NotifyLastAccess(ctx, remote, StateAuthorized)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// No modification is allowed to get lost.
+	actual, err := sess.PublicKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, actual, len(pubs))
+	for _, pub := range pubs {
+		found, err := a.FindByPublicKey(ctx, pub, nil)
+		require.NoError(t, err)
+		require.Equal(t, sess.Id(), found.Id())
+	}
+}
+
+func TestRedisRepository_maxConnections(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+
+	a := newTestRedisRepository(t, server, 1)
+	b := newTestRedisRepository(t, server, 1)
+
+	sess, err := a.Create(ctx, "foo", remote, nil)
+	require.NoError(t, err)
+	other, err := b.FindBy(ctx, "foo", sess.Id(), nil)
+	require.NoError(t, err)
+
+	ci, err := sess.ConnectionInterceptor(ctx)
+	require.NoError(t, err)
+
+	_, err = other.ConnectionInterceptor(ctx)
+	require.ErrorIs(t, err, ErrMaxConnectionsPerSessionReached)
+
+	require.NoError(t, ci.Close())
+	ci, err = other.ConnectionInterceptor(ctx)
+	require.NoError(t, err)
+	require.NoError(t, ci.Close())
+
+	// Connections of gone instances are released after their lease timed out.
+	ci, err = sess.ConnectionInterceptor(ctx)
+	require.NoError(t, err)
+	_, err = other.ConnectionInterceptor(ctx)
+	require.ErrorIs(t, err, ErrMaxConnectionsPerSessionReached)
+	server.SetTime(time.Now().Add(time.Hour))
+	server.FastForward(time.Hour)
+	ci, err = other.ConnectionInterceptor(ctx)
+	require.NoError(t, err)
+	require.NoError(t, ci.Close())
+}
+
+func TestRedisRepository_IsLeader(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+
+	a := newTestRedisRepository(t, server, 0)
+	b := newTestRedisRepository(t, server, 0)
+
+	leader, err := a.IsLeader(ctx)
+	require.NoError(t, err)
+	require.True(t, leader)
+
+	leader, err = b.IsLeader(ctx)
+	require.NoError(t, err)
+	require.False(t, leader)
+
+	leader, err = a.IsLeader(ctx)
+	require.NoError(t, err)
+	require.True(t, leader)
+
+	require.NoError(t, a.Close())
+
+	leader, err = b.IsLeader(ctx)
+	require.NoError(t, err)
+	require.True(t, leader)
+}
+
+func TestRedisRepository_Import(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	pub := newTestPublicKey(t)
+
+	var sourceConf configuration.SessionFs
+	require.NoError(t, sourceConf.SetDefaults())
+	sourceConf.Storage = filepath.Join(t.TempDir(), "sessions")
+	source, err := NewFsRepository(ctx, &sourceConf)
+	require.NoError(t, err)
+
+	original, err := source.Create(ctx, "foo", remote, []byte("token"))
+	require.NoError(t, err)
+	require.NoError(t, original.AddPublicKey(ctx, pub))
+
+	instance := newTestRedisRepository(t, server, 0)
+	_, err = instance.Import(ctx, original)
+	require.NoError(t, err)
+
+	actual, err := instance.FindByPublicKey(ctx, pub, nil)
+	require.NoError(t, err)
+	require.Equal(t, original.Id(), actual.Id())
+}
+
+func newTestRedisRepository(t *testing.T, server *miniredis.Miniredis, maxConnections uint16) *RedisRepository {
+	var conf configuration.SessionRedis
+	require.NoError(t, conf.SetDefaults())
+	conf.Url = "redis://" + server.Addr()
+	conf.MaxConnections = maxConnections
+	conf.LeaseTimeout = common.DurationOf(time.Minute)
+
+	result, err := NewRedisRepository(context.Background(), &conf)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, result.Close()) })
+	return result
+}
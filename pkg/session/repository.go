@@ -14,6 +14,10 @@ import (
 
 var (
 	ErrNoSuchSession = errors.New("no such session")
+
+	// errSessionDisposed is used internally to report that a session was
+	// disposed by another process sharing the same Repository.
+	errSessionDisposed = errors.New("session disposed")
 )
 
 type Repository interface {
@@ -28,6 +32,22 @@ type Repository interface {
 	Delete(context.Context, Session) error
 }
 
+// Importer is implemented by Repository instances which are able to take
+// over sessions of other repositories including their ids, times, tokens and
+// public keys. An existing session with the same id will be replaced.
+type Importer interface {
+	Import(ctx context.Context, from Session) (Session, error)
+}
+
+// Elector is implemented by Repository instances which are shared between
+// several processes. Only the leader is allowed to dispose expired sessions
+// while housekeeping.
+type Elector interface {
+	// IsLeader tries to become (or stay) the leader and reports if this
+	// process is the leader right now.
+	IsLeader(ctx context.Context) (bool, error)
+}
+
 type CloseableRepository interface {
 	Repository
 	io.Closer
@@ -26,6 +26,9 @@ type Session interface {
 	EnvironmentToken(context.Context) ([]byte, error)
 	HasPublicKey(context.Context, ssh.PublicKey) (bool, error)
 
+	// PublicKeys returns all public keys which are currently bound to this Session.
+	PublicKeys(context.Context) ([]ssh.PublicKey, error)
+
 	// ConnectionInterceptor creates a new instance of ConnectionInterceptor to
 	// watch net.Conn of each connection related to this Session.
 	//
@@ -37,6 +40,14 @@ type Session interface {
 	SetEnvironmentToken(context.Context, []byte) error
 	AddPublicKey(context.Context, ssh.PublicKey) error
 	DeletePublicKey(context.Context, ssh.PublicKey) error
+
+	// AddTags attaches the given Tags to this Session. Existing tags with the
+	// same keys are replaced.
+	AddTags(context.Context, Tags) error
+
+	// AddNote adds the given Note to this Session.
+	AddNote(context.Context, Note) error
+
 	NotifyLastAccess(ctx context.Context, remote net.Remote, newState State) (oldState State, err error)
 	Dispose(ctx context.Context) (bool, error)
 
@@ -0,0 +1,81 @@
+package session
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+)
+
+// Tags are key/value pairs attached to a Session, like the number of a
+// ticket or the team of the user. They can be used to find sessions (see
+// HasTags).
+type Tags map[string]string
+
+// Set parses the given plain tag in format <key>=<value> and adds it to this
+// Tags. If the value is absent (format <key>) an empty value is added.
+func (this *Tags) Set(plain string) error {
+	k, v, _ := strings.Cut(plain, "=")
+	if err := configuration.ValidateSessionTagKey(k); err != nil {
+		return err
+	}
+	if *this == nil {
+		*this = Tags{}
+	}
+	(*this)[k] = v
+	return nil
+}
+
+func (this Tags) String() string {
+	keys := slices.Sorted(maps.Keys(this))
+	var buf strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(this[k])
+	}
+	return buf.String()
+}
+
+// Matches returns true if the given Tags contains all tags of this Tags. An
+// empty value matches every value of the tag with the same key.
+func (this Tags) Matches(candidate Tags) bool {
+	for k, v := range this {
+		cv, ok := candidate[k]
+		if !ok || (v != "" && v != cv) {
+			return false
+		}
+	}
+	return true
+}
+
+// Note is a free text attached to a Session, for example by its user to
+// describe what was done with it.
+type Note struct {
+	At     time.Time `json:"at"`
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
+}
+
+func (this Note) String() string {
+	return this.At.Format(time.RFC3339) + " " + this.Author + ": " + this.Text
+}
+
+func (this Note) GetField(name string) (any, bool, error) {
+	switch name {
+	case "at":
+		return this.At, true, nil
+	case "author":
+		return this.Author, true, nil
+	case "text":
+		return this.Text, true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown field %q", name)
+	}
+}
@@ -0,0 +1,241 @@
+package session
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+const (
+	// tokenCipherMinKeyMaterial is the minimum length of the material a key
+	// is derived from.
+	tokenCipherMinKeyMaterial = 16
+	tokenCipherKeyIdLength    = 8
+	tokenCipherDataKeyLength  = 32
+	tokenCipherKeyInfo        = "bifroest session token encryption"
+)
+
+var (
+	// tokenCipherMagic is the prefix of each encrypted token, to distinguish
+	// it from unencrypted ones, which were stored before encryption was
+	// enabled.
+	tokenCipherMagic = []byte("BFE\x01")
+)
+
+// newTokenCipher creates a tokenCipher for the given configuration. It is nil
+// if encryption is not enabled, which is also a valid tokenCipher.
+func newTokenCipher(conf *configuration.SessionEncryption) (*tokenCipher, error) {
+	if conf == nil || !conf.IsEnabled() {
+		return nil, nil
+	}
+
+	result := tokenCipher{
+		byId: make(map[string]*tokenKey),
+	}
+	if !conf.Key.IsZero() {
+		k, err := loadTokenKey(&conf.Key)
+		if err != nil {
+			return nil, err
+		}
+		result.current = k
+		result.byId[k.id] = k
+	}
+	for i := range conf.PreviousKeys {
+		k, err := loadTokenKey(&conf.PreviousKeys[i])
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := result.byId[k.id]; !exists {
+			result.byId[k.id] = k
+		}
+	}
+	return &result, nil
+}
+
+// tokenCipher encrypts tokens of sessions using envelope encryption: each
+// token is encrypted with a random data key, which is itself encrypted with
+// the current key. Tokens encrypted with previous keys can still be decrypted.
+type tokenCipher struct {
+	current *tokenKey
+	byId    map[string]*tokenKey
+}
+
+// seal encrypts the given plain token with the current key. Empty tokens stay
+// empty. If there is no current key, the token is returned unencrypted.
+func (this *tokenCipher) seal(plain []byte) ([]byte, error) {
+	if len(plain) == 0 || this == nil || this.current == nil {
+		return plain, nil
+	}
+
+	dataKey := make([]byte, tokenCipherDataKeyLength)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := gcmSeal(this.current.material, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := gcmSeal(dataKey, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	result := bytes.NewBuffer(make([]byte, 0, len(tokenCipherMagic)+tokenCipherKeyIdLength+len(wrapped)+len(encrypted)))
+	result.Write(tokenCipherMagic)
+	result.Write(this.current.rawId)
+	result.Write(wrapped)
+	result.Write(encrypted)
+	return result.Bytes(), nil
+}
+
+// open decrypts the given token. outdated is true if the token should be
+// sealed again, because it is not encrypted with the current key.
+func (this *tokenCipher) open(data []byte) (plain []byte, outdated bool, _ error) {
+	if len(data) == 0 {
+		return data, false, nil
+	}
+	if !bytes.HasPrefix(data, tokenCipherMagic) {
+		// Stored before encryption was enabled.
+		return data, this != nil && this.current != nil, nil
+	}
+
+	rest := data[len(tokenCipherMagic):]
+	if len(rest) < tokenCipherKeyIdLength {
+		return nil, false, errors.System.Newf("encrypted token is truncated")
+	}
+	id := hex.EncodeToString(rest[:tokenCipherKeyIdLength])
+	rest = rest[tokenCipherKeyIdLength:]
+
+	if this == nil {
+		return nil, false, errors.Config.Newf("token is encrypted with key %s, but no session encryption is configured", id)
+	}
+	k, ok := this.byId[id]
+	if !ok {
+		return nil, false, errors.Config.Newf("token is encrypted with key %s, which is neither the configured key nor one of the previous keys", id)
+	}
+
+	wrappedLength := gcmSealedLength(tokenCipherDataKeyLength)
+	if len(rest) < wrappedLength {
+		return nil, false, errors.System.Newf("encrypted token is truncated")
+	}
+	dataKey, err := gcmOpen(k.material, rest[:wrappedLength])
+	if err != nil {
+		return nil, false, errors.System.Newf("cannot decrypt data key of token with key %s: %w", id, err)
+	}
+	plain, err = gcmOpen(dataKey, rest[wrappedLength:])
+	if err != nil {
+		return nil, false, errors.System.Newf("cannot decrypt token with key %s: %w", id, err)
+	}
+	return plain, k != this.current, nil
+}
+
+type tokenKey struct {
+	id       string
+	rawId    []byte
+	material []byte
+}
+
+func loadTokenKey(conf *configuration.SessionEncryptionKey) (*tokenKey, error) {
+	fail := func(err error) (*tokenKey, error) {
+		return nil, errors.Config.Newf("cannot load session encryption key %v: %w", conf, err)
+	}
+
+	var source []byte
+	switch {
+	case conf.File != "":
+		raw, err := os.ReadFile(conf.File)
+		if err != nil {
+			return fail(err)
+		}
+		source = []byte(strings.TrimSpace(string(raw)))
+	case conf.Env != "":
+		v, ok := os.LookupEnv(conf.Env)
+		if !ok {
+			return fail(fmt.Errorf("environment variable %s is not set", conf.Env))
+		}
+		source = []byte(strings.TrimSpace(v))
+	case conf.HostKey != "":
+		raw, err := os.ReadFile(conf.HostKey)
+		if err != nil {
+			return fail(err)
+		}
+		pk, err := ssh.ParseRawPrivateKey(raw)
+		if err != nil {
+			return fail(err)
+		}
+		if v, ok := pk.(*ed25519.PrivateKey); ok {
+			pk = *v
+		}
+		if source, err = x509.MarshalPKCS8PrivateKey(pk); err != nil {
+			return fail(err)
+		}
+	default:
+		return fail(fmt.Errorf("no source configured"))
+	}
+
+	if len(source) < tokenCipherMinKeyMaterial {
+		return fail(fmt.Errorf("key material needs to be at least %d bytes long", tokenCipherMinKeyMaterial))
+	}
+
+	material, err := hkdf.Key(sha256.New, source, nil, tokenCipherKeyInfo, tokenCipherDataKeyLength)
+	if err != nil {
+		return fail(err)
+	}
+	hash := sha256.Sum256(material)
+	rawId := hash[:tokenCipherKeyIdLength]
+	return &tokenKey{
+		id:       hex.EncodeToString(rawId),
+		rawId:    rawId,
+		material: material,
+	}, nil
+}
+
+func gcmSealedLength(plainLength int) int {
+	// nonce + plain + tag
+	return 12 + plainLength + 16
+}
+
+func gcmSeal(key, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), gcmSealedLength(len(plain)))
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
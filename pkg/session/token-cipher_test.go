@@ -0,0 +1,264 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/engity-com/bifroest/pkg/configuration"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/net"
+)
+
+func TestTokenCipher(t *testing.T) {
+	t.Setenv("BIFROEST_TEST_KEY_A", "a-very-secret-key-for-testing")
+	t.Setenv("BIFROEST_TEST_KEY_B", "another-secret-key-for-testing")
+	plain := []byte("token")
+
+	a := newTestTokenCipher(t, "BIFROEST_TEST_KEY_A")
+	sealed, err := a.seal(plain)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(sealed, plain))
+
+	actual, outdated, err := a.open(sealed)
+	require.NoError(t, err)
+	require.False(t, outdated)
+	require.Equal(t, plain, actual)
+
+	// Unencrypted tokens are still readable, but should be encrypted.
+	actual, outdated, err = a.open(plain)
+	require.NoError(t, err)
+	require.True(t, outdated)
+	require.Equal(t, plain, actual)
+
+	// After rotation, the previous key is still able to decrypt.
+	b := newTestTokenCipher(t, "BIFROEST_TEST_KEY_B", "BIFROEST_TEST_KEY_A")
+	actual, outdated, err = b.open(sealed)
+	require.NoError(t, err)
+	require.True(t, outdated)
+	require.Equal(t, plain, actual)
+
+	// ...but not without it.
+	_, _, err = newTestTokenCipher(t, "BIFROEST_TEST_KEY_B").open(sealed)
+	require.True(t, errors.IsType(err, errors.Config))
+	_, _, err = (*tokenCipher)(nil).open(sealed)
+	require.True(t, errors.IsType(err, errors.Config))
+}
+
+func TestTokenCipher_missingKey(t *testing.T) {
+	_, err := newTokenCipher(&configuration.SessionEncryption{
+		Key: configuration.SessionEncryptionKey{Env: "BIFROEST_TEST_KEY_ABSENT"},
+	})
+	require.ErrorContains(t, err, "environment variable BIFROEST_TEST_KEY_ABSENT is not set")
+
+	_, err = newTokenCipher(&configuration.SessionEncryption{
+		Key: configuration.SessionEncryptionKey{File: filepath.Join(t.TempDir(), "absent")},
+	})
+	require.True(t, errors.IsType(err, errors.Config))
+}
+
+func TestFsRepository_encryption(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("BIFROEST_TEST_KEY_A", "a-very-secret-key-for-testing")
+	t.Setenv("BIFROEST_TEST_KEY_B", "another-secret-key-for-testing")
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	storage := t.TempDir()
+
+	newInstance := func(keys ...string) *FsRepository {
+		var conf configuration.SessionFs
+		require.NoError(t, conf.SetDefaults())
+		conf.Storage = storage
+		if len(keys) > 0 {
+			conf.Encryption.Key.Env = keys[0]
+			for _, k := range keys[1:] {
+				conf.Encryption.PreviousKeys = append(conf.Encryption.PreviousKeys, configuration.SessionEncryptionKey{Env: k})
+			}
+		}
+		result, err := NewFsRepository(ctx, &conf)
+		require.NoError(t, err)
+		return result
+	}
+	rawToken := func(sess Session) []byte {
+		fn, err := newInstance().file(sess.Flow(), sess.Id(), FsFileAccessToken)
+		require.NoError(t, err)
+		result, err := os.ReadFile(fn)
+		require.NoError(t, err)
+		return result
+	}
+
+	// Created without encryption...
+	sess, err := newInstance().Create(ctx, "foo", remote, []byte("token"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), rawToken(sess))
+
+	// ...encrypted on first access...
+	a := newInstance("BIFROEST_TEST_KEY_A")
+	actual, err := a.FindByAccessToken(ctx, []byte("token"), nil)
+	require.NoError(t, err)
+	at, err := actual.AuthorizationToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), at)
+	sealedByA := rawToken(sess)
+	require.True(t, bytes.HasPrefix(sealedByA, tokenCipherMagic))
+
+	// ...and again after the key was rotated.
+	b := newInstance("BIFROEST_TEST_KEY_B", "BIFROEST_TEST_KEY_A")
+	actual, err = b.FindByAccessToken(ctx, []byte("token"), nil)
+	require.NoError(t, err)
+	at, err = actual.AuthorizationToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), at)
+	require.NotEqual(t, sealedByA, rawToken(sess))
+
+	// Without the key, it fails clearly.
+	_, err = newInstance().FindByAccessToken(ctx, []byte("token"), nil)
+	require.True(t, errors.IsType(err, errors.Config))
+	_, err = a.FindByAccessToken(ctx, []byte("token"), nil)
+	require.True(t, errors.IsType(err, errors.Config))
+}
+
+func newTestTokenCipher(t *testing.T, env string, previousEnvs ...string) *tokenCipher {
+	conf := configuration.SessionEncryption{
+		Key: configuration.SessionEncryptionKey{Env: env},
+	}
+	for _, v := range previousEnvs {
+		conf.PreviousKeys = append(conf.PreviousKeys, configuration.SessionEncryptionKey{Env: v})
+	}
+	result, err := newTokenCipher(&conf)
+	require.NoError(t, err)
+	return result
+}
+
+func TestBoltRepository_encryption(t *testing.T) {
+	ctx := context.Background()
+	var conf configuration.SessionBolt
+	require.NoError(t, conf.SetDefaults())
+	conf.File = filepath.Join(t.TempDir(), "sessions.db")
+	instance, err := NewBoltRepository(ctx, &conf)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, instance.Close()) }()
+
+	testRecordStoreEncryption(t, instance, &instance.cipher, func(sess Session) []byte {
+		var result record
+		require.NoError(t, instance.db.View(func(tx *bbolt.Tx) error {
+			rawFlow, rawId, err := boltKeysOf(sess.Flow(), sess.Id())
+			require.NoError(t, err)
+			return json.Unmarshal(tx.Bucket(boltBucketSessions).Bucket(rawFlow).Get(rawId), &result)
+		}))
+		return result.AuthorizationToken
+	})
+}
+
+func TestRedisRepository_encryption(t *testing.T) {
+	server := miniredis.RunT(t)
+	instance := newTestRedisRepository(t, server, 0)
+
+	testRecordStoreEncryption(t, instance, &instance.cipher, func(sess Session) []byte {
+		raw, err := server.Get(instance.sessionKey(sess.Flow(), sess.Id()))
+		require.NoError(t, err)
+		var result record
+		require.NoError(t, json.Unmarshal([]byte(raw), &result))
+		return result.AuthorizationToken
+	})
+}
+
+// testRecordStoreEncryption ensures that the tokens of the given Repository
+// are encrypted again with the current key, once they are accessed. The key
+// is rotated by replacing the given cipher.
+func testRecordStoreEncryption(t *testing.T, instance Repository, cipher **tokenCipher, rawToken func(Session) []byte) {
+	ctx := context.Background()
+	t.Setenv("BIFROEST_TEST_KEY_A", "a-very-secret-key-for-testing")
+	t.Setenv("BIFROEST_TEST_KEY_B", "another-secret-key-for-testing")
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	accessToken := func() {
+		actual, err := instance.FindByAccessToken(ctx, []byte("token"), nil)
+		require.NoError(t, err)
+		at, err := actual.AuthorizationToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []byte("token"), at)
+	}
+
+	// Created without encryption...
+	*cipher = nil
+	sess, err := instance.Create(ctx, "foo", remote, []byte("token"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), rawToken(sess))
+
+	// ...encrypted on first access...
+	*cipher = newTestTokenCipher(t, "BIFROEST_TEST_KEY_A")
+	accessToken()
+	sealedByA := rawToken(sess)
+	require.True(t, bytes.HasPrefix(sealedByA, tokenCipherMagic))
+
+	// ...and again after the key was rotated...
+	*cipher = newTestTokenCipher(t, "BIFROEST_TEST_KEY_B", "BIFROEST_TEST_KEY_A")
+	accessToken()
+	sealedByB := rawToken(sess)
+	require.NotEqual(t, sealedByA, sealedByB)
+	_, outdated, err := (*cipher).open(sealedByB)
+	require.NoError(t, err)
+	require.False(t, outdated)
+
+	// ...which means the previous key is not required anymore.
+	*cipher = newTestTokenCipher(t, "BIFROEST_TEST_KEY_B")
+	accessToken()
+	require.Equal(t, sealedByB, rawToken(sess))
+}
+
+func TestMemoryRepository_encryption(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("BIFROEST_TEST_KEY_A", "a-very-secret-key-for-testing")
+	t.Setenv("BIFROEST_TEST_KEY_B", "another-secret-key-for-testing")
+	remote := testRemote{"user", net.MustNewHost("127.0.0.1")}
+	snapshot := filepath.Join(t.TempDir(), "snapshot.json")
+
+	newInstance := func(keys ...string) *MemoryRepository {
+		var conf configuration.SessionMemory
+		require.NoError(t, conf.SetDefaults())
+		conf.Snapshot = snapshot
+		if len(keys) > 0 {
+			conf.Encryption.Key.Env = keys[0]
+			for _, k := range keys[1:] {
+				conf.Encryption.PreviousKeys = append(conf.Encryption.PreviousKeys, configuration.SessionEncryptionKey{Env: k})
+			}
+		}
+		result, err := NewMemoryRepository(ctx, &conf)
+		require.NoError(t, err)
+		return result
+	}
+	rawToken := func(sess Session) []byte {
+		raw, err := os.ReadFile(snapshot)
+		require.NoError(t, err)
+		var buf memorySnapshot
+		require.NoError(t, json.Unmarshal(raw, &buf))
+		return buf.Sessions[sess.Flow()][sess.Id()].AuthorizationToken
+	}
+
+	a := newInstance("BIFROEST_TEST_KEY_A")
+	sess, err := a.Create(ctx, "foo", remote, []byte("token"))
+	require.NoError(t, err)
+	require.NoError(t, a.Close())
+	sealedByA := rawToken(sess)
+	require.True(t, bytes.HasPrefix(sealedByA, tokenCipherMagic))
+
+	// The snapshot is written again immediately after the key was rotated,
+	// without waiting for the repository to be closed...
+	b := newInstance("BIFROEST_TEST_KEY_B", "BIFROEST_TEST_KEY_A")
+	sealedByB := rawToken(sess)
+	require.NotEqual(t, sealedByA, sealedByB)
+	_, outdated, err := b.cipher.open(sealedByB)
+	require.NoError(t, err)
+	require.False(t, outdated)
+
+	// ...which means the previous key is not required anymore.
+	actual, err := newInstance("BIFROEST_TEST_KEY_B").FindByAccessToken(ctx, []byte("token"), nil)
+	require.NoError(t, err)
+	require.Equal(t, sess.Id(), actual.Id())
+}
@@ -1,11 +1,13 @@
 package ssh
 
 import (
+	"io"
 	gonet "net"
 
 	log "github.com/echocat/slf4g"
 	glssh "github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/engity-com/bifroest/pkg/common"
 	"github.com/engity-com/bifroest/pkg/sys"
@@ -21,6 +23,21 @@ func AgentRequested(sshSess glssh.Session) bool {
 	return glssh.AgentRequested(sshSess)
 }
 
+// AgentRequestedBy reports whether the client of the given ctx has requested
+// agent forwarding within any of its sessions.
+func AgentRequestedBy(ctx glssh.Context) bool {
+	return glssh.AgentRequested(&contextOnlySession{ctx: ctx})
+}
+
+type contextOnlySession struct {
+	glssh.Session
+	ctx glssh.Context
+}
+
+func (this *contextOnlySession) Context() glssh.Context {
+	return this.ctx
+}
+
 func ForwardAgentConnections(ln gonet.Listener, logger log.Logger, sshSess glssh.Session) {
 	ctx := sshSess.Context()
 	sshConn := ctx.Value(glssh.ContextKeyConn).(gossh.Conn)
@@ -36,19 +53,64 @@ func ForwardAgentConnections(ln gonet.Listener, logger log.Logger, sshSess glssh
 		}
 		go func(conn gonet.Conn) {
 			defer common.IgnoreCloseError(conn)
-			channel, reqs, err := sshConn.OpenChannel(authAgentChannelName, nil)
+			forwardToAgent(ctx, logger, sshConn, conn)
+		}(conn)
+	}
+}
+
+// ForwardUpstreamAgentConnections forwards agent channels which are opened
+// by the given upstream server to the agent which was forwarded by the
+// client of the given ctx. A channel is only accepted if allowed reports
+// true at the time it is opened; otherwise it is rejected. This prevents an
+// upstream server from using the client's agent if the client never
+// requested to forward it to this server.
+func ForwardUpstreamAgentConnections(ctx glssh.Context, logger log.Logger, upstream *gossh.Client, allowed func() bool) {
+	for nc := range upstream.HandleChannelOpen(authAgentChannelName) {
+		if !allowed() {
+			logger.Warnf("upstream server opened %s channel, but agent forwarding was not requested; rejecting...", authAgentChannelName)
+			_ = nc.Reject(gossh.Prohibited, "agent forwarding not requested")
+			continue
+		}
+		go func(nc gossh.NewChannel) {
+			channel, reqs, err := nc.Accept()
 			if err != nil {
 				logger.WithError(err).
-					Warnf("failed to open %s channel; rejecting...", authAgentChannelName)
+					Warnf("failed to accept upstream %s channel; rejecting...", authAgentChannelName)
 				return
 			}
 			defer common.IgnoreCloseError(channel)
 			go gossh.DiscardRequests(reqs)
-			if err := sys.FullDuplexCopy(ctx, conn, channel, &sys.FullDuplexCopyOpts{}); err != nil {
-				logger.WithError(err).
-					Warnf("failed to handle %s requests; closing...", authAgentChannelName)
-				return
-			}
-		}(conn)
+			sshConn := ctx.Value(glssh.ContextKeyConn).(gossh.Conn)
+			forwardToAgent(ctx, logger, sshConn, channel)
+		}(nc)
+	}
+}
+
+// OpenAgent opens a new channel to the agent which was forwarded by the
+// client of the given ctx. The returned io.Closer has to be closed if the
+// agent is no longer required.
+func OpenAgent(ctx glssh.Context) (agent.ExtendedAgent, io.Closer, error) {
+	sshConn := ctx.Value(glssh.ContextKeyConn).(gossh.Conn)
+	channel, reqs, err := sshConn.OpenChannel(authAgentChannelName, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	go gossh.DiscardRequests(reqs)
+	return agent.NewClient(channel), channel, nil
+}
+
+func forwardToAgent(ctx glssh.Context, logger log.Logger, sshConn gossh.Conn, conn io.ReadWriter) {
+	channel, reqs, err := sshConn.OpenChannel(authAgentChannelName, nil)
+	if err != nil {
+		logger.WithError(err).
+			Warnf("failed to open %s channel; rejecting...", authAgentChannelName)
+		return
+	}
+	defer common.IgnoreCloseError(channel)
+	go gossh.DiscardRequests(reqs)
+	if err := sys.FullDuplexCopy(ctx, conn, channel, &sys.FullDuplexCopyOpts{}); err != nil {
+		logger.WithError(err).
+			Warnf("failed to handle %s requests; closing...", authAgentChannelName)
+		return
 	}
 }
@@ -0,0 +1,47 @@
+package ssh
+
+import (
+	"io"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+var (
+	// ErrTerminalLineCanceled is returned by ReadTerminalLine if the user has
+	// pressed Ctrl+C or Ctrl+D.
+	ErrTerminalLineCanceled = errors.User.Newf("input canceled")
+)
+
+// ReadTerminalLine reads a line from the given terminal, which is in raw mode,
+// so the input needs to be echoed manually to w.
+func ReadTerminalLine(r io.Reader, w io.Writer) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		switch b[0] {
+		case '\r', '\n':
+			_, err := io.WriteString(w, "\r\n")
+			return string(line), err
+		case 0x7f, '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				if _, err := io.WriteString(w, "\b \b"); err != nil {
+					return "", err
+				}
+			}
+		case 0x03, 0x04:
+			_, _ = io.WriteString(w, "\r\n")
+			return "", ErrTerminalLineCanceled
+		default:
+			if b[0] >= 0x20 && b[0] < 0x7f {
+				line = append(line, b[0])
+				if _, err := w.Write(b); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+}
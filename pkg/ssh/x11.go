@@ -0,0 +1,342 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	gonet "net"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	log "github.com/echocat/slf4g"
+	glssh "github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/engity-com/bifroest/pkg/common"
+	"github.com/engity-com/bifroest/pkg/errors"
+	"github.com/engity-com/bifroest/pkg/sys"
+)
+
+const (
+	DisplayEnvName    = "DISPLAY"
+	XAuthorityEnvName = "XAUTHORITY"
+
+	// X11DisplayOffset is the first display number which is used for
+	// forwarded displays; same as the default of OpenSSH.
+	X11DisplayOffset = 10
+
+	x11RequestType = "x11-req"
+	x11ChannelName = "x11"
+
+	x11MaxDisplay = 1000
+	x11BasePort   = 6000
+
+	// xAuthorityFamilyWild matches every address of a host.
+	xAuthorityFamilyWild = 0xFFFF
+)
+
+var x11ContextKey = struct{ uint64 }{62012245}
+
+// X11Request is the payload of a x11-req request of a client, see RFC 4254
+// section 6.3.1.
+type X11Request struct {
+	SingleConnection bool
+	AuthProtocol     string
+	AuthCookie       string
+	ScreenNumber     uint32
+}
+
+// X11RequestCallback decides whether the given X11Request of the session of
+// the given ctx is accepted.
+type X11RequestCallback func(ctx glssh.Context, req X11Request) bool
+
+// X11Forwarding is an accepted X11Request of a session. The processes of the
+// session only get a generated cookie (see Cookie), which is replaced by the
+// real cookie of the client for each forwarded connection. This ensures that
+// the real cookie never leaves the client.
+type X11Forwarding struct {
+	request    X11Request
+	realCookie []byte
+	fakeCookie []byte
+}
+
+func newX11Forwarding(req X11Request) (*X11Forwarding, error) {
+	realCookie, err := hex.DecodeString(req.AuthCookie)
+	if err != nil {
+		return nil, errors.User.Newf("illegal X11 authentication cookie: %w", err)
+	}
+	if len(realCookie) == 0 {
+		return nil, errors.User.Newf("empty X11 authentication cookie")
+	}
+	fakeCookie := make([]byte, len(realCookie))
+	if _, err := rand.Read(fakeCookie); err != nil {
+		return nil, errors.System.Newf("cannot generate X11 authentication cookie: %w", err)
+	}
+	return &X11Forwarding{req, realCookie, fakeCookie}, nil
+}
+
+// AuthProtocol returns the X11 authentication protocol requested by the
+// client, usually MIT-MAGIC-COOKIE-1.
+func (this *X11Forwarding) AuthProtocol() string {
+	return this.request.AuthProtocol
+}
+
+// Cookie returns the generated cookie which has to be provided to the
+// processes of the session, see WriteXAuthority.
+func (this *X11Forwarding) Cookie() []byte {
+	return this.fakeCookie
+}
+
+// Display returns the value of the DISPLAY environment variable for the
+// given display number.
+func (this *X11Forwarding) Display(number uint16) string {
+	return fmt.Sprintf("localhost:%d.%d", number, this.request.ScreenNumber)
+}
+
+// X11Requested returns the X11Forwarding of the given session, if it was
+// requested by the client and accepted by the X11RequestCallback of
+// WithX11Forwarding. Otherwise, nil is returned.
+func X11Requested(sshSess glssh.Session) *X11Forwarding {
+	v, _ := sshSess.Context().Value(x11ContextKey).(*X11Forwarding)
+	return v
+}
+
+// WithX11Forwarding wraps the given newChan of a session, so that x11-req
+// requests are handled by the given callback. The session has to be created
+// with the returned context, which makes the accepted request available via
+// X11Requested.
+func WithX11Forwarding(ctx glssh.Context, newChan gossh.NewChannel, callback X11RequestCallback) (glssh.Context, gossh.NewChannel) {
+	sessCtx := &x11SessionContext{Context: ctx}
+	return sessCtx, &x11NewChannel{newChan, sessCtx, callback}
+}
+
+type x11SessionContext struct {
+	glssh.Context
+	forwarding atomic.Pointer[X11Forwarding]
+}
+
+func (this *x11SessionContext) Value(key any) any {
+	if key == x11ContextKey {
+		return this.forwarding.Load()
+	}
+	return this.Context.Value(key)
+}
+
+type x11NewChannel struct {
+	gossh.NewChannel
+	context  *x11SessionContext
+	callback X11RequestCallback
+}
+
+func (this *x11NewChannel) Accept() (gossh.Channel, <-chan *gossh.Request, error) {
+	channel, reqs, err := this.NewChannel.Accept()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filtered := make(chan *gossh.Request)
+	go func() {
+		defer close(filtered)
+		for req := range reqs {
+			if req.Type == x11RequestType {
+				_ = req.Reply(this.handle(req), nil)
+				continue
+			}
+			filtered <- req
+		}
+	}()
+
+	return channel, filtered, nil
+}
+
+func (this *x11NewChannel) handle(req *gossh.Request) bool {
+	if this.context.forwarding.Load() != nil {
+		return false
+	}
+
+	var payload X11Request
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		return false
+	}
+	if this.callback == nil || !this.callback(this.context, payload) {
+		return false
+	}
+
+	fwd, err := newX11Forwarding(payload)
+	if err != nil {
+		return false
+	}
+	this.context.forwarding.Store(fwd)
+	return true
+}
+
+// ListenX11Display listens on the first free X11 display of localhost,
+// starting at X11DisplayOffset. It returns the listener together with its
+// display number.
+func ListenX11Display() (gonet.Listener, uint16, error) {
+	for display := X11DisplayOffset; display < x11MaxDisplay; display++ {
+		ln, err := gonet.Listen("tcp", "127.0.0.1:"+strconv.Itoa(x11BasePort+display))
+		if err == nil {
+			return ln, uint16(display), nil
+		}
+	}
+	return nil, 0, errors.Network.Newf("no free X11 display available")
+}
+
+// WriteXAuthority writes an entry in the format of Xauthority files, which
+// grants access to the given display number of every host with the given
+// protocol and cookie.
+func WriteXAuthority(w io.Writer, display uint16, protocol string, cookie []byte) error {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint16(xAuthorityFamilyWild))
+	for _, field := range [][]byte{
+		nil,
+		[]byte(strconv.Itoa(int(display))),
+		[]byte(protocol),
+		cookie,
+	} {
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(field)))
+		buf.Write(field)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// NewXAuthorityFile creates a new temporary Xauthority file (see
+// WriteXAuthority), which is only readable by its owner. It is the
+// responsibility of the caller to remove it.
+func NewXAuthorityFile(display uint16, protocol string, cookie []byte) (_ string, rErr error) {
+	fail := func(err error) (string, error) {
+		return "", errors.System.Newf("cannot create Xauthority file: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "bifroest-xauth-*")
+	if err != nil {
+		return fail(err)
+	}
+	success := false
+	defer func() {
+		if !success {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	defer common.KeepCloseError(&rErr, f)
+
+	if err := WriteXAuthority(f, display, protocol, cookie); err != nil {
+		return fail(err)
+	}
+
+	success = true
+	return f.Name(), nil
+}
+
+// ForwardX11Connections opens a x11 channel to the client of the given
+// session for each connection accepted by ln, until ln is closed.
+func ForwardX11Connections(ln gonet.Listener, logger log.Logger, sshSess glssh.Session, fwd *X11Forwarding) {
+	ctx := sshSess.Context()
+	sshConn := ctx.Value(glssh.ContextKeyConn).(gossh.Conn)
+	for {
+		conn, err := ln.Accept()
+		if sys.IsClosedError(err) {
+			return
+		}
+		if err != nil {
+			logger.WithError(err).
+				Warnf("failed to listen for %s channel connections; closing...", x11ChannelName)
+			return
+		}
+		if fwd.request.SingleConnection {
+			_ = ln.Close()
+		}
+		go func(conn gonet.Conn) {
+			defer common.IgnoreCloseError(conn)
+			forwardToX11(ctx, logger, sshConn, conn, fwd)
+		}(conn)
+		if fwd.request.SingleConnection {
+			return
+		}
+	}
+}
+
+type x11ChannelData struct {
+	OriginatorAddress string
+	OriginatorPort    uint32
+}
+
+func forwardToX11(ctx glssh.Context, logger log.Logger, sshConn gossh.Conn, conn gonet.Conn, fwd *X11Forwarding) {
+	setup, err := fwd.replaceCookie(conn)
+	if err != nil {
+		logger.WithError(err).
+			Info("rejected X11 connection")
+		return
+	}
+
+	data := x11ChannelData{OriginatorAddress: "127.0.0.1"}
+	if v, ok := conn.RemoteAddr().(*gonet.TCPAddr); ok {
+		data.OriginatorAddress = v.IP.String()
+		data.OriginatorPort = uint32(v.Port)
+	}
+
+	channel, reqs, err := sshConn.OpenChannel(x11ChannelName, gossh.Marshal(&data))
+	if err != nil {
+		logger.WithError(err).
+			Warnf("failed to open %s channel; rejecting...", x11ChannelName)
+		return
+	}
+	defer common.IgnoreCloseError(channel)
+	go gossh.DiscardRequests(reqs)
+
+	if _, err := channel.Write(setup); err != nil {
+		logger.WithError(err).
+			Warnf("failed to write to %s channel; closing...", x11ChannelName)
+		return
+	}
+	if err := sys.FullDuplexCopy(ctx, conn, channel, &sys.FullDuplexCopyOpts{}); err != nil {
+		logger.WithError(err).
+			Warnf("failed to handle %s requests; closing...", x11ChannelName)
+		return
+	}
+}
+
+// replaceCookie reads the connection setup of a X11 client from r, ensures
+// it was authenticated with the generated cookie of this X11Forwarding and
+// returns it with the real cookie of the client.
+func (this *X11Forwarding) replaceCookie(r io.Reader) ([]byte, error) {
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, errors.Network.Newf("cannot read X11 connection setup: %w", err)
+	}
+
+	var order binary.ByteOrder
+	switch head[0] {
+	case 'B':
+		order = binary.BigEndian
+	case 'l':
+		order = binary.LittleEndian
+	default:
+		return nil, errors.Network.Newf("illegal byte order of X11 connection setup: %d", head[0])
+	}
+
+	padded := func(n uint16) int {
+		return (int(n) + 3) &^ 3
+	}
+	protocolLen, cookieLen := order.Uint16(head[6:8]), order.Uint16(head[8:10])
+	body := make([]byte, padded(protocolLen)+padded(cookieLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, errors.Network.Newf("cannot read X11 connection setup: %w", err)
+	}
+
+	protocol := body[:protocolLen]
+	cookie := body[padded(protocolLen) : padded(protocolLen)+int(cookieLen)]
+	if string(protocol) != this.request.AuthProtocol || subtle.ConstantTimeCompare(cookie, this.fakeCookie) != 1 {
+		return nil, errors.Permission.Newf("X11 connection uses wrong authentication")
+	}
+	copy(cookie, this.realCookie)
+
+	return append(head, body...), nil
+}
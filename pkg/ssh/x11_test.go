@@ -0,0 +1,66 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestX11Forwarding_replaceCookie(t *testing.T) {
+	fwd, err := newX11Forwarding(X11Request{AuthProtocol: "MIT-MAGIC-COOKIE-1", AuthCookie: "00112233445566778899aabbccddeeff"})
+	require.NoError(t, err)
+	require.Len(t, fwd.Cookie(), 16)
+	require.NotEqual(t, fwd.realCookie, fwd.Cookie())
+
+	setupOf := func(protocol string, cookie []byte) []byte {
+		result := []byte{'l', 0, 11, 0, 0, 0, byte(len(protocol)), 0, byte(len(cookie)), 0, 0, 0}
+		result = append(result, protocol...)
+		result = append(result, make([]byte, (4-len(protocol)%4)%4)...)
+		result = append(result, cookie...)
+		return result
+	}
+
+	cases := []struct {
+		name     string
+		given    []byte
+		expected []byte
+	}{{
+		name:     "generated-cookie",
+		given:    setupOf("MIT-MAGIC-COOKIE-1", fwd.Cookie()),
+		expected: setupOf("MIT-MAGIC-COOKIE-1", fwd.realCookie),
+	}, {
+		name:  "real-cookie",
+		given: setupOf("MIT-MAGIC-COOKIE-1", fwd.realCookie),
+	}, {
+		name:  "other-protocol",
+		given: setupOf("XDM-AUTHORIZATION-1", fwd.Cookie()),
+	}, {
+		name:  "illegal-byte-order",
+		given: append([]byte{'x'}, setupOf("MIT-MAGIC-COOKIE-1", fwd.Cookie())[1:]...),
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, actualErr := fwd.replaceCookie(bytes.NewReader(c.given))
+			if c.expected == nil {
+				require.Error(t, actualErr)
+			} else {
+				require.NoError(t, actualErr)
+				require.Equal(t, c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWriteXAuthority(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteXAuthority(&buf, 12, "MIT-MAGIC-COOKIE-1", []byte{1, 2}))
+	require.Equal(t, []byte{
+		0xFF, 0xFF,
+		0, 0,
+		0, 2, '1', '2',
+		0, 18, 'M', 'I', 'T', '-', 'M', 'A', 'G', 'I', 'C', '-', 'C', 'O', 'O', 'K', 'I', 'E', '-', '1',
+		0, 2, 1, 2,
+	}, buf.Bytes())
+}
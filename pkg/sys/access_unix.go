@@ -0,0 +1,59 @@
+//go:build unix
+
+package sys
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+)
+
+// AccessMode is a combination of the permissions checked by CheckAccess.
+type AccessMode uint32
+
+const (
+	AccessExecute AccessMode = 1 << iota
+	AccessWrite
+	AccessRead
+)
+
+// CheckAccess checks, like access(2) would do if this process had the given
+// credential, whether the given path can be accessed with the given mode.
+// This includes the search permission of all of its parent directories. Only
+// the classic permission bits are respected; the path is expected to have its
+// symlinks already resolved. The returned error is an *os.PathError with
+// syscall.EACCES if the access is not permitted.
+func CheckAccess(cred *syscall.Credential, path string, mode AccessMode) error {
+	if cred.Uid == 0 {
+		return nil
+	}
+	path = filepath.Clean(path)
+	if dir := filepath.Dir(path); dir != path {
+		if err := CheckAccess(cred, dir, AccessExecute); err != nil {
+			return err
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !isAccessPermitted(cred, fi, mode) {
+		return &os.PathError{Op: "access", Path: path, Err: syscall.EACCES}
+	}
+	return nil
+}
+
+func isAccessPermitted(cred *syscall.Credential, fi os.FileInfo, mode AccessMode) bool {
+	perm := AccessMode(fi.Mode().Perm())
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		switch {
+		case st.Uid == cred.Uid:
+			perm >>= 6
+		case st.Gid == cred.Gid || slices.Contains(cred.Groups, st.Gid):
+			perm >>= 3
+		}
+	}
+	return perm&mode == mode
+}
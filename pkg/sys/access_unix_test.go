@@ -0,0 +1,91 @@
+//go:build unix
+
+package sys
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAccess(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(filepath.Dir(dir), 0711))
+	require.NoError(t, os.Chmod(dir, 0711))
+
+	fi, err := os.Stat(dir)
+	require.NoError(t, err)
+	st := fi.Sys().(*syscall.Stat_t)
+	owner := &syscall.Credential{Uid: st.Uid, Gid: st.Gid}
+	groupMember := &syscall.Credential{Uid: st.Uid + 4242, Gid: st.Gid + 4242, Groups: []uint32{st.Gid}}
+	other := &syscall.Credential{Uid: st.Uid + 4242, Gid: st.Gid + 4242}
+
+	cases := []struct {
+		name        string
+		dirMode     os.FileMode
+		fileMode    os.FileMode
+		cred        *syscall.Credential
+		mode        AccessMode
+		expectedErr bool
+	}{{
+		name:     "owner",
+		dirMode:  0700,
+		fileMode: 0600,
+		cred:     owner,
+		mode:     AccessRead | AccessWrite,
+	}, {
+		name:        "other-without-permission",
+		dirMode:     0755,
+		fileMode:    0600,
+		cred:        other,
+		mode:        AccessWrite,
+		expectedErr: true,
+	}, {
+		name:     "other-with-permission",
+		dirMode:  0755,
+		fileMode: 0606,
+		cred:     other,
+		mode:     AccessWrite,
+	}, {
+		name:        "other-with-read-only-permission",
+		dirMode:     0755,
+		fileMode:    0604,
+		cred:        other,
+		mode:        AccessRead | AccessWrite,
+		expectedErr: true,
+	}, {
+		name:     "group-member",
+		dirMode:  0750,
+		fileMode: 0660,
+		cred:     groupMember,
+		mode:     AccessWrite,
+	}, {
+		name:        "other-without-search-permission-of-parent",
+		dirMode:     0700,
+		fileMode:    0666,
+		cred:        other,
+		mode:        AccessWrite,
+		expectedErr: true,
+	}}
+
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			parent := filepath.Join(dir, string(rune('a'+i)))
+			require.NoError(t, os.Mkdir(parent, c.dirMode))
+			require.NoError(t, os.Chmod(parent, c.dirMode))
+			fn := filepath.Join(parent, "file")
+			require.NoError(t, os.WriteFile(fn, nil, c.fileMode))
+			require.NoError(t, os.Chmod(fn, c.fileMode))
+
+			actualErr := CheckAccess(c.cred, fn, c.mode)
+			if c.expectedErr {
+				require.ErrorIs(t, actualErr, syscall.EACCES)
+			} else {
+				require.NoError(t, actualErr)
+			}
+		})
+	}
+}
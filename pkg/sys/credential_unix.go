@@ -0,0 +1,63 @@
+//go:build unix
+
+package sys
+
+import (
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+// LookupCredential resolves the given user and group (both either by name or
+// by numeric id) to a credential a process can be started with. If group is
+// empty, the primary group of the user is used. Numeric ids which are not
+// known to the system are used as they are. The home directory of the user is
+// returned, too, if known.
+func LookupCredential(plainUser, plainGroup string) (_ *syscall.Credential, home string, _ error) {
+	fail := func(err error) (*syscall.Credential, string, error) {
+		return nil, "", errors.System.Newf("cannot lookup credential for %s:%s: %w", plainUser, plainGroup, err)
+	}
+
+	var result syscall.Credential
+	if uid, err := strconv.ParseUint(plainUser, 10, 32); err == nil {
+		result.Uid = uint32(uid)
+		if u, err := user.LookupId(plainUser); err == nil {
+			home = u.HomeDir
+			if gid, err := strconv.ParseUint(u.Gid, 10, 32); err == nil {
+				result.Gid = uint32(gid)
+			}
+		}
+	} else {
+		u, err := user.Lookup(plainUser)
+		if err != nil {
+			return fail(err)
+		}
+		if v, err := strconv.ParseUint(u.Uid, 10, 32); err != nil {
+			return fail(err)
+		} else {
+			result.Uid = uint32(v)
+		}
+		if v, err := strconv.ParseUint(u.Gid, 10, 32); err != nil {
+			return fail(err)
+		} else {
+			result.Gid = uint32(v)
+		}
+		home = u.HomeDir
+	}
+
+	if plainGroup != "" {
+		if gid, err := strconv.ParseUint(plainGroup, 10, 32); err == nil {
+			result.Gid = uint32(gid)
+		} else if g, err := user.LookupGroup(plainGroup); err != nil {
+			return fail(err)
+		} else if v, err := strconv.ParseUint(g.Gid, 10, 32); err != nil {
+			return fail(err)
+		} else {
+			result.Gid = uint32(v)
+		}
+	}
+
+	return &result, home, nil
+}
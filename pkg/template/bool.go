@@ -71,6 +71,12 @@ func (this Bool) String() string {
 	return this.plain
 }
 
+// DependsOnData reports whether this template accesses the data it is
+// rendered with.
+func (this Bool) DependsOnData() bool {
+	return dependsOnData(this.tmpl)
+}
+
 func (this Bool) IsZero() bool {
 	return len(this.plain) == 0
 }
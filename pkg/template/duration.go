@@ -71,6 +71,12 @@ func (this Duration) String() string {
 	return this.plain
 }
 
+// DependsOnData reports whether this template accesses the data it is
+// rendered with.
+func (this Duration) DependsOnData() bool {
+	return dependsOnData(this.tmpl)
+}
+
 func (this Duration) IsZero() bool {
 	return len(this.plain) == 0
 }
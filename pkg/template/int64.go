@@ -71,6 +71,12 @@ func (this Int64) String() string {
 	return this.plain
 }
 
+// DependsOnData reports whether this template accesses the data it is
+// rendered with.
+func (this Int64) DependsOnData() bool {
+	return dependsOnData(this.tmpl)
+}
+
 func (this Int64) IsZero() bool {
 	return len(this.plain) == 0
 }
@@ -53,6 +53,12 @@ func (this String) String() string {
 	return this.plain
 }
 
+// DependsOnData reports whether this template accesses the data it is
+// rendered with.
+func (this String) DependsOnData() bool {
+	return dependsOnData(this.tmpl)
+}
+
 func (this String) IsZero() bool {
 	return len(this.plain) == 0
 }
@@ -80,3 +80,33 @@ func TestString(t *testing.T) {
 		})
 	}
 }
+
+func TestString_DependsOnData(t *testing.T) {
+	cases := []struct {
+		plain    string
+		expected bool
+	}{
+		{"", false},
+		{"foobar", false},
+		{`{{env "HOME"}}`, false},
+		{`{{with env "HOME"}}{{.}}{{end}}`, false},
+		{`{{range $v := list "a" "b"}}{{$v}}{{.}}{{end}}`, false},
+		{`{{.}}`, true},
+		{`{{.session.id}}`, true},
+		{`{{$.session.id}}`, true},
+		{`{{if env "FOO"}}{{.session.id}}{{end}}`, true},
+		{`{{with env "FOO"}}{{$.session.id}}{{end}}`, true},
+		{`{{with env "FOO"}}foo{{else}}{{.session.id}}{{end}}`, true},
+		{`{{with .session}}{{.id}}{{end}}`, true},
+		{`{{$x := .session}}{{$x.id}}`, true},
+		{`{{printf "%v" .}}`, true},
+		{`{{define "x"}}{{.session.id}}{{end}}{{template "x"}}`, true},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
+			instance, err := NewString(c.plain)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, instance.DependsOnData(), c.plain)
+		})
+	}
+}
@@ -37,6 +37,17 @@ func (this Strings) Render(data any) ([]string, error) {
 	return result, nil
 }
 
+// DependsOnData reports whether any of these templates accesses the data
+// it is rendered with.
+func (this Strings) DependsOnData() bool {
+	for _, v := range this {
+		if v.DependsOnData() {
+			return true
+		}
+	}
+	return false
+}
+
 func (this Strings) IsZero() bool {
 	return len(this) == 0
 }
@@ -1,6 +1,8 @@
 package template
 
 import (
+	"text/template/parse"
+
 	"github.com/engity-com/bifroest/internal/text/template"
 )
 
@@ -10,3 +12,82 @@ func NewTemplate(name, plain string) (*template.Template, error) {
 		Option("missingkey=error", "invalid=nil", "nil=empty").
 		Parse(plain)
 }
+
+// dependsOnData reports whether the given template accesses the data it is
+// executed with, in any of its branches. Functions like env can still be
+// used without depending on the data.
+func dependsOnData(tmpl *template.Template) bool {
+	if tmpl == nil {
+		return false
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && nodeDependsOnData(t.Tree.Root, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeDependsOnData reports whether the given node accesses the data of the
+// template. dotIsData tells if the dot (.) still refers to the data; inside
+// of range and with it refers to the evaluated pipeline instead.
+func nodeDependsOnData(node parse.Node, dotIsData bool) bool {
+	switch n := node.(type) {
+	case nil:
+		return false
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if nodeDependsOnData(child, dotIsData) {
+				return true
+			}
+		}
+		return false
+	case *parse.ActionNode:
+		return nodeDependsOnData(n.Pipe, dotIsData)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if nodeDependsOnData(cmd, dotIsData) {
+				return true
+			}
+		}
+		return false
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if nodeDependsOnData(arg, dotIsData) {
+				return true
+			}
+		}
+		return false
+	case *parse.ChainNode:
+		return nodeDependsOnData(n.Node, dotIsData)
+	case *parse.DotNode, *parse.FieldNode:
+		return dotIsData
+	case *parse.VariableNode:
+		// $ always refers to the data, other variables are declared by
+		// pipelines which are checked themselves.
+		return n.Ident[0] == "$"
+	case *parse.IfNode:
+		return nodeDependsOnData(n.Pipe, dotIsData) ||
+			nodeDependsOnData(n.List, dotIsData) ||
+			nodeDependsOnData(n.ElseList, dotIsData)
+	case *parse.RangeNode:
+		return nodeDependsOnData(n.Pipe, dotIsData) ||
+			nodeDependsOnData(n.List, false) ||
+			nodeDependsOnData(n.ElseList, dotIsData)
+	case *parse.WithNode:
+		return nodeDependsOnData(n.Pipe, dotIsData) ||
+			nodeDependsOnData(n.List, false) ||
+			nodeDependsOnData(n.ElseList, dotIsData)
+	case *parse.TemplateNode:
+		return nodeDependsOnData(n.Pipe, dotIsData)
+	default:
+		// Text, comments, literals, break and continue.
+		return false
+	}
+}
@@ -62,6 +62,12 @@ func (this TextMarshaller[T, PT]) String() string {
 	return this.plain
 }
 
+// DependsOnData reports whether this template accesses the data it is
+// rendered with.
+func (this TextMarshaller[T, PT]) DependsOnData() bool {
+	return dependsOnData(this.tmpl)
+}
+
 func (this TextMarshaller[T, PT]) IsZero() bool {
 	return len(this.plain) == 0
 }
@@ -71,6 +71,12 @@ func (this Uint64) String() string {
 	return this.plain
 }
 
+// DependsOnData reports whether this template accesses the data it is
+// rendered with.
+func (this Uint64) DependsOnData() bool {
+	return dependsOnData(this.tmpl)
+}
+
 func (this Uint64) IsZero() bool {
 	return len(this.plain) == 0
 }
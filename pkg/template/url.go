@@ -68,6 +68,12 @@ func (this Url) String() string {
 	return this.plain
 }
 
+// DependsOnData reports whether this template accesses the data it is
+// rendered with.
+func (this Url) DependsOnData() bool {
+	return dependsOnData(this.tmpl)
+}
+
 func (this Url) IsZero() bool {
 	return len(this.plain) == 0
 }
@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/engity-com/bifroest/pkg/codec"
+)
+
+// Carrier holds the serialized trace context of a span to transport it to
+// another process.
+type Carrier map[string]string
+
+func (this Carrier) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return this.EncodeMsgPack(enc)
+}
+
+func (this *Carrier) DecodeMsgpack(dec *msgpack.Decoder) error {
+	return this.DecodeMsgPack(dec)
+}
+
+func (this Carrier) EncodeMsgPack(enc codec.MsgPackEncoder) error {
+	if err := enc.EncodeMapLen(len(this)); err != nil {
+		return err
+	}
+	for k, v := range this {
+		if err := enc.EncodeString(k); err != nil {
+			return err
+		}
+		if err := enc.EncodeString(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Carrier) DecodeMsgPack(dec codec.MsgPackDecoder) error {
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+	if n <= 0 {
+		*this = nil
+		return nil
+	}
+	buf := make(Carrier, n)
+	for i := 0; i < n; i++ {
+		k, err := dec.DecodeString()
+		if err != nil {
+			return err
+		}
+		if buf[k], err = dec.DecodeString(); err != nil {
+			return err
+		}
+	}
+	*this = buf
+	return nil
+}
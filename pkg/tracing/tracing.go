@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/engity-com/bifroest/pkg/errors"
+)
+
+const (
+	instrumentationName = "github.com/engity-com/bifroest"
+)
+
+var (
+	propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+)
+
+// Tracer returns the trace.Tracer of Bifröst. As long as no tracer provider
+// was registered by the service (see service.Service#configureTracing), all
+// spans created by it are no-ops.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Propagator returns the propagation.TextMapPropagator which is used to
+// transport the trace context between processes.
+func Propagator() propagation.TextMapPropagator {
+	return propagator
+}
+
+// Start creates a new span with the given name and attributes as child of the
+// span which might be present in the given ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the given span. If err is not nil, it will be recorded and the span
+// is marked as failed.
+func End(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// EndWith is like End but takes a pointer to the error, which makes it
+// usable within defer statements with named returns.
+func EndWith(span trace.Span, err *error) {
+	End(span, *err)
+}
+
+// Inject serializes the trace context of the given ctx into a new Carrier. If
+// there is nothing to propagate, nil is returned.
+func Inject(ctx context.Context) Carrier {
+	result := propagation.MapCarrier{}
+	propagator.Inject(ctx, result)
+	if len(result) == 0 {
+		return nil
+	}
+	return Carrier(result)
+}
+
+// Extract returns a copy of the given ctx which contains the trace context
+// serialized in the given Carrier.
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// WrapTransport returns an http.RoundTripper which creates a span for each
+// request and propagates the trace context to the target.
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(rt, otelhttp.WithPropagators(propagator))
+}